@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/replay"
+	"github.com/user/qcmd/internal/tokens"
+)
+
+// freeBackends cost nothing to call, so they are neither tracked nor
+// limited by the budget.
+var freeBackends = map[string]bool{"mock": true}
+
+// tracksUsage reports whether calls to backendName cost money. Replayed
+// calls never reach the provider.
+func tracksUsage(backendName string) bool {
+	mode, _ := replay.FromEnv()
+	return !freeBackends[backendName] && mode != replay.Replay
+}
+
+// recordUsage adds the tokens and estimated cost of resp, returned by
+// backendName, to the usage records.
+func recordUsage(cfg *config.Config, backendName string, resp *backend.Response) error {
+	return recordUsageFor(cfg, backendName, "", resp)
+}
+
+// recordUsageFor is recordUsage for a call made on behalf of user.
+func recordUsageFor(cfg *config.Config, backendName, user string, resp *backend.Response) error {
+	if !tracksUsage(backendName) {
+		return nil
+	}
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	in, out := resp.InputTokens, resp.OutputTokens
+	if in == 0 && out == 0 {
+		// Without a split, price everything at the (higher) output rate
+		// so the budget errs on the safe side.
+		out = resp.TokensUsed
+	}
+	price, _ := tokens.LookupPriceIn(cfg.PriceTable(), resp.Model)
+	return history.AppendUsage(s, history.Usage{
+		Backend:      backendName,
+		Model:        resp.Model,
+		InputTokens:  in,
+		OutputTokens: out,
+		CostUSD:      price.Cost(in, out),
+		User:         user,
+	})
+}
+
+// budgetExceeded reports whether calling backendName would go over the
+// monthly budget, and how much has been spent this month.
+func budgetExceeded(cfg *config.Config, backendName string) (bool, float64, error) {
+	if cfg.Budget.MonthlyUSD <= 0 || !tracksUsage(backendName) {
+		return false, 0, nil
+	}
+	s, err := openStore()
+	if err != nil {
+		return false, 0, err
+	}
+	defer s.Close()
+	now := time.Now()
+	usage, err := history.LoadUsageSince(s, history.MonthStart(now))
+	if err != nil {
+		return false, 0, err
+	}
+	spent := history.MonthlySpend(usage, now)
+	return spent >= cfg.Budget.MonthlyUSD, spent, nil
+}
+
+// writeBudgetExceeded explains that the monthly budget was reached and
+// what to do about it.
+func writeBudgetExceeded(w io.Writer, cfg *config.Config, spent float64, blocked bool) {
+	prefix := "qcmd: warning: "
+	if blocked {
+		prefix = "qcmd: "
+	}
+	fmt.Fprint(w, prefix+i18n.Sprintf("monthly budget of $%.2f reached ($%.2f spent this month)\n", cfg.Budget.MonthlyUSD, spent))
+	if cfg.Budget.LocalBackend != "" {
+		fmt.Fprint(w, i18n.Sprintf("  Use the local backend instead: qcmd --backend %s\n", cfg.Budget.LocalBackend))
+	} else {
+		fmt.Fprintln(w, i18n.T("  Raise budget.monthly_usd, or set budget.local_backend to a free backend to use instead"))
+	}
+}
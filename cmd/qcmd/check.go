@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// checkFinding is a command the checker rated, and where it was found.
+type checkFinding struct {
+	Result  safety.CheckResult
+	Command string
+	// Line is the line of the file Command starts on; 0 for a command
+	// given as arguments.
+	Line int
+}
+
+// handleCheckCommand implements `qcmd check [--verbose] [--format F]
+// COMMAND|--file FILE`, which runs the safety checker on a command, or on
+// every command in a script, without generating anything.
+func handleCheckCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd check [--verbose] [--format text|sarif] COMMAND")
+		fmt.Fprintln(os.Stderr, "       qcmd check [--verbose] [--format text|sarif] --file FILE")
+	}
+
+	var verbose bool
+	var format, file string
+	fs := flag.NewFlagSet("qcmd check", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.BoolVar(&verbose, "verbose", false, "Also show the score, matched pattern, segment and remediation hint")
+	fs.StringVar(&format, "format", "text", "Output format: text, or sarif for code-scanning dashboards")
+	fs.StringVar(&file, "file", "", "Check every command in a script or command list (- for stdin)")
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if (fs.NArg() == 0) == (file == "") {
+		fs.Usage()
+		return exitcode.UserError
+	}
+	if format != "text" && format != "sarif" {
+		fmt.Fprintf(os.Stderr, "qcmd: unknown format %q (want text or sarif)\n", format)
+		return exitcode.UserError
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
+		return exitcode.UserError
+	}
+	i18n.SetLanguage(i18n.Detect(cfg.UI.Language))
+
+	checker := newChecker(cfg)
+	var findings []checkFinding
+	if file == "" {
+		command := strings.Join(fs.Args(), " ")
+		findings = []checkFinding{{Result: checker.Check(command), Command: command}}
+	} else {
+		script, err := readCheckFile(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.UserError
+		}
+		findings = checkScript(checker, script)
+	}
+
+	if format == "sarif" {
+		err = writeSARIF(os.Stdout, findings, file)
+	} else {
+		writeCheckFindings(os.Stdout, findings, file, verbose)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	for _, f := range findings {
+		if f.Result.Level == safety.Danger {
+			return exitcode.DangerBlocked
+		}
+	}
+	return exitcode.Success
+}
+
+// readCheckFile reads the file named by --file, or stdin for "-".
+func readCheckFile(name string) (string, error) {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading commands: %w", err)
+	}
+	return string(data), nil
+}
+
+// checkScript checks every pipeline in script on its own, so each finding
+// points at its line, and returns those that are not safe.
+func checkScript(checker *safety.Checker, script string) []checkFinding {
+	var findings []checkFinding
+	for _, pipeline := range shellparse.Pipelines(script) {
+		result := checker.Check(pipeline.Text)
+		if result.Level == safety.Safe {
+			continue
+		}
+		findings = append(findings, checkFinding{
+			Result:  result,
+			Command: pipeline.Text,
+			Line:    pipeline.Line + result.Line - 1,
+		})
+	}
+	return findings
+}
+
+// writeCheckFindings reports findings as text: a single command as
+// writeCheckResult does, and each finding in file prefixed with where it
+// is. A file without findings is reported as safe.
+func writeCheckFindings(w io.Writer, findings []checkFinding, file string, verbose bool) {
+	if file == "" {
+		writeCheckResult(w, findings[0].Result, findings[0].Command, verbose)
+		return
+	}
+	if len(findings) == 0 {
+		fmt.Fprintln(w, safety.Safe)
+		return
+	}
+	for _, f := range findings {
+		fmt.Fprintf(w, "%s:%d: ", file, f.Line)
+		writeCheckResult(w, f.Result, f.Command, verbose)
+	}
+}
+
+// writeCheckResult reports a safety result: its level, category and reason,
+// and with verbose also why it matched and how to avoid it.
+func writeCheckResult(w io.Writer, result safety.CheckResult, command string, verbose bool) {
+	if result.Level == safety.Safe {
+		fmt.Fprintln(w, result.Level)
+		return
+	}
+	fmt.Fprintf(w, "%s (%s): %s\n", result.Level, result.Category, i18n.T(result.Description))
+	if !verbose {
+		return
+	}
+
+	fmt.Fprint(w, i18n.Sprintf("  Score: %d\n", result.Score))
+	fmt.Fprint(w, i18n.Sprintf("  Matched: %s\n", result.Pattern))
+	if segment := describeSegment(result, command); segment != "" {
+		fmt.Fprint(w, i18n.Sprintf("  Segment: %s\n", segment))
+	}
+	if result.Hint != "" {
+		fmt.Fprint(w, i18n.Sprintf("  Hint: %s\n", i18n.T(result.Hint)))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/sanitize"
+)
+
+// clarify puts the model's questions about an ambiguous query to the user,
+// up to advanced.clarify_rounds times, and sends each answer to generate as
+// the next turn of the conversation. It stops at the first reply that is
+// not a question or when the user gives no answer, returning the request
+// and response last answered. An error from generate ends it.
+func clarify(cfg *config.Config, req *backend.Request, resp *backend.Response, generate func(*backend.Request) (*backend.Request, *backend.Response, error)) (*backend.Request, *backend.Response, error) {
+	reader := bufio.NewReader(promptInput)
+	for round := 0; round < cfg.Advanced.ClarifyRounds; round++ {
+		sentinel, ok := sanitize.ParseErrorSentinel(sanitize.Sanitize(resp.Command))
+		if !ok || sentinel.Code != sanitize.CodeAmbiguous {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "\n  %s\n\n%s", sentinel.Message, i18n.T("Your answer (Enter to give up): "))
+		answer, err := reader.ReadString('\n')
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "")
+			}
+			break
+		}
+
+		answered, next, err := generate(withClarification(req, strings.TrimSpace(resp.Command), answer))
+		if err != nil {
+			return req, resp, err
+		}
+		req, resp = answered, next
+	}
+	return req, resp, nil
+}
+
+// withClarification returns a copy of req with question, the model's
+// reply, and the user's answer to it added to the conversation.
+func withClarification(req *backend.Request, question, answer string) *backend.Request {
+	next := *req
+	next.Clarifications = append(append([]backend.Clarification(nil), req.Clarifications...),
+		backend.Clarification{Question: question, Answer: answer})
+	return &next
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/user/qcmd/internal/container"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+)
+
+// deliverSnippet checks a generated Dockerfile or compose file and prints
+// it. Settings worth a second look are pointed out when lint is set, but
+// do not block the snippet: nothing in it runs until the user builds or
+// starts it.
+func deliverSnippet(snippet string, format container.Format, exec, lint bool) int {
+	if err := container.Check(format, snippet); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid %s: %v\n%s\n", format, err, snippet)
+		return exitcode.UserError
+	}
+	if lint {
+		lintSnippet(os.Stderr, snippet, format)
+	}
+	if exec {
+		fmt.Fprintf(os.Stderr, "qcmd: --exec only runs commands; printing the %s instead\n", format)
+	}
+	fmt.Fprintln(os.Stdout, snippet)
+	return exitcode.Success
+}
+
+// lintSnippet writes a caution to w for each finding in snippet.
+func lintSnippet(w io.Writer, snippet string, format container.Format) {
+	for _, f := range container.Lint(format, snippet) {
+		fmt.Fprint(w, i18n.Sprintf("Caution: line %d: %s\n", f.Line, i18n.T(f.Description)))
+	}
+}
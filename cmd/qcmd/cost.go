@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/tokens"
+)
+
+// handleCostCommand implements `qcmd cost estimate [flags] [QUERY]`, which
+// is the same as `qcmd --estimate-cost`, and `qcmd cost report`.
+func handleCostCommand(args []string) int {
+	switch {
+	case len(args) > 0 && args[0] == "estimate":
+		return generate(append([]string{"--estimate-cost"}, args[1:]...), backend.TaskCommand)
+	case len(args) > 0 && args[0] == "report":
+		return handleCostReport(args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, "usage: qcmd cost estimate [flags] [QUERY]")
+		fmt.Fprintln(os.Stderr, "       qcmd cost report [--by user|backend|model]")
+		return exitcode.UserError
+	}
+}
+
+// handleCostReport implements `qcmd cost report [--by user|backend|model]`,
+// which breaks down the estimated spend this month, e.g. by the users of a
+// shared `qcmd serve`.
+func handleCostReport(args []string) int {
+	var by string
+	fs := flag.NewFlagSet("qcmd cost report", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&by, "by", "user", "Group by user, backend or model")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	keys := map[string]func(history.Usage) string{
+		"user":    func(u history.Usage) string { return u.User },
+		"backend": func(u history.Usage) string { return u.Backend },
+		"model":   func(u history.Usage) string { return u.Model },
+	}
+	key, ok := keys[by]
+	if fs.NArg() != 0 || !ok {
+		fmt.Fprintln(os.Stderr, "usage: qcmd cost report [--by user|backend|model]")
+		return exitcode.UserError
+	}
+
+	s, err := openStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	defer s.Close()
+	now := time.Now()
+	usage, err := history.LoadUsageSince(s, history.MonthStart(now))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := printCostReport(os.Stdout, by, history.MonthlySpendBy(usage, now, key)); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	return exitcode.Success
+}
+
+// printCostReport writes spend, grouped by the field by, most first, and
+// its total.
+func printCostReport(w io.Writer, by string, spend map[string]float64) error {
+	groups := make([]string, 0, len(spend))
+	total := 0.0
+	for group, spent := range spend {
+		groups = append(groups, group)
+		total += spent
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if spend[groups[i]] != spend[groups[j]] {
+			return spend[groups[i]] > spend[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\tTHIS MONTH\n", strings.ToUpper(by))
+	for _, group := range groups {
+		name := group
+		if name == "" {
+			// Calls made directly rather than through a gateway.
+			name = "-"
+		}
+		fmt.Fprintf(tw, "%s\t$%.4f\n", name, spend[group])
+	}
+	fmt.Fprintf(tw, "total\t$%.4f\n", total)
+	return tw.Flush()
+}
+
+// printCostEstimate writes the estimated tokens of req and what it would
+// cost with each priced model, cheapest first, marking req's model with *.
+// Models share the estimate since it does not depend on the tokenizer.
+func printCostEstimate(w io.Writer, req *backend.Request, maxTokens int, prices map[string]tokens.Price) error {
+	inputTokens, err := backend.EstimatePromptTokens(req)
+	if err != nil {
+		return err
+	}
+	outputTokens := backend.EstimateCompletionTokens(req, maxTokens)
+
+	fmt.Fprintf(w, "Estimated input tokens:  %d\n", inputTokens)
+	fmt.Fprintf(w, "Estimated output tokens: %d typical, up to %d\n", outputTokens, maxTokens)
+	fmt.Fprintln(w, "")
+
+	models := make([]string, 0, len(prices)+1)
+	for model := range prices {
+		models = append(models, model)
+	}
+	if _, ok := prices[req.Model]; !ok {
+		models = append(models, req.Model)
+	}
+	cost := func(model string, out int) (float64, bool) {
+		p, ok := tokens.LookupPriceIn(prices, model)
+		return p.Cost(inputTokens, out), ok
+	}
+	sort.Slice(models, func(i, j int) bool {
+		ci, oki := cost(models[i], outputTokens)
+		cj, okj := cost(models[j], outputTokens)
+		if oki != okj {
+			return oki // unknown prices last
+		}
+		if ci != cj {
+			return ci < cj
+		}
+		return models[i] < models[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODEL\tTYPICAL\tMAX")
+	for _, model := range models {
+		marker := ""
+		if model == req.Model {
+			marker = " *"
+		}
+		typical, ok := cost(model, outputTokens)
+		if !ok {
+			fmt.Fprintf(tw, "%s%s\tunknown\tunknown\n", model, marker)
+			continue
+		}
+		most, _ := cost(model, maxTokens)
+		fmt.Fprintf(tw, "%s%s\t$%.6f\t$%.6f\n", model, marker, typical, most)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "* selected model. Prices are USD list prices; add or override them in [prices].")
+	return nil
+}
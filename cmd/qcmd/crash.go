@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/crash"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/xdg"
+)
+
+// crashDirName is the directory in the state directory that holds crash
+// reports.
+const crashDirName = "crashes"
+
+// crashed handles a panic with value v and stack, recovered by run with
+// args: it saves a crash report in the state directory and says where, so
+// it can be attached to a bug report. If the report cannot be saved, the
+// stack is printed instead.
+func crashed(v any, stack []byte, args []string) int {
+	keepFirst := len(args) > 0 && subcommands[args[0]] != nil
+	build := readBuildInfo(debug.ReadBuildInfo())
+	if build.Commit != "" {
+		build.Version += " (commit " + build.Commit + ")"
+	}
+	r := crash.New(v, stack, build.Version, args, keepFirst, crashReportsIncludeQuery())
+	fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: internal error: %s\n", r.Panic))
+
+	dir, err := xdg.StateDir()
+	if err == nil {
+		var path string
+		if path, err = crash.Write(filepath.Join(dir, crashDirName), r); err == nil {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: crash report saved to %s; please attach it to a bug report\n", path))
+			return exitcode.SystemError
+		}
+	}
+	fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: saving a crash report: %v\n", err))
+	os.Stderr.Write(stack)
+	return exitcode.SystemError
+}
+
+// crashReportsIncludeQuery reports whether the config qcmd finds without
+// --config opts in to crash reports with the query. One that cannot be
+// loaded, or that panics too, does not.
+func crashReportsIncludeQuery() (include bool) {
+	defer func() {
+		if recover() != nil {
+			include = false
+		}
+	}()
+	cfg, err := config.Load(nil)
+	return err == nil && cfg.Advanced.CrashReportsIncludeQuery
+}
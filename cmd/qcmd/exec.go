@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/sanitize"
+)
+
+// promptInput is where exec mode reads confirmations from. Tests replace it.
+var promptInput io.Reader = os.Stdin
+
+// commandEditor edits a command before it is run.
+type commandEditor interface {
+	Edit(ctx context.Context, initial string) (string, error)
+}
+
+// confirmAndRun shows command and asks whether to run, edit or cancel it.
+// Edited commands are re-checked with blocked before being offered again.
+// The command's error output goes to stderr. It returns the command that
+// was executed ("" if none) and the exit code qcmd should return: the
+// command's own exit status once it has run.
+func confirmAndRun(command string, ed commandEditor, blocked func(string) bool, stderr io.Writer) (string, int) {
+	reader := bufio.NewReader(promptInput)
+	for {
+		fmt.Fprintf(os.Stderr, "\n  %s\n\n%s", command, i18n.T("Run this command? [y]es / [e]dit / [N]o: "))
+		answer, err := reader.ReadString('\n')
+		if err != nil && answer == "" {
+			fmt.Fprintln(os.Stderr, "")
+			fmt.Fprintln(os.Stderr, i18n.T("qcmd: cancelled"))
+			return "", exitcode.Success
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return command, runShell(command, stderr)
+
+		case "e", "edit":
+			edited, err := ed.Edit(context.Background(), command)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+				continue
+			}
+			if edited == "" {
+				fmt.Fprintln(os.Stderr, i18n.T("qcmd: cancelled"))
+				return "", exitcode.Success
+			}
+			if blocked(edited) {
+				fmt.Fprintln(os.Stderr, "")
+				fmt.Fprintln(os.Stderr, i18n.T("WARNING: Edited command matches a dangerous pattern; not running it."))
+				fmt.Println(edited)
+				return "", exitcode.DangerBlocked
+			}
+			command = edited
+
+		default:
+			fmt.Fprintln(os.Stderr, i18n.T("qcmd: cancelled"))
+			return "", exitcode.Success
+		}
+	}
+}
+
+// runShell runs command through the user's shell with the terminal attached,
+// its error output going to stderr, and returns its exit status.
+func runShell(command string, stderr io.Writer) int {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+
+	cmd := exec.Command(shell, "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "qcmd: running command: %v\n", err)
+		return exitcode.SystemError
+	}
+	return exitcode.Success
+}
+
+// stderrTailSize is how much of a command's error output is kept by
+// history.capture_exec.
+const stderrTailSize = 2048
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	max  int
+	buf  []byte
+	lost bool
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+		t.lost = true
+	}
+	return len(p), nil
+}
+
+// String returns the text kept, without a line cut short at its start.
+func (t *tailWriter) String() string {
+	s := string(t.buf)
+	if i := strings.IndexByte(s, '\n'); t.lost && i >= 0 {
+		s = s[i+1:]
+	}
+	return s
+}
+
+// recordCorrection remembers that the user ran edited instead of generated.
+func recordCorrection(query, generated, edited string) error {
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return history.AppendCorrection(s, history.Correction{
+		Query:     query,
+		Generated: generated,
+		Edited:    edited,
+	})
+}
+
+// correctionsSection returns past corrections related to query as a prompt
+// context section.
+func correctionsSection(query string, max int) (backend.ContextSection, bool, error) {
+	if max <= 0 {
+		return backend.ContextSection{}, false, nil
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return backend.ContextSection{}, false, err
+	}
+	defer s.Close()
+	all, err := history.LoadCorrections(s)
+	if err != nil {
+		return backend.ContextSection{}, false, err
+	}
+
+	related := history.SimilarCorrections(all, query, max)
+	if len(related) == 0 {
+		return backend.ContextSection{}, false, nil
+	}
+
+	var b strings.Builder
+	for _, c := range related {
+		fmt.Fprintf(&b, "- For %q you suggested `%s`; the user ran `%s` instead.\n", c.Query, c.Generated, c.Edited)
+	}
+	return backend.ContextSection{
+		Name:     "Past corrections by this user",
+		Content:  strings.TrimRight(b.String(), "\n"),
+		Priority: backend.PriorityNormal,
+	}, true, nil
+}
+
+// fillPlaceholders shows command and asks for a value for each of its
+// placeholders, such as <bucket-name>. It returns command with the answers
+// filled in; an empty answer leaves the placeholder as it is.
+func fillPlaceholders(command string, placeholders []string) string {
+	reader := bufio.NewReader(promptInput)
+	values := make(map[string]string, len(placeholders))
+	fmt.Fprintf(os.Stderr, "\n  %s\n\n", command)
+	for _, p := range placeholders {
+		fmt.Fprint(os.Stderr, i18n.Sprintf("Value for %s (Enter to keep): ", p))
+		answer, err := reader.ReadString('\n')
+		values[p] = strings.TrimSpace(answer)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "")
+			break
+		}
+	}
+	return sanitize.FillPlaceholders(command, values)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/sanitize"
+)
+
+// explain prints the backend's explanation of command followed by the
+// local safety checker's rating. Nothing is run, injected or recorded.
+func explain(w io.Writer, command, explanation string, cfg *config.Config, checkSafety bool) int {
+	explanation = strings.TrimSpace(explanation)
+	if isError, errMsg := sanitize.CheckErrorSentinel(explanation); isError {
+		fmt.Fprintf(os.Stderr, "qcmd: LLM could not explain command: %s\n", errMsg)
+		return exitcode.UserError
+	}
+
+	var result *safety.CheckResult
+	if checkSafety {
+		r := newChecker(cfg).Check(command)
+		result = &r
+	}
+	writeExplanation(w, explanation, result)
+	return exitcode.Success
+}
+
+// writeExplanation writes an explanation and, unless result is nil, the
+// safety rating of the explained command.
+func writeExplanation(w io.Writer, explanation string, result *safety.CheckResult) {
+	fmt.Fprintln(w, explanation)
+	if result == nil {
+		return
+	}
+	fmt.Fprintln(w)
+	if result.Level == safety.Safe {
+		fmt.Fprintln(w, "Safety check: safe")
+		return
+	}
+	fmt.Fprintf(w, "Safety check: %s (%s): %s\n", result.Level, result.Category, result.Description)
+	if result.Hint != "" {
+		fmt.Fprintf(w, "Hint: %s\n", result.Hint)
+	}
+}
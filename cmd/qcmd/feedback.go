@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/user/qcmd/internal/audit"
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/store"
+	"github.com/user/qcmd/internal/xdg"
+)
+
+// dataPath returns the location of name in the data directory.
+func dataPath(name string) (string, error) {
+	dataDir, err := xdg.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, name), nil
+}
+
+// statePath returns the location of name in the state directory.
+func statePath(name string) (string, error) {
+	stateDir, err := xdg.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, name), nil
+}
+
+// movedStatePath returns the location of name in the state directory,
+// first moving there the file qcmd kept in the data directory before.
+func movedStatePath(name string) (string, error) {
+	path, err := statePath(name)
+	if err != nil {
+		return "", err
+	}
+	old, err := dataPath(name)
+	if err != nil {
+		return path, nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(old); err == nil && os.MkdirAll(filepath.Dir(path), 0700) == nil && os.Rename(old, path) == nil {
+			os.Remove(old + ".lock")
+		}
+	}
+	return path, nil
+}
+
+// runtimePath returns the location of name in the runtime directory.
+func runtimePath(name string) (string, error) {
+	dir, err := xdg.RuntimeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// openStore opens the database in the data directory, first moving into
+// it the files qcmd kept its records in before. The caller closes it.
+func openStore() (*store.Store, error) {
+	dataDir, err := xdg.DataDir()
+	if err != nil {
+		return nil, err
+	}
+	s, err := store.Open(filepath.Join(dataDir, store.FileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, importFiles := range []func(*store.Store, string) error{history.Import, audit.Import} {
+		if err := importFiles(s, dataDir); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// loadHistory returns the local history, oldest first.
+func loadHistory() ([]history.Entry, error) {
+	s, err := openStore()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return history.Load(s)
+}
+
+// recordHistory appends a generated command to the local history.
+func recordHistory(e history.Entry) error {
+	s, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	_, err = history.Append(s, e)
+	return err
+}
+
+// recordGenerated records a generated command like recordHistory, unless
+// it was not run and repeats an entry recorded for a similar query in the
+// last dedupeDays days. That entry is then moved to the end of the history
+// instead, and returned as it was.
+func recordGenerated(e history.Entry, dedupeDays int, now time.Time) (history.Entry, bool, error) {
+	if dedupeDays <= 0 || e.Executed != "" {
+		return history.Entry{}, false, recordHistory(e)
+	}
+
+	s, err := openStore()
+	if err != nil {
+		return history.Entry{}, false, err
+	}
+	defer s.Close()
+	entries, err := history.Load(s)
+	if err != nil {
+		return history.Entry{}, false, err
+	}
+	previous, ok := history.Duplicate(entries, e, now.AddDate(0, 0, -dedupeDays))
+	if !ok {
+		_, err = history.Append(s, e)
+		return history.Entry{}, false, err
+	}
+	_, err = history.Repeat(s, previous.ID, now, func(old *history.Entry) {
+		if old.Undo == "" {
+			old.Undo = e.Undo
+		}
+	})
+	return previous, true, err
+}
+
+// daysAgo describes when t was, relative to now, in whole calendar days:
+// "today", "yesterday" or "3 days ago".
+func daysAgo(t, now time.Time) string {
+	y, m, d := t.Date()
+	then := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	y, m, d = now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	switch days := int(today.Sub(then).Hours()/24 + 0.5); {
+	case days <= 0:
+		return i18n.T("today")
+	case days == 1:
+		return i18n.T("yesterday")
+	default:
+		return i18n.Sprintf("%d days ago", days)
+	}
+}
+
+// loadExamples returns up to max of the most recent few-shot examples.
+func loadExamples(max int) ([]backend.Example, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+
+	path, err := dataPath(history.ExamplesFileName)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := history.LoadExamples(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) > max {
+		stored = stored[len(stored)-max:]
+	}
+
+	examples := make([]backend.Example, len(stored))
+	for i, ex := range stored {
+		examples[i] = backend.Example{Query: ex.Query, Command: ex.Command}
+	}
+	return examples, nil
+}
+
+// handleFeedbackCommand implements
+// `qcmd feedback good|bad [--note TEXT] [--correct COMMAND]`.
+func handleFeedbackCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd feedback good|bad [--note TEXT] [--correct COMMAND]")
+	}
+	if len(args) == 0 || (args[0] != history.FeedbackGood && args[0] != history.FeedbackBad) {
+		usage()
+		return exitcode.UserError
+	}
+	verdict := args[0]
+
+	var note, correct string
+	fs := flag.NewFlagSet("qcmd feedback", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&note, "note", "", "Free-form note stored with the entry")
+	fs.StringVar(&correct, "correct", "", "The command you wanted (bad only); saved as a few-shot example")
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitcode.UserError
+	}
+	if correct != "" && verdict != history.FeedbackBad {
+		fmt.Fprintln(os.Stderr, "qcmd: --correct can only be used with 'bad'")
+		return exitcode.UserError
+	}
+
+	s, err := openStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	defer s.Close()
+
+	entry, err := history.UpdateLast(s, func(e *history.Entry) {
+		e.Feedback = verdict
+		if note != "" {
+			e.Note = note
+		}
+	})
+	if err != nil {
+		if errors.Is(err, history.ErrEmpty) {
+			fmt.Fprintln(os.Stderr, "qcmd: no history entry to annotate")
+			return exitcode.UserError
+		}
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	fmt.Fprintf(os.Stderr, "Marked #%d as %s: %s\n", entry.ID, verdict, entry.Command)
+
+	if correct != "" {
+		examplesPath, err := dataPath(history.ExamplesFileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		if err := history.AppendExample(examplesPath, history.Example{Query: entry.Query, Command: correct}); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		fmt.Fprintf(os.Stderr, "Saved corrected example to %s\n", examplesPath)
+	}
+
+	return exitcode.Success
+}
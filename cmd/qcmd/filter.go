@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/filter"
+	"github.com/user/qcmd/internal/i18n"
+)
+
+// Limits on what is shown of a sample: the model sees its first
+// sampleExcerptBytes, and the user the first sampleOutputLines lines the
+// program printed.
+const (
+	sampleExcerptBytes = 2048
+	sampleOutputLines  = 20
+)
+
+// sampleExcerpt returns the start of the sample file at path, cut at a line
+// end, for the prompt.
+func sampleExcerpt(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	buf := make([]byte, sampleExcerptBytes)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("reading %s: %w", path, err)
+	}
+	excerpt := buf[:n]
+	if n == len(buf) {
+		if i := bytes.LastIndexByte(excerpt, '\n'); i > 0 {
+			excerpt = excerpt[:i]
+		}
+	}
+	return string(excerpt), nil
+}
+
+// samplePrompt is added to the system prompt so the program fits the
+// sample's structure.
+func samplePrompt(excerpt string) string {
+	return "The input looks like this (the start of a sample):\n" + excerpt
+}
+
+// trySample runs program on the sample file at path and shows its output
+// on w. It reports whether the program may be offered: programs qcmd does
+// not try are, with a note, but programs that fail on the sample are not.
+func trySample(w io.Writer, program, path string) bool {
+	if err := filter.Check(program); err != nil {
+		fmt.Fprintf(w, "qcmd: not trying the program on %s: %v\n", path, err)
+		return true
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(w, "qcmd: %v\n", err)
+		return false
+	}
+	defer file.Close()
+
+	result, err := filter.Run(context.Background(), program, file)
+	if err != nil {
+		fmt.Fprintf(w, "qcmd: trying the program on %s: %v\n%s\n", path, err, program)
+		return false
+	}
+	if result.ExitCode != 0 {
+		fmt.Fprintf(w, "qcmd: the program failed on %s (exit status %d)", path, result.ExitCode)
+		if result.Stderr != "" {
+			fmt.Fprintf(w, ": %s", result.Stderr)
+		}
+		fmt.Fprintf(w, "\n%s\n", program)
+		return false
+	}
+
+	fmt.Fprint(w, i18n.Sprintf("Output on %s:\n", path))
+	writeSampleOutput(w, result)
+	return true
+}
+
+// writeSampleOutput writes the first lines of result's output to w,
+// indented, and how many more there are.
+func writeSampleOutput(w io.Writer, result filter.Result) {
+	text := strings.TrimRight(string(result.Output), "\n")
+	if text == "" {
+		fmt.Fprintln(w, i18n.T("  (no output)"))
+		return
+	}
+	lines := strings.Split(text, "\n")
+	shown := lines
+	if len(shown) > sampleOutputLines {
+		shown = shown[:sampleOutputLines]
+	}
+	for _, line := range shown {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	switch {
+	case result.Truncated:
+		fmt.Fprintln(w, i18n.T("  ... (output cut off)"))
+	case len(lines) > len(shown):
+		fmt.Fprint(w, i18n.Sprintf("  ... (%d more lines)\n", len(lines)-len(shown)))
+	}
+}
+
+// confirmFilter asks whether to use a program after its sample output was
+// shown. Anything but no accepts it.
+func confirmFilter() bool {
+	fmt.Fprint(os.Stderr, i18n.T("Use this command? [Y/n]: "))
+	answer, err := bufio.NewReader(promptInput).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr, "")
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "n", "no":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/history"
+)
+
+// errNothingToFix is returned by fixQuery when it has no command to fix.
+var errNothingToFix = errors.New("nothing to fix: give the command that failed, install the shell hook (qcmd hook zsh|bash), or run commands with --exec and history.capture_exec on")
+
+// failure is a command that failed, as remembered by qcmd or the shell.
+type failure struct {
+	Command string
+	// Status is the exit status; Stderr the end of the error output, when
+	// known.
+	Status int
+	Stderr string
+	Time   time.Time
+}
+
+// fixQuery returns the query for `qcmd fix`: about the command given as
+// args or, without args, the last command that failed (see lastFailure),
+// which it also returns.
+func fixQuery(args []string) (string, *failure, error) {
+	if len(args) > 0 {
+		return backend.FixQuery(strings.Join(args, " "), 0, ""), nil, nil
+	}
+	last, err := lastFailure()
+	if err != nil {
+		return "", nil, err
+	}
+	return backend.FixQuery(last.Command, last.Status, last.Stderr), &last, nil
+}
+
+// lastFailure returns the more recent of the last command run with --exec,
+// provided it failed, and the last failure recorded by the shell hook.
+func lastFailure() (failure, error) {
+	var latest *failure
+
+	entries, err := loadHistory()
+	if err != nil {
+		return failure{}, err
+	}
+	if e, ok := history.LastExecuted(entries); ok && e.ExitCode != 0 {
+		latest = &failure{Command: e.Executed, Status: e.ExitCode, Stderr: e.Stderr, Time: e.Time}
+	}
+
+	path, err := lastFailurePath()
+	if err != nil {
+		return failure{}, err
+	}
+	shell, ok, err := readShellFailure(path)
+	if err != nil {
+		return failure{}, err
+	}
+	if ok && (latest == nil || shell.Time.After(latest.Time)) {
+		latest = &shell
+	}
+
+	if latest == nil {
+		return failure{}, errNothingToFix
+	}
+	return *latest, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/health"
+)
+
+// breakerPolicy returns the circuit breaker settings in cfg.
+func breakerPolicy(cfg *config.Config) health.Policy {
+	return health.Policy{
+		Failures: cfg.Advanced.BreakerFailures,
+		Window:   time.Duration(cfg.Advanced.BreakerWindowSeconds) * time.Second,
+		Cooldown: time.Duration(cfg.Advanced.BreakerCooldownSeconds) * time.Second,
+	}
+}
+
+// isOutage reports whether err from a backend suggests the provider is
+// down, rather than that the request or the credentials are at fault.
+func isOutage(err error) bool {
+	var apiErr *backend.APIError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, backend.ErrNetwork):
+		return true
+	case errors.As(err, &apiErr):
+		return apiErr.StatusCode >= 500
+	default:
+		return false
+	}
+}
+
+// healthyBackend returns the backend to use for name: name itself unless
+// its circuit is open, else the first of the configured fallback backends
+// whose circuit is closed. It returns false if there is none, along with
+// when name may be tried again.
+func healthyBackend(cfg *config.Config, state *health.State, name string, now time.Time) (string, time.Time, bool) {
+	open, until := state.Open(name, now)
+	if !open {
+		return name, time.Time{}, true
+	}
+	for _, fallback := range cfg.Advanced.FallbackBackends {
+		if open, _ := state.Open(fallback, now); !open && fallback != name {
+			return fallback, until, true
+		}
+	}
+	return "", until, false
+}
+
+// healthPath returns the location of the backend health file in the state
+// directory. A file left in the data directory that cannot be moved is
+// left behind: the health is only relearned.
+func healthPath() (string, error) {
+	return movedStatePath(health.FileName)
+}
+
+// loadHealth reads the backend health from the state directory.
+func loadHealth() (string, *health.State, error) {
+	path, err := healthPath()
+	if err != nil {
+		return "", nil, err
+	}
+	state, err := health.Load(path)
+	return path, state, err
+}
+
+// selectBackend applies the circuit breaker to backendName, returning the
+// backend to call instead. It fails if backendName is skipped and no
+// fallback is available. A breaker that is disabled, or whose state cannot
+// be read, leaves backendName as it is.
+func selectBackend(cfg *config.Config, f *flags, backendName string) (string, error) {
+	if cfg.Advanced.BreakerFailures == 0 {
+		return backendName, nil
+	}
+	_, state, err := loadHealth()
+	if err != nil {
+		if f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: reading backend health: %v\n", err)
+		}
+		return backendName, nil
+	}
+	name, until, ok := healthyBackend(cfg, state, backendName, time.Now())
+	if !ok {
+		return "", fmt.Errorf("backend %s failed repeatedly and is skipped until %s; use --backend %s to try it anyway, or set fallback_backends", backendName, until.Format("15:04:05"), backendName)
+	}
+	if name != backendName && f.verbosity > verbosityQuiet {
+		fmt.Fprintf(os.Stderr, "qcmd: backend %s failed repeatedly; using %s until %s\n", backendName, name, until.Format("15:04:05"))
+	}
+	return name, nil
+}
+
+// recordHealth records the outcome of a call to backend name for the
+// circuit breaker. Errors other than outages leave the record alone, and
+// the file is only written when it changes. Other qcmds calling backends
+// at the same time wait for the update.
+func recordHealth(cfg *config.Config, name string, callErr error, verbose bool) {
+	if cfg.Advanced.BreakerFailures == 0 || callErr != nil && !isOutage(callErr) {
+		return
+	}
+	path, err := healthPath()
+	if err == nil {
+		err = health.Update(path, func(state *health.State) bool {
+			if callErr == nil {
+				return state.RecordSuccess(name)
+			}
+			if state.RecordFailure(name, time.Now(), breakerPolicy(cfg)) && verbose {
+				fmt.Fprintf(os.Stderr, "qcmd: backend %s failed repeatedly; skipping it for %ds\n", name, cfg.Advanced.BreakerCooldownSeconds)
+			}
+			return true
+		})
+	}
+	if err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: recording backend health: %v\n", err)
+	}
+}
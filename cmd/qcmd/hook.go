@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/user/qcmd/internal/exitcode"
+)
+
+// lastFailureFileName is the file in the runtime directory where the shell
+// hook stores the last command that failed.
+const lastFailureFileName = "last-failure"
+
+// hookOptions parameterizes the generated shell hook.
+type hookOptions struct {
+	// Dir is the directory holding Path, created by the hook if needed.
+	Dir string
+	// Path is the file the hook writes the last failure to.
+	Path string
+}
+
+// handleHookCommand implements `qcmd hook zsh|bash`, which prints a shell
+// hook that remembers the last failed command for `qcmd fix`.
+func handleHookCommand(args []string) int {
+	fs := flag.NewFlagSet("qcmd hook", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd hook zsh|bash")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Prints a shell hook; add eval \"$(qcmd hook zsh)\" to your .zshrc, or")
+		fmt.Fprintln(os.Stderr, "eval \"$(qcmd hook bash)\" to your .bashrc.")
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitcode.UserError
+	}
+
+	path, err := lastFailurePath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := writeHook(os.Stdout, fs.Arg(0), hookOptions{Dir: filepath.Dir(path), Path: path}); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.UserError
+	}
+	return exitcode.Success
+}
+
+// lastFailurePath returns the file the shell hook writes to.
+func lastFailurePath() (string, error) {
+	return runtimePath(lastFailureFileName)
+}
+
+// writeHook renders the hook for shell to w.
+func writeHook(w io.Writer, shell string, opts hookOptions) error {
+	tmpl, ok := hookTemplates[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q (want zsh or bash)", shell)
+	}
+	if strings.ContainsAny(opts.Path, "'\n") {
+		return fmt.Errorf("cannot quote state file path %q", opts.Path)
+	}
+	return tmpl.Execute(w, opts)
+}
+
+// readShellFailure reads the file written by the shell hook at path: the
+// exit status on the first line and the command after it. A missing file
+// yields false.
+func readShellFailure(path string) (failure, bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return failure{}, false, nil
+	}
+	if err != nil {
+		return failure{}, false, fmt.Errorf("reading %s: %w", filepath.Base(path), err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return failure{}, false, fmt.Errorf("reading %s: %w", filepath.Base(path), err)
+	}
+
+	line, command, _ := strings.Cut(string(data), "\n")
+	status, err := strconv.Atoi(strings.TrimSpace(line))
+	command = strings.TrimRight(command, "\n")
+	if err != nil || command == "" {
+		return failure{}, false, fmt.Errorf("reading %s: not written by qcmd hook", filepath.Base(path))
+	}
+	return failure{Command: command, Status: status, Time: info.ModTime()}, true, nil
+}
+
+// hookTemplates are the hooks printed by `qcmd hook`, by shell. Both skip
+// commands interrupted with Ctrl-C (status 130) and qcmd fix itself, and
+// keep the file private to the user.
+var hookTemplates = map[string]*template.Template{
+	"zsh": template.Must(template.New("zsh").Parse(`# qcmd shell hook, generated by: qcmd hook zsh
+# Remembers the last command that failed, so "qcmd fix" with no arguments
+# can fix it.
+
+_qcmd_hook_preexec() {
+    _qcmd_hook_command=$1
+}
+
+_qcmd_hook_precmd() {
+    local exit_status=$? cmd=$_qcmd_hook_command
+    _qcmd_hook_command=
+    (( exit_status == 0 || exit_status == 130 )) && return 0
+    [[ -z $cmd || $cmd == "qcmd fix"* ]] && return 0
+    ( umask 077 && mkdir -p '{{.Dir}}' && print -r -- "$exit_status"$'\n'"$cmd" >| '{{.Path}}' ) 2>/dev/null
+    return 0
+}
+
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _qcmd_hook_preexec
+# First, so the status is not that of another hook.
+precmd_functions=(_qcmd_hook_precmd ${precmd_functions:#_qcmd_hook_precmd})
+`)),
+	"bash": template.Must(template.New("bash").Parse(`# qcmd shell hook, generated by: qcmd hook bash
+# Remembers the last command that failed, so "qcmd fix" with no arguments
+# can fix it.
+
+_qcmd_hook_precmd() {
+    local exit_status=$? cmd
+    cmd=$(HISTTIMEFORMAT= builtin history 1)
+    [[ $cmd =~ ^[[:space:]]*[0-9]+\*?[[:space:]]+(.*)$ ]] && cmd=${BASH_REMATCH[1]}
+    if [[ $exit_status -ne 0 && $exit_status -ne 130 && -n $cmd && $cmd != "qcmd fix"* ]]; then
+        ( umask 077 && mkdir -p '{{.Dir}}' && printf '%s\n%s\n' "$exit_status" "$cmd" >| '{{.Path}}' ) 2>/dev/null
+    fi
+    return $exit_status
+}
+
+# First, so the status is not that of another prompt command.
+if [[ $PROMPT_COMMAND != *_qcmd_hook_precmd* ]]; then
+    PROMPT_COMMAND="_qcmd_hook_precmd${PROMPT_COMMAND:+; $PROMPT_COMMAND}"
+fi
+`)),
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/index"
+	"github.com/user/qcmd/internal/recall"
+	"github.com/user/qcmd/internal/replay"
+	"github.com/user/qcmd/internal/snippets"
+)
+
+// handleIndexCommand implements `qcmd index rebuild|status`.
+func handleIndexCommand(args []string) int {
+	if len(args) != 1 || args[0] != "rebuild" && args[0] != "status" {
+		fmt.Fprintln(os.Stderr, "usage: qcmd index rebuild|status")
+		return exitcode.UserError
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
+		return exitcode.UserError
+	}
+	i18n.SetLanguage(i18n.Detect(cfg.UI.Language))
+
+	path, err := dataPath(index.FileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	previous, err := index.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	if args[0] == "status" {
+		printIndexStatus(os.Stdout, cfg, previous)
+		return exitcode.Success
+	}
+
+	items, err := indexItems()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	embedder, err := createEmbedder(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.UserError
+	}
+	model := indexModel(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+	defer cancel()
+	embed := func(ctx context.Context, texts []string) ([][]float32, error) {
+		return embedder.Embed(ctx, texts, model)
+	}
+	idx, embedded, err := index.Build(ctx, items, model, previous, embed, time.Now())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return backendExitCode(err)
+	}
+	if err := index.Save(path, idx); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	fmt.Fprint(os.Stderr, i18n.Sprintf("Indexed %d items with %s (%d embedded, %d unchanged)\n",
+		len(idx.Items), model, embedded, len(idx.Items)-embedded))
+	if !cfg.Index.Enabled {
+		fmt.Fprintln(os.Stderr, i18n.T("Note: recall only uses the index once [index] enabled = true"))
+	}
+	return exitcode.Success
+}
+
+// printIndexStatus writes what the index holds and whether recall can use
+// it with the current configuration.
+func printIndexStatus(w io.Writer, cfg *config.Config, idx *index.Index) {
+	if idx == nil {
+		fmt.Fprintln(w, i18n.T("No index yet; build one with: qcmd index rebuild"))
+		return
+	}
+	counts := make(map[string]int)
+	for _, it := range idx.Items {
+		counts[it.Kind]++
+	}
+	fmt.Fprint(w, i18n.Sprintf("Items:   %d history, %d snippets\n", counts[index.KindHistory], counts[index.KindSnippet]))
+	fmt.Fprint(w, i18n.Sprintf("Model:   %s\n", idx.Model))
+	fmt.Fprint(w, i18n.Sprintf("Built:   %s\n", idx.Built.Local().Format("2006-01-02 15:04")))
+	switch {
+	case !cfg.Index.Enabled:
+		fmt.Fprintln(w, i18n.T("Status:  not used ([index] enabled = false)"))
+	case idx.Model != indexModel(cfg):
+		fmt.Fprint(w, i18n.Sprintf("Status:  not used (configured model is %s; run qcmd index rebuild)\n", indexModel(cfg)))
+	default:
+		fmt.Fprintln(w, i18n.T("Status:  used by qcmd recall"))
+	}
+}
+
+// indexItems returns the texts to index: each history entry's query and
+// command, and each snippet's name, description and command. Entries rated
+// bad are left out, as recall never returns them.
+func indexItems() ([]index.Item, error) {
+	var items []index.Item
+
+	entries, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Feedback == history.FeedbackBad {
+			continue
+		}
+		items = append(items, index.Item{
+			Kind: index.KindHistory,
+			Key:  strconv.Itoa(e.ID),
+			Text: e.Query + "\n" + historyCommand(e),
+		})
+	}
+
+	path, err := dataPath(snippets.FileName)
+	if err != nil {
+		return nil, err
+	}
+	list, err := snippets.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range list {
+		items = append(items, index.Item{
+			Kind: index.KindSnippet,
+			Key:  s.Name,
+			Text: snippetText(s),
+		})
+	}
+	return items, nil
+}
+
+// historyCommand returns the command the user ran for e, or was given if
+// they did not run it.
+func historyCommand(e history.Entry) string {
+	if e.Executed != "" {
+		return e.Executed
+	}
+	return e.Command
+}
+
+// snippetText returns the text indexed for s.
+func snippetText(s snippets.Snippet) string {
+	text := s.Name
+	if s.Description != "" {
+		text += ": " + s.Description
+	}
+	return text + "\n" + s.Command
+}
+
+// indexModel returns the name under which the configured embedding model
+// records its vectors.
+func indexModel(cfg *config.Config) string {
+	if cfg.Index.Backend == "mock" {
+		return "mock"
+	}
+	return cfg.Index.Model
+}
+
+// createEmbedder returns the backend [index] configures for embeddings.
+func createEmbedder(cfg *config.Config) (backend.Embedder, error) {
+	mode, dir := replay.FromEnv()
+	client := replay.WrapClient(httpClient(cfg, nil), mode, dir)
+
+	switch cfg.Index.Backend {
+	case "openai":
+		key := cfg.OpenAI.APIKey
+		if key == "" && mode == replay.Replay {
+			key = "replay"
+		}
+		opts := []backend.OpenAIOption{
+			backend.WithOpenAIAPIKey(key),
+			backend.WithOpenAIHTTPClient(client),
+		}
+		if cfg.Index.URL != "" {
+			opts = append(opts, backend.WithOpenAIEmbeddingsURL(cfg.Index.URL))
+		}
+		return backend.NewOpenAIBackend(opts...), nil
+
+	case "mock":
+		return backend.NewMockBackend(), nil
+
+	default:
+		return nil, fmt.Errorf("unknown index backend: %s (valid: openai, mock)", cfg.Index.Backend)
+	}
+}
+
+// addSemanticScores scores the indexed candidates by how close they are in
+// meaning to query. Without a usable index, or if the query cannot be
+// embedded, it leaves the scores unset, so recall matches words only; why
+// is reported when verbose.
+func addSemanticScores(cfg *config.Config, query string, candidates []recall.Candidate, verbose bool) {
+	if !cfg.Index.Enabled {
+		return
+	}
+	warn := func(format string, args ...any) {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: "+format+"\n", args...)
+		}
+	}
+
+	path, err := dataPath(index.FileName)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	idx, err := index.Load(path)
+	switch {
+	case err != nil:
+		warn("%v", err)
+		return
+	case idx == nil:
+		warn("no index yet; build one with: qcmd index rebuild")
+		return
+	case idx.Model != indexModel(cfg):
+		warn("the index was built with %s, not %s; run qcmd index rebuild", idx.Model, indexModel(cfg))
+		return
+	}
+
+	embedder, err := createEmbedder(cfg)
+	if err != nil {
+		warn("%v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+	defer cancel()
+	vectors, err := embedder.Embed(ctx, []string{query}, idx.Model)
+	if err != nil {
+		warn("semantic search failed: %v", err)
+		return
+	}
+
+	// Rescale similarities so the configured minimum is recall's.
+	scores := make(map[string]float64)
+	least := cfg.Index.MinSimilarity
+	for _, h := range idx.Search(vectors[0], least) {
+		scores[h.ID()] = recall.MinScore + (1-recall.MinScore)*(h.Similarity-least)/(1-least)
+	}
+	for i := range candidates {
+		if candidates[i].ID != "" {
+			candidates[i].Semantic = scores[candidates[i].ID]
+		}
+	}
+}
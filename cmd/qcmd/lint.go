@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/lint"
+	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/sanitize"
+)
+
+// lintTasks are the tasks whose answers are shell code a linter can
+// read. Crontab lines and filters are not: their commands are only part of
+// the answer, or run on data.
+var lintTasks = map[backend.Task]bool{
+	backend.TaskCommand: true, backend.TaskComment: true, backend.TaskComplete: true,
+	backend.TaskScript: true, backend.TaskFix: true,
+}
+
+// lintCommand runs shellcheck on command, or the built-in checks where
+// shellcheck does not run, and prints what it finds. With lint.fix_errors,
+// a command with errors is sent back to the model once for a fix, and the
+// fixed command is linted instead. It returns the command and response to
+// go on with: command and resp unchanged if no linter runs, it fails, or
+// the fix is unusable.
+func lintCommand(cfg *config.Config, f *flags, be backend.Backend, backendName string, req *backend.Request, resp *backend.Response, command string, progress, posix bool) (string, *backend.Response) {
+	verbose := f.verbosity >= verbosityVerbose
+	opts := []lint.Option{
+		lint.WithDialect(lint.Dialect(filepath.Base(os.Getenv("SHELL")), posix)),
+		lint.WithSeverity(cfg.Lint.Severity),
+		lint.WithExclude(cfg.Lint.Exclude),
+	}
+	var linter lint.Linter
+	if cfg.Lint.Shellcheck {
+		if s, err := lint.NewShellcheck(opts...); err == nil {
+			linter = s
+		} else if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		}
+	}
+	if linter == nil && cfg.Lint.Builtin {
+		linter = lint.NewBuiltin(opts...)
+	}
+	if linter == nil {
+		return command, resp
+	}
+
+	findings, err := linter.Lint(context.Background(), command)
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: %v\n", err)
+		}
+		return command, resp
+	}
+	if cfg.Lint.FixErrors && lint.HasErrors(findings) {
+		if fixed, fixedResp, ok := fixLintErrors(cfg, f, be, backendName, req, command, findings, progress); ok {
+			if fixedFindings, err := linter.Lint(context.Background(), fixed); err == nil {
+				command, resp, findings = fixed, fixedResp, fixedFindings
+			}
+		}
+	}
+
+	if f.verbosity > verbosityQuiet {
+		for _, finding := range findings {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", linter.Name(), finding)
+		}
+	}
+	return command, resp
+}
+
+// fixLintErrors asks the backend for command again with findings, and
+// returns the answer if it is a usable command different from command.
+func fixLintErrors(cfg *config.Config, f *flags, be backend.Backend, backendName string, req *backend.Request, command string, findings []lint.Finding, progress bool) (string, *backend.Response, bool) {
+	verbose := f.verbosity >= verbosityVerbose
+	lines := make([]string, len(findings))
+	for i, finding := range findings {
+		lines[i] = finding.String()
+	}
+	fix := *req
+	fix.AppendPrompt = strings.TrimSpace(req.AppendPrompt + "\n\n" + backend.LintFixPrompt(command, strings.Join(lines, "\n")))
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+	defer cancel()
+	spinner := output.NewSpinner(os.Stderr, "Fixing...")
+	if progress {
+		spinner.Start()
+	}
+	fixedResp, err := be.GenerateCommand(ctx, &fix)
+	spinner.Stop()
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: asking for a fix of what shellcheck found: %v\n", err)
+		}
+		return "", nil, false
+	}
+	if err := recordUsage(cfg, backendName, fixedResp); err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+	}
+
+	fixed := sanitize.Sanitize(fixedResp.Command)
+	if isError, _ := sanitize.CheckErrorSentinel(fixed); isError || strings.TrimSpace(fixed) == "" || fixed == command {
+		return "", nil, false
+	}
+	if f.verbosity > verbosityQuiet {
+		fmt.Fprintf(os.Stderr, "qcmd: fixed the errors shellcheck found (was: %s)\n", command)
+	}
+	return fixed, fixedResp, true
+}
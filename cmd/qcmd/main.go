@@ -6,86 +6,276 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/user/qcmd/internal/advice"
+	"github.com/user/qcmd/internal/audit"
 	"github.com/user/qcmd/internal/backend"
 	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/container"
+	"github.com/user/qcmd/internal/cron"
 	"github.com/user/qcmd/internal/editor"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/filter"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
 	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/regex"
+	"github.com/user/qcmd/internal/replay"
 	"github.com/user/qcmd/internal/safety"
 	"github.com/user/qcmd/internal/sanitize"
 	"github.com/user/qcmd/internal/shellctx"
+	"github.com/user/qcmd/internal/shellparse"
+	"github.com/user/qcmd/internal/tokens"
+	"github.com/user/qcmd/internal/xdg"
 )
 
-// Exit codes following the project specification.
+// version, commit and date are set at build time via ldflags:
+// -X main.version=... -X main.commit=... -X main.date=... Builds without
+// them, such as go install's, fill commit and date in from the build
+// information Go records (see readBuildInfo).
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// verbosity controls how much qcmd writes to stderr. Errors and danger
+// warnings are always shown.
+type verbosity int
+
 const (
-	exitSuccess        = 0
-	exitUserError      = 1
-	exitSystemError    = 2
-	exitDangerBlocked  = 3
-	maxQueryLength     = 10000
+	// verbosityQuiet shows only errors and danger warnings.
+	verbosityQuiet verbosity = iota
+	// verbosityNormal adds the spinner, caution warnings and confirmations.
+	verbosityNormal
+	// verbosityVerbose adds backend details, token counts and non-fatal
+	// warnings.
+	verbosityVerbose
+	// verbosityDebug adds timings and the safety rating of every command.
+	verbosityDebug
 )
 
-// version is set at build time via ldflags: -X main.version=...
-var version = "dev"
+// parseVerbosity parses a --verbosity value.
+func parseVerbosity(s string) (verbosity, error) {
+	switch s {
+	case "quiet":
+		return verbosityQuiet, nil
+	case "normal", "":
+		return verbosityNormal, nil
+	case "verbose":
+		return verbosityVerbose, nil
+	case "debug":
+		return verbosityDebug, nil
+	default:
+		return verbosityNormal, fmt.Errorf("invalid verbosity %q (want quiet, normal, verbose or debug)", s)
+	}
+}
 
 // flags holds all command-line flags.
 type flags struct {
-	queryFile  string
-	query      string
-	backendStr string
-	model      string
-	outputMode string
-	noSafety   bool
-	configPath string
-	verbose    bool
-	showVer    bool
+	queryFile        string
+	query            string
+	clipboard        bool
+	args             []string
+	backendStr       string
+	model            string
+	outputMode       string
+	noSafety         bool
+	configPath       string
+	verbosity        verbosity
+	dryRun           bool
+	estimate         bool
+	exec             bool
+	editResult       bool
+	systemPromptFile string
+	appendPrompt     string
+	showVer          bool
+	metaFD           int
+	metaFile         string
+	cursorMarker     bool
+	progress         string
+	recipe           *output.Recipe
+	writeDir         string
+	format           container.Format
+	sample           string
+	flavor           regex.Flavor
+	tests            stringList
+	recall           bool
+	shellHistory     bool
 }
 
 func main() {
 	os.Exit(run(os.Args[1:]))
 }
 
-func run(args []string) int {
+// subcommands are the commands run dispatches on its first argument, with
+// the rest of the arguments. Anything else is a query.
+var subcommands = map[string]func(args []string) int{
+	"config":            handleConfigCommand,
+	"backends":          func([]string) int { return handleBackendsCommand() },
+	"snippet":           handleSnippetCommand,
+	"sync":              handleSyncCommand,
+	"feedback":          handleFeedbackCommand,
+	"script":            func(args []string) int { return generate(args, backend.TaskScript) },
+	"explain":           func(args []string) int { return generate(args, backend.TaskExplain) },
+	"safety":            handleSafetyCommand,
+	"check":             handleCheckCommand,
+	"zle-wrap":          handleZLEWrapCommand,
+	"hook":              handleHookCommand,
+	"undo":              handleUndoCommand,
+	"cost":              handleCostCommand,
+	"cron":              func(args []string) int { return generate(args, backend.TaskCron) },
+	"unit":              func(args []string) int { return generate(args, backend.TaskUnit) },
+	"container":         func(args []string) int { return generate(args, backend.TaskContainer) },
+	"filter":            func(args []string) int { return generate(args, backend.TaskFilter) },
+	"regex":             func(args []string) int { return generate(args, backend.TaskRegex) },
+	"fix":               func(args []string) int { return generate(args, backend.TaskFix) },
+	"plan":              func(args []string) int { return generate(args, backend.TaskPlan) },
+	"completion-helper": func(args []string) int { return generate(args, backend.TaskComplete) },
+	"version":           handleVersionCommand,
+	"recall":            func(args []string) int { return generate(append([]string{"--recall"}, args...), backend.TaskCommand) },
+	"index":             handleIndexCommand,
+	"serve":             handleServeCommand,
+	"vscode-task":       handleVSCodeTaskCommand,
+}
+
+func run(args []string) (code int) {
+	// Save a report of a crash rather than just print the stack.
+	defer func() {
+		if v := recover(); v != nil {
+			code = crashed(v, debug.Stack(), args)
+		}
+	}()
+
+	// Follow the locale until the config, which may choose a language,
+	// has been loaded.
+	i18n.SetLanguage(i18n.Detect(""))
+
 	// Check for subcommands first (before flag parsing).
 	if len(args) > 0 {
-		switch args[0] {
-		case "config":
-			return handleConfigCommand(args[1:])
-		case "backends":
-			return handleBackendsCommand()
+		if subcommand, ok := subcommands[args[0]]; ok {
+			return subcommand(args[1:])
 		}
 	}
 
+	return generate(args, backend.TaskCommand)
+}
+
+// generate parses flags, asks the backend for output of the given task and
+// delivers the result.
+func generate(args []string, task backend.Task) int {
 	// Parse flags.
 	f, err := parseFlags(args)
 	if err != nil {
 		// If it's a help request, flag package already printed help.
 		if errors.Is(err, flag.ErrHelp) {
-			return exitSuccess
+			return exitcode.Success
 		}
 		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
-		return exitUserError
+		return exitcode.UserError
 	}
 
 	// Handle --version.
 	if f.showVer {
 		fmt.Printf("qcmd version %s\n", version)
-		return exitSuccess
+		return exitcode.Success
+	}
+
+	if f.recipe != nil && task != backend.TaskCommand {
+		fmt.Fprintln(os.Stderr, "qcmd: --as only applies to generated commands")
+		return exitcode.UserError
+	}
+	if f.exec && task == backend.TaskCron {
+		fmt.Fprintln(os.Stderr, "qcmd: --exec cannot run a crontab entry; add it with crontab -e")
+		return exitcode.UserError
+	}
+	if f.exec && task == backend.TaskUnit {
+		fmt.Fprintln(os.Stderr, "qcmd: --exec cannot run unit files; install them with --write")
+		return exitcode.UserError
+	}
+	if f.writeDir != "" && task != backend.TaskUnit {
+		fmt.Fprintln(os.Stderr, "qcmd: --write only applies to qcmd unit")
+		return exitcode.UserError
+	}
+	if f.format != container.Auto && task != backend.TaskContainer {
+		fmt.Fprintln(os.Stderr, "qcmd: --format only applies to qcmd container")
+		return exitcode.UserError
+	}
+	if f.sample != "" && task != backend.TaskFilter {
+		fmt.Fprintln(os.Stderr, "qcmd: --sample only applies to qcmd filter")
+		return exitcode.UserError
+	}
+	if (f.flavor != "" || len(f.tests) > 0) && task != backend.TaskRegex {
+		fmt.Fprintln(os.Stderr, "qcmd: --flavor and --test only apply to qcmd regex")
+		return exitcode.UserError
+	}
+	if f.exec && task == backend.TaskRegex {
+		fmt.Fprintln(os.Stderr, "qcmd: --exec cannot run a regex; try it with --test")
+		return exitcode.UserError
+	}
+	if f.flavor == "" {
+		f.flavor = regex.PCRE
+	}
+	if f.recall && task != backend.TaskCommand {
+		fmt.Fprintln(os.Stderr, "qcmd: --recall only applies to generated commands")
+		return exitcode.UserError
+	}
+	if f.shellHistory && !f.recall {
+		fmt.Fprintln(os.Stderr, "qcmd: --shell-history only applies to qcmd recall")
+		return exitcode.UserError
+	}
+
+	// explain takes the command to explain as arguments, and the other
+	// tasks, recall and cost estimates take the query. fix builds its own.
+	if (task != backend.TaskCommand && task != backend.TaskScript && task != backend.TaskFix || f.recall || f.estimate) && f.query == "" && len(f.args) > 0 {
+		f.query = strings.Join(f.args, " ")
 	}
 
 	// Load configuration.
 	cfg, err := config.Load(&config.LoadOptions{ConfigPath: f.configPath})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
-		return exitSystemError
+		return exitcode.SystemError
 	}
 
 	// Validate configuration.
 	if err := cfg.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
-		return exitUserError
+		return exitcode.UserError
+	}
+	i18n.SetLanguage(i18n.Detect(cfg.UI.Language))
+
+	// fix takes the command that failed as arguments, or fixes the last
+	// one qcmd or the shell hook saw fail.
+	if task == backend.TaskFix && f.query == "" && f.queryFile == "" {
+		var last *failure
+		f.query, last, err = fixQuery(f.args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.UserError
+		}
+		if last != nil && f.verbosity > verbosityQuiet {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: fixing %s (exit status %d)\n", last.Command, last.Status))
+		}
+	}
+
+	// Honor --no-safety only when allowed, and audit it either way.
+	if f.noSafety {
+		if code := authorizeNoSafety(cfg); code != exitcode.Success {
+			return code
+		}
 	}
 
 	// Override backend from flag if provided.
@@ -94,9 +284,23 @@ func run(args []string) int {
 		backendName = f.backendStr
 	}
 
-	// Override model from flag if provided.
+	// A backend that keeps failing is skipped for a while in favour of a
+	// fallback; one chosen with --backend is always tried.
+	fellBack := false
+	if f.backendStr == "" && !f.dryRun && !f.estimate {
+		name, err := selectBackend(cfg, f, backendName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.NetworkFailure
+		}
+		fellBack = name != backendName
+		backendName = name
+	}
+
+	// Override model from flag if provided. It names a model of the
+	// configured backend, not of a fallback.
 	modelName := cfg.GetModel(backendName)
-	if f.model != "" {
+	if f.model != "" && !fellBack {
 		modelName = f.model
 	}
 
@@ -108,7 +312,7 @@ func run(args []string) int {
 		outputMode, err = output.ParseMode(f.outputMode)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "qcmd: invalid output mode: %s\n", f.outputMode)
-			return exitUserError
+			return exitcode.UserError
 		}
 	} else {
 		// No flag provided - use config value (or default to auto).
@@ -119,126 +323,1019 @@ func run(args []string) int {
 			outputMode = output.ModeAuto
 		}
 	}
+	// auto-smart is resolved once the command is known, except under a
+	// widget, which always wants the raw command.
+	if outputMode == output.ModeAutoSmart && fromWidget() {
+		var reason string
+		outputMode, _, reason = smartMode(true, task, false, safety.CheckResult{}, false)
+		if f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: output mode %s: %s\n", outputMode, reason)
+		}
+	}
+
+	progress, err := showProgress(f.progress, f.verbosity, outputMode, output.IsTerminal(os.Stderr))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.UserError
+	}
 
 	// Get query input.
 	query, err := getQuery(f, cfg)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
-		return exitUserError
+		return exitcode.UserError
 	}
 
 	// Validate input.
-	if err := validateInput(query); err != nil {
+	if err := validateInput(query, cfg.Advanced.MaxQueryLength); err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
-		return exitUserError
+		return exitcode.UserError
 	}
 
-	// Warn if both --query and --query-file are provided.
-	if f.verbose && f.queryFile != "" && f.query != "" {
-		fmt.Fprintln(os.Stderr, "qcmd: warning: --query-file takes precedence over --query")
+	// A buffer with comment lines, as the zle widget sends for
+	// `# resize all pngs to 50%`, has its comments turned into commands.
+	if task == backend.TaskCommand && !f.recall && f.queryFile == "" && !f.clipboard && commentBuffer(query) {
+		task = backend.TaskComment
+	}
+
+	// completion-helper is given the command line typed so far, with what
+	// it should do in a trailing comment.
+	var partial string
+	if task == backend.TaskComplete {
+		partial, query = shellparse.SplitComment(query)
+		if partial == "" {
+			fmt.Fprintln(os.Stderr, "qcmd: nothing to complete; type the start of a command, optionally followed by # and what it should do")
+			return exitcode.UserError
+		}
+	}
+
+	// Past commands that match are offered before generating a new one.
+	if f.recall && !f.dryRun && !f.estimate {
+		if code, ok := recallCommand(f, cfg, query, outputMode); ok {
+			return code
+		}
+		if f.verbosity > verbosityQuiet {
+			fmt.Fprintln(os.Stderr, i18n.T("qcmd: nothing in the history matches; generating a new command"))
+		}
 	}
 
 	// Create backend.
-	be, err := createBackend(backendName, cfg)
+	var timings timingLog
+	var trace func(backend.Timing)
+	if f.verbosity >= verbosityVerbose {
+		trace = timings.add
+	}
+	be, err := createBackend(backendName, cfg, trace)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
-		return exitUserError
+		return exitcode.UserError
 	}
 
+	// The safety check needs the git state only after the response
+	// arrives; look it up while waiting for it.
+	go gitStatusCached()
+
 	// Gather shell context if enabled.
 	var shellContext *backend.ShellContext
+	var abbrs []shellctx.Abbreviation
+	var aliases []shellctx.Alias
 	if cfg.IncludeContext {
 		shellContext = shellctx.GatherContext()
+		// Abbreviations and aliases are read here since the collision and
+		// safety checks need them too; both are cheap.
+		if cfg.Context.IncludeAbbreviations {
+			abbrs, err = shellctx.LoadAbbreviations(shellContext.Shell)
+			if err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: ignoring abbreviations: %v\n", err)
+			}
+		}
+		if cfg.Context.IncludeAliases != "none" {
+			aliases = shellctx.ParseAliases(os.Getenv(shellctx.AliasesEnv))
+		}
+		sections, late := shellctx.GatherSections(contextSources(cfg, f, query, shellContext.WorkingDir, abbrs, aliases), cfg.Context.GatherTimeout())
+		shellContext.Sections = append(shellContext.Sections, sections...)
+		if len(late) > 0 && f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: context sources timed out: %s\n", strings.Join(late, ", "))
+		}
 	}
 
 	// Create context with timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
 	defer cancel()
 
+	// Load few-shot examples recorded via `qcmd feedback bad --correct`.
+	// They pair queries with commands, which is no help for explanations,
+	// fixes, completions, comment buffers or plans.
+	var examples []backend.Example
+	if task != backend.TaskExplain && task != backend.TaskFix && task != backend.TaskComplete && task != backend.TaskComment && task != backend.TaskPlan {
+		examples, err = loadExamples(cfg.Context.MaxExamples)
+		if err != nil && f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: ignoring examples: %v\n", err)
+		}
+	}
+
 	// Build request.
 	req := &backend.Request{
-		Query:   query,
-		Context: shellContext,
-		Model:   modelName,
+		Query:        query,
+		Partial:      partial,
+		Context:      shellContext,
+		Model:        modelName,
+		Examples:     examples,
+		Task:         task,
+		Structured:   cfg.Advanced.StructuredOutput && task == backend.TaskCommand,
+		AppendPrompt: f.appendPrompt,
+	}
+	// Notes on the OS and the [style] preferences come first, so that
+	// more specific instructions after them win.
+	posix := writesPOSIX(cfg, task, filepath.Base(os.Getenv("SHELL")))
+	if prompt := stylePrompt(cfg, task, runtime.GOOS, posix); prompt != "" {
+		req.AppendPrompt = strings.TrimSpace(prompt + "\n\n" + req.AppendPrompt)
+	}
+	if prompt, ok := backend.ContainerFormatPrompts[string(f.format)]; ok {
+		req.AppendPrompt = strings.TrimSpace(prompt + "\n\n" + req.AppendPrompt)
+	}
+	if task == backend.TaskRegex {
+		req.AppendPrompt = strings.TrimSpace(backend.RegexFlavorPrompts[string(f.flavor)] + "\n\n" + req.AppendPrompt)
+	}
+	if f.sample != "" {
+		excerpt, err := sampleExcerpt(f.sample)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: sample: %v\n", err)
+			return exitcode.UserError
+		}
+		req.AppendPrompt = strings.TrimSpace(samplePrompt(excerpt) + "\n\n" + req.AppendPrompt)
+	}
+	if f.systemPromptFile != "" {
+		content, err := os.ReadFile(f.systemPromptFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: reading system prompt file: %v\n", err)
+			return exitcode.UserError
+		}
+		req.SystemPrompt = strings.TrimSpace(string(content))
+		if _, err := backend.BuildSystemPrompt(req); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %s: %v\n", f.systemPromptFile, err)
+			return exitcode.UserError
+		}
+	}
+
+	applyModelSettings(cfg, req)
+	maxTokens := cfg.Advanced.MaxTokens
+	if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
 	}
 
-	if f.verbose {
+	// Trim lower-priority context to stay within the token budget.
+	dropped, err := backend.FitBudget(req, cfg.Context.TokenBudget)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: building prompt: %v\n", err)
+		return exitcode.SystemError
+	}
+	if f.verbosity >= verbosityVerbose && len(dropped) > 0 {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: context trimmed to fit token budget of %d (dropped: %s)\n",
+			cfg.Context.TokenBudget, strings.Join(dropped, ", "))
+	}
+
+	if f.verbosity >= verbosityVerbose {
 		fmt.Fprintf(os.Stderr, "qcmd: using backend=%s model=%s\n", backendName, modelName)
 	}
 
+	// Handle --dry-run: show the assembled prompt without calling the API.
+	if f.dryRun {
+		if err := printDryRun(os.Stdout, backendName, req, maxTokens, cfg.PriceTable()); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: building prompt: %v\n", err)
+			return exitcode.SystemError
+		}
+		return exitcode.Success
+	}
+
+	// Handle --estimate-cost: price the request without sending it.
+	if f.estimate {
+		if err := printCostEstimate(os.Stdout, req, maxTokens, cfg.PriceTable()); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: building prompt: %v\n", err)
+			return exitcode.SystemError
+		}
+		return exitcode.Success
+	}
+
+	// Paid backends count against the monthly budget.
+	exceeded, spent, err := budgetExceeded(cfg, backendName)
+	if err != nil && f.verbosity >= verbosityVerbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: checking budget: %v\n", err)
+	}
+	if exceeded {
+		if cfg.Budget.Action == "block" {
+			writeBudgetExceeded(os.Stderr, cfg, spent, true)
+			return exitcode.UserError
+		}
+		if f.verbosity > verbosityQuiet {
+			writeBudgetExceeded(os.Stderr, cfg, spent, false)
+		}
+	}
+
 	// Call LLM backend.
-	resp, err := be.GenerateCommand(ctx, req)
+	spinner := output.NewSpinner(os.Stderr, "Generating...")
+	if progress {
+		spinner.Start()
+	}
+	start := time.Now()
+	var retried func(format string, args ...any)
+	if f.verbosity > verbosityQuiet {
+		retried = func(format string, args ...any) {
+			fmt.Fprintf(os.Stderr, "qcmd: "+format+"\n", args...)
+		}
+	}
+	req, resp, err := generateFitting(ctx, cfg, be, req, retried)
+	spinner.Stop()
+	timings.flush(os.Stderr)
+	recordHealth(cfg, backendName, err, f.verbosity >= verbosityVerbose)
+	if f.verbosity >= verbosityDebug {
+		fmt.Fprintf(os.Stderr, "qcmd: backend responded in %s\n", time.Since(start).Round(time.Millisecond))
+	}
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
 			fmt.Fprintln(os.Stderr, "qcmd: request timed out")
-			return exitSystemError
+			return exitcode.SystemError
 		}
 		if errors.Is(err, context.Canceled) {
 			fmt.Fprintln(os.Stderr, "qcmd: request canceled")
-			return exitSystemError
+			return exitcode.SystemError
 		}
 		if errors.Is(err, backend.ErrNoAPIKey) {
 			fmt.Fprintf(os.Stderr, "qcmd: no API key configured for backend %q\n", backendName)
 			fmt.Fprintf(os.Stderr, "  Set %s_API_KEY environment variable or add api_key to config\n", strings.ToUpper(backendName))
-			return exitUserError
+			return exitcode.AuthFailure
+		}
+		if errors.Is(err, backend.ErrEmptyResponse) {
+			fmt.Fprintln(os.Stderr, "qcmd: LLM returned empty response")
+			return exitcode.EmptyOutput
+		}
+		if errors.Is(err, backend.ErrContextTooLong) {
+			fmt.Fprintf(os.Stderr, "qcmd: the query is too long for the model: %v\n", err)
+			fmt.Fprintln(os.Stderr, "  Shorten it, or set advanced.context_fallback_models to models with larger context windows")
+			return exitcode.UserError
 		}
 		fmt.Fprintf(os.Stderr, "qcmd: API error: %v\n", err)
-		return exitSystemError
+		return backendExitCode(err)
+	}
+	if err := recordUsage(cfg, backendName, resp); err != nil && f.verbosity >= verbosityVerbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+	}
+
+	// Explanations are printed as they are; there is no command to deliver.
+	if task == backend.TaskExplain {
+		return explain(os.Stdout, query, resp.Command, cfg, !f.noSafety)
 	}
 
 	// Sanitize command.
 	command := sanitize.Sanitize(resp.Command)
 
+	// A refusal in prose is no command; reminded what to answer, the model
+	// often gives one, or the error sentinel with its reason.
+	if refused, _ := sanitize.CheckRefusal(command); refused && cfg.Advanced.RetryRefusals {
+		if f.verbosity >= verbosityVerbose {
+			fmt.Fprintln(os.Stderr, "qcmd: the model answered with a refusal; asking again")
+		}
+		retried := withRefusalReminder(req)
+		retryResp, err := be.GenerateCommand(ctx, retried)
+		timings.flush(os.Stderr)
+		if err != nil {
+			if f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: asking again: %v\n", err)
+			}
+		} else {
+			if err := recordUsage(cfg, backendName, retryResp); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+			}
+			req, resp = retried, retryResp
+			command = sanitize.Sanitize(resp.Command)
+		}
+	}
+
+	// On a terminal, a question about an ambiguous query is put to the
+	// user and the answer sent back, rather than ending with exit code 9.
+	if outputMode != output.ModeZLE && output.IsTerminal(os.Stdin) && output.IsTerminal(os.Stderr) {
+		req, resp, err = clarify(cfg, req, resp, func(next *backend.Request) (*backend.Request, *backend.Response, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+			defer cancel()
+			spinner := output.NewSpinner(os.Stderr, "Generating...")
+			if progress {
+				spinner.Start()
+			}
+			answered, resp, err := generateFitting(ctx, cfg, be, next, retried)
+			spinner.Stop()
+			timings.flush(os.Stderr)
+			recordHealth(cfg, backendName, err, f.verbosity >= verbosityVerbose)
+			if err == nil {
+				if err := recordUsage(cfg, backendName, resp); err != nil && f.verbosity >= verbosityVerbose {
+					fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+				}
+			}
+			return answered, resp, err
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: API error: %v\n", err)
+			return backendExitCode(err)
+		}
+		command = sanitize.Sanitize(resp.Command)
+	}
+
 	// Check for empty command after sanitization.
 	if strings.TrimSpace(command) == "" {
 		fmt.Fprintln(os.Stderr, "qcmd: LLM returned empty response")
-		return exitUserError
+		return exitcode.EmptyOutput
+	}
+
+	// Check for error sentinel. An ambiguous request gets its own exit
+	// code, so wrappers can ask for the missing detail.
+	if sentinel, ok := sanitize.ParseErrorSentinel(command); ok {
+		if sentinel.Code == sanitize.CodeAmbiguous {
+			fmt.Fprintf(os.Stderr, "qcmd: the request needs more detail: %s\n", sentinel.Message)
+			return exitcode.Ambiguous
+		}
+		fmt.Fprintf(os.Stderr, "qcmd: LLM could not generate command: %s\n", sentinel.Message)
+		return exitcode.UserError
 	}
 
-	// Check for error sentinel.
-	if isError, errMsg := sanitize.CheckErrorSentinel(command); isError {
-		fmt.Fprintf(os.Stderr, "qcmd: LLM could not generate command: %s\n", errMsg)
-		return exitUserError
+	// Refusals are reported as such rather than checked and delivered.
+	if refused, reason := sanitize.CheckRefusal(command); refused {
+		fmt.Fprintf(os.Stderr, "qcmd: LLM declined the request: %s\n", reason)
+		return exitcode.UserError
 	}
 
-	if f.verbose {
+	// Flags differ between versions of a tool (GNU and BSD sed -i), so on
+	// request the command is checked against the installed one's help.
+	if task == backend.TaskCommand && cfg.Context.IncludeHelp {
+		command, resp = checkFlags(cfg, f, be, backendName, req, resp, command, progress)
+		timings.flush(os.Stderr)
+	}
+
+	// Lint findings are shown with the command; on request, errors are
+	// sent back to the model to fix.
+	if (cfg.Lint.Shellcheck || cfg.Lint.Builtin) && lintTasks[task] {
+		command, resp = lintCommand(cfg, f, be, backendName, req, resp, command, progress, posix)
+	}
+
+	if f.verbosity >= verbosityVerbose {
 		fmt.Fprintf(os.Stderr, "qcmd: tokens used: %d\n", resp.TokensUsed)
+		if resp.HasConfidence {
+			fmt.Fprintf(os.Stderr, "qcmd: model confidence: %.0f%%\n", resp.Confidence*100)
+		}
+	}
+
+	// Scripts are reviewed in the editor rather than injected or run.
+	if task == backend.TaskScript {
+		code := reviewScript(command, cfg, !f.noSafety)
+		if cfg.History.Enabled {
+			entry := history.Entry{Query: query, Command: command, Backend: backendName, Model: resp.Model}
+			if err := recordHistory(entry); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording history: %v\n", err)
+			}
+		}
+		return code
+	}
+
+	// A regex is tried on the test strings instead of safety-checked.
+	if task == backend.TaskRegex {
+		code := deliverRegex(command, f.flavor, f.tests, outputMode, f.verbosity == verbosityQuiet)
+		if cfg.History.Enabled {
+			entry := history.Entry{Query: query, Command: command, Backend: backendName, Model: resp.Model}
+			if err := recordHistory(entry); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording history: %v\n", err)
+			}
+		}
+		return code
+	}
+
+	// Unit files are checked as a whole; they are not a command to deliver.
+	// Each file may come in its own code fence, so the raw answer is used.
+	if task == backend.TaskUnit {
+		code := deliverUnits(resp.Command, cfg, f.writeDir, !f.noSafety)
+		if cfg.History.Enabled {
+			entry := history.Entry{Query: query, Command: strings.TrimSpace(resp.Command), Backend: backendName, Model: resp.Model}
+			if err := recordHistory(entry); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording history: %v\n", err)
+			}
+		}
+		return code
+	}
+
+	// A plan is checked step by step, and with --exec run step by step.
+	if task == backend.TaskPlan {
+		executed, code := deliverPlan(command, cfg, f.exec, !f.noSafety, f.verbosity >= verbosityVerbose)
+		if cfg.History.Enabled {
+			entry := history.Entry{Query: query, Command: command, Executed: executed, Backend: backendName, Model: resp.Model}
+			if err := recordHistory(entry); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording history: %v\n", err)
+			}
+		}
+		return code
+	}
+
+	// Dockerfiles and compose files are not shell commands, so they are
+	// checked on their own terms instead of with the shell safety patterns.
+	if task == backend.TaskContainer {
+		snippet := sanitize.Snippet(resp.Command)
+		format := f.format
+		if format == container.Auto {
+			format = container.Detect(snippet)
+		}
+		if format != container.Command {
+			code := deliverSnippet(snippet, format, f.exec, !f.noSafety && cfg.Safety.ShowWarnings && f.verbosity > verbosityQuiet)
+			if cfg.History.Enabled {
+				entry := history.Entry{Query: query, Command: snippet, Backend: backendName, Model: resp.Model}
+				if err := recordHistory(entry); err != nil && f.verbosity >= verbosityVerbose {
+					fmt.Fprintf(os.Stderr, "qcmd: warning: recording history: %v\n", err)
+				}
+			}
+			return code
+		}
+	}
+
+	generated := command
+
+	// Let the user tweak the command first; the edit is checked below.
+	if f.editResult {
+		edited, err := editor.NewEditor(cfg.Editor.Editor).Edit(context.Background(), command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: editing command: %v\n", err)
+			return exitcode.SystemError
+		}
+		if edited == "" {
+			fmt.Fprintln(os.Stderr, i18n.T("qcmd: cancelled"))
+			return exitcode.Success
+		}
+		if edited != command {
+			if err := recordCorrection(query, command, edited); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording correction: %v\n", err)
+			}
+			command = edited
+		}
+	}
+
+	// Half-filled commands such as "aws s3 ls s3://<bucket-name>" must not
+	// run by accident: ask for the values on a terminal. In ZLE mode the
+	// command is printed instead of inserted (see safety.block_placeholders),
+	// and after --edit-result they are only reported.
+	placeholders := sanitize.Placeholders(command)
+	if len(placeholders) > 0 && !f.editResult && outputMode != output.ModeZLE && output.IsTerminal(os.Stdin) && output.IsTerminal(os.Stderr) {
+		command = fillPlaceholders(command, placeholders)
+		placeholders = sanitize.Placeholders(command)
+	}
+	if len(placeholders) > 0 && f.verbosity > verbosityQuiet {
+		fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: warning: fill in the placeholders before running: %s\n", strings.Join(placeholders, ", ")))
+	}
+
+	// A crontab line is validated and its schedule explained; only the
+	// command it runs is safety-checked.
+	checked := command
+	if task == backend.TaskCron {
+		cronEntry, err := cron.Parse(command)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: invalid crontab entry: %v\n%s\n", err, command)
+			return exitcode.UserError
+		}
+		if f.verbosity > verbosityQuiet {
+			fmt.Fprintf(os.Stderr, "Runs %s.\n", cronEntry.Describe())
+		}
+		checked = cronEntry.Command
+	}
+
+	// The reviewer runs while the command is checked below; its verdict is
+	// only a note.
+	var review func() reviewResult
+	if cfg.Review.Enabled {
+		review = startReview(cfg, query, command, shellContext)
 	}
 
-	// Run safety check (unless disabled).
+	// So does the search for a command undoing this one, if it changes
+	// anything; it is stored for `qcmd undo`.
+	var findUndo func() undoResult
+	if (task == backend.TaskCommand || task == backend.TaskComment) && cfg.History.Enabled && cfg.History.SuggestUndo && !safety.ReadOnly(generated) {
+		findUndo = startUndo(cfg, be, req, generated)
+	}
+
+	// Abbreviations expand as the command is typed or edited, so warn about
+	// them. They and aliases are checked as they will run as well.
+	collisions := shellctx.AbbreviationCollisions(command, abbrs)
+	if f.verbosity > verbosityQuiet {
+		for _, c := range collisions {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: warning: %q is your abbreviation for %q; typing it expands to: %s\n", c.Name, c.Expansion, c.Expanded))
+		}
+	}
+
+	// Run safety check (unless disabled). The checker is built once and
+	// reused for edits made before running the command.
+	checker := sync.OnceValue(func() *safety.Checker { return newChecker(cfg) })
 	var checkResult safety.CheckResult
 	isDangerous := false
 	if !f.noSafety {
-		checker := safety.NewChecker()
-		checkResult = checker.Check(command)
+		if cfg.Safety.StrictAsRoot && f.verbosity > verbosityQuiet {
+			env := checkEnvironment()
+			env.SudoCached = !env.Root && safety.RunsElevated(checked) && sudoCached()
+			if env.Privileged() {
+				printRootBanner(env)
+			}
+		}
+		checkResult = checker().Check(checked)
+		expanded := shellctx.ExpandAliases(checked, aliases)
+		for _, c := range collisions {
+			expanded = append(expanded, c.Expanded)
+		}
+		for _, cmd := range expanded {
+			if result := checker().Check(cmd); result.Score > checkResult.Score {
+				checkResult = result
+			}
+		}
 
 		if checkResult.Level == safety.Danger && cfg.Safety.BlockDangerous {
 			isDangerous = true
 			fmt.Fprintln(os.Stderr, "")
-			fmt.Fprintln(os.Stderr, "WARNING: Dangerous command detected!")
-			fmt.Fprintf(os.Stderr, "  Category: %s\n", checkResult.Category)
-			fmt.Fprintf(os.Stderr, "  Reason: %s\n", checkResult.Description)
+			fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+			printFinding(checkResult, checked)
+			fmt.Fprintln(os.Stderr, "")
+		} else if checkResult.Level == safety.Danger && cfg.Safety.ShowWarnings && f.verbosity > verbosityQuiet && !trustedWarning(cfg, checkResult, checked, f.verbosity >= verbosityVerbose) {
+			fmt.Fprintln(os.Stderr, "")
+			fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+			printFinding(checkResult, checked)
 			fmt.Fprintln(os.Stderr, "")
-		} else if checkResult.Level == safety.Caution && cfg.Safety.ShowWarnings {
+		} else if checkResult.Level == safety.Caution && cfg.Safety.Warns(checkResult.Category) && f.verbosity > verbosityQuiet && !trustedWarning(cfg, checkResult, checked, f.verbosity >= verbosityVerbose) {
 			fmt.Fprintln(os.Stderr, "")
-			fmt.Fprintln(os.Stderr, "Caution: Review this command before executing.")
-			fmt.Fprintf(os.Stderr, "  Category: %s\n", checkResult.Category)
-			fmt.Fprintf(os.Stderr, "  Reason: %s\n", checkResult.Description)
+			fmt.Fprintln(os.Stderr, i18n.T("Caution: Review this command before executing."))
+			printFinding(checkResult, checked)
 			fmt.Fprintln(os.Stderr, "")
 		}
+		if f.verbosity >= verbosityDebug {
+			fmt.Fprintf(os.Stderr, "qcmd: safety: level=%s score=%d category=%s read_only=%t\n", checkResult.Level, checkResult.Score, checkResult.Category, checkResult.ReadOnly)
+		}
+	}
+
+	// Commands that may run for a long time get tips on following and
+	// detaching them. Tips never change what happens to the command.
+	if cfg.Advice.LongRunning && !isDangerous && f.verbosity > verbosityQuiet {
+		for _, tip := range advice.Check(checked) {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("Tip: %s\n", i18n.T(tip)))
+		}
+	}
+	if cfg.Advice.DiskSpace && !isDangerous && f.verbosity > verbosityQuiet {
+		printShortages(os.Stderr, advice.CheckSpace(checked))
+	}
+	if cfg.Advice.MissingPaths && f.verbosity > verbosityQuiet {
+		if wd, err := os.Getwd(); err == nil {
+			for _, path := range advice.MissingPaths(checked, wd) {
+				fmt.Fprint(os.Stderr, i18n.Sprintf("Warning: %s does not exist here; check the path before running the command\n", path))
+			}
+		}
+	}
+
+	if posix && !bashScript(checked) && f.verbosity > verbosityQuiet {
+		for _, a := range advice.CheckPOSIX(checked) {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("Warning: not POSIX sh: %s\n", i18n.T(a)))
+		}
+	}
+
+	if review != nil {
+		spinner := output.NewSpinner(os.Stderr, "Reviewing...")
+		if progress {
+			spinner.Start()
+		}
+		result := review()
+		spinner.Stop()
+		if result.resp != nil {
+			if err := recordUsage(cfg, result.backend, result.resp); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+			}
+		}
+		if f.verbosity > verbosityQuiet {
+			writeReview(os.Stderr, result, f.verbosity >= verbosityVerbose)
+		}
+	}
+
+	// A filter is tried on the sample before it is offered, and offered
+	// applied to the sample file. Dangerous programs are not tried.
+	if task == backend.TaskFilter && f.sample != "" && checkResult.Level != safety.Danger {
+		if !trySample(os.Stderr, command, f.sample) {
+			return exitcode.UserError
+		}
+		if !f.exec && outputMode != output.ModeZLE && output.IsTerminal(os.Stdin) && output.IsTerminal(os.Stderr) && !confirmFilter() {
+			fmt.Fprintln(os.Stderr, i18n.T("qcmd: cancelled"))
+			return exitcode.Success
+		}
+		command = filter.Command(command, f.sample)
+	}
+
+	// Commands the model is unsure about are not injected, so they are not
+	// run with a stray Enter; the shell integration prints them instead.
+	lowConfidence := resp.HasConfidence && resp.Confidence < cfg.Advanced.MinConfidence
+	if lowConfidence && f.verbosity > verbosityQuiet {
+		fmt.Fprint(os.Stderr, i18n.Sprintf("Note: the model is only %.0f%% confident in this command; check it before running it.\n", resp.Confidence*100))
+	}
+
+	// So are long one-liners and deep pipelines, where mistakes hide.
+	complexity := safety.MeasureComplexity(checked)
+	tooLong, tooDeep := complexity.TooLong(cfg.Safety.MaxLength), complexity.TooDeep(cfg.Safety.MaxPipeline)
+	tooComplex := !f.noSafety && (tooLong || tooDeep)
+	if tooComplex && f.verbosity > verbosityQuiet {
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, i18n.T("Review carefully: this command is hard to check at a glance."))
+		if tooLong {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("  It is %d characters long (safety.max_length is %d).\n", complexity.Length, cfg.Safety.MaxLength))
+		}
+		if tooDeep {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("  It chains %d commands in one pipeline (safety.max_pipeline is %d).\n", complexity.Pipeline, cfg.Safety.MaxPipeline))
+		}
+		fmt.Fprintln(os.Stderr, "")
+	}
+
+	meta := output.Metadata{Level: checkResult.Level.String(), Category: checkResult.Category, Score: checkResult.Score, ReadOnly: checkResult.ReadOnly, Description: checkResult.Description}
+	if f.noSafety {
+		meta = output.Metadata{Level: "unchecked"}
+	}
+	meta.Placeholders = placeholders
+
+	// Tell the shell widget how the command was rated, on a side channel so
+	// stdout stays the raw command.
+	if f.metaFD > 0 || f.metaFile != "" {
+		if err := writeMetadata(f, meta); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: writing metadata: %v\n", err)
+		}
+	}
+
+	// The command is recorded as generated: values filled in for its
+	// placeholders may be secrets.
+	entry := history.Entry{
+		Query:   query,
+		Command: generated,
+		Backend: backendName,
+		Model:   resp.Model,
+	}
+	code := exitcode.Success
+
+	exec := f.exec
+	if outputMode == output.ModeAutoSmart {
+		interactive := f.recipe == nil && output.IsTerminal(os.Stdin) && output.IsTerminal(os.Stderr)
+		var offer bool
+		var reason string
+		outputMode, offer, reason = smartMode(false, task, !f.noSafety, checkResult, interactive)
+		exec = exec || offer
+		if f.verbosity >= verbosityVerbose {
+			mode := outputMode.String()
+			if offer {
+				mode = "exec"
+			}
+			fmt.Fprintf(os.Stderr, "qcmd: output mode %s: %s\n", mode, reason)
+		}
+	}
+
+	if exec && !isDangerous {
+		// Let the user run, edit or cancel the command.
+		blocked := func(cmd string) bool {
+			return !f.noSafety && cfg.Safety.BlockDangerous && checker().Check(cmd).Level == safety.Danger
+		}
+		// With capture_exec the end of the command's error output is kept
+		// for `qcmd fix`, at the cost of it no longer writing to a terminal.
+		stderr := io.Writer(os.Stderr)
+		var tail *tailWriter
+		if cfg.History.Enabled && cfg.History.CaptureExec {
+			tail = &tailWriter{max: stderrTailSize}
+			stderr = io.MultiWriter(os.Stderr, tail)
+		}
+		entry.Executed, code = confirmAndRun(command, editor.NewEditor(cfg.Editor.Editor), blocked, stderr)
+		if tail != nil && entry.Executed != "" && code != exitcode.Success {
+			entry.ExitCode, entry.Stderr = code, tail.String()
+		}
+		// Running a command as it was warned about trusts it.
+		if entry.Executed == command && !f.noSafety {
+			recordTrust(cfg, checkResult, checked, f.verbosity >= verbosityVerbose)
+		}
+
+		// Remember edits so related queries can learn from them.
+		if entry.Executed != "" && entry.Executed != command {
+			if err := recordCorrection(query, command, entry.Executed); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording correction: %v\n", err)
+			}
+		}
+	} else {
+		// Output the command.
+		output.SetQuiet(f.verbosity == verbosityQuiet)
+		blockPlaceholders := outputMode == output.ModeZLE && cfg.Safety.BlockPlaceholders && len(placeholders) > 0
+		var err error
+		switch {
+		case f.recipe != nil:
+			err = output.WriteRecipe(os.Stdout, command, *f.recipe)
+		case outputMode == output.ModeLauncher:
+			err = output.WriteLauncher(os.Stdout, command, meta)
+		case outputMode == output.ModeVim:
+			err = output.WriteVim(os.Stdout, command, meta)
+		default:
+			delivered := command
+			// The widget strips the marker and puts the cursor there, at a
+			// placeholder or between empty quotes, when inserting.
+			if f.cursorMarker && outputMode == output.ModeZLE && !isDangerous && !lowConfidence && !blockPlaceholders && !tooComplex {
+				if at := sanitize.EditPoint(command); at >= 0 {
+					delivered = output.MarkCursor(command, at)
+				}
+			}
+			err = output.Output(delivered, outputMode, isDangerous)
+		}
+		if errors.Is(err, output.ErrMultiLineTarget) {
+			// Show the command anyway so it is not lost.
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n%s\n", err, command)
+			code = exitcode.UserError
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: output error: %v\n", err)
+			return exitcode.SystemError
+		} else if isDangerous {
+			code = exitcode.DangerBlocked
+		} else if lowConfidence && outputMode == output.ModeZLE {
+			code = exitcode.LowConfidence
+		} else if blockPlaceholders {
+			code = exitcode.Placeholders
+		} else if tooComplex && outputMode == output.ModeZLE {
+			code = exitcode.TooComplex
+		}
+	}
+
+	if findUndo != nil {
+		result := findUndo()
+		if result.resp != nil {
+			if err := recordUsage(cfg, backendName, result.resp); err != nil && f.verbosity >= verbosityVerbose {
+				fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+			}
+		}
+		if result.err != nil && f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: suggesting an undo: %v\n", result.err)
+		}
+		entry.Undo = result.command
 	}
 
-	// Output the command.
-	if err := output.Output(command, outputMode, isDangerous); err != nil {
-		fmt.Fprintf(os.Stderr, "qcmd: output error: %v\n", err)
-		return exitSystemError
+	// Record the command locally so it can be annotated with `qcmd feedback`.
+	// A repeat of a recent command is pointed out rather than recorded twice.
+	if cfg.History.Enabled {
+		now := time.Now()
+		previous, repeated, err := recordGenerated(entry, cfg.History.DedupeDays, now)
+		if err != nil && f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: recording history: %v\n", err)
+		}
+		switch {
+		case !repeated || f.verbosity == verbosityQuiet:
+		case previous.Feedback == history.FeedbackBad:
+			fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: same as #%d from %s, which you marked bad\n", previous.ID, daysAgo(previous.Time, now)))
+		default:
+			fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: same as #%d from %s\n", previous.ID, daysAgo(previous.Time, now)))
+		}
 	}
 
-	// Return appropriate exit code.
-	if isDangerous {
-		return exitDangerBlocked
+	return code
+}
+
+// writeMetadata writes meta to the file descriptor and/or file requested
+// with --meta-fd and --meta-file.
+func writeMetadata(f *flags, meta output.Metadata) error {
+	if f.metaFD > 0 {
+		fd := os.NewFile(uintptr(f.metaFD), "meta-fd")
+		if fd == nil {
+			return fmt.Errorf("invalid file descriptor %d", f.metaFD)
+		}
+		defer fd.Close()
+		if err := output.WriteMetadata(fd, meta); err != nil {
+			return err
+		}
+	}
+	if f.metaFile != "" {
+		file, err := os.OpenFile(f.metaFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		if err := output.WriteMetadata(file, meta); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+	return nil
+}
+
+// authorizeNoSafety decides whether --no-safety may be honored and records
+// the decision in the audit log. Safety is only disabled if the decision
+// could be recorded.
+func authorizeNoSafety(cfg *config.Config) int {
+	s, err := openStore()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	defer s.Close()
+
+	entry := audit.Entry{Event: audit.EventSafetyDisabled, Args: os.Args[1:]}
+	if !cfg.Safety.AllowDisable {
+		entry.Event = audit.EventSafetyDisableRefused
+		entry.Detail = "safety.allow_disable is false"
+	}
+	if err := audit.Append(s, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: refusing --no-safety: cannot write audit log: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	if !cfg.Safety.AllowDisable {
+		fmt.Fprintln(os.Stderr, "qcmd: --no-safety is not allowed by your configuration")
+		fmt.Fprintln(os.Stderr, "  Set allow_disable = true in the [safety] section to permit it")
+		return exitcode.UserError
+	}
+	return exitcode.Success
+}
+
+// contextSources returns the optional prompt context sources enabled in
+// cfg, in the order their sections appear in the prompt. abbrs and aliases
+// have already been loaded.
+func contextSources(cfg *config.Config, f *flags, query, workingDir string, abbrs []shellctx.Abbreviation, aliases []shellctx.Alias) []shellctx.Source {
+	sources := []shellctx.Source{{Name: "remote", Gather: shellctx.RemoteSection}}
+	if cfg.Context.IncludeListing {
+		sources = append(sources, shellctx.Source{Name: "listing", Gather: func() (backend.ContextSection, bool) {
+			return shellctx.DirectoryListing(workingDir, cfg.Context.ListingLimit)
+		}})
+	}
+	if cfg.Context.IncludeAbbreviations {
+		sources = append(sources, shellctx.Source{Name: "abbreviations", Gather: func() (backend.ContextSection, bool) {
+			return shellctx.AbbreviationsSection(abbrs)
+		}})
+	}
+	if cfg.Context.IncludeAliases != "none" {
+		sources = append(sources, shellctx.Source{Name: "aliases", Gather: func() (backend.ContextSection, bool) {
+			return shellctx.AliasesSection(aliases, cfg.Context.IncludeAliases == "expansions")
+		}})
+	}
+	if cfg.Context.IncludeContainers {
+		// Asking kubectl and docker routinely takes longer than the
+		// default timeout, and users opted in to waiting for them.
+		sources = append(sources, shellctx.Source{Name: "containers", Timeout: shellctx.ContainersTimeout, Gather: func() (backend.ContextSection, bool) {
+			return shellctx.ContainersSection(cfg.Context.ContainersLimit)
+		}})
+	}
+	// Tool probes are cached until the tool changes, so the longer wait
+	// is only for the first run and after upgrades.
+	if cfg.Context.DetectToolFlavors {
+		sources = append(sources, shellctx.Source{Name: "tool flavors", Timeout: shellctx.ToolFlavorsTimeout, Gather: func() (backend.ContextSection, bool) {
+			return shellctx.ToolFlavorsSection(runtime.GOOS, probeCache())
+		}})
+	}
+	if len(cfg.Context.ToolVersions) > 0 {
+		sources = append(sources, shellctx.Source{Name: "tool versions", Timeout: shellctx.ToolVersionsTimeout, Gather: func() (backend.ContextSection, bool) {
+			return shellctx.ToolVersionsSection(cfg.Context.ToolVersions, probeCache())
+		}})
+	}
+	sources = append(sources, shellctx.Source{Name: "corrections", Gather: func() (backend.ContextSection, bool) {
+		section, ok, err := correctionsSection(query, cfg.Context.MaxCorrections)
+		if err != nil && f.verbosity >= verbosityVerbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: ignoring corrections: %v\n", err)
+		}
+		return section, ok
+	}})
+	return sources
+}
+
+// newChecker builds a safety checker honoring the configured patterns,
+// categories and thresholds, the process environment, the state of the current git
+// repository and its project policy.
+func newChecker(cfg *config.Config) *safety.Checker {
+	opts := []safety.Option{safety.WithEnvironment(checkEnvironment())}
+	if cfg.Safety.StrictAsRoot {
+		opts = append(opts, safety.WithStrictAsRoot(), safety.WithSudoProbe(sudoCached))
+	}
+	if cfg.Safety.ProductionHosts != nil {
+		opts = append(opts, safety.WithProductionHosts(cfg.Safety.ProductionHosts...))
+	}
+	// Validate has already rejected invalid patterns.
+	if patterns, err := cfg.Safety.CustomPatterns(); err == nil {
+		opts = append(opts, safety.WithCustomPatterns(patterns...))
+	}
+	opts = append(opts,
+		safety.WithDisabledCategories(cfg.Safety.DisabledCategories...),
+		safety.WithThresholds(cfg.Safety.WarnThreshold, cfg.Safety.BlockThreshold),
+	)
+
+	if git, ok := gitStatusCached(); ok {
+		opts = append(opts, safety.WithGitState(git))
+	}
+	if wd, err := os.Getwd(); err == nil {
+		policy, _, err := config.LoadPolicy(wd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: ignoring project policy: %v\n", err)
+		} else if policy != nil && policy.Git.ProtectedBranches != nil {
+			opts = append(opts, safety.WithProtectedBranches(policy.Git.ProtectedBranches...))
+		}
+	}
+
+	return safety.NewChecker(opts...)
+}
+
+// gitStatusCached looks up the git state of the working directory at most
+// once per process, so generate can start it early.
+var gitStatusCached = sync.OnceValues(func() (safety.GitState, bool) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return safety.GitState{}, false
+	}
+	branch, dirty, ok := shellctx.GitStatus(wd)
+	return safety.GitState{Branch: branch, Dirty: dirty}, ok
+})
+
+// probeCache loads the cache of tool probes at most once per process.
+var probeCache = sync.OnceValue(func() *shellctx.ProbeCache {
+	path := ""
+	if dir, err := xdg.CacheDir(); err == nil {
+		path = filepath.Join(dir, shellctx.ProbeCacheFileName)
+	}
+	return shellctx.LoadProbeCache(path)
+})
+
+// sudoCached runs the sudo probe at most once per process. It is only run
+// for commands that use sudo or doas: without cached credentials, each
+// probe is a failed attempt in the system's auth log.
+var sudoCached = sync.OnceValue(shellctx.SudoCached)
+
+// checkEnvironment describes the environment generated commands will run
+// in. Whether sudo credentials are cached is left to the checker's probe,
+// for commands that need to know.
+func checkEnvironment() safety.Environment {
+	hostname, _ := os.Hostname()
+	return safety.Environment{
+		VirtualEnv: os.Getenv("VIRTUAL_ENV") != "" || os.Getenv("CONDA_PREFIX") != "",
+		Root:       os.Geteuid() == 0,
+		Remote:     shellctx.InSSHSession(),
+		Hostname:   hostname,
+	}
+}
+
+// printRootBanner warns that commands will run with superuser rights and
+// are therefore checked more strictly.
+func printRootBanner(env safety.Environment) {
+	reason := i18n.T("qcmd is running as root")
+	if !env.Root {
+		reason = i18n.T("sudo credentials are cached")
+	}
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "************************************************************")
+	fmt.Fprint(os.Stderr, i18n.Sprintf("  PRIVILEGED: %s.\n", reason))
+	fmt.Fprintln(os.Stderr, i18n.T("  Risky filesystem and system commands are treated as dangerous."))
+	fmt.Fprintln(os.Stderr, "************************************************************")
+	fmt.Fprintln(os.Stderr, "")
+}
+
+// printShortages warns about transfers that may not fit where they write.
+func printShortages(w io.Writer, shortages []advice.Shortage) {
+	for _, s := range shortages {
+		format := "Warning: writing to %s needs about %s, but only %s is free there\n"
+		if s.Compressed {
+			format = "Warning: writing to %s may need up to %s before compression, but only %s is free there\n"
+		}
+		fmt.Fprint(w, i18n.Sprintf(format, s.Dest, advice.FormatBytes(s.Needed), advice.FormatBytes(s.Free)))
+	}
+}
+
+// printFinding shows the category and reason of a safety result, which
+// part of a compound command triggered it (unless that part is the whole
+// command) and how to do the same thing more safely.
+func printFinding(result safety.CheckResult, command string) {
+	fmt.Fprint(os.Stderr, i18n.Sprintf("  Category: %s\n", result.Category))
+	fmt.Fprint(os.Stderr, i18n.Sprintf("  Reason: %s\n", i18n.T(result.Description)))
+	if segment := describeSegment(result, command); segment != "" {
+		fmt.Fprint(os.Stderr, i18n.Sprintf("  Segment: %s\n", segment))
+	}
+	if result.Hint != "" {
+		fmt.Fprint(os.Stderr, i18n.Sprintf("  Hint: %s\n", i18n.T(result.Hint)))
+	}
+}
+
+// describeSegment names the part of command that triggered result, with
+// its line for multi-line commands. It returns "" when that part is the
+// whole command.
+func describeSegment(result safety.CheckResult, command string) string {
+	switch {
+	case result.Segment == "" || result.Segment == strings.TrimSpace(command):
+		return ""
+	case strings.Contains(command, "\n"):
+		return i18n.Sprintf("%s (line %d)", result.Segment, result.Line)
+	default:
+		return result.Segment
+	}
+}
+
+// backendExitCode maps a backend error to the exit code scripts can branch on.
+func backendExitCode(err error) int {
+	switch {
+	case errors.Is(err, backend.ErrNoAPIKey), errors.Is(err, backend.ErrUnauthorized):
+		return exitcode.AuthFailure
+	case errors.Is(err, backend.ErrRateLimited):
+		return exitcode.RateLimited
+	case errors.Is(err, backend.ErrNetwork):
+		return exitcode.NetworkFailure
+	case errors.Is(err, backend.ErrEmptyResponse):
+		return exitcode.EmptyOutput
+	case errors.Is(err, backend.ErrContextTooLong):
+		return exitcode.UserError
+	default:
+		return exitcode.SystemError
 	}
-	return exitSuccess
 }
 
 // parseFlags parses command-line flags and returns a flags struct.
@@ -247,62 +1344,225 @@ func parseFlags(args []string) (*flags, error) {
 	fs := flag.NewFlagSet("qcmd", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 
-	fs.StringVar(&f.queryFile, "query-file", "", "Read query from file")
-	fs.StringVar(&f.query, "query", "", "Direct query string")
-	fs.StringVar(&f.backendStr, "backend", "", "Override backend (anthropic|openai|openrouter)")
-	fs.StringVar(&f.model, "model", "", "Override model")
-	fs.StringVar(&f.outputMode, "output", "", "Output mode: zle|clipboard|print|auto")
-	fs.BoolVar(&f.noSafety, "no-safety", false, "Disable safety checks")
-	fs.StringVar(&f.configPath, "config", "", "Config file path")
-	fs.BoolVar(&f.verbose, "verbose", false, "Verbose output to stderr")
-	fs.BoolVar(&f.showVer, "version", false, "Print version and exit")
+	var as string
+	fs.StringVar(&as, "as", "", i18n.T("Print the command as a build file entry: make-target NAME|just-recipe NAME"))
+	fs.StringVar(&f.writeDir, "write", "", i18n.T("With unit, write the unit files to this directory instead of stdout"))
+	var format string
+	fs.StringVar(&format, "format", "auto", i18n.T("With container, what to generate: auto|command|dockerfile|compose"))
+	fs.StringVar(&f.sample, "sample", "", i18n.T("With filter, try the program on this input file before offering it"))
+	var flavor string
+	fs.StringVar(&flavor, "flavor", "", i18n.T("With regex, the syntax to use: pcre|ere|bre|go (default pcre)"))
+	fs.Var(&f.tests, "test", i18n.T("With regex, try it on this string (repeatable)"))
+	fs.BoolVar(&f.recall, "recall", false, i18n.T("Look for a matching command in the history before generating one"))
+	fs.BoolVar(&f.shellHistory, "shell-history", false, i18n.T("With recall, also search the shell's history file"))
+	fs.StringVar(&f.queryFile, "query-file", "", i18n.T("Read query from file (- for stdin)"))
+	fs.StringVar(&f.query, "query", "", i18n.T("Direct query string"))
+	fs.BoolVar(&f.clipboard, "clipboard", false, i18n.T("Read the query (with explain, the command) from the clipboard"))
+	fs.StringVar(&f.backendStr, "backend", "", i18n.T("Override backend (anthropic|openai|openrouter|remote|mock)"))
+	fs.StringVar(&f.model, "model", "", i18n.T("Override model"))
+	fs.StringVar(&f.outputMode, "output", "", i18n.T("Output mode: zle|clipboard|print|auto|auto-smart|launcher|vim"))
+	fs.BoolVar(&f.noSafety, "no-safety", false, i18n.T("Disable safety checks"))
+	fs.IntVar(&f.metaFD, "meta-fd", 0, i18n.T("Also write safety metadata (level, category) for the shell widget to this file descriptor"))
+	fs.StringVar(&f.metaFile, "meta-file", "", i18n.T("Also write safety metadata (level, category) for the shell widget to this file"))
+	fs.BoolVar(&f.cursorMarker, "cursor-marker", false, i18n.T("With --output=zle, mark where the shell widget should put the cursor with %{CURSOR}%"))
+	fs.StringVar(&f.progress, "progress", "auto", i18n.T("Show a spinner on stderr while waiting: auto|always|never (auto: only on a terminal, not in zle mode)"))
+	fs.StringVar(&f.configPath, "config", "", i18n.T("Config file path"))
+	var quiet, verbose, debug bool
+	var level string
+	fs.StringVar(&level, "verbosity", "", i18n.T("Stderr verbosity: quiet|normal|verbose|debug"))
+	fs.BoolVar(&quiet, "quiet", false, i18n.T("Only print errors and danger warnings to stderr (--verbosity=quiet)"))
+	fs.BoolVar(&verbose, "verbose", false, i18n.T("Verbose output to stderr (--verbosity=verbose)"))
+	fs.BoolVar(&debug, "debug", false, i18n.T("Debug output to stderr (--verbosity=debug)"))
+	fs.BoolVar(&f.dryRun, "dry-run", false, i18n.T("Print the assembled prompt and estimated cost without calling the API"))
+	fs.BoolVar(&f.estimate, "estimate-cost", false, i18n.T("Print the estimated tokens and cost per model without calling the API"))
+	fs.BoolVar(&f.exec, "exec", false, i18n.T("Confirm, optionally edit, then run the command"))
+	fs.BoolVar(&f.editResult, "edit-result", false, i18n.T("Edit the generated command in your editor before output"))
+	fs.StringVar(&f.systemPromptFile, "system-prompt-file", "", i18n.T("Replace the system prompt's instructions with this file's (a template; context is still added)"))
+	fs.StringVar(&f.appendPrompt, "append-prompt", "", i18n.T("Add instructions to the end of the system prompt"))
+	fs.BoolVar(&f.showVer, "version", false, i18n.T("Print version and exit"))
 
 	fs.Usage = func() {
-		fmt.Fprintln(os.Stderr, "qcmd - Natural language to shell command")
+		fmt.Fprintln(os.Stderr, i18n.T("qcmd - Natural language to shell command"))
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "Usage:")
+		fmt.Fprintln(os.Stderr, i18n.T("Usage:"))
 		fmt.Fprintln(os.Stderr, "  qcmd [flags]")
 		fmt.Fprintln(os.Stderr, "  qcmd [command]")
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "Flags:")
+		fmt.Fprintln(os.Stderr, i18n.T("Flags:"))
 		fs.PrintDefaults()
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "Input Precedence (highest to lowest):")
-		fmt.Fprintln(os.Stderr, "  1. --query-file (if provided)")
-		fmt.Fprintln(os.Stderr, "  2. --query (if provided)")
-		fmt.Fprintln(os.Stderr, "  3. Interactive editor")
+		fmt.Fprintln(os.Stderr, i18n.T("Input Precedence (highest to lowest):"))
+		fmt.Fprintln(os.Stderr, i18n.T("  1. --query-file (if provided; with --query, it is material for the query)"))
+		fmt.Fprintln(os.Stderr, i18n.T("  2. --clipboard (if provided)"))
+		fmt.Fprintln(os.Stderr, i18n.T("  3. --query (if provided)"))
+		fmt.Fprintln(os.Stderr, i18n.T("  4. Interactive editor"))
 		fmt.Fprintln(os.Stderr, "")
-		fmt.Fprintln(os.Stderr, "Commands:")
-		fmt.Fprintln(os.Stderr, "  config           Show current configuration")
-		fmt.Fprintln(os.Stderr, "  config init      Create default config file")
-		fmt.Fprintln(os.Stderr, "  backends         List available backends")
+		fmt.Fprintln(os.Stderr, i18n.T("Commands:"))
+		fmt.Fprintln(os.Stderr, i18n.T("  config           Show current configuration"))
+		fmt.Fprintln(os.Stderr, i18n.T("  config init      Create default config file"))
+		fmt.Fprintln(os.Stderr, i18n.T("  backends         List available backends"))
+		fmt.Fprintln(os.Stderr, i18n.T("  snippet          List, show, add, or rm saved snippets"))
+		fmt.Fprintln(os.Stderr, i18n.T("  sync push|pull   Share snippets through the [sync] git remote"))
+		fmt.Fprintln(os.Stderr, i18n.T("  feedback good|bad  Rate the last generated command"))
+		fmt.Fprintln(os.Stderr, i18n.T("  script [flags]   Generate an annotated multi-step script for review"))
+		fmt.Fprintln(os.Stderr, i18n.T("  explain [--clipboard] [COMMAND]  Explain a command without running it"))
+		fmt.Fprintln(os.Stderr, i18n.T("  cron [flags] DESCRIPTION  Generate a crontab line and explain its schedule"))
+		fmt.Fprintln(os.Stderr, i18n.T("  unit [--write DIR] DESCRIPTION  Generate systemd service/timer units"))
+		fmt.Fprintln(os.Stderr, i18n.T("  container [--format F] DESCRIPTION  Generate a docker command, Dockerfile or compose file"))
+		fmt.Fprintln(os.Stderr, i18n.T("  filter [--sample FILE] DESCRIPTION  Generate a jq/awk/sed program and try it on a sample"))
+		fmt.Fprintln(os.Stderr, i18n.T("  regex [--flavor F] [--test S]... DESCRIPTION  Generate a regex and try it on test strings"))
+		fmt.Fprintln(os.Stderr, i18n.T("  plan [--exec] DESCRIPTION  Generate several commands to run in order, one step at a time"))
+		fmt.Fprintln(os.Stderr, i18n.T("  fix [COMMAND]    Correct a failed command, by default the last one"))
+		fmt.Fprintln(os.Stderr, i18n.T("  undo             Show a best-effort command reversing the last one"))
+		fmt.Fprintln(os.Stderr, i18n.T("  recall [--shell-history] DESCRIPTION  Find a past command, or generate one if none matches"))
+		fmt.Fprintln(os.Stderr, i18n.T("  index rebuild|status  Index history and snippets for recall by meaning"))
+		fmt.Fprintln(os.Stderr, i18n.T("  serve                 Answer requests over a socket, reloading the config as it changes"))
+		fmt.Fprintln(os.Stderr, i18n.T("  safety test --file CASES  Check safety patterns against expected levels"))
+		fmt.Fprintln(os.Stderr, i18n.T("  safety forget [COMMAND]   Warn again about commands you ran despite a caution"))
+		fmt.Fprintln(os.Stderr, i18n.T("  check [--verbose] COMMAND  Explain how the safety checker rates a command"))
+		fmt.Fprintln(os.Stderr, i18n.T("  check --format sarif --file FILE  Report a script's risky commands as SARIF"))
+		fmt.Fprintln(os.Stderr, i18n.T("  zle-wrap [--key KEY]  Print the zsh widget for binding qcmd to a key"))
+		fmt.Fprintln(os.Stderr, i18n.T("  completion-helper LINE  Complete a partly typed command; a # comment says what it should do"))
+		fmt.Fprintln(os.Stderr, i18n.T("  hook zsh|bash    Print a shell hook that remembers the last failed command for fix"))
+		fmt.Fprintln(os.Stderr, i18n.T("  cost estimate [QUERY]  Estimate tokens and cost per model without calling the API"))
+		fmt.Fprintln(os.Stderr, i18n.T("  cost report [--by user|backend|model]  Show this month's spend by user, backend or model"))
+		fmt.Fprintln(os.Stderr, i18n.T("  vscode-task [--write] [COMMAND]  Turn the last command into a VS Code task"))
+		fmt.Fprintln(os.Stderr, i18n.T("  version [--json] [--check]  Show the version and build, and whether a newer release is out"))
+	}
+
+	if err := fs.Parse(joinAsFlag(args)); err != nil {
+		return nil, err
+	}
+
+	if as != "" {
+		recipe, err := output.ParseRecipe(as)
+		if err != nil {
+			return nil, err
+		}
+		if f.exec {
+			return nil, fmt.Errorf("--as cannot be used with --exec")
+		}
+		f.recipe = &recipe
 	}
 
-	if err := fs.Parse(args); err != nil {
+	// The shorthand flags are mutually exclusive with each other and with
+	// --verbosity.
+	var set []string
+	for name, on := range map[string]bool{"verbosity": level != "", "quiet": quiet, "verbose": verbose, "debug": debug} {
+		if on {
+			set = append(set, "--"+name)
+		}
+	}
+	if len(set) > 1 {
+		sort.Strings(set)
+		return nil, fmt.Errorf("conflicting verbosity flags: %s", strings.Join(set, ", "))
+	}
+	switch {
+	case quiet:
+		level = "quiet"
+	case verbose:
+		level = "verbose"
+	case debug:
+		level = "debug"
+	}
+	var err error
+	if f.verbosity, err = parseVerbosity(level); err != nil {
 		return nil, err
 	}
+	if f.format, err = container.ParseFormat(format); err != nil {
+		return nil, err
+	}
+	if flavor != "" {
+		if f.flavor, err = regex.ParseFlavor(flavor); err != nil {
+			return nil, err
+		}
+	}
+	f.args = fs.Args()
 
 	return f, nil
 }
 
+// joinAsFlag joins the two values of --as KIND NAME into one argument, as
+// the flag package only takes one value per flag.
+func joinAsFlag(args []string) []string {
+	joined := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(joined, args[i:]...)
+		}
+		switch {
+		case (arg == "--as" || arg == "-as") && i+2 < len(args) && !strings.HasPrefix(args[i+2], "-"):
+			joined = append(joined, arg, args[i+1]+" "+args[i+2])
+			i += 2
+		case (strings.HasPrefix(arg, "--as=") || strings.HasPrefix(arg, "-as=")) && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-"):
+			joined = append(joined, arg+" "+args[i+1])
+			i++
+		default:
+			joined = append(joined, arg)
+		}
+	}
+	return joined
+}
+
+// showProgress reports whether to draw a spinner while waiting on the
+// backend. In auto mode it is drawn only when stderr is a terminal, the
+// output is not captured by the zle widget and qcmd is not quiet.
+func showProgress(setting string, v verbosity, mode output.Mode, stderrTTY bool) (bool, error) {
+	switch setting {
+	case "auto", "":
+		return stderrTTY && mode != output.ModeZLE && v > verbosityQuiet, nil
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid progress mode %q (want auto, always or never)", setting)
+	}
+}
+
+// queryStdin is where --query-file - reads the query from. Tests replace it.
+var queryStdin io.Reader = os.Stdin
+
+// readQueryFile reads the file named by --query-file, or stdin for "-".
+func readQueryFile(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(queryStdin)
+	}
+	return os.ReadFile(name)
+}
+
 // getQuery gets the query string from the appropriate source.
-// Precedence: --query-file > --query > editor
+// Precedence: --query-file > --clipboard > --query > editor, except that
+// --query and --query-file together are combined.
 func getQuery(f *flags, cfg *config.Config) (string, error) {
-	// Priority 1: --query-file
+	// Priority 1: --query-file ("-" for stdin)
 	if f.queryFile != "" {
-		content, err := os.ReadFile(f.queryFile)
+		content, err := readQueryFile(f.queryFile)
 		if err != nil {
 			return "", fmt.Errorf("reading query file: %w", err)
 		}
-		return editor.ProcessInput(string(content)), nil
+		if f.query != "" {
+			return combineQuery(f.query, f.queryFile, string(content), cfg), nil
+		}
+		return longQuery(editor.ProcessInput(string(content)), cfg), nil
 	}
 
-	// Priority 2: --query
+	// Priority 2: --clipboard
+	if f.clipboard {
+		text, err := output.PasteFromClipboard()
+		if err != nil {
+			return "", fmt.Errorf("reading clipboard: %w", err)
+		}
+		return longQuery(strings.TrimSpace(text), cfg), nil
+	}
+
+	// Priority 3: --query
 	if f.query != "" {
 		return f.query, nil
 	}
 
-	// Priority 3: Interactive editor
+	// Priority 4: Interactive editor
 	// Note: Editor uses background context (no timeout) - timeout is for API calls only.
 	ed := editor.NewEditor(cfg.Editor.Editor)
 	query, err := ed.GetInput(context.Background())
@@ -313,11 +1573,81 @@ func getQuery(f *flags, cfg *config.Config) (string, error) {
 	return query, nil
 }
 
-// validateInput validates the query string.
-func validateInput(query string) error {
+// combineQuery appends material read from the query file named name to
+// the instruction given with --query, under a separator naming its source.
+// The material is kept verbatim, comment lines included, and is what gets
+// shortened when advanced.long_query is "truncate".
+func combineQuery(instruction, name, material string, cfg *config.Config) string {
+	source := name
+	if name == "-" {
+		source = "stdin"
+	}
+	separator := fmt.Sprintf("\n\n--- %s ---\n", source)
+	material = strings.TrimSpace(material)
+	if maxLength := cfg.Advanced.MaxQueryLength; cfg.Advanced.LongQuery == "truncate" && maxLength > 0 {
+		if room := maxLength - len(instruction) - len(separator); room > 0 {
+			material = truncateQuery(material, room)
+		}
+	}
+	return instruction + separator + material
+}
+
+// longQuery applies advanced.long_query to a query read from a file or the
+// clipboard. Such queries are often mostly pasted output.
+func longQuery(query string, cfg *config.Config) string {
+	if cfg.Advanced.LongQuery == "truncate" {
+		return truncateQuery(query, cfg.Advanced.MaxQueryLength)
+	}
+	return query
+}
+
+// truncateQuery shortens query to at most maxLength bytes by keeping its
+// start and end, where the question and the most recent output usually
+// are, and noting how much was left out. Cuts are made at line breaks when
+// possible. A maxLength of 0 means no limit.
+func truncateQuery(query string, maxLength int) string {
+	if maxLength <= 0 || len(query) <= maxLength {
+		return query
+	}
+	// Reserve room for the note; its number is at most as long as
+	// len(query) itself.
+	noteLength := len(fmt.Sprintf("\n[... %d bytes omitted ...]\n", len(query)))
+	keep := maxLength - noteLength
+	if keep <= 0 {
+		return query[:runeStart(query, maxLength)]
+	}
+
+	head := query[:runeStart(query, keep/2)]
+	if i := strings.LastIndexByte(head, '\n'); i > 0 {
+		head = head[:i]
+	}
+	start := len(query) - (keep - keep/2)
+	for !utf8.RuneStart(query[start]) {
+		start++
+	}
+	tail := query[start:]
+	if i := strings.IndexByte(tail, '\n'); i >= 0 && i < len(tail)-1 {
+		tail = tail[i+1:]
+	}
+	omitted := len(query) - len(head) - len(tail)
+	return fmt.Sprintf("%s\n[... %d bytes omitted ...]\n%s", head, omitted, tail)
+}
+
+// runeStart returns the largest index at most i that starts a rune in s,
+// so s[:i] does not end in the middle of a UTF-8 sequence.
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+// validateInput validates the query string. A maxLength of 0 means no
+// limit.
+func validateInput(query string, maxLength int) error {
 	// Check for empty/whitespace-only input.
 	if strings.TrimSpace(query) == "" {
-		return errors.New("empty query")
+		return errors.New(i18n.T("empty query"))
 	}
 
 	// Check for null bytes (security).
@@ -326,39 +1656,281 @@ func validateInput(query string) error {
 	}
 
 	// Check reasonable length (prevent abuse).
-	if len(query) > maxQueryLength {
-		return fmt.Errorf("query too long (max %d bytes)", maxQueryLength)
+	if maxLength > 0 && len(query) > maxLength {
+		return errors.New(i18n.Sprintf("query too long (%d bytes, max %d)\n"+
+			"  Shorten it, raise advanced.max_query_length, or set advanced.long_query = \"truncate\"\n"+
+			"  to keep the start and end of long --query-file and --clipboard queries", len(query), maxLength))
+	}
+
+	return nil
+}
+
+// commentBuffer reports whether query, a shell buffer, has a line that is
+// only a comment.
+func commentBuffer(query string) bool {
+	for _, line := range strings.Split(query, "\n") {
+		if shellparse.IsComment(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// styleTasks are the tasks whose answers are shell commands, to which the
+// [style] preferences and the instructions for the OS apply.
+var styleTasks = map[backend.Task]bool{
+	backend.TaskCommand: true, backend.TaskScript: true, backend.TaskCron: true,
+	backend.TaskFix: true, backend.TaskUndo: true, backend.TaskComplete: true,
+	backend.TaskComment: true, backend.TaskPlan: true, backend.TaskFilter: true,
+}
+
+// posixShells run POSIX sh syntax and little else.
+var posixShells = map[string]bool{"sh": true, "dash": true, "ash": true, "posh": true}
+
+// writesPOSIX reports whether commands for task must run in a POSIX sh:
+// with style.avoid_bashisms, in a POSIX shell, and always for crontab
+// lines, which cron runs with sh.
+func writesPOSIX(cfg *config.Config, task backend.Task, shell string) bool {
+	if !styleTasks[task] {
+		return false
+	}
+	return cfg.Style.AvoidBashisms || posixShells[shell] || task == backend.TaskCron
+}
+
+// stylePrompt returns the instructions added to the system prompt for
+// task: those for goos, from style.os or backend.OSPrompts, then one for
+// each [style] preference set. posix asks for sh syntax.
+func stylePrompt(cfg *config.Config, task backend.Task, goos string, posix bool) string {
+	if !styleTasks[task] {
+		return ""
+	}
+	var parts []string
+	osPrompt, ok := cfg.Style.OS[goos]
+	if !ok {
+		osPrompt = backend.OSPrompts[goos]
+	}
+	if osPrompt != "" {
+		parts = append(parts, osPrompt)
+	}
+	if cfg.Style.LongFlags {
+		parts = append(parts, backend.StylePrompts["long_flags"])
+	}
+	if cfg.Style.POSIX {
+		parts = append(parts, backend.StylePrompts["posix"])
+	}
+	if posix {
+		parts = append(parts, backend.StylePrompts["avoid_bashisms"])
+	}
+	return strings.Join(parts, "\n")
+}
+
+// bashScript reports whether script starts with a #! line naming bash or
+// zsh, so it does not run in sh whatever the preferences.
+func bashScript(script string) bool {
+	first, _, _ := strings.Cut(script, "\n")
+	return strings.HasPrefix(first, "#!") && (strings.Contains(first, "bash") || strings.Contains(first, "zsh"))
+}
+
+// applyModelSettings adjusts req with the [models] settings for its model:
+// the prompt suffix is added after any other appended instructions, and
+// temperature and max_tokens fill in what req leaves unset.
+func applyModelSettings(cfg *config.Config, req *backend.Request) {
+	settings, ok := cfg.ModelSettings(req.Model)
+	if !ok {
+		return
+	}
+	if settings.PromptSuffix != "" {
+		req.AppendPrompt = strings.TrimSpace(req.AppendPrompt + "\n\n" + settings.PromptSuffix)
+	}
+	if req.Temperature == nil && settings.Temperature != nil {
+		temperature := *settings.Temperature
+		req.Temperature = &temperature
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = settings.MaxTokens
+	}
+}
+
+// withRefusalReminder returns a copy of req for asking again after the
+// model refused it in prose, reminding it to answer with a command.
+func withRefusalReminder(req *backend.Request) *backend.Request {
+	retried := *req
+	retried.AppendPrompt = strings.TrimSpace(req.AppendPrompt + "\n\n" + backend.RefusalReminder)
+	return &retried
+}
+
+// printDryRun writes the fully assembled prompt for req along with the
+// selected backend/model and estimated token usage and cost.
+func printDryRun(w io.Writer, backendName string, req *backend.Request, maxTokens int, prices map[string]tokens.Price) error {
+	system, err := backend.BuildSystemPrompt(req)
+	if err != nil {
+		return err
+	}
+	inputTokens, err := backend.EstimatePromptTokens(req)
+	if err != nil {
+		return err
 	}
 
+	fmt.Fprintf(w, "Backend: %s\n", backendName)
+	fmt.Fprintf(w, "Model:   %s\n", req.Model)
+	if req.Temperature != nil {
+		fmt.Fprintf(w, "Temperature: %g\n", *req.Temperature)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "--- system ---")
+	fmt.Fprintln(w, system)
+	for _, m := range backend.BuildMessages(req) {
+		fmt.Fprintf(w, "--- %s ---\n", m.Role)
+		fmt.Fprintln(w, m.Content)
+	}
+	fmt.Fprintln(w, "")
+	fmt.Fprintf(w, "Estimated input tokens: %d\n", inputTokens)
+	fmt.Fprintf(w, "Max output tokens:      %d\n", maxTokens)
+
+	price, ok := tokens.LookupPriceIn(prices, req.Model)
+	if !ok {
+		fmt.Fprintf(w, "Estimated cost:         unknown (no price for model %q)\n", req.Model)
+		return nil
+	}
+	fmt.Fprintf(w, "Estimated cost:         $%.6f input, up to $%.6f total\n",
+		price.Cost(inputTokens, 0), price.Cost(inputTokens, maxTokens))
 	return nil
 }
 
+// httpClient returns the client for provider APIs, with the connect, TLS
+// and response header timeouts in cfg, compressing large requests if cfg
+// asks for it. The overall timeout is left to the context of each call.
+// If trace is not nil, it is passed the timing of each request.
+func httpClient(cfg *config.Config, trace func(backend.Timing)) *http.Client {
+	return instrumentClient(cfg, newHTTPClient(cfg), trace)
+}
+
+// newHTTPClient returns a client with the connect, TLS and response header
+// timeouts in cfg.
+func newHTTPClient(cfg *config.Config) *http.Client {
+	return backend.NewHTTPClient(backend.Timeouts{
+		Connect:        cfg.ConnectTimeout(),
+		TLSHandshake:   cfg.TLSTimeout(),
+		ResponseHeader: cfg.ResponseHeaderTimeout(),
+	})
+}
+
+// instrumentClient wraps client to pass request timings to trace, if it is
+// not nil, and to compress large requests if cfg asks for it.
+func instrumentClient(cfg *config.Config, client *http.Client, trace func(backend.Timing)) *http.Client {
+	if trace != nil {
+		client = backend.TraceTimings(client, trace)
+	}
+	if cfg.Advanced.CompressRequestsOver > 0 {
+		client = backend.CompressRequests(client, cfg.Advanced.CompressRequestsOver)
+	}
+	return client
+}
+
+// timingLog collects the timings of API requests, to print them once the
+// spinner is gone.
+type timingLog struct {
+	mu      sync.Mutex
+	timings []backend.Timing
+}
+
+// add records t.
+func (l *timingLog) add(t backend.Timing) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timings = append(l.timings, t)
+}
+
+// flush writes the recorded timings to w and forgets them.
+func (l *timingLog) flush(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, t := range l.timings {
+		fmt.Fprintf(w, "qcmd: http: %s\n", t)
+	}
+	l.timings = nil
+}
+
 // createBackend creates an LLM backend based on the configured backend name.
-func createBackend(name string, cfg *config.Config) (backend.Backend, error) {
+// When QCMD_RECORD or QCMD_REPLAY is set, backend HTTP traffic is recorded
+// to or replayed from fixture files. If trace is not nil, it is passed the
+// timing of each request that goes over the network.
+func createBackend(name string, cfg *config.Config, trace func(backend.Timing)) (backend.Backend, error) {
+	base := newHTTPClient(cfg)
+	if name == "remote" && cfg.Remote.CAFile != "" {
+		pem, err := os.ReadFile(cfg.Remote.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading remote.ca_file: %w", err)
+		}
+		if err := backend.TrustCertificates(base, pem); err != nil {
+			return nil, fmt.Errorf("remote.ca_file: %w", err)
+		}
+	}
+	mode, dir := replay.FromEnv()
+	client := replay.WrapClient(instrumentClient(cfg, base, trace), mode, dir)
+
+	// Replayed requests never reach the provider, so no real key is needed.
+	apiKey := func(key string) string {
+		if key == "" && mode == replay.Replay {
+			return "replay"
+		}
+		return key
+	}
+
 	switch name {
 	case "anthropic":
 		return backend.NewAnthropicBackend(
-			backend.WithAnthropicAPIKey(cfg.Anthropic.APIKey),
+			backend.WithAnthropicAPIKey(apiKey(cfg.Anthropic.APIKey)),
 			backend.WithAnthropicModel(cfg.Anthropic.Model),
 			backend.WithAnthropicMaxTokens(cfg.Advanced.MaxTokens),
+			backend.WithAnthropicHTTPClient(client),
 		), nil
 
 	case "openai":
 		return backend.NewOpenAIBackend(
-			backend.WithOpenAIAPIKey(cfg.OpenAI.APIKey),
+			backend.WithOpenAIAPIKey(apiKey(cfg.OpenAI.APIKey)),
 			backend.WithOpenAIModel(cfg.OpenAI.Model),
 			backend.WithOpenAIMaxTokens(cfg.Advanced.MaxTokens),
+			backend.WithOpenAIHTTPClient(client),
 		), nil
 
 	case "openrouter":
 		return backend.NewOpenRouterBackend(
-			backend.WithOpenRouterAPIKey(cfg.OpenRouter.APIKey),
+			backend.WithOpenRouterAPIKey(apiKey(cfg.OpenRouter.APIKey)),
 			backend.WithOpenRouterModel(cfg.OpenRouter.Model),
 			backend.WithOpenRouterMaxTokens(cfg.Advanced.MaxTokens),
+			backend.WithOpenRouterHTTPClient(client),
 		), nil
 
+	case "remote":
+		user := cfg.Remote.User
+		if user == "" {
+			user = os.Getenv("USER")
+		}
+		return backend.NewRemoteBackend(
+			backend.WithRemoteURL(cfg.Remote.URL),
+			backend.WithRemoteToken(cfg.Remote.Token),
+			backend.WithRemoteUser(user),
+			backend.WithRemoteModel(cfg.Remote.Model),
+			backend.WithRemoteHTTPClient(client),
+		), nil
+
+	case "mock":
+		opts := []backend.MockOption{}
+		for _, rule := range cfg.Mock.Rules {
+			pattern, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mock rule %q: %w", rule.Match, err)
+			}
+			opts = append(opts, backend.WithMockRule(pattern, rule.Command))
+		}
+		if cfg.Mock.Fallback != "" {
+			opts = append(opts, backend.WithMockFallback(cfg.Mock.Fallback))
+		}
+		return backend.NewMockBackend(opts...), nil
+
 	default:
-		return nil, fmt.Errorf("unknown backend: %s (valid: anthropic, openai, openrouter)", name)
+		return nil, fmt.Errorf("unknown backend: %s (valid: anthropic, openai, openrouter, remote, mock)", name)
 	}
 }
 
@@ -373,7 +1945,7 @@ func handleConfigCommand(args []string) int {
 	cfg, err := config.Load(nil)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
-		return exitSystemError
+		return exitcode.SystemError
 	}
 
 	fmt.Fprintln(os.Stderr, "Current configuration:")
@@ -401,8 +1973,11 @@ func handleConfigCommand(args []string) int {
 	fmt.Fprintln(os.Stderr, "  [advanced]")
 	fmt.Fprintf(os.Stderr, "    Timeout:       %ds\n", cfg.Advanced.TimeoutSeconds)
 	fmt.Fprintf(os.Stderr, "    Max Tokens:    %d\n", cfg.Advanced.MaxTokens)
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "  [ui]")
+	fmt.Fprintf(os.Stderr, "    Language:      %s (%s)\n", cfg.UI.Language, i18n.Detect(cfg.UI.Language))
 
-	return exitSuccess
+	return exitcode.Success
 }
 
 // handleConfigInit handles the 'config init' subcommand.
@@ -410,10 +1985,10 @@ func handleConfigInit() int {
 	path, err := config.InitConfig()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
-		return exitUserError
+		return exitcode.UserError
 	}
 	fmt.Fprintf(os.Stderr, "Created config file: %s\n", path)
-	return exitSuccess
+	return exitcode.Success
 }
 
 // handleBackendsCommand handles the 'backends' subcommand.
@@ -421,7 +1996,7 @@ func handleBackendsCommand() int {
 	cfg, err := config.Load(nil)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
-		return exitSystemError
+		return exitcode.SystemError
 	}
 
 	fmt.Fprintln(os.Stderr, "Available backends:")
@@ -467,8 +2042,40 @@ func handleBackendsCommand() int {
 	fmt.Fprintf(os.Stderr, "  openrouter%s\n", activeMarker)
 	fmt.Fprintf(os.Stderr, "    Status: %s\n", openrouterStatus)
 	fmt.Fprintf(os.Stderr, "    Model:  %s\n", cfg.OpenRouter.Model)
+	fmt.Fprintln(os.Stderr, "")
+
+	// Remote
+	remoteStatus := "not configured"
+	if cfg.Remote.URL != "" {
+		remoteStatus = "gateway at " + cfg.Remote.URL
+	}
+	remoteModel := cfg.Remote.Model
+	if remoteModel == "" {
+		remoteModel = "(the gateway's)"
+	}
+	activeMarker = ""
+	if cfg.Backend == "remote" {
+		activeMarker = " (active)"
+	}
+	fmt.Fprintf(os.Stderr, "  remote%s\n", activeMarker)
+	fmt.Fprintf(os.Stderr, "    Status: %s\n", remoteStatus)
+	fmt.Fprintf(os.Stderr, "    Model:  %s\n", remoteModel)
+	fmt.Fprintln(os.Stderr, "")
+
+	// Mock
+	mockRules := "built-in demo rules"
+	if len(cfg.Mock.Rules) > 0 {
+		mockRules = fmt.Sprintf("%d configured rules", len(cfg.Mock.Rules))
+	}
+	activeMarker = ""
+	if cfg.Backend == "mock" {
+		activeMarker = " (active)"
+	}
+	fmt.Fprintf(os.Stderr, "  mock%s\n", activeMarker)
+	fmt.Fprintln(os.Stderr, "    Status: available (offline, no API key needed)")
+	fmt.Fprintf(os.Stderr, "    Rules:  %s\n", mockRules)
 
-	return exitSuccess
+	return exitcode.Success
 }
 
 // maskAPIKey returns a masked version of an API key for display.
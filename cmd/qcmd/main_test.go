@@ -1,13 +1,113 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+	"unicode/utf8"
 
+	"github.com/user/qcmd/internal/advice"
+	"github.com/user/qcmd/internal/audit"
+	"github.com/user/qcmd/internal/backend"
 	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/health"
+	"github.com/user/qcmd/internal/history"
 	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/replay"
+	"github.com/user/qcmd/internal/safety"
 	"github.com/user/qcmd/internal/sanitize"
+	"github.com/user/qcmd/internal/snippets"
+	"github.com/user/qcmd/internal/tokens"
+	"github.com/user/qcmd/internal/trust"
 )
 
+// testDataHome is the data directory TestMain sets up, which runQcmd
+// replaces with one of the test's own.
+var testDataHome string
+
+// TestMain points the data, state and cache directories somewhere
+// temporary, so no test touches the home directory.
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "qcmd-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	testDataHome = filepath.Join(dir, "data")
+	os.Setenv("XDG_DATA_HOME", testDataHome)
+	os.Setenv("XDG_STATE_HOME", filepath.Join(dir, "state"))
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// runQcmd runs qcmd with args and the config cfgTOML, which QCMD_CONFIG
+// names, and returns the exit code and what it printed to stdout and
+// stderr, through the output package or directly. Unless the test chose a
+// data directory itself, it gets a fresh one, shared by its runs.
+func runQcmd(t *testing.T, cfgTOML string, args ...string) (code int, stdout, stderr string) {
+	t.Helper()
+	cfgPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(cfgPath, []byte(cfgTOML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("QCMD_CONFIG", cfgPath)
+	t.Setenv("QCMD_BACKEND", "")
+	if os.Getenv("XDG_DATA_HOME") == testDataHome {
+		t.Setenv("XDG_DATA_HOME", t.TempDir())
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	output.SetOutputWriters(&outBuf, &errBuf)
+	defer output.SetOutputWriters(nil, nil)
+	// Some output bypasses the output package; read it as it is written,
+	// so a full pipe cannot block the run.
+	capture := func(f **os.File) func() string {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		orig := *f
+		*f = w
+		done := make(chan []byte)
+		go func() {
+			data, _ := io.ReadAll(r)
+			done <- data
+		}()
+		return func() string {
+			*f = orig
+			w.Close()
+			return string(<-done)
+		}
+	}
+	restoreStdout := capture(&os.Stdout)
+	restoreStderr := capture(&os.Stderr)
+	code = run(args)
+	stdout = restoreStdout() + outBuf.String()
+	stderr = restoreStderr() + errBuf.String()
+	return code, stdout, stderr
+}
+
 // TestOutputModePrecedence verifies that --output flag overrides config
 // and config is used when flag is absent.
 func TestOutputModePrecedence(t *testing.T) {
@@ -181,7 +281,7 @@ func TestEmptySanitizedOutputHandling(t *testing.T) {
 // that main.go should exhibit when sanitized output is empty.
 func TestEmptySanitizedOutputShouldFail(t *testing.T) {
 	// These inputs should all result in empty sanitized output,
-	// which main.go should treat as an error (exitUserError).
+	// which main.go should treat as an error (exitcode.EmptyOutput).
 	emptyInputs := []string{
 		"",
 		"   ",
@@ -213,3 +313,3130 @@ func TestConfigOutputModeIntegration(t *testing.T) {
 		t.Errorf("config.Default() OutputMode = %v, want ModeAuto", mode)
 	}
 }
+
+// TestPrintDryRun verifies that --dry-run output contains the assembled
+// prompt, the selected backend/model and the token/cost estimates.
+func TestPrintDryRun(t *testing.T) {
+	req := &backend.Request{
+		Query:   "list big files",
+		Model:   "claude-haiku-4-5-20251001",
+		Context: &backend.ShellContext{WorkingDir: "/srv", Shell: "zsh", OS: "linux"},
+	}
+
+	var buf bytes.Buffer
+	if err := printDryRun(&buf, "anthropic", req, 512, tokens.DefaultPrices); err != nil {
+		t.Fatalf("printDryRun() error: %v", err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"Backend: anthropic",
+		"Model:   claude-haiku-4-5-20251001",
+		"--- system ---",
+		"Working directory: /srv",
+		"--- user ---\nlist big files",
+		"Estimated input tokens:",
+		"Estimated cost:         $",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry run output missing %q:\n%s", want, out)
+		}
+	}
+
+	req.Model = "unknown-model"
+	buf.Reset()
+	if err := printDryRun(&buf, "openai", req, 512, tokens.DefaultPrices); err != nil {
+		t.Fatalf("printDryRun() error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "unknown (no price for model") {
+		t.Errorf("expected unknown cost for unpriced model, got:\n%s", buf.String())
+	}
+}
+
+// TestApplyModelSettings verifies that [models] settings adjust requests to
+// the models they match, without overriding what the request already sets.
+func TestApplyModelSettings(t *testing.T) {
+	zero, one := 0.0, 1.0
+	cfg := config.Default()
+	cfg.Models = map[string]config.ModelConfig{
+		"gpt-5o": {PromptSuffix: "Never use markdown.", Temperature: &zero, MaxTokens: 256},
+	}
+
+	req := &backend.Request{Query: "list files", Model: "gpt-5o-mini", AppendPrompt: "Prefer POSIX tools."}
+	applyModelSettings(cfg, req)
+	if req.AppendPrompt != "Prefer POSIX tools.\n\nNever use markdown." {
+		t.Errorf("AppendPrompt = %q", req.AppendPrompt)
+	}
+	if req.Temperature == nil || *req.Temperature != 0 || req.MaxTokens != 256 {
+		t.Errorf("Temperature = %v, MaxTokens = %d; want 0 and 256", req.Temperature, req.MaxTokens)
+	}
+
+	req = &backend.Request{Query: "list files", Model: "gpt-5o", Temperature: &one, MaxTokens: 64}
+	applyModelSettings(cfg, req)
+	if *req.Temperature != 1 || req.MaxTokens != 64 {
+		t.Errorf("Temperature = %v, MaxTokens = %d; want the request's own", *req.Temperature, req.MaxTokens)
+	}
+
+	req = &backend.Request{Query: "list files", Model: "claude-haiku-4-5"}
+	applyModelSettings(cfg, req)
+	if req.AppendPrompt != "" || req.Temperature != nil || req.MaxTokens != 0 {
+		t.Errorf("unmatched model adjusted: %+v", req)
+	}
+}
+
+// TestBackendExitCode verifies that backend failures map to distinct exit codes.
+func TestBackendExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"no api key", backend.ErrNoAPIKey, exitcode.AuthFailure},
+		{"unauthorized", &backend.APIError{StatusCode: 401, Message: "bad key"}, exitcode.AuthFailure},
+		{"forbidden", &backend.APIError{StatusCode: 403, Message: "denied"}, exitcode.AuthFailure},
+		{"rate limited", &backend.APIError{StatusCode: 429, Message: "slow down"}, exitcode.RateLimited},
+		{"network", fmt.Errorf("executing request: %w: %w", backend.ErrNetwork, errors.New("connection refused")), exitcode.NetworkFailure},
+		{"empty response", backend.ErrEmptyResponse, exitcode.EmptyOutput},
+		{"server error", &backend.APIError{StatusCode: 500, Message: "oops"}, exitcode.SystemError},
+		{"other", errors.New("parsing response: bad json"), exitcode.SystemError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := backendExitCode(tt.err); got != tt.want {
+				t.Errorf("backendExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// updateFixtures re-records the replay fixtures used by end-to-end tests.
+// Run `go test ./cmd/qcmd -run TestRunReplay -update` after changing the prompt.
+var updateFixtures = flag.Bool("update", false, "re-record replay fixtures in testdata/")
+
+// cannedTransport answers every request with a fixed Anthropic response.
+// It is only used to (re-)record fixtures.
+type cannedTransport struct{}
+
+func (cannedTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	body := `{"id":"msg_replay","type":"message","role":"assistant","model":"claude-haiku-4-5-20251001",` +
+		`"content":[{"type":"text","text":"ls -la"}],"stop_reason":"end_turn","usage":{"input_tokens":152,"output_tokens":4}}`
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// TestRunReplay drives run() end-to-end against a recorded backend exchange.
+func TestRunReplay(t *testing.T) {
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "replay"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ANTHROPIC_API_KEY", "")
+
+	if *updateFixtures {
+		os.RemoveAll(fixtures)
+		t.Setenv(replay.EnvRecord, fixtures)
+		t.Setenv("ANTHROPIC_API_KEY", "record")
+		orig := http.DefaultClient
+		http.DefaultClient = &http.Client{Transport: cannedTransport{}}
+		defer func() { http.DefaultClient = orig }()
+	} else {
+		t.Setenv(replay.EnvReplay, fixtures)
+	}
+
+	cfg := "include_context = false\n"
+	code, stdout, stderr := runQcmd(t, cfg, "--query", "list all files including hidden ones", "--output", "print")
+	if code != exitcode.Success {
+		t.Fatalf("run() = %d, want %d (stderr: %s)", code, exitcode.Success, stderr)
+	}
+	if stdout != "ls -la\n" {
+		t.Errorf("stdout = %q, want %q", stdout, "ls -la\n")
+	}
+
+	// The generated command is recorded so it can be rated afterwards.
+	if code, _, _ := runQcmd(t, cfg, "feedback", "bad", "--note", "want hidden only", "--correct", "ls -d .*"); code != exitcode.Success {
+		t.Fatalf("feedback returned %d", code)
+	}
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("history = %+v, %v; want one entry", entries, err)
+	}
+	if e := entries[0]; e.Command != "ls -la" || e.Feedback != history.FeedbackBad || e.Note != "want hidden only" {
+		t.Errorf("history entry = %+v", e)
+	}
+	examples, err := loadExamples(5)
+	if err != nil || len(examples) != 1 || examples[0].Command != "ls -d .*" {
+		t.Errorf("examples = %+v, %v", examples, err)
+	}
+}
+
+// TestParseSnippetAdd verifies flag handling for `qcmd snippet add`.
+func TestParseSnippetAdd(t *testing.T) {
+	s, err := parseSnippetAdd([]string{"--favorite", "--desc", "listening sockets", "ports", "ss", "-tulpn"})
+	if err != nil {
+		t.Fatalf("parseSnippetAdd() error: %v", err)
+	}
+	if s.Name != "ports" || s.Command != "ss -tulpn" || s.Description != "listening sockets" || !s.Favorite {
+		t.Errorf("parseSnippetAdd() = %+v", s)
+	}
+
+	if _, err := parseSnippetAdd([]string{"only-name"}); err == nil {
+		t.Error("parseSnippetAdd() expected error without a command")
+	}
+}
+
+// fakeEditor replaces the command with a fixed string.
+type fakeEditor struct{ result string }
+
+func (e fakeEditor) Edit(ctx context.Context, initial string) (string, error) {
+	return e.result, nil
+}
+
+// TestConfirmAndRun verifies the run/edit/cancel prompt of --exec mode.
+func TestConfirmAndRun(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+	never := func(string) bool { return false }
+
+	tests := []struct {
+		name         string
+		answers      string
+		editResult   string
+		blocked      func(string) bool
+		wantExecuted string
+		wantCode     int
+	}{
+		{"yes runs the command", "y\n", "", never, "exit 0", exitcode.Success},
+		{"default cancels", "\n", "", never, "", exitcode.Success},
+		{"eof cancels", "", "", never, "", exitcode.Success},
+		{"edit then run propagates exit status", "e\nyes\n", "exit 9", never, "exit 9", 9},
+		{"edited dangerous command is refused", "e\n", "rm -rf /", func(string) bool { return true }, "", exitcode.DangerBlocked},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promptInput = strings.NewReader(tt.answers)
+			defer func() { promptInput = os.Stdin }()
+
+			executed, code := confirmAndRun("exit 0", fakeEditor{tt.editResult}, tt.blocked, os.Stderr)
+			if executed != tt.wantExecuted || code != tt.wantCode {
+				t.Errorf("confirmAndRun() = %q, %d; want %q, %d", executed, code, tt.wantExecuted, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFillPlaceholders(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers string
+		want    string
+	}{
+		{"all filled", "my-bucket\n30\n", "aws s3 ls s3://my-bucket --max-items 30"},
+		{"empty answer keeps placeholder", "\n30\n", "aws s3 ls s3://<bucket-name> --max-items 30"},
+		{"eof keeps the rest", "my-bucket", "aws s3 ls s3://my-bucket --max-items <count>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promptInput = strings.NewReader(tt.answers)
+			defer func() { promptInput = os.Stdin }()
+
+			command := "aws s3 ls s3://<bucket-name> --max-items <count>"
+			got := fillPlaceholders(command, []string{"<bucket-name>", "<count>"})
+			if got != tt.want {
+				t.Errorf("fillPlaceholders() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCorrectionsSection verifies that related past edits are surfaced as
+// prompt context.
+func TestCorrectionsSection(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	if err := recordCorrection("show disk usage", "df", "df -h"); err != nil {
+		t.Fatal(err)
+	}
+
+	section, ok, err := correctionsSection("show disk usage per mount", 3)
+	if err != nil || !ok {
+		t.Fatalf("correctionsSection() = %v, %v; want a section", ok, err)
+	}
+	if !strings.Contains(section.Content, "`df -h`") {
+		t.Errorf("section content = %q, want the edited command", section.Content)
+	}
+
+	if _, ok, _ := correctionsSection("restart nginx", 3); ok {
+		t.Error("correctionsSection() returned a section for an unrelated query")
+	}
+}
+
+// TestAnnotateScript verifies that scripts are safety-checked line by line.
+func TestAnnotateScript(t *testing.T) {
+	script := "#!/bin/sh\nset -eu\n# Step 1: clean up\nrm -rf /\necho done"
+
+	annotated, dangerous := annotateScript(script, safety.NewChecker(), func(string) bool { return true })
+	if !dangerous {
+		t.Error("annotateScript() did not flag rm -rf /")
+	}
+	if !strings.Contains(annotated, "# qcmd: DANGER") || !strings.Contains(annotated, "\nrm -rf /\n") {
+		t.Errorf("annotated script missing warning or original line:\n%s", annotated)
+	}
+	if strings.Count(annotated, "# qcmd:") != 1 {
+		t.Errorf("expected exactly one annotation:\n%s", annotated)
+	}
+}
+
+// TestRunScript drives `qcmd script` end-to-end with the mock backend.
+func TestRunScript(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	cfg := "backend = \"mock\"\ninclude_context = false\n[editor]\neditor = \"true\"\n"
+
+	// run() prints the script path.
+	code, out, _ := runQcmd(t, cfg, "script", "--query", "show disk usage")
+	if code != exitcode.Success {
+		t.Fatalf("run(script) = %d, want %d", code, exitcode.Success)
+	}
+	path := strings.TrimSpace(out)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading script %q: %v", path, err)
+	}
+	if string(content) != "df -h\n" {
+		t.Errorf("script content = %q, want %q", content, "df -h\n")
+	}
+}
+
+// TestRunCron drives `qcmd cron` end-to-end with the mock backend.
+func TestRunCron(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "backup"
+command = "30 2 * * 1-5 /usr/local/bin/backup.sh"
+[[mock.rules]]
+match = "percent"
+command = "0 0 * * * date +%F"
+[[mock.rules]]
+match = "wipe"
+command = "@daily rm -rf /"
+`
+
+	tests := []struct {
+		query      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"backup every weekday", exitcode.Success, "30 2 * * 1-5 /usr/local/bin/backup.sh\n", "Runs at 02:30 on Monday through Friday.\n"},
+		{"percent", exitcode.UserError, "", "unescaped %"},
+		{"wipe", exitcode.DangerBlocked, "@daily rm -rf /\n", "Runs at 00:00 every day.\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, "cron", "--output", "print", tt.query)
+			if code != tt.wantCode {
+				t.Errorf("run(cron %q) = %d, want %d; stderr:\n%s", tt.query, code, tt.wantCode, stderr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+// TestRunRefusal verifies that a refusal in prose is reported as one, with
+// or without asking again, instead of being checked as a command.
+func TestRunRefusal(t *testing.T) {
+	for _, retry := range []bool{false, true} {
+		t.Run(fmt.Sprintf("retry_refusals=%v", retry), func(t *testing.T) {
+			cfg := fmt.Sprintf(`backend = "mock"
+include_context = false
+[advanced]
+retry_refusals = %v
+[history]
+enabled = false
+[[mock.rules]]
+match = "neighbour"
+command = "I'm sorry, but I can't help with accessing other people's accounts."
+`, retry)
+
+			code, stdout, stderr := runQcmd(t, cfg, "--output", "print", "--verbose", "--query", "read my neighbour's mail")
+			if code != exitcode.UserError || stdout != "" {
+				t.Errorf("run() = %d, stdout %q; want %d and nothing printed", code, stdout, exitcode.UserError)
+			}
+			if !strings.Contains(stderr, "LLM declined the request: I'm sorry") {
+				t.Errorf("stderr = %q, want the refusal", stderr)
+			}
+			if asked := strings.Contains(stderr, "asking again"); asked != retry {
+				t.Errorf("asked again = %v, want %v; stderr:\n%s", asked, retry, stderr)
+			}
+		})
+	}
+
+	req := withRefusalReminder(&backend.Request{Query: "q", AppendPrompt: "Prefer POSIX tools."})
+	if !strings.HasPrefix(req.AppendPrompt, "Prefer POSIX tools.\n\n") || !strings.HasSuffix(req.AppendPrompt, backend.RefusalReminder) {
+		t.Errorf("AppendPrompt = %q", req.AppendPrompt)
+	}
+}
+
+// TestRunSentinel verifies the exit codes for the error sentinel's reasons.
+func TestRunSentinel(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "clean up"
+command = 'echo "QCMD_ERROR[code=ambiguous]: Which directory should be cleaned up?"'
+[[mock.rules]]
+match = "registry"
+command = 'echo "QCMD_ERROR[code=impossible]: there is no registry on Linux"'
+[[mock.rules]]
+match = "plain"
+command = 'echo "QCMD_ERROR: unclear"'
+`
+
+	tests := []struct {
+		query      string
+		wantCode   int
+		wantStderr string
+	}{
+		{"clean up old files", exitcode.Ambiguous, "needs more detail: Which directory should be cleaned up?"},
+		{"edit the registry", exitcode.UserError, "could not generate command: there is no registry on Linux"},
+		{"plain", exitcode.UserError, "could not generate command: unclear"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, "--output", "print", "--query", tt.query)
+			if code != tt.wantCode || stdout != "" {
+				t.Errorf("run(%q) = %d, stdout %q; want %d and nothing printed", tt.query, code, stdout, tt.wantCode)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+// TestClarify verifies that the model's questions are put to the user and
+// the answers sent as later turns, within advanced.clarify_rounds.
+func TestClarify(t *testing.T) {
+	be := backend.NewMockBackend(
+		backend.WithMockRule(regexp.MustCompile(`(?s)clean up.*\n(/\S+)$`), "find $1 -mtime +7 -delete"),
+		backend.WithMockRule(regexp.MustCompile(`clean up`), `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`),
+	)
+	tests := []struct {
+		name      string
+		rounds    int
+		answers   string
+		wantCalls int
+		want      string
+	}{
+		{"answered", 2, "/tmp\n", 1, "find /tmp -mtime +7 -delete"},
+		{"asked again", 2, "the temp one\n/var/tmp\n", 2, "find /var/tmp -mtime +7 -delete"},
+		{"out of rounds", 1, "the temp one\n/var/tmp\n", 1, `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`},
+		{"no answer", 2, "\n", 0, `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`},
+		{"end of input", 2, "", 0, `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`},
+		{"disabled", 0, "/tmp\n", 0, `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			promptInput = strings.NewReader(tt.answers)
+			defer func() { promptInput = os.Stdin }()
+			cfg := config.Default()
+			cfg.Advanced.ClarifyRounds = tt.rounds
+
+			req := &backend.Request{Query: "clean up old files"}
+			resp, err := be.GenerateCommand(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			calls := 0
+			req, resp, err = clarify(cfg, req, resp, func(next *backend.Request) (*backend.Request, *backend.Response, error) {
+				calls++
+				resp, err := be.GenerateCommand(context.Background(), next)
+				return next, resp, err
+			})
+			if err != nil || calls != tt.wantCalls || resp.Command != tt.want {
+				t.Errorf("clarify() = %q, %v after %d calls; want %q after %d", resp.Command, err, calls, tt.want, tt.wantCalls)
+			}
+			if len(req.Clarifications) != calls {
+				t.Errorf("request has %d clarifications, want %d", len(req.Clarifications), calls)
+			}
+		})
+	}
+}
+
+// TestRunCursorMarker verifies that zle output marks the edit point only
+// when asked to, and only for commands the widget inserts. Commands with
+// placeholders are not inserted unless safety.block_placeholders is off.
+func TestRunCursorMarker(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "commit"
+command = 'git commit -m ""'
+[[mock.rules]]
+match = "wipe"
+command = 'rm -rf / --message ""'
+[[mock.rules]]
+match = "list"
+command = 'ls -la'
+[[mock.rules]]
+match = "bucket"
+command = 'aws s3 ls s3://BUCKET_NAME/'
+`
+	allow := cfg + "[safety]\nblock_placeholders = false\n"
+
+	tests := []struct {
+		name     string
+		config   string
+		args     []string
+		wantCode int
+		want     string
+	}{
+		{"marked", cfg, []string{"--cursor-marker", "--query", "commit"}, exitcode.Success, `git commit -m "%{CURSOR}%"`},
+		{"not asked", cfg, []string{"--query", "commit"}, exitcode.Success, `git commit -m ""`},
+		{"nothing to edit", cfg, []string{"--cursor-marker", "--query", "list"}, exitcode.Success, "ls -la"},
+		{"blocked", cfg, []string{"--cursor-marker", "--query", "wipe"}, exitcode.DangerBlocked, `rm -rf / --message ""`},
+		{"placeholder", cfg, []string{"--cursor-marker", "--query", "bucket"}, exitcode.Placeholders, "aws s3 ls s3://BUCKET_NAME/"},
+		{"placeholder allowed", allow, []string{"--cursor-marker", "--query", "bucket"}, exitcode.Success, "aws s3 ls s3://%{CURSOR}%BUCKET_NAME/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, _ := runQcmd(t, tt.config, append([]string{"--output", "zle"}, tt.args...)...)
+			if code != tt.wantCode || stdout != tt.want {
+				t.Errorf("run(%v) = %d, %q; want %d, %q", tt.args, code, stdout, tt.wantCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestSmartMode(t *testing.T) {
+	caution := safety.CheckResult{Level: safety.Caution, Score: 40, Category: "filesystem"}
+	tests := []struct {
+		name        string
+		widget      bool
+		task        backend.Task
+		checked     bool
+		result      safety.CheckResult
+		interactive bool
+		wantMode    output.Mode
+		wantExec    bool
+	}{
+		{"widget", true, backend.TaskCommand, true, caution, true, output.ModeZLE, false},
+		{"read-only at a terminal", false, backend.TaskCommand, true, safety.CheckResult{Level: safety.Safe, ReadOnly: true}, true, output.ModePrint, true},
+		{"read-only in a pipe", false, backend.TaskCommand, true, safety.CheckResult{Level: safety.Safe, ReadOnly: true}, false, output.ModePrint, false},
+		{"mutating", false, backend.TaskCommand, true, safety.CheckResult{Level: safety.Safe}, true, output.ModePrint, false},
+		{"low score still counts", false, backend.TaskCommand, true, safety.CheckResult{Level: safety.Safe, Score: 5, ReadOnly: true}, true, output.ModePrint, false},
+		{"caution", false, backend.TaskCommand, true, caution, true, output.ModePrint, false},
+		{"unchecked", false, backend.TaskCommand, false, safety.CheckResult{}, true, output.ModePrint, false},
+		{"fix", false, backend.TaskFix, true, safety.CheckResult{Level: safety.Safe, ReadOnly: true}, true, output.ModePrint, true},
+		{"crontab entry", false, backend.TaskCron, true, safety.CheckResult{Level: safety.Safe, ReadOnly: true}, true, output.ModePrint, false},
+		{"script", false, backend.TaskScript, true, safety.CheckResult{Level: safety.Safe, ReadOnly: true}, true, output.ModePrint, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, exec, reason := smartMode(tt.widget, tt.task, tt.checked, tt.result, tt.interactive)
+			if mode != tt.wantMode || exec != tt.wantExec || reason == "" {
+				t.Errorf("smartMode() = %v, %v, %q; want %v, %v", mode, exec, reason, tt.wantMode, tt.wantExec)
+			}
+		})
+	}
+}
+
+func TestRunAutoSmart(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+output_mode = "auto-smart"
+[history]
+enabled = false
+[[mock.rules]]
+match = "list"
+command = 'ls -la'
+`
+
+	tests := []struct {
+		name   string
+		widget string
+		want   string
+	}{
+		{"widget", "1", "ls -la"},
+		{"pipe", "", "ls -la\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("QCMD_WIDGET", tt.widget)
+			if code, stdout, _ := runQcmd(t, cfg, "--query", "list"); code != exitcode.Success || stdout != tt.want {
+				t.Errorf("run() = %d, %q; want %d, %q", code, stdout, exitcode.Success, tt.want)
+			}
+		})
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	tail := &tailWriter{max: 10}
+	fmt.Fprint(tail, "abc\n")
+	if got := tail.String(); got != "abc\n" {
+		t.Errorf("String() = %q, want %q", got, "abc\n")
+	}
+	fmt.Fprint(tail, "first line\nlast\n")
+	if got := tail.String(); got != "last\n" {
+		t.Errorf("String() = %q, want the cut line dropped", got)
+	}
+}
+
+// TestRunFix runs a failing command with --exec and history.capture_exec,
+// then fixes it with `qcmd fix` and no arguments.
+func TestRunFix(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = true
+capture_exec = true
+[[mock.rules]]
+match = "unpack"
+command = 'echo "tar: a.tgz: Cannot open" >&2; exit 2'
+[[mock.rules]]
+match = "Cannot open"
+command = 'tar xzf ./a.tgz'
+`
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	t.Setenv("SHELL", "/bin/sh")
+
+	if code, _, _ := runQcmd(t, cfg, "fix", "--output", "print"); code != exitcode.UserError {
+		t.Errorf("fix with nothing run = %d, want %d", code, exitcode.UserError)
+	}
+
+	promptInput = strings.NewReader("y\n")
+	defer func() { promptInput = os.Stdin }()
+	if code, _, _ := runQcmd(t, cfg, "--exec", "--query", "unpack"); code != 2 {
+		t.Fatalf("run --exec = %d, want the command's status 2", code)
+	}
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("history = %+v, %v; want one entry", entries, err)
+	}
+	if e := entries[0]; e.ExitCode != 2 || e.Stderr != "tar: a.tgz: Cannot open\n" {
+		t.Errorf("history entry = %+v, want exit code and stderr", e)
+	}
+
+	if code, stdout, _ := runQcmd(t, cfg, "fix", "--output", "print"); code != exitcode.Success || stdout != "tar xzf ./a.tgz\n" {
+		t.Errorf("fix = %d, %q; want %q", code, stdout, "tar xzf ./a.tgz\n")
+	}
+}
+
+func TestWriteHook(t *testing.T) {
+	opts := hookOptions{Dir: "/run/user/1000/qcmd", Path: "/run/user/1000/qcmd/last-failure"}
+	tests := []struct {
+		shell   string
+		opts    hookOptions
+		want    []string
+		wantErr bool
+	}{
+		{shell: "zsh", opts: opts, want: []string{"add-zsh-hook preexec _qcmd_hook_preexec", "precmd_functions=(_qcmd_hook_precmd", ">| '/run/user/1000/qcmd/last-failure'", "umask 077"}},
+		{shell: "bash", opts: opts, want: []string{`PROMPT_COMMAND="_qcmd_hook_precmd`, ">| '/run/user/1000/qcmd/last-failure'", "umask 077"}},
+		{shell: "fish", opts: opts, wantErr: true},
+		{shell: "zsh", opts: hookOptions{Dir: "/tmp/it's", Path: "/tmp/it's/last-failure"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeHook(&buf, tt.shell, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeHook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(buf.String(), s) {
+					t.Errorf("hook missing %q", s)
+				}
+			}
+			// Check the syntax when the shell is installed.
+			if sh, err := exec.LookPath(tt.shell); err == nil && !tt.wantErr {
+				check := exec.Command(sh, "-n")
+				check.Stdin = &buf
+				if out, err := check.CombinedOutput(); err != nil {
+					t.Errorf("%s -n: %v\n%s", tt.shell, err, out)
+				}
+			}
+		})
+	}
+}
+
+// TestLastFailure verifies that `qcmd fix` picks the more recent of the
+// last --exec failure and the shell hook's.
+func TestLastFailure(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	if _, err := lastFailure(); !errors.Is(err, errNothingToFix) {
+		t.Fatalf("lastFailure() with nothing recorded error = %v, want errNothingToFix", err)
+	}
+
+	if err := recordHistory(history.Entry{Query: "build", Command: "make", Executed: "make", ExitCode: 2, Stderr: "no rule", Time: time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lastFailure(); err != nil || got.Command != "make" || got.Stderr != "no rule" {
+		t.Errorf("lastFailure() = %+v, %v; want the --exec failure", got, err)
+	}
+
+	path, _ := lastFailurePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("127\ngti status\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lastFailure(); err != nil || got.Command != "gti status" || got.Status != 127 {
+		t.Errorf("lastFailure() = %+v, %v; want the shell hook's failure", got, err)
+	}
+
+	if err := os.WriteFile(path, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lastFailure(); err == nil {
+		t.Error("lastFailure() with a malformed state file succeeded")
+	}
+}
+
+func TestRunUndo(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = true
+suggest_undo = true
+[[mock.rules]]
+match = "rename"
+command = "mv a.txt b.txt"
+[[mock.rules]]
+match = "list"
+command = "ls -la"
+[[mock.rules]]
+match = "owner"
+command = "chown bob notes.txt"
+[[mock.rules]]
+match = "Command to reverse"
+command = 'echo "QCMD_ERROR: the previous owner is unknown"'
+`
+	if code, _, _ := runQcmd(t, cfg, "undo"); code != exitcode.UserError {
+		t.Errorf("undo with no history = %d, want %d", code, exitcode.UserError)
+	}
+	for _, query := range []string{"rename", "list", "owner"} {
+		if code, _, _ := runQcmd(t, cfg, "--output", "print", "--query", query); code != exitcode.Success {
+			t.Fatalf("run %q = %d, want %d", query, code, exitcode.Success)
+		}
+	}
+
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("history = %+v, %v; want three entries", entries, err)
+	}
+	// Read-only commands get none, nor do those the model cannot reverse.
+	for i, want := range []string{"mv b.txt a.txt", "", ""} {
+		if entries[i].Undo != want {
+			t.Errorf("entries[%d].Undo = %q, want %q", i, entries[i].Undo, want)
+		}
+	}
+
+	if code, _, _ := runQcmd(t, cfg, "undo"); code != exitcode.Success {
+		t.Errorf("undo = %d, want %d", code, exitcode.Success)
+	}
+	if code, _, _ := runQcmd(t, cfg, "undo", "extra"); code != exitcode.UserError {
+		t.Errorf("undo extra = %d, want %d", code, exitcode.UserError)
+	}
+}
+
+func TestRunQuietCategories(t *testing.T) {
+	tests := []struct {
+		name        string
+		quiet       string
+		wantCaution bool
+	}{
+		{"warned", "[]", true},
+		{"quiet", `["system"]`, false},
+		{"other category quiet", `["network"]`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := `backend = "mock"
+include_context = false
+[safety]
+strict_as_root = false
+quiet_categories = ` + tt.quiet + `
+[[mock.rules]]
+match = "stop"
+command = "pkill -f myapp"
+`
+			code, stdout, stderr := runQcmd(t, cfg, "--output", "print", "--query", "stop myapp")
+			if code != exitcode.Success || stdout != "pkill -f myapp\n" {
+				t.Errorf("run() = %d, %q; want %d, %q", code, stdout, exitcode.Success, "pkill -f myapp\n")
+			}
+			if strings.Contains(stderr, "Caution:") != tt.wantCaution {
+				t.Errorf("stderr = %q, want caution %t", stderr, tt.wantCaution)
+			}
+		})
+	}
+}
+
+func TestRunTrustedCaution(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[safety]
+strict_as_root = false
+[[mock.rules]]
+match = "stop"
+command = "eval true"
+`
+	t.Setenv("SHELL", "/bin/sh")
+	stateHome := t.TempDir()
+	t.Setenv("XDG_STATE_HOME", stateHome)
+	defer func() { promptInput = os.Stdin }()
+
+	// runQuery runs the query, answering answer if asked, and returns what
+	// was written to stderr.
+	runQuery := func(args []string, answer string) string {
+		t.Helper()
+		promptInput = strings.NewReader(answer)
+		_, _, stderr := runQcmd(t, cfg, args...)
+		return stderr
+	}
+	printArgs := []string{"--output", "print", "--query", "stop it"}
+
+	if got := runQuery(printArgs, ""); !strings.Contains(got, "Caution:") {
+		t.Fatalf("first run: stderr = %q, want a caution", got)
+	}
+	// Printing the command is not running it.
+	if got := runQuery(printArgs, ""); !strings.Contains(got, "Caution:") {
+		t.Errorf("after print: stderr = %q, want a caution", got)
+	}
+	// Declining to run it trusts nothing either.
+	runQuery([]string{"--exec", "--query", "stop it"}, "n\n")
+	if got := runQuery(printArgs, ""); !strings.Contains(got, "Caution:") {
+		t.Errorf("after declining: stderr = %q, want a caution", got)
+	}
+
+	runQuery([]string{"--exec", "--query", "stop it"}, "y\n")
+	if got := runQuery(printArgs, ""); strings.Contains(got, "Caution:") {
+		t.Errorf("after running: stderr = %q, want no caution", got)
+	}
+	if _, err := os.Stat(filepath.Join(stateHome, "qcmd", trust.FileName)); err != nil {
+		t.Errorf("trusted commands not in the state directory: %v", err)
+	}
+
+	if code, _, _ := runQcmd(t, cfg, "safety", "forget", "eval", "false"); code != exitcode.UserError {
+		t.Errorf("safety forget of an untrusted command = %d, want %d", code, exitcode.UserError)
+	}
+	if code, _, _ := runQcmd(t, cfg, "safety", "forget"); code != exitcode.Success {
+		t.Errorf("safety forget = %d, want %d", code, exitcode.Success)
+	}
+	if got := runQuery(printArgs, ""); !strings.Contains(got, "Caution:") {
+		t.Errorf("after forget: stderr = %q, want a caution", got)
+	}
+
+	// Dangerous commands that are not blocked are warned about and trusted
+	// alike; blocked ones are never run, so never trusted.
+	dangerCfg := `backend = "mock"
+include_context = false
+[safety]
+block_dangerous = false
+strict_as_root = false
+[[safety.patterns]]
+match = "^true wipe"
+level = "danger"
+description = "wipes everything"
+[[mock.rules]]
+match = "wipe"
+command = "true wipe"
+`
+	runDanger := func(cfg string, args []string, answer string) string {
+		t.Helper()
+		promptInput = strings.NewReader(answer)
+		_, _, stderr := runQcmd(t, cfg, args...)
+		return stderr
+	}
+	wipeArgs := []string{"--output", "print", "--query", "wipe it"}
+	if got := runDanger(dangerCfg, wipeArgs, ""); !strings.Contains(got, "WARNING: Dangerous") {
+		t.Fatalf("unblocked danger: stderr = %q, want a warning", got)
+	}
+	runDanger(dangerCfg, []string{"--exec", "--query", "wipe it"}, "y\n")
+	if got := runDanger(dangerCfg, wipeArgs, ""); strings.Contains(got, "WARNING: Dangerous") {
+		t.Errorf("after running danger: stderr = %q, want no warning", got)
+	}
+	blockedCfg := strings.Replace(dangerCfg, "block_dangerous = false", "block_dangerous = true", 1)
+	if got := runDanger(blockedCfg, wipeArgs, ""); !strings.Contains(got, "WARNING: Dangerous") {
+		t.Errorf("blocked danger after running: stderr = %q, want a warning", got)
+	}
+}
+
+func TestCheckScript(t *testing.T) {
+	script := "#!/bin/sh\nset -e\ncd /tmp\n\nrm -rf /\necho done"
+	findings := checkScript(safety.NewChecker(), script)
+	if len(findings) != 1 {
+		t.Fatalf("checkScript() = %+v, want one finding", findings)
+	}
+	if f := findings[0]; f.Line != 5 || f.Command != "rm -rf /" || f.Result.Level != safety.Danger {
+		t.Errorf("finding = %+v, want rm -rf / on line 5", f)
+	}
+
+	var out bytes.Buffer
+	writeCheckFindings(&out, findings, "deploy.sh", false)
+	if want := "deploy.sh:5: danger (filesystem): Recursive delete on root or home directory\n"; out.String() != want {
+		t.Errorf("writeCheckFindings() = %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	writeCheckFindings(&out, nil, "deploy.sh", false)
+	if out.String() != "safe\n" {
+		t.Errorf("writeCheckFindings() without findings = %q, want %q", out.String(), "safe\n")
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	checker := safety.NewChecker()
+	findings := checkScript(checker, "cd /tmp\nrm -rf /\nsudo apt update\n")
+
+	var out bytes.Buffer
+	if err := writeSARIF(&out, findings, "deploy.sh"); err != nil {
+		t.Fatalf("writeSARIF() error = %v", err)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil {
+		t.Fatalf("writeSARIF() wrote invalid JSON: %v\n%s", err, out.String())
+	}
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("log = %+v, want one SARIF 2.1.0 run", log)
+	}
+	run := log.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "filesystem" || run.Tool.Driver.Rules[1].ID != "system" {
+		t.Errorf("rules = %+v, want filesystem and system", run.Tool.Driver.Rules)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("results = %+v, want two", run.Results)
+	}
+	want := []struct {
+		rule, level string
+		line        int
+	}{
+		{"filesystem", "error", 2},
+		{"system", "warning", 3},
+	}
+	for i, w := range want {
+		r := run.Results[i]
+		if r.RuleID != w.rule || r.Level != w.level || len(r.Locations) != 1 {
+			t.Errorf("results[%d] = %+v, want %s %s", i, r, w.rule, w.level)
+			continue
+		}
+		if loc := r.Locations[0].PhysicalLocation; loc.ArtifactLocation.URI != "deploy.sh" || loc.Region.StartLine != w.line {
+			t.Errorf("results[%d] location = %+v, want deploy.sh:%d", i, loc, w.line)
+		}
+	}
+
+	// A command given as arguments has no location, and safe findings are
+	// left out.
+	out.Reset()
+	findings = []checkFinding{{Result: checker.Check("ls"), Command: "ls"}}
+	if err := writeSARIF(&out, findings, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out.Bytes(), &log); err != nil || len(log.Runs[0].Results) != 0 {
+		t.Errorf("writeSARIF() of a safe command = %s, %v; want no results", out.String(), err)
+	}
+}
+
+// TestRunCompletionHelper verifies that `qcmd completion-helper` sends the
+// typed command line with the comment as the query, and refuses a line
+// that is only a comment.
+func TestRunCompletionHelper(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "^tar -c\n.*png"
+command = "tar -czf pngs.tar.gz *.png"
+[[mock.rules]]
+match = "^ls -l\n$"
+command = "ls -la"
+`
+
+	tests := []struct {
+		name       string
+		line       string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"with comment", "tar -c # all pngs here, gzipped", exitcode.Success, "tar -czf pngs.tar.gz *.png\n", ""},
+		{"without comment", "ls -l", exitcode.Success, "ls -la\n", ""},
+		{"only a comment", "# list files", exitcode.UserError, "", "nothing to complete"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, "completion-helper", "--output", "print", "--query", tt.line)
+			if code != tt.wantCode {
+				t.Errorf("run(completion-helper %q) = %d, want %d; stderr:\n%s", tt.line, code, tt.wantCode, stderr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+// TestRunCommentBuffer verifies that a buffer of shell comments, as the zle
+// widget sends it, is sent whole with the comment prompt and replaced by
+// the commands.
+func TestRunCommentBuffer(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "^# resize all pngs"
+command = "mogrify -resize 50% *.png"
+[[mock.rules]]
+match = "^cd ~/photos\n# compress"
+command = "cd ~/photos\ntar -czf photos.tar.gz ."
+`
+
+	tests := []struct {
+		buffer string
+		want   string
+	}{
+		{"# resize all pngs to 50%", "mogrify -resize 50% *.png\n"},
+		{"cd ~/photos\n# compress them", "cd ~/photos\ntar -czf photos.tar.gz .\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.buffer, func(t *testing.T) {
+			if code, stdout, _ := runQcmd(t, cfg, "--output", "print", "--query", tt.buffer); code != exitcode.Success || stdout != tt.want {
+				t.Errorf("run(%q) = %d, %q; want %d, %q", tt.buffer, code, stdout, exitcode.Success, tt.want)
+			}
+		})
+	}
+
+	code, dryRun, _ := runQcmd(t, cfg, "--dry-run", "--query", "# resize all pngs to 50%")
+	if code != exitcode.Success {
+		t.Fatalf("run(--dry-run) = %d, want %d", code, exitcode.Success)
+	}
+	for _, want := range []string{"You turn shell comments into commands", "Shell buffer:\n# resize all pngs to 50%"} {
+		if !strings.Contains(dryRun, want) {
+			t.Errorf("dry run missing %q:\n%s", want, dryRun)
+		}
+	}
+}
+
+// TestRunPlan drives `qcmd plan` end-to-end with the mock backend: printing
+// the plan, and stepping through it with --exec.
+func TestRunPlan(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "steps"
+command = """
+# Step 1: Do nothing
+true
+# Step 2: Fail
+sh -c 'exit 3'
+# Step 3: Never reached
+true
+"""
+[[mock.rules]]
+match = "wipe"
+command = """
+# Step 1: Do nothing
+true
+# Step 2: Wipe everything
+rm -rf /
+"""
+[[mock.rules]]
+match = "prose"
+command = "Just run ls"
+`
+	t.Setenv("SHELL", "/bin/sh")
+	defer func() { promptInput = os.Stdin }()
+
+	tests := []struct {
+		name       string
+		args       []string
+		answers    string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "print",
+			args:       []string{"steps"},
+			wantCode:   exitcode.Success,
+			wantStdout: "# Step 1: Do nothing\ntrue\n\n# Step 2: Fail\nsh -c 'exit 3'\n\n# Step 3: Never reached\ntrue\n",
+		},
+		{name: "exec stops at a failed step", args: []string{"--exec", "steps"}, answers: "y\ny\n", wantCode: 3, wantStderr: "step 2 failed with exit status 3"},
+		{name: "exec declined", args: []string{"--exec", "steps"}, answers: "y\nn\n", wantCode: exitcode.Success, wantStderr: "stopped before step 2 of 3"},
+		{name: "print dangerous", args: []string{"wipe"}, wantCode: exitcode.DangerBlocked, wantStdout: "# Step 1: Do nothing\ntrue\n\n# Step 2: Wipe everything\nrm -rf /\n", wantStderr: "WARNING: step 2: dangerous command"},
+		{name: "exec dangerous", args: []string{"--exec", "wipe"}, answers: "y\n", wantCode: exitcode.DangerBlocked, wantStdout: "rm -rf /\n", wantStderr: "not running step 2"},
+		{name: "not a plan", args: []string{"prose"}, wantCode: exitcode.UserError, wantStderr: "invalid plan"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// One byte at a time, so each step's prompt reads only its line.
+			promptInput = iotest.OneByteReader(strings.NewReader(tt.answers))
+			code, stdout, stderr := runQcmd(t, cfg, append([]string{"plan"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("run(plan %q) = %d, want %d; stderr:\n%s", tt.args, code, tt.wantCode, stderr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+// TestRunAdvice verifies that tips for long-running commands are printed
+// unless advice.long_running is off or --quiet is given.
+func TestRunAdvice(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "sync"
+command = "rsync -a src/ backup/"
+`
+	off := cfg + "[advice]\nlong_running = false\n"
+
+	tests := []struct {
+		name    string
+		cfg     string
+		args    []string
+		wantTip bool
+	}{
+		{"on", cfg, nil, true},
+		{"off", off, nil, false},
+		{"quiet", cfg, []string{"--quiet"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, tt.cfg, append(tt.args, "--output", "print", "--query", "sync src to backup")...)
+			if code != exitcode.Success || stdout != "rsync -a src/ backup/\n" {
+				t.Fatalf("run() = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+			}
+			if got := strings.Contains(stderr, "Tip: Add -P"); got != tt.wantTip {
+				t.Errorf("stderr = %q, want tip: %v", stderr, tt.wantTip)
+			}
+		})
+	}
+}
+
+func TestPrintShortages(t *testing.T) {
+	var buf bytes.Buffer
+	printShortages(&buf, []advice.Shortage{
+		{Transfer: advice.Transfer{Dest: "/mnt/backup"}, Needed: 12 << 30, Free: 3 << 30},
+		{Transfer: advice.Transfer{Dest: "site.tgz", Compressed: true}, Needed: 2 << 30, Free: 1 << 30},
+	})
+	want := "Warning: writing to /mnt/backup needs about 12.0 GiB, but only 3.0 GiB is free there\n" +
+		"Warning: writing to site.tgz may need up to 2.0 GiB before compression, but only 1.0 GiB is free there\n"
+	if buf.String() != want {
+		t.Errorf("printShortages() = %q, want %q", buf.String(), want)
+	}
+}
+
+// TestRunMissingPaths verifies that advice.missing_paths warns about a path
+// the generated command reads that does not exist.
+func TestRunMissingPaths(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[advice]
+missing_paths = true
+[[mock.rules]]
+match = "config"
+command = "cat /qcmd-test-missing/app.yml"
+`
+	code, stdout, stderr := runQcmd(t, cfg, "--output", "print", "--query", "show the app config")
+	if code != exitcode.Success || stdout != "cat /qcmd-test-missing/app.yml\n" {
+		t.Fatalf("run() = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	if want := "Warning: /qcmd-test-missing/app.yml does not exist here"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+}
+
+// TestRunComplexity verifies that commands over safety.max_pipeline get a
+// banner, and in zle mode are printed instead of inserted.
+func TestRunComplexity(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[safety]
+max_pipeline = 2
+[[mock.rules]]
+match = "ssh"
+command = "ps aux | grep ssh | wc -l"
+`
+
+	tests := []struct {
+		mode     string
+		wantCode int
+	}{
+		{"zle", exitcode.TooComplex},
+		{"print", exitcode.Success},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, "--output", tt.mode, "--query", "count ssh processes")
+			if code != tt.wantCode || !strings.HasPrefix(stdout, "ps aux | grep ssh | wc -l") {
+				t.Fatalf("run() = %d, stdout %q; want %d; stderr:\n%s", code, stdout, tt.wantCode, stderr)
+			}
+			if want := "It chains 3 commands in one pipeline (safety.max_pipeline is 2)"; !strings.Contains(stderr, want) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+			}
+		})
+	}
+}
+
+func TestStylePrompt(t *testing.T) {
+	tests := []struct {
+		name  string
+		style config.StyleConfig
+		task  backend.Task
+		goos  string
+		posix bool
+		want  string
+	}{
+		{"nothing set", config.StyleConfig{}, backend.TaskCommand, "linux", false, ""},
+		{"built-in OS", config.StyleConfig{}, backend.TaskCommand, "darwin", false, backend.OSPrompts["darwin"]},
+		{"OS replaced", config.StyleConfig{OS: map[string]string{"darwin": "Use gsed."}}, backend.TaskCommand, "darwin", false, "Use gsed."},
+		{"OS removed", config.StyleConfig{OS: map[string]string{"darwin": ""}}, backend.TaskCommand, "darwin", false, ""},
+		{"preferences", config.StyleConfig{LongFlags: true, POSIX: true}, backend.TaskScript, "linux", true, backend.StylePrompts["long_flags"] + "\n" + backend.StylePrompts["posix"] + "\n" + backend.StylePrompts["avoid_bashisms"]},
+		{"not a command", config.StyleConfig{LongFlags: true}, backend.TaskExplain, "darwin", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Style = tt.style
+			if got := stylePrompt(cfg, tt.task, tt.goos, tt.posix); got != tt.want {
+				t.Errorf("stylePrompt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWritesPOSIX(t *testing.T) {
+	cfg := config.Default()
+	avoid := config.Default()
+	avoid.Style.AvoidBashisms = true
+	tests := []struct {
+		name  string
+		cfg   *config.Config
+		task  backend.Task
+		shell string
+		want  bool
+	}{
+		{"zsh", cfg, backend.TaskCommand, "zsh", false},
+		{"dash", cfg, backend.TaskCommand, "dash", true},
+		{"crontab", cfg, backend.TaskCron, "zsh", true},
+		{"preference", avoid, backend.TaskCommand, "bash", true},
+		{"not a command", avoid, backend.TaskRegex, "sh", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := writesPOSIX(tt.cfg, tt.task, tt.shell); got != tt.want {
+				t.Errorf("writesPOSIX() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunBashisms verifies that a command using bash syntax is warned
+// about when commands are meant for sh.
+func TestRunBashisms(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[style]
+avoid_bashisms = true
+[[mock.rules]]
+match = "notes"
+command = "[[ -f notes.txt ]] && cat notes.txt"
+`
+	code, _, stderr := runQcmd(t, cfg, "--output", "print", "--query", "show the notes")
+	if code != exitcode.Success {
+		t.Fatalf("run() = %d; stderr:\n%s", code, stderr)
+	}
+	if want := "Warning: not POSIX sh: [[ ]] is a bash test"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+}
+
+// TestRunShellcheck verifies that what shellcheck finds in a generated
+// command is printed to stderr.
+func TestRunShellcheck(t *testing.T) {
+	bin := t.TempDir()
+	fake := "#!/bin/sh\ncat > /dev/null\n" +
+		`echo '{"comments":[{"line":1,"column":4,"level":"warning","code":2086,"message":"Double quote to prevent globbing and word splitting."}]}'` +
+		"\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(bin, "shellcheck"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "file"
+command = "rm notes*"
+`
+	code, stdout, stderr := runQcmd(t, cfg, "--output", "print", "--query", "delete the file")
+	if code != exitcode.Success || stdout != "rm notes*\n" {
+		t.Fatalf("run() = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	if want := "shellcheck: SC2086 (warning): Double quote to prevent globbing"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+}
+
+// TestRunBuiltinLint verifies that the built-in checks run when shellcheck
+// does not.
+func TestRunBuiltinLint(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[lint]
+shellcheck = false
+[[mock.rules]]
+match = "build"
+command = "cd build; make"
+`
+	code, stdout, stderr := runQcmd(t, cfg, "--output", "print", "--query", "build it")
+	if code != exitcode.Success || stdout != "cd build; make\n" {
+		t.Fatalf("run() = %d, stdout %q; stderr:\n%s", code, stdout, stderr)
+	}
+	if want := "lint: SC2164 (warning): Use cd ... &&"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+}
+
+// TestDaysAgo verifies the relative day in "same as #42 from yesterday".
+func TestDaysAgo(t *testing.T) {
+	now := time.Date(2026, 3, 10, 9, 0, 0, 0, time.Local)
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{now.Add(-time.Hour), "today"},
+		{time.Date(2026, 3, 9, 23, 0, 0, 0, time.Local), "yesterday"},
+		{time.Date(2026, 3, 7, 10, 0, 0, 0, time.Local), "3 days ago"},
+	}
+	for _, tt := range tests {
+		if got := daysAgo(tt.t, now); got != tt.want {
+			t.Errorf("daysAgo(%v) = %q, want %q", tt.t, got, tt.want)
+		}
+	}
+}
+
+// TestRunRepeatedCommand verifies a command generated again for the same
+// query is pointed out instead of recorded twice.
+func TestRunRepeatedCommand(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[[mock.rules]]
+match = "disk"
+command = "df -h"
+`
+	var stderr string
+	for _, query := range []string{"show disk usage", "disk usage please"} {
+		var code int
+		code, _, stderr = runQcmd(t, cfg, "--output", "print", "--query", query)
+		if code != exitcode.Success {
+			t.Fatalf("run(%q) = %d; stderr:\n%s", query, code, stderr)
+		}
+	}
+
+	if want := "qcmd: same as #1 from today"; !strings.Contains(stderr, want) {
+		t.Errorf("stderr = %q, want it to contain %q", stderr, want)
+	}
+	entries, err := loadHistory()
+	if err != nil || len(entries) != 1 || entries[0].Query != "show disk usage" {
+		t.Errorf("history = %+v, %v; want the first entry only", entries, err)
+	}
+}
+
+// TestCrashed checks the crash report saved when run panics, which leaves
+// out what the user typed unless the config opts in.
+func TestCrashed(t *testing.T) {
+	args := []string{"explain", "--model", "big", "secret-project"}
+	tests := []struct {
+		name      string
+		config    string
+		wantArgs  string
+		wantQuery bool
+	}{
+		{"redacted", "", "explain --model [redacted] [redacted]", false},
+		{"opted in", "[advanced]\ncrash_reports_include_query = true\n", "explain --model big secret-project", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfgPath := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(cfgPath, []byte(tt.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+			stateHome := t.TempDir()
+			t.Setenv("QCMD_CONFIG", cfgPath)
+			t.Setenv("XDG_STATE_HOME", stateHome)
+
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig := os.Stderr
+			os.Stderr = w
+			code := crashed("no command for secret-project", []byte("goroutine 1 [running]:\nmain.run()\n"), args)
+			os.Stderr = orig
+			w.Close()
+			stderr, _ := io.ReadAll(r)
+
+			if code != exitcode.SystemError {
+				t.Errorf("crashed() = %d, want %d", code, exitcode.SystemError)
+			}
+			if strings.Contains(string(stderr), "secret-project") != tt.wantQuery {
+				t.Errorf("stderr = %q; want the query shown: %v", stderr, tt.wantQuery)
+			}
+			paths, _ := filepath.Glob(filepath.Join(stateHome, "qcmd", crashDirName, "*.txt"))
+			if len(paths) != 1 {
+				t.Fatalf("crash reports = %q, want one", paths)
+			}
+			if !strings.Contains(string(stderr), paths[0]) {
+				t.Errorf("stderr = %q, want the report's path", stderr)
+			}
+			report, err := os.ReadFile(paths[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(report), "args:    "+tt.wantArgs+"\n") || !strings.Contains(string(report), "main.run()") {
+				t.Errorf("report = %s; want args %q and the stack", report, tt.wantArgs)
+			}
+			if strings.Contains(string(report), "secret-project") != tt.wantQuery {
+				t.Errorf("report = %s; want the query included: %v", report, tt.wantQuery)
+			}
+		})
+	}
+}
+
+// TestReadBuildInfo checks that what Go records about a build fills in
+// what the ldflags did not set.
+func TestReadBuildInfo(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	recorded := &debug.BuildInfo{
+		Main: debug.Module{Version: "v1.4.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "0123456789abcdef"},
+			{Key: "vcs.time", Value: "2026-03-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	tests := []struct {
+		name                  string
+		version, commit, date string
+		bi                    *debug.BuildInfo
+		ok                    bool
+		want                  buildInfo
+	}{
+		{"ldflags", "v1.2.3", "abc1234", "2026-03-02T08:00:00Z", recorded, true, buildInfo{Version: "v1.2.3", Commit: "abc1234", BuildDate: "2026-03-02T08:00:00Z"}},
+		{"go install", "dev", "", "", recorded, true, buildInfo{Version: "v1.4.0", Commit: "0123456789abcdef-dirty", BuildDate: "2026-03-01T12:00:00Z"}},
+		{"go build", "dev", "", "", &debug.BuildInfo{Main: debug.Module{Version: "(devel)"}}, true, buildInfo{Version: "dev"}},
+		{"no build information", "dev", "", "", nil, false, buildInfo{Version: "dev"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, commit, date = tt.version, tt.commit, tt.date
+			got := readBuildInfo(tt.bi, tt.ok)
+			if got.GoVersion != runtime.Version() || got.OS != runtime.GOOS || got.Arch != runtime.GOARCH {
+				t.Errorf("readBuildInfo() = %+v, want this binary's Go version and platform", got)
+			}
+			got.GoVersion, got.OS, got.Arch = "", "", ""
+			if got != tt.want {
+				t.Errorf("readBuildInfo() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunVersion drives `qcmd version` against a fake release feed.
+func TestRunVersion(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	failing := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"tag_name": "v1.3.0", "html_url": "https://example.com/releases/v1.3.0"}`)
+	}))
+	defer srv.Close()
+
+	newer, older := true, false
+	tests := []struct {
+		name     string
+		version  string
+		check    bool
+		args     []string
+		failing  bool
+		wantCode int
+		want     *updateInfo
+	}{
+		{"no check", "v1.2.3", false, []string{"--json"}, false, exitcode.Success, nil},
+		{"flag", "v1.2.3", false, []string{"--json", "--check"}, false, exitcode.Success, &updateInfo{Latest: "v1.3.0", URL: "https://example.com/releases/v1.3.0", Newer: &newer}},
+		{"config", "v1.3.0", true, []string{"--json"}, false, exitcode.Success, &updateInfo{Latest: "v1.3.0", URL: "https://example.com/releases/v1.3.0", Newer: &older}},
+		{"development build", "dev", false, []string{"--json", "--check"}, false, exitcode.Success, &updateInfo{Latest: "v1.3.0", URL: "https://example.com/releases/v1.3.0"}},
+		{"feed down", "v1.2.3", false, []string{"--json", "--check"}, true, exitcode.NetworkFailure, &updateInfo{Error: "looking up the latest release: 503 Service Unavailable"}},
+		{"feed down, config", "v1.2.3", true, []string{"--json"}, true, exitcode.Success, &updateInfo{Error: "looking up the latest release: 503 Service Unavailable"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+			version = tt.version
+			failing = tt.failing
+
+			cfg := fmt.Sprintf("[update]\ncheck = %v\nurl = %q\n", tt.check, srv.URL)
+			code, stdout, stderr := runQcmd(t, cfg, append([]string{"version"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("run(version) = %d, want %d; stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			var info buildInfo
+			if err := json.Unmarshal([]byte(stdout), &info); err != nil {
+				t.Fatalf("parsing output: %v\n%s", err, stdout)
+			}
+			if info.Version != tt.version || info.GoVersion != runtime.Version() {
+				t.Errorf("version = %q, go = %q; want %q, %q", info.Version, info.GoVersion, tt.version, runtime.Version())
+			}
+			if !reflect.DeepEqual(info.Update, tt.want) {
+				t.Errorf("update = %+v, want %+v", info.Update, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunUnit drives `qcmd unit` end-to-end with the mock backend.
+func TestRunUnit(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "backup"
+command = """
+# File: backup.service
+[Service]
+Type=oneshot
+ExecStart=/usr/local/bin/backup.sh
+
+# File: backup.timer
+[Timer]
+OnCalendar=daily
+
+[Install]
+WantedBy=timers.target
+"""
+[[mock.rules]]
+match = "broken"
+command = """
+# File: broken.service
+[Service]
+Type=oneshot
+"""
+`
+	runUnit := func(args ...string) (int, string) {
+		code, stdout, _ := runQcmd(t, cfg, append([]string{"unit"}, args...)...)
+		return code, stdout
+	}
+
+	code, out := runUnit("nightly backup")
+	if code != exitcode.Success {
+		t.Fatalf("run(unit) = %d, want %d", code, exitcode.Success)
+	}
+	want := "# File: backup.service\n[Service]\nType=oneshot\nExecStart=/usr/local/bin/backup.sh\n\n" +
+		"# File: backup.timer\n[Timer]\nOnCalendar=daily\n\n[Install]\nWantedBy=timers.target\n"
+	if out != want {
+		t.Errorf("run(unit) stdout = %q, want %q", out, want)
+	}
+
+	if code, _ := runUnit("broken"); code != exitcode.UserError {
+		t.Errorf("run(unit) with a service without ExecStart= = %d, want %d", code, exitcode.UserError)
+	}
+
+	dir := filepath.Join(t.TempDir(), "units")
+	if code, out := runUnit("--write", dir, "nightly backup"); code != exitcode.Success || out != "" {
+		t.Fatalf("run(unit --write) = %d with stdout %q, want %d and no stdout", code, out, exitcode.Success)
+	}
+	for _, name := range []string{"backup.service", "backup.timer"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("run(unit --write) did not write %s: %v", name, err)
+		}
+	}
+	if code, _ := runUnit("--write", dir, "nightly backup"); code != exitcode.SystemError {
+		t.Errorf("run(unit --write) over existing files = %d, want %d", code, exitcode.SystemError)
+	}
+}
+
+func TestRunSafetyCases(t *testing.T) {
+	custom := safety.Pattern{
+		Regex:       regexp.MustCompile(`kubectl .*--context[= ]prod`),
+		Level:       safety.Danger,
+		Description: "Command targets the production cluster",
+		Category:    "custom",
+	}
+	checker := safety.NewChecker(safety.WithCustomPatterns(custom))
+
+	cases := []safetyCase{
+		{Command: "rm -rf /", Level: "danger", Category: "filesystem"},
+		{Command: "ls -la", Level: "safe"},
+		{Command: "kubectl --context prod get pods", Level: "danger", Category: "custom"},
+		{Command: "sudo ls", Level: "danger"},
+		{Command: "rm -rf ~", Level: "danger", Category: "network"},
+	}
+
+	var out bytes.Buffer
+	failed, err := runSafetyCases(checker, cases, &out)
+	if err != nil {
+		t.Fatalf("runSafetyCases() error = %v", err)
+	}
+	if failed != 2 {
+		t.Errorf("failed = %d, want 2\n%s", failed, out.String())
+	}
+	for _, want := range []string{
+		"FAIL case 4: sudo ls\n  want: danger\n  got:  caution (system, score 50)",
+		"FAIL case 5: rm -rf ~\n  want: danger (network)\n  got:  danger (filesystem, score 100)",
+		"2 of 5 cases failed",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if _, err := runSafetyCases(checker, []safetyCase{{Command: "ls", Level: "fine"}}, io.Discard); err == nil {
+		t.Error("runSafetyCases() with an unknown level should fail")
+	}
+}
+
+func TestWriteCheckResult(t *testing.T) {
+	checker := safety.NewChecker()
+
+	tests := []struct {
+		name    string
+		command string
+		verbose bool
+		want    string
+	}{
+		{"safe", "ls -la", true, "safe\n"},
+		{"terse", "rm -rf /", false, "danger (filesystem): Recursive delete on root or home directory\n"},
+		{
+			name:    "verbose with segment",
+			command: "cd /tmp && rm -rf /",
+			verbose: true,
+			want: "danger (filesystem): Recursive delete on root or home directory\n" +
+				"  Score: 100\n" +
+				"  Matched: (?i)rm\\s+(-[rf]+\\s+)*(/|~|\\$HOME)(\\s|$)\n" +
+				"  Segment: rm -rf /\n" +
+				"  Hint: Name the specific directory to delete instead of / or ~\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			writeCheckResult(&out, checker.Check(tt.command), tt.command, tt.verbose)
+			if out.String() != tt.want {
+				t.Errorf("writeCheckResult() =\n%s\nwant:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizeNoSafety(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	cfg := config.Default()
+	if code := authorizeNoSafety(cfg); code != exitcode.UserError {
+		t.Errorf("authorizeNoSafety() without opt-in = %d, want %d", code, exitcode.UserError)
+	}
+	cfg.Safety.AllowDisable = true
+	if code := authorizeNoSafety(cfg); code != exitcode.Success {
+		t.Errorf("authorizeNoSafety() with opt-in = %d, want %d", code, exitcode.Success)
+	}
+
+	s, err := openStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	entries, err := audit.Load(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want 2", len(entries))
+	}
+	if entries[0].Event != audit.EventSafetyDisableRefused || entries[1].Event != audit.EventSafetyDisabled {
+		t.Errorf("audit events = %q, %q", entries[0].Event, entries[1].Event)
+	}
+}
+
+func TestWriteMetadataFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meta.env")
+	if err := os.WriteFile(path, []byte("stale contents from a previous run\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &flags{metaFile: path}
+	if err := writeMetadata(f, output.Metadata{Level: "danger", Category: "filesystem", Score: 100}); err != nil {
+		t.Fatalf("writeMetadata() error = %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "QCMD_LEVEL='danger' QCMD_CATEGORY='filesystem' QCMD_SCORE='100' QCMD_PLACEHOLDERS=''\n"; string(got) != want {
+		t.Errorf("metadata file = %q, want %q", got, want)
+	}
+}
+
+func TestWriteZLEWrap(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    zleWrapOptions
+		want    []string
+		notWant []string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			opts: zleWrapOptions{Name: "_qcmd_widget", Key: "^Q", Timeout: 60},
+			want: []string{"function _qcmd_widget() {", "zle -N _qcmd_widget", "bindkey '^Q' _qcmd_widget", ">= 60", "--output=zle", "3)", "10)", "11)", "QCMD_PLACEHOLDERS", "--cursor-marker", "marker='" + output.CursorMarker + "'", "subcommand=(completion-helper)"},
+		},
+		{
+			name:    "no key binding",
+			opts:    zleWrapOptions{Name: "my_widget", Timeout: 10},
+			want:    []string{"zle -N my_widget", "timed out after 10s"},
+			notWant: []string{"bindkey"},
+		},
+		{name: "invalid name", opts: zleWrapOptions{Name: "a;b", Key: "^Q", Timeout: 60}, wantErr: true},
+		{name: "quote in key", opts: zleWrapOptions{Name: "w", Key: "'", Timeout: 60}, wantErr: true},
+		{name: "zero timeout", opts: zleWrapOptions{Name: "w", Key: "^Q"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeZLEWrap(&buf, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeZLEWrap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, s := range tt.want {
+				if !strings.Contains(buf.String(), s) {
+					t.Errorf("widget missing %q", s)
+				}
+			}
+			for _, s := range tt.notWant {
+				if strings.Contains(buf.String(), s) {
+					t.Errorf("widget unexpectedly contains %q", s)
+				}
+			}
+		})
+	}
+}
+
+func TestShowProgress(t *testing.T) {
+	tests := []struct {
+		setting   string
+		verbosity verbosity
+		mode      output.Mode
+		stderrTTY bool
+		want      bool
+		wantErr   bool
+	}{
+		{setting: "auto", verbosity: verbosityNormal, mode: output.ModePrint, stderrTTY: true, want: true},
+		{setting: "auto", verbosity: verbosityNormal, mode: output.ModePrint, stderrTTY: false, want: false},
+		{setting: "auto", verbosity: verbosityNormal, mode: output.ModeZLE, stderrTTY: true, want: false},
+		{setting: "auto", verbosity: verbosityQuiet, mode: output.ModePrint, stderrTTY: true, want: false},
+		{setting: "always", verbosity: verbosityQuiet, mode: output.ModeZLE, stderrTTY: false, want: true},
+		{setting: "never", verbosity: verbosityDebug, mode: output.ModePrint, stderrTTY: true, want: false},
+		{setting: "sometimes", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := showProgress(tt.setting, tt.verbosity, tt.mode, tt.stderrTTY)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("showProgress(%q) error = %v, wantErr %v", tt.setting, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("showProgress(%q, %v, %v, %v) = %v, want %v", tt.setting, tt.verbosity, tt.mode, tt.stderrTTY, got, tt.want)
+		}
+	}
+}
+
+func TestParseFlagsVerbosity(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    verbosity
+		wantErr bool
+	}{
+		{args: nil, want: verbosityNormal},
+		{args: []string{"--quiet"}, want: verbosityQuiet},
+		{args: []string{"--verbose"}, want: verbosityVerbose},
+		{args: []string{"--debug"}, want: verbosityDebug},
+		{args: []string{"--verbosity", "quiet"}, want: verbosityQuiet},
+		{args: []string{"--verbosity", "loud"}, wantErr: true},
+		{args: []string{"--quiet", "--verbose"}, wantErr: true},
+		{args: []string{"--verbosity", "debug", "--quiet"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFlags(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && f.verbosity != tt.want {
+			t.Errorf("parseFlags(%q) verbosity = %v, want %v", tt.args, f.verbosity, tt.want)
+		}
+	}
+}
+
+func TestParseFlagsAs(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    *output.Recipe
+		wantErr bool
+	}{
+		{args: nil},
+		{args: []string{"--as", "make-target", "build", "--query", "build it"}, want: &output.Recipe{Kind: output.RecipeMake, Name: "build"}},
+		{args: []string{"--query", "x", "-as=just-recipe", "test"}, want: &output.Recipe{Kind: output.RecipeJust, Name: "test"}},
+		{args: []string{"--as", "make-target:build"}, wantErr: true},
+		{args: []string{"--as", "make-target", "--query", "x"}, wantErr: true},
+		{args: []string{"--as", "just-recipe", "two words"}, wantErr: true},
+		{args: []string{"--as", "make-target", "build", "--exec"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		f, err := parseFlags(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFlags(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(f.recipe, tt.want) {
+			t.Errorf("parseFlags(%q) recipe = %+v, want %+v", tt.args, f.recipe, tt.want)
+		}
+		if err == nil && tt.want != nil && f.query == "" {
+			t.Errorf("parseFlags(%q) lost the query", tt.args)
+		}
+	}
+}
+
+func TestWriteExplanation(t *testing.T) {
+	checker := safety.NewChecker()
+	tests := []struct {
+		name    string
+		command string
+		checked bool
+		want    string
+	}{
+		{
+			name:    "safe",
+			command: "ls -la",
+			checked: true,
+			want:    "Lists files.\n\nSafety check: safe\n",
+		},
+		{
+			name:    "unchecked",
+			command: "rm -rf /",
+			want:    "Lists files.\n",
+		},
+		{
+			name:    "dangerous",
+			command: "rm -rf /",
+			checked: true,
+			want:    "Lists files.\n\nSafety check: danger (filesystem): ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var result *safety.CheckResult
+			if tt.checked {
+				r := checker.Check(tt.command)
+				result = &r
+			}
+			var out bytes.Buffer
+			writeExplanation(&out, "Lists files.", result)
+			if !strings.HasPrefix(out.String(), tt.want) {
+				t.Errorf("writeExplanation() = %q, want prefix %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestGetQueryClipboard(t *testing.T) {
+	defer output.SetPasteFunc(nil)
+	cfg := config.Default()
+
+	output.SetPasteFunc(func() (string, error) { return "  rm -rf build\n", nil })
+	got, err := getQuery(&flags{clipboard: true, query: "ignored"}, cfg)
+	if err != nil || got != "rm -rf build" {
+		t.Errorf("getQuery(--clipboard) = %q, %v; want %q", got, err, "rm -rf build")
+	}
+
+	output.SetPasteFunc(func() (string, error) { return "", output.ErrNoClipboard })
+	if _, err := getQuery(&flags{clipboard: true}, cfg); !errors.Is(err, output.ErrNoClipboard) {
+		t.Errorf("getQuery(--clipboard) error = %v, want ErrNoClipboard", err)
+	}
+}
+
+func TestGetQueryFileStdin(t *testing.T) {
+	defer func(r io.Reader) { queryStdin = r }(queryStdin)
+	queryStdin = strings.NewReader("# ignored comment\nlist open ports\n")
+
+	got, err := getQuery(&flags{queryFile: "-"}, config.Default())
+	if err != nil || got != "list open ports" {
+		t.Errorf("getQuery(--query-file -) = %q, %v; want %q", got, err, "list open ports")
+	}
+}
+
+func TestGetQueryCombined(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.log")
+	if err := os.WriteFile(path, []byte("# not a comment\npanic: nil map\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := getQuery(&flags{queryFile: path, query: "find what logged this"}, config.Default())
+	want := "find what logged this\n\n--- " + path + " ---\n# not a comment\npanic: nil map"
+	if err != nil || got != want {
+		t.Errorf("getQuery(--query, --query-file) = %q, %v; want %q", got, err, want)
+	}
+
+	defer func(r io.Reader) { queryStdin = r }(queryStdin)
+	queryStdin = strings.NewReader(strings.Repeat("log line\n", 2000))
+	cfg := config.Default()
+	cfg.Advanced.LongQuery = "truncate"
+	got, err = getQuery(&flags{queryFile: "-", query: "why did this fail?"}, cfg)
+	if err != nil || !strings.HasPrefix(got, "why did this fail?\n\n--- stdin ---\n") || len(got) > cfg.Advanced.MaxQueryLength {
+		t.Errorf("getQuery(--query, --query-file -) = %d bytes starting %.40q, %v; want the instruction and truncated material", len(got), got, err)
+	}
+}
+
+func TestTruncateQuery(t *testing.T) {
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		lines = append(lines, fmt.Sprintf("log line %d", i))
+	}
+	log := "why does this fail?\n" + strings.Join(lines, "\n")
+
+	tests := []struct {
+		name      string
+		query     string
+		maxLength int
+		contains  []string
+	}{
+		{"short query", "list files", 100, []string{"list files"}},
+		{"no limit", log, 0, []string{"log line 500"}},
+		{"long log", log, 500, []string{"why does this fail?\n", "bytes omitted", "\nlog line 999"}},
+		{"multi-byte runes", strings.Repeat("é", 1000), 101, []string{"bytes omitted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateQuery(tt.query, tt.maxLength)
+			if tt.maxLength > 0 && len(got) > tt.maxLength {
+				t.Errorf("truncateQuery() is %d bytes, want at most %d", len(got), tt.maxLength)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateQuery() = %q, not valid UTF-8", got)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("truncateQuery() = %q, missing %q", got, want)
+				}
+			}
+		})
+	}
+
+	if err := validateInput(log, 500); err == nil || !strings.Contains(err.Error(), "long_query") {
+		t.Errorf("validateInput(long query) error = %v, want guidance on long_query", err)
+	}
+	if err := validateInput(log, 0); err != nil {
+		t.Errorf("validateInput(long query, no limit) error = %v", err)
+	}
+}
+
+func TestParseVerdict(t *testing.T) {
+	tests := []struct {
+		answer string
+		want   string
+	}{
+		{"OK: lists files by size", "OK: lists files by size"},
+		{"concern:  deletes more than asked\nIt also removes hidden files.", "CONCERN: deletes more than asked"},
+		{"`OK: fine`", "OK: fine"},
+		{"This looks fine to me.", "UNCLEAR: This looks fine to me."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			if got := parseVerdict(tt.answer); got != tt.want {
+				t.Errorf("parseVerdict(%q) = %q, want %q", tt.answer, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestReview runs a review against the mock backend.
+func TestReview(t *testing.T) {
+	cfg := config.Default()
+	cfg.Backend = "anthropic"
+	cfg.Review.Backend = "mock"
+	cfg.Mock.Rules = []config.MockRuleConfig{
+		{Match: `(?s)^Request: list files.*\nls -S$`, Command: "OK: lists files by size"},
+	}
+
+	result := startReview(cfg, "list files by size", "ls -S", nil)()
+	var buf bytes.Buffer
+	writeReview(&buf, result, false)
+	if want := "Review (mock): OK: lists files by size\n"; buf.String() != want {
+		t.Errorf("review = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeReview(&buf, reviewResult{model: "mock", err: errors.New("boom")}, false)
+	if buf.Len() != 0 {
+		t.Errorf("failed review printed %q without verbose", buf.String())
+	}
+}
+
+func TestPrintCostEstimate(t *testing.T) {
+	req := &backend.Request{Query: "list big files", Model: "local-model"}
+	prices := map[string]tokens.Price{
+		"cheap":  {Input: 0.1, Output: 0.5},
+		"pricey": {Input: 10, Output: 50},
+	}
+
+	var buf bytes.Buffer
+	if err := printCostEstimate(&buf, req, 512, prices); err != nil {
+		t.Fatalf("printCostEstimate() error: %v", err)
+	}
+
+	out := buf.String()
+	cheap, pricey, local := strings.Index(out, "cheap "), strings.Index(out, "pricey "), strings.Index(out, "local-model *")
+	if cheap < 0 || pricey < 0 || local < 0 || !(cheap < pricey && pricey < local) {
+		t.Errorf("want rows cheap, pricey, then the unpriced selected model:\n%s", out)
+	}
+	if !strings.Contains(out, "up to 512") || !strings.Contains(out, "unknown") {
+		t.Errorf("missing output token limit or unknown price:\n%s", out)
+	}
+}
+
+// TestBudget verifies that recorded usage counts against the monthly budget.
+func TestBudget(t *testing.T) {
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("QCMD_REPLAY", "")
+	cfg := config.Default()
+	cfg.Budget.MonthlyUSD = 0.01
+	cfg.Prices = map[string]tokens.Price{"test-model": {Input: 1, Output: 5}}
+
+	if exceeded, _, err := budgetExceeded(cfg, "openai"); exceeded || err != nil {
+		t.Fatalf("budgetExceeded() = %v, %v before any usage", exceeded, err)
+	}
+
+	resp := &backend.Response{Model: "test-model", InputTokens: 5000, OutputTokens: 1000}
+	for _, name := range []string{"openai", "mock"} {
+		if err := recordUsage(cfg, name, resp); err != nil {
+			t.Fatalf("recordUsage(%s) error: %v", name, err)
+		}
+	}
+
+	exceeded, spent, err := budgetExceeded(cfg, "openai")
+	if !exceeded || err != nil || spent != 0.01 {
+		t.Errorf("budgetExceeded() = %v, %v, %v; want true, 0.01, nil", exceeded, spent, err)
+	}
+	if exceeded, _, _ := budgetExceeded(cfg, "mock"); exceeded {
+		t.Error("budget applied to the free mock backend")
+	}
+
+	var buf bytes.Buffer
+	cfg.Budget.LocalBackend = "mock"
+	writeBudgetExceeded(&buf, cfg, spent, true)
+	if !strings.Contains(buf.String(), "qcmd --backend mock") {
+		t.Errorf("budget message does not suggest the local backend:\n%s", buf.String())
+	}
+
+	// Calls made by a gateway are attributed to the user.
+	if err := recordUsageFor(cfg, "anthropic", "alice", resp); err != nil {
+		t.Fatalf("recordUsageFor() error: %v", err)
+	}
+	s, err := openStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	usage, err := history.LoadUsage(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byUser := history.MonthlySpendBy(usage, time.Now(), func(u history.Usage) string { return u.User })
+	buf.Reset()
+	if err := printCostReport(&buf, "user", byUser); err != nil {
+		t.Fatal(err)
+	}
+	want := "USER   THIS MONTH\n-      $0.0100\nalice  $0.0100\ntotal  $0.0200\n"
+	if buf.String() != want {
+		t.Errorf("printCostReport() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestAddVSCodeTask(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".vscode", "tasks.json")
+
+	replaced, err := addVSCodeTask(path, newVSCodeTask("list files", "ls -la"))
+	if err != nil || replaced {
+		t.Fatalf("addVSCodeTask() on a new file = %v, %v; want false, nil", replaced, err)
+	}
+	if _, err := addVSCodeTask(path, newVSCodeTask("disk usage", "du -sh .")); err != nil {
+		t.Fatalf("addVSCodeTask() error = %v", err)
+	}
+	replaced, err = addVSCodeTask(path, newVSCodeTask("list files", "ls -lah"))
+	if err != nil || !replaced {
+		t.Fatalf("addVSCodeTask() with an existing label = %v, %v; want true, nil", replaced, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Version string       `json:"version"`
+		Tasks   []vscodeTask `json:"tasks"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatalf("tasks file is not JSON: %v\n%s", err, data)
+	}
+	want := []vscodeTask{newVSCodeTask("list files", "ls -lah"), newVSCodeTask("disk usage", "du -sh .")}
+	if doc.Version != "2.0.0" || !reflect.DeepEqual(doc.Tasks, want) {
+		t.Errorf("tasks file = %+v, want version 2.0.0 and tasks %+v", doc, want)
+	}
+
+	// Settings qcmd does not know about are kept.
+	other := filepath.Join(t.TempDir(), "tasks.json")
+	os.WriteFile(other, []byte(`{"version": "2.0.0", "options": {"cwd": "src"}, "tasks": []}`), 0644)
+	if _, err := addVSCodeTask(other, newVSCodeTask("build", "make")); err != nil {
+		t.Fatalf("addVSCodeTask() error = %v", err)
+	}
+	data, _ = os.ReadFile(other)
+	if !strings.Contains(string(data), `"cwd": "src"`) {
+		t.Errorf("addVSCodeTask() dropped other settings:\n%s", data)
+	}
+
+	// Files with comments are not rewritten.
+	commented := filepath.Join(t.TempDir(), "tasks.json")
+	original := "{\n\t// build tasks\n\t\"version\": \"2.0.0\"\n}\n"
+	os.WriteFile(commented, []byte(original), 0644)
+	if _, err := addVSCodeTask(commented, newVSCodeTask("build", "make")); err == nil {
+		t.Error("addVSCodeTask() on a file with comments: expected error")
+	}
+	if data, _ := os.ReadFile(commented); string(data) != original {
+		t.Errorf("addVSCodeTask() changed a file it could not parse:\n%s", data)
+	}
+}
+
+func TestRunContainer(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "image"
+command = """
+` + "```dockerfile" + `
+FROM python:3.12-slim
+WORKDIR /app
+COPY . .
+CMD ["python", "app.py"]
+` + "```" + `
+"""
+[[mock.rules]]
+match = "stack"
+command = """
+services:
+  web:
+    image: nginx:1.27
+    privileged: true
+"""
+[[mock.rules]]
+match = "list"
+command = "docker ps -a"
+[[mock.rules]]
+match = "broken"
+command = """
+FROM alpine:3.20
+RUNN true
+"""
+`
+	runContainer := func(args ...string) (int, string, string) {
+		return runQcmd(t, cfg, append([]string{"container", "--output", "print"}, args...)...)
+	}
+
+	tests := []struct {
+		query      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"python image", exitcode.Success, "FROM python:3.12-slim\nWORKDIR /app\nCOPY . .\nCMD [\"python\", \"app.py\"]\n", ""},
+		{"web stack", exitcode.Success, "services:\n  web:\n    image: nginx:1.27\n    privileged: true\n", "Caution: line 4: Runs a privileged container"},
+		{"list containers", exitcode.Success, "docker ps -a\n", ""},
+		{"broken", exitcode.UserError, "", `unknown instruction "RUNN"`},
+	}
+	for _, tt := range tests {
+		code, out, errOut := runContainer(tt.query)
+		if code != tt.wantCode || out != tt.wantStdout {
+			t.Errorf("run(container %q) = %d, %q; want %d, %q", tt.query, code, out, tt.wantCode, tt.wantStdout)
+		}
+		if !strings.Contains(errOut, tt.wantStderr) {
+			t.Errorf("run(container %q) stderr = %q, want it to contain %q", tt.query, errOut, tt.wantStderr)
+		}
+	}
+
+	if code, _, _ := runContainer("--format", "helm", "python image"); code != exitcode.UserError {
+		t.Errorf("run(container --format helm) = %d, want %d", code, exitcode.UserError)
+	}
+	if code, _, _ := runQcmd(t, cfg, "--format", "compose", "list"); code != exitcode.UserError {
+		t.Errorf("run(--format without container) = %d, want %d", code, exitcode.UserError)
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	dir := t.TempDir()
+	sample := filepath.Join(dir, "users.csv")
+	if err := os.WriteFile(sample, []byte("name,email\nada,ada@example.com\nbob,bob@example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "emails"
+command = "awk -F, 'NR > 1 { print $$2 }'"
+[[mock.rules]]
+match = "broken"
+command = "awk '{ print $$2'"
+[[mock.rules]]
+match = "in place"
+command = "sed -i 's/ada/eve/'"
+`
+
+	// The mock backend expands $1 in rules, so $2 is written $$2 above.
+	quoted := "'" + sample + "'"
+	tests := []struct {
+		query      string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"extract emails", exitcode.Success, "awk -F, 'NR > 1 { print $2 }' < " + quoted + "\n", "  ada@example.com\n  bob@example.com\n"},
+		{"broken", exitcode.UserError, "", "the program failed on"},
+		{"replace in place", exitcode.Success, "sed -i 's/ada/eve/' < " + quoted + "\n", "not trying the program"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, "filter", "--output", "print", "--sample", sample, tt.query)
+			if code != tt.wantCode {
+				t.Errorf("run(filter %q) = %d, want %d; stderr:\n%s", tt.query, code, tt.wantCode, stderr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+
+	if code, _, _ := runQcmd(t, cfg, "--sample", sample, "emails"); code != exitcode.UserError {
+		t.Errorf("run(--sample without filter) = %d, want %d", code, exitcode.UserError)
+	}
+	if data, _ := os.ReadFile(sample); !strings.Contains(string(data), "ada") {
+		t.Errorf("the sample was modified: %q", data)
+	}
+}
+
+func TestRunRegex(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "email"
+command = '([a-z]+)@example\.com'
+[[mock.rules]]
+match = "lookahead"
+command = 'foo(?=bar)'
+[[mock.rules]]
+match = "delete"
+command = 'rm -rf /'
+`
+
+	tests := []struct {
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"--test", "ada@example.com", "--test", "ada@example.org", "email"}, exitcode.Success, `([a-z]+)@example\.com` + "\n",
+			"  match     \"ada@example.com\": \"ada@example.com\" (groups: \"ada\")\n  no match  \"ada@example.org\"\n"},
+		{[]string{"--test", "foobar", "lookahead"}, exitcode.Success, "foo(?=bar)\n", "cannot try the regex here"},
+		// A regex is not a command, so it is not blocked.
+		{[]string{"delete"}, exitcode.Success, "rm -rf /\n", ""},
+		{[]string{"--flavor", "emacs", "email"}, exitcode.UserError, "", "invalid flavor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, append([]string{"regex", "--output", "print"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("run(regex %v) = %d, want %d; stderr:\n%s", tt.args, code, tt.wantCode, stderr)
+			}
+			if stdout != tt.wantStdout {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+
+	if code, _, _ := runQcmd(t, cfg, "--test", "x", "email"); code != exitcode.UserError {
+		t.Errorf("run(--test without regex) = %d, want %d", code, exitcode.UserError)
+	}
+}
+
+func TestRunRecall(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "kubernetes"
+command = "kubectl get pods -A"
+`
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SHELL", "/bin/bash")
+	t.Setenv("HISTFILE", "")
+	if err := os.WriteFile(filepath.Join(home, ".bash_history"), []byte("ls\npg_dump -Fc shop > shop.dump\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []history.Entry{
+		{Query: "trim a video to the first minute", Command: "ffmpeg -i in.mp4 -t 60 out.mp4"},
+		{Query: "cut the first minute of a video", Command: "ffmpeg -i in.mp4 -t 60 -c copy out.mp4", Executed: "ffmpeg -i talk.mp4 -t 60 -c copy short.mp4"},
+		{Query: "remove everything", Command: "rm -rf ~"},
+		{Query: "list big files", Command: "du -ah . | sort -h", Feedback: history.FeedbackBad},
+	} {
+		if err := recordHistory(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"that ffmpeg command to trim a video"}, exitcode.Success, "ffmpeg -i in.mp4 -t 60 out.mp4\n", "Recalled from qcmd history"},
+		{[]string{"cutting the video"}, exitcode.Success, "ffmpeg -i talk.mp4 -t 60 -c copy short.mp4\n", ""},
+		{[]string{"remove everything"}, exitcode.DangerBlocked, "rm -rf ~\n", "Dangerous command detected"},
+		// Commands rated bad are not recalled. The mock backend has no rule
+		// for the query, so generating a new command fails.
+		{[]string{"list big files"}, exitcode.UserError, "", "nothing in the history matches"},
+		{[]string{"show kubernetes pods"}, exitcode.Success, "kubectl get pods -A\n", "generating a new command"},
+		// The shell's history is only searched on request.
+		{[]string{"postgres dump of the shop database"}, exitcode.UserError, "", "nothing in the history matches"},
+		{[]string{"--shell-history", "pg_dump the shop database"}, exitcode.Success, "pg_dump -Fc shop > shop.dump\n", "Recalled from bash history"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, append([]string{"recall", "--output", "print"}, tt.args...)...)
+			if code != tt.wantCode {
+				t.Errorf("run(recall %v) = %d, want %d; stderr:\n%s", tt.args, code, tt.wantCode, stderr)
+			}
+			if !strings.HasPrefix(stdout, tt.wantStdout) {
+				t.Errorf("stdout = %q, want %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunIndex(t *testing.T) {
+	cfg := `backend = "mock"
+include_context = false
+[history]
+enabled = false
+[index]
+enabled = true
+backend = "mock"
+[[mock.rules]]
+match = "^never$"
+command = "true"
+`
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	if err := recordHistory(history.Entry{Query: "dump the shop database", Command: "pg_dump -Fc shop > shop.dump"}); err != nil {
+		t.Fatal(err)
+	}
+	snippetsPath, err := dataPath(snippets.FileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := snippets.Save(snippetsPath, []snippets.Snippet{{Name: "backup", Description: "backup backup", Command: "backup.sh"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{[]string{"index", "status"}, exitcode.Success, "No index yet", ""},
+		{[]string{"index", "frobnicate"}, exitcode.UserError, "", "usage: qcmd index"},
+		// Only one word of three matches, so without the index recall
+		// gives up and the mock backend has no rule to generate with.
+		{[]string{"recall", "--output", "print", "--verbose", "backup nightly weekly"}, exitcode.UserError, "", "no index yet"},
+		{[]string{"index", "rebuild"}, exitcode.Success, "", "Indexed 2 items with mock (2 embedded, 0 unchanged)"},
+		{[]string{"index", "rebuild"}, exitcode.Success, "", "(0 embedded, 2 unchanged)"},
+		{[]string{"index", "status"}, exitcode.Success, "Items:   1 history, 1 snippets", ""},
+		{[]string{"recall", "--output", "print", "backup nightly weekly"}, exitcode.Success, "backup.sh\n", `Recalled from snippet "backup"`},
+		{[]string{"recall", "--output", "print", "dump shop database"}, exitcode.Success, "pg_dump -Fc shop > shop.dump\n", "Recalled from qcmd history"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, cfg, tt.args...)
+			if code != tt.wantCode {
+				t.Errorf("run(%v) = %d, want %d; stderr:\n%s", tt.args, code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+// scriptedBackend answers requests with commands in turn and records them.
+type scriptedBackend struct {
+	commands []string
+	requests []*backend.Request
+}
+
+func (b *scriptedBackend) Name() string { return "scripted" }
+
+func (b *scriptedBackend) GenerateCommand(ctx context.Context, req *backend.Request) (*backend.Response, error) {
+	b.requests = append(b.requests, req)
+	if len(b.requests) > len(b.commands) {
+		return nil, errors.New("no more answers")
+	}
+	return &backend.Response{Command: b.commands[len(b.requests)-1], Model: "scripted"}, nil
+}
+
+// windowedBackend refuses prompts over the context window of each model,
+// in bytes for simplicity, and records the requests it is sent.
+type windowedBackend struct {
+	windows  map[string]int
+	requests []*backend.Request
+}
+
+func (b *windowedBackend) Name() string { return "windowed" }
+
+func (b *windowedBackend) GenerateCommand(ctx context.Context, req *backend.Request) (*backend.Response, error) {
+	b.requests = append(b.requests, req)
+	size := len(req.Query)
+	if req.Context != nil {
+		for _, s := range req.Context.Sections {
+			size += len(s.Content)
+		}
+	}
+	for _, e := range req.Examples {
+		size += len(e.Query) + len(e.Command)
+	}
+	if size > b.windows[req.Model] {
+		return nil, &backend.APIError{StatusCode: 400, Message: "prompt is too long"}
+	}
+	return &backend.Response{Command: "ls", Model: req.Model}, nil
+}
+
+func TestGenerateFitting(t *testing.T) {
+	long := strings.Repeat("x", 100)
+	tests := []struct {
+		name       string
+		query      string
+		fallbacks  []string
+		wantModel  string
+		wantCalls  int
+		wantLogged int
+		wantErr    bool
+	}{
+		{name: "fits", query: "list", wantModel: "small", wantCalls: 1},
+		{name: "fits without context", query: "list", wantModel: "small", wantCalls: 2, wantLogged: 1},
+		{name: "larger model", query: long, fallbacks: []string{"small", "medium", "large"}, wantModel: "large", wantCalls: 4, wantLogged: 3},
+		{name: "no fallback", query: long, wantCalls: 2, wantLogged: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Advanced.ContextFallbackModels = tt.fallbacks
+			be := &windowedBackend{windows: map[string]int{"small": 50, "medium": 80, "large": 1000}}
+			req := &backend.Request{
+				Query:    tt.query,
+				Model:    "small",
+				Context:  &backend.ShellContext{WorkingDir: "/srv"},
+				Examples: []backend.Example{{Query: "disk", Command: "df -h"}},
+			}
+			if tt.name != "fits" {
+				req.Context.Sections = []backend.ContextSection{{Name: "directory listing", Content: long}}
+			}
+			var logged []string
+			answered, resp, err := generateFitting(context.Background(), cfg, be, req, func(format string, args ...any) {
+				logged = append(logged, fmt.Sprintf(format, args...))
+			})
+
+			if len(be.requests) != tt.wantCalls || len(logged) != tt.wantLogged {
+				t.Errorf("%d calls, logged %q; want %d calls, %d messages", len(be.requests), logged, tt.wantCalls, tt.wantLogged)
+			}
+			if tt.wantErr {
+				if !errors.Is(err, backend.ErrContextTooLong) {
+					t.Errorf("err = %v, want ErrContextTooLong", err)
+				}
+				return
+			}
+			if err != nil || resp.Model != tt.wantModel || answered.Model != tt.wantModel {
+				t.Fatalf("generateFitting() = %+v, %v; want an answer from %s", resp, err, tt.wantModel)
+			}
+			if tt.wantModel == "large" && len(answered.Context.Sections) != 1 {
+				t.Errorf("larger model was sent %d context sections, want the full request", len(answered.Context.Sections))
+			}
+		})
+	}
+
+	req := &backend.Request{Context: &backend.ShellContext{Sections: []backend.ContextSection{{Name: "git"}}}}
+	trimmed, dropped := withoutOptionalContext(req)
+	if len(req.Context.Sections) != 1 || len(trimmed.Context.Sections) != 0 || !reflect.DeepEqual(dropped, []string{"git"}) {
+		t.Errorf("withoutOptionalContext() changed the request or dropped %q", dropped)
+	}
+}
+
+func TestCheckFlags(t *testing.T) {
+	bin := t.TempDir()
+	tool := filepath.Join(bin, "qcmdtool")
+	if err := os.WriteFile(tool, []byte("#!/bin/sh\necho 'usage: qcmdtool [-i extension] file'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	cfg := config.Default()
+	cfg.Context.IncludeHelp = true
+	f := &flags{verbosity: verbosityNormal}
+	req := &backend.Request{
+		Query:   "edit file in place",
+		Context: &backend.ShellContext{OS: "darwin", Sections: []backend.ContextSection{{Name: "Directory listing", Content: "file"}}},
+	}
+
+	tests := []struct {
+		name        string
+		command     string
+		answers     []string
+		want        string
+		wantRequest bool
+	}{
+		{"revised", "qcmdtool -i file", []string{"qcmdtool -i '' file"}, "qcmdtool -i '' file", true},
+		{"sentinel keeps the draft", "qcmdtool -i file", []string{`echo "QCMD_ERROR: unsure"`}, "qcmdtool -i file", true},
+		{"failure keeps the draft", "qcmdtool -i file", nil, "qcmdtool -i file", true},
+		{"no help", "qcmd-no-such-tool -x", []string{"unused"}, "qcmd-no-such-tool -x", false},
+		{"builtin only", "cd /tmp", []string{"unused"}, "cd /tmp", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			be := &scriptedBackend{commands: tt.answers}
+			first := &backend.Response{Command: tt.command}
+			got, resp := checkFlags(cfg, f, be, "mock", req, first, tt.command, false)
+			if got != tt.want {
+				t.Errorf("checkFlags() = %q, want %q", got, tt.want)
+			}
+			if (len(be.requests) > 0) != tt.wantRequest {
+				t.Fatalf("checkFlags() made %d requests, want a request: %v", len(be.requests), tt.wantRequest)
+			}
+			if got == tt.command && resp != first {
+				t.Error("checkFlags() replaced the response but kept the command")
+			}
+			if !tt.wantRequest {
+				return
+			}
+			check := be.requests[0]
+			sections := check.Context.Sections
+			if len(sections) != 2 || sections[1].Content != "usage: qcmdtool [-i extension] file" {
+				t.Errorf("check request sections = %+v, want the listing and the help", sections)
+			}
+			if len(req.Context.Sections) != 1 {
+				t.Errorf("checkFlags() changed the original request's sections: %+v", req.Context.Sections)
+			}
+			if !strings.Contains(check.AppendPrompt, "qcmdtool -i file") {
+				t.Errorf("check request prompt %q does not quote the draft", check.AppendPrompt)
+			}
+		})
+	}
+}
+
+func TestIsOutage(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{fmt.Errorf("executing request: %w: %w", backend.ErrNetwork, errors.New("connection refused")), true},
+		{fmt.Errorf("calling API: %w", context.DeadlineExceeded), true},
+		{&backend.APIError{StatusCode: 503, Message: "overloaded"}, true},
+		{&backend.APIError{StatusCode: 429, Message: "slow down"}, false},
+		{&backend.APIError{StatusCode: 401, Message: "bad key"}, false},
+		{backend.ErrNoAPIKey, false},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := isOutage(tt.err); got != tt.want {
+			t.Errorf("isOutage(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRunBreaker(t *testing.T) {
+	noFallback := `backend = "openai"
+include_context = false
+[history]
+enabled = false
+[[mock.rules]]
+match = "^list files$"
+command = "ls"
+[advanced]
+`
+	withFallback := noFallback + `fallback_backends = ["openai", "mock"]` + "\n"
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	// Three outages in a row open the circuit of the openai backend.
+	for i := 0; i < 3; i++ {
+		recordHealth(config.Default(), "openai", backend.ErrNetwork, false)
+	}
+	// Other errors and successes of other backends change nothing.
+	recordHealth(config.Default(), "openai", backend.ErrUnauthorized, false)
+	recordHealth(config.Default(), "mock", nil, false)
+
+	tests := []struct {
+		name       string
+		cfg        string
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{"fails fast", noFallback, nil, exitcode.NetworkFailure, "", "backend openai failed repeatedly and is skipped until"},
+		{"falls back", withFallback, nil, exitcode.Success, "ls\n", "using mock until"},
+		{"explicit backend", noFallback, []string{"--backend", "mock"}, exitcode.Success, "ls\n", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runQcmd(t, tt.cfg, append(tt.args, "--output", "print", "--query", "list files")...)
+			if code != tt.wantCode {
+				t.Errorf("run() = %d, want %d; stderr:\n%s", code, tt.wantCode, stderr)
+			}
+			if !strings.Contains(stdout, tt.wantStdout) {
+				t.Errorf("stdout = %q, want it to contain %q", stdout, tt.wantStdout)
+			}
+			if !strings.Contains(stderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want it to contain %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+
+	// A success closes the circuit again.
+	recordHealth(config.Default(), "openai", nil, false)
+	_, state, err := loadHealth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := state.Open("openai", time.Now()); open {
+		t.Error("circuit still open after a success")
+	}
+}
+
+func TestHealthPath(t *testing.T) {
+	dataDir, stateDir := t.TempDir(), t.TempDir()
+	t.Setenv("XDG_DATA_HOME", dataDir)
+	t.Setenv("XDG_STATE_HOME", stateDir)
+	old := filepath.Join(dataDir, "qcmd", health.FileName)
+	if err := os.MkdirAll(filepath.Dir(old), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(old, []byte(`{"backends": {}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := healthPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(stateDir, "qcmd", health.FileName); path != want {
+		t.Errorf("healthPath() = %q, want %q", path, want)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != `{"backends": {}}` {
+		t.Errorf("moved file = %q, %v", data, err)
+	}
+	if _, err := os.Stat(old); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("old file still there: %v", err)
+	}
+}
+
+func TestTimingLog(t *testing.T) {
+	var l timingLog
+	l.add(backend.Timing{Host: "api.anthropic.com", Protocol: "HTTP/2.0", DNS: 12 * time.Millisecond, Connect: 30 * time.Millisecond, TLS: 41 * time.Millisecond, TTFB: 1234 * time.Millisecond, Total: 1301 * time.Millisecond})
+	l.add(backend.Timing{Host: "api.anthropic.com", Protocol: "HTTP/2.0", Reused: true, Sent: 5120, TTFB: 800 * time.Millisecond, Total: 802 * time.Millisecond})
+
+	var buf bytes.Buffer
+	l.flush(&buf)
+	want := "qcmd: http: api.anthropic.com: dns 12ms, connect 30ms, tls 41ms, ttfb 1.23s, total 1.3s (HTTP/2.0, new connection)\n" +
+		"qcmd: http: api.anthropic.com: ttfb 800ms, total 802ms, sent 5120 bytes (HTTP/2.0, reused connection)\n"
+	if buf.String() != want {
+		t.Errorf("flush() wrote:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	buf.Reset()
+	l.flush(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("second flush() wrote %q", buf.String())
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.toml")
+	writeConfig := func(rules string) {
+		cfg := "backend = \"mock\"\ninclude_context = false\n[history]\nenabled = false\n" + rules
+		if err := os.WriteFile(cfgPath, []byte(cfg), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("[[mock.rules]]\nmatch = \"^list files$\"\ncommand = \"ls\"\n[[mock.rules]]\nmatch = \"^wipe$\"\ncommand = \"rm -rf /\"\n")
+	t.Setenv("QCMD_BACKEND", "")
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	var log bytes.Buffer
+	s, err := newServer(&config.LoadOptions{ConfigPath: cfgPath}, &log)
+	if err != nil {
+		t.Fatalf("newServer() error: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	post := func(query string) (int, generateResponse) {
+		t.Helper()
+		body, _ := json.Marshal(generateRequest{Query: query, WorkingDir: "/tmp", Shell: "zsh"})
+		resp, err := http.Post(ts.URL+"/v1/generate", "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out generateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, out
+	}
+
+	if status, out := post("list files"); status != http.StatusOK || out.Command != "ls" || out.Backend != "mock" || out.Safety == nil || out.Safety.Level != "safe" {
+		t.Errorf("generate = %d %+v", status, out)
+	}
+	if status, out := post("wipe"); status != http.StatusUnprocessableEntity || out.Command != "" || out.Safety == nil || out.Safety.Level != "danger" {
+		t.Errorf("generate of a dangerous command = %d %+v", status, out)
+	}
+	if status, _ := post(""); status != http.StatusBadRequest {
+		t.Errorf("generate of an empty query = %d, want %d", status, http.StatusBadRequest)
+	}
+	badUser, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/generate", strings.NewReader(`{"query": "list files"}`))
+	badUser.Header.Set(UserHeader, "alice; rm -rf")
+	if resp, err := http.DefaultClient.Do(badUser); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("generate with an invalid user = %v, %v; want status %d", resp, err, http.StatusBadRequest)
+	} else {
+		resp.Body.Close()
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`qcmd_requests_total{backend="mock",status="200"} 1`,
+		`qcmd_requests_total{backend="mock",status="422"} 1`,
+		`qcmd_requests_total{backend="",status="400"} 1`,
+		`qcmd_request_duration_seconds_count{backend="mock"} 2`,
+		`qcmd_commands_total{level="danger"} 1`,
+		`qcmd_commands_total{level="safe"} 1`,
+		`qcmd_requests_in_flight 0`,
+	} {
+		if !strings.Contains(string(metrics), want+"\n") {
+			t.Errorf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+
+	// A changed file is picked up on reload.
+	writeConfig("[[mock.rules]]\nmatch = \"^list files$\"\ncommand = \"ls -la\"\n")
+	changed, err := s.reload()
+	if err != nil {
+		t.Fatalf("reload() error: %v", err)
+	}
+	if !reflect.DeepEqual(changed, []string{"mock.rules"}) {
+		t.Errorf("reload() changed %v, want [mock.rules]", changed)
+	}
+	if _, out := post("list files"); out.Command != "ls -la" {
+		t.Errorf("after reload, command = %q, want %q", out.Command, "ls -la")
+	}
+
+	// An invalid file is not.
+	if err := os.WriteFile(cfgPath, []byte("backend = \"nope\"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.reload(); err == nil {
+		t.Error("reload() of an invalid config succeeded")
+	}
+	if _, out := post("list files"); out.Command != "ls -la" {
+		t.Errorf("after a failed reload, command = %q, want %q", out.Command, "ls -la")
+	}
+
+	// The watcher reloads by itself.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	before := s.state.Load()
+	go s.watchConfig(ctx, 10*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+	writeConfig("[[mock.rules]]\nmatch = \"^list files$\"\ncommand = \"ls -lah\"\n")
+	deadline := time.Now().Add(2 * time.Second)
+	for s.state.Load() == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, out := post("list files"); out.Command != "ls -lah" {
+		t.Errorf("after the watcher reloaded, command = %q, want %q", out.Command, "ls -lah")
+	}
+}
+
+func TestServeSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qcmd", SocketFileName)
+	ln, err := listenSocket(path)
+	if err != nil {
+		t.Fatalf("listenSocket() error: %v", err)
+	}
+	defer func() { ln.Close() }()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("socket mode = %o, want 600", mode)
+	}
+
+	tests := []struct {
+		name string
+		uid  int
+		want bool
+	}{
+		{"same user", os.Geteuid(), true},
+		// Where peer credentials are unsupported, the socket mode decides.
+		{"other user", os.Geteuid() + 1, runtime.GOOS != "linux"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refused := make(chan string, 1)
+			pl := &peerListener{Listener: ln, uid: tt.uid, logf: func(format string, args ...any) {
+				refused <- fmt.Sprintf(format, args...)
+			}}
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				if conn, err := pl.Accept(); err == nil {
+					accepted <- conn
+				}
+			}()
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			select {
+			case c := <-accepted:
+				c.Close()
+				if !tt.want {
+					t.Error("Accept() let the connection through")
+				}
+			case msg := <-refused:
+				if tt.want {
+					t.Errorf("Accept() refused the connection: %s", msg)
+				}
+				// Unblock the pending Accept: the listener is reused.
+				ln.Close()
+				if ln, err = listenSocket(path); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	s := &server{}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no token", "", "", http.StatusUnauthorized},
+		{"right token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"missing token", "s3cret", "", http.StatusUnauthorized},
+		{"not a bearer token", "s3cret", "s3cret", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Serve.Token = tt.token
+			s.state.Store(&serveState{cfg: cfg})
+			req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			s.requireToken(ok).ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestServeLimiter(t *testing.T) {
+	l := newLimiter()
+	ctx := context.Background()
+	if err := l.Acquire(ctx, 1); err != nil {
+		t.Fatalf("Acquire() error: %v", err)
+	}
+
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(short, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() beyond the limit = %v, want a deadline error", err)
+	}
+	// A raised limit, as after a reload, lets it through.
+	if err := l.Acquire(ctx, 2); err != nil {
+		t.Errorf("Acquire() under a raised limit error: %v", err)
+	}
+	if err := l.Acquire(ctx, 0); err != nil {
+		t.Errorf("Acquire() without a limit error: %v", err)
+	}
+
+	waited := make(chan error, 1)
+	go func() { waited <- l.Acquire(ctx, 3) }()
+	time.Sleep(20 * time.Millisecond)
+	l.Release()
+	if err := <-waited; err != nil {
+		t.Errorf("Acquire() after a Release error: %v", err)
+	}
+	if n := l.Active(); n != 3 {
+		t.Errorf("Active() = %d, want 3", n)
+	}
+
+	go func() { waited <- l.Acquire(ctx, 1) }()
+	time.Sleep(20 * time.Millisecond)
+	l.Close()
+	if err := <-waited; !errors.Is(err, errShuttingDown) {
+		t.Errorf("waiting Acquire() after Close = %v, want errShuttingDown", err)
+	}
+	if err := l.Acquire(ctx, 0); !errors.Is(err, errShuttingDown) {
+		t.Errorf("Acquire() after Close = %v, want errShuttingDown", err)
+	}
+}
+
+func TestServeDrain(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(cfgPath, []byte("backend = \"mock\"\n[advanced]\ntimeout_seconds = 5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("QCMD_BACKEND", "")
+	s, err := newServer(&config.LoadOptions{ConfigPath: cfgPath}, io.Discard)
+	if err != nil {
+		t.Fatalf("newServer() error: %v", err)
+	}
+
+	started := make(chan struct{})
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() { ran <- s.run(ctx, []servedListener{{ln: ln, srv: &http.Server{Handler: slow}}}, nil) }()
+
+	got := make(chan string, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			got <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		got <- string(body)
+	}()
+	<-started
+	cancel()
+
+	if body := <-got; body != "done" {
+		t.Errorf("request in flight at shutdown got %q, want %q", body, "done")
+	}
+	if err := <-ran; err != nil {
+		t.Errorf("run() error: %v", err)
+	}
+	if _, err := http.Get("http://" + ln.Addr().String()); err == nil {
+		t.Error("request after shutdown succeeded")
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	m := newServeMetrics()
+	m.observe("openai", 200, 300*time.Millisecond, "safe")
+	m.observe("openai", 200, 3*time.Second, "warning")
+	m.observe("openai", 504, 2*time.Minute, "")
+	m.reloaded(nil)
+	m.reloaded(errors.New("invalid config"))
+
+	var b bytes.Buffer
+	m.write(&b, 2)
+	out := b.String()
+	for _, want := range []string{
+		"# TYPE qcmd_request_duration_seconds histogram\n",
+		`qcmd_request_duration_seconds_bucket{backend="openai",le="0.25"} 0` + "\n",
+		`qcmd_request_duration_seconds_bucket{backend="openai",le="0.5"} 1` + "\n",
+		`qcmd_request_duration_seconds_bucket{backend="openai",le="4"} 2` + "\n",
+		`qcmd_request_duration_seconds_bucket{backend="openai",le="60"} 2` + "\n",
+		`qcmd_request_duration_seconds_bucket{backend="openai",le="+Inf"} 3` + "\n",
+		`qcmd_request_duration_seconds_sum{backend="openai"} 123.3` + "\n",
+		`qcmd_requests_total{backend="openai",status="504"} 1` + "\n",
+		`qcmd_commands_total{level="warning"} 1` + "\n",
+		`qcmd_config_reloads_total{result="failure"} 1` + "\n",
+		"qcmd_requests_in_flight 2\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+	if label("a\"b\\c\n") != `"a\"b\\c\n"` {
+		t.Errorf("label() = %s", label("a\"b\\c\n"))
+	}
+}
+
+func TestServeRemoteBackend(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.toml")
+	cfg := "backend = \"mock\"\n[[mock.rules]]\nmatch = \"^list files$\"\ncommand = \"ls\"\n[[mock.rules]]\nmatch = \"^wipe$\"\ncommand = \"rm -rf /\"\n"
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("QCMD_BACKEND", "")
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	s, err := newServer(&config.LoadOptions{ConfigPath: cfgPath}, io.Discard)
+	if err != nil {
+		t.Fatalf("newServer() error: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	// Compressed requests, as with compress_requests_over, are accepted.
+	remote := backend.NewRemoteBackend(
+		backend.WithRemoteURL(ts.URL),
+		backend.WithRemoteUser("alice"),
+		backend.WithRemoteHTTPClient(backend.CompressRequests(&http.Client{}, 1)),
+	)
+	req := &backend.Request{Query: "list files", Context: &backend.ShellContext{WorkingDir: "/srv", Shell: "zsh", OS: "linux"}}
+	resp, err := remote.GenerateCommand(context.Background(), req)
+	if err != nil {
+		t.Fatalf("GenerateCommand() error: %v", err)
+	}
+	if resp.Command != "ls" || resp.Model != "mock" {
+		t.Errorf("GenerateCommand() = %+v, want ls from mock", resp)
+	}
+
+	// The client checks safety, so the gateway passes commands on.
+	resp, err = remote.GenerateCommand(context.Background(), &backend.Request{Query: "wipe"})
+	if err != nil || resp.Command != "rm -rf /" {
+		t.Errorf("GenerateCommand() of a dangerous command = %+v, %v", resp, err)
+	}
+
+	_, err = remote.GenerateCommand(context.Background(), &backend.Request{Query: strings.Repeat("x", 20000)})
+	var apiErr *backend.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("GenerateCommand() of an overlong query error = %v, want status 400", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request latency
+// histogram. Generations take from a fraction of a second to the timeout.
+var durationBuckets = []float64{0.25, 0.5, 1, 2, 4, 8, 15, 30, 60}
+
+// serveMetrics counts what `qcmd serve` answers, for GET /metrics in the
+// Prometheus text format. Labels carry backend names, statuses and safety
+// levels only, never queries or commands.
+type serveMetrics struct {
+	mu        sync.Mutex
+	requests  map[[2]string]uint64 // by backend and status
+	durations map[string]*histogram
+	levels    map[string]uint64
+	reloads   map[string]uint64 // by result
+}
+
+// histogram is one Prometheus histogram with durationBuckets.
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// newServeMetrics returns empty metrics.
+func newServeMetrics() *serveMetrics {
+	return &serveMetrics{
+		requests:  make(map[[2]string]uint64),
+		durations: make(map[string]*histogram),
+		levels:    make(map[string]uint64),
+		reloads:   make(map[string]uint64),
+	}
+}
+
+// observe records a request answered with status after d. backendName is
+// empty if the request failed before one was chosen, and level if no
+// command was rated.
+func (m *serveMetrics) observe(backendName string, status int, d time.Duration, level string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{backendName, strconv.Itoa(status)}]++
+	h := m.durations[backendName]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.durations[backendName] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+	if level != "" {
+		m.levels[level]++
+	}
+}
+
+// reloaded records a config reload, which failed if err is not nil.
+func (m *serveMetrics) reloaded(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reloads[result]++
+}
+
+// write writes the metrics to w in the Prometheus text format, along with
+// inFlight, the generations running now.
+func (m *serveMetrics) write(w io.Writer, inFlight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header(w, "qcmd_requests_total", "counter", "Requests answered, from clients and remote backends, by backend and HTTP status.")
+	for _, key := range sortedKeys(m.requests) {
+		fmt.Fprintf(w, "qcmd_requests_total{backend=%s,status=%s} %d\n", label(key[0]), label(key[1]), m.requests[key])
+	}
+
+	header(w, "qcmd_request_duration_seconds", "histogram", "Time to answer requests, by backend.")
+	for _, name := range sortedKeys(m.durations) {
+		h := m.durations[name]
+		var cumulative uint64
+		for i, le := range durationBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "qcmd_request_duration_seconds_bucket{backend=%s,le=%s} %d\n", label(name), label(strconv.FormatFloat(le, 'g', -1, 64)), cumulative)
+		}
+		fmt.Fprintf(w, "qcmd_request_duration_seconds_bucket{backend=%s,le=\"+Inf\"} %d\n", label(name), h.count)
+		fmt.Fprintf(w, "qcmd_request_duration_seconds_sum{backend=%s} %s\n", label(name), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "qcmd_request_duration_seconds_count{backend=%s} %d\n", label(name), h.count)
+	}
+
+	header(w, "qcmd_commands_total", "counter", "Generated commands, by safety level, including blocked ones.")
+	for _, level := range sortedKeys(m.levels) {
+		fmt.Fprintf(w, "qcmd_commands_total{level=%s} %d\n", label(level), m.levels[level])
+	}
+
+	header(w, "qcmd_config_reloads_total", "counter", "Config reloads, by result.")
+	for _, result := range sortedKeys(m.reloads) {
+		fmt.Fprintf(w, "qcmd_config_reloads_total{result=%s} %d\n", label(result), m.reloads[result])
+	}
+
+	header(w, "qcmd_requests_in_flight", "gauge", "Generations running now.")
+	fmt.Fprintf(w, "qcmd_requests_in_flight %d\n", inFlight)
+}
+
+// handler returns the GET /metrics endpoint for m, reading the number of
+// generations in flight from inFlight.
+func (m *serveMetrics) handler(inFlight func() int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.write(w, inFlight())
+	})
+}
+
+// header writes the HELP and TYPE lines of a metric.
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelEscaper escapes label values as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// label quotes a label value.
+func label(v string) string {
+	return `"` + labelEscaper.Replace(v) + `"`
+}
+
+// sortedKeys returns the keys of m in order, so the output is stable.
+func sortedKeys[K interface{ ~string | ~[2]string }, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+	return keys
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+)
+
+// generateFitting calls be with req and, if the prompt is too long for the
+// model, tries again: first without the optional context sections and
+// examples, then with the whole request on each of
+// advanced.context_fallback_models in order. It returns the request that
+// was answered along with the answer, or the last error. logf, if not nil,
+// is told about each retry.
+func generateFitting(ctx context.Context, cfg *config.Config, be backend.Backend, req *backend.Request, logf func(format string, args ...any)) (*backend.Request, *backend.Response, error) {
+	resp, err := be.GenerateCommand(ctx, req)
+	if !errors.Is(err, backend.ErrContextTooLong) {
+		return req, resp, err
+	}
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+
+	if trimmed, dropped := withoutOptionalContext(req); len(dropped) > 0 {
+		logf("prompt too long for %s; retrying without %s", modelLabel(req.Model), strings.Join(dropped, ", "))
+		resp, err = be.GenerateCommand(ctx, trimmed)
+		if !errors.Is(err, backend.ErrContextTooLong) {
+			return trimmed, resp, err
+		}
+	}
+
+	for _, model := range cfg.Advanced.ContextFallbackModels {
+		if model == req.Model {
+			continue
+		}
+		larger := *req
+		larger.Model = model
+		logf("prompt too long for %s; retrying with %s", modelLabel(req.Model), model)
+		resp, err = be.GenerateCommand(ctx, &larger)
+		if !errors.Is(err, backend.ErrContextTooLong) {
+			return &larger, resp, err
+		}
+	}
+	return req, nil, err
+}
+
+// withoutOptionalContext returns a copy of req without its context sections
+// and examples, along with the names of what was left out. The working
+// directory, shell and OS are kept.
+func withoutOptionalContext(req *backend.Request) (*backend.Request, []string) {
+	trimmed := *req
+	var dropped []string
+	if req.Context != nil && len(req.Context.Sections) > 0 {
+		shellContext := *req.Context
+		for _, s := range shellContext.Sections {
+			dropped = append(dropped, s.Name)
+		}
+		shellContext.Sections = nil
+		trimmed.Context = &shellContext
+	}
+	if len(req.Examples) > 0 {
+		dropped = append(dropped, "examples")
+		trimmed.Examples = nil
+	}
+	return &trimmed, dropped
+}
+
+// modelLabel names model in messages, which is empty when the backend
+// picks its default.
+func modelLabel(model string) string {
+	if model == "" {
+		return "the default model"
+	}
+	return model
+}
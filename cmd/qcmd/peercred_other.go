@@ -0,0 +1,12 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// peerUID returns errNoPeerCredentials: the standard library cannot read
+// peer credentials here, so the socket's permissions are all that limits
+// who connects.
+func peerUID(conn *net.UnixConn) (int, error) {
+	return 0, errNoPeerCredentials
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/editor"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/plan"
+	"github.com/user/qcmd/internal/safety"
+)
+
+// deliverPlan checks the steps of the plan in answer and prints it, or with
+// exec steps through it, checking each step and asking before running it.
+// It returns the commands that ran, one per line, and the exit code: that
+// of the step that failed, if one did.
+func deliverPlan(answer string, cfg *config.Config, exec, checkSafety, verbose bool) (string, int) {
+	steps, err := plan.Parse(answer)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid plan: %v\n%s\n", err, answer)
+		return "", exitcode.UserError
+	}
+	checker := newChecker(cfg)
+
+	if !exec {
+		dangerous := false
+		if checkSafety {
+			dangerous = checkSteps(os.Stderr, steps, checker, cfg.Safety.Warns)
+		}
+		if err := plan.Write(os.Stdout, steps); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: output error: %v\n", err)
+			return "", exitcode.SystemError
+		}
+		if dangerous && cfg.Safety.BlockDangerous {
+			return "", exitcode.DangerBlocked
+		}
+		return "", exitcode.Success
+	}
+
+	blocked := func(cmd string) bool {
+		return checkSafety && cfg.Safety.BlockDangerous && checker.Check(cmd).Level == safety.Danger
+	}
+	ed := editor.NewEditor(cfg.Editor.Editor)
+	var ran []string
+	for i, s := range steps {
+		fmt.Fprint(os.Stderr, i18n.Sprintf("\nStep %d of %d: %s\n", i+1, len(steps), s.Description))
+		var result safety.CheckResult
+		if checkSafety {
+			result = checker.Check(s.Command)
+			if result.Level == safety.Danger && cfg.Safety.BlockDangerous {
+				fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+				printFinding(result, s.Command)
+				fmt.Println(s.Command)
+				fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: not running step %d or the steps after it\n", i+1))
+				return strings.Join(ran, "\n"), exitcode.DangerBlocked
+			}
+			if result.Level == safety.Danger && cfg.Safety.ShowWarnings && !trustedWarning(cfg, result, s.Command, verbose) {
+				fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+				printFinding(result, s.Command)
+			}
+			if result.Level == safety.Caution && cfg.Safety.Warns(result.Category) && !trustedWarning(cfg, result, s.Command, verbose) {
+				fmt.Fprintln(os.Stderr, i18n.T("Caution: Review this command before executing."))
+				printFinding(result, s.Command)
+			}
+		}
+
+		executed, code := confirmAndRun(s.Command, ed, blocked, os.Stderr)
+		if executed == "" {
+			if code == exitcode.Success {
+				fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: stopped before step %d of %d\n", i+1, len(steps)))
+			}
+			return strings.Join(ran, "\n"), code
+		}
+		ran = append(ran, executed)
+		if executed == s.Command && checkSafety {
+			recordTrust(cfg, result, s.Command, verbose)
+		}
+		if code != exitcode.Success {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("qcmd: step %d failed with exit status %d; not running the rest of the plan\n", i+1, code))
+			return strings.Join(ran, "\n"), code
+		}
+	}
+	return strings.Join(ran, "\n"), exitcode.Success
+}
+
+// checkSteps safety-checks the steps of a plan and warns about them on w,
+// about cautionary ones only in the categories warns accepts. It reports
+// whether any is dangerous.
+func checkSteps(w io.Writer, steps []plan.Step, checker *safety.Checker, warns func(category string) bool) bool {
+	dangerous := false
+	for i, s := range steps {
+		switch result := checker.Check(s.Command); {
+		case result.Level == safety.Danger:
+			dangerous = true
+			fmt.Fprintf(w, "WARNING: step %d: dangerous command (%s): %s\n", i+1, result.Category, result.Description)
+		case result.Level == safety.Caution && warns(result.Category):
+			fmt.Fprint(w, i18n.Sprintf("Caution: step %d: %s: %s\n", i+1, result.Category, i18n.T(result.Description)))
+		}
+	}
+	return dangerous
+}
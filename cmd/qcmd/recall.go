@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/editor"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/index"
+	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/recall"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/shellctx"
+	"github.com/user/qcmd/internal/snippets"
+)
+
+// maxOtherMatches is how many runners-up recall lists after its pick.
+const maxOtherMatches = 3
+
+// recallCandidates returns the commands recall searches: qcmd's history,
+// the saved snippets, and the shell's history if shellHistory is set.
+// Commands rated bad with `qcmd feedback` are left out.
+func recallCandidates(shellHistory bool) ([]recall.Candidate, error) {
+	entries, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	var candidates []recall.Candidate
+	for _, e := range entries {
+		if e.Feedback == history.FeedbackBad {
+			continue
+		}
+		candidates = append(candidates, recall.Candidate{
+			Command: historyCommand(e),
+			Query:   e.Query,
+			Time:    e.Time,
+			Source:  "qcmd history",
+			ID:      index.Item{Kind: index.KindHistory, Key: strconv.Itoa(e.ID)}.ID(),
+		})
+	}
+
+	path, err := dataPath(snippets.FileName)
+	if err != nil {
+		return nil, err
+	}
+	list, err := snippets.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, sn := range list {
+		candidates = append(candidates, recall.Candidate{
+			Command: sn.Command,
+			Query:   sn.Name + " " + sn.Description,
+			Source:  fmt.Sprintf("snippet %q", sn.Name),
+			ID:      index.Item{Kind: index.KindSnippet, Key: sn.Name}.ID(),
+		})
+	}
+
+	if shellHistory {
+		shell := shellctx.GetShellFromPath(os.Getenv("SHELL"))
+		cmds, err := shellctx.LoadShellHistory(shell)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range cmds {
+			candidates = append(candidates, recall.Candidate{Command: c.Command, Time: c.Time, Source: shell + " history"})
+		}
+	}
+	return candidates, nil
+}
+
+// recallCommand delivers the past command that best matches query, like a
+// generated one: safety-checked, then output or run. It reports false if
+// nothing matches, so a new command can be generated instead.
+func recallCommand(f *flags, cfg *config.Config, query string, mode output.Mode) (int, bool) {
+	candidates, err := recallCandidates(f.shellHistory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: recall: %v\n", err)
+		return exitcode.SystemError, true
+	}
+	addSemanticScores(cfg, query, candidates, f.verbosity >= verbosityVerbose)
+	results := recall.Search(query, candidates, time.Now())
+	if len(results) == 0 {
+		return 0, false
+	}
+	best := results[0]
+	command := best.Command
+
+	if f.verbosity > verbosityQuiet {
+		when := ""
+		if !best.Time.IsZero() {
+			when = ", " + best.Time.Format("2006-01-02")
+		}
+		fmt.Fprint(os.Stderr, i18n.Sprintf("Recalled from %s%s", best.Source, when))
+		if best.Query != "" {
+			fmt.Fprintf(os.Stderr, ": %q", best.Query)
+		}
+		fmt.Fprintln(os.Stderr)
+		others := results[1:]
+		if len(others) > maxOtherMatches {
+			others = others[:maxOtherMatches]
+		}
+		for _, r := range others {
+			fmt.Fprint(os.Stderr, i18n.Sprintf("  also: %s\n", r.Command))
+		}
+	}
+
+	// Shell history was never checked, and qcmd's may predate the current
+	// rules.
+	checker := newChecker(cfg)
+	isDangerous := false
+	var result safety.CheckResult
+	if !f.noSafety {
+		result = checker.Check(command)
+		if result.Level == safety.Danger && cfg.Safety.BlockDangerous {
+			isDangerous = true
+			fmt.Fprintln(os.Stderr, "")
+			fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+			printFinding(result, command)
+			fmt.Fprintln(os.Stderr, "")
+		} else if result.Level == safety.Danger && cfg.Safety.ShowWarnings && f.verbosity > verbosityQuiet && !trustedWarning(cfg, result, command, f.verbosity >= verbosityVerbose) {
+			fmt.Fprintln(os.Stderr, "")
+			fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+			printFinding(result, command)
+			fmt.Fprintln(os.Stderr, "")
+		} else if result.Level == safety.Caution && cfg.Safety.Warns(result.Category) && f.verbosity > verbosityQuiet && !trustedWarning(cfg, result, command, f.verbosity >= verbosityVerbose) {
+			fmt.Fprintln(os.Stderr, "")
+			fmt.Fprintln(os.Stderr, i18n.T("Caution: Review this command before executing."))
+			printFinding(result, command)
+			fmt.Fprintln(os.Stderr, "")
+		}
+	}
+
+	if f.exec && !isDangerous {
+		blocked := func(cmd string) bool {
+			return !f.noSafety && cfg.Safety.BlockDangerous && checker.Check(cmd).Level == safety.Danger
+		}
+		executed, code := confirmAndRun(command, editor.NewEditor(cfg.Editor.Editor), blocked, os.Stderr)
+		if executed == command && !f.noSafety {
+			recordTrust(cfg, result, command, f.verbosity >= verbosityVerbose)
+		}
+		return code, true
+	}
+
+	output.SetQuiet(f.verbosity == verbosityQuiet)
+	if err := output.Output(command, mode, isDangerous); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: output error: %v\n", err)
+		return exitcode.SystemError, true
+	}
+	if isDangerous {
+		return exitcode.DangerBlocked, true
+	}
+	return exitcode.Success, true
+}
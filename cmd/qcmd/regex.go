@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/regex"
+)
+
+// stringList is a flag that may be given several times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// deliverRegex tries pattern on the test strings, showing the results on
+// stderr, and outputs it like a command. A regex is not shell code, so it
+// is not safety-checked.
+func deliverRegex(pattern string, flavor regex.Flavor, tests []string, mode output.Mode, quiet bool) int {
+	if len(tests) > 0 {
+		re, err := regex.Compile(pattern, flavor)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: cannot try the regex here: %v\n", err)
+		} else {
+			writeMatches(os.Stderr, regex.Test(re, tests))
+		}
+	}
+
+	output.SetQuiet(quiet)
+	if err := output.Output(pattern, mode, false); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	return exitcode.Success
+}
+
+// writeMatches writes one line per test string to w: whether the regex
+// matched it, and what it matched.
+func writeMatches(w io.Writer, matches []regex.Match) {
+	for _, m := range matches {
+		if !m.Matched {
+			fmt.Fprint(w, i18n.Sprintf("  no match  %q\n", m.Input))
+			continue
+		}
+		line := i18n.Sprintf("  match     %q: %q", m.Input, m.Text)
+		if len(m.Groups) > 0 {
+			groups := make([]string, len(m.Groups))
+			for i, g := range m.Groups {
+				groups[i] = fmt.Sprintf("%q", g)
+			}
+			line += i18n.Sprintf(" (groups: %s)", strings.Join(groups, ", "))
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/sanitize"
+)
+
+// reviewResult is a reviewer's verdict on a generated command.
+type reviewResult struct {
+	model   string
+	verdict string
+	err     error
+
+	// backend and resp describe the call, for usage tracking.
+	backend string
+	resp    *backend.Response
+}
+
+// startReview asks the [review] backend in the background whether command
+// does what query asked for and is safe, with the same shell context as the
+// original request. The returned function waits for the verdict, which
+// takes at most review.timeout_seconds.
+func startReview(cfg *config.Config, query, command string, shellContext *backend.ShellContext) func() reviewResult {
+	name := cfg.Review.Backend
+	if name == "" {
+		name = cfg.Backend
+	}
+	model := cfg.Review.Model
+	if model == "" {
+		model = cfg.GetModel(name)
+	}
+
+	done := make(chan reviewResult, 1)
+	go func() {
+		if exceeded, _, _ := budgetExceeded(cfg, name); exceeded && cfg.Budget.Action == "block" {
+			done <- reviewResult{model: model, err: fmt.Errorf("monthly budget reached")}
+			return
+		}
+		be, err := createBackend(name, cfg, nil)
+		if err != nil {
+			done <- reviewResult{model: model, err: err}
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Review.Timeout())
+		defer cancel()
+		req := &backend.Request{
+			Query:   backend.ReviewQuery(query, command),
+			Context: shellContext,
+			Model:   model,
+			Task:    backend.TaskReview,
+		}
+		applyModelSettings(cfg, req)
+		resp, err := be.GenerateCommand(ctx, req)
+		if err != nil {
+			done <- reviewResult{model: model, err: err}
+			return
+		}
+		done <- reviewResult{model: model, verdict: parseVerdict(resp.Command), backend: name, resp: resp}
+	}()
+	return func() reviewResult { return <-done }
+}
+
+// parseVerdict reduces a reviewer's answer to a single line starting with
+// "OK:" or "CONCERN:". Answers in another format are kept, marked as
+// unclear, so a confused reviewer is not mistaken for an approving one.
+func parseVerdict(answer string) string {
+	line := strings.TrimSpace(sanitize.Sanitize(answer))
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = strings.TrimSpace(line[:i])
+	}
+	for _, prefix := range []string{"OK:", "CONCERN:"} {
+		if len(line) >= len(prefix) && strings.EqualFold(line[:len(prefix)], prefix) {
+			return prefix + " " + strings.TrimSpace(line[len(prefix):])
+		}
+	}
+	return "UNCLEAR: " + line
+}
+
+// writeReview writes the verdict to w as a note. A failed review is only
+// reported when verbose.
+func writeReview(w io.Writer, r reviewResult, verbose bool) {
+	if r.err != nil {
+		if verbose {
+			fmt.Fprintf(w, "qcmd: warning: review failed: %v\n", r.err)
+		}
+		return
+	}
+	fmt.Fprintf(w, "Review (%s): %s\n", r.model, r.verdict)
+}
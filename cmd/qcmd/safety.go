@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/trust"
+)
+
+// safetyCase is one expectation in a `qcmd safety test` cases file.
+type safetyCase struct {
+	Command string `toml:"command"`
+	Level   string `toml:"level"`
+	// Category is optional; when set the result's category must match too.
+	Category string `toml:"category"`
+}
+
+// safetyCaseFile is the layout of a cases file: a list of [[case]] tables.
+type safetyCaseFile struct {
+	Cases []safetyCase `toml:"case"`
+}
+
+// handleSafetyCommand implements `qcmd safety test --file CASES` and
+// `qcmd safety forget [COMMAND]`.
+func handleSafetyCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd safety test --file CASES")
+		fmt.Fprintln(os.Stderr, "       qcmd safety forget [COMMAND]")
+	}
+	if len(args) > 0 && args[0] == "forget" {
+		return handleSafetyForget(args[1:])
+	}
+	if len(args) == 0 || args[0] != "test" {
+		usage()
+		return exitcode.UserError
+	}
+
+	var file string
+	fs := flag.NewFlagSet("qcmd safety test", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&file, "file", "", "TOML file of [[case]] tables with command, level and optional category")
+	fs.Usage = func() {
+		usage()
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if file == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return exitcode.UserError
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
+		return exitcode.UserError
+	}
+
+	var cases safetyCaseFile
+	if _, err := toml.DecodeFile(file, &cases); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: reading cases: %v\n", err)
+		return exitcode.UserError
+	}
+	if len(cases.Cases) == 0 {
+		fmt.Fprintf(os.Stderr, "qcmd: %s contains no [[case]] tables\n", file)
+		return exitcode.UserError
+	}
+
+	failed, err := runSafetyCases(newChecker(cfg), cases.Cases, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %s: %v\n", file, err)
+		return exitcode.UserError
+	}
+	if failed > 0 {
+		return exitcode.UserError
+	}
+	return exitcode.Success
+}
+
+// runSafetyCases checks every case against checker, reports mismatches
+// and a summary to w, and returns the number of failed cases.
+func runSafetyCases(checker *safety.Checker, cases []safetyCase, w io.Writer) (int, error) {
+	failed := 0
+	for i, c := range cases {
+		want, err := safety.ParseLevel(c.Level)
+		if err != nil {
+			return 0, fmt.Errorf("case %d: %w", i+1, err)
+		}
+
+		result := checker.Check(c.Command)
+		if result.Level == want && (c.Category == "" || result.Category == c.Category) {
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(w, "FAIL case %d: %s\n", i+1, c.Command)
+		if c.Category != "" {
+			fmt.Fprintf(w, "  want: %s (%s)\n", want, c.Category)
+		} else {
+			fmt.Fprintf(w, "  want: %s\n", want)
+		}
+		if result.Level == safety.Safe {
+			fmt.Fprintf(w, "  got:  %s\n", result.Level)
+		} else {
+			fmt.Fprintf(w, "  got:  %s (%s, score %d): %s\n", result.Level, result.Category, result.Score, result.Description)
+		}
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d cases failed\n", failed, len(cases))
+	} else {
+		fmt.Fprintf(w, "all %d cases passed\n", len(cases))
+	}
+	return failed, nil
+}
+
+// handleSafetyForget implements `qcmd safety forget [COMMAND]`, which stops
+// trusting COMMAND, or every command, so it is warned about again.
+func handleSafetyForget(args []string) int {
+	fs := flag.NewFlagSet("qcmd safety forget", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd safety forget [COMMAND]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Forgets the cautionary and dangerous commands you ran with --exec, or just")
+		fmt.Fprintln(os.Stderr, "COMMAND, so they are warned about again.")
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+
+	path, err := trustPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	if fs.NArg() > 0 {
+		command := strings.Join(fs.Args(), " ")
+		var forgot bool
+		err := trust.Update(path, func(cache *trust.Cache) bool {
+			forgot = cache.Forget(command)
+			return forgot
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		if !forgot {
+			fmt.Fprintf(os.Stderr, "qcmd: not a trusted command: %s\n", command)
+			return exitcode.UserError
+		}
+		fmt.Fprintln(os.Stderr, "qcmd: forgot 1 trusted command")
+		return exitcode.Success
+	}
+
+	if err := trust.Save(path, &trust.Cache{}); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	fmt.Fprintln(os.Stderr, "qcmd: forgot all trusted commands")
+	return exitcode.Success
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/user/qcmd/internal/safety"
+)
+
+// SARIF 2.1.0 documents, as far as `qcmd check --format sarif` fills them
+// in. See https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string          `json:"ruleId"`
+	Level      string          `json:"level"`
+	Message    sarifMessage    `json:"message"`
+	Locations  []sarifLocation `json:"locations,omitempty"`
+	Properties sarifProperties `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int          `json:"startLine"`
+	Snippet   sarifMessage `json:"snippet"`
+}
+
+// sarifProperties carries what SARIF has no field for.
+type sarifProperties struct {
+	Command string `json:"command"`
+	Segment string `json:"segment,omitempty"`
+	Score   int    `json:"score"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// sarifSchema is the schema SARIF consumers validate against.
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// sarifRuleID is the rule a result is reported under: its category, or
+// "incomplete" for a command that could not be checked fully.
+func sarifRuleID(result safety.CheckResult) string {
+	if result.Category == "" {
+		return "incomplete"
+	}
+	return result.Category
+}
+
+// writeSARIF writes the findings that are not safe as a SARIF log, one
+// rule per category. Findings in file point at their line; a command given
+// as arguments has no location. Messages are in English, for dashboards.
+func writeSARIF(w io.Writer, findings []checkFinding, file string) error {
+	results := []sarifResult{}
+	rules := make(map[string]bool)
+	for _, f := range findings {
+		if f.Result.Level == safety.Safe {
+			continue
+		}
+		level := "warning"
+		if f.Result.Level == safety.Danger {
+			level = "error"
+		}
+		text := f.Result.Description
+		if f.Result.Hint != "" {
+			text += ". " + f.Result.Hint
+		}
+		r := sarifResult{
+			RuleID:  sarifRuleID(f.Result),
+			Level:   level,
+			Message: sarifMessage{Text: text},
+			Properties: sarifProperties{
+				Command: f.Command,
+				Segment: f.Result.Segment,
+				Score:   f.Result.Score,
+				Pattern: f.Result.Pattern,
+			},
+		}
+		if file != "" && file != "-" {
+			r.Locations = []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: filepath.ToSlash(file)},
+				Region:           sarifRegion{StartLine: f.Line, Snippet: sarifMessage{Text: f.Command}},
+			}}}
+		}
+		results = append(results, r)
+		rules[r.RuleID] = true
+	}
+
+	driver := sarifDriver{Name: "qcmd", Version: version, Rules: []sarifRule{}}
+	for id := range rules {
+		description := fmt.Sprintf("Risky %s command", id)
+		if id == "incomplete" {
+			description = "Command that could not be checked fully"
+		}
+		driver.Rules = append(driver.Rules, sarifRule{ID: id, ShortDescription: sarifMessage{Text: description}})
+	}
+	sort.Slice(driver.Rules, func(i, j int) bool { return driver.Rules[i].ID < driver.Rules[j].ID })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{Driver: driver}, Results: results}},
+	})
+}
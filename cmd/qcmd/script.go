@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/editor"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// annotateScript safety-checks every command in script and inserts a
+// warning comment above the line each flagged command starts on; cautionary
+// commands are flagged only in the categories warns accepts. It returns the
+// annotated script and whether any command was dangerous.
+func annotateScript(script string, checker *safety.Checker, warns func(category string) bool) (string, bool) {
+	findings := make(map[int]safety.CheckResult)
+	for _, pipeline := range shellparse.Pipelines(script) {
+		if result := checker.Check(pipeline.Text); result.Level > findings[pipeline.Line].Level {
+			findings[pipeline.Line] = result
+		}
+	}
+
+	dangerous := false
+	var b strings.Builder
+	for i, line := range strings.Split(script, "\n") {
+		result := findings[i+1]
+		switch {
+		case result.Level == safety.Danger:
+			dangerous = true
+			fmt.Fprintf(&b, "# qcmd: DANGER (%s): %s\n", result.Category, result.Description)
+			fmt.Fprintf(os.Stderr, "WARNING: line %d: dangerous command (%s): %s\n", i+1, result.Category, result.Description)
+		case result.Level == safety.Caution && warns(result.Category):
+			fmt.Fprintf(&b, "# qcmd: CAUTION (%s): %s\n", result.Category, result.Description)
+			fmt.Fprint(os.Stderr, i18n.Sprintf("Caution: line %d: %s: %s\n", i+1, result.Category, i18n.T(result.Description)))
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return b.String(), dangerous
+}
+
+// reviewScript writes script to a temp file, opens it in the editor for
+// review and prints the file's path to stdout.
+func reviewScript(script string, cfg *config.Config, checkSafety bool) int {
+	dangerous := false
+	if checkSafety {
+		script, dangerous = annotateScript(script, newChecker(cfg), cfg.Safety.Warns)
+	} else if !strings.HasSuffix(script, "\n") {
+		script += "\n"
+	}
+
+	f, err := os.CreateTemp("", "qcmd-script-*.sh")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: creating script file: %v\n", err)
+		return exitcode.SystemError
+	}
+	path := f.Name()
+	if _, err := f.WriteString(script); err != nil {
+		f.Close()
+		fmt.Fprintf(os.Stderr, "qcmd: writing script: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: writing script: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	if err := editor.NewEditor(cfg.Editor.Editor).Open(context.Background(), path); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: %v\n", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Script saved for review; run it with: sh %s\n", path)
+	fmt.Println(path)
+
+	if dangerous && cfg.Safety.BlockDangerous {
+		return exitcode.DangerBlocked
+	}
+	return exitcode.Success
+}
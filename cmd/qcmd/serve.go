@@ -0,0 +1,777 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/sanitize"
+)
+
+// SocketFileName is the name of the daemon's socket in the runtime
+// directory.
+const SocketFileName = "qcmd.sock"
+
+// configPollInterval is how often `qcmd serve` looks for config changes.
+const configPollInterval = time.Second
+
+// handleServeCommand implements `qcmd serve [--socket PATH] [--listen
+// ADDR [--tls-cert FILE --tls-key FILE]]`, a daemon that answers generate
+// requests over HTTP, so editor integrations need not start qcmd for every
+// request, and a team can share one set of provider keys.
+func handleServeCommand(args []string) int {
+	var socketPath, listenAddr, tlsCert, tlsKey string
+	fs := flag.NewFlagSet("qcmd serve", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&socketPath, "socket", "", "Unix socket to listen on (default qcmd.sock in the runtime directory)")
+	fs.StringVar(&listenAddr, "listen", "", "Also listen on this TCP address, e.g. 127.0.0.1:7788; needs serve.token")
+	fs.StringVar(&tlsCert, "tls-cert", "", "Serve HTTPS on --listen with this PEM certificate")
+	fs.StringVar(&tlsKey, "tls-key", "", "PEM private key for --tls-cert")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd serve [--socket PATH] [--listen ADDR [--tls-cert FILE --tls-key FILE]]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if fs.NArg() != 0 || (tlsCert == "") != (tlsKey == "") || tlsCert != "" && listenAddr == "" {
+		fs.Usage()
+		return exitcode.UserError
+	}
+	var tlsConfig *tls.Config
+	if tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: loading TLS certificate: %v\n", err)
+			return exitcode.UserError
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	s, err := newServer(nil, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.UserError
+	}
+	if socketPath == "" {
+		if socketPath, err = runtimePath(SocketFileName); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// Once draining, a second interrupt exits at once.
+		<-ctx.Done()
+		stop()
+	}()
+
+	// The socket admits only this user; the TCP listener, which anyone who
+	// can reach it may connect to, needs the token.
+	ln, err := listenSocket(socketPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	defer os.Remove(socketPath)
+	servers := []servedListener{{
+		ln:  &peerListener{Listener: ln, uid: os.Geteuid(), logf: s.logf},
+		srv: &http.Server{Handler: s.handler()},
+	}}
+	if listenAddr != "" {
+		// Other users of this machine can reach loopback too.
+		if s.state.Load().cfg.Serve.Token == "" {
+			ln.Close()
+			fmt.Fprintf(os.Stderr, "qcmd: refusing to listen on %s without serve.token; set one or use the socket\n", listenAddr)
+			return exitcode.UserError
+		}
+		tcp, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			ln.Close()
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		if tlsConfig != nil {
+			tcp = tls.NewListener(tcp, tlsConfig)
+		}
+		servers = append(servers, servedListener{
+			ln:  tcp,
+			srv: &http.Server{Handler: s.requireToken(s.handler())},
+		})
+	}
+
+	// SIGHUP reloads at once, as daemons do.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	if err := s.run(ctx, servers, hup); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	return exitcode.Success
+}
+
+// run serves on servers until ctx is done or one of them fails, reloading
+// the configuration when it changes or on a signal from reload. It then
+// stops accepting requests and waits for those in flight, for up to the
+// configured timeout, before cancelling the rest. Every goroutine it
+// starts has finished when it returns.
+func (s *server) run(ctx context.Context, servers []servedListener, reload <-chan os.Signal) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.watchConfig(ctx, configPollInterval)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-reload:
+				s.reloadAndLog()
+			}
+		}
+	}()
+
+	errs := make(chan error, len(servers))
+	for _, sl := range servers {
+		s.logf("listening on %s", sl.ln.Addr())
+		wg.Add(1)
+		go func(sl servedListener) {
+			defer wg.Done()
+			if err := sl.srv.Serve(sl.ln); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(sl)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-errs:
+	}
+	cancel()
+
+	// A generation cannot outlast the timeout, so that bounds the wait.
+	drain := s.state.Load().cfg.Timeout()
+	s.logf("shutting down, waiting up to %s for %d request(s) in flight", drain, s.limit.Active())
+	s.limit.Close()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), drain)
+	defer cancelShutdown()
+	for _, sl := range servers {
+		if shutdownErr := sl.srv.Shutdown(shutdownCtx); shutdownErr != nil {
+			s.logf("gave up waiting, cancelling the remaining requests")
+			sl.srv.Close()
+		}
+	}
+	wg.Wait()
+	return err
+}
+
+// servedListener is a listener and the server answering on it.
+type servedListener struct {
+	ln  net.Listener
+	srv *http.Server
+}
+
+// requireToken wraps h so requests must carry the configured serve.token
+// as a bearer token. If a reload removed the token, requests are refused.
+// The current token is used, so a reload rotates it.
+func (s *server) requireToken(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := s.state.Load().cfg.Serve.Token
+		if token == "" {
+			writeJSON(w, http.StatusUnauthorized, generateResponse{Error: "serve.token is not set"})
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, generateResponse{Error: "missing or wrong token"})
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// errNoPeerCredentials is returned by peerUID on systems where it cannot
+// tell who is connecting.
+var errNoPeerCredentials = errors.New("peer credentials not supported on this system")
+
+// peerListener accepts only Unix socket connections from processes of the
+// user uid, where the system can tell, closing others. A local API that
+// hands out shell commands must not answer other users.
+type peerListener struct {
+	net.Listener
+	uid  int
+	logf func(format string, args ...any)
+}
+
+// Accept implements net.Listener.
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		unixConn, ok := conn.(*net.UnixConn)
+		if !ok {
+			return conn, nil
+		}
+		uid, err := peerUID(unixConn)
+		switch {
+		case errors.Is(err, errNoPeerCredentials), err == nil && uid == l.uid:
+			return conn, nil
+		case err != nil:
+			l.logf("refused a connection: %v", err)
+		default:
+			l.logf("refused a connection from uid %d", uid)
+		}
+		conn.Close()
+	}
+}
+
+// listenSocket listens on the Unix socket at path. A socket left behind by
+// a daemon that did not exit cleanly is replaced; one that still answers
+// is not.
+func listenSocket(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("qcmd serve is already running on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("removing stale socket: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating socket directory: %w", err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// Connecting takes write permission, so this keeps other users out.
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("restricting socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// serveState is the configuration requests to the daemon are served with.
+// A reload replaces it as a whole, so a request sees one configuration
+// throughout, even if the file changes meanwhile.
+type serveState struct {
+	cfg     *config.Config
+	checker *safety.Checker
+}
+
+// server answers generate requests for `qcmd serve`.
+type server struct {
+	opts    *config.LoadOptions
+	state   atomic.Pointer[serveState]
+	limit   *limiter
+	metrics *serveMetrics
+
+	logMu sync.Mutex
+	log   io.Writer
+}
+
+// newServer returns a server with the configuration opts selects, logging
+// to log.
+func newServer(opts *config.LoadOptions, log io.Writer) (*server, error) {
+	s := &server{opts: opts, log: log, limit: newLimiter(), metrics: newServeMetrics()}
+	state, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	s.state.Store(state)
+	return s, nil
+}
+
+// load reads and validates the configuration.
+func (s *server) load() (*serveState, error) {
+	cfg, err := config.Load(s.opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &serveState{cfg: cfg, checker: newChecker(cfg)}, nil
+}
+
+// reload loads the configuration again and switches to it, returning the
+// settings that changed. An invalid configuration is an error and leaves
+// the current one in place.
+func (s *server) reload() ([]string, error) {
+	next, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	prev := s.state.Swap(next)
+	return config.Changes(prev.cfg, next.cfg)
+}
+
+// reloadAndLog reloads the configuration and logs the outcome.
+func (s *server) reloadAndLog() {
+	changed, err := s.reload()
+	s.metrics.reloaded(err)
+	switch {
+	case err != nil:
+		s.logf("config not reloaded, keeping the current one: %v", err)
+	case len(changed) == 0:
+		s.logf("config reloaded, nothing changed")
+	default:
+		s.logf("config reloaded, changed: %s", strings.Join(changed, ", "))
+	}
+}
+
+// watchConfig reloads the configuration whenever the config file or the
+// organization policy changes, checking every interval until ctx is done.
+// Polling, rather than file notifications, also catches editors that save
+// by replacing the file.
+func (s *server) watchConfig(ctx context.Context, interval time.Duration) {
+	paths := []string{config.Path(s.opts), config.OrgPolicyPath}
+	stamps := fileStamps(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if now := fileStamps(paths); now != stamps {
+				stamps = now
+				s.reloadAndLog()
+			}
+		}
+	}
+}
+
+// fileStamps returns the size and modification time of each of paths, so
+// a change to any of them changes the result.
+func fileStamps(paths []string) string {
+	var b strings.Builder
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%s %d %d\n", path, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// logf writes a line to the server log.
+func (s *server) logf(format string, args ...any) {
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	fmt.Fprintf(s.log, "qcmd serve: %s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// generateRequest is the body of POST /v1/generate.
+type generateRequest struct {
+	Query string `json:"query"`
+	// Partial, if set, is the command line typed so far, which the
+	// command completes; Query then says what it should do, and may be
+	// empty.
+	Partial string `json:"partial,omitempty"`
+	// WorkingDir and Shell describe the client's shell, for the prompt.
+	WorkingDir string `json:"cwd,omitempty"`
+	Shell      string `json:"shell,omitempty"`
+}
+
+// generateResponse is the answer to POST /v1/generate. A command the
+// safety check blocks is left out, with the reason in Error.
+type generateResponse struct {
+	Command string       `json:"command,omitempty"`
+	Backend string       `json:"backend,omitempty"`
+	Model   string       `json:"model,omitempty"`
+	Safety  *serveSafety `json:"safety,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	// Reason is the code the model gave with the error sentinel, such as
+	// "ambiguous" when the query lacks a detail Error asks for.
+	Reason string `json:"reason,omitempty"`
+}
+
+// serveSafety is the safety rating of a generated command.
+type serveSafety struct {
+	Level       string `json:"level"`
+	Score       int    `json:"score"`
+	ReadOnly    bool   `json:"read_only"`
+	Category    string `json:"category,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// UserHeader is the request header in which clients of a shared daemon
+// say who they are, so usage can be attributed to them. It is taken on
+// trust: anyone holding the token may claim any name.
+const UserHeader = backend.RemoteUserHeader
+
+// validUser reports whether user is acceptable in UserHeader: empty, or a
+// short name of letters, digits and ".-_@".
+func validUser(user string) bool {
+	if len(user) > 64 {
+		return false
+	}
+	for _, r := range user {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(".-_@", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// handler returns the daemon's HTTP API.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok", "version": version})
+	})
+	mux.HandleFunc("/v1/generate", func(w http.ResponseWriter, r *http.Request) {
+		var req generateRequest
+		user, ok := decodeRequest(w, r, &req, 1<<20)
+		if !ok {
+			return
+		}
+		start := time.Now()
+		status, resp := s.generate(r.Context(), req, user)
+		level := ""
+		if resp.Safety != nil {
+			level = resp.Safety.Level
+		}
+		s.metrics.observe(resp.Backend, status, time.Since(start), level)
+		writeJSON(w, status, resp)
+	})
+	mux.HandleFunc(backend.RemotePath, func(w http.ResponseWriter, r *http.Request) {
+		// Prompts with context and examples run larger than queries.
+		var req backend.RemoteRequest
+		user, ok := decodeRequest(w, r, &req, 4<<20)
+		if !ok {
+			return
+		}
+		start := time.Now()
+		status, resp := s.complete(r.Context(), req, user)
+		s.metrics.observe(resp.Backend, status, time.Since(start), "")
+		writeJSON(w, status, resp)
+	})
+	mux.Handle("/metrics", s.metrics.handler(s.limit.Active))
+	return mux
+}
+
+// decodeRequest reads the JSON body of the POST request r, of at most
+// limit bytes once decompressed, into v and returns the user it is made
+// for. If the request is unacceptable, it answers it and returns false.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any, limit int64) (string, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, generateResponse{Error: "use POST"})
+		return "", false
+	}
+	user := r.Header.Get(UserHeader)
+	if !validUser(user) {
+		writeJSON(w, http.StatusBadRequest, generateResponse{Error: "invalid " + UserHeader + " header"})
+		return "", false
+	}
+	var body io.Reader = r.Body
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "", "identity":
+	case "gzip":
+		// Clients with compress_requests_over set send this.
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, generateResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+			return "", false
+		}
+		body = gz
+	default:
+		writeJSON(w, http.StatusUnsupportedMediaType, generateResponse{Error: "unsupported Content-Encoding " + encoding})
+		return "", false
+	}
+	if err := json.NewDecoder(io.LimitReader(body, limit)).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, generateResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return "", false
+	}
+	return user, true
+}
+
+// writeJSON writes v as the JSON response with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// generate answers one generate request, made for user, with the current
+// configuration, returning the HTTP status to answer with.
+func (s *server) generate(ctx context.Context, in generateRequest, user string) (int, generateResponse) {
+	state := s.state.Load()
+	cfg := state.cfg
+
+	query, partial := strings.TrimSpace(in.Query), strings.TrimSpace(in.Partial)
+	task := backend.TaskCommand
+	if partial != "" {
+		task = backend.TaskComplete
+	}
+	if err := validateInput(partial+"\n"+query, cfg.Advanced.MaxQueryLength); err != nil {
+		return http.StatusBadRequest, generateResponse{Error: err.Error()}
+	}
+
+	var shellContext *backend.ShellContext
+	if cfg.IncludeContext {
+		shellContext = &backend.ShellContext{WorkingDir: in.WorkingDir, Shell: in.Shell, OS: runtime.GOOS}
+	}
+	var examples []backend.Example
+	if task == backend.TaskCommand {
+		examples, _ = loadExamples(cfg.Context.MaxExamples)
+	}
+	req := &backend.Request{
+		Query:      query,
+		Partial:    partial,
+		Context:    shellContext,
+		Examples:   examples,
+		Task:       task,
+		Structured: cfg.Advanced.StructuredOutput && task == backend.TaskCommand,
+	}
+	if _, err := backend.FitBudget(req, cfg.Context.TokenBudget); err != nil {
+		return http.StatusInternalServerError, generateResponse{Error: fmt.Sprintf("building prompt: %v", err)}
+	}
+
+	// The timeout covers waiting for a slot as well as the generation, so
+	// clients are answered within it either way.
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout())
+	defer cancel()
+	backendName, resp, status, err := s.call(ctx, cfg, req, user)
+	if err != nil {
+		return status, generateResponse{Backend: backendName, Error: err.Error()}
+	}
+
+	command := sanitize.Sanitize(resp.Command)
+	if refused, _ := sanitize.CheckRefusal(command); refused && cfg.Advanced.RetryRefusals {
+		if name, retryResp, _, err := s.call(ctx, cfg, withRefusalReminder(req), user); err == nil {
+			backendName, resp = name, retryResp
+			command = sanitize.Sanitize(resp.Command)
+		}
+	}
+
+	out := generateResponse{Backend: backendName, Model: resp.Model}
+	if strings.TrimSpace(command) == "" {
+		out.Error = "LLM returned empty response"
+		return http.StatusBadGateway, out
+	}
+	if sentinel, ok := sanitize.ParseErrorSentinel(command); ok {
+		out.Error = "LLM could not generate command: " + sentinel.Message
+		out.Reason = sentinel.Code
+		return http.StatusUnprocessableEntity, out
+	}
+	if refused, reason := sanitize.CheckRefusal(command); refused {
+		out.Error = "LLM declined the request: " + reason
+		return http.StatusUnprocessableEntity, out
+	}
+
+	result := state.checker.Check(command)
+	out.Safety = &serveSafety{Level: result.Level.String(), Score: result.Score, ReadOnly: result.ReadOnly, Category: result.Category, Description: result.Description}
+	if result.Level == safety.Danger && cfg.Safety.BlockDangerous {
+		out.Error = "dangerous command blocked: " + result.Description
+		return http.StatusUnprocessableEntity, out
+	}
+	out.Command = command
+
+	if cfg.History.Enabled {
+		entry := history.Entry{Query: query, Command: command, Backend: backendName, Model: resp.Model}
+		if err := recordHistory(entry); err != nil {
+			s.logf("recording history: %v", err)
+		}
+	}
+	return http.StatusOK, out
+}
+
+// complete answers a request from another qcmd's remote backend, made for
+// user, by passing it to this daemon's backend. The client builds the
+// prompt and checks the command itself, so neither happens here.
+func (s *server) complete(ctx context.Context, in backend.RemoteRequest, user string) (int, backend.RemoteResponse) {
+	cfg := s.state.Load().cfg
+	req := in.Request()
+	if err := validateInput(strings.TrimSpace(req.Query), cfg.Advanced.MaxQueryLength); err != nil {
+		return http.StatusBadRequest, backend.RemoteResponse{Error: err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout())
+	defer cancel()
+	backendName, resp, status, err := s.call(ctx, cfg, req, user)
+	if err != nil {
+		return status, backend.RemoteResponse{Backend: backendName, Error: err.Error()}
+	}
+	return http.StatusOK, backend.NewRemoteResponse(backendName, resp)
+}
+
+// call sends req, made for user, to the configured backend, or a fallback
+// if it keeps failing, once a slot is free. It records the outcome for the
+// circuit breaker and the usage file. On failure it returns the HTTP
+// status to answer with: backend errors become 502, 504 or, for rate
+// limits, 429.
+func (s *server) call(ctx context.Context, cfg *config.Config, req *backend.Request, user string) (string, *backend.Response, int, error) {
+	if err := s.limit.Acquire(ctx, cfg.Serve.MaxConcurrent); err != nil {
+		if errors.Is(err, errShuttingDown) {
+			return "", nil, http.StatusServiceUnavailable, err
+		}
+		return "", nil, http.StatusServiceUnavailable, fmt.Errorf("too many requests in flight, try again later (%w)", err)
+	}
+	defer s.limit.Release()
+
+	backendName, err := selectBackend(cfg, &flags{verbosity: verbosityQuiet}, cfg.Backend)
+	if err != nil {
+		return "", nil, http.StatusServiceUnavailable, err
+	}
+	if exceeded, _, _ := budgetExceeded(cfg, backendName); exceeded && cfg.Budget.Action == "block" {
+		return backendName, nil, http.StatusPaymentRequired, errors.New("monthly budget reached")
+	}
+	be, err := createBackend(backendName, cfg, nil)
+	if err != nil {
+		return backendName, nil, http.StatusInternalServerError, err
+	}
+	if req.Model == "" {
+		// The gateway chose the model, so its settings for it apply;
+		// clients apply their own when they name one.
+		req.Model = cfg.GetModel(backendName)
+		applyModelSettings(cfg, req)
+	}
+
+	req, resp, err := generateFitting(ctx, cfg, be, req, func(format string, args ...any) {
+		s.logf("%s: "+format, append([]any{backendName}, args...)...)
+	})
+	if errors.Is(err, context.Canceled) {
+		// The client went away or the daemon is stopping; neither says
+		// anything about the backend.
+		s.logf("%s: request cancelled", backendName)
+		return backendName, nil, http.StatusServiceUnavailable, errors.New("request cancelled")
+	}
+	recordHealth(cfg, backendName, err, false)
+	if err != nil {
+		s.logf("%s: %v", backendName, err)
+		status := http.StatusBadGateway
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusGatewayTimeout
+		case errors.Is(err, backend.ErrRateLimited):
+			status = http.StatusTooManyRequests
+		case errors.Is(err, backend.ErrContextTooLong):
+			// Remote backends map this back to ErrContextTooLong.
+			status = http.StatusRequestEntityTooLarge
+		}
+		return backendName, nil, status, err
+	}
+	if err := recordUsageFor(cfg, backendName, user, resp); err != nil {
+		s.logf("recording usage: %v", err)
+	}
+	return backendName, resp, http.StatusOK, nil
+}
+
+// errShuttingDown is returned by limiter.Acquire once the daemon is
+// stopping.
+var errShuttingDown = errors.New("qcmd serve is shutting down")
+
+// limiter bounds how many generations run at once. The bound is passed to
+// each Acquire rather than fixed, so a config reload changes it for new
+// requests while those running finish.
+type limiter struct {
+	mu     sync.Mutex
+	active int
+	// freed is closed, and replaced, whenever a slot is released.
+	freed  chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// newLimiter returns a limiter with no slots taken.
+func newLimiter() *limiter {
+	return &limiter{freed: make(chan struct{}), closed: make(chan struct{})}
+}
+
+// Acquire takes a slot, waiting while max are taken. max 0 means no limit.
+// It gives up when ctx is done or the limiter is closed.
+func (l *limiter) Acquire(ctx context.Context, max int) error {
+	for {
+		l.mu.Lock()
+		select {
+		case <-l.closed:
+			l.mu.Unlock()
+			return errShuttingDown
+		default:
+		}
+		if max == 0 || l.active < max {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		freed := l.freed
+		l.mu.Unlock()
+
+		select {
+		case <-freed:
+		case <-l.closed:
+			return errShuttingDown
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Release gives back a slot taken by Acquire.
+func (l *limiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	close(l.freed)
+	l.freed = make(chan struct{})
+}
+
+// Active returns how many slots are taken.
+func (l *limiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+// Close makes waiting and future calls to Acquire fail, for shutting down.
+// Slots already taken are unaffected.
+func (l *limiter) Close() {
+	l.once.Do(func() { close(l.closed) })
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/safety"
+)
+
+// widgetEnv is set by the shell widgets when they run qcmd, so
+// --output=auto-smart can tell it is feeding a line editor.
+const widgetEnv = "QCMD_WIDGET"
+
+// fromWidget reports whether qcmd was run by a shell widget.
+func fromWidget() bool {
+	return os.Getenv(widgetEnv) != ""
+}
+
+// execTasks are the tasks whose answer is a command auto-smart may offer
+// to run. Crontab lines, scripts, unit files and the like are printed.
+var execTasks = map[backend.Task]bool{
+	backend.TaskCommand: true, backend.TaskComment: true,
+	backend.TaskFix: true, backend.TaskComplete: true,
+}
+
+// smartMode resolves output.ModeAutoSmart for one command of task. A
+// widget gets ModeZLE. A safe, read-only command is offered to run (exec
+// is true) when the user is at a terminal to confirm it; anything else is
+// printed. reason says why, for --verbose. result is ignored when checked
+// is false.
+func smartMode(widget bool, task backend.Task, checked bool, result safety.CheckResult, interactive bool) (mode output.Mode, exec bool, reason string) {
+	switch {
+	case widget:
+		return output.ModeZLE, false, "run by a shell widget"
+	case !execTasks[task]:
+		return output.ModePrint, false, "not a command to run"
+	case !checked:
+		return output.ModePrint, false, "safety check skipped"
+	case !result.ReadOnly:
+		return output.ModePrint, false, "command may change something"
+	case result.Level != safety.Safe || result.Score > 0:
+		return output.ModePrint, false, "the safety check matched a pattern"
+	case !interactive:
+		return output.ModePrint, false, "read-only, but not at a terminal"
+	default:
+		return output.ModePrint, true, "read-only command"
+	}
+}
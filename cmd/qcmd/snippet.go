@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/snippets"
+)
+
+// errNoSnippet is returned when `qcmd snippet rm` names no snippet.
+var errNoSnippet = errors.New("no such snippet")
+
+// handleSnippetCommand implements `qcmd snippet list|show|add|rm`.
+func handleSnippetCommand(args []string) int {
+	path, err := dataPath(snippets.FileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	list, err := snippets.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	sub := "list"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	switch sub {
+	case "list":
+		printSnippets(os.Stdout, list)
+		return exitcode.Success
+
+	case "show":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: qcmd snippet show NAME")
+			return exitcode.UserError
+		}
+		s, ok := snippets.Find(list, args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "qcmd: no snippet named %q\n", args[0])
+			return exitcode.UserError
+		}
+		fmt.Println(s.Command)
+		return exitcode.Success
+
+	case "add":
+		s, err := parseSnippetAdd(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.UserError
+		}
+		err = snippets.Update(path, func(list []snippets.Snippet) ([]snippets.Snippet, error) {
+			return snippets.Put(list, s), nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		fmt.Fprintf(os.Stderr, "Saved snippet %q\n", s.Name)
+		return exitcode.Success
+
+	case "rm":
+		if len(args) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: qcmd snippet rm NAME")
+			return exitcode.UserError
+		}
+		err := snippets.Update(path, func(list []snippets.Snippet) ([]snippets.Snippet, error) {
+			list, ok := snippets.Remove(list, args[0])
+			if !ok {
+				return nil, errNoSnippet
+			}
+			return list, nil
+		})
+		if errors.Is(err, errNoSnippet) {
+			fmt.Fprintf(os.Stderr, "qcmd: no snippet named %q\n", args[0])
+			return exitcode.UserError
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		fmt.Fprintf(os.Stderr, "Removed snippet %q\n", args[0])
+		return exitcode.Success
+
+	default:
+		fmt.Fprintf(os.Stderr, "qcmd: unknown snippet command %q (must be list, show, add, or rm)\n", sub)
+		return exitcode.UserError
+	}
+}
+
+// parseSnippetAdd parses `qcmd snippet add [--favorite] [--desc TEXT] NAME COMMAND...`.
+func parseSnippetAdd(args []string) (snippets.Snippet, error) {
+	var s snippets.Snippet
+	fs := flag.NewFlagSet("qcmd snippet add", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&s.Description, "desc", "", "Short description")
+	fs.BoolVar(&s.Favorite, "favorite", false, "Mark the snippet as a favorite")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd snippet add [--favorite] [--desc TEXT] NAME COMMAND...")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return s, err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return s, fmt.Errorf("snippet add requires a name and a command")
+	}
+
+	s.Name = fs.Arg(0)
+	s.Command = strings.Join(fs.Args()[1:], " ")
+	return s, nil
+}
+
+// printSnippets writes one line per snippet, favorites marked with '*'.
+func printSnippets(w io.Writer, list []snippets.Snippet) {
+	for _, s := range list {
+		mark := " "
+		if s.Favorite {
+			mark = "*"
+		}
+		line := fmt.Sprintf("%s %-20s %s", mark, s.Name, s.Command)
+		if s.Description != "" {
+			line += "  # " + s.Description
+		}
+		fmt.Fprintln(w, line)
+	}
+}
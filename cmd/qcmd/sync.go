@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/gitsync"
+	"github.com/user/qcmd/internal/snippets"
+	"github.com/user/qcmd/internal/xdg"
+)
+
+// handleSyncCommand implements `qcmd sync push|pull`.
+func handleSyncCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "push" && args[0] != "pull") {
+		fmt.Fprintln(os.Stderr, "usage: qcmd sync push|pull")
+		return exitcode.UserError
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
+		return exitcode.UserError
+	}
+
+	dataDir, err := xdg.DataDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	local, err := dataPath(snippets.FileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+
+	repo := &gitsync.Repo{
+		Dir:    filepath.Join(dataDir, "sync"),
+		Remote: cfg.Sync.Remote,
+		Branch: cfg.Sync.Branch,
+	}
+
+	var n int
+	if args[0] == "push" {
+		n, err = repo.Push(context.Background(), local)
+	} else {
+		n, err = repo.Pull(context.Background(), local)
+	}
+	if err != nil {
+		if errors.Is(err, gitsync.ErrNoRemote) {
+			fmt.Fprintln(os.Stderr, "qcmd: no sync remote configured")
+			fmt.Fprintln(os.Stderr, "  Set remote in the [sync] section of your config")
+			return exitcode.UserError
+		}
+		fmt.Fprintf(os.Stderr, "qcmd: sync %s failed: %v\n", args[0], err)
+		return exitcode.SystemError
+	}
+
+	fmt.Fprintf(os.Stderr, "Synced %d shared snippets with %s\n", n, cfg.Sync.Remote)
+	return exitcode.Success
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/output"
+	"github.com/user/qcmd/internal/sanitize"
+	"github.com/user/qcmd/internal/shellctx"
+)
+
+// helpCheckRequest returns a copy of req that asks the model to check
+// command against the local help of the program it runs, and that
+// program. It returns nil if there is no help to check against.
+func helpCheckRequest(req *backend.Request, command string, limit, tokenBudget int) (*backend.Request, string, error) {
+	tool := shellctx.MainTool(command)
+	if tool == "" || req.Context == nil {
+		return nil, tool, nil
+	}
+	section, ok := shellctx.ToolHelpSection(tool, limit)
+	if !ok {
+		return nil, tool, nil
+	}
+
+	check := *req
+	shellContext := *req.Context
+	shellContext.Sections = append(append([]backend.ContextSection(nil), req.Context.Sections...), section)
+	check.Context = &shellContext
+	check.AppendPrompt = strings.TrimSpace(req.AppendPrompt + "\n\n" + backend.HelpCheckPrompt(tool, command))
+	if _, err := backend.FitBudget(&check, tokenBudget); err != nil {
+		return nil, tool, err
+	}
+	return &check, tool, nil
+}
+
+// checkFlags asks the backend again with the local help of the program
+// command runs, so its options match the installed version, and returns
+// the revised command and response. If there is no help, or the second
+// request fails or is unusable, command and resp are returned unchanged.
+func checkFlags(cfg *config.Config, f *flags, be backend.Backend, backendName string, req *backend.Request, resp *backend.Response, command string, progress bool) (string, *backend.Response) {
+	verbose := f.verbosity >= verbosityVerbose
+	check, tool, err := helpCheckRequest(req, command, cfg.Context.HelpLimit, cfg.Context.TokenBudget)
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: not checking flags: %v\n", err)
+		}
+		return command, resp
+	}
+	if check == nil {
+		if verbose && tool != "" {
+			fmt.Fprintf(os.Stderr, "qcmd: no local help found for %s\n", tool)
+		}
+		return command, resp
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+	defer cancel()
+	spinner := output.NewSpinner(os.Stderr, "Checking flags...")
+	if progress {
+		spinner.Start()
+	}
+	revised, err := be.GenerateCommand(ctx, check)
+	spinner.Stop()
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: checking flags against the help for %s: %v\n", tool, err)
+		}
+		return command, resp
+	}
+	if err := recordUsage(cfg, backendName, revised); err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: recording usage: %v\n", err)
+	}
+
+	revisedCommand := sanitize.Sanitize(revised.Command)
+	if isError, _ := sanitize.CheckErrorSentinel(revisedCommand); isError || strings.TrimSpace(revisedCommand) == "" {
+		return command, resp
+	}
+	if verbose {
+		if revisedCommand != command {
+			fmt.Fprintf(os.Stderr, "qcmd: revised using the local help for %s (was: %s)\n", tool, command)
+		} else {
+			fmt.Fprintf(os.Stderr, "qcmd: flags checked against the local help for %s\n", tool)
+		}
+	}
+	return revisedCommand, revised
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/trust"
+)
+
+// trustPath returns the location of the trust cache in the state
+// directory. A cache left in the data directory that cannot be moved is
+// left behind: its commands are only warned about again.
+func trustPath() (string, error) {
+	return movedStatePath(trust.FileName)
+}
+
+// trusts reports whether a warning at result's level can be trusted away:
+// caution warnings, and danger warnings when dangerous commands are not
+// blocked. Blocked commands are never run as warned, so never trusted.
+func trusts(cfg *config.Config, result safety.CheckResult) bool {
+	if cfg.Safety.TrustSeconds == 0 {
+		return false
+	}
+	switch result.Level {
+	case safety.Caution:
+		return true
+	case safety.Danger:
+		return !cfg.Safety.BlockDangerous
+	}
+	return false
+}
+
+// trustedWarning reports whether the warning about command can be left out
+// because the user ran the identical command recently. A cache that cannot
+// be read trusts nothing.
+func trustedWarning(cfg *config.Config, result safety.CheckResult, command string, verbose bool) bool {
+	if !trusts(cfg, result) {
+		return false
+	}
+	path, err := trustPath()
+	var cache *trust.Cache
+	if err == nil {
+		cache, err = trust.Load(path)
+	}
+	if err != nil {
+		if verbose {
+			fmt.Fprintf(os.Stderr, "qcmd: warning: reading trusted commands: %v\n", err)
+		}
+		return false
+	}
+	ok, until := cache.Trusted(command, time.Now())
+	if ok && verbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning not shown: you ran this command before; trusted until %s (qcmd safety forget)\n", until.Format("2006-01-02 15:04"))
+	}
+	return ok
+}
+
+// recordTrust trusts command, which the user ran despite a warning, for
+// safety.trust_seconds.
+func recordTrust(cfg *config.Config, result safety.CheckResult, command string, verbose bool) {
+	if !trusts(cfg, result) {
+		return
+	}
+	path, err := trustPath()
+	if err == nil {
+		err = trust.Update(path, func(cache *trust.Cache) bool {
+			cache.Add(command, time.Now(), time.Duration(cfg.Safety.TrustSeconds)*time.Second)
+			return true
+		})
+	}
+	if err != nil && verbose {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: recording trusted command: %v\n", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/sanitize"
+	"github.com/user/qcmd/internal/undo"
+)
+
+// undoResult is a suggested command reversing a generated one.
+type undoResult struct {
+	// command is the suggestion; empty when there is none.
+	command string
+	err     error
+
+	// resp describes the call, for usage tracking; nil when the local
+	// rules knew the answer.
+	resp *backend.Response
+}
+
+// startUndo looks for a command reversing command: from the local rules,
+// or else by asking be in the background, with req's model and shell
+// context. The returned function waits for the answer, which takes at
+// most advanced.timeout_seconds.
+func startUndo(cfg *config.Config, be backend.Backend, req *backend.Request, command string) func() undoResult {
+	done := make(chan undoResult, 1)
+	if inverse, ok := undo.Inverse(command); ok {
+		done <- undoResult{command: inverse}
+		return func() undoResult { return <-done }
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout())
+		defer cancel()
+		undoReq := &backend.Request{
+			Query:   backend.UndoQuery(command),
+			Context: req.Context,
+			Model:   req.Model,
+			Task:    backend.TaskUndo,
+		}
+		applyModelSettings(cfg, undoReq)
+		resp, err := be.GenerateCommand(ctx, undoReq)
+		if err != nil {
+			done <- undoResult{err: err}
+			return
+		}
+		// An irreversible command is answered with the error sentinel.
+		inverse := sanitize.Sanitize(resp.Command)
+		if isError, _ := sanitize.CheckErrorSentinel(inverse); isError {
+			inverse = ""
+		}
+		done <- undoResult{command: inverse, resp: resp}
+	}()
+	return func() undoResult { return <-done }
+}
+
+// handleUndoCommand implements `qcmd undo`, which prints the suggested undo
+// of the most recent command that has one. Nothing is run.
+func handleUndoCommand(args []string) int {
+	fs := flag.NewFlagSet("qcmd undo", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd undo")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Prints a best-effort command reversing the last generated command that")
+		fmt.Fprintln(os.Stderr, "changed something. Requires history.suggest_undo = true.")
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitcode.UserError
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
+		return exitcode.UserError
+	}
+
+	entries, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	var entry *history.Entry
+	for i := len(entries) - 1; i >= 0 && entry == nil; i-- {
+		if entries[i].Undo != "" {
+			entry = &entries[i]
+		}
+	}
+	if entry == nil {
+		fmt.Fprintln(os.Stderr, "qcmd: no undo suggestion in the history (set history.suggest_undo = true)")
+		return exitcode.UserError
+	}
+
+	fmt.Fprintf(os.Stderr, "Best-effort undo for #%d: %s\n", entry.ID, entry.Command)
+	fmt.Fprintln(os.Stderr, "It cannot restore what the command overwrote or deleted; check it before running it.")
+	result := newChecker(cfg).Check(entry.Undo)
+	if result.Level != safety.Safe {
+		writeCheckResult(os.Stderr, result, entry.Undo, false)
+	}
+	fmt.Println(entry.Undo)
+	if result.Level == safety.Danger {
+		return exitcode.DangerBlocked
+	}
+	return exitcode.Success
+}
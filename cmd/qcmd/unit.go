@@ -0,0 +1,137 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/systemd"
+)
+
+// deliverUnits checks the unit files in answer and prints them, or writes
+// them to dir if it is set. The commands they run are safety-checked like
+// generated commands.
+func deliverUnits(answer string, cfg *config.Config, dir string, checkSafety bool) int {
+	units, err := systemd.Split(answer)
+	if err == nil {
+		for _, u := range units {
+			if err = systemd.Check(u); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid unit file: %v\n%s\n", err, answer)
+		return exitcode.UserError
+	}
+
+	dangerous := false
+	if checkSafety {
+		dangerous = checkUnits(os.Stderr, units, newChecker(cfg), cfg.Safety.Warns)
+	}
+	blocked := dangerous && cfg.Safety.BlockDangerous
+
+	if dir == "" {
+		writeUnits(os.Stdout, units)
+	} else if blocked {
+		fmt.Fprintln(os.Stderr, "qcmd: not writing unit files that run a dangerous command")
+	} else if err := saveUnits(dir, units); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	} else {
+		// Enabling the timer starts the service on schedule; otherwise
+		// the service itself is enabled.
+		enable := units[0].Name
+		for _, u := range units {
+			if u.Type() == "timer" {
+				enable = u.Name
+				break
+			}
+		}
+		paths := make([]string, len(units))
+		for i, u := range units {
+			paths[i] = filepath.Join(dir, u.Name)
+		}
+		fmt.Fprintf(os.Stderr, "Install with: sudo cp %s /etc/systemd/system/ && sudo systemctl daemon-reload && sudo systemctl enable --now %s\n",
+			strings.Join(paths, " "), enable)
+	}
+
+	if blocked {
+		return exitcode.DangerBlocked
+	}
+	return exitcode.Success
+}
+
+// checkUnits safety-checks the commands units run and warns about them on
+// w, about cautionary ones only in the categories warns accepts. It reports
+// whether any is dangerous.
+func checkUnits(w io.Writer, units []systemd.Unit, checker *safety.Checker, warns func(category string) bool) bool {
+	dangerous := false
+	for _, u := range units {
+		for _, cmd := range systemd.Commands(u) {
+			switch result := checker.Check(cmd); {
+			case result.Level == safety.Danger:
+				dangerous = true
+				fmt.Fprintf(w, "WARNING: %s: dangerous command (%s): %s\n", u.Name, result.Category, result.Description)
+			case result.Level == safety.Caution && warns(result.Category):
+				fmt.Fprint(w, i18n.Sprintf("Caution: %s: %s: %s\n", u.Name, result.Category, i18n.T(result.Description)))
+			}
+		}
+	}
+	return dangerous
+}
+
+// writeUnits writes units to w, each after its "# File:" line.
+func writeUnits(w io.Writer, units []systemd.Unit) {
+	for i, u := range units {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "# File: %s\n%s", u.Name, u.Content)
+	}
+}
+
+// saveUnits writes each unit to its file in dir. Existing files are not
+// overwritten, and nothing is written if any exists.
+func saveUnits(dir string, units []systemd.Unit) error {
+	for _, u := range units {
+		if path := filepath.Join(dir, u.Name); fileExists(path) {
+			return fmt.Errorf("%s already exists; remove it or choose another --write directory", path)
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating %s: %w", dir, err)
+	}
+	for _, u := range units {
+		path := filepath.Join(dir, u.Name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists; remove it or choose another --write directory", path)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteString(u.Content); err != nil {
+			f.Close()
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+	}
+	return nil
+}
+
+// fileExists reports whether something exists at path.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/update"
+	"github.com/user/qcmd/internal/xdg"
+)
+
+// updateTimeout bounds looking up the latest release.
+const updateTimeout = 5 * time.Second
+
+// buildInfo describes this qcmd binary, for `qcmd version`. Fields that
+// are not known are empty.
+type buildInfo struct {
+	Version   string      `json:"version"`
+	Commit    string      `json:"commit"`
+	BuildDate string      `json:"build_date"`
+	GoVersion string      `json:"go_version"`
+	OS        string      `json:"os"`
+	Arch      string      `json:"arch"`
+	Update    *updateInfo `json:"update,omitempty"`
+}
+
+// updateInfo is the outcome of looking up the latest release.
+type updateInfo struct {
+	Latest string `json:"latest,omitempty"`
+	URL    string `json:"url,omitempty"`
+	// Newer is null when this qcmd's version cannot be compared with
+	// the latest, as for development builds.
+	Newer *bool  `json:"newer"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleVersionCommand implements `qcmd version [--json] [--check]`, which
+// prints the version and build of this qcmd and, if asked, whether a newer
+// release is out.
+func handleVersionCommand(args []string) int {
+	var asJSON, check bool
+	fs := flag.NewFlagSet("qcmd version", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.BoolVar(&asJSON, "json", false, "Print the build information as JSON")
+	fs.BoolVar(&check, "check", false, "Look up whether a newer release is out (as update.check does)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd version [--json] [--check]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return exitcode.UserError
+	}
+
+	// A broken config is one reason to ask for the version, so it does not
+	// stop qcmd from answering.
+	cfg, err := config.Load(nil)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: warning: ignoring config: %v\n", err)
+		cfg = config.Default()
+	}
+
+	info := readBuildInfo(debug.ReadBuildInfo())
+	code := exitcode.Success
+	if check || cfg.Update.Check {
+		info.Update = checkUpdate(cfg.Update.URL, info.Version)
+		if info.Update.Error != "" {
+			fmt.Fprintf(os.Stderr, "qcmd: checking for a newer release: %s\n", info.Update.Error)
+			// Only a check asked for on the command line fails the command.
+			if check {
+				code = exitcode.NetworkFailure
+			}
+		}
+	}
+
+	if asJSON {
+		err = printVersionJSON(os.Stdout, info)
+	} else {
+		err = printVersion(os.Stdout, info)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	return code
+}
+
+// readBuildInfo returns what is known about this binary: the version,
+// commit and date set at build time, with the commit and date, and for go
+// install the version, filled in from bi, Go's record of the build, when
+// they were not set.
+func readBuildInfo(bi *debug.BuildInfo, ok bool) buildInfo {
+	info := buildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildDate: date,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	settings := make(map[string]string)
+	for _, s := range bi.Settings {
+		settings[s.Key] = s.Value
+	}
+	if info.Commit == "" && settings["vcs.revision"] != "" {
+		info.Commit = settings["vcs.revision"]
+		if settings["vcs.modified"] == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.BuildDate == "" {
+		// Go records the time of the commit, not of the build.
+		info.BuildDate = settings["vcs.time"]
+	}
+	return info
+}
+
+// checkUpdate looks up the latest release in the feed at url, or in the
+// cache, and compares it with current.
+func checkUpdate(url, current string) *updateInfo {
+	path := ""
+	if dir, err := xdg.CacheDir(); err == nil {
+		path = filepath.Join(dir, update.CacheFileName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), updateTimeout)
+	defer cancel()
+	client := &http.Client{Timeout: updateTimeout}
+
+	var r update.Release
+	var err error
+	if path != "" {
+		r, err = update.Cached(ctx, client, url, path, time.Now())
+	} else {
+		r, err = update.Latest(ctx, client, url)
+	}
+	if err != nil {
+		return &updateInfo{Error: err.Error()}
+	}
+	u := &updateInfo{Latest: r.Version, URL: r.URL}
+	if newer, ok := update.Newer(current, r.Version); ok {
+		u.Newer = &newer
+	}
+	return u
+}
+
+// printVersion writes info for people to read.
+func printVersion(w io.Writer, info buildInfo) error {
+	unknown := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return s
+	}
+	fmt.Fprintf(w, "qcmd version %s\n", info.Version)
+	fmt.Fprintf(w, "commit:     %s\n", unknown(info.Commit))
+	fmt.Fprintf(w, "built:      %s\n", unknown(info.BuildDate))
+	fmt.Fprintf(w, "go:         %s\n", info.GoVersion)
+	fmt.Fprintf(w, "platform:   %s/%s\n", info.OS, info.Arch)
+	u := info.Update
+	if u == nil || u.Error != "" {
+		return nil
+	}
+	latest := u.Latest
+	switch {
+	case u.Newer == nil:
+		// A development build may be older or newer.
+	case *u.Newer && u.URL != "":
+		latest += " (newer; see " + u.URL + ")"
+	case *u.Newer:
+		latest += " (newer)"
+	default:
+		latest += " (up to date)"
+	}
+	_, err := fmt.Fprintf(w, "latest:     %s\n", latest)
+	return err
+}
+
+// printVersionJSON writes info as JSON, for scripts and packaging checks.
+func printVersionJSON(w io.Writer, info buildInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(info)
+}
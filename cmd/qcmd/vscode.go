@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/qcmd/internal/config"
+	"github.com/user/qcmd/internal/exitcode"
+	"github.com/user/qcmd/internal/history"
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/safety"
+)
+
+// defaultTasksFile is where VS Code looks for workspace tasks.
+const defaultTasksFile = ".vscode/tasks.json"
+
+// vscodeTask is a shell task in VS Code's tasks.json.
+type vscodeTask struct {
+	Label   string `json:"label"`
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	// ProblemMatcher is empty rather than missing so VS Code does not ask
+	// for one every time the task runs.
+	ProblemMatcher []string `json:"problemMatcher"`
+}
+
+// newVSCodeTask returns a shell task running command.
+func newVSCodeTask(label, command string) vscodeTask {
+	return vscodeTask{Label: label, Type: "shell", Command: command, ProblemMatcher: []string{}}
+}
+
+// handleVSCodeTaskCommand implements
+// `qcmd vscode-task [--label TEXT] [--write] [--file PATH] [COMMAND...]`,
+// which turns COMMAND, or the last command in the history, into a VS Code
+// task.
+func handleVSCodeTaskCommand(args []string) int {
+	usage := func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd vscode-task [--label TEXT] [--write] [--file PATH] [COMMAND...]")
+	}
+
+	var label, path string
+	var write bool
+	fs := flag.NewFlagSet("qcmd vscode-task", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&label, "label", "", "Task label (default: the query, or the command)")
+	fs.BoolVar(&write, "write", false, "Add the task to the tasks file instead of printing it")
+	fs.StringVar(&path, "file", defaultTasksFile, "Tasks file to add the task to with --write")
+	fs.Usage = func() {
+		usage()
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Without COMMAND, uses the command qcmd generated (or you ran) last.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: failed to load config: %v\n", err)
+		return exitcode.SystemError
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: invalid config: %v\n", err)
+		return exitcode.UserError
+	}
+	i18n.SetLanguage(i18n.Detect(cfg.UI.Language))
+
+	command := strings.Join(fs.Args(), " ")
+	if command == "" {
+		entry, code := lastHistoryEntry()
+		if code != exitcode.Success {
+			return code
+		}
+		command = entry.Command
+		if entry.Executed != "" {
+			command = entry.Executed
+		}
+		if label == "" {
+			label = taskLabel(entry.Query)
+		}
+	}
+	if label == "" {
+		label = taskLabel(command)
+	}
+
+	// A task is run with a click, long after the command was reviewed.
+	result := newChecker(cfg).Check(command)
+	switch {
+	case result.Level == safety.Danger && cfg.Safety.BlockDangerous:
+		fmt.Fprintln(os.Stderr, i18n.T("WARNING: Dangerous command detected!"))
+		fmt.Fprint(os.Stderr, i18n.Sprintf("  Reason: %s\n", i18n.T(result.Description)))
+		fmt.Fprintln(os.Stderr, "qcmd: not saving a dangerous command as a task")
+		return exitcode.DangerBlocked
+	case result.Level == safety.Danger && cfg.Safety.ShowWarnings, result.Level == safety.Caution && cfg.Safety.Warns(result.Category):
+		fmt.Fprint(os.Stderr, i18n.Sprintf("Caution: %s", i18n.T(result.Description))+"\n")
+	}
+
+	task := newVSCodeTask(label, command)
+	if !write {
+		if err := writeVSCodeTask(os.Stdout, task); err != nil {
+			fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+			return exitcode.SystemError
+		}
+		return exitcode.Success
+	}
+
+	replaced, err := addVSCodeTask(path, task)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.SystemError
+	}
+	if replaced {
+		fmt.Fprintf(os.Stderr, "Updated task %q in %s\n", task.Label, path)
+	} else {
+		fmt.Fprintf(os.Stderr, "Added task %q to %s\n", task.Label, path)
+	}
+	return exitcode.Success
+}
+
+// lastHistoryEntry returns the most recent history entry, reporting a
+// missing or unreadable history itself.
+func lastHistoryEntry() (history.Entry, int) {
+	entries, err := loadHistory()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return history.Entry{}, exitcode.SystemError
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(os.Stderr, "qcmd: no history entry to use; pass a COMMAND")
+		return history.Entry{}, exitcode.UserError
+	}
+	return entries[len(entries)-1], exitcode.Success
+}
+
+// taskLabel derives a label from the first line of s.
+func taskLabel(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// writeVSCodeTask writes task to w as indented JSON, ready to paste into
+// the "tasks" array of tasks.json.
+func writeVSCodeTask(w io.Writer, task vscodeTask) error {
+	data, err := json.MarshalIndent(task, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// addVSCodeTask adds task to the tasks file at path, creating the file if
+// needed. A task with the same label is replaced, and replaced reports
+// whether there was one. Other settings in the file are kept, but VS Code's
+// comments are not, so files with comments are left alone.
+func addVSCodeTask(path string, task vscodeTask) (replaced bool, err error) {
+	doc := map[string]any{"version": "2.0.0"}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return false, err
+	default:
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return false, fmt.Errorf("%s is not plain JSON (comments are not supported); print the task and add it by hand: %w", path, err)
+		}
+	}
+
+	var tasks []any
+	if v, ok := doc["tasks"]; ok {
+		if tasks, ok = v.([]any); !ok {
+			return false, fmt.Errorf("%s: \"tasks\" is not a list", path)
+		}
+	}
+	for i, t := range tasks {
+		if existing, ok := t.(map[string]any); ok && existing["label"] == task.Label {
+			tasks[i] = task
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		tasks = append(tasks, task)
+	}
+	doc["tasks"] = tasks
+
+	data, err = json.MarshalIndent(doc, "", "\t")
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("creating %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return false, fmt.Errorf("writing %s: %w", path, err)
+	}
+	return replaced, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/user/qcmd/internal/exitcode"
+)
+
+// zleWrapOptions parameterizes the generated zsh widget.
+type zleWrapOptions struct {
+	Name    string
+	Key     string
+	Timeout int
+}
+
+// widgetNameRegex matches names that are safe to use as a zsh function and
+// widget name.
+var widgetNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// handleZLEWrapCommand implements `qcmd zle-wrap [--key KEY] [--name NAME]
+// [--timeout SECONDS]`, which prints the canonical zsh widget for qcmd.
+func handleZLEWrapCommand(args []string) int {
+	var opts zleWrapOptions
+	fs := flag.NewFlagSet("qcmd zle-wrap", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&opts.Key, "key", "^Q", "Key sequence to bind the widget to, in bindkey notation (empty to skip binding)")
+	fs.StringVar(&opts.Name, "name", "_qcmd_widget", "Name of the generated widget function")
+	fs.IntVar(&opts.Timeout, "timeout", 60, "Seconds to wait for qcmd before the widget gives up")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: qcmd zle-wrap [--key KEY] [--name NAME] [--timeout SECONDS]")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Prints a zsh widget; add eval \"$(qcmd zle-wrap)\" to your .zshrc.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitcode.Success
+		}
+		return exitcode.UserError
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitcode.UserError
+	}
+
+	if err := writeZLEWrap(os.Stdout, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "qcmd: %v\n", err)
+		return exitcode.UserError
+	}
+	return exitcode.Success
+}
+
+// writeZLEWrap renders the zsh widget for opts to w.
+func writeZLEWrap(w io.Writer, opts zleWrapOptions) error {
+	if !widgetNameRegex.MatchString(opts.Name) {
+		return fmt.Errorf("invalid widget name %q", opts.Name)
+	}
+	if strings.ContainsAny(opts.Key, "'\n") {
+		return fmt.Errorf("invalid key sequence %q", opts.Key)
+	}
+	if opts.Timeout <= 0 {
+		return fmt.Errorf("timeout must be positive")
+	}
+	return zleWrapTemplate.Execute(w, opts)
+}
+
+// zleWrapTemplate is the widget printed by `qcmd zle-wrap`. It uses the
+// current buffer as the query, or completes it with `qcmd
+// completion-helper` when it is the start of a command followed by a #
+// comment, and handles qcmd's exit codes the same way shell/qcmd.zsh does:
+// exit codes 3 and 8 print the command instead of injecting it.
+var zleWrapTemplate = template.Must(template.New("zle-wrap").Parse(`# qcmd zsh widget, generated by: qcmd zle-wrap
+# Type a request on the command line and press the bound key to replace it
+# with the generated command. The request can be a comment, which becomes
+# the command it describes: # resize all pngs to 50%
+# To complete a command instead, type its start and what it should do in a
+# comment: tar -c # all pngs here, gzipped
+
+function {{.Name}}() {
+    emulate -L zsh
+    setopt local_options no_notify no_monitor
+
+    local query=$BUFFER
+    [[ -z "${query//[[:space:]]/}" ]] && return 0
+
+    # The start of a command with a trailing comment is completed.
+    local -a subcommand=()
+    local trimmed=${query#"${query%%[![:space:]]*}"}
+    [[ $trimmed != \#* && $trimmed == *[[:space:]]\#* ]] && subcommand=(completion-helper)
+
+    local out err meta rc
+    out=$(mktemp) && err=$(mktemp) && meta=$(mktemp) && rc=$(mktemp) || {
+        zle -M "qcmd: failed to create temp file"
+        rm -f "$out" "$err" "$meta" "$rc"
+        return 1
+    }
+
+    # Run qcmd in the background so the widget can draw a spinner. The job
+    # is disowned, so its exit code is passed back through a file.
+    {
+        QCMD_WIDGET=1 QCMD_ALIASES="$(alias)" qcmd $subcommand --query "$query" --output=zle --cursor-marker --meta-file "$meta" >"$out" 2>"$err" </dev/null
+        print $? >"$rc"
+    } &!
+    local pid=$!
+
+    zmodload -F zsh/zselect b:zselect
+    local -a frames=('|' '/' '-' '\')
+    local start=$SECONDS i=0
+    while kill -0 $pid 2>/dev/null; do
+        if (( SECONDS - start >= {{.Timeout}} )); then
+            pkill -P $pid 2>/dev/null
+            kill $pid 2>/dev/null
+            print 124 >"$rc"
+            break
+        fi
+        zle -M "qcmd: ${frames[i % 4 + 1]} $(( SECONDS - start ))s"
+        zle -R
+        (( i++ ))
+        zselect -t 10
+    done
+    zle -M ""
+
+    # qcmd marks where to put the cursor, such as between empty quotes.
+    local cmd exit_code marker='%{CURSOR}%' mark=-1
+    cmd=$(<"$out")
+    exit_code=$(<"$rc")
+    if [[ $cmd == *${(b)marker}* ]]; then
+        mark=${#${cmd%%${(b)marker}*}}
+        cmd=${cmd//${(b)marker}/}
+    fi
+    typeset -g QCMD_LEVEL="" QCMD_CATEGORY="" QCMD_SCORE="" QCMD_PLACEHOLDERS=""
+    [[ -s "$meta" ]] && source "$meta"
+
+    # Pass diagnostics through to the terminal above the prompt.
+    if [[ -s "$err" ]]; then
+        zle -I
+        cat "$err" >&2
+    fi
+    rm -f "$out" "$err" "$meta" "$rc"
+
+    case $exit_code in
+        0)
+            BUFFER=$cmd
+            CURSOR=${#BUFFER}
+            # Highlight placeholders such as <bucket-name> and move the
+            # cursor to the first one, so they are filled in before running,
+            # unless qcmd marked the place.
+            region_highlight=()
+            local p before rest offset start first=-1
+            for p in ${=QCMD_PLACEHOLDERS}; do
+                rest=$BUFFER offset=0
+                while [[ $rest == *${(b)p}* ]]; do
+                    before=${rest%%${(b)p}*}
+                    start=$(( offset + ${#before} ))
+                    region_highlight+=("$start $(( start + ${#p} )) standout")
+                    (( first < 0 || start < first )) && first=$start
+                    offset=$(( start + ${#p} ))
+                    rest=${BUFFER:$offset}
+                done
+            done
+            if (( mark >= 0 )); then
+                CURSOR=$mark
+            elif (( first >= 0 )); then
+                CURSOR=$first
+            fi
+            ;;
+        3)
+            # Dangerous command - print but don't inject
+            zle -I
+            print -u2 "Command blocked from injection (safety check triggered)"
+            print -u2 "Review the command below. Copy manually if intended:"
+            print -r -- "$cmd"
+            ;;
+        8)
+            # The model was unsure - print but don't inject
+            zle -I
+            print -u2 "Command not inserted (the model was not confident in it)"
+            print -u2 "Review the command below. Copy manually if intended:"
+            print -r -- "$cmd"
+            ;;
+        10)
+            # Placeholders left to fill in - print but don't inject, so
+            # pressing Enter cannot run the command as it is
+            zle -I
+            local shown=$cmd p
+            for p in ${=QCMD_PLACEHOLDERS}; do
+                shown=${shown//${(b)p}/${(%):-%S}$p${(%):-%s}}
+            done
+            print -u2 "Command not inserted (fill in the highlighted placeholders first)"
+            print -u2 "Review the command below. Copy manually if intended:"
+            print -r -- "$shown"
+            ;;
+        11)
+            # Long or deeply piped - print but don't inject
+            zle -I
+            print -u2 "Command not inserted (review carefully: it is long or has a deep pipeline)"
+            print -u2 "Review the command below. Copy manually if intended:"
+            print -r -- "$cmd"
+            ;;
+        124)
+            zle -M "qcmd: timed out after {{.Timeout}}s"
+            ;;
+        *)
+            # Other failures - stderr already printed above
+            ;;
+    esac
+    zle reset-prompt
+    return 0
+}
+zle -N {{.Name}}
+{{- if .Key}}
+bindkey '{{.Key}}' {{.Name}}
+{{- end}}
+`))
@@ -0,0 +1,73 @@
+// Package advice comments on commands beyond their safety: how to follow
+// and detach ones that run for a long time, whether there is room for what
+// they write, whether the paths they read exist and whether they run in a
+// POSIX sh. Unlike the safety checks, advice never blocks a command; it is
+// shown next to it.
+package advice
+
+import "regexp"
+
+// Rule matches commands that are likely to run for a long time.
+type Rule struct {
+	// Regex matches the commands the rule is about.
+	Regex *regexp.Regexp
+	// Unless, if set, matches commands that already follow the advice.
+	Unless *regexp.Regexp
+	// Advice says what to do about it.
+	Advice string
+}
+
+// LongRunning lists commands that often run for minutes or hours.
+var LongRunning = []Rule{
+	{
+		Regex:  regexp.MustCompile(`\bfind\s+/(\s|$)`),
+		Unless: regexp.MustCompile(`\s-(xdev|mount)\b`),
+		Advice: "Searching from / reads the whole filesystem; start from a narrower directory or add -xdev to stay on one filesystem",
+	},
+	{
+		Regex:  regexp.MustCompile(`\btar\s+(-?[A-Za-z]*c[A-Za-z]*|--create)(\s|$)`),
+		Unless: regexp.MustCompile(`\|\s*pv\b|--checkpoint`),
+		Advice: "tar shows no progress on large archives; pipe it through pv (tar -cf - DIR | pv > out.tar) to see how far it has got",
+	},
+	{
+		Regex:  regexp.MustCompile(`\bdd\s`),
+		Unless: regexp.MustCompile(`\bstatus=progress\b|\|\s*pv\b`),
+		Advice: "Add status=progress to see how far dd has got",
+	},
+	{
+		Regex:  regexp.MustCompile(`\brsync\s`),
+		Unless: regexp.MustCompile(`\s(-[A-Za-z]*P[A-Za-z]*|--progress|--info=progress2?)(\s|$)`),
+		Advice: "Add -P (--partial --progress) to rsync to see progress and resume an interrupted transfer",
+	},
+	{
+		Regex:  regexp.MustCompile(`\bscp\s+(\S+\s+)*-[A-Za-z]*r`),
+		Advice: "scp cannot resume an interrupted copy; rsync -aP can, and shows progress",
+	},
+}
+
+// detached matches commands that survive the terminal closing.
+var detached = regexp.MustCompile(`\b(nohup|tmux|screen|setsid|systemd-run)\b|&\s*$`)
+
+// detachAdvice is added when any LongRunning rule matches a command that
+// is not already detached, whether or not it follows the rule's advice.
+const detachAdvice = "If it may take a while, run it in tmux or screen, or with nohup, so it survives a closed terminal or dropped SSH connection"
+
+// Check returns the advice about cmd, if it is likely to run for a long
+// time, in the order of the rules.
+func Check(cmd string) []string {
+	var advice []string
+	long := false
+	for _, r := range LongRunning {
+		if !r.Regex.MatchString(cmd) {
+			continue
+		}
+		long = true
+		if r.Unless == nil || !r.Unless.MatchString(cmd) {
+			advice = append(advice, r.Advice)
+		}
+	}
+	if long && !detached.MatchString(cmd) {
+		advice = append(advice, detachAdvice)
+	}
+	return advice
+}
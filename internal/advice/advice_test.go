@@ -0,0 +1,193 @@
+package advice
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"find / -name '*.log'", []string{LongRunning[0].Advice, detachAdvice}},
+		{"find / -xdev -name '*.log'", []string{detachAdvice}},
+		{"find . -name '*.log'", nil},
+		{"tar -czf backup.tgz /srv", []string{LongRunning[1].Advice, detachAdvice}},
+		{"tar czf backup.tgz /srv", []string{LongRunning[1].Advice, detachAdvice}},
+		{"tar -xzf backup.tgz", nil},
+		{"tar -cf - /srv | pv > backup.tar", []string{detachAdvice}},
+		{"dd if=disk.img of=/dev/sdb bs=4M", []string{LongRunning[2].Advice, detachAdvice}},
+		{"dd if=disk.img of=/dev/sdb bs=4M status=progress", []string{detachAdvice}},
+		{"rsync -a src/ host:dst/", []string{LongRunning[3].Advice, detachAdvice}},
+		{"rsync -aP src/ host:dst/", []string{detachAdvice}},
+		{"nohup rsync -aP src/ host:dst/ &", nil},
+		{"tmux new -d 'rsync -a src/ host:dst/'", []string{LongRunning[3].Advice}},
+		{"scp -r photos host:", []string{LongRunning[4].Advice, detachAdvice}},
+		{"scp notes.txt host:", nil},
+		{"ls -la", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := Check(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []Transfer
+	}{
+		{"cp -r photos videos /mnt/backup", []Transfer{{Sources: []string{"photos", "videos"}, Dest: "/mnt/backup"}}},
+		{"cp -t /mnt/backup -r photos", []Transfer{{Sources: []string{"photos"}, Dest: "/mnt/backup"}}},
+		{"sudo rsync -a -e ssh --exclude '*.tmp' src/ dst/", []Transfer{{Sources: []string{"src/"}, Dest: "dst/"}}},
+		{"rsync -a src/ host:dst/", nil},
+		{"tar -czf /tmp/site.tgz -C /srv www", []Transfer{{Sources: []string{"/srv/www"}, Dest: "/tmp/site.tgz", Compressed: true}}},
+		{"tar cf backup.tar notes", []Transfer{{Sources: []string{"notes"}, Dest: "backup.tar"}}},
+		{"tar --create --file=backup.tar.zst notes", []Transfer{{Sources: []string{"notes"}, Dest: "backup.tar.zst", Compressed: true}}},
+		{"tar -xzf site.tgz", nil},
+		{"tar -cf - notes | ssh host 'cat > notes.tar'", nil},
+		{"zip -r notes.zip notes", []Transfer{{Sources: []string{"notes"}, Dest: "notes.zip", Compressed: true}}},
+		{"dd if=disk.img of=copy.img bs=4M", []Transfer{{Sources: []string{"disk.img"}, Dest: "copy.img"}}},
+		{"dd if=disk.img of=/dev/sdb", nil},
+		{"cp $SRC /mnt", nil},
+		{"cd /srv && cp -r www /mnt/www-backup", []Transfer{{Sources: []string{"www"}, Dest: "/mnt/www-backup"}}},
+		{"ls -la", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := Transfers(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Transfers(%q) = %+v, want %+v", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.txt")
+	if err := os.WriteFile(small, []byte("hello\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got := CheckSpace("cp " + small + " " + filepath.Join(dir, "copy.txt")); len(got) != 0 {
+		t.Errorf("CheckSpace() for a small file = %+v, want none", got)
+	}
+
+	// A sparse file reports a size far beyond the free space without
+	// taking any.
+	huge := filepath.Join(dir, "huge.img")
+	f, err := os.Create(huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Truncate(1 << 43); err != nil {
+		t.Skipf("cannot create a sparse file: %v", err)
+	}
+	dest := filepath.Join(dir, "new", "copy.img")
+	got := CheckSpace("cp " + huge + " " + dest)
+	if _, err := freeSpace(dir); err != nil {
+		if len(got) != 0 {
+			t.Errorf("CheckSpace() without free space support = %+v, want none", got)
+		}
+		return
+	}
+	if len(got) != 1 || got[0].Dest != dest || got[0].Needed != 1<<43 || got[0].Free >= got[0].Needed {
+		t.Errorf("CheckSpace() for a huge file = %+v, want one shortage", got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{512, "512 B"},
+		{1536, "1.5 KiB"},
+		{5 << 30, "5.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestMissingPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"notes.txt", "src/main.go"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"cat notes.txt", nil},
+		{"cat config/app.yml", []string{"config/app.yml"}},
+		{"grep -rn 'TODO' src/ docs/", []string{"docs/"}},
+		{"sed -i 's/a/b/' notes.txt", nil},
+		{"sed -e 's/a/b/' -e 's/c/d/' missing.txt", []string{"missing.txt"}},
+		{"cp notes.txt backup/notes.txt", nil},
+		{"cp report.pdf backup/", []string{"report.pdf"}},
+		{"cd src && cat main.go", nil},
+		{"cd src && cat notes.txt", []string{"notes.txt"}},
+		{"find . -name '*.go' -newer other.go", nil},
+		{"python3 tool.py out.txt", []string{"tool.py"}},
+		{"bash -c 'cat /nope/x'", nil},
+		{"cat *.txt $HOME/x.txt https://example.com/a.txt", nil},
+		{"git checkout feature/login", nil},
+		{"cat notes.txt > out/new.txt", nil},
+		{"cat notes.txt missing.txt missing.txt", []string{"missing.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := MissingPaths(tt.cmd, dir); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MissingPaths(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPOSIX(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{`if [[ -f notes.txt ]]; then cat notes.txt; fi`, []string{Bashisms[0].Advice}},
+		{`[ "$a" == "b" ] && echo same`, []string{Bashisms[1].Advice}},
+		{`[ "$a" = "b" ] && echo same`, nil},
+		{"diff <(sort a) <(sort b)", []string{Bashisms[2].Advice}},
+		{`printf $'a\tb\n'`, []string{Bashisms[3].Advice}},
+		{"cp notes.txt{,.bak}", []string{Bashisms[4].Advice}},
+		{"echo ${HOME}", nil},
+		{"files=(a b c); echo ${files[0]}", []string{Bashisms[5].Advice}},
+		{"make &> build.log", []string{Bashisms[6].Advice}},
+		{"source venv/bin/activate && python app.py", []string{posixKeywords["source"]}},
+		{"awk '{print $1,$2}' notes.txt | grep '[[x]]'", nil},
+		{`echo "a{b,c}" 'x == y'`, nil},
+		{`find . -name '*.log' -exec rm {} \;`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := CheckPOSIX(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CheckPOSIX(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
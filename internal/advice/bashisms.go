@@ -0,0 +1,61 @@
+package advice
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// Bashism matches syntax that bash or zsh accept but a POSIX sh does not.
+type Bashism struct {
+	// Regex matches the syntax in a command whose quoted text is blanked
+	// out, so strings such as awk programs do not match.
+	Regex *regexp.Regexp
+	// Advice says what to write instead.
+	Advice string
+}
+
+// Bashisms lists the bash and zsh syntax most often written for sh.
+var Bashisms = []Bashism{
+	{regexp.MustCompile(`(^|[\s;&|(!])\[\[\s`), "[[ ]] is a bash test; use [ ] in sh"},
+	{regexp.MustCompile(`(^|[\s;&|(!])(\[|test)\s[^;&|]*\s==\s`), "== in a test is a bashism; use = in sh"},
+	{regexp.MustCompile(`[<>]\(`), "process substitution <(...) needs bash or zsh; use a pipe or a temporary file in sh"},
+	{regexp.MustCompile(`\$'`), "$'...' strings need bash or zsh; use printf in sh"},
+	{regexp.MustCompile(`(^|[^$])\{[^{}\s]*,[^{}\s]*\}`), "brace expansion {a,b} needs bash or zsh; write the words out in sh"},
+	{regexp.MustCompile(`(^|[\s;&|(])\w+=\(`), "arrays need bash or zsh; use positional parameters or a loop in sh"},
+	{regexp.MustCompile(`&>`), "&> needs bash or zsh; use > file 2>&1 in sh"},
+}
+
+// posixKeywords maps words that start a simple command in bash or zsh but
+// not in sh to the advice about them.
+var posixKeywords = map[string]string{
+	"source":   "source is a bashism; use . in sh",
+	"function": "the function keyword is a bashism; use name() { ... } in sh",
+}
+
+// CheckPOSIX returns advice about the bash and zsh syntax in cmd, which is
+// meant to run in a POSIX sh, in the order of Bashisms.
+func CheckPOSIX(cmd string) []string {
+	masked := shellparse.MaskQuotes(cmd)
+	var advice []string
+	for _, b := range Bashisms {
+		if b.Regex.MatchString(masked) {
+			advice = append(advice, b.Advice)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, pipeline := range shellparse.Pipelines(masked) {
+		for _, c := range shellparse.Commands(pipeline.Text) {
+			fields := strings.Fields(c)
+			if len(fields) == 0 {
+				continue
+			}
+			if a, ok := posixKeywords[fields[0]]; ok && !seen[a] {
+				seen[a] = true
+				advice = append(advice, a)
+			}
+		}
+	}
+	return advice
+}
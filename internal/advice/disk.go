@@ -0,0 +1,311 @@
+package advice
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// errSizeBudget stops measuring sources that take too long to walk.
+var errSizeBudget = errors.New("size budget exceeded")
+
+// sizeBudget bounds how long the sources of a command are measured. What
+// was counted by then is a lower bound, which is enough to warn.
+const sizeBudget = 500 * time.Millisecond
+
+// Transfer is a command writing a copy of local files: a copy, a sync, an
+// archive being created or a dd to a file.
+type Transfer struct {
+	// Sources are the files and directories read.
+	Sources []string
+	// Dest is the file or directory written, which may not exist yet.
+	Dest string
+	// Compressed reports whether the output is compressed, so it likely
+	// takes less space than the sources.
+	Compressed bool
+}
+
+// Shortage is a transfer whose sources are larger than the space left
+// where it writes.
+type Shortage struct {
+	Transfer
+	// Needed is the size of the sources in bytes, at least.
+	Needed uint64
+	// Free is the space available to the user at Dest, in bytes.
+	Free uint64
+}
+
+// Transfers returns the transfers among the simple commands in cmd. Remote
+// paths, devices and paths with variables are left out: their size cannot
+// be told from here.
+func Transfers(cmd string) []Transfer {
+	var transfers []Transfer
+	for _, pipeline := range shellparse.Pipelines(cmd) {
+		for _, c := range shellparse.Commands(pipeline.Text) {
+			words := shellparse.Words(c)
+			for len(words) > 0 && (words[0] == "sudo" || words[0] == "nice" || words[0] == "ionice" || words[0] == "nohup") {
+				words = words[1:]
+			}
+			if len(words) == 0 {
+				continue
+			}
+			var t Transfer
+			var ok bool
+			switch filepath.Base(words[0]) {
+			case "cp":
+				t, ok = copyTransfer(words[1:], map[string]bool{"-t": true, "-S": true})
+			case "rsync":
+				t, ok = copyTransfer(words[1:], map[string]bool{"-e": true, "--rsh": true, "--exclude": true, "--include": true, "-f": true, "--filter": true})
+			case "tar":
+				t, ok = tarTransfer(words[1:])
+			case "zip":
+				t, ok = zipTransfer(words[1:])
+			case "dd":
+				t, ok = ddTransfer(words[1:])
+			}
+			if ok && local(t) {
+				transfers = append(transfers, t)
+			}
+		}
+	}
+	return transfers
+}
+
+// copyTransfer reads the arguments of cp or rsync: sources, then the
+// destination, or a -t/--target-directory option naming it. Options in
+// withValue take the next argument.
+func copyTransfer(args []string, withValue map[string]bool) (Transfer, bool) {
+	var t Transfer
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == "-t" || a == "--target-directory":
+			if i+1 < len(args) {
+				t.Dest = args[i+1]
+			}
+			i++
+		case strings.HasPrefix(a, "--target-directory="):
+			t.Dest = strings.TrimPrefix(a, "--target-directory=")
+		case withValue[a]:
+			i++
+		case strings.HasPrefix(a, "-") && a != "-":
+		default:
+			operands = append(operands, a)
+		}
+	}
+	if t.Dest == "" {
+		if len(operands) < 2 {
+			return t, false
+		}
+		t.Dest = operands[len(operands)-1]
+		operands = operands[:len(operands)-1]
+	}
+	t.Sources = operands
+	return t, len(t.Sources) > 0
+}
+
+// tarTransfer reads the arguments of tar when it creates an archive in a
+// file. Options may be bundled, with or without a leading -.
+func tarTransfer(args []string) (Transfer, bool) {
+	var t Transfer
+	create := false
+	dir := ""
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--create":
+			create = true
+		case a == "--file" || a == "--directory":
+			if i+1 < len(args) {
+				if a == "--file" {
+					t.Dest = args[i+1]
+				} else {
+					dir = args[i+1]
+				}
+			}
+			i++
+		case strings.HasPrefix(a, "--file="):
+			t.Dest = strings.TrimPrefix(a, "--file=")
+		case strings.HasPrefix(a, "--directory="):
+			dir = strings.TrimPrefix(a, "--directory=")
+		case a == "--gzip" || a == "--bzip2" || a == "--xz" || a == "--zstd" || a == "--auto-compress":
+			t.Compressed = true
+		case strings.HasPrefix(a, "--"):
+		case strings.HasPrefix(a, "-") || i == 0:
+			// A bundle such as -czf or czf; f and C take the next
+			// arguments, in order.
+			for _, flag := range strings.TrimPrefix(a, "-") {
+				switch flag {
+				case 'c':
+					create = true
+				case 'z', 'j', 'J', 'a':
+					t.Compressed = true
+				case 'f', 'C':
+					if i+1 < len(args) {
+						i++
+						if flag == 'f' {
+							t.Dest = args[i]
+						} else {
+							dir = args[i]
+						}
+					}
+				}
+			}
+		default:
+			src := a
+			if dir != "" && !filepath.IsAbs(src) {
+				src = filepath.Join(dir, src)
+			}
+			t.Sources = append(t.Sources, src)
+		}
+	}
+	if strings.HasSuffix(t.Dest, "z") || strings.HasSuffix(t.Dest, ".bz2") || strings.HasSuffix(t.Dest, ".zst") {
+		t.Compressed = true
+	}
+	return t, create && t.Dest != "" && t.Dest != "-" && len(t.Sources) > 0
+}
+
+// zipTransfer reads the arguments of zip: the archive, then what goes in.
+func zipTransfer(args []string) (Transfer, bool) {
+	t := Transfer{Compressed: true}
+	for _, a := range args {
+		switch {
+		case strings.HasPrefix(a, "-"):
+		case t.Dest == "":
+			t.Dest = a
+		default:
+			t.Sources = append(t.Sources, a)
+		}
+	}
+	return t, t.Dest != "" && len(t.Sources) > 0
+}
+
+// ddTransfer reads the if= and of= operands of dd writing to a file.
+func ddTransfer(args []string) (Transfer, bool) {
+	var t Transfer
+	for _, a := range args {
+		if v, ok := strings.CutPrefix(a, "if="); ok {
+			t.Sources = []string{v}
+		} else if v, ok := strings.CutPrefix(a, "of="); ok {
+			t.Dest = v
+		}
+	}
+	return t, len(t.Sources) == 1 && t.Dest != "" && !strings.HasPrefix(t.Dest, "/dev/") && !strings.HasPrefix(t.Sources[0], "/dev/")
+}
+
+// local reports whether all of t's paths are on this machine and spelled
+// out, so they can be measured.
+func local(t Transfer) bool {
+	for _, p := range append([]string{t.Dest}, t.Sources...) {
+		if strings.ContainsAny(p, "$`") {
+			return false
+		}
+		// host:path, but not a local path with a colon after a slash.
+		if i := strings.IndexByte(p, ':'); i >= 0 && !strings.Contains(p[:i], "/") {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckSpace measures the sources of each transfer in cmd and returns
+// those that need more space than is free where they write. Relative paths
+// are taken from the working directory. Transfers that cannot be measured
+// are left out.
+func CheckSpace(cmd string) []Shortage {
+	var shortages []Shortage
+	for _, t := range Transfers(cmd) {
+		free, err := freeSpace(existingParent(expandHome(t.Dest)))
+		if err != nil {
+			continue
+		}
+		var paths []string
+		for _, src := range t.Sources {
+			src = expandHome(src)
+			matches, err := filepath.Glob(src)
+			if err != nil || len(matches) == 0 {
+				matches = []string{src}
+			}
+			paths = append(paths, matches...)
+		}
+		if needed := size(paths, time.Now().Add(sizeBudget)); needed > free {
+			shortages = append(shortages, Shortage{Transfer: t, Needed: needed, Free: free})
+		}
+	}
+	return shortages
+}
+
+// size adds up the sizes of the regular files at and under paths, without
+// following symbolic links. It stops at deadline.
+func size(paths []string, deadline time.Time) uint64 {
+	var total uint64
+	n := 0
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(_ string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if n++; n%1000 == 0 && time.Now().After(deadline) {
+				return errSizeBudget
+			}
+			if d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					total += uint64(info.Size())
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, errSizeBudget) {
+			break
+		}
+	}
+	return total
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// existingParent returns path, or its nearest ancestor that exists: where
+// a file that is yet to be created will be written.
+func existingParent(path string) string {
+	path = filepath.Clean(path)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// FormatBytes formats n for people, as in 1.5 GiB.
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
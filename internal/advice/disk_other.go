@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package advice
+
+import "errors"
+
+// freeSpace returns an error: free space is only checked on Linux and
+// macOS.
+func freeSpace(path string) (uint64, error) {
+	return 0, errors.New("checking free space is not supported on this system")
+}
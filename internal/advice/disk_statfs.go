@@ -0,0 +1,18 @@
+//go:build linux || darwin
+
+package advice
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// freeSpace returns the bytes available to the user on the filesystem
+// holding path.
+func freeSpace(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("checking free space: %w", err)
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
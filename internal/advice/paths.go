@@ -0,0 +1,130 @@
+package advice
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// readPrograms maps programs whose operands are files they read to the
+// number of leading operands that are not files, such as grep's pattern or
+// chmod's mode.
+var readPrograms = map[string]int{
+	"cat": 0, "less": 0, "more": 0, "head": 0, "tail": 0, "wc": 0, "sort": 0, "uniq": 0,
+	"ls": 0, "cd": 0, "du": 0, "stat": 0, "file": 0, "diff": 0, "cmp": 0,
+	"rm":     0,
+	"md5sum": 0, "sha1sum": 0, "sha256sum": 0, "sha512sum": 0,
+	"gzip": 0, "gunzip": 0, "bzip2": 0, "xz": 0, "unzip": 0,
+	"vi": 0, "vim": 0, "nvim": 0, "nano": 0, "code": 0, "open": 0, "xdg-open": 0,
+	"grep": 1, "egrep": 1, "fgrep": 1, "rg": 1, "sed": 1, "awk": 1, "jq": 1,
+	"chmod": 1, "chown": 1, "chgrp": 1,
+}
+
+// firstPrograms read only their first operand: a script, whose arguments
+// may be anything, or where find starts.
+var firstPrograms = map[string]bool{
+	"python": true, "python3": true, "node": true, "bash": true, "sh": true, "zsh": true,
+	"source": true, ".": true, "find": true,
+}
+
+// copyPrograms read all operands but the last, which is where they write.
+var copyPrograms = map[string]bool{"cp": true, "mv": true, "rsync": true, "scp": true, "ln": true, "install": true}
+
+// fileName matches a bare file name with an extension, such as notes.txt.
+var fileName = regexp.MustCompile(`^[\w.-]+\.[A-Za-z][A-Za-z0-9]{0,4}$`)
+
+// looksLikePath reports whether word is probably meant as a path: it has a
+// slash, starts with ~ or is a file name with an extension. Words with
+// globs, variables, URLs, remote hosts or spaces (a script given to sh -c)
+// are left alone.
+func looksLikePath(word string) bool {
+	if word == "" || strings.HasPrefix(word, "-") || strings.ContainsAny(word, "$`*?[]{}=<>|: \t\n") {
+		return false
+	}
+	if strings.HasPrefix(word, "/dev/") || strings.HasPrefix(word, "/proc/") || strings.HasPrefix(word, "/sys/") {
+		return false
+	}
+	return strings.Contains(word, "/") || strings.HasPrefix(word, "~") || fileName.MatchString(word)
+}
+
+// MissingPaths returns the paths cmd reads that do not exist, relative
+// paths taken from dir. Only programs known to read files are looked at,
+// and only arguments that look like paths, so a path that a generated
+// command made up is likely to be caught and few others are. A cd to an
+// existing directory moves dir for the commands after it.
+func MissingPaths(cmd, dir string) []string {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, pipeline := range shellparse.Pipelines(cmd) {
+		for _, c := range shellparse.Commands(pipeline.Text) {
+			words := shellparse.Words(c)
+			for len(words) > 0 && (words[0] == "sudo" || words[0] == "nice" || words[0] == "nohup" || words[0] == "time") {
+				words = words[1:]
+			}
+			if len(words) == 0 {
+				continue
+			}
+			program := filepath.Base(words[0])
+			operands, scripted := readOperands(words[1:])
+			skip, reads := readPrograms[program]
+			if scripted {
+				skip = 0
+			}
+			switch {
+			case reads:
+				if skip > len(operands) {
+					skip = len(operands)
+				}
+				operands = operands[skip:]
+			case firstPrograms[program] && len(operands) > 0:
+				operands = operands[:1]
+			case copyPrograms[program] && len(operands) > 1:
+				operands = operands[:len(operands)-1]
+			default:
+				continue
+			}
+
+			for _, op := range operands {
+				if !looksLikePath(op) && program != "cd" {
+					continue
+				}
+				path := expandHome(op)
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(dir, path)
+				}
+				if _, err := os.Stat(path); err != nil {
+					if !seen[op] {
+						seen[op] = true
+						missing = append(missing, op)
+					}
+				} else if program == "cd" {
+					dir = path
+				}
+			}
+		}
+	}
+	return missing
+}
+
+// readOperands returns the arguments that are not options, redirections,
+// or the value of a -e option, and whether there was one: then the sed
+// script or grep pattern is not among the operands.
+func readOperands(args []string) (operands []string, scripted bool) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "-e":
+			scripted = true
+			i++
+		case a == ">" || a == ">>" || a == "<" || a == "2>":
+			i++
+		case strings.HasPrefix(a, "-") || strings.ContainsAny(a, "<>"):
+		default:
+			operands = append(operands, a)
+		}
+	}
+	return operands, scripted
+}
@@ -0,0 +1,135 @@
+// Package audit keeps an append-only local log of security-relevant
+// events, such as safety checks being switched off, for managed and team
+// deployments to review.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+// FileName is the name of the file in the data directory the audit log was
+// kept in before the store; Import moves it into the store.
+const FileName = "audit.jsonl"
+
+// Events recorded in the audit log.
+const (
+	// EventSafetyDisabled records a run with --no-safety.
+	EventSafetyDisabled = "safety_disabled"
+	// EventSafetyDisableRefused records a --no-safety refused by policy.
+	EventSafetyDisableRefused = "safety_disable_refused"
+)
+
+// Entry is a single audit event.
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	User   string    `json:"user,omitempty"`
+	Dir    string    `json:"dir,omitempty"`
+	Args   []string  `json:"args,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Append adds e to the audit log in s, filling in the time, user and
+// working directory if unset. The log is only ever appended to.
+func Append(s *store.Store, e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	if e.User == "" {
+		e.User = os.Getenv("USER")
+	}
+	if e.Dir == "" {
+		e.Dir, _ = os.Getwd()
+	}
+	if err := insert(s.DB(), e); err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	return nil
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insert stores e. Its arguments are stored as a JSON array.
+func insert(db execer, e Entry) error {
+	args := ""
+	if len(e.Args) > 0 {
+		data, err := json.Marshal(e.Args)
+		if err != nil {
+			return err
+		}
+		args = string(data)
+	}
+	_, err := db.Exec("INSERT INTO audit (time, event, user, dir, args, detail) VALUES (?, ?, ?, ?, ?, ?)",
+		store.FormatTime(e.Time), e.Event, e.User, e.Dir, args, e.Detail)
+	return err
+}
+
+// Load reads all entries from s, oldest first, with times in UTC.
+func Load(s *store.Store) ([]Entry, error) {
+	rows, err := s.DB().Query("SELECT time, event, user, dir, args, detail FROM audit ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("reading audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var e Entry
+		var t, args string
+		if err := rows.Scan(&t, &e.Event, &e.User, &e.Dir, &args, &e.Detail); err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+		if e.Time, err = store.ParseTime(t); err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+		e.Time = e.Time.UTC()
+		if args != "" {
+			if err := json.Unmarshal([]byte(args), &e.Args); err != nil {
+				return nil, fmt.Errorf("reading audit log: %w", err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading audit log: %w", err)
+	}
+	return entries, nil
+}
+
+// Import moves the audit log qcmd kept in dir before the store into s. A
+// missing log is skipped.
+func Import(s *store.Store, dir string) error {
+	return s.Import(filepath.Join(dir, FileName), func(tx *sql.Tx, path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+			var e Entry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				return fmt.Errorf("audit log line %d: %w", line, err)
+			}
+			if err := insert(tx, e); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
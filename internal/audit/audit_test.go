@@ -0,0 +1,70 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+func TestAppendLoad(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.Open(filepath.Join(dir, store.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	entries, err := Load(s)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Load() on a new store = %v, %v, want no entries", entries, err)
+	}
+
+	t.Setenv("USER", "alice")
+	if err := Append(s, Entry{Event: EventSafetyDisabled, Args: []string{"--no-safety", "-q", "wipe disk"}}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	when := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := Append(s, Entry{Time: when, Event: EventSafetyDisableRefused, User: "bob", Dir: "/srv", Detail: "policy"}); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	entries, err = Load(s)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Load() returned %d entries, want 2", len(entries))
+	}
+
+	first := entries[0]
+	wd, _ := os.Getwd()
+	if first.Event != EventSafetyDisabled || first.User != "alice" || first.Dir != wd || first.Time.IsZero() {
+		t.Errorf("first entry = %+v, want defaults filled in", first)
+	}
+	if len(first.Args) != 3 || first.Args[2] != "wipe disk" {
+		t.Errorf("first entry args = %q", first.Args)
+	}
+	second := entries[1]
+	if !second.Time.Equal(when) || second.User != "bob" || second.Dir != "/srv" || second.Detail != "policy" {
+		t.Errorf("second entry = %+v, want explicit fields kept", second)
+	}
+
+	// An audit log from before the store is added to the stored one.
+	legacy := `{"time":"2024-04-01T09:00:00Z","event":"safety_disabled","user":"carol","args":["--no-safety"]}` + "\n"
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := Import(s, dir); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	entries, err = Load(s)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("Load() after Import() = %+v, %v; want 3 entries", entries, err)
+	}
+	if e := entries[2]; e.User != "carol" || len(e.Args) != 1 || e.Time.Location() != time.UTC {
+		t.Errorf("imported entry = %+v", e)
+	}
+}
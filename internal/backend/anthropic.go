@@ -2,14 +2,11 @@
 package backend
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
-	"text/template"
 )
 
 const (
@@ -96,10 +93,11 @@ func (b *AnthropicBackend) Name() string {
 
 // anthropicRequest is the request body for the Anthropic API.
 type anthropicRequest struct {
-	Model     string              `json:"model"`
-	MaxTokens int                 `json:"max_tokens"`
-	System    string              `json:"system,omitempty"`
-	Messages  []anthropicMessage  `json:"messages"`
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
 }
 
 // anthropicMessage represents a message in the Anthropic API.
@@ -139,12 +137,12 @@ func (b *AnthropicBackend) GenerateCommand(ctx context.Context, request *Request
 		return nil, ErrNoAPIKey
 	}
 
-	if request.Query == "" {
+	if request.Empty() {
 		return nil, ErrEmptyQuery
 	}
 
 	// Build system prompt
-	systemPrompt, err := b.buildSystemPrompt(request.Context)
+	systemPrompt, err := BuildSystemPrompt(request)
 	if err != nil {
 		return nil, fmt.Errorf("building system prompt: %w", err)
 	}
@@ -157,57 +155,21 @@ func (b *AnthropicBackend) GenerateCommand(ctx context.Context, request *Request
 
 	// Build request body
 	reqBody := anthropicRequest{
-		Model:     model,
-		MaxTokens: b.maxTokens,
-		System:    systemPrompt,
-		Messages: []anthropicMessage{
-			{Role: "user", Content: request.Query},
-		},
+		Model:       model,
+		MaxTokens:   maxTokensFor(request, b.maxTokens),
+		Temperature: request.Temperature,
+		System:      systemPrompt,
 	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling request: %w", err)
-	}
-
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL, bytes.NewReader(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
-	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("x-api-key", b.apiKey)
-	httpReq.Header.Set("anthropic-version", AnthropicAPIVersion)
-
-	// Execute request
-	resp, err := b.httpClient.Do(httpReq)
-	if err != nil {
-		// Check for context deadline exceeded
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("request timeout: %w", context.DeadlineExceeded)
-		}
-		if ctx.Err() == context.Canceled {
-			return nil, fmt.Errorf("request canceled: %w", context.Canceled)
-		}
-		return nil, fmt.Errorf("executing request: %w", err)
+	for _, m := range BuildMessages(request) {
+		reqBody.Messages = append(reqBody.Messages, anthropicMessage{Role: m.Role, Content: m.Content})
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := postJSON(ctx, b.httpClient, b.baseURL, map[string]string{
+		"x-api-key":         b.apiKey,
+		"anthropic-version": AnthropicAPIVersion,
+	}, reqBody, anthropicErrorMessage)
 	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
-	}
-
-	// Handle non-2xx responses
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		var apiResp anthropicResponse
-		if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
-			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiResp.Error.Message)
-		}
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
 	// Parse response
@@ -233,38 +195,20 @@ func (b *AnthropicBackend) GenerateCommand(ctx context.Context, request *Request
 		return nil, ErrEmptyResponse
 	}
 
-	return &Response{
-		Command:    command,
-		Model:      apiResp.Model,
-		TokensUsed: apiResp.Usage.InputTokens + apiResp.Usage.OutputTokens,
-	}, nil
+	return decodeStructured(request, &Response{
+		Command:      command,
+		Model:        apiResp.Model,
+		TokensUsed:   apiResp.Usage.InputTokens + apiResp.Usage.OutputTokens,
+		InputTokens:  apiResp.Usage.InputTokens,
+		OutputTokens: apiResp.Usage.OutputTokens,
+	}), nil
 }
 
-// buildSystemPrompt constructs the system prompt with optional context.
-func (b *AnthropicBackend) buildSystemPrompt(shellCtx *ShellContext) (string, error) {
-	if shellCtx == nil {
-		return SystemPromptNoContext, nil
-	}
-
-	tmpl, err := template.New("system").Parse(SystemPromptTemplate)
-	if err != nil {
-		return "", fmt.Errorf("parsing template: %w", err)
-	}
-
-	var buf bytes.Buffer
-	data := struct {
-		WorkingDir string
-		Shell      string
-		OS         string
-	}{
-		WorkingDir: shellCtx.WorkingDir,
-		Shell:      shellCtx.Shell,
-		OS:         shellCtx.OS,
-	}
-
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("executing template: %w", err)
+// anthropicErrorMessage extracts the error message from an error response body.
+func anthropicErrorMessage(body []byte) string {
+	var apiResp anthropicResponse
+	if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
+		return apiResp.Error.Message
 	}
-
-	return buf.String(), nil
+	return ""
 }
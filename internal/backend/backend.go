@@ -16,6 +16,19 @@ var (
 
 	// ErrEmptyResponse is returned when the LLM returns an empty response.
 	ErrEmptyResponse = errors.New("empty response from LLM")
+
+	// ErrRateLimited is matched by API errors caused by provider rate limits.
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrUnauthorized is matched by API errors caused by a rejected API key.
+	ErrUnauthorized = errors.New("authentication failed")
+
+	// ErrNetwork wraps transport failures (DNS, connection refused, resets).
+	ErrNetwork = errors.New("network error")
+
+	// ErrContextTooLong is matched by API errors caused by a prompt longer
+	// than the model's context window.
+	ErrContextTooLong = errors.New("prompt too long for the model")
 )
 
 // Backend defines the contract for LLM providers.
@@ -28,11 +41,23 @@ type Backend interface {
 	Name() string
 }
 
+// Embedder is implemented by backends that can turn texts into vectors for
+// semantic search.
+type Embedder interface {
+	// Embed returns one vector per text, in order. An empty model means
+	// the backend's default embedding model.
+	Embed(ctx context.Context, texts []string, model string) ([][]float32, error)
+}
+
 // Request contains the input for command generation.
 type Request struct {
 	// Query is the user's natural language query describing the desired command.
 	Query string
 
+	// Partial is the command line typed so far, for TaskComplete; Query
+	// then says what it should do, and may be empty.
+	Partial string
+
 	// Context provides optional shell context (pwd, shell type, OS).
 	// May be nil if context is not available or disabled.
 	Context *ShellContext
@@ -40,6 +65,58 @@ type Request struct {
 	// Model overrides the default model for this request.
 	// If empty, the backend's default model is used.
 	Model string
+
+	// Examples are few-shot query/command pairs sent ahead of the query,
+	// oldest first. May be empty.
+	Examples []Example
+
+	// Task selects the system prompt; the zero value asks for a command.
+	Task Task
+
+	// Structured asks for a command as JSON along with the model's
+	// confidence in it (see StructuredOutputPrompt). Only TaskCommand
+	// supports it.
+	Structured bool
+
+	// SystemPrompt, if set, replaces the task's instructions. It is a
+	// template like the built-in prompts, and the shell context is
+	// appended to it the same way.
+	SystemPrompt string
+
+	// AppendPrompt is added to the end of the system prompt.
+	AppendPrompt string
+
+	// Temperature, if not nil, is sent in place of the provider's default
+	// sampling temperature.
+	Temperature *float64
+
+	// MaxTokens, if not 0, overrides the backend's maximum response length.
+	MaxTokens int
+
+	// Clarifications are the questions the model asked about Query, in
+	// order, with the user's answers. They are sent as later turns of the
+	// conversation. May be empty.
+	Clarifications []Clarification
+}
+
+// Empty reports whether the request gives nothing to generate from: no
+// query and no partly typed command line.
+func (r *Request) Empty() bool {
+	return r.Query == "" && r.Partial == ""
+}
+
+// Example is a query paired with the command that should be produced for it.
+type Example struct {
+	Query   string
+	Command string
+}
+
+// Clarification is a detail the model asked for, with the user's answer.
+type Clarification struct {
+	// Question is the model's whole reply, such as an ambiguous error
+	// sentinel, so the conversation is sent as it happened.
+	Question string
+	Answer   string
 }
 
 // Response contains the result of command generation.
@@ -53,6 +130,18 @@ type Response struct {
 	// TokensUsed is the number of tokens consumed (for cost tracking).
 	// May be 0 if not available from the API.
 	TokensUsed int
+
+	// InputTokens and OutputTokens split TokensUsed into prompt and
+	// completion tokens, which are priced differently. Both are 0 if not
+	// available from the API.
+	InputTokens  int
+	OutputTokens int
+
+	// Confidence is the model's confidence in Command, from 0 to 1. It is
+	// only set (and HasConfidence true) for structured requests the model
+	// answered in the requested format.
+	Confidence    float64
+	HasConfidence bool
 }
 
 // ShellContext provides context about the user's shell environment.
@@ -67,34 +156,344 @@ type ShellContext struct {
 
 	// OS is the operating system, e.g., "darwin", "linux".
 	OS string
+
+	// Sections holds optional supplementary context (directory listing,
+	// history, ...) appended to the prompt. Sections may be dropped to
+	// stay within the token budget; see FitBudget.
+	Sections []ContextSection
 }
 
-// SystemPromptTemplate is the shared system prompt template for all backends.
+// Context section priorities. Lower-priority sections are trimmed first
+// when the assembled prompt exceeds the token budget.
+const (
+	PriorityLow    = 10
+	PriorityNormal = 50
+	PriorityHigh   = 90
+)
+
+// ContextSection is a named block of supplementary context for the prompt.
+type ContextSection struct {
+	// Name is the section heading shown to the LLM, e.g., "Directory listing".
+	Name string
+
+	// Content is the section body.
+	Content string
+
+	// Priority determines trim order (see PriorityLow, PriorityNormal, PriorityHigh).
+	Priority int
+}
+
+// SystemPromptNoContext is the system prompt when shell context is not available.
 // It instructs the LLM to output only shell commands.
-const SystemPromptTemplate = `You are a shell command generator. Your ONLY job is to output a valid shell command.
+const SystemPromptNoContext = `You are a shell command generator. Your ONLY job is to output a valid shell command.
 
 Rules:
 1. Output ONLY the raw shell command - no explanation, no markdown, no code fences
 2. Do not include any text before or after the command
 3. If multiple commands are needed, chain them with && or ;
 4. For complex commands, use proper line continuation with backslashes
-5. If the request is unclear or impossible, output exactly: echo "QCMD_ERROR: <brief reason>"
+5. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
 6. If the request would require dangerous operations, still provide the command (the tool handles safety)
-7. Escape shell metacharacters properly (e.g., use \; not ; in find -exec, escape $ in strings)
+7. Escape shell metacharacters properly (e.g., use \; not ; in find -exec, escape $ in strings)`
+
+// contextPromptTemplate renders the shell context appended to every
+// task's system prompt when context is available.
+const contextPromptTemplate = `
 
 Context provided:
 - Working directory: {{.WorkingDir}}
 - Shell: {{.Shell}}
-- OS: {{.OS}}`
+- OS: {{.OS}}{{range .Sections}}
 
-// SystemPromptNoContext is the system prompt when shell context is not available.
-const SystemPromptNoContext = `You are a shell command generator. Your ONLY job is to output a valid shell command.
+{{.Name}}:
+{{.Content}}{{end}}`
+
+// SystemPromptTemplate is the shared system prompt template for all backends.
+const SystemPromptTemplate = SystemPromptNoContext + contextPromptTemplate
+
+// ScriptPromptNoContext is the system prompt for TaskScript when shell
+// context is not available.
+const ScriptPromptNoContext = `You are a shell script generator. Your ONLY job is to output a short, reviewable shell script.
 
 Rules:
-1. Output ONLY the raw shell command - no explanation, no markdown, no code fences
-2. Do not include any text before or after the command
-3. If multiple commands are needed, chain them with && or ;
-4. For complex commands, use proper line continuation with backslashes
-5. If the request is unclear or impossible, output exactly: echo "QCMD_ERROR: <brief reason>"
-6. If the request would require dangerous operations, still provide the command (the tool handles safety)
-7. Escape shell metacharacters properly (e.g., use \; not ; in find -exec, escape $ in strings)`
+1. Output ONLY the script - no explanation before or after it, no markdown, no code fences
+2. Start with a shebang line and "set -eu"
+3. Split the workflow into a few clear steps, each preceded by a "# Step N: ..." comment
+4. Keep it short: prefer standard tools and avoid unnecessary functions or option parsing
+5. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+6. If the request would require dangerous operations, still provide the script (the tool handles safety)`
+
+// ScriptPromptTemplate is the system prompt template for TaskScript.
+const ScriptPromptTemplate = ScriptPromptNoContext + contextPromptTemplate
+
+// ExplainPromptNoContext is the system prompt for TaskExplain when shell
+// context is not available.
+const ExplainPromptNoContext = `You explain shell commands to someone deciding whether to run them.
+
+Rules:
+1. Output plain text only - no markdown headings, no code fences
+2. Start with one sentence saying what the command does as a whole
+3. Then explain each part (command, option, pipe, redirection) on its own line, starting with "- "
+4. End with a line starting "Risks: " naming anything destructive, irreversible, privileged or network-facing, or "Risks: none"
+5. Do not suggest running the command and do not rewrite it
+6. If the input is not a shell command, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"`
+
+// ExplainPromptTemplate is the system prompt template for TaskExplain.
+const ExplainPromptTemplate = ExplainPromptNoContext + contextPromptTemplate
+
+// CronPromptNoContext is the system prompt for TaskCron when shell context
+// is not available.
+const CronPromptNoContext = `You are a crontab entry generator. Your ONLY job is to output a single crontab line: a schedule followed by the command to run.
+
+Rules:
+1. Output ONLY the crontab line - no explanation, no markdown, no code fences
+2. Use the five schedule fields (minute hour day-of-month month day-of-week) or a shorthand such as @daily or @reboot
+3. Keep the command on one line; chain steps with && and use absolute paths, since cron runs with a minimal PATH
+4. Escape every % in the command as \%, since cron turns a bare % into a newline
+5. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+6. If the request would require dangerous operations, still provide the line (the tool handles safety)`
+
+// CronPromptTemplate is the system prompt template for TaskCron.
+const CronPromptTemplate = CronPromptNoContext + contextPromptTemplate
+
+// UnitPromptNoContext is the system prompt for TaskUnit when shell context
+// is not available.
+const UnitPromptNoContext = `You are a systemd unit generator. Your ONLY job is to output systemd unit files.
+
+Rules:
+1. Output ONLY the unit files - no explanation, no markdown, no code fences
+2. Start each file with a line "# File: <name>.service" or "# File: <name>.timer", followed by its contents
+3. Output a .service unit, plus a .timer unit with the same name when the request implies a schedule
+4. Use absolute paths in Exec lines, and include an [Install] section in the unit that should be enabled
+5. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+6. If the request would require dangerous operations, still provide the units (the tool handles safety)`
+
+// UnitPromptTemplate is the system prompt template for TaskUnit.
+const UnitPromptTemplate = UnitPromptNoContext + contextPromptTemplate
+
+// ContainerPromptNoContext is the system prompt for TaskContainer when
+// shell context is not available.
+const ContainerPromptNoContext = `You are a container configuration generator. Your ONLY job is to output a Dockerfile, a docker-compose file, or a single docker command, whichever the request implies.
+
+Rules:
+1. Output ONLY the Dockerfile, compose file or command - no explanation, no markdown, no code fences
+2. Output a complete Dockerfile when the request is about building an image, starting with FROM (or ARG before FROM)
+3. Output a complete docker-compose YAML file when the request is about running several services together, starting with a top-level "services:" key and indented with spaces
+4. Otherwise output a single docker command on one line
+5. Pin base images to a tag and prefer official images
+6. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+7. If the request would require dangerous settings, still provide them (the tool handles safety)`
+
+// ContainerPromptTemplate is the system prompt template for TaskContainer.
+const ContainerPromptTemplate = ContainerPromptNoContext + contextPromptTemplate
+
+// ContainerFormatPrompts are appended to the TaskContainer system prompt
+// to ask for one kind of answer instead of letting the request decide.
+var ContainerFormatPrompts = map[string]string{
+	"command":    "Output a single docker command, even if a Dockerfile or compose file would also work.",
+	"dockerfile": "Output a Dockerfile, even if a single command would also work.",
+	"compose":    "Output a docker-compose file, even for a single service.",
+}
+
+// FilterPromptNoContext is the system prompt for TaskFilter when shell
+// context is not available.
+const FilterPromptNoContext = `You are a text filter generator. Your ONLY job is to output a jq, awk or sed program, as a shell command, that turns the user's input into what they asked for.
+
+Rules:
+1. Output ONLY the command - no explanation, no markdown, no code fences
+2. Read the input from stdin and write the result to stdout; do not name input files and do not redirect
+3. Use jq for JSON, and awk or sed for text; pipe into sort, uniq, head, tail, cut, tr, grep or wc only when needed
+4. Do not edit files in place, write files, or run other commands from within the program
+5. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"`
+
+// FilterPromptTemplate is the system prompt template for TaskFilter.
+const FilterPromptTemplate = FilterPromptNoContext + contextPromptTemplate
+
+// RegexPromptNoContext is the system prompt for TaskRegex when shell
+// context is not available.
+const RegexPromptNoContext = `You are a regular expression generator. Your ONLY job is to output a single regular expression that matches what the user describes.
+
+Rules:
+1. Output ONLY the regular expression - no explanation, no markdown, no code fences
+2. Do not add delimiters such as /.../, quotes or flags around it
+3. Prefer the simplest expression that matches what was asked and nothing more
+4. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"`
+
+// RegexPromptTemplate is the system prompt template for TaskRegex.
+const RegexPromptTemplate = RegexPromptNoContext + contextPromptTemplate
+
+// FixPromptNoContext is the system prompt for TaskFix when shell context
+// is not available.
+const FixPromptNoContext = `You are a shell command fixer. Your ONLY job is to output a corrected version of a command that failed.
+
+Rules:
+1. Output ONLY the corrected command - no explanation, no markdown, no code fences
+2. Use the exit status and error output, when given, to find what went wrong, and change only what is needed to fix it
+3. If the command is right but something it needs is missing, such as a package or a directory, output the command that provides it instead
+4. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+5. If the fix would require dangerous operations, still provide it (the tool handles safety)`
+
+// FixPromptTemplate is the system prompt template for TaskFix.
+const FixPromptTemplate = FixPromptNoContext + contextPromptTemplate
+
+// UndoPromptNoContext is the system prompt for TaskUndo when shell context
+// is not available.
+const UndoPromptNoContext = `You suggest how to reverse a shell command after it has run. Your ONLY job is to output a single command that undoes its effects.
+
+Rules:
+1. Output ONLY the command - no explanation, no markdown, no code fences
+2. Assume the command succeeded, and undo only what it changed
+3. If its effects cannot be reversed from the command alone, such as deleted files or overwritten data, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+4. Prefer a command that fails safely to one that could destroy data`
+
+// UndoPromptTemplate is the system prompt template for TaskUndo.
+const UndoPromptTemplate = UndoPromptNoContext + contextPromptTemplate
+
+// CompletePromptNoContext is the system prompt for TaskComplete when shell
+// context is not available.
+const CompletePromptNoContext = `You are a shell command line completer. Your ONLY job is to output the complete command line that the partial one the user typed was going to be.
+
+Rules:
+1. Output ONLY the complete command line - no explanation, no markdown, no code fences
+2. Start from what was typed and keep it as it is; only add to it, or fix a mistake in it if it would not run
+3. If the user described what the command should do, complete it to do that
+4. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+5. If the command would require dangerous operations, still provide it (the tool handles safety)`
+
+// CompletePromptTemplate is the system prompt template for TaskComplete.
+const CompletePromptTemplate = CompletePromptNoContext + contextPromptTemplate
+
+// CommentPromptNoContext is the system prompt for TaskComment when shell
+// context is not available.
+const CommentPromptNoContext = `You turn shell comments into commands. The user typed comments at their shell prompt describing what to run. Your ONLY job is to output their shell buffer with each comment replaced by the command it describes.
+
+Rules:
+1. Output ONLY the resulting buffer - no explanation, no markdown, no code fences
+2. Replace each comment line with the command it describes, in the same place; keep every other line exactly as it is
+3. A comment may refer to the lines around it, such as "now compress them"; take names and paths from them
+4. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+5. If the command would require dangerous operations, still provide it (the tool handles safety)`
+
+// CommentPromptTemplate is the system prompt template for TaskComment.
+const CommentPromptTemplate = CommentPromptNoContext + contextPromptTemplate
+
+// PlanPromptNoContext is the system prompt for TaskPlan when shell context
+// is not available.
+const PlanPromptNoContext = `You are a shell command planner. The user's task takes several commands run one after another. Your ONLY job is to output them as a numbered plan.
+
+Rules:
+1. Output ONLY the plan - no explanation, no markdown, no code fences
+2. Start each step with a line "# Step N: <what it does>", followed by its command on the next line
+3. Give each step one command; do not join steps with && or ;
+4. Use as few steps as the task needs, and no more than 10
+5. If a detail is missing, output exactly: echo "QCMD_ERROR[code=ambiguous]: <question asking for it>"; if the request is impossible, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"
+6. If a step would require dangerous operations, still provide it (the tool handles safety)`
+
+// PlanPromptTemplate is the system prompt template for TaskPlan.
+const PlanPromptTemplate = PlanPromptNoContext + contextPromptTemplate
+
+// RegexFlavorPrompts are appended to the TaskRegex system prompt to name
+// the dialect to write in.
+var RegexFlavorPrompts = map[string]string{
+	"pcre": "Use Perl-compatible (PCRE) syntax.",
+	"ere":  "Use POSIX extended (ERE) syntax, as in grep -E: no \\d, \\w, \\s, lazy quantifiers or lookarounds; use classes such as [[:digit:]] instead.",
+	"bre":  "Use POSIX basic (BRE) syntax, as in grep and sed without -E: write groups as \\( \\), intervals as \\{ \\} and alternation as \\|; no \\d, \\w or \\s.",
+	"go":   "Use Go regexp (RE2) syntax: no lookarounds or backreferences.",
+}
+
+// OSPrompts are appended to the system prompt of tasks that write shell
+// commands, keyed by the OS qcmd runs on (runtime.GOOS), for what differs
+// from a GNU/Linux system. style.os in the configuration overrides them.
+var OSPrompts = map[string]string{
+	"darwin":  "The system is macOS: unless the context says otherwise, sed, date, stat, find and xargs are the BSD versions (sed -i '', date -v, stat -f), services are managed with launchctl, packages with brew, and the clipboard is pbcopy and pbpaste.",
+	"freebsd": "The system is FreeBSD: the tools are the BSD versions, services are managed with service and sysrc, and packages with pkg.",
+	"openbsd": "The system is OpenBSD: the tools are the BSD versions, privileges are gained with doas, services are managed with rcctl, and packages with pkg_add.",
+	"netbsd":  "The system is NetBSD: the tools are the BSD versions, and packages are managed with pkgin.",
+}
+
+// StylePrompts are appended to the system prompt of tasks that write shell
+// commands for each style preference the user set, keyed by its name in
+// the [style] configuration section.
+var StylePrompts = map[string]string{
+	"long_flags":     "Prefer long options (--recursive) to short ones (-r) wherever the tool has them, so the command is easier to read.",
+	"posix":          "Prefer commands and options defined by POSIX to GNU or BSD extensions, so the command works on any Unix system.",
+	"avoid_bashisms": "The command runs in a POSIX sh: do not use bash or zsh syntax such as [[ ]], ==, arrays, <(...), {a,b} brace expansion, $'...' strings, function name { } or source.",
+}
+
+// StructuredOutputPrompt is appended to the TaskCommand system prompt for
+// structured requests.
+const StructuredOutputPrompt = `
+
+Output format:
+Instead of the raw command, output a single JSON object and nothing else:
+{"command": "<the shell command>", "confidence": <number from 0 to 1>}
+"confidence" is how sure you are that the command does exactly what was requested and runs as is on this system. Use a low value when you had to guess names, paths or flags. An error echo (rule 5) also goes in "command".`
+
+// ReviewPromptNoContext is the system prompt for TaskReview when shell
+// context is not available.
+const ReviewPromptNoContext = `You review a shell command that another model generated for a user's request, before the user runs it.
+
+Rules:
+1. Output exactly one line of plain text - no markdown, no code fences
+2. Start with "OK: " if the command does what was requested without unexpected side effects, otherwise start with "CONCERN: "
+3. Follow with one short sentence giving the reason
+4. Do not rewrite the command`
+
+// ReviewPromptTemplate is the system prompt template for TaskReview.
+const ReviewPromptTemplate = ReviewPromptNoContext + contextPromptTemplate
+
+// Task selects what kind of output the LLM is asked to produce.
+type Task string
+
+const (
+	// TaskCommand asks for a single shell command (the default).
+	TaskCommand Task = ""
+
+	// TaskScript asks for a short annotated multi-step script.
+	TaskScript Task = "script"
+
+	// TaskExplain asks for a plain-text explanation of the command given
+	// as the query.
+	TaskExplain Task = "explain"
+
+	// TaskReview asks whether a generated command matches the request it
+	// was generated for (see ReviewQuery) and is safe to run.
+	TaskReview Task = "review"
+
+	// TaskCron asks for a crontab line: a schedule and a command.
+	TaskCron Task = "cron"
+
+	// TaskUnit asks for systemd unit files: a service, and a timer if the
+	// request implies a schedule.
+	TaskUnit Task = "unit"
+
+	// TaskContainer asks for a Dockerfile, a compose file or a docker
+	// command, whichever the request implies.
+	TaskContainer Task = "container"
+
+	// TaskFilter asks for a jq, awk or sed program that reads stdin.
+	TaskFilter Task = "filter"
+
+	// TaskRegex asks for a regular expression.
+	TaskRegex Task = "regex"
+
+	// TaskFix asks for a corrected version of a command that failed,
+	// described by the query (see FixQuery).
+	TaskFix Task = "fix"
+
+	// TaskUndo asks for a command that reverses the one in the query (see
+	// UndoQuery).
+	TaskUndo Task = "undo"
+
+	// TaskComplete asks for the command line that Request.Partial starts,
+	// doing what the query describes.
+	TaskComplete Task = "complete"
+
+	// TaskComment asks for the shell buffer in the query with its comment
+	// lines replaced by the commands they describe.
+	TaskComment Task = "comment"
+
+	// TaskPlan asks for several commands to run in order, each as a
+	// "# Step N:" section (see internal/plan).
+	TaskPlan Task = "plan"
+)
@@ -1,11 +1,20 @@
 package backend
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 	"time"
@@ -602,6 +611,38 @@ func TestOpenAIBackend_GenerateCommand_APIError(t *testing.T) {
 	}
 }
 
+func TestOpenAIBackend_Embed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "" {
+			t.Errorf("expected no Authorization header without a key, got %q", got)
+		}
+		var reqBody openaiEmbeddingRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		if reqBody.Model != DefaultOpenAIEmbeddingModel {
+			t.Errorf("expected model %s, got %s", DefaultOpenAIEmbeddingModel, reqBody.Model)
+		}
+		// Answer out of order; Embed must follow the indexes.
+		fmt.Fprint(w, `{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}]}`)
+	}))
+	defer server.Close()
+
+	b := NewOpenAIBackend(WithOpenAIEmbeddingsURL(server.URL))
+	vectors, err := b.Embed(context.Background(), []string{"list files", "disk usage"}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := [][]float32{{1, 0}, {0, 1}}
+	if !reflect.DeepEqual(vectors, want) {
+		t.Errorf("Embed() = %v, want %v", vectors, want)
+	}
+
+	if _, err := NewOpenAIBackend().Embed(context.Background(), []string{"x"}, ""); !errors.Is(err, ErrNoAPIKey) {
+		t.Errorf("expected ErrNoAPIKey from OpenAI's endpoint without a key, got %v", err)
+	}
+}
+
 // =============================================================================
 // OpenRouter Backend Tests
 // =============================================================================
@@ -863,6 +904,174 @@ func TestOpenRouterBackend_GenerateCommand_APIError(t *testing.T) {
 	}
 }
 
+// =============================================================================
+// Remote Backend Tests
+// =============================================================================
+
+func TestRemoteBackend_Name(t *testing.T) {
+	b := NewRemoteBackend()
+	if got := b.Name(); got != "remote" {
+		t.Errorf("Name() = %q, want %q", got, "remote")
+	}
+}
+
+func TestRemoteBackend_GenerateCommand_Success(t *testing.T) {
+	want := &Request{
+		Query: "list files",
+		Context: &ShellContext{
+			WorkingDir: "/srv",
+			Shell:      "zsh",
+			OS:         "linux",
+			Sections:   []ContextSection{{Name: "Directory listing", Content: "a.txt", Priority: PriorityLow}},
+		},
+		Model:        "gpt-4o-mini",
+		Examples:     []Example{{Query: "disk usage", Command: "du -sh ."}},
+		Task:         TaskScript,
+		Structured:   true,
+		AppendPrompt: "Prefer POSIX sh.",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != RemotePath {
+			t.Errorf("expected path %s, got %s", RemotePath, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer team-token" {
+			t.Errorf("expected Authorization header, got %q", got)
+		}
+		if got := r.Header.Get(RemoteUserHeader); got != "alice" {
+			t.Errorf("expected %s header, got %q", RemoteUserHeader, got)
+		}
+
+		var reqBody RemoteRequest
+		if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+			t.Errorf("failed to decode request body: %v", err)
+		}
+		if got := reqBody.Request(); !reflect.DeepEqual(got, want) {
+			t.Errorf("request = %+v, want %+v", got, want)
+		}
+
+		json.NewEncoder(w).Encode(NewRemoteResponse("openai", &Response{
+			Command:       "ls -la",
+			Model:         "gpt-4o-mini",
+			TokensUsed:    30,
+			InputTokens:   25,
+			OutputTokens:  5,
+			Confidence:    0.9,
+			HasConfidence: true,
+		}))
+	}))
+	defer server.Close()
+
+	b := NewRemoteBackend(
+		WithRemoteURL(server.URL+"/"),
+		WithRemoteToken("team-token"),
+		WithRemoteUser("alice"),
+		WithRemoteModel("ignored-when-the-request-names-one"),
+	)
+	resp, err := b.GenerateCommand(context.Background(), want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantResp := &Response{Command: "ls -la", Model: "gpt-4o-mini", TokensUsed: 30, InputTokens: 25, OutputTokens: 5, Confidence: 0.9, HasConfidence: true}
+	if !reflect.DeepEqual(resp, wantResp) {
+		t.Errorf("response = %+v, want %+v", resp, wantResp)
+	}
+}
+
+func TestRemoteBackend_GenerateCommand_DefaultModel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var reqBody RemoteRequest
+		json.NewDecoder(r.Body).Decode(&reqBody)
+		if reqBody.Model != "team-model" {
+			t.Errorf("expected model team-model, got %q", reqBody.Model)
+		}
+		if r.Header.Get("Authorization") != "" || r.Header.Get(RemoteUserHeader) != "" {
+			t.Error("expected no Authorization or user header")
+		}
+		json.NewEncoder(w).Encode(RemoteResponse{Command: "ls"})
+	}))
+	defer server.Close()
+
+	b := NewRemoteBackend(WithRemoteURL(server.URL), WithRemoteModel("team-model"))
+	resp, err := b.GenerateCommand(context.Background(), &Request{Query: "list files"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.HasConfidence {
+		t.Error("expected no confidence")
+	}
+}
+
+func TestRemoteBackend_GenerateCommand_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		wantErr error
+		wantMsg string
+	}{
+		{"rate limited", http.StatusTooManyRequests, `{"error":"rate limited upstream"}`, ErrRateLimited, "rate limited upstream"},
+		{"bad token", http.StatusUnauthorized, `{"error":"missing or wrong token"}`, ErrUnauthorized, "missing or wrong token"},
+		{"gateway down", http.StatusBadGateway, `{"backend":"openai","error":"network error"}`, nil, "network error"},
+		{"empty", http.StatusOK, `{"backend":"openai"}`, ErrEmptyResponse, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			_, err := NewRemoteBackend(WithRemoteURL(server.URL)).GenerateCommand(context.Background(), &Request{Query: "list files"})
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantMsg)
+			}
+		})
+	}
+
+	if _, err := NewRemoteBackend().GenerateCommand(context.Background(), &Request{Query: "ls"}); err == nil || !strings.Contains(err.Error(), "remote.url") {
+		t.Errorf("error without a URL = %v", err)
+	}
+	if _, err := NewRemoteBackend(WithRemoteURL("http://127.0.0.1:1")).GenerateCommand(context.Background(), &Request{}); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("error for an empty query = %v, want ErrEmptyQuery", err)
+	}
+}
+
+func TestTrustCertificates(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	if _, err := NewHTTPClient(Timeouts{}).Get(server.URL); err == nil {
+		t.Fatal("request to a server with a private certificate succeeded without trusting it")
+	}
+
+	client := NewHTTPClient(Timeouts{})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := TrustCertificates(client, certPEM); err != nil {
+		t.Fatalf("TrustCertificates() error: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request after trusting the certificate: %v", err)
+	}
+	resp.Body.Close()
+
+	if err := TrustCertificates(NewHTTPClient(Timeouts{}), []byte("not a certificate")); err == nil {
+		t.Error("TrustCertificates() accepted a file without certificates")
+	}
+	if err := TrustCertificates(&http.Client{}, certPEM); err == nil {
+		t.Error("TrustCertificates() accepted a client without an *http.Transport")
+	}
+}
+
 // =============================================================================
 // Functional Options Tests
 // =============================================================================
@@ -968,6 +1177,11 @@ func TestBackendInterfaceCompliance(t *testing.T) {
 	var _ Backend = (*AnthropicBackend)(nil)
 	var _ Backend = (*OpenAIBackend)(nil)
 	var _ Backend = (*OpenRouterBackend)(nil)
+	var _ Backend = (*MockBackend)(nil)
+	var _ Backend = (*RemoteBackend)(nil)
+
+	var _ Embedder = (*OpenAIBackend)(nil)
+	var _ Embedder = (*MockBackend)(nil)
 }
 
 // =============================================================================
@@ -1131,3 +1345,745 @@ func TestContextCancellation(t *testing.T) {
 		t.Errorf("expected context.Canceled, got %v", err)
 	}
 }
+
+// =============================================================================
+// Prompt Budget Tests
+// =============================================================================
+
+func TestBuildSystemPrompt_Sections(t *testing.T) {
+	prompt, err := BuildSystemPrompt(&Request{Context: &ShellContext{
+		WorkingDir: "/tmp",
+		Shell:      "zsh",
+		OS:         "linux",
+		Sections: []ContextSection{
+			{Name: "Directory listing", Content: "a.txt\nsrc/", Priority: PriorityLow},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(prompt, "Directory listing:\na.txt\nsrc/") {
+		t.Errorf("expected section in system prompt, got %q", prompt)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prompt != SystemPromptNoContext {
+		t.Errorf("expected SystemPromptNoContext for nil context")
+	}
+}
+
+func TestBuildSystemPrompt_Task(t *testing.T) {
+	prompt, err := BuildSystemPrompt(&Request{Task: TaskScript, Context: &ShellContext{OS: "linux"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(prompt, ScriptPromptNoContext) || !strings.Contains(prompt, "- OS: linux") {
+		t.Errorf("expected script prompt with context, got %q", prompt)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskExplain})
+	if err != nil || prompt != ExplainPromptNoContext {
+		t.Errorf("expected explain prompt without context, got %q, %v", prompt, err)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskReview})
+	if err != nil || prompt != ReviewPromptNoContext {
+		t.Errorf("expected review prompt without context, got %q, %v", prompt, err)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskFix})
+	if err != nil || prompt != FixPromptNoContext {
+		t.Errorf("expected fix prompt without context, got %q, %v", prompt, err)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskUndo})
+	if err != nil || prompt != UndoPromptNoContext {
+		t.Errorf("expected undo prompt without context, got %q, %v", prompt, err)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskComplete})
+	if err != nil || prompt != CompletePromptNoContext {
+		t.Errorf("expected complete prompt without context, got %q, %v", prompt, err)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskPlan})
+	if err != nil || prompt != PlanPromptNoContext {
+		t.Errorf("expected plan prompt without context, got %q, %v", prompt, err)
+	}
+
+	prompt, err = BuildSystemPrompt(&Request{Task: TaskComment, Context: &ShellContext{OS: "linux"}})
+	if err != nil || !strings.HasPrefix(prompt, CommentPromptNoContext) || !strings.Contains(prompt, "- OS: linux") {
+		t.Errorf("expected comment prompt with context, got %q, %v", prompt, err)
+	}
+
+	if _, err := BuildSystemPrompt(&Request{Task: "bogus"}); err == nil {
+		t.Error("expected error for unknown task")
+	}
+}
+
+func TestFixQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		status  int
+		stderr  string
+		want    string
+	}{
+		{"command only", "tar xf a.tgz", 0, "", "Failed command:\ntar xf a.tgz"},
+		{"with status", "tar xf a.tgz", 2, "", "Failed command:\ntar xf a.tgz\n\nExit status: 2"},
+		{"with stderr", "tar xf a.tgz", 2, "tar: a.tgz: Cannot open\n", "Failed command:\ntar xf a.tgz\n\nExit status: 2\n\nEnd of its error output:\ntar: a.tgz: Cannot open"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FixQuery(tt.command, tt.status, tt.stderr); got != tt.want {
+				t.Errorf("FixQuery() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildSystemPrompt_Override(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      Request
+		want     []string
+		unwanted []string
+	}{
+		{
+			name:     "replaced instructions with context",
+			req:      Request{SystemPrompt: "Answer with fish syntax in {{.WorkingDir}}.", Context: &ShellContext{WorkingDir: "/srv", OS: "linux"}},
+			want:     []string{"Answer with fish syntax in /srv.", "- OS: linux"},
+			unwanted: []string{SystemPromptNoContext},
+		},
+		{
+			name: "replaced instructions without context",
+			req:  Request{SystemPrompt: "Answer with fish syntax in {{.WorkingDir}}."},
+			want: []string{"Answer with fish syntax in ."},
+		},
+		{
+			name: "appended instructions",
+			req:  Request{AppendPrompt: "  Prefer ripgrep over grep.\n"},
+			want: []string{SystemPromptNoContext + "\n\nPrefer ripgrep over grep."},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt, err := BuildSystemPrompt(&tt.req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(prompt, want) {
+					t.Errorf("prompt %q does not contain %q", prompt, want)
+				}
+			}
+			for _, unwanted := range tt.unwanted {
+				if strings.Contains(prompt, unwanted) {
+					t.Errorf("prompt %q contains %q", prompt, unwanted)
+				}
+			}
+		})
+	}
+
+	if _, err := BuildSystemPrompt(&Request{SystemPrompt: "{{.Bogus"}); err == nil {
+		t.Error("expected error for invalid system prompt template")
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"ls", 1},
+		{"ls -la", 3},
+	}
+
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFitBudget(t *testing.T) {
+	newRequest := func() *Request {
+		return &Request{
+			Query: "list files",
+			Context: &ShellContext{
+				WorkingDir: "/home/user",
+				Shell:      "zsh",
+				OS:         "linux",
+				Sections: []ContextSection{
+					{Name: "history", Content: strings.Repeat("word ", 100), Priority: PriorityNormal},
+					{Name: "listing", Content: strings.Repeat("word ", 100), Priority: PriorityLow},
+				},
+			},
+		}
+	}
+
+	base, err := EstimatePromptTokens(&Request{
+		Query:   "list files",
+		Context: &ShellContext{WorkingDir: "/home/user", Shell: "zsh", OS: "linux"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		budget      int
+		wantDropped []string
+	}{
+		{"zero budget is unlimited", 0, nil},
+		{"everything fits", base + 1000, nil},
+		{"drop lowest priority first", base + 150, []string{"listing"}},
+		{"drop all sections", base + 10, []string{"listing", "history"}},
+		{"core context is never trimmed", 1, []string{"listing", "history"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest()
+			dropped, err := FitBudget(req, tt.budget)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(dropped, ",") != strings.Join(tt.wantDropped, ",") {
+				t.Errorf("dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+			if got := len(req.Context.Sections); got != 2-len(tt.wantDropped) {
+				t.Errorf("remaining sections = %d, want %d", got, 2-len(tt.wantDropped))
+			}
+		})
+	}
+}
+
+func TestFitBudget_NilContext(t *testing.T) {
+	dropped, err := FitBudget(&Request{Query: "test"}, 1)
+	if err != nil || dropped != nil {
+		t.Errorf("FitBudget(nil context) = %v, %v; want nil, nil", dropped, err)
+	}
+}
+
+// =============================================================================
+// Mock Backend Tests
+// =============================================================================
+
+func TestMockBackend_Name(t *testing.T) {
+	if got := NewMockBackend().Name(); got != "mock" {
+		t.Errorf("Name() = %q, want %q", got, "mock")
+	}
+}
+
+func TestMockBackend_GenerateCommand(t *testing.T) {
+	b := NewMockBackend(
+		WithMockRule(regexp.MustCompile(`(?i)compress (\S+)`), "tar -czf $1.tar.gz $1"),
+		WithMockRule(regexp.MustCompile(`(?i)list`), "ls -la"),
+		WithMockFallback("echo fallback"),
+	)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"Compress logs", "tar -czf logs.tar.gz logs"},
+		{"list everything", "ls -la"},
+		{"compress then list", "tar -czf then.tar.gz then"},
+		{"something else", "echo fallback"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp, err := b.GenerateCommand(context.Background(), &Request{Query: tt.query})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.Command != tt.want {
+				t.Errorf("Command = %q, want %q", resp.Command, tt.want)
+			}
+			if resp.Model != "mock" {
+				t.Errorf("Model = %q, want %q", resp.Model, "mock")
+			}
+		})
+	}
+}
+
+func TestMockBackend_DefaultRules(t *testing.T) {
+	b := NewMockBackend()
+
+	resp, err := b.GenerateCommand(context.Background(), &Request{Query: "list all files"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Command != "ls -la" {
+		t.Errorf("Command = %q, want %q", resp.Command, "ls -la")
+	}
+
+	resp, err = b.GenerateCommand(context.Background(), &Request{Query: "brew coffee"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Command != DefaultMockFallback {
+		t.Errorf("Command = %q, want DefaultMockFallback", resp.Command)
+	}
+}
+
+func TestMockBackend_Errors(t *testing.T) {
+	b := NewMockBackend()
+
+	if _, err := b.GenerateCommand(context.Background(), &Request{}); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("expected ErrEmptyQuery, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.GenerateCommand(ctx, &Request{Query: "list files"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	empty := NewMockBackend(WithMockFallback(""), WithMockRule(regexp.MustCompile(`^x$`), "x"))
+	if _, err := empty.GenerateCommand(context.Background(), &Request{Query: "y"}); !errors.Is(err, ErrEmptyResponse) {
+		t.Errorf("expected ErrEmptyResponse, got %v", err)
+	}
+}
+
+func TestMockBackend_Embed(t *testing.T) {
+	b := NewMockBackend()
+	vectors, err := b.Embed(context.Background(), []string{"trim a video", "trim the video", "disk usage", ""}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	dot := func(a, b []float32) (d float32) {
+		for i := range a {
+			d += a[i] * b[i]
+		}
+		return d
+	}
+	if len(vectors) != 4 || len(vectors[0]) != MockEmbeddingSize {
+		t.Fatalf("Embed() returned %d vectors of %d", len(vectors), len(vectors[0]))
+	}
+	if same, other := dot(vectors[0], vectors[1]), dot(vectors[0], vectors[2]); same <= other {
+		t.Errorf("similarity of shared words %v, want more than unrelated %v", same, other)
+	}
+	if d := dot(vectors[3], vectors[3]); d != 0 {
+		t.Errorf("empty text embedding has norm %v, want 0", d)
+	}
+}
+
+// =============================================================================
+// HTTP Error Classification Tests
+// =============================================================================
+
+func TestAPIError_Is(t *testing.T) {
+	tests := []struct {
+		status       int
+		message      string
+		rateLimited  bool
+		unauthorized bool
+		tooLong      bool
+	}{
+		{status: 401, unauthorized: true},
+		{status: 403, unauthorized: true},
+		{status: 429, rateLimited: true},
+		{status: 400},
+		{status: 400, message: "This model's maximum context length is 128000 tokens.", tooLong: true},
+		{status: 400, message: "prompt is too long: 210000 tokens > 200000 maximum", tooLong: true},
+		{status: 413, tooLong: true},
+		{status: 500},
+	}
+
+	for _, tt := range tests {
+		if tt.message == "" {
+			tt.message = "nope"
+		}
+		t.Run(http.StatusText(tt.status)+" "+tt.message, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": tt.message}})
+			}))
+			defer server.Close()
+
+			b := NewOpenAIBackend(
+				WithOpenAIAPIKey("test-api-key"),
+				WithOpenAIBaseURL(server.URL),
+			)
+			_, err := b.GenerateCommand(context.Background(), &Request{Query: "test"})
+
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+				t.Fatalf("expected *APIError with status %d, got %v", tt.status, err)
+			}
+			if got := errors.Is(err, ErrRateLimited); got != tt.rateLimited {
+				t.Errorf("errors.Is(err, ErrRateLimited) = %v, want %v", got, tt.rateLimited)
+			}
+			if got := errors.Is(err, ErrUnauthorized); got != tt.unauthorized {
+				t.Errorf("errors.Is(err, ErrUnauthorized) = %v, want %v", got, tt.unauthorized)
+			}
+			if got := errors.Is(err, ErrContextTooLong); got != tt.tooLong {
+				t.Errorf("errors.Is(err, ErrContextTooLong) = %v, want %v", got, tt.tooLong)
+			}
+		})
+	}
+}
+
+func TestPostJSON_NetworkError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	b := NewAnthropicBackend(
+		WithAnthropicAPIKey("test-api-key"),
+		WithAnthropicBaseURL(url),
+	)
+	_, err := b.GenerateCommand(context.Background(), &Request{Query: "test"})
+	if !errors.Is(err, ErrNetwork) {
+		t.Errorf("expected ErrNetwork, got %v", err)
+	}
+}
+
+func TestNewHTTPClient_ResponseHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte(`{"content":[{"type":"text","text":"ls"}]}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		wantErr  bool
+	}{
+		{"header timeout", Timeouts{Connect: time.Second, ResponseHeader: 50 * time.Millisecond}, true},
+		{"no header timeout", Timeouts{Connect: time.Second}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewAnthropicBackend(
+				WithAnthropicAPIKey("test-api-key"),
+				WithAnthropicBaseURL(server.URL),
+				WithAnthropicHTTPClient(NewHTTPClient(tt.timeouts)),
+			)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := b.GenerateCommand(ctx, &Request{Query: "test"})
+			if tt.wantErr && !errors.Is(err, ErrNetwork) {
+				t.Errorf("expected ErrNetwork, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestTraceTimings(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(`{"content":[{"type":"text","text":"ls"}]}`))
+	}))
+	defer server.Close()
+
+	var timings []Timing
+	client := TraceTimings(NewHTTPClient(Timeouts{Connect: time.Second}), func(t Timing) {
+		timings = append(timings, t)
+	})
+	b := NewAnthropicBackend(
+		WithAnthropicAPIKey("test-api-key"),
+		WithAnthropicBaseURL(server.URL),
+		WithAnthropicHTTPClient(client),
+	)
+	for i := 0; i < 2; i++ {
+		if _, err := b.GenerateCommand(context.Background(), &Request{Query: "test"}); err != nil {
+			t.Fatalf("GenerateCommand() error: %v", err)
+		}
+	}
+
+	if len(timings) != 2 {
+		t.Fatalf("got %d timings, want 2", len(timings))
+	}
+	for i, timing := range timings {
+		if timing.Host != strings.TrimPrefix(server.URL, "http://") {
+			t.Errorf("timing %d: Host = %q", i, timing.Host)
+		}
+		if timing.TTFB < 20*time.Millisecond || timing.Total < timing.TTFB {
+			t.Errorf("timing %d: TTFB = %v, Total = %v", i, timing.TTFB, timing.Total)
+		}
+		if timing.Sent == 0 {
+			t.Errorf("timing %d: Sent = 0", i)
+		}
+		if timing.Protocol != "HTTP/1.1" {
+			t.Errorf("timing %d: Protocol = %q", i, timing.Protocol)
+		}
+	}
+	if timings[0].Reused || !timings[1].Reused {
+		t.Errorf("Reused = %v, %v; want false, true", timings[0].Reused, timings[1].Reused)
+	}
+	if s := timings[0].String(); !strings.Contains(s, "connect ") || !strings.Contains(s, "new connection") {
+		t.Errorf("String() = %q", s)
+	}
+	if s := timings[1].String(); strings.Contains(s, "connect ") || !strings.Contains(s, "ttfb ") {
+		t.Errorf("String() of a reused connection = %q", s)
+	}
+}
+
+func TestPostJSON_ResponseEncoding(t *testing.T) {
+	const payload = `{"content":[{"type":"text","text":"ls -la"}]}`
+	encode := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(payload))
+		w.Close()
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		encoding string
+		body     []byte
+	}{
+		{"", []byte(payload)},
+		{"gzip", encode(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })},
+		{"deflate", encode(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })},
+		{"deflate", encode(func(w io.Writer) io.WriteCloser { fw, _ := flate.NewWriter(w, flate.DefaultCompression); return fw })},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Accept-Encoding"); got != "gzip, deflate" {
+					t.Errorf("Accept-Encoding = %q", got)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				w.Write(tt.body)
+			}))
+			defer server.Close()
+
+			b := NewAnthropicBackend(WithAnthropicAPIKey("test-api-key"), WithAnthropicBaseURL(server.URL))
+			resp, err := b.GenerateCommand(context.Background(), &Request{Query: "test"})
+			if err != nil {
+				t.Fatalf("GenerateCommand() error: %v", err)
+			}
+			if resp.Command != "ls -la" {
+				t.Errorf("Command = %q, want %q", resp.Command, "ls -la")
+			}
+		})
+	}
+}
+
+func TestCompressRequests(t *testing.T) {
+	var gotEncoding, gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		var body io.Reader = r.Body
+		if gotEncoding == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Fatalf("gzip.NewReader() error: %v", err)
+			}
+			body = gz
+		}
+		data, _ := io.ReadAll(body)
+		gotBody = string(data)
+		w.Write([]byte(`{"content":[{"type":"text","text":"ls"}]}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		minBytes     int
+		wantEncoding string
+	}{
+		{"large body", 10, "gzip"},
+		{"small body", 1 << 20, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewAnthropicBackend(
+				WithAnthropicAPIKey("test-api-key"),
+				WithAnthropicBaseURL(server.URL),
+				WithAnthropicHTTPClient(CompressRequests(&http.Client{}, tt.minBytes)),
+			)
+			if _, err := b.GenerateCommand(context.Background(), &Request{Query: "find large files"}); err != nil {
+				t.Fatalf("GenerateCommand() error: %v", err)
+			}
+			if gotEncoding != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", gotEncoding, tt.wantEncoding)
+			}
+			if !strings.Contains(gotBody, "find large files") {
+				t.Errorf("server got body %q", gotBody)
+			}
+		})
+	}
+}
+
+// =============================================================================
+// Few-shot Example Tests
+// =============================================================================
+
+func TestBuildMessages_Examples(t *testing.T) {
+	req := &Request{
+		Query: "show disk usage",
+		Examples: []Example{
+			{Query: "list files", Command: "ls -A"},
+		},
+	}
+
+	got := BuildMessages(req)
+	want := []Message{
+		{Role: "user", Content: "list files"},
+		{Role: "assistant", Content: "ls -A"},
+		{Role: "user", Content: "show disk usage"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("BuildMessages() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("message[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBuildMessages_Partial(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"with comment", "all pngs, gzipped", "Command line so far:\ntar -c\n\nWhat it should do: all pngs, gzipped"},
+		{"without comment", "", "Command line so far:\ntar -c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &Request{Query: tt.query, Partial: "tar -c", Task: TaskComplete}
+			got := BuildMessages(req)
+			if len(got) != 1 || got[0].Content != tt.want {
+				t.Errorf("BuildMessages() = %+v, want one message %q", got, tt.want)
+			}
+			if rt := NewRemoteRequest(req).Request(); rt.Partial != req.Partial {
+				t.Errorf("remote round trip Partial = %q, want %q", rt.Partial, req.Partial)
+			}
+		})
+	}
+}
+
+func TestBuildMessages_Comment(t *testing.T) {
+	req := &Request{Query: "cd ~/photos\n# resize all pngs to 50%", Task: TaskComment}
+	want := []Message{{Role: "user", Content: "Shell buffer:\ncd ~/photos\n# resize all pngs to 50%"}}
+	if got := BuildMessages(req); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildMessages() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBuildMessages_Clarifications(t *testing.T) {
+	req := &Request{
+		Query:    "clean up old files",
+		Examples: []Example{{Query: "list files", Command: "ls -A"}},
+		Clarifications: []Clarification{
+			{Question: `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`, Answer: "/tmp"},
+		},
+	}
+	want := []Message{
+		{Role: "user", Content: "list files"},
+		{Role: "assistant", Content: "ls -A"},
+		{Role: "user", Content: "clean up old files"},
+		{Role: "assistant", Content: `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`},
+		{Role: "user", Content: "/tmp"},
+	}
+	if got := BuildMessages(req); !reflect.DeepEqual(got, want) {
+		t.Errorf("BuildMessages() = %+v, want %+v", got, want)
+	}
+	if got := NewRemoteRequest(req).Request(); !reflect.DeepEqual(got.Clarifications, req.Clarifications) {
+		t.Errorf("remote round trip = %+v, want %+v", got.Clarifications, req.Clarifications)
+	}
+}
+
+func TestStructuredOutput(t *testing.T) {
+	req := &Request{
+		Query:      "show disk usage",
+		Structured: true,
+		Examples:   []Example{{Query: "list files", Command: "ls -A"}},
+	}
+
+	prompt, err := BuildSystemPrompt(req)
+	if err != nil || !strings.HasSuffix(prompt, StructuredOutputPrompt) {
+		t.Errorf("BuildSystemPrompt() = %q, %v; want structured output instructions", prompt, err)
+	}
+	if msgs := BuildMessages(req); msgs[1].Content != `{"command":"ls -A","confidence":1}` {
+		t.Errorf("structured example answer = %q", msgs[1].Content)
+	}
+	if _, err := BuildSystemPrompt(&Request{Task: TaskExplain, Structured: true}); err == nil {
+		t.Error("expected error for structured explain request")
+	}
+
+	tests := []struct {
+		name           string
+		answer         string
+		wantCommand    string
+		wantConfidence float64
+		wantHas        bool
+	}{
+		{"json", `{"command": "df -h", "confidence": 0.9}`, "df -h", 0.9, true},
+		{"fenced", "```json\n{\"command\": \"du -sh .\", \"confidence\": 0.3}\n```", "du -sh .", 0.3, true},
+		{"clamped", `{"command": "df -h", "confidence": 7}`, "df -h", 1, true},
+		{"no confidence", `{"command": "df -h"}`, "df -h", 0, false},
+		{"bare command", "df -h", "df -h", 0, false},
+		{"braces in bare command", `find . -exec ls {} \;`, `find . -exec ls {} \;`, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewMockBackend(WithMockRule(regexp.MustCompile(".*"), tt.answer))
+			resp, err := b.GenerateCommand(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Command != tt.wantCommand || resp.Confidence != tt.wantConfidence || resp.HasConfidence != tt.wantHas {
+				t.Errorf("GenerateCommand() = %q, %v, %v; want %q, %v, %v",
+					resp.Command, resp.Confidence, resp.HasConfidence, tt.wantCommand, tt.wantConfidence, tt.wantHas)
+			}
+		})
+	}
+}
+
+func TestRequestSampling(t *testing.T) {
+	var body map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = nil
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	backends := map[string]Backend{
+		"anthropic":  NewAnthropicBackend(WithAnthropicAPIKey("k"), WithAnthropicBaseURL(server.URL), WithAnthropicMaxTokens(512)),
+		"openai":     NewOpenAIBackend(WithOpenAIAPIKey("k"), WithOpenAIBaseURL(server.URL), WithOpenAIMaxTokens(512)),
+		"openrouter": NewOpenRouterBackend(WithOpenRouterAPIKey("k"), WithOpenRouterBaseURL(server.URL), WithOpenRouterMaxTokens(512)),
+	}
+	zero := 0.0
+	for name, b := range backends {
+		t.Run(name, func(t *testing.T) {
+			b.GenerateCommand(context.Background(), &Request{Query: "list files"})
+			if _, ok := body["temperature"]; ok || body["max_tokens"] != 512.0 {
+				t.Errorf("default request body = %v; want no temperature and max_tokens 512", body)
+			}
+
+			b.GenerateCommand(context.Background(), &Request{Query: "list files", Temperature: &zero, MaxTokens: 64})
+			if body["temperature"] != 0.0 || body["max_tokens"] != 64.0 {
+				t.Errorf("adjusted request body = %v; want temperature 0 and max_tokens 64", body)
+			}
+		})
+	}
+
+	remote := NewRemoteRequest(&Request{Query: "list files", Temperature: &zero, MaxTokens: 64})
+	if got := remote.Request(); got.Temperature == nil || *got.Temperature != 0 || got.MaxTokens != 64 {
+		t.Errorf("remote round trip = %+v; want temperature 0 and max_tokens 64", got)
+	}
+}
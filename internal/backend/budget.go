@@ -0,0 +1,89 @@
+package backend
+
+import "github.com/user/qcmd/internal/tokens"
+
+// EstimateTokens returns an approximate token count for text.
+func EstimateTokens(text string) int {
+	return tokens.Estimate(text)
+}
+
+// EstimatePromptTokens returns the approximate token count of the fully
+// assembled prompt (system prompt, context and messages) for req.
+func EstimatePromptTokens(req *Request) (int, error) {
+	system, err := BuildSystemPrompt(req)
+	if err != nil {
+		return 0, err
+	}
+	total := EstimateTokens(system)
+	for _, m := range BuildMessages(req) {
+		total += EstimateTokens(m.Content)
+	}
+	return total, nil
+}
+
+// typicalCompletionTokens is the usual size of an answer for each task.
+var typicalCompletionTokens = map[Task]int{
+	TaskCommand:   40,
+	TaskScript:    300,
+	TaskExplain:   250,
+	TaskReview:    30,
+	TaskCron:      50,
+	TaskUnit:      250,
+	TaskContainer: 200,
+	TaskFilter:    50,
+	TaskRegex:     30,
+	TaskFix:       40,
+	TaskUndo:      40,
+	TaskComplete:  40,
+	TaskComment:   60,
+	TaskPlan:      150,
+}
+
+// EstimateCompletionTokens returns the typical number of tokens in an answer
+// to req, at most maxTokens (when positive). Structured answers carry some
+// JSON on top of the command.
+func EstimateCompletionTokens(req *Request, maxTokens int) int {
+	n := typicalCompletionTokens[req.Task]
+	if req.Structured {
+		n += 15
+	}
+	if maxTokens > 0 && n > maxTokens {
+		n = maxTokens
+	}
+	return n
+}
+
+// FitBudget drops context sections from req, lowest priority first, until
+// the estimated prompt size is within budget tokens. Among sections of equal
+// priority the last one added is dropped first. The core context (working
+// directory, shell, OS) and the query itself are never trimmed, so the
+// prompt may still exceed the budget after all sections are dropped.
+//
+// Returns the names of the dropped sections in the order they were dropped.
+func FitBudget(req *Request, budget int) ([]string, error) {
+	if budget <= 0 || req.Context == nil {
+		return nil, nil
+	}
+
+	var dropped []string
+	for {
+		tokens, err := EstimatePromptTokens(req)
+		if err != nil {
+			return dropped, err
+		}
+		sections := req.Context.Sections
+		if tokens <= budget || len(sections) == 0 {
+			return dropped, nil
+		}
+
+		victim := 0
+		for i, s := range sections {
+			if s.Priority <= sections[victim].Priority {
+				victim = i
+			}
+		}
+
+		dropped = append(dropped, sections[victim].Name)
+		req.Context.Sections = append(sections[:victim:victim], sections[victim+1:]...)
+	}
+}
@@ -0,0 +1,247 @@
+package backend
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Timeouts bound the stages of an HTTP request to a provider. A zero
+// timeout leaves its stage bounded only by the request's context, which
+// carries the overall timeout.
+type Timeouts struct {
+	// Connect bounds establishing the TCP connection, DNS included.
+	Connect time.Duration
+	// TLSHandshake bounds the TLS handshake.
+	TLSHandshake time.Duration
+	// ResponseHeader bounds the wait for the response headers after the
+	// request is sent. Without streaming, providers send them only once
+	// the whole answer is generated.
+	ResponseHeader time.Duration
+}
+
+// NewHTTPClient returns a client for provider APIs that gives up on each
+// stage of a request after its timeout, so an unreachable host fails in
+// seconds while a slow generation still gets the overall timeout.
+//
+// Requests to a provider, such as a flag check after a generation, reuse
+// the connection: HTTP/2 is negotiated where the provider offers it, and
+// idle connections are kept for a minute.
+func NewHTTPClient(t Timeouts) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   t.Connect,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = t.TLSHandshake
+	transport.ResponseHeaderTimeout = t.ResponseHeader
+	// A custom dialer turns HTTP/2 off unless it is asked for.
+	transport.ForceAttemptHTTP2 = true
+	transport.MaxIdleConnsPerHost = 4
+	transport.IdleConnTimeout = time.Minute
+	return &http.Client{Transport: transport}
+}
+
+// APIError is returned when a provider responds with a non-2xx status.
+// It matches ErrRateLimited, ErrUnauthorized and ErrContextTooLong via
+// errors.Is so callers can branch on the failure mode without inspecting
+// status codes.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+// Error implements the error interface.
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API error (%d): %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether the API error corresponds to target.
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrContextTooLong:
+		return e.StatusCode == http.StatusRequestEntityTooLarge ||
+			e.StatusCode == http.StatusBadRequest && isContextLengthMessage(e.Message)
+	}
+	return false
+}
+
+// contextLengthPhrases appear in the messages providers send with a 400
+// for prompts over the context window, e.g. OpenAI's "This model's maximum
+// context length is 128000 tokens" and Anthropic's "prompt is too long".
+var contextLengthPhrases = []string{
+	"context length",
+	"context_length",
+	"context window",
+	"prompt is too long",
+	"too many tokens",
+}
+
+// isContextLengthMessage reports whether an error message from a provider
+// says the prompt did not fit the model.
+func isContextLengthMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	for _, phrase := range contextLengthPhrases {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// postJSON marshals payload, POSTs it to url with the given headers and
+// returns the response body. Non-2xx responses are returned as *APIError;
+// errorMessage extracts the provider's error message from the body and may
+// return "" to fall back to the raw body. Transport failures wrap ErrNetwork.
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload any, errorMessage func(body []byte) string) ([]byte, error) {
+	jsonBody, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	// Set headers. Asking for compression ourselves turns off the
+	// transport's transparent gzip support; readBody decodes instead.
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept-Encoding", "gzip, deflate")
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	// Execute request
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		// Check for context deadline exceeded
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("request timeout: %w", context.DeadlineExceeded)
+		}
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, fmt.Errorf("request canceled: %w", context.Canceled)
+		}
+		return nil, fmt.Errorf("executing request: %w: %w", ErrNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	// Read response body
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Handle non-2xx responses
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg := errorMessage(body)
+		if msg == "" {
+			msg = string(body)
+		}
+		return nil, &APIError{StatusCode: resp.StatusCode, Message: msg}
+	}
+
+	return body, nil
+}
+
+// readBody reads the body of resp, decoding it as its Content-Encoding
+// says. Truncated transfers wrap ErrNetwork.
+func readBody(resp *http.Response) ([]byte, error) {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w: %w", ErrNetwork, err)
+	}
+
+	var r io.Reader
+	switch encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return raw, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			return nil, fmt.Errorf("decoding gzip response: %w", err)
+		}
+		r = gz
+	case "deflate":
+		// Deflate is meant to be zlib-wrapped, but some servers send it
+		// raw.
+		if zr, err := zlib.NewReader(bytes.NewReader(raw)); err == nil {
+			r = zr
+		} else {
+			r = flate.NewReader(bytes.NewReader(raw))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported response encoding %q", encoding)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s response: %w: %w", resp.Header.Get("Content-Encoding"), ErrNetwork, err)
+	}
+	return body, nil
+}
+
+// CompressRequests returns a copy of client that gzips request bodies of
+// at least minBytes, such as prompts with large directory listings or
+// history. Only some providers accept compressed requests.
+func CompressRequests(client *http.Client, minBytes int) *http.Client {
+	compressed := *client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	compressed.Transport = &gzipTransport{next: next, minBytes: minBytes}
+	return &compressed
+}
+
+// gzipTransport is the transport CompressRequests installs.
+type gzipTransport struct {
+	next     http.RoundTripper
+	minBytes int
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.GetBody == nil || req.ContentLength < int64(t.minBytes) || req.Header.Get("Content-Encoding") != "" {
+		return t.next.RoundTrip(req)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, fmt.Errorf("compressing request: %w", err)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err = io.Copy(gz, body)
+	body.Close()
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compressing request: %w", err)
+	}
+	req.Body.Close()
+
+	data := buf.Bytes()
+	compressed := req.Clone(req.Context())
+	compressed.Header.Set("Content-Encoding", "gzip")
+	compressed.ContentLength = int64(len(data))
+	compressed.Body = io.NopCloser(bytes.NewReader(data))
+	compressed.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	return t.next.RoundTrip(compressed)
+}
@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// DefaultMockFallback is returned by the mock backend when no rule matches.
+// It uses the error sentinel so callers report it like a real LLM refusal.
+const DefaultMockFallback = `echo "QCMD_ERROR: no mock rule matches this query"`
+
+// MockEmbeddingSize is the length of the mock backend's embeddings.
+const MockEmbeddingSize = 64
+
+// MockRule maps queries matching Pattern to Command. Command may reference
+// capture groups from Pattern using $1 or ${name} syntax.
+type MockRule struct {
+	Pattern *regexp.Regexp
+	Command string
+}
+
+// DefaultMockRules are used when the mock backend is created without rules.
+// They cover a handful of common requests for demos.
+var DefaultMockRules = []MockRule{
+	{Pattern: regexp.MustCompile(`(?i)\b(list|show)\b.*\bfiles\b`), Command: "ls -la"},
+	{Pattern: regexp.MustCompile(`(?i)\bdisk\b.*\b(usage|space)\b`), Command: "df -h"},
+	{Pattern: regexp.MustCompile(`(?i)\bfind\b.*\.(\w+)\s+files\b`), Command: `find . -type f -name "*.$1"`},
+	{Pattern: regexp.MustCompile(`(?i)\bgit\b.*\bstatus\b`), Command: "git status"},
+	{Pattern: regexp.MustCompile(`(?i)\bprocess(es)?\b`), Command: "ps aux"},
+}
+
+// MockBackend implements the Backend interface with canned, rule-based
+// responses. It never touches the network, which makes it suitable for
+// tests, demos and air-gapped environments.
+type MockBackend struct {
+	rules    []MockRule
+	fallback string
+}
+
+// MockOption is a functional option for configuring MockBackend.
+type MockOption func(*MockBackend)
+
+// WithMockRule appends a rule. Rules are evaluated in the order added.
+func WithMockRule(pattern *regexp.Regexp, command string) MockOption {
+	return func(b *MockBackend) {
+		b.rules = append(b.rules, MockRule{Pattern: pattern, Command: command})
+	}
+}
+
+// WithMockFallback sets the command returned when no rule matches.
+func WithMockFallback(command string) MockOption {
+	return func(b *MockBackend) {
+		b.fallback = command
+	}
+}
+
+// NewMockBackend creates a new mock backend with the given options.
+// If no rules are provided, DefaultMockRules are used.
+func NewMockBackend(opts ...MockOption) *MockBackend {
+	b := &MockBackend{
+		fallback: DefaultMockFallback,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	if len(b.rules) == 0 {
+		b.rules = DefaultMockRules
+	}
+
+	return b
+}
+
+// Name returns the backend identifier.
+func (b *MockBackend) Name() string {
+	return "mock"
+}
+
+// GenerateCommand returns the command of the first rule matching the query
+// and any answers to clarifying questions.
+func (b *MockBackend) GenerateCommand(ctx context.Context, request *Request) (*Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if request.Empty() {
+		return nil, ErrEmptyQuery
+	}
+
+	// A partly typed command line precedes the query, and answers to
+	// clarifying questions follow it on their own lines, so rules can
+	// match what the user added.
+	text := request.Query
+	if request.Partial != "" {
+		text = request.Partial + "\n" + text
+	}
+	for _, c := range request.Clarifications {
+		text += "\n" + c.Answer
+	}
+
+	command := b.fallback
+	for _, rule := range b.rules {
+		if match := rule.Pattern.FindStringSubmatchIndex(text); match != nil {
+			command = string(rule.Pattern.ExpandString(nil, rule.Command, text, match))
+			break
+		}
+	}
+
+	if command == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	return decodeStructured(request, &Response{
+		Command: command,
+		Model:   "mock",
+	}), nil
+}
+
+// Embed returns a hashed bag of words for each text, so texts sharing words
+// are similar. It stands in for an embedding model in tests and demos; it
+// knows nothing of meaning. The model is ignored.
+func (b *MockBackend) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		v := make([]float32, MockEmbeddingSize)
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, w := range words {
+			h := fnv.New32a()
+			h.Write([]byte(w))
+			v[h.Sum32()%MockEmbeddingSize]++
+		}
+		var norm float64
+		for _, x := range v {
+			norm += float64(x * x)
+		}
+		if norm > 0 {
+			for j := range v {
+				v[j] /= float32(math.Sqrt(norm))
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
@@ -1,14 +1,11 @@
 package backend
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
-	"text/template"
 )
 
 const (
@@ -17,15 +14,25 @@ const (
 
 	// DefaultOpenAIModel is the default model for OpenAI.
 	DefaultOpenAIModel = "gpt-5o"
+
+	// DefaultOpenAIEmbeddingsURL is the default embeddings endpoint.
+	DefaultOpenAIEmbeddingsURL = "https://api.openai.com/v1/embeddings"
+
+	// DefaultOpenAIEmbeddingModel is the default model for Embed.
+	DefaultOpenAIEmbeddingModel = "text-embedding-3-small"
+
+	// openaiEmbedBatch is how many texts Embed sends per request.
+	openaiEmbedBatch = 256
 )
 
 // OpenAIBackend implements the Backend interface for the OpenAI API.
 type OpenAIBackend struct {
-	apiKey     string
-	baseURL    string
-	model      string
-	maxTokens  int
-	httpClient *http.Client
+	apiKey        string
+	baseURL       string
+	embeddingsURL string
+	model         string
+	maxTokens     int
+	httpClient    *http.Client
 }
 
 // OpenAIOption is a functional option for configuring OpenAIBackend.
@@ -45,6 +52,14 @@ func WithOpenAIBaseURL(url string) OpenAIOption {
 	}
 }
 
+// WithOpenAIEmbeddingsURL sets the endpoint Embed uses. Any server with an
+// OpenAI-compatible embeddings API works, such as a local Ollama.
+func WithOpenAIEmbeddingsURL(url string) OpenAIOption {
+	return func(b *OpenAIBackend) {
+		b.embeddingsURL = url
+	}
+}
+
 // WithOpenAIModel sets the model to use.
 func WithOpenAIModel(model string) OpenAIOption {
 	return func(b *OpenAIBackend) {
@@ -69,10 +84,11 @@ func WithOpenAIHTTPClient(client *http.Client) OpenAIOption {
 // NewOpenAIBackend creates a new OpenAI backend with the given options.
 func NewOpenAIBackend(opts ...OpenAIOption) *OpenAIBackend {
 	b := &OpenAIBackend{
-		baseURL:    DefaultOpenAIBaseURL,
-		model:      DefaultOpenAIModel,
-		maxTokens:  DefaultMaxTokens,
-		httpClient: http.DefaultClient,
+		baseURL:       DefaultOpenAIBaseURL,
+		embeddingsURL: DefaultOpenAIEmbeddingsURL,
+		model:         DefaultOpenAIModel,
+		maxTokens:     DefaultMaxTokens,
+		httpClient:    http.DefaultClient,
 	}
 
 	for _, opt := range opts {
@@ -89,9 +105,10 @@ func (b *OpenAIBackend) Name() string {
 
 // openaiRequest is the request body for the OpenAI API.
 type openaiRequest struct {
-	Model     string          `json:"model"`
-	MaxTokens int             `json:"max_tokens"`
-	Messages  []openaiMessage `json:"messages"`
+	Model       string          `json:"model"`
+	MaxTokens   int             `json:"max_tokens"`
+	Temperature *float64        `json:"temperature,omitempty"`
+	Messages    []openaiMessage `json:"messages"`
 }
 
 // openaiMessage represents a message in the OpenAI API.
@@ -136,12 +153,12 @@ func (b *OpenAIBackend) GenerateCommand(ctx context.Context, request *Request) (
 		return nil, ErrNoAPIKey
 	}
 
-	if request.Query == "" {
+	if request.Empty() {
 		return nil, ErrEmptyQuery
 	}
 
 	// Build system prompt
-	systemPrompt, err := b.buildSystemPrompt(request.Context)
+	systemPrompt, err := BuildSystemPrompt(request)
 	if err != nil {
 		return nil, fmt.Errorf("building system prompt: %w", err)
 	}
@@ -154,56 +171,22 @@ func (b *OpenAIBackend) GenerateCommand(ctx context.Context, request *Request) (
 
 	// Build request body
 	reqBody := openaiRequest{
-		Model:     model,
-		MaxTokens: b.maxTokens,
+		Model:       model,
+		MaxTokens:   maxTokensFor(request, b.maxTokens),
+		Temperature: request.Temperature,
 		Messages: []openaiMessage{
 			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: request.Query},
 		},
 	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling request: %w", err)
+	for _, m := range BuildMessages(request) {
+		reqBody.Messages = append(reqBody.Messages, openaiMessage{Role: m.Role, Content: m.Content})
 	}
 
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL, bytes.NewReader(jsonBody))
+	body, err := postJSON(ctx, b.httpClient, b.baseURL, map[string]string{
+		"Authorization": "Bearer " + b.apiKey,
+	}, reqBody, openaiErrorMessage)
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
-	}
-
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+b.apiKey)
-
-	// Execute request
-	resp, err := b.httpClient.Do(httpReq)
-	if err != nil {
-		// Check for context deadline exceeded
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("request timeout: %w", context.DeadlineExceeded)
-		}
-		if ctx.Err() == context.Canceled {
-			return nil, fmt.Errorf("request canceled: %w", context.Canceled)
-		}
-		return nil, fmt.Errorf("executing request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
-	}
-
-	// Handle non-2xx responses
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		var apiResp openaiResponse
-		if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
-			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiResp.Error.Message)
-		}
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
 	// Parse response
@@ -222,38 +205,77 @@ func (b *OpenAIBackend) GenerateCommand(ctx context.Context, request *Request) (
 		return nil, ErrEmptyResponse
 	}
 
-	return &Response{
-		Command:    command,
-		Model:      apiResp.Model,
-		TokensUsed: apiResp.Usage.TotalTokens,
-	}, nil
+	return decodeStructured(request, &Response{
+		Command:      command,
+		Model:        apiResp.Model,
+		TokensUsed:   apiResp.Usage.TotalTokens,
+		InputTokens:  apiResp.Usage.PromptTokens,
+		OutputTokens: apiResp.Usage.CompletionTokens,
+	}), nil
 }
 
-// buildSystemPrompt constructs the system prompt with optional context.
-func (b *OpenAIBackend) buildSystemPrompt(shellCtx *ShellContext) (string, error) {
-	if shellCtx == nil {
-		return SystemPromptNoContext, nil
+// openaiErrorMessage extracts the error message from an error response body.
+func openaiErrorMessage(body []byte) string {
+	var apiResp openaiResponse
+	if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
+		return apiResp.Error.Message
 	}
+	return ""
+}
 
-	tmpl, err := template.New("system").Parse(SystemPromptTemplate)
-	if err != nil {
-		return "", fmt.Errorf("parsing template: %w", err)
-	}
+// openaiEmbeddingRequest is the request body for the embeddings API.
+type openaiEmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
 
-	var buf bytes.Buffer
-	data := struct {
-		WorkingDir string
-		Shell      string
-		OS         string
-	}{
-		WorkingDir: shellCtx.WorkingDir,
-		Shell:      shellCtx.Shell,
-		OS:         shellCtx.OS,
-	}
+// openaiEmbeddingResponse is the response from the embeddings API.
+type openaiEmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Error *openaiError `json:"error,omitempty"`
+}
 
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("executing template: %w", err)
+// Embed returns embeddings of texts from the embeddings endpoint. A key is
+// only required by OpenAI's own endpoint; local servers usually have none.
+func (b *OpenAIBackend) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	if b.apiKey == "" && b.embeddingsURL == DefaultOpenAIEmbeddingsURL {
+		return nil, ErrNoAPIKey
+	}
+	if model == "" {
+		model = DefaultOpenAIEmbeddingModel
+	}
+	headers := map[string]string{}
+	if b.apiKey != "" {
+		headers["Authorization"] = "Bearer " + b.apiKey
 	}
 
-	return buf.String(), nil
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += openaiEmbedBatch {
+		batch := texts[start:min(start+openaiEmbedBatch, len(texts))]
+		body, err := postJSON(ctx, b.httpClient, b.embeddingsURL, headers,
+			openaiEmbeddingRequest{Model: model, Input: batch}, openaiErrorMessage)
+		if err != nil {
+			return nil, err
+		}
+
+		var apiResp openaiEmbeddingResponse
+		if err := json.Unmarshal(body, &apiResp); err != nil {
+			return nil, fmt.Errorf("parsing response: %w", err)
+		}
+		if len(apiResp.Data) != len(batch) {
+			return nil, fmt.Errorf("got %d embeddings for %d texts", len(apiResp.Data), len(batch))
+		}
+		out := make([][]float32, len(batch))
+		for _, d := range apiResp.Data {
+			if d.Index < 0 || d.Index >= len(batch) || len(d.Embedding) == 0 {
+				return nil, ErrEmptyResponse
+			}
+			out[d.Index] = d.Embedding
+		}
+		vectors = append(vectors, out...)
+	}
+	return vectors, nil
 }
@@ -1,14 +1,11 @@
 package backend
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
-	"text/template"
 )
 
 const (
@@ -114,9 +111,10 @@ func (b *OpenRouterBackend) Name() string {
 // openrouterRequest is the request body for the OpenRouter API.
 // OpenRouter uses OpenAI-compatible format.
 type openrouterRequest struct {
-	Model     string              `json:"model"`
-	MaxTokens int                 `json:"max_tokens"`
-	Messages  []openrouterMessage `json:"messages"`
+	Model       string              `json:"model"`
+	MaxTokens   int                 `json:"max_tokens"`
+	Temperature *float64            `json:"temperature,omitempty"`
+	Messages    []openrouterMessage `json:"messages"`
 }
 
 // openrouterMessage represents a message in the OpenRouter API.
@@ -161,12 +159,12 @@ func (b *OpenRouterBackend) GenerateCommand(ctx context.Context, request *Reques
 		return nil, ErrNoAPIKey
 	}
 
-	if request.Query == "" {
+	if request.Empty() {
 		return nil, ErrEmptyQuery
 	}
 
 	// Build system prompt
-	systemPrompt, err := b.buildSystemPrompt(request.Context)
+	systemPrompt, err := BuildSystemPrompt(request)
 	if err != nil {
 		return nil, fmt.Errorf("building system prompt: %w", err)
 	}
@@ -179,58 +177,24 @@ func (b *OpenRouterBackend) GenerateCommand(ctx context.Context, request *Reques
 
 	// Build request body (OpenAI-compatible format)
 	reqBody := openrouterRequest{
-		Model:     model,
-		MaxTokens: b.maxTokens,
+		Model:       model,
+		MaxTokens:   maxTokensFor(request, b.maxTokens),
+		Temperature: request.Temperature,
 		Messages: []openrouterMessage{
 			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: request.Query},
 		},
 	}
-
-	jsonBody, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, fmt.Errorf("marshaling request: %w", err)
-	}
-
-	// Create HTTP request
-	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL, bytes.NewReader(jsonBody))
-	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+	for _, m := range BuildMessages(request) {
+		reqBody.Messages = append(reqBody.Messages, openrouterMessage{Role: m.Role, Content: m.Content})
 	}
 
-	// Set headers
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Authorization", "Bearer "+b.apiKey)
-	httpReq.Header.Set("HTTP-Referer", b.httpReferer)
-	httpReq.Header.Set("X-Title", b.xTitle)
-
-	// Execute request
-	resp, err := b.httpClient.Do(httpReq)
+	body, err := postJSON(ctx, b.httpClient, b.baseURL, map[string]string{
+		"Authorization": "Bearer " + b.apiKey,
+		"HTTP-Referer":  b.httpReferer,
+		"X-Title":       b.xTitle,
+	}, reqBody, openrouterErrorMessage)
 	if err != nil {
-		// Check for context deadline exceeded
-		if ctx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("request timeout: %w", context.DeadlineExceeded)
-		}
-		if ctx.Err() == context.Canceled {
-			return nil, fmt.Errorf("request canceled: %w", context.Canceled)
-		}
-		return nil, fmt.Errorf("executing request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response: %w", err)
-	}
-
-	// Handle non-2xx responses
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		var apiResp openrouterResponse
-		if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
-			return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, apiResp.Error.Message)
-		}
-		return nil, fmt.Errorf("API error (%d): %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
 	// Parse response
@@ -249,38 +213,20 @@ func (b *OpenRouterBackend) GenerateCommand(ctx context.Context, request *Reques
 		return nil, ErrEmptyResponse
 	}
 
-	return &Response{
-		Command:    command,
-		Model:      apiResp.Model,
-		TokensUsed: apiResp.Usage.TotalTokens,
-	}, nil
+	return decodeStructured(request, &Response{
+		Command:      command,
+		Model:        apiResp.Model,
+		TokensUsed:   apiResp.Usage.TotalTokens,
+		InputTokens:  apiResp.Usage.PromptTokens,
+		OutputTokens: apiResp.Usage.CompletionTokens,
+	}), nil
 }
 
-// buildSystemPrompt constructs the system prompt with optional context.
-func (b *OpenRouterBackend) buildSystemPrompt(shellCtx *ShellContext) (string, error) {
-	if shellCtx == nil {
-		return SystemPromptNoContext, nil
-	}
-
-	tmpl, err := template.New("system").Parse(SystemPromptTemplate)
-	if err != nil {
-		return "", fmt.Errorf("parsing template: %w", err)
-	}
-
-	var buf bytes.Buffer
-	data := struct {
-		WorkingDir string
-		Shell      string
-		OS         string
-	}{
-		WorkingDir: shellCtx.WorkingDir,
-		Shell:      shellCtx.Shell,
-		OS:         shellCtx.OS,
-	}
-
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("executing template: %w", err)
+// openrouterErrorMessage extracts the error message from an error response body.
+func openrouterErrorMessage(body []byte) string {
+	var apiResp openrouterResponse
+	if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
+		return apiResp.Error.Message
 	}
-
-	return buf.String(), nil
+	return ""
 }
@@ -0,0 +1,235 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// taskPrompt holds the system prompt for a task with and without context.
+type taskPrompt struct {
+	noContext string
+	tmpl      *template.Template
+}
+
+// taskPrompts maps each task to its system prompt.
+var taskPrompts = map[Task]taskPrompt{
+	TaskCommand:   {SystemPromptNoContext, template.Must(template.New("system").Parse(SystemPromptTemplate))},
+	TaskScript:    {ScriptPromptNoContext, template.Must(template.New("script").Parse(ScriptPromptTemplate))},
+	TaskExplain:   {ExplainPromptNoContext, template.Must(template.New("explain").Parse(ExplainPromptTemplate))},
+	TaskReview:    {ReviewPromptNoContext, template.Must(template.New("review").Parse(ReviewPromptTemplate))},
+	TaskCron:      {CronPromptNoContext, template.Must(template.New("cron").Parse(CronPromptTemplate))},
+	TaskUnit:      {UnitPromptNoContext, template.Must(template.New("unit").Parse(UnitPromptTemplate))},
+	TaskContainer: {ContainerPromptNoContext, template.Must(template.New("container").Parse(ContainerPromptTemplate))},
+	TaskFilter:    {FilterPromptNoContext, template.Must(template.New("filter").Parse(FilterPromptTemplate))},
+	TaskRegex:     {RegexPromptNoContext, template.Must(template.New("regex").Parse(RegexPromptTemplate))},
+	TaskFix:       {FixPromptNoContext, template.Must(template.New("fix").Parse(FixPromptTemplate))},
+	TaskUndo:      {UndoPromptNoContext, template.Must(template.New("undo").Parse(UndoPromptTemplate))},
+	TaskComplete:  {CompletePromptNoContext, template.Must(template.New("complete").Parse(CompletePromptTemplate))},
+	TaskComment:   {CommentPromptNoContext, template.Must(template.New("comment").Parse(CommentPromptTemplate))},
+	TaskPlan:      {PlanPromptNoContext, template.Must(template.New("plan").Parse(PlanPromptTemplate))},
+}
+
+// customPrompt builds a taskPrompt from instructions that replace a
+// task's own. Like the built-in prompts, they may refer to the shell
+// context, which is empty when it is not available.
+func customPrompt(instructions string) (taskPrompt, error) {
+	// Parse the instructions alone first, so errors point into them.
+	noContext, err := template.New("custom").Parse(instructions)
+	if err != nil {
+		return taskPrompt{}, fmt.Errorf("parsing system prompt: %w", err)
+	}
+	tmpl, err := template.New("custom").Parse(instructions + contextPromptTemplate)
+	if err != nil {
+		return taskPrompt{}, fmt.Errorf("parsing system prompt: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := noContext.Execute(&buf, &ShellContext{}); err != nil {
+		return taskPrompt{}, fmt.Errorf("executing system prompt: %w", err)
+	}
+	return taskPrompt{noContext: buf.String(), tmpl: tmpl}, nil
+}
+
+// BuildSystemPrompt constructs the system prompt for req's task with
+// optional context. It is shared by all backends so that the prompt can
+// also be assembled without calling an API (token budgeting, dry runs).
+func BuildSystemPrompt(req *Request) (string, error) {
+	prompt, ok := taskPrompts[req.Task]
+	if !ok {
+		return "", fmt.Errorf("unknown task %q", req.Task)
+	}
+	if req.Structured && req.Task != TaskCommand {
+		return "", fmt.Errorf("task %q does not support structured output", req.Task)
+	}
+
+	if req.SystemPrompt != "" {
+		custom, err := customPrompt(req.SystemPrompt)
+		if err != nil {
+			return "", err
+		}
+		prompt = custom
+	}
+
+	var buf bytes.Buffer
+	if req.Context == nil {
+		buf.WriteString(prompt.noContext)
+	} else if err := prompt.tmpl.Execute(&buf, req.Context); err != nil {
+		return "", fmt.Errorf("executing template: %w", err)
+	}
+	if req.AppendPrompt != "" {
+		buf.WriteString("\n\n" + strings.TrimSpace(req.AppendPrompt))
+	}
+	if req.Structured {
+		buf.WriteString(StructuredOutputPrompt)
+	}
+
+	return buf.String(), nil
+}
+
+// ReviewQuery is the query for a TaskReview request about command, which
+// was generated for request.
+func ReviewQuery(request, command string) string {
+	return "Request: " + request + "\n\nGenerated command:\n" + command
+}
+
+// FixQuery is the query for a TaskFix request about command, which failed
+// with exit status status and wrote stderr. A zero status or empty stderr
+// means it is not known and is left out.
+func FixQuery(command string, status int, stderr string) string {
+	query := "Failed command:\n" + command
+	if status != 0 {
+		query += fmt.Sprintf("\n\nExit status: %d", status)
+	}
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		query += "\n\nEnd of its error output:\n" + stderr
+	}
+	return query
+}
+
+// UndoQuery is the query for a TaskUndo request about command.
+func UndoQuery(command string) string {
+	return "Command to reverse:\n" + command
+}
+
+// completeMessage is the user message for a TaskComplete request: the
+// partial command line and, if given, what it should do.
+func completeMessage(partial, query string) string {
+	message := "Command line so far:\n" + partial
+	if query = strings.TrimSpace(query); query != "" {
+		message += "\n\nWhat it should do: " + query
+	}
+	return message
+}
+
+// commentMessage is the user message for a TaskComment request: the shell
+// buffer whose comments are to become commands.
+func commentMessage(buffer string) string {
+	return "Shell buffer:\n" + buffer
+}
+
+// HelpCheckPrompt is appended to the system prompt of a second request
+// that checks draft, a command running tool, against tool's help from the
+// user's machine, which the request carries as a context section.
+func HelpCheckPrompt(tool, draft string) string {
+	return "A first draft of the command was:\n" + draft + "\n\n" +
+		"The context includes the help of " + tool + " as installed on this machine. " +
+		"Check every option of the draft against it: tools differ between versions and platforms, " +
+		"such as GNU and BSD sed. Output the corrected command, or the draft if it is right."
+}
+
+// LintFixPrompt is appended to the system prompt of a second request that
+// asks for draft to be corrected after shellcheck reported findings, one
+// per line, about it.
+func LintFixPrompt(draft, findings string) string {
+	return "A first draft of the answer was:\n" + draft + "\n\n" +
+		"shellcheck reported:\n" + findings + "\n\n" +
+		"Output the answer again with these problems fixed, and nothing else changed."
+}
+
+// RefusalReminder is appended to the system prompt of a request asked
+// again after the model answered it with a refusal in prose.
+const RefusalReminder = `A previous answer to this request was a refusal written in prose, which the user cannot run. ` +
+	`Output only a shell command. If the request truly cannot or should not be done, output exactly: echo "QCMD_ERROR[code=impossible]: <brief reason>"`
+
+// Message is a single chat message sent to the LLM.
+type Message struct {
+	Role    string
+	Content string
+}
+
+// BuildMessages returns the conversation messages for req, excluding the
+// system prompt. Few-shot examples are sent as prior user/assistant turns,
+// and clarifications as turns after the query. Backends convert these to
+// their wire format.
+func BuildMessages(req *Request) []Message {
+	messages := make([]Message, 0, 2*len(req.Examples)+1+2*len(req.Clarifications))
+	for _, ex := range req.Examples {
+		answer := ex.Command
+		if req.Structured {
+			// Examples were confirmed by the user, so they are certain.
+			certain := 1.0
+			b, _ := json.Marshal(structuredAnswer{Command: ex.Command, Confidence: &certain})
+			answer = string(b)
+		}
+		messages = append(messages,
+			Message{Role: "user", Content: ex.Query},
+			Message{Role: "assistant", Content: answer},
+		)
+	}
+	query := req.Query
+	switch req.Task {
+	case TaskComplete:
+		query = completeMessage(req.Partial, req.Query)
+	case TaskComment:
+		query = commentMessage(req.Query)
+	}
+	messages = append(messages, Message{Role: "user", Content: query})
+	for _, c := range req.Clarifications {
+		messages = append(messages,
+			Message{Role: "assistant", Content: c.Question},
+			Message{Role: "user", Content: c.Answer},
+		)
+	}
+	return messages
+}
+
+// structuredAnswer is the JSON object requested by StructuredOutputPrompt.
+type structuredAnswer struct {
+	Command    string   `json:"command"`
+	Confidence *float64 `json:"confidence"`
+}
+
+// decodeStructured replaces resp.Command with the command in a structured
+// answer and records the confidence. Answers that are not in the requested
+// format, e.g. a bare command, are left as they are.
+func decodeStructured(req *Request, resp *Response) *Response {
+	if !req.Structured {
+		return resp
+	}
+	// Tolerate code fences or prose around the object.
+	start, end := strings.IndexByte(resp.Command, '{'), strings.LastIndexByte(resp.Command, '}')
+	if start < 0 || end < start {
+		return resp
+	}
+	var answer structuredAnswer
+	if err := json.Unmarshal([]byte(resp.Command[start:end+1]), &answer); err != nil || strings.TrimSpace(answer.Command) == "" {
+		return resp
+	}
+	resp.Command = strings.TrimSpace(answer.Command)
+	if answer.Confidence != nil {
+		resp.Confidence = min(max(*answer.Confidence, 0), 1)
+		resp.HasConfidence = true
+	}
+	return resp
+}
+
+// maxTokensFor returns the response length limit for request: its own, or
+// else def, the backend's.
+func maxTokensFor(request *Request, def int) int {
+	if request.MaxTokens > 0 {
+		return request.MaxTokens
+	}
+	return def
+}
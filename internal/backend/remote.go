@@ -0,0 +1,294 @@
+package backend
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RemotePath is the endpoint of a `qcmd serve` gateway that the remote
+// backend calls. It passes requests to the gateway's own backend as they
+// are, so the client builds the prompt and checks the command itself.
+const RemotePath = "/v1/backend"
+
+// RemoteUserHeader is the request header naming the user a call to a
+// gateway is made for, so the gateway can attribute its cost.
+const RemoteUserHeader = "X-Qcmd-User"
+
+// RemoteBackend implements the Backend interface against another qcmd
+// running `qcmd serve`, which holds the provider keys for a team.
+type RemoteBackend struct {
+	baseURL    string
+	token      string
+	user       string
+	model      string
+	httpClient *http.Client
+}
+
+// RemoteOption is a functional option for configuring RemoteBackend.
+type RemoteOption func(*RemoteBackend)
+
+// WithRemoteURL sets the gateway's address, e.g. https://qcmd.example.com.
+func WithRemoteURL(url string) RemoteOption {
+	return func(b *RemoteBackend) {
+		b.baseURL = strings.TrimSuffix(url, "/")
+	}
+}
+
+// WithRemoteToken sets the gateway's serve token.
+func WithRemoteToken(token string) RemoteOption {
+	return func(b *RemoteBackend) {
+		b.token = token
+	}
+}
+
+// WithRemoteUser sets the name sent for cost attribution.
+func WithRemoteUser(user string) RemoteOption {
+	return func(b *RemoteBackend) {
+		b.user = user
+	}
+}
+
+// WithRemoteModel sets the model to ask the gateway for. Empty leaves the
+// choice to the gateway.
+func WithRemoteModel(model string) RemoteOption {
+	return func(b *RemoteBackend) {
+		b.model = model
+	}
+}
+
+// WithRemoteHTTPClient sets a custom HTTP client.
+func WithRemoteHTTPClient(client *http.Client) RemoteOption {
+	return func(b *RemoteBackend) {
+		b.httpClient = client
+	}
+}
+
+// NewRemoteBackend creates a new remote backend with the given options.
+func NewRemoteBackend(opts ...RemoteOption) *RemoteBackend {
+	b := &RemoteBackend{
+		httpClient: http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// Name returns the backend identifier.
+func (b *RemoteBackend) Name() string {
+	return "remote"
+}
+
+// RemoteRequest is the body of a request to RemotePath: a Request with
+// stable JSON names.
+type RemoteRequest struct {
+	Query          string                `json:"query"`
+	Partial        string                `json:"partial,omitempty"`
+	Context        *RemoteContext        `json:"context,omitempty"`
+	Model          string                `json:"model,omitempty"`
+	Examples       []RemoteExample       `json:"examples,omitempty"`
+	Task           Task                  `json:"task,omitempty"`
+	Structured     bool                  `json:"structured,omitempty"`
+	SystemPrompt   string                `json:"system_prompt,omitempty"`
+	AppendPrompt   string                `json:"append_prompt,omitempty"`
+	Temperature    *float64              `json:"temperature,omitempty"`
+	MaxTokens      int                   `json:"max_tokens,omitempty"`
+	Clarifications []RemoteClarification `json:"clarifications,omitempty"`
+}
+
+// RemoteContext is a ShellContext in a RemoteRequest.
+type RemoteContext struct {
+	WorkingDir string          `json:"cwd,omitempty"`
+	Shell      string          `json:"shell,omitempty"`
+	OS         string          `json:"os,omitempty"`
+	Sections   []RemoteSection `json:"sections,omitempty"`
+}
+
+// RemoteSection is a ContextSection in a RemoteRequest.
+type RemoteSection struct {
+	Name     string `json:"name"`
+	Content  string `json:"content"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// RemoteExample is an Example in a RemoteRequest.
+type RemoteExample struct {
+	Query   string `json:"query"`
+	Command string `json:"command"`
+}
+
+// RemoteClarification is a Clarification in a RemoteRequest.
+type RemoteClarification struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// RemoteResponse is the answer from RemotePath: a Response, or the reason
+// there is none in Error.
+type RemoteResponse struct {
+	Command      string   `json:"command,omitempty"`
+	Backend      string   `json:"backend,omitempty"`
+	Model        string   `json:"model,omitempty"`
+	TokensUsed   int      `json:"tokens_used,omitempty"`
+	InputTokens  int      `json:"input_tokens,omitempty"`
+	OutputTokens int      `json:"output_tokens,omitempty"`
+	Confidence   *float64 `json:"confidence,omitempty"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// NewRemoteRequest converts r for sending to a gateway.
+func NewRemoteRequest(r *Request) RemoteRequest {
+	out := RemoteRequest{
+		Query:        r.Query,
+		Partial:      r.Partial,
+		Model:        r.Model,
+		Task:         r.Task,
+		Structured:   r.Structured,
+		SystemPrompt: r.SystemPrompt,
+		AppendPrompt: r.AppendPrompt,
+		Temperature:  r.Temperature,
+		MaxTokens:    r.MaxTokens,
+	}
+	if r.Context != nil {
+		out.Context = &RemoteContext{WorkingDir: r.Context.WorkingDir, Shell: r.Context.Shell, OS: r.Context.OS}
+		for _, s := range r.Context.Sections {
+			out.Context.Sections = append(out.Context.Sections, RemoteSection(s))
+		}
+	}
+	for _, e := range r.Examples {
+		out.Examples = append(out.Examples, RemoteExample(e))
+	}
+	for _, c := range r.Clarifications {
+		out.Clarifications = append(out.Clarifications, RemoteClarification(c))
+	}
+	return out
+}
+
+// Request converts r back to the Request it was made from.
+func (r RemoteRequest) Request() *Request {
+	out := &Request{
+		Query:        r.Query,
+		Partial:      r.Partial,
+		Model:        r.Model,
+		Task:         r.Task,
+		Structured:   r.Structured,
+		SystemPrompt: r.SystemPrompt,
+		AppendPrompt: r.AppendPrompt,
+		Temperature:  r.Temperature,
+		MaxTokens:    r.MaxTokens,
+	}
+	if r.Context != nil {
+		out.Context = &ShellContext{WorkingDir: r.Context.WorkingDir, Shell: r.Context.Shell, OS: r.Context.OS}
+		for _, s := range r.Context.Sections {
+			out.Context.Sections = append(out.Context.Sections, ContextSection(s))
+		}
+	}
+	for _, e := range r.Examples {
+		out.Examples = append(out.Examples, Example(e))
+	}
+	for _, c := range r.Clarifications {
+		out.Clarifications = append(out.Clarifications, Clarification(c))
+	}
+	return out
+}
+
+// NewRemoteResponse converts resp, returned by backendName, for sending
+// back to a client.
+func NewRemoteResponse(backendName string, resp *Response) RemoteResponse {
+	out := RemoteResponse{
+		Command:      resp.Command,
+		Backend:      backendName,
+		Model:        resp.Model,
+		TokensUsed:   resp.TokensUsed,
+		InputTokens:  resp.InputTokens,
+		OutputTokens: resp.OutputTokens,
+	}
+	if resp.HasConfidence {
+		confidence := resp.Confidence
+		out.Confidence = &confidence
+	}
+	return out
+}
+
+// GenerateCommand sends the request to the gateway, which passes it to its
+// backend and returns the answer.
+func (b *RemoteBackend) GenerateCommand(ctx context.Context, request *Request) (*Response, error) {
+	if b.baseURL == "" {
+		return nil, errors.New("no gateway URL configured (remote.url)")
+	}
+
+	if request.Empty() {
+		return nil, ErrEmptyQuery
+	}
+
+	remoteReq := NewRemoteRequest(request)
+	if remoteReq.Model == "" {
+		remoteReq.Model = b.model
+	}
+
+	headers := map[string]string{}
+	if b.token != "" {
+		headers["Authorization"] = "Bearer " + b.token
+	}
+	if b.user != "" {
+		headers[RemoteUserHeader] = b.user
+	}
+	body, err := postJSON(ctx, b.httpClient, b.baseURL+RemotePath, headers, remoteReq, remoteErrorMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	var remoteResp RemoteResponse
+	if err := json.Unmarshal(body, &remoteResp); err != nil {
+		return nil, fmt.Errorf("parsing response: %w", err)
+	}
+	if strings.TrimSpace(remoteResp.Command) == "" {
+		return nil, ErrEmptyResponse
+	}
+
+	resp := &Response{
+		Command:      remoteResp.Command,
+		Model:        remoteResp.Model,
+		TokensUsed:   remoteResp.TokensUsed,
+		InputTokens:  remoteResp.InputTokens,
+		OutputTokens: remoteResp.OutputTokens,
+	}
+	if remoteResp.Confidence != nil {
+		resp.Confidence = *remoteResp.Confidence
+		resp.HasConfidence = true
+	}
+	return resp, nil
+}
+
+// remoteErrorMessage extracts the error message from an error response body.
+func remoteErrorMessage(body []byte) string {
+	var remoteResp RemoteResponse
+	if err := json.Unmarshal(body, &remoteResp); err == nil {
+		return remoteResp.Error
+	}
+	return ""
+}
+
+// TrustCertificates makes client, as returned by NewHTTPClient, trust only
+// the CA certificates in pemCerts, for servers with a private CA such as a
+// team's gateway.
+func TrustCertificates(client *http.Client, pemCerts []byte) error {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return errors.New("trusting certificates: client was not made by NewHTTPClient")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return errors.New("trusting certificates: no PEM certificates found")
+	}
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return nil
+}
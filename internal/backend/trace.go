@@ -0,0 +1,172 @@
+package backend
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timing is how long the stages of one HTTP request took, so slowness can
+// be told apart as network or model time. Stages that did not happen, such
+// as DNS and connecting on a reused connection, are zero.
+type Timing struct {
+	Host     string
+	Protocol string
+	Reused   bool
+	// Sent is the size of the request body as sent, after any
+	// compression.
+	Sent int64
+
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from finishing sending the request to the first
+	// byte of the response, which for providers includes generating the
+	// answer.
+	TTFB time.Duration
+	// Total is the time from the start of the request to reading the
+	// whole response.
+	Total time.Duration
+}
+
+// String formats t for verbose output.
+func (t Timing) String() string {
+	var parts []string
+	if !t.Reused {
+		parts = append(parts,
+			"dns "+formatStage(t.DNS),
+			"connect "+formatStage(t.Connect),
+			"tls "+formatStage(t.TLS))
+	}
+	parts = append(parts,
+		"ttfb "+formatStage(t.TTFB),
+		"total "+formatStage(t.Total))
+	if t.Sent > 0 {
+		parts = append(parts, fmt.Sprintf("sent %d bytes", t.Sent))
+	}
+	conn := "new connection"
+	if t.Reused {
+		conn = "reused connection"
+	}
+	if t.Protocol != "" {
+		conn = t.Protocol + ", " + conn
+	}
+	return fmt.Sprintf("%s: %s (%s)", t.Host, strings.Join(parts, ", "), conn)
+}
+
+// formatStage rounds d to a readable precision.
+func formatStage(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(10 * time.Millisecond).String()
+}
+
+// TraceTimings returns a copy of client that passes the Timing of each
+// request to report once its response body is closed. report may be called
+// from several goroutines if the client is.
+func TraceTimings(client *http.Client, report func(Timing)) *http.Client {
+	traced := *client
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	traced.Transport = &timingTransport{next: next, report: report}
+	return &traced
+}
+
+// timingTransport is the transport TraceTimings installs.
+type timingTransport struct {
+	next   http.RoundTripper
+	report func(Timing)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr := &requestTrace{start: time.Now()}
+	tr.timing.Host = req.URL.Host
+	tr.timing.Sent = req.ContentLength
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace()))
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	tr.mu.Lock()
+	tr.timing.Protocol = resp.Proto
+	tr.mu.Unlock()
+	resp.Body = &timedBody{ReadCloser: resp.Body, trace: tr, report: t.report}
+	return resp, nil
+}
+
+// requestTrace collects the Timing of one request from httptrace hooks,
+// which the transport may call from its own goroutines.
+type requestTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	timing Timing
+
+	dnsStart, connectStart, tlsStart, wrote time.Time
+}
+
+// clientTrace returns the hooks that fill in tr.
+func (tr *requestTrace) clientTrace() *httptrace.ClientTrace {
+	stage := func(f func()) {
+		tr.mu.Lock()
+		defer tr.mu.Unlock()
+		f()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { stage(func() { tr.dnsStart = time.Now() }) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { stage(func() { tr.timing.DNS = time.Since(tr.dnsStart) }) },
+		ConnectStart: func(string, string) {
+			stage(func() {
+				// Of several addresses tried, count from the first.
+				if tr.connectStart.IsZero() {
+					tr.connectStart = time.Now()
+				}
+			})
+		},
+		ConnectDone: func(string, string, error) {
+			stage(func() { tr.timing.Connect = time.Since(tr.connectStart) })
+		},
+		TLSHandshakeStart: func() { stage(func() { tr.tlsStart = time.Now() }) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			stage(func() { tr.timing.TLS = time.Since(tr.tlsStart) })
+		},
+		GotConn:      func(info httptrace.GotConnInfo) { stage(func() { tr.timing.Reused = info.Reused }) },
+		WroteRequest: func(httptrace.WroteRequestInfo) { stage(func() { tr.wrote = time.Now() }) },
+		GotFirstResponseByte: func() {
+			stage(func() {
+				if !tr.wrote.IsZero() {
+					tr.timing.TTFB = time.Since(tr.wrote)
+				}
+			})
+		},
+	}
+}
+
+// timedBody reports the request's Timing when the response body is closed.
+type timedBody struct {
+	io.ReadCloser
+	trace  *requestTrace
+	report func(Timing)
+	once   sync.Once
+}
+
+// Close implements io.Closer.
+func (b *timedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.trace.mu.Lock()
+		b.trace.timing.Total = time.Since(b.trace.start)
+		timing := b.trace.timing
+		b.trace.mu.Unlock()
+		b.report(timing)
+	})
+	return err
+}
@@ -2,19 +2,32 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/BurntSushi/toml"
+
+	"github.com/user/qcmd/internal/i18n"
+	"github.com/user/qcmd/internal/safety"
+	"github.com/user/qcmd/internal/tokens"
+	"github.com/user/qcmd/internal/xdg"
 )
 
 // DefaultConfigTOML is the default configuration template for `config init`.
 const DefaultConfigTOML = `# qcmd configuration file
 # See: https://github.com/user/qcmd
 
-# Default backend to use: anthropic | openai | openrouter
+# Default backend to use: anthropic | openai | openrouter | remote | mock
 backend = "anthropic"
 
 # Include shell context (pwd, shell, OS) in prompts
@@ -24,8 +37,121 @@ include_context = true
 # "auto" = try clipboard, then print
 # "clipboard" = always clipboard
 # "print" = always print
+# "auto-smart" = decide per query: zle from a widget, offer to run
+#                read-only commands, print the rest
 output_mode = "auto"
 
+[context]
+# Approximate token budget for the assembled prompt (0 = unlimited).
+# Lower-priority context (e.g. the directory listing) is trimmed first.
+token_budget = 2000
+# Include a listing of the working directory in prompts
+include_listing = false
+# Maximum number of directory entries to include
+listing_limit = 50
+# Maximum number of few-shot examples (from "qcmd feedback bad --correct")
+# to include, most recent first (0 = none)
+max_examples = 5
+# Maximum number of related past corrections (commands edited before
+# running in --exec mode) to include (0 = none)
+max_corrections = 3
+# Read your zsh-abbr or fish abbreviations so prompts can reuse them and
+# generated commands that an abbreviation would rewrite are flagged
+include_abbreviations = false
+# Send your shell aliases (passed by the shell integration) to the model so
+# it can use them; they are also expanded before the safety check.
+# "none", "names", or "expansions" (with credentials redacted)
+include_aliases = "none"
+# Include the current kubectl context, namespace and pods, and running
+# docker containers, when kubectl or docker is installed
+include_containers = false
+# Maximum number of pods and of containers to include
+containers_limit = 20
+# Milliseconds to wait for context sources, which run concurrently, before
+# sending the request without the slow ones (0 = wait for all). The
+# container probe, which runs external tools, gets up to two seconds.
+gather_timeout_ms = 50
+# On macOS and the BSDs, check whether sed, grep, find, date and other
+# tools are the GNU or BSD versions, whose options differ (sed -i ''), and
+# tell the model. Takes up to half a second the first time; the results
+# are cached until the tools change.
+detect_tool_flavors = true
+# Tools whose installed versions are included, so generated commands avoid
+# options they lack. Each is asked for its version (at most one second, run
+# concurrently), which is cached until the tool changes. Empty = none.
+tool_versions = ["git", "docker", "kubectl", "python3", "node"]
+# After generating a command, read the --help output (or man page) of the
+# program it runs on this machine and ask the model to check the flags
+# against it, e.g. BSD vs GNU sed -i. This makes a second request.
+include_help = false
+# Maximum bytes of help to include (0 = all)
+help_limit = 3000
+
+[review]
+# Ask a second (ideally cheap) model whether each generated command matches
+# the request and is safe, and show its verdict as a note. The note never
+# blocks or changes the command.
+enabled = false
+# Backend for the reviewer (empty = same as the main backend)
+backend = ""
+# Model for the reviewer (empty = the reviewer backend's configured model)
+model = ""
+# Seconds to wait for the verdict before giving up on it
+timeout_seconds = 10
+
+[budget]
+# Monthly limit in USD on the estimated spend of paid backends, tracked in
+# qcmd.db in the data directory (0 = no limit)
+monthly_usd = 0.0
+# What to do once the limit is reached: "warn" or "block"
+action = "warn"
+# Free backend to suggest once the limit is reached (e.g. "mock")
+local_backend = ""
+
+[history]
+# Record generated commands locally (never synced or uploaded)
+enabled = true
+# Also record the exit status and last lines of error output of commands
+# run with --exec, so "qcmd fix" with no arguments can fix the last failure.
+# Error output can contain secrets; it stays in the local history file.
+capture_exec = false
+# After generating a command that changes something, also suggest a command
+# that reverses it, shown by "qcmd undo". Costs a second request unless the
+# command is a simple one such as mv or git stash.
+suggest_undo = false
+# When a command is the same as one generated for a similar query in the
+# last dedupe_days days, say so ("same as #42 from yesterday") instead of
+# recording it again. 0 records every command.
+dedupe_days = 7
+
+[index]
+# Let "qcmd recall" match history and snippets by meaning, not just words,
+# using an embedding model. Build the index with "qcmd index rebuild" and
+# rerun it to pick up new commands. Indexing sends your qcmd history and
+# snippets to the embedding endpoint.
+enabled = false
+# Embedding backend: openai | mock (mock only matches shared words)
+backend = "openai"
+# Embedding model
+model = "text-embedding-3-small"
+# OpenAI-compatible embeddings endpoint (empty = OpenAI's). A local server
+# such as Ollama (http://localhost:11434/v1/embeddings) keeps everything on
+# this machine and needs no API key.
+url = ""
+# Lowest cosine similarity (0 to 1) for a match by meaning
+min_similarity = 0.45
+
+[serve]
+# Shared secret "qcmd serve --listen" requires from clients, sent as
+# "Authorization: Bearer TOKEN" (or use QCMD_SERVE_TOKEN env var). Without
+# one, --listen is refused, even on loopback. The Unix socket is limited to
+# your user instead.
+token = ""
+# Generations the daemon runs at once (0 = no limit). Further requests wait
+# for a slot, within timeout_seconds, so busy editor integrations cannot
+# run into the provider's rate limits.
+max_concurrent = 4
+
 [anthropic]
 # API key (or use ANTHROPIC_API_KEY env var)
 api_key = ""
@@ -44,34 +170,373 @@ api_key = ""
 # Model to use (any model available on OpenRouter)
 model = "anthropic/claude-haiku-4-5-20251001"
 
+[remote]
+# A team's "qcmd serve" gateway, which holds the provider keys
+# (backend = "remote"). Use https unless the gateway is on this machine.
+url = ""
+# The gateway's serve.token (or use QCMD_REMOTE_TOKEN env var)
+token = ""
+# Name the gateway attributes your usage to (default: $USER)
+user = ""
+# Model to ask the gateway for (default: the gateway's)
+model = ""
+# PEM file of the CA that signed the gateway's certificate, if it is not
+# publicly trusted
+ca_file = ""
+
+[mock]
+# Offline backend for tests, demos and air-gapped use (backend = "mock").
+# Rules are tried in order; the first whose regex matches the query wins.
+# Commands may reference capture groups ($1). Without rules, a few
+# built-in demo rules are used.
+# fallback = 'echo "QCMD_ERROR: no mock rule matches this query"'
+# [[mock.rules]]
+# match = "(?i)list.*files"
+# command = "ls -la"
+
+[sync]
+# Git remote holding team-shared snippets and favorites (empty = disabled).
+# Used by "qcmd sync push" and "qcmd sync pull"; history is never synced.
+remote = ""
+# Branch to pull from and push to
+branch = "main"
+
+[update]
+# Have "qcmd version" look up the latest release, as --check does; nothing
+# else in qcmd contacts the release feed
+check = false
+# Where to look: GitHub's latest-release API for qcmd, or a mirror that
+# answers the same way
+url = "https://api.github.com/repos/user/qcmd/releases/latest"
+
 [safety]
 # Block dangerous commands from being injected (still prints them)
 block_dangerous = true
-# Show warnings for cautionary commands
+# Show warnings for cautionary commands, and for dangerous ones that are
+# not blocked
 show_warnings = true
+# Honor --no-safety. Off by default so safety checks cannot be skipped by
+# accident; an administrator's /etc/qcmd/policy.toml overrides this.
+# Every run with --no-safety is recorded in the audit log.
+allow_disable = false
+# Pattern categories to switch off, e.g. ["kubernetes", "database"] on a
+# throwaway dev cluster, or ["package"] if global-install warnings are noise.
+# Built-in categories: filesystem, network, system, obfuscation, cloud,
+# kubernetes, infrastructure, database, git, package.
+disabled_categories = []
+# Categories whose cautionary commands are passed without a warning, e.g.
+# ["system"] if you use sudo all the time. They are still checked, and
+# dangerous ones still blocked; only the caution banner is left out.
+quiet_categories = []
+# After you run a cautionary command with --exec, or a dangerous one with
+# block_dangerous = false, the identical command is not warned about again
+# for this long (0 = always warn). "qcmd safety forget" clears the list.
+trust_seconds = 3600
+# Every pattern has a severity score from 1 to 100. Matches scoring at least
+# block_threshold are dangerous, at least warn_threshold cautionary, and
+# anything lower is allowed silently. Raise warn_threshold to 55 to stop
+# warning about plain sudo; raise block_threshold to 95 to only block the
+# most destructive commands.
+warn_threshold = 50
+block_threshold = 90
+# Over SSH, reboot and shutdown commands are dangerous on hosts whose name
+# matches one of these patterns (wildcards: * and ?), cautionary elsewhere.
+# production_hosts = ["*prod*", "*prd*"]
+# When running as root, or when sudo credentials are cached (for what
+# commands run through sudo run), cautionary filesystem and system commands
+# are treated as dangerous and a banner is shown.
+strict_as_root = true
+# In zle mode, commands that still have placeholders such as <bucket-name>,
+# {{name}} or YOUR_TOKEN are printed instead of inserted at the prompt, so
+# they cannot be run by pressing Enter. Set to false to insert them with the
+# placeholders highlighted.
+block_placeholders = true
+# Long one-liners and deep pipelines are where mistakes hide. Commands
+# longer than max_length characters, or with more than max_pipeline
+# commands in one pipeline, get a "review carefully" banner and are printed
+# instead of inserted in zle mode (0 = no limit).
+max_length = 400
+max_pipeline = 6
+# Custom patterns, tried before the built-in ones. Check them with
+# "qcmd safety test --file cases.toml".
+# [[safety.patterns]]
+# match = "kubectl .*--context[= ]prod"
+# level = "danger"            # danger | caution
+# score = 95                  # optional; defaults to 90 for danger, 50 for caution
+# description = "Command targets the production cluster"
+# hint = "Try it against staging first"
+# category = "custom"
+
+[advice]
+# Suggest how to run commands that may take a long time, such as find /,
+# dd, rsync or creating a large tar archive: with a progress display, or in
+# tmux or with nohup. Tips are printed to stderr and never block anything.
+long_running = true
+# Before showing a command that copies files or creates an archive (cp,
+# rsync, tar, zip, dd), add up the size of what it reads and warn if it is
+# more than the free space where it writes. Reading the sizes of a large
+# tree takes up to half a second.
+disk_space = false
+# Warn about files and directories a command reads that do not exist in the
+# working directory, which the model may have made up, e.g. "cat
+# config/app.yml" where there is no config/app.yml. Only commands known to
+# read files (cat, grep, cp, rm, ...) are checked.
+missing_paths = false
+
+[lint]
+# Run shellcheck, if it is installed, on generated commands and scripts and
+# print what it finds to stderr. It runs for up to two seconds.
+shellcheck = true
+# Without shellcheck, or with shellcheck = false, run a few built-in
+# checks instead: unquoted variables in rm, mv and cp operands, cd whose
+# failure is not handled, and backticks
+builtin = true
+# Least serious findings to print: "error", "warning", "info" or "style"
+severity = "warning"
+# Checks to skip, e.g. ["SC2086"]; the built-in checks use shellcheck's
+# codes
+exclude = []
+# When shellcheck finds an error, send the command back to the model once,
+# with the findings, for a corrected version. This costs a second request.
+fix_errors = false
+
+[style]
+# How generated commands should be written. Each preference set here is
+# added to the instructions sent to the model.
+# Prefer long options (--recursive) to short ones (-r)
+long_flags = false
+# Prefer POSIX commands and options to GNU or BSD extensions
+posix = false
+# Write for a POSIX sh: no [[ ]], arrays, <(...) and other bash or zsh
+# syntax. Implied when your shell is sh or dash, and for crontab lines,
+# which cron runs with sh. Commands that use such syntax anyway are warned
+# about.
+avoid_bashisms = false
+# Instructions for the model on each OS, keyed by the name Go gives it
+# ("darwin", "linux", "freebsd"). They replace the built-in ones, which
+# describe the tools and package managers of macOS and the BSDs; set an
+# empty string to send none.
+# [style.os]
+# darwin = "GNU coreutils are installed with a g prefix (gsed, gdate)."
 
 [editor]
 # Override $EDITOR/$VISUAL (uncomment to use)
 # editor = "nvim"
 
+[ui]
+# Language of warnings, prompts and help: "auto" (from LC_ALL, LC_MESSAGES
+# or LANG), "en" or "es"
+language = "auto"
+
 [advanced]
 # API call timeout in seconds
 timeout_seconds = 30
+# Seconds to wait for a connection to the API and for the TLS handshake, so
+# an unreachable provider fails fast (0 = only timeout_seconds applies)
+connect_timeout_seconds = 2
+tls_timeout_seconds = 3
+# Seconds from sending a request to the response headers; providers send
+# them only once the command is generated (0 = only timeout_seconds applies)
+response_header_timeout_seconds = 0
+# Gzip request bodies of at least this many bytes, for large contexts on
+# slow links; not every provider accepts it (0 = never)
+compress_requests_over = 0
 # Maximum tokens for LLM response
 max_tokens = 512
+# Ask the model to answer in JSON with its confidence in the command
+structured_output = false
+# With structured_output, commands the model is less confident about than
+# this (0 to 1) are printed instead of inserted by the shell integration
+min_confidence = 0.6
+# Ask once more, with a reminder to answer with a command, when the model
+# answers with a refusal in prose ("I can't help with that") instead
+retry_refusals = false
+# When the model needs a detail the query left out, ask for it on the
+# terminal and send the answer, up to this many times (0 = exit with code 9)
+clarify_rounds = 2
+# Maximum query length in bytes (0 = unlimited)
+max_query_length = 10000
+# What to do with a --query-file or --clipboard query over max_query_length:
+# "error" = refuse it
+# "truncate" = keep its start and end (useful for pasted logs)
+long_query = "error"
+# Circuit breaker: after breaker_failures timeouts, network errors or server
+# errors from a backend within breaker_window_seconds, skip it for
+# breaker_cooldown_seconds instead of waiting on it (0 = disabled)
+breaker_failures = 3
+breaker_window_seconds = 300
+breaker_cooldown_seconds = 60
+# Backends to use, in order, while the configured one is skipped; with none,
+# qcmd fails at once
+fallback_backends = []
+# Models with larger context windows to retry with, in order, when the
+# prompt is too long for the configured one even without optional context
+context_fallback_models = []
+# Crash reports, saved in the state directory if qcmd panics, leave out what
+# you typed; set this to keep the query and flag values in them
+crash_reports_include_query = false
+
+# Model prices in USD per million tokens, for "qcmd cost estimate" and
+# --dry-run. Entries add to or override the built-in table; a key matches
+# models it prefixes.
+# [prices]
+# "gpt-5o" = { input = 1.25, output = 10.0 }
+
+# Adjustments applied whenever a model is used, for models that need
+# instructions repeated or different sampling. A key matches models it
+# prefixes; the longest match wins.
+# [models."gpt-5o"]
+# prompt_suffix = "Never use markdown."   # added to the system prompt
+# temperature = 0                         # provider default if unset
+# max_tokens = 256                        # overrides advanced.max_tokens
 `
 
 // Config represents the full configuration for qcmd.
 type Config struct {
-	Backend        string          `toml:"backend"`
-	IncludeContext bool            `toml:"include_context"`
-	OutputMode     string          `toml:"output_mode"`
-	Anthropic      AnthropicConfig `toml:"anthropic"`
-	OpenAI         OpenAIConfig    `toml:"openai"`
+	Backend        string           `toml:"backend"`
+	IncludeContext bool             `toml:"include_context"`
+	OutputMode     string           `toml:"output_mode"`
+	Context        ContextConfig    `toml:"context"`
+	Review         ReviewConfig     `toml:"review"`
+	Budget         BudgetConfig     `toml:"budget"`
+	History        HistoryConfig    `toml:"history"`
+	Index          IndexConfig      `toml:"index"`
+	Serve          ServeConfig      `toml:"serve"`
+	Anthropic      AnthropicConfig  `toml:"anthropic"`
+	OpenAI         OpenAIConfig     `toml:"openai"`
 	OpenRouter     OpenRouterConfig `toml:"openrouter"`
-	Safety         SafetyConfig    `toml:"safety"`
-	Editor         EditorConfig    `toml:"editor"`
-	Advanced       AdvancedConfig  `toml:"advanced"`
+	Remote         RemoteConfig     `toml:"remote"`
+	Mock           MockConfig       `toml:"mock"`
+	Sync           SyncConfig       `toml:"sync"`
+	Update         UpdateConfig     `toml:"update"`
+	Safety         SafetyConfig     `toml:"safety"`
+	Advice         AdviceConfig     `toml:"advice"`
+	Lint           LintConfig       `toml:"lint"`
+	Style          StyleConfig      `toml:"style"`
+	Editor         EditorConfig     `toml:"editor"`
+	UI             UIConfig         `toml:"ui"`
+	Advanced       AdvancedConfig   `toml:"advanced"`
+
+	// Prices adds or overrides model prices (USD per million tokens) used
+	// for cost estimates; see tokens.DefaultPrices.
+	Prices map[string]tokens.Price `toml:"prices"`
+
+	// Models holds per-model adjustments, keyed by model name or prefix;
+	// see ModelSettings.
+	Models map[string]ModelConfig `toml:"models"`
+}
+
+// ModelConfig adjusts requests to one model or family of models.
+type ModelConfig struct {
+	// PromptSuffix is appended to the system prompt.
+	PromptSuffix string `toml:"prompt_suffix"`
+	// Temperature, if set, is sent instead of the provider's default.
+	Temperature *float64 `toml:"temperature"`
+	// MaxTokens, if not 0, overrides advanced.max_tokens.
+	MaxTokens int `toml:"max_tokens"`
+}
+
+// ContextConfig holds configuration for optional prompt context sources.
+type ContextConfig struct {
+	TokenBudget    int  `toml:"token_budget"`
+	IncludeListing bool `toml:"include_listing"`
+	ListingLimit   int  `toml:"listing_limit"`
+	MaxExamples    int  `toml:"max_examples"`
+	MaxCorrections int  `toml:"max_corrections"`
+
+	// IncludeAbbreviations reads the user's shell abbreviations into the
+	// prompt and warns when a generated command collides with one.
+	IncludeAbbreviations bool `toml:"include_abbreviations"`
+
+	// IncludeAliases controls what the prompt learns about the user's
+	// aliases: "none", "names", or "expansions" (credentials redacted).
+	IncludeAliases string `toml:"include_aliases"`
+
+	// IncludeContainers adds the current Kubernetes context and docker
+	// containers, at most ContainersLimit names of each.
+	IncludeContainers bool `toml:"include_containers"`
+	ContainersLimit   int  `toml:"containers_limit"`
+
+	// GatherTimeoutMs bounds how long context sources may delay the
+	// request; sources that take longer are left out. 0 waits for all.
+	GatherTimeoutMs int `toml:"gather_timeout_ms"`
+
+	// DetectToolFlavors tells the model which tools are GNU and which BSD,
+	// on systems that ship BSD tools.
+	DetectToolFlavors bool `toml:"detect_tool_flavors"`
+
+	// ToolVersions lists the tools whose installed versions are included.
+	ToolVersions []string `toml:"tool_versions"`
+
+	// IncludeHelp checks generated commands against the local help of
+	// the program they run, in a second request. At most HelpLimit bytes
+	// of help are sent.
+	IncludeHelp bool `toml:"include_help"`
+	HelpLimit   int  `toml:"help_limit"`
+}
+
+// GatherTimeout returns the configured context gathering timeout as a
+// time.Duration.
+func (c *ContextConfig) GatherTimeout() time.Duration {
+	return time.Duration(c.GatherTimeoutMs) * time.Millisecond
+}
+
+// ReviewConfig holds configuration for the optional second-model review
+// of generated commands.
+type ReviewConfig struct {
+	Enabled bool `toml:"enabled"`
+	// Backend and Model default to the main backend and its model.
+	Backend        string `toml:"backend"`
+	Model          string `toml:"model"`
+	TimeoutSeconds int    `toml:"timeout_seconds"`
+}
+
+// Timeout returns the configured review timeout as a time.Duration.
+func (c *ReviewConfig) Timeout() time.Duration {
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// BudgetConfig holds the monthly spending limit for paid backends.
+type BudgetConfig struct {
+	// MonthlyUSD is the limit on estimated spend per calendar month; 0
+	// disables it.
+	MonthlyUSD float64 `toml:"monthly_usd"`
+	// Action is "warn" or "block".
+	Action string `toml:"action"`
+	// LocalBackend is suggested once the limit is reached.
+	LocalBackend string `toml:"local_backend"`
+}
+
+// HistoryConfig holds configuration for the local command history.
+type HistoryConfig struct {
+	Enabled bool `toml:"enabled"`
+	// CaptureExec records the exit status and stderr tail of commands run
+	// with --exec.
+	CaptureExec bool `toml:"capture_exec"`
+	// SuggestUndo stores a command reversing each mutating command.
+	SuggestUndo bool `toml:"suggest_undo"`
+	// DedupeDays is how far back a repeated command is found instead of
+	// being recorded again; 0 turns this off.
+	DedupeDays int `toml:"dedupe_days"`
+}
+
+// IndexConfig holds configuration for the embedding index used by recall.
+type IndexConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Backend string `toml:"backend"`
+	Model   string `toml:"model"`
+	// URL is an OpenAI-compatible embeddings endpoint; empty means OpenAI's.
+	URL           string  `toml:"url"`
+	MinSimilarity float64 `toml:"min_similarity"`
+}
+
+// ServeConfig holds configuration for `qcmd serve`.
+type ServeConfig struct {
+	// Token is the bearer token clients of the TCP listener must send.
+	Token string `toml:"token"`
+	// MaxConcurrent is how many generations may run at once; 0 means no
+	// limit.
+	MaxConcurrent int `toml:"max_concurrent"`
 }
 
 // AnthropicConfig holds Anthropic-specific configuration.
@@ -92,10 +557,165 @@ type OpenRouterConfig struct {
 	Model  string `toml:"model"`
 }
 
+// RemoteConfig holds configuration for the backend that calls another
+// qcmd's `qcmd serve` gateway.
+type RemoteConfig struct {
+	URL    string `toml:"url"`
+	Token  string `toml:"token"`
+	User   string `toml:"user"`
+	Model  string `toml:"model"`
+	CAFile string `toml:"ca_file"`
+}
+
+// MockConfig holds configuration for the offline mock backend.
+type MockConfig struct {
+	Fallback string           `toml:"fallback"`
+	Rules    []MockRuleConfig `toml:"rules"`
+}
+
+// MockRuleConfig maps a query regex to a canned command.
+type MockRuleConfig struct {
+	Match   string `toml:"match"`
+	Command string `toml:"command"`
+}
+
+// SyncConfig holds configuration for sharing snippets through a git remote.
+type SyncConfig struct {
+	Remote string `toml:"remote"`
+	Branch string `toml:"branch"`
+}
+
+// UpdateConfig holds configuration for checking for a newer release.
+type UpdateConfig struct {
+	// Check makes `qcmd version` look up the latest release, as its
+	// --check flag does.
+	Check bool   `toml:"check"`
+	URL   string `toml:"url"`
+}
+
 // SafetyConfig holds safety check configuration.
 type SafetyConfig struct {
-	BlockDangerous bool `toml:"block_dangerous"`
-	ShowWarnings   bool `toml:"show_warnings"`
+	BlockDangerous     bool                  `toml:"block_dangerous"`
+	ShowWarnings       bool                  `toml:"show_warnings"`
+	AllowDisable       bool                  `toml:"allow_disable"`
+	DisabledCategories []string              `toml:"disabled_categories"`
+	QuietCategories    []string              `toml:"quiet_categories"`
+	WarnThreshold      int                   `toml:"warn_threshold"`
+	BlockThreshold     int                   `toml:"block_threshold"`
+	Patterns           []SafetyPatternConfig `toml:"patterns"`
+	// ProductionHosts replaces safety.DefaultProductionHosts when set.
+	ProductionHosts []string `toml:"production_hosts"`
+	// StrictAsRoot escalates cautionary filesystem and system commands to
+	// dangerous when they run with superuser rights.
+	StrictAsRoot bool `toml:"strict_as_root"`
+	// TrustSeconds is how long a cautionary command run with --exec goes
+	// without a warning when generated again.
+	TrustSeconds int `toml:"trust_seconds"`
+	// BlockPlaceholders prints commands with unfilled placeholders instead
+	// of inserting them in zle mode.
+	BlockPlaceholders bool `toml:"block_placeholders"`
+	// MaxLength and MaxPipeline, if not 0, bound the length of a command in
+	// characters and the number of commands in one of its pipelines before
+	// it is flagged for careful review.
+	MaxLength   int `toml:"max_length"`
+	MaxPipeline int `toml:"max_pipeline"`
+}
+
+// Warns reports whether cautionary commands in category are warned about:
+// warnings are shown and the category is not in QuietCategories.
+func (c *SafetyConfig) Warns(category string) bool {
+	if !c.ShowWarnings {
+		return false
+	}
+	for _, quiet := range c.QuietCategories {
+		if quiet == category {
+			return false
+		}
+	}
+	return true
+}
+
+// SafetyPatternConfig defines a custom safety pattern.
+type SafetyPatternConfig struct {
+	Match       string `toml:"match"`
+	Level       string `toml:"level"`
+	Description string `toml:"description"`
+	Category    string `toml:"category"`
+	Score       int    `toml:"score"`
+	Hint        string `toml:"hint"`
+}
+
+// CustomPatterns compiles the configured custom safety patterns. Patterns
+// without a category get the "custom" category.
+func (c *SafetyConfig) CustomPatterns() ([]safety.Pattern, error) {
+	patterns := make([]safety.Pattern, 0, len(c.Patterns))
+	for i, p := range c.Patterns {
+		re, err := regexp.Compile(p.Match)
+		if err != nil {
+			return nil, fmt.Errorf("safety.patterns[%d]: invalid match regex: %w", i, err)
+		}
+		level, err := safety.ParseLevel(p.Level)
+		if err != nil || level == safety.Safe {
+			return nil, fmt.Errorf("safety.patterns[%d]: level must be danger or caution", i)
+		}
+		if p.Score < 0 || p.Score > 100 {
+			return nil, fmt.Errorf("safety.patterns[%d]: score must be between 1 and 100", i)
+		}
+		category := p.Category
+		if category == "" {
+			category = "custom"
+		}
+		description := p.Description
+		if description == "" {
+			description = "Matches custom pattern " + p.Match
+		}
+		patterns = append(patterns, safety.Pattern{
+			Regex:       re,
+			Level:       level,
+			Score:       p.Score,
+			Description: description,
+			Category:    category,
+			Hint:        p.Hint,
+		})
+	}
+	return patterns, nil
+}
+
+// AdviceConfig holds the tips shown next to generated commands.
+type AdviceConfig struct {
+	// LongRunning suggests progress displays and detaching for commands
+	// that may take a long time.
+	LongRunning bool `toml:"long_running"`
+	// DiskSpace warns when a command copying or archiving files writes
+	// more than the destination has room for.
+	DiskSpace bool `toml:"disk_space"`
+	// MissingPaths warns about paths a command reads that do not exist.
+	MissingPaths bool `toml:"missing_paths"`
+}
+
+// lintCodeRegex matches shellcheck check codes.
+var lintCodeRegex = regexp.MustCompile(`^SC[0-9]{4}$`)
+
+// LintConfig holds configuration for linting generated commands.
+type LintConfig struct {
+	Shellcheck bool `toml:"shellcheck"`
+	// Builtin runs qcmd's own checks where shellcheck does not run.
+	Builtin bool `toml:"builtin"`
+	// Severity is the least serious level of finding reported.
+	Severity string   `toml:"severity"`
+	Exclude  []string `toml:"exclude"`
+	// FixErrors asks the model for a corrected command when shellcheck
+	// finds an error.
+	FixErrors bool `toml:"fix_errors"`
+}
+
+// StyleConfig holds preferences for how generated commands are written.
+type StyleConfig struct {
+	LongFlags     bool `toml:"long_flags"`
+	POSIX         bool `toml:"posix"`
+	AvoidBashisms bool `toml:"avoid_bashisms"`
+	// OS replaces backend.OSPrompts for the OSes it names.
+	OS map[string]string `toml:"os"`
 }
 
 // EditorConfig holds editor configuration.
@@ -103,10 +723,91 @@ type EditorConfig struct {
 	Editor string `toml:"editor"`
 }
 
+// UIConfig holds configuration for how qcmd talks to the user.
+type UIConfig struct {
+	// Language is a code from i18n.Languages, or "auto" to follow the
+	// locale.
+	Language string `toml:"language"`
+}
+
 // AdvancedConfig holds advanced configuration options.
 type AdvancedConfig struct {
 	TimeoutSeconds int `toml:"timeout_seconds"`
 	MaxTokens      int `toml:"max_tokens"`
+
+	// TimeoutSeconds bounds a whole API call; these bound its stages, so
+	// connection failures surface quickly. 0 leaves a stage unbounded but
+	// for TimeoutSeconds.
+	ConnectTimeoutSeconds        int `toml:"connect_timeout_seconds"`
+	TLSTimeoutSeconds            int `toml:"tls_timeout_seconds"`
+	ResponseHeaderTimeoutSeconds int `toml:"response_header_timeout_seconds"`
+
+	// CompressRequestsOver is the request size, in bytes, from which
+	// request bodies are gzipped; 0 disables compression.
+	CompressRequestsOver int `toml:"compress_requests_over"`
+
+	// StructuredOutput asks for the model's confidence along with the
+	// command; below MinConfidence the command is not injected.
+	StructuredOutput bool    `toml:"structured_output"`
+	MinConfidence    float64 `toml:"min_confidence"`
+
+	// RetryRefusals asks again, once, when the model answers with a
+	// refusal in prose rather than a command or the error sentinel.
+	RetryRefusals bool `toml:"retry_refusals"`
+
+	// ClarifyRounds bounds how many times the model's questions about an
+	// ambiguous query are put to the user on a terminal; 0 never asks.
+	ClarifyRounds int `toml:"clarify_rounds"`
+
+	// MaxQueryLength caps queries, in bytes; 0 means no limit. LongQuery
+	// says whether longer file and clipboard queries are refused ("error")
+	// or shortened to their start and end ("truncate").
+	MaxQueryLength int    `toml:"max_query_length"`
+	LongQuery      string `toml:"long_query"`
+
+	// BreakerFailures outages of a backend within BreakerWindowSeconds
+	// make qcmd skip it for BreakerCooldownSeconds, using the first of
+	// FallbackBackends that is not skipped too; 0 disables this.
+	BreakerFailures        int      `toml:"breaker_failures"`
+	BreakerWindowSeconds   int      `toml:"breaker_window_seconds"`
+	BreakerCooldownSeconds int      `toml:"breaker_cooldown_seconds"`
+	FallbackBackends       []string `toml:"fallback_backends"`
+
+	// ContextFallbackModels are tried in order, with the same backend,
+	// when a prompt is too long for the model even after dropping
+	// optional context.
+	ContextFallbackModels []string `toml:"context_fallback_models"`
+
+	// CrashReportsIncludeQuery keeps the query, flag values and panic
+	// value in crash reports, which otherwise redact them.
+	CrashReportsIncludeQuery bool `toml:"crash_reports_include_query"`
+}
+
+// PriceTable returns the built-in model prices with the configured ones
+// applied.
+func (c *Config) PriceTable() map[string]tokens.Price {
+	return tokens.MergePrices(c.Prices)
+}
+
+// ModelSettings returns the [models] entry for model: the one named after
+// it, or else the one with the longest key that prefixes it.
+func (c *Config) ModelSettings(model string) (ModelConfig, bool) {
+	if model == "" {
+		return ModelConfig{}, false
+	}
+	if m, ok := c.Models[model]; ok {
+		return m, true
+	}
+	best := ""
+	for key := range c.Models {
+		if strings.HasPrefix(model, key) && len(key) > len(best) {
+			best = key
+		}
+	}
+	if best == "" {
+		return ModelConfig{}, false
+	}
+	return c.Models[best], true
 }
 
 // Timeout returns the configured timeout as a time.Duration.
@@ -114,12 +815,57 @@ func (c *Config) Timeout() time.Duration {
 	return time.Duration(c.Advanced.TimeoutSeconds) * time.Second
 }
 
+// ConnectTimeout returns the configured connect timeout as a
+// time.Duration.
+func (c *Config) ConnectTimeout() time.Duration {
+	return time.Duration(c.Advanced.ConnectTimeoutSeconds) * time.Second
+}
+
+// TLSTimeout returns the configured TLS handshake timeout as a
+// time.Duration.
+func (c *Config) TLSTimeout() time.Duration {
+	return time.Duration(c.Advanced.TLSTimeoutSeconds) * time.Second
+}
+
+// ResponseHeaderTimeout returns the configured response header timeout as
+// a time.Duration.
+func (c *Config) ResponseHeaderTimeout() time.Duration {
+	return time.Duration(c.Advanced.ResponseHeaderTimeoutSeconds) * time.Second
+}
+
 // Default returns a Config with sensible default values.
 func Default() *Config {
 	return &Config{
 		Backend:        "anthropic",
 		IncludeContext: true,
 		OutputMode:     "auto",
+		Context: ContextConfig{
+			TokenBudget:       2000,
+			ListingLimit:      50,
+			MaxExamples:       5,
+			MaxCorrections:    3,
+			IncludeAliases:    "none",
+			ContainersLimit:   20,
+			GatherTimeoutMs:   50,
+			DetectToolFlavors: true,
+			ToolVersions:      []string{"git", "docker", "kubectl", "python3", "node"},
+			HelpLimit:         3000,
+		},
+		Review: ReviewConfig{
+			TimeoutSeconds: 10,
+		},
+		Budget: BudgetConfig{
+			Action: "warn",
+		},
+		History: HistoryConfig{
+			Enabled:    true,
+			DedupeDays: 7,
+		},
+		Index: IndexConfig{
+			Backend:       "openai",
+			Model:         "text-embedding-3-small",
+			MinSimilarity: 0.45,
+		},
 		Anthropic: AnthropicConfig{
 			Model: "claude-haiku-4-5-20251001",
 		},
@@ -129,13 +875,50 @@ func Default() *Config {
 		OpenRouter: OpenRouterConfig{
 			Model: "anthropic/claude-haiku-4-5-20251001",
 		},
+		Sync: SyncConfig{
+			Branch: "main",
+		},
+		Update: UpdateConfig{
+			URL: "https://api.github.com/repos/user/qcmd/releases/latest",
+		},
+		Serve: ServeConfig{
+			MaxConcurrent: 4,
+		},
 		Safety: SafetyConfig{
-			BlockDangerous: true,
-			ShowWarnings:   true,
+			BlockDangerous:    true,
+			ShowWarnings:      true,
+			WarnThreshold:     safety.DefaultWarnThreshold,
+			BlockThreshold:    safety.DefaultBlockThreshold,
+			StrictAsRoot:      true,
+			TrustSeconds:      3600,
+			BlockPlaceholders: true,
+			MaxLength:         400,
+			MaxPipeline:       6,
+		},
+		Advice: AdviceConfig{
+			LongRunning: true,
+		},
+		Lint: LintConfig{
+			Shellcheck: true,
+			Builtin:    true,
+			Severity:   "warning",
+		},
+		UI: UIConfig{
+			Language: "auto",
 		},
 		Advanced: AdvancedConfig{
-			TimeoutSeconds: 30,
-			MaxTokens:      512,
+			TimeoutSeconds:        30,
+			ConnectTimeoutSeconds: 2,
+			TLSTimeoutSeconds:     3,
+			MaxTokens:             512,
+			MinConfidence:         0.6,
+			ClarifyRounds:         2,
+			MaxQueryLength:        10000,
+			LongQuery:             "error",
+
+			BreakerFailures:        3,
+			BreakerWindowSeconds:   300,
+			BreakerCooldownSeconds: 60,
 		},
 	}
 }
@@ -149,10 +932,11 @@ type LoadOptions struct {
 // Load loads configuration from the appropriate source with the following priority:
 // 1. --config flag (via LoadOptions.ConfigPath)
 // 2. $QCMD_CONFIG env var
-// 3. $XDG_CONFIG_HOME/qcmd/config.toml
+// 3. config.toml in the config directory (xdg.ConfigDir)
 // 4. ~/.config/qcmd/config.toml
 //
 // Environment variables override file config for API keys and backend selection.
+// Settings in the organization policy file (OrgPolicyPath) override both.
 func Load(opts *LoadOptions) (*Config, error) {
 	cfg := Default()
 
@@ -169,9 +953,73 @@ func Load(opts *LoadOptions) (*Config, error) {
 	// Apply environment variable overrides
 	applyEnvOverrides(cfg)
 
+	// Settings enforced by an administrator win over everything else
+	if err := applyOrgPolicy(cfg, OrgPolicyPath); err != nil {
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
+// Path returns the config file Load reads with opts, or "" if there is
+// none.
+func Path(opts *LoadOptions) string {
+	return findConfigPath(opts)
+}
+
+// Changes returns the dotted names of the settings that differ between old
+// and cfg, such as "advanced.timeout_seconds", in order. Values are left
+// out since some are API keys.
+func Changes(old, cfg *Config) ([]string, error) {
+	before, err := flatten(old)
+	if err != nil {
+		return nil, err
+	}
+	after, err := flatten(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var changed []string
+	for key, value := range after {
+		if prev, ok := before[key]; !ok || !reflect.DeepEqual(prev, value) {
+			changed = append(changed, key)
+		}
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// flatten returns the settings in cfg by dotted name, as TOML sees them.
+// Arrays, including arrays of tables, are single settings.
+func flatten(cfg *Config) (map[string]any, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(cfg); err != nil {
+		return nil, fmt.Errorf("encoding config: %w", err)
+	}
+	var tree map[string]any
+	if _, err := toml.Decode(buf.String(), &tree); err != nil {
+		return nil, fmt.Errorf("decoding config: %w", err)
+	}
+	flat := make(map[string]any)
+	var walk func(prefix string, table map[string]any)
+	walk = func(prefix string, table map[string]any) {
+		for key, value := range table {
+			if sub, ok := value.(map[string]any); ok {
+				walk(prefix+key+".", sub)
+				continue
+			}
+			flat[prefix+key] = value
+		}
+	}
+	walk("", tree)
+	return flat, nil
+}
+
 // findConfigPath determines the config file path based on priority.
 func findConfigPath(opts *LoadOptions) string {
 	// Priority 1: Explicit path from --config flag
@@ -184,9 +1032,9 @@ func findConfigPath(opts *LoadOptions) string {
 		return envPath
 	}
 
-	// Priority 3: $XDG_CONFIG_HOME/qcmd/config.toml
-	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
-		xdgPath := filepath.Join(xdgConfigHome, "qcmd", "config.toml")
+	// Priority 3: config.toml in the config directory
+	if configDir, err := xdg.ConfigDir(); err == nil {
+		xdgPath := filepath.Join(configDir, "config.toml")
 		if fileExists(xdgPath) {
 			return xdgPath
 		}
@@ -240,6 +1088,13 @@ func applyEnvOverrides(cfg *Config) {
 		cfg.OpenRouter.APIKey = key
 	}
 
+	if token := os.Getenv("QCMD_SERVE_TOKEN"); token != "" {
+		cfg.Serve.Token = token
+	}
+	if token := os.Getenv("QCMD_REMOTE_TOKEN"); token != "" {
+		cfg.Remote.Token = token
+	}
+
 	// Backend override from environment
 	if backend := os.Getenv("QCMD_BACKEND"); backend != "" {
 		cfg.Backend = backend
@@ -255,25 +1110,10 @@ func fileExists(path string) bool {
 	return !info.IsDir()
 }
 
-// GetConfigDir returns the directory where config should be stored.
-// Uses $XDG_CONFIG_HOME/qcmd if set, otherwise ~/.config/qcmd.
-func GetConfigDir() (string, error) {
-	if xdgConfigHome := os.Getenv("XDG_CONFIG_HOME"); xdgConfigHome != "" {
-		return filepath.Join(xdgConfigHome, "qcmd"), nil
-	}
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", fmt.Errorf("getting home directory: %w", err)
-	}
-
-	return filepath.Join(homeDir, ".config", "qcmd"), nil
-}
-
 // InitConfig creates a default configuration file at the standard location.
 // Returns an error if the file already exists.
 func InitConfig() (string, error) {
-	configDir, err := GetConfigDir()
+	configDir, err := xdg.ConfigDir()
 	if err != nil {
 		return "", err
 	}
@@ -308,6 +1148,8 @@ func (c *Config) GetAPIKey(backend string) string {
 		return c.OpenAI.APIKey
 	case "openrouter":
 		return c.OpenRouter.APIKey
+	case "remote":
+		return c.Remote.Token
 	default:
 		return ""
 	}
@@ -322,27 +1164,52 @@ func (c *Config) GetModel(backend string) string {
 		return c.OpenAI.Model
 	case "openrouter":
 		return c.OpenRouter.Model
+	case "remote":
+		return c.Remote.Model
+	case "mock":
+		return "mock"
 	default:
 		return ""
 	}
 }
 
+// validateRemoteURL checks that u is a gateway address the token can be
+// sent to: https, or plain http only to this machine.
+func validateRemoteURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid remote.url: %s", u)
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+			return nil
+		}
+		return fmt.Errorf("remote.url must use https unless the gateway is on this machine: %s", u)
+	default:
+		return fmt.Errorf("invalid remote.url: %s (must be an http or https URL)", u)
+	}
+}
+
 // Validate checks the configuration for errors.
 func (c *Config) Validate() error {
 	// Validate backend
 	switch c.Backend {
-	case "anthropic", "openai", "openrouter":
+	case "anthropic", "openai", "openrouter", "remote", "mock":
 		// valid
 	default:
-		return fmt.Errorf("invalid backend: %s (must be anthropic, openai, or openrouter)", c.Backend)
+		return fmt.Errorf("invalid backend: %s (must be anthropic, openai, openrouter, remote, or mock)", c.Backend)
 	}
 
 	// Validate output mode
 	switch c.OutputMode {
-	case "auto", "clipboard", "print", "zle":
+	case "auto", "auto-smart", "clipboard", "print", "zle":
 		// valid
 	default:
-		return fmt.Errorf("invalid output_mode: %s (must be auto, clipboard, print, or zle)", c.OutputMode)
+		return fmt.Errorf("invalid output_mode: %s (must be auto, auto-smart, clipboard, print, or zle)", c.OutputMode)
 	}
 
 	// Validate timeout
@@ -350,10 +1217,236 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("timeout_seconds must be positive")
 	}
 
+	if c.Advanced.ConnectTimeoutSeconds < 0 || c.Advanced.TLSTimeoutSeconds < 0 || c.Advanced.ResponseHeaderTimeoutSeconds < 0 {
+		return fmt.Errorf("connect_timeout_seconds, tls_timeout_seconds and response_header_timeout_seconds must not be negative")
+	}
+
+	if c.Advanced.CompressRequestsOver < 0 {
+		return fmt.Errorf("compress_requests_over must not be negative")
+	}
+
 	// Validate max_tokens
 	if c.Advanced.MaxTokens <= 0 {
 		return fmt.Errorf("max_tokens must be positive")
 	}
 
+	// Validate min_confidence
+	if c.Advanced.ClarifyRounds < 0 {
+		return fmt.Errorf("clarify_rounds must not be negative")
+	}
+	if c.Advanced.MinConfidence < 0 || c.Advanced.MinConfidence > 1 {
+		return fmt.Errorf("min_confidence must be between 0 and 1")
+	}
+
+	// Validate language
+	if c.UI.Language != "auto" && c.UI.Language != "" && !i18n.Supported(c.UI.Language) {
+		return fmt.Errorf("invalid ui.language: %s (must be auto or one of %s)", c.UI.Language, strings.Join(i18n.Languages(), ", "))
+	}
+
+	// Validate query length handling
+	if c.Advanced.MaxQueryLength < 0 {
+		return fmt.Errorf("max_query_length must not be negative")
+	}
+	switch c.Lint.Severity {
+	case "error", "warning", "info", "style":
+	default:
+		return fmt.Errorf("invalid lint.severity: %s (must be error, warning, info or style)", c.Lint.Severity)
+	}
+	for _, code := range c.Lint.Exclude {
+		if !lintCodeRegex.MatchString(code) {
+			return fmt.Errorf("invalid lint.exclude entry %q (must be a check code such as SC2086)", code)
+		}
+	}
+
+	switch c.Advanced.LongQuery {
+	case "error", "truncate":
+	default:
+		return fmt.Errorf("invalid long_query: %s (must be error or truncate)", c.Advanced.LongQuery)
+	}
+
+	// Validate circuit breaker settings
+	if c.Advanced.BreakerFailures < 0 {
+		return fmt.Errorf("breaker_failures must not be negative")
+	}
+	if c.Advanced.BreakerFailures > 0 && (c.Advanced.BreakerWindowSeconds <= 0 || c.Advanced.BreakerCooldownSeconds <= 0) {
+		return fmt.Errorf("breaker_window_seconds and breaker_cooldown_seconds must be positive")
+	}
+	for _, name := range c.Advanced.FallbackBackends {
+		switch name {
+		case "anthropic", "openai", "openrouter", "remote", "mock":
+		default:
+			return fmt.Errorf("invalid fallback_backends entry: %s (must be anthropic, openai, openrouter, remote, or mock)", name)
+		}
+	}
+	for _, model := range c.Advanced.ContextFallbackModels {
+		if strings.TrimSpace(model) == "" {
+			return fmt.Errorf("context_fallback_models must not contain empty model names")
+		}
+	}
+
+	if c.Serve.MaxConcurrent < 0 {
+		return fmt.Errorf("serve.max_concurrent must not be negative")
+	}
+
+	// Validate the gateway address
+	if c.Remote.URL != "" {
+		if err := validateRemoteURL(c.Remote.URL); err != nil {
+			return err
+		}
+	} else if c.Backend == "remote" {
+		return fmt.Errorf("remote.url must be set to use the remote backend")
+	}
+
+	// Validate per-model settings
+	for model, m := range c.Models {
+		if model == "" {
+			return fmt.Errorf("models: the model name must not be empty")
+		}
+		if m.Temperature != nil && (*m.Temperature < 0 || *m.Temperature > 2) {
+			return fmt.Errorf("models.%s: temperature must be between 0 and 2", model)
+		}
+		if m.MaxTokens < 0 {
+			return fmt.Errorf("models.%s: max_tokens must not be negative", model)
+		}
+	}
+
+	// Validate prices
+	for model, p := range c.Prices {
+		if p.Input < 0 || p.Output < 0 {
+			return fmt.Errorf("prices.%s: prices must not be negative", model)
+		}
+	}
+
+	// Validate mock rules
+	for i, rule := range c.Mock.Rules {
+		if _, err := regexp.Compile(rule.Match); err != nil {
+			return fmt.Errorf("mock.rules[%d]: invalid match regex: %w", i, err)
+		}
+	}
+
+	// Validate context limits
+	if c.Context.TokenBudget < 0 {
+		return fmt.Errorf("token_budget must not be negative")
+	}
+	if c.Context.ListingLimit < 0 {
+		return fmt.Errorf("listing_limit must not be negative")
+	}
+	if c.Context.MaxExamples < 0 {
+		return fmt.Errorf("max_examples must not be negative")
+	}
+	if c.Context.MaxCorrections < 0 {
+		return fmt.Errorf("max_corrections must not be negative")
+	}
+	if c.Context.ContainersLimit < 0 {
+		return fmt.Errorf("containers_limit must not be negative")
+	}
+	if c.Context.GatherTimeoutMs < 0 {
+		return fmt.Errorf("gather_timeout_ms must not be negative")
+	}
+	for _, tool := range c.Context.ToolVersions {
+		if tool == "" || strings.ContainsAny(tool, " \t\n") {
+			return fmt.Errorf("invalid tool_versions entry %q: must be a program name", tool)
+		}
+	}
+	if c.Context.HelpLimit < 0 {
+		return fmt.Errorf("help_limit must not be negative")
+	}
+	switch c.Context.IncludeAliases {
+	case "none", "names", "expansions":
+	default:
+		return fmt.Errorf("invalid include_aliases: %s (must be none, names, or expansions)", c.Context.IncludeAliases)
+	}
+
+	// Validate review settings
+	switch c.Review.Backend {
+	case "", "anthropic", "openai", "openrouter", "remote", "mock":
+	default:
+		return fmt.Errorf("invalid review.backend: %s (must be anthropic, openai, openrouter, remote, or mock)", c.Review.Backend)
+	}
+	if c.Review.TimeoutSeconds <= 0 {
+		return fmt.Errorf("review.timeout_seconds must be positive")
+	}
+
+	// Validate index settings
+	switch c.Index.Backend {
+	case "openai", "mock":
+	default:
+		return fmt.Errorf("invalid index.backend: %s (must be openai or mock)", c.Index.Backend)
+	}
+	if c.Index.MinSimilarity < 0 || c.Index.MinSimilarity >= 1 {
+		return fmt.Errorf("index.min_similarity must be at least 0 and less than 1")
+	}
+
+	// Validate budget settings
+	if c.Budget.MonthlyUSD < 0 {
+		return fmt.Errorf("budget.monthly_usd must not be negative")
+	}
+	switch c.Budget.Action {
+	case "warn", "block":
+	default:
+		return fmt.Errorf("invalid budget.action: %s (must be warn or block)", c.Budget.Action)
+	}
+	switch c.Budget.LocalBackend {
+	case "", "anthropic", "openai", "openrouter", "mock":
+	default:
+		return fmt.Errorf("invalid budget.local_backend: %s (must be anthropic, openai, openrouter, or mock)", c.Budget.LocalBackend)
+	}
+
+	// Validate safety thresholds
+	if c.Safety.WarnThreshold < 1 || c.Safety.BlockThreshold > 100 || c.Safety.WarnThreshold > c.Safety.BlockThreshold {
+		return fmt.Errorf("safety thresholds must satisfy 1 <= warn_threshold <= block_threshold <= 100")
+	}
+
+	// Validate custom safety patterns and categories
+	patterns, err := c.Safety.CustomPatterns()
+	if err != nil {
+		return err
+	}
+	for _, list := range []struct {
+		key        string
+		categories []string
+	}{
+		{"disabled_categories", c.Safety.DisabledCategories},
+		{"quiet_categories", c.Safety.QuietCategories},
+	} {
+		for _, category := range list.categories {
+			known := safety.KnownCategory(category)
+			for _, p := range patterns {
+				known = known || p.Category == category
+			}
+			if !known {
+				return fmt.Errorf("safety.%s: unknown category %q", list.key, category)
+			}
+		}
+	}
+
+	if c.Safety.TrustSeconds < 0 {
+		return fmt.Errorf("safety.trust_seconds must not be negative")
+	}
+
+	if c.Safety.MaxLength < 0 || c.Safety.MaxPipeline < 0 {
+		return fmt.Errorf("safety.max_length and safety.max_pipeline must not be negative")
+	}
+
+	for _, pattern := range c.Safety.ProductionHosts {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("safety.production_hosts: invalid pattern %q", pattern)
+		}
+	}
+
+	if c.History.DedupeDays < 0 {
+		return fmt.Errorf("history.dedupe_days must not be negative")
+	}
+
+	// Validate sync settings
+	if c.Sync.Remote != "" && c.Sync.Branch == "" {
+		return fmt.Errorf("sync.branch must be set when sync.remote is configured")
+	}
+
+	// Validate the release feed
+	if parsed, err := url.Parse(c.Update.URL); err != nil || parsed.Host == "" || parsed.Scheme != "https" && parsed.Scheme != "http" {
+		return fmt.Errorf("invalid update.url: %s (must be an http or https URL)", c.Update.URL)
+	}
+
 	return nil
 }
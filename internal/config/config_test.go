@@ -1,8 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -22,8 +25,64 @@ func TestDefault(t *testing.T) {
 		{"openrouter.model", cfg.OpenRouter.Model, "anthropic/claude-haiku-4-5-20251001"},
 		{"safety.block_dangerous", cfg.Safety.BlockDangerous, true},
 		{"safety.show_warnings", cfg.Safety.ShowWarnings, true},
+		{"safety.strict_as_root", cfg.Safety.StrictAsRoot, true},
+		{"safety.trust_seconds", cfg.Safety.TrustSeconds, 3600},
+		{"safety.block_placeholders", cfg.Safety.BlockPlaceholders, true},
+		{"safety.max_length", cfg.Safety.MaxLength, 400},
+		{"safety.max_pipeline", cfg.Safety.MaxPipeline, 6},
+		{"advice.long_running", cfg.Advice.LongRunning, true},
+		{"advice.disk_space", cfg.Advice.DiskSpace, false},
+		{"advice.missing_paths", cfg.Advice.MissingPaths, false},
+		{"lint.shellcheck", cfg.Lint.Shellcheck, true},
+		{"lint.builtin", cfg.Lint.Builtin, true},
+		{"lint.severity", cfg.Lint.Severity, "warning"},
+		{"lint.fix_errors", cfg.Lint.FixErrors, false},
+		{"style.long_flags", cfg.Style.LongFlags, false},
+		{"style.posix", cfg.Style.POSIX, false},
+		{"style.avoid_bashisms", cfg.Style.AvoidBashisms, false},
 		{"advanced.timeout_seconds", cfg.Advanced.TimeoutSeconds, 30},
+		{"advanced.connect_timeout_seconds", cfg.Advanced.ConnectTimeoutSeconds, 2},
+		{"advanced.tls_timeout_seconds", cfg.Advanced.TLSTimeoutSeconds, 3},
+		{"advanced.response_header_timeout_seconds", cfg.Advanced.ResponseHeaderTimeoutSeconds, 0},
+		{"advanced.compress_requests_over", cfg.Advanced.CompressRequestsOver, 0},
+		{"serve.max_concurrent", cfg.Serve.MaxConcurrent, 4},
 		{"advanced.max_tokens", cfg.Advanced.MaxTokens, 512},
+		{"advanced.structured_output", cfg.Advanced.StructuredOutput, false},
+		{"advanced.crash_reports_include_query", cfg.Advanced.CrashReportsIncludeQuery, false},
+		{"advanced.min_confidence", cfg.Advanced.MinConfidence, 0.6},
+		{"advanced.max_query_length", cfg.Advanced.MaxQueryLength, 10000},
+		{"advanced.long_query", cfg.Advanced.LongQuery, "error"},
+		{"advanced.breaker_failures", cfg.Advanced.BreakerFailures, 3},
+		{"advanced.breaker_window_seconds", cfg.Advanced.BreakerWindowSeconds, 300},
+		{"advanced.breaker_cooldown_seconds", cfg.Advanced.BreakerCooldownSeconds, 60},
+		{"ui.language", cfg.UI.Language, "auto"},
+		{"context.token_budget", cfg.Context.TokenBudget, 2000},
+		{"context.include_listing", cfg.Context.IncludeListing, false},
+		{"context.include_abbreviations", cfg.Context.IncludeAbbreviations, false},
+		{"context.include_aliases", cfg.Context.IncludeAliases, "none"},
+		{"context.include_containers", cfg.Context.IncludeContainers, false},
+		{"context.containers_limit", cfg.Context.ContainersLimit, 20},
+		{"context.gather_timeout_ms", cfg.Context.GatherTimeoutMs, 50},
+		{"context.detect_tool_flavors", cfg.Context.DetectToolFlavors, true},
+		{"context.include_help", cfg.Context.IncludeHelp, false},
+		{"context.help_limit", cfg.Context.HelpLimit, 3000},
+		{"context.listing_limit", cfg.Context.ListingLimit, 50},
+		{"context.max_examples", cfg.Context.MaxExamples, 5},
+		{"context.max_corrections", cfg.Context.MaxCorrections, 3},
+		{"review.enabled", cfg.Review.Enabled, false},
+		{"review.backend", cfg.Review.Backend, ""},
+		{"review.timeout_seconds", cfg.Review.TimeoutSeconds, 10},
+		{"budget.monthly_usd", cfg.Budget.MonthlyUSD, 0.0},
+		{"budget.action", cfg.Budget.Action, "warn"},
+		{"history.enabled", cfg.History.Enabled, true},
+		{"history.dedupe_days", cfg.History.DedupeDays, 7},
+		{"index.enabled", cfg.Index.Enabled, false},
+		{"index.backend", cfg.Index.Backend, "openai"},
+		{"index.min_similarity", cfg.Index.MinSimilarity, 0.45},
+		{"sync.remote", cfg.Sync.Remote, ""},
+		{"sync.branch", cfg.Sync.Branch, "main"},
+		{"update.check", cfg.Update.Check, false},
+		{"update.url", cfg.Update.URL, "https://api.github.com/repos/user/qcmd/releases/latest"},
 	}
 
 	for _, tt := range tests {
@@ -144,6 +203,8 @@ api_key = "file-openrouter-key"
 	t.Setenv("OPENAI_API_KEY", "env-openai-key")
 	t.Setenv("OPENROUTER_API_KEY", "env-openrouter-key")
 	t.Setenv("QCMD_BACKEND", "openrouter")
+	t.Setenv("QCMD_SERVE_TOKEN", "env-serve-token")
+	t.Setenv("QCMD_REMOTE_TOKEN", "env-remote-token")
 
 	cfg, err := Load(&LoadOptions{ConfigPath: configPath})
 	if err != nil {
@@ -159,6 +220,8 @@ api_key = "file-openrouter-key"
 		{"openai.api_key", cfg.OpenAI.APIKey, "env-openai-key"},
 		{"openrouter.api_key", cfg.OpenRouter.APIKey, "env-openrouter-key"},
 		{"backend", cfg.Backend, "openrouter"},
+		{"serve.token", cfg.Serve.Token, "env-serve-token"},
+		{"remote.token", cfg.Remote.Token, "env-remote-token"},
 	}
 
 	for _, tt := range tests {
@@ -291,6 +354,7 @@ func TestGetAPIKey(t *testing.T) {
 	cfg.Anthropic.APIKey = "anthropic-key"
 	cfg.OpenAI.APIKey = "openai-key"
 	cfg.OpenRouter.APIKey = "openrouter-key"
+	cfg.Remote.Token = "remote-token"
 
 	tests := []struct {
 		backend  string
@@ -299,6 +363,7 @@ func TestGetAPIKey(t *testing.T) {
 		{"anthropic", "anthropic-key"},
 		{"openai", "openai-key"},
 		{"openrouter", "openrouter-key"},
+		{"remote", "remote-token"},
 		{"invalid", ""},
 	}
 
@@ -317,6 +382,7 @@ func TestGetModel(t *testing.T) {
 	cfg.Anthropic.Model = "claude-custom"
 	cfg.OpenAI.Model = "gpt-custom"
 	cfg.OpenRouter.Model = "router-custom"
+	cfg.Remote.Model = "remote-custom"
 
 	tests := []struct {
 		backend  string
@@ -325,6 +391,7 @@ func TestGetModel(t *testing.T) {
 		{"anthropic", "claude-custom"},
 		{"openai", "gpt-custom"},
 		{"openrouter", "router-custom"},
+		{"remote", "remote-custom"},
 		{"invalid", ""},
 	}
 
@@ -359,6 +426,189 @@ func TestValidate(t *testing.T) {
 			modify:    func(c *Config) { c.OutputMode = "invalid" },
 			wantError: true,
 		},
+		{
+			name:      "invalid production_hosts pattern",
+			modify:    func(c *Config) { c.Safety.ProductionHosts = []string{"prod["} },
+			wantError: true,
+		},
+		{
+			name:      "negative containers_limit",
+			modify:    func(c *Config) { c.Context.ContainersLimit = -1 },
+			wantError: true,
+		},
+		{
+			name:      "negative gather_timeout_ms",
+			modify:    func(c *Config) { c.Context.GatherTimeoutMs = -1 },
+			wantError: true,
+		},
+		{
+			name:      "invalid include_aliases",
+			modify:    func(c *Config) { c.Context.IncludeAliases = "all" },
+			wantError: true,
+		},
+		{
+			name:      "include_aliases names",
+			modify:    func(c *Config) { c.Context.IncludeAliases = "names" },
+			wantError: false,
+		},
+		{
+			name:      "invalid review backend",
+			modify:    func(c *Config) { c.Review.Backend = "llama" },
+			wantError: true,
+		},
+		{
+			name:      "review with mock backend",
+			modify:    func(c *Config) { c.Review.Enabled, c.Review.Backend = true, "mock" },
+			wantError: false,
+		},
+		{
+			name:      "zero review timeout",
+			modify:    func(c *Config) { c.Review.TimeoutSeconds = 0 },
+			wantError: true,
+		},
+		{
+			name:      "tool_versions entry with a space",
+			modify:    func(c *Config) { c.Context.ToolVersions = []string{"git", "go version"} },
+			wantError: true,
+		},
+		{
+			name:      "negative help_limit",
+			modify:    func(c *Config) { c.Context.HelpLimit = -1 },
+			wantError: true,
+		},
+		{
+			name:      "invalid index backend",
+			modify:    func(c *Config) { c.Index.Backend = "anthropic" },
+			wantError: true,
+		},
+		{
+			name:      "index min_similarity of 1",
+			modify:    func(c *Config) { c.Index.MinSimilarity = 1 },
+			wantError: true,
+		},
+		{
+			name:      "min_confidence above 1",
+			modify:    func(c *Config) { c.Advanced.MinConfidence = 60 },
+			wantError: true,
+		},
+		{
+			name:      "invalid ui.language",
+			modify:    func(c *Config) { c.UI.Language = "klingon" },
+			wantError: true,
+		},
+		{
+			name:      "ui.language es",
+			modify:    func(c *Config) { c.UI.Language = "es" },
+			wantError: false,
+		},
+		{
+			name:      "negative max_query_length",
+			modify:    func(c *Config) { c.Advanced.MaxQueryLength = -1 },
+			wantError: true,
+		},
+		{
+			name:      "invalid long_query",
+			modify:    func(c *Config) { c.Advanced.LongQuery = "summarize" },
+			wantError: true,
+		},
+		{
+			name:      "long_query truncate",
+			modify:    func(c *Config) { c.Advanced.LongQuery = "truncate" },
+			wantError: false,
+		},
+		{
+			name:      "negative connect timeout",
+			modify:    func(c *Config) { c.Advanced.ConnectTimeoutSeconds = -1 },
+			wantError: true,
+		},
+		{
+			name:      "unbounded response headers",
+			modify:    func(c *Config) { c.Advanced.ResponseHeaderTimeoutSeconds = 0 },
+			wantError: false,
+		},
+		{
+			name:      "negative compress_requests_over",
+			modify:    func(c *Config) { c.Advanced.CompressRequestsOver = -1 },
+			wantError: true,
+		},
+		{
+			name:      "remote backend without a URL",
+			modify:    func(c *Config) { c.Backend = "remote" },
+			wantError: true,
+		},
+		{
+			name:      "remote backend over https",
+			modify:    func(c *Config) { c.Backend = "remote"; c.Remote.URL = "https://qcmd.example.com:7788" },
+			wantError: false,
+		},
+		{
+			name:      "remote backend over http to this machine",
+			modify:    func(c *Config) { c.Backend = "remote"; c.Remote.URL = "http://127.0.0.1:7788" },
+			wantError: false,
+		},
+		{
+			name:      "remote backend over http to another machine",
+			modify:    func(c *Config) { c.Backend = "remote"; c.Remote.URL = "http://qcmd.example.com:7788" },
+			wantError: true,
+		},
+		{
+			name:      "remote URL without a host",
+			modify:    func(c *Config) { c.Remote.URL = "qcmd.example.com" },
+			wantError: true,
+		},
+		{
+			name:      "remote fallback",
+			modify:    func(c *Config) { c.Advanced.FallbackBackends = []string{"remote"} },
+			wantError: false,
+		},
+		{
+			name:      "negative serve.max_concurrent",
+			modify:    func(c *Config) { c.Serve.MaxConcurrent = -1 },
+			wantError: true,
+		},
+		{
+			name:      "negative breaker_failures",
+			modify:    func(c *Config) { c.Advanced.BreakerFailures = -1 },
+			wantError: true,
+		},
+		{
+			name:      "zero breaker cooldown",
+			modify:    func(c *Config) { c.Advanced.BreakerCooldownSeconds = 0 },
+			wantError: true,
+		},
+		{
+			name: "zero breaker cooldown with breaker disabled",
+			modify: func(c *Config) {
+				c.Advanced.BreakerFailures = 0
+				c.Advanced.BreakerCooldownSeconds = 0
+			},
+			wantError: false,
+		},
+		{
+			name:      "fallback backends",
+			modify:    func(c *Config) { c.Advanced.FallbackBackends = []string{"openrouter", "mock"} },
+			wantError: false,
+		},
+		{
+			name:      "invalid fallback backend",
+			modify:    func(c *Config) { c.Advanced.FallbackBackends = []string{"ollama"} },
+			wantError: true,
+		},
+		{
+			name:      "negative budget",
+			modify:    func(c *Config) { c.Budget.MonthlyUSD = -1 },
+			wantError: true,
+		},
+		{
+			name:      "invalid budget action",
+			modify:    func(c *Config) { c.Budget.Action = "ignore" },
+			wantError: true,
+		},
+		{
+			name:      "blocking budget",
+			modify:    func(c *Config) { c.Budget.MonthlyUSD, c.Budget.Action, c.Budget.LocalBackend = 5, "block", "mock" },
+			wantError: false,
+		},
 		{
 			name:      "zero timeout",
 			modify:    func(c *Config) { c.Advanced.TimeoutSeconds = 0 },
@@ -389,6 +639,137 @@ func TestValidate(t *testing.T) {
 			modify:    func(c *Config) { c.Backend = "openrouter" },
 			wantError: false,
 		},
+		{
+			name:      "valid mock backend",
+			modify:    func(c *Config) { c.Backend = "mock" },
+			wantError: false,
+		},
+		{
+			name: "invalid mock rule regex",
+			modify: func(c *Config) {
+				c.Mock.Rules = []MockRuleConfig{{Match: "(unclosed", Command: "ls"}}
+			},
+			wantError: true,
+		},
+		{
+			name:      "negative token_budget",
+			modify:    func(c *Config) { c.Context.TokenBudget = -1 },
+			wantError: true,
+		},
+		{
+			name:      "negative max_examples",
+			modify:    func(c *Config) { c.Context.MaxExamples = -1 },
+			wantError: true,
+		},
+		{
+			name: "sync remote without branch",
+			modify: func(c *Config) {
+				c.Sync.Remote = "git@example.com:team/qcmd-snippets.git"
+				c.Sync.Branch = ""
+			},
+			wantError: true,
+		},
+		{
+			name:      "known disabled safety category",
+			modify:    func(c *Config) { c.Safety.DisabledCategories = []string{"kubernetes", "database"} },
+			wantError: false,
+		},
+		{
+			name: "valid custom safety pattern",
+			modify: func(c *Config) {
+				c.Safety.Patterns = []SafetyPatternConfig{{Match: "terraform .*prod", Level: "danger", Category: "team"}}
+				c.Safety.DisabledCategories = []string{"team"}
+			},
+			wantError: false,
+		},
+		{
+			name:      "custom safety pattern with invalid regex",
+			modify:    func(c *Config) { c.Safety.Patterns = []SafetyPatternConfig{{Match: "([", Level: "danger"}} },
+			wantError: true,
+		},
+		{
+			name:      "custom safety pattern with safe level",
+			modify:    func(c *Config) { c.Safety.Patterns = []SafetyPatternConfig{{Match: "ls", Level: "safe"}} },
+			wantError: true,
+		},
+		{
+			name: "custom safety pattern with score out of range",
+			modify: func(c *Config) {
+				c.Safety.Patterns = []SafetyPatternConfig{{Match: "ls", Level: "caution", Score: 150}}
+			},
+			wantError: true,
+		},
+		{
+			name: "tuned safety thresholds",
+			modify: func(c *Config) {
+				c.Safety.WarnThreshold = 60
+				c.Safety.BlockThreshold = 95
+			},
+			wantError: false,
+		},
+		{
+			name: "warn threshold above block threshold",
+			modify: func(c *Config) {
+				c.Safety.WarnThreshold = 95
+				c.Safety.BlockThreshold = 60
+			},
+			wantError: true,
+		},
+		{
+			name:      "zero warn threshold",
+			modify:    func(c *Config) { c.Safety.WarnThreshold = 0 },
+			wantError: true,
+		},
+		{
+			name:      "unknown disabled safety category",
+			modify:    func(c *Config) { c.Safety.DisabledCategories = []string{"kubernetess"} },
+			wantError: true,
+		},
+		{
+			name:      "known quiet safety category",
+			modify:    func(c *Config) { c.Safety.QuietCategories = []string{"system"} },
+			wantError: false,
+		},
+		{
+			name:      "negative trust_seconds",
+			modify:    func(c *Config) { c.Safety.TrustSeconds = -1 },
+			wantError: true,
+		},
+		{
+			name:      "invalid lint.severity",
+			modify:    func(c *Config) { c.Lint.Severity = "fatal" },
+			wantError: true,
+		},
+		{
+			name:      "invalid lint.exclude",
+			modify:    func(c *Config) { c.Lint.Exclude = []string{"2086"} },
+			wantError: true,
+		},
+		{
+			name:      "negative max_pipeline",
+			modify:    func(c *Config) { c.Safety.MaxPipeline = -1 },
+			wantError: true,
+		},
+		{
+			name:      "negative history dedupe_days",
+			modify:    func(c *Config) { c.History.DedupeDays = -1 },
+			wantError: true,
+		},
+		{
+			name:      "empty update url",
+			modify:    func(c *Config) { c.Update.URL = "" },
+			wantError: true,
+		},
+		{
+			name:      "update url without scheme",
+			modify:    func(c *Config) { c.Update.URL = "example.com/latest" },
+			wantError: true,
+		},
+		{
+			name:      "unknown quiet safety category",
+			modify:    func(c *Config) { c.Safety.QuietCategories = []string{"sudo"} },
+			wantError: true,
+		},
 		{
 			name:      "valid zle output_mode",
 			modify:    func(c *Config) { c.OutputMode = "zle" },
@@ -404,6 +785,34 @@ func TestValidate(t *testing.T) {
 			modify:    func(c *Config) { c.OutputMode = "print" },
 			wantError: false,
 		},
+		{
+			name:      "valid auto-smart output_mode",
+			modify:    func(c *Config) { c.OutputMode = "auto-smart" },
+			wantError: false,
+		},
+		{
+			name: "model temperature out of range",
+			modify: func(c *Config) {
+				temperature := 2.5
+				c.Models = map[string]ModelConfig{"gpt-5o": {Temperature: &temperature}}
+			},
+			wantError: true,
+		},
+		{
+			name:      "negative model max_tokens",
+			modify:    func(c *Config) { c.Models = map[string]ModelConfig{"gpt-5o": {MaxTokens: -1}} },
+			wantError: true,
+		},
+		{
+			name:      "empty context fallback model",
+			modify:    func(c *Config) { c.Advanced.ContextFallbackModels = []string{"gpt-4.1", " "} },
+			wantError: true,
+		},
+		{
+			name:      "empty model key",
+			modify:    func(c *Config) { c.Models = map[string]ModelConfig{"": {MaxTokens: 100}} },
+			wantError: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -419,6 +828,20 @@ func TestValidate(t *testing.T) {
 	}
 }
 
+func TestSafetyWarns(t *testing.T) {
+	c := SafetyConfig{ShowWarnings: true, QuietCategories: []string{"system"}}
+	if c.Warns("system") {
+		t.Error("Warns(system) = true for a quiet category")
+	}
+	if !c.Warns("network") {
+		t.Error("Warns(network) = false, want true")
+	}
+	c.ShowWarnings = false
+	if c.Warns("network") {
+		t.Error("Warns(network) = true with show_warnings off")
+	}
+}
+
 func TestInitConfig(t *testing.T) {
 	tmpDir := t.TempDir()
 	t.Setenv("XDG_CONFIG_HOME", tmpDir)
@@ -491,3 +914,174 @@ api_key = "my-key"
 		t.Errorf("advanced.timeout_seconds = %d, want %d", cfg.Advanced.TimeoutSeconds, 30)
 	}
 }
+
+func TestLoadPolicy(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	sub := filepath.Join(repo, "cmd", "tool")
+	if err := os.MkdirAll(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(repo, ".git"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// A policy above the repository root is never picked up.
+	if err := os.WriteFile(filepath.Join(root, PolicyFileName), []byte("[git]\nprotected_branches = [\"outer\"]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, path, err := LoadPolicy(sub)
+	if err != nil || policy != nil || path != "" {
+		t.Fatalf("LoadPolicy() without repo policy = %v, %q, %v, want nil", policy, path, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"protected branches", "[git]\nprotected_branches = [\"main\", \"release/*\"]\n", []string{"main", "release/*"}},
+		{"explicitly none", "[git]\nprotected_branches = []\n", []string{}},
+		{"unset", "# nothing yet\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(repo, PolicyFileName), []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			policy, path, err := LoadPolicy(sub)
+			if err != nil {
+				t.Fatalf("LoadPolicy() error = %v", err)
+			}
+			if path != filepath.Join(repo, PolicyFileName) {
+				t.Errorf("path = %q, want the repository policy", path)
+			}
+			got := policy.Git.ProtectedBranches
+			if (got == nil) != (tt.want == nil) || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ProtectedBranches = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, PolicyFileName), []byte("[git\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := LoadPolicy(sub); err == nil {
+		t.Error("LoadPolicy() with invalid TOML should fail")
+	}
+}
+
+func TestApplyOrgPolicy(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		content string // empty means no policy file
+		user    bool
+		want    bool
+		wantErr bool
+	}{
+		{"no policy keeps user setting", "", true, true, false},
+		{"policy forbids", "[safety]\nallow_disable = false\n", true, false, false},
+		{"policy permits", "[safety]\nallow_disable = true\n", false, true, false},
+		{"policy silent on setting", "[safety]\n", true, true, false},
+		{"invalid policy", "[safety\n", true, true, true},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "missing.toml")
+			if tt.content != "" {
+				path = filepath.Join(dir, fmt.Sprintf("policy%d.toml", i))
+				if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cfg := Default()
+			cfg.Safety.AllowDisable = tt.user
+			err := applyOrgPolicy(cfg, path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyOrgPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if cfg.Safety.AllowDisable != tt.want {
+				t.Errorf("AllowDisable = %v, want %v", cfg.Safety.AllowDisable, tt.want)
+			}
+		})
+	}
+
+	if Default().Safety.AllowDisable {
+		t.Error("Default() should not allow disabling safety")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	old := Default()
+	cfg := Default()
+	cfg.Anthropic.APIKey = "sk-new"
+	cfg.Advanced.TimeoutSeconds = 60
+	cfg.Safety.Patterns = append(cfg.Safety.Patterns, SafetyPatternConfig{Match: "terraform destroy", Level: "danger", Description: "Destroys infrastructure"})
+
+	got, err := Changes(old, cfg)
+	if err != nil {
+		t.Fatalf("Changes() error: %v", err)
+	}
+	want := []string{"advanced.timeout_seconds", "anthropic.api_key", "safety.patterns"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+
+	if got, err := Changes(cfg, cfg); err != nil || len(got) != 0 {
+		t.Errorf("Changes() of a config with itself = %v, %v", got, err)
+	}
+}
+
+func TestModelSettings(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	content := `
+[models."gpt-5"]
+prompt_suffix = "family"
+
+[models."gpt-5o"]
+prompt_suffix = "Never use markdown."
+temperature = 0
+max_tokens = 256
+
+[models."gpt-5o-mini-2026"]
+max_tokens = 128
+`
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(&LoadOptions{ConfigPath: configPath})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		model      string
+		wantSuffix string
+		wantTokens int
+		wantOK     bool
+	}{
+		{"gpt-5o", "Never use markdown.", 256, true},
+		{"gpt-5o-mini", "Never use markdown.", 256, true},
+		{"gpt-5o-mini-2026", "", 128, true},
+		{"gpt-5-nano", "family", 0, true},
+		{"claude-haiku-4-5", "", 0, false},
+		{"", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, ok := cfg.ModelSettings(tt.model)
+			if ok != tt.wantOK || got.PromptSuffix != tt.wantSuffix || got.MaxTokens != tt.wantTokens {
+				t.Errorf("ModelSettings(%q) = %+v, %v; want suffix %q, max_tokens %d, %v",
+					tt.model, got, ok, tt.wantSuffix, tt.wantTokens, tt.wantOK)
+			}
+		})
+	}
+	if got, _ := cfg.ModelSettings("gpt-5o"); got.Temperature == nil || *got.Temperature != 0 {
+		t.Errorf("temperature = %v, want 0", got.Temperature)
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// PolicyFileName is the name of the per-project policy file. It is looked
+// up from the working directory upwards and is meant to be committed to
+// the repository so every contributor gets the same safety settings.
+const PolicyFileName = ".qcmd.toml"
+
+// OrgPolicyPath is the system-wide policy file administrators use to
+// enforce settings for every user of a machine. A variable so tests can
+// point it elsewhere.
+var OrgPolicyPath = "/etc/qcmd/policy.toml"
+
+// OrgPolicy holds settings enforced by an administrator. Unset fields
+// leave the user's configuration alone.
+type OrgPolicy struct {
+	Safety OrgSafetyPolicy `toml:"safety"`
+}
+
+// OrgSafetyPolicy holds the enforced safety settings.
+type OrgSafetyPolicy struct {
+	// AllowDisable decides whether --no-safety is honored.
+	AllowDisable *bool `toml:"allow_disable"`
+}
+
+// applyOrgPolicy overrides cfg with the organization policy at path, if
+// there is one. An unreadable policy is an error rather than being
+// skipped, so a broken file never silently lifts a restriction.
+func applyOrgPolicy(cfg *Config, path string) error {
+	var policy OrgPolicy
+	if _, err := toml.DecodeFile(path, &policy); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("loading organization policy %s: %w", path, err)
+	}
+
+	if policy.Safety.AllowDisable != nil {
+		cfg.Safety.AllowDisable = *policy.Safety.AllowDisable
+	}
+	return nil
+}
+
+// Policy holds per-project settings.
+type Policy struct {
+	Git GitPolicy `toml:"git"`
+}
+
+// GitPolicy holds the project's git safety settings.
+type GitPolicy struct {
+	// ProtectedBranches are the branch patterns (e.g. "release/*") on which
+	// force pushes and deletions are blocked. Nil means the built-in default.
+	ProtectedBranches []string `toml:"protected_branches"`
+}
+
+// LoadPolicy parses the nearest policy file in dir or one of its parents,
+// stopping at the root of the git repository containing dir. It returns
+// nil and an empty path when there is no policy file.
+func LoadPolicy(dir string) (*Policy, string, error) {
+	for {
+		path := filepath.Join(dir, PolicyFileName)
+		if _, err := os.Stat(path); err == nil {
+			var policy Policy
+			md, err := toml.DecodeFile(path, &policy)
+			if err != nil {
+				return nil, path, fmt.Errorf("parse policy file %s: %w", path, err)
+			}
+			if md.IsDefined("git", "protected_branches") && policy.Git.ProtectedBranches == nil {
+				policy.Git.ProtectedBranches = []string{}
+			}
+			return &policy, path, nil
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, path, fmt.Errorf("stat policy file: %w", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return nil, "", nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, "", nil
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,261 @@
+// Package container recognizes generated Dockerfiles and Compose files,
+// checks that they are well formed, and points out settings that weaken
+// isolation from the host. Shell safety patterns are not applied to them:
+// a Dockerfile's commands run inside the image and YAML is not a shell.
+package container
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Format is the kind of answer to a container query.
+type Format string
+
+const (
+	// Auto lets the model choose from the request.
+	Auto Format = "auto"
+	// Command is a single docker or podman command.
+	Command Format = "command"
+	// Dockerfile is a complete Dockerfile.
+	Dockerfile Format = "dockerfile"
+	// Compose is a docker-compose YAML file.
+	Compose Format = "compose"
+)
+
+// ParseFormat parses a --format value.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case Auto, Command, Dockerfile, Compose:
+		return f, nil
+	case "":
+		return Auto, nil
+	}
+	return "", fmt.Errorf("invalid format %q (must be auto, command, dockerfile, or compose)", s)
+}
+
+var (
+	dockerfileStart = regexp.MustCompile(`(?i)^(FROM|ARG)\s`)
+	composeKey      = regexp.MustCompile(`^(services|version|name|include|volumes|networks|configs|secrets|x-[\w-]+):`)
+	heredocRegex    = regexp.MustCompile(`<<-?\s*["']?(\w+)["']?`)
+)
+
+// instructions are the Dockerfile instructions.
+var instructions = map[string]bool{
+	"FROM": true, "RUN": true, "CMD": true, "LABEL": true, "MAINTAINER": true,
+	"EXPOSE": true, "ENV": true, "ADD": true, "COPY": true, "ENTRYPOINT": true,
+	"VOLUME": true, "USER": true, "WORKDIR": true, "ARG": true, "ONBUILD": true,
+	"STOPSIGNAL": true, "HEALTHCHECK": true, "SHELL": true,
+}
+
+// Detect tells what text is: a Dockerfile if its first instruction is FROM
+// or ARG, a Compose file if it has a top-level "services:" key, and
+// otherwise a command.
+func Detect(text string) Format {
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if dockerfileStart.MatchString(trimmed) {
+			return Dockerfile
+		}
+		break
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "services:") {
+			return Compose
+		}
+	}
+	return Command
+}
+
+// Check reports the first problem that would stop docker from reading
+// text as the given format.
+func Check(format Format, text string) error {
+	switch format {
+	case Dockerfile:
+		return checkDockerfile(text)
+	case Compose:
+		return checkCompose(text)
+	}
+	return nil
+}
+
+// checkDockerfile checks that every instruction is known and that the
+// first one, after ARGs, is FROM.
+func checkDockerfile(text string) error {
+	seenFrom := false
+	for _, l := range instructionLines(text) {
+		word, _, _ := strings.Cut(l.text, " ")
+		word = strings.ToUpper(strings.TrimSpace(word))
+		if !instructions[word] {
+			return fmt.Errorf("line %d: unknown instruction %q", l.line, word)
+		}
+		if !seenFrom && word != "ARG" && word != "FROM" {
+			return fmt.Errorf("line %d: %s before FROM", l.line, word)
+		}
+		if word == "FROM" {
+			seenFrom = true
+		}
+	}
+	if !seenFrom {
+		return fmt.Errorf("no FROM instruction")
+	}
+	return nil
+}
+
+// checkCompose checks what can be checked without a YAML parser: a
+// top-level "services:" key and no tab indentation.
+func checkCompose(text string) error {
+	hasServices := false
+	for i, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			return fmt.Errorf("line %d: YAML does not allow tabs for indentation", i+1)
+		}
+		if strings.HasPrefix(line, "services:") {
+			hasServices = true
+		}
+		if line != "" && line[0] != ' ' && line[0] != '#' && line != "---" && !composeKey.MatchString(line) {
+			return fmt.Errorf("line %d: unknown top-level key in %q", i+1, line)
+		}
+	}
+	if !hasServices {
+		return fmt.Errorf("no top-level services: key")
+	}
+	return nil
+}
+
+// instructionLine is a Dockerfile instruction with its continuation lines
+// joined.
+type instructionLine struct {
+	text string
+	line int
+}
+
+// instructionLines returns the instructions in a Dockerfile, skipping
+// comments, blank lines and heredoc bodies.
+func instructionLines(text string) []instructionLine {
+	var result []instructionLine
+	var cur *instructionLine
+	heredoc := ""
+	for i, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if heredoc != "" {
+			if trimmed == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+		if cur == nil {
+			if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+				continue
+			}
+			result = append(result, instructionLine{line: i + 1})
+			cur = &result[len(result)-1]
+		} else if strings.HasPrefix(trimmed, "#") {
+			// Comments inside a continued instruction are dropped.
+			continue
+		}
+		if m := heredocRegex.FindStringSubmatch(line); m != nil {
+			heredoc = m[1]
+		}
+		if strings.HasSuffix(trimmed, `\`) {
+			cur.text += strings.TrimSuffix(trimmed, `\`) + " "
+			continue
+		}
+		cur.text += trimmed
+		cur = nil
+	}
+	return result
+}
+
+// Finding is a setting that weakens the container's isolation or makes
+// the build less reproducible.
+type Finding struct {
+	Line        int
+	Description string
+}
+
+// lintRule flags lines matching regex.
+type lintRule struct {
+	regex       *regexp.Regexp
+	description string
+}
+
+var composeRules = []lintRule{
+	{regexp.MustCompile(`^\s*privileged:\s*["']?true`), "Runs a privileged container with full access to the host"},
+	{regexp.MustCompile(`/var/run/docker\.sock`), "Mounts the Docker socket, which gives control of the host"},
+	{regexp.MustCompile(`^\s*network_mode:\s*["']?host`), "Shares the host's network"},
+	{regexp.MustCompile(`^\s*pid:\s*["']?host`), "Shares the host's processes"},
+	{regexp.MustCompile(`^\s*-\s*["']?/:`), "Mounts the host's root directory"},
+	{regexp.MustCompile(`^\s*-\s*["']?(SYS_ADMIN|ALL)["']?\s*$`), "Adds broad kernel capabilities"},
+}
+
+var dockerfileRules = []lintRule{
+	{regexp.MustCompile(`(?i)^RUN\s.*\b(curl|wget)\b[^|]*\|\s*(sudo\s+)?(ba|z)?sh\b`), "Pipes a download into a shell"},
+}
+
+// Lint returns the settings in text that deserve a second look.
+func Lint(format Format, text string) []Finding {
+	var findings []Finding
+	switch format {
+	case Compose:
+		for i, line := range strings.Split(text, "\n") {
+			findings = appendFindings(findings, composeRules, line, i+1)
+		}
+	case Dockerfile:
+		stages := make(map[string]bool)
+		for _, l := range instructionLines(text) {
+			findings = appendFindings(findings, dockerfileRules, l.text, l.line)
+			if image, stage, ok := parseFrom(l.text); ok {
+				if unpinned(image) && !stages[strings.ToLower(image)] {
+					findings = append(findings, Finding{Line: l.line, Description: "Uses an unpinned base image; pin a tag or digest"})
+				}
+				stages[strings.ToLower(stage)] = true
+			}
+		}
+	}
+	return findings
+}
+
+// parseFrom returns the image and stage name of a FROM instruction.
+func parseFrom(instruction string) (image, stage string, ok bool) {
+	fields := strings.Fields(instruction)
+	if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+		return "", "", false
+	}
+	fields = fields[1:]
+	for len(fields) > 0 && strings.HasPrefix(fields[0], "--") {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	if len(fields) == 3 && strings.EqualFold(fields[1], "AS") {
+		stage = fields[2]
+	}
+	return fields[0], stage, true
+}
+
+// unpinned reports whether image names no tag, or "latest", and no digest.
+// scratch and images named by a build argument are not images to pin.
+func unpinned(image string) bool {
+	if image == "scratch" || strings.Contains(image, "$") || strings.Contains(image, "@") {
+		return false
+	}
+	name := image[strings.LastIndexByte(image, '/')+1:]
+	_, tag, ok := strings.Cut(name, ":")
+	return !ok || tag == "latest"
+}
+
+// appendFindings adds a finding for each rule that matches line.
+func appendFindings(findings []Finding, rules []lintRule, line string, n int) []Finding {
+	for _, r := range rules {
+		if r.regex.MatchString(line) {
+			findings = append(findings, Finding{Line: n, Description: r.description})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,129 @@
+package container
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const goDockerfile = `# syntax=docker/dockerfile:1
+ARG GO_VERSION=1.22
+FROM golang:${GO_VERSION} AS build
+WORKDIR /src
+COPY . .
+RUN go build \
+    -o /out/app ./cmd/app
+RUN <<EOF
+set -e
+echo built
+EOF
+
+FROM alpine AS final
+RUN curl -fsSL https://example.com/install.sh | sh
+COPY --from=build /out/app /usr/local/bin/app
+ENTRYPOINT ["app"]
+`
+
+const webCompose = `services:
+  web:
+    image: nginx:1.27
+    privileged: true
+    network_mode: host
+    volumes:
+      - /var/run/docker.sock:/var/run/docker.sock
+      - "/:/host"
+    cap_add:
+      - SYS_ADMIN
+volumes:
+  data:
+`
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text string
+		want Format
+	}{
+		{goDockerfile, Dockerfile},
+		{"FROM alpine\nRUN true", Dockerfile},
+		{webCompose, Compose},
+		{"version: '3.8'\nservices:\n  db:\n    image: postgres:16", Compose},
+		{"docker run --rm -it alpine sh", Command},
+		{"docker compose up -d", Command},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.text); got != tt.want {
+			t.Errorf("Detect(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		format  Format
+		text    string
+		wantErr string
+	}{
+		{Dockerfile, goDockerfile, ""},
+		{Dockerfile, "FROM alpine\nRUNN true", "unknown instruction \"RUNN\""},
+		{Dockerfile, "WORKDIR /app\nFROM alpine", "WORKDIR before FROM"},
+		{Dockerfile, "ARG X=1", "no FROM"},
+		{Compose, webCompose, ""},
+		{Compose, "---\nservices:\n  web:\n    image: nginx:1.27", ""},
+		{Compose, "services:\n\tweb:\n", "tabs"},
+		{Compose, "volumes:\n  data:\n", "no top-level services"},
+		{Compose, "services:\n  web:\nimage: nginx", "unknown top-level key"},
+		{Command, "anything", ""},
+	}
+	for _, tt := range tests {
+		err := Check(tt.format, tt.text)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Check(%s, %q) error = %v", tt.format, tt.text, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Check(%s, %q) error = %v, want one mentioning %q", tt.format, tt.text, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	got := Lint(Dockerfile, goDockerfile)
+	want := []Finding{
+		{Line: 13, Description: "Uses an unpinned base image; pin a tag or digest"},
+		{Line: 14, Description: "Pipes a download into a shell"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint(Dockerfile) = %+v, want %+v", got, want)
+	}
+
+	got = Lint(Compose, webCompose)
+	var lines []int
+	for _, f := range got {
+		lines = append(lines, f.Line)
+	}
+	if want := []int{4, 5, 7, 8, 10}; !reflect.DeepEqual(lines, want) {
+		t.Errorf("Lint(Compose) flagged lines %v, want %v: %+v", lines, want, got)
+	}
+
+	for _, pinned := range []string{"FROM alpine:3.20", "FROM scratch", "FROM ${BASE}", "FROM alpine@sha256:abc", "FROM localhost:5000/app:1.0"} {
+		if got := Lint(Dockerfile, pinned); got != nil {
+			t.Errorf("Lint(%q) = %+v, want no findings", pinned, got)
+		}
+	}
+	if got := Lint(Dockerfile, "FROM localhost:5000/app:latest"); len(got) != 1 {
+		t.Errorf("Lint(latest tag) = %+v, want one finding", got)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, s := range []string{"", "auto", "command", "dockerfile", "compose"} {
+		if _, err := ParseFormat(s); err != nil {
+			t.Errorf("ParseFormat(%q) error = %v", s, err)
+		}
+	}
+	if _, err := ParseFormat("helm"); err == nil {
+		t.Error("ParseFormat(\"helm\") expected error")
+	}
+}
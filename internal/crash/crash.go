@@ -0,0 +1,147 @@
+// Package crash writes reports of qcmd panicking, for bug reports. A
+// report holds what is needed to find the bug (the stack, qcmd's version,
+// the platform and the flags used) but not what the user typed: arguments
+// other than flags are redacted, and so are panic values that may quote
+// them, unless the user opts in.
+package crash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxReports is how many reports are kept; writing one removes the oldest
+// beyond it.
+const MaxReports = 10
+
+// Redacted replaces what the user typed in a report.
+const Redacted = "[redacted]"
+
+// filePrefix starts the name of every report, which continues with the
+// time, so reports sort oldest first.
+const filePrefix = "crash-"
+
+// Report describes a panic.
+type Report struct {
+	Time      time.Time
+	Version   string
+	GoVersion string
+	OS        string
+	Arch      string
+	Args      []string
+	Panic     string
+	Stack     []byte
+}
+
+// New returns a report of the panic value v, recovered with stack, in this
+// process. Unless includeQuery is set, args and v are redacted: the first
+// argument is kept only if keepFirst says it is a subcommand.
+func New(v any, stack []byte, version string, args []string, keepFirst, includeQuery bool) Report {
+	r := Report{
+		Time:      time.Now(),
+		Version:   version,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Args:      args,
+		Panic:     fmt.Sprint(v),
+		Stack:     stack,
+	}
+	if !includeQuery {
+		r.Args = RedactArgs(args, keepFirst)
+		r.Panic = Describe(v)
+	}
+	return r
+}
+
+// RedactArgs returns args with everything but flag names redacted:
+// positional arguments, which make up the query, and flag values, which
+// may be part of it. The first argument is kept if keepFirst is set.
+func RedactArgs(args []string, keepFirst bool) []string {
+	redacted := make([]string, len(args))
+	positional := false
+	for i, arg := range args {
+		switch {
+		case i == 0 && keepFirst:
+			redacted[i] = arg
+		case positional, arg == "-", !strings.HasPrefix(arg, "-"):
+			redacted[i] = Redacted
+		case arg == "--":
+			// Everything after it is positional.
+			redacted[i] = arg
+			positional = true
+		default:
+			name, _, hasValue := strings.Cut(arg, "=")
+			if hasValue {
+				name += "=" + Redacted
+			}
+			redacted[i] = name
+		}
+	}
+	return redacted
+}
+
+// Describe returns the panic value v for a report. Messages of runtime
+// errors, such as an index out of range, are kept; other values may quote
+// the query, so only their type is.
+func Describe(v any) string {
+	var rerr runtime.Error
+	if err, ok := v.(error); ok && errors.As(err, &rerr) {
+		return rerr.Error()
+	}
+	return fmt.Sprintf("%T value %s", v, Redacted)
+}
+
+// Format returns the report as text.
+func (r Report) Format() string {
+	var b strings.Builder
+	b.WriteString("qcmd crash report\n\n")
+	fmt.Fprintf(&b, "time:    %s\n", r.Time.Format(time.RFC3339))
+	fmt.Fprintf(&b, "version: %s\n", r.Version)
+	fmt.Fprintf(&b, "go:      %s\n", r.GoVersion)
+	fmt.Fprintf(&b, "os:      %s/%s\n", r.OS, r.Arch)
+	fmt.Fprintf(&b, "args:    %s\n", strings.Join(r.Args, " "))
+	fmt.Fprintf(&b, "\npanic: %s\n\n", r.Panic)
+	b.Write(r.Stack)
+	return b.String()
+}
+
+// Write saves r as a new file in dir, readable only by the user, and
+// returns its path. The oldest reports beyond MaxReports are removed.
+func Write(dir string, r Report) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating crash report directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, filePrefix+r.Time.Format("20060102-150405")+"-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("creating crash report: %w", err)
+	}
+	if _, err := f.WriteString(r.Format()); err != nil {
+		f.Close()
+		return "", fmt.Errorf("writing crash report: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("writing crash report: %w", err)
+	}
+	prune(dir)
+	return f.Name(), nil
+}
+
+// prune removes the oldest reports in dir beyond MaxReports. Reports that
+// cannot be removed are left for next time.
+func prune(dir string) {
+	names, err := filepath.Glob(filepath.Join(dir, filePrefix+"*.txt"))
+	if err != nil || len(names) <= MaxReports {
+		return
+	}
+	sort.Strings(names)
+	for _, name := range names[:len(names)-MaxReports] {
+		os.Remove(name)
+	}
+}
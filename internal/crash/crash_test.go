@@ -0,0 +1,135 @@
+package crash
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		keepFirst bool
+		want      []string
+	}{
+		{"query", []string{"find", "big", "files"}, false, []string{Redacted, Redacted, Redacted}},
+		{"subcommand", []string{"explain", "tar", "xzf"}, true, []string{"explain", Redacted, Redacted}},
+		{"flags kept", []string{"-x", "--verbose", "list"}, false, []string{"-x", "--verbose", Redacted}},
+		{"flag values", []string{"--backend", "openai", "--model=gpt-5o"}, false, []string{"--backend", Redacted, "--model=" + Redacted}},
+		{"stdin", []string{"--query-file", "-"}, false, []string{"--query-file", Redacted}},
+		{"after --", []string{"--", "-rf", "query"}, false, []string{"--", Redacted, Redacted}},
+		{"none", []string{}, true, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactArgs(tt.args, tt.keepFirst); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RedactArgs(%q, %v) = %q, want %q", tt.args, tt.keepFirst, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	var runtimeErr error
+	func() {
+		defer func() { runtimeErr = recover().(error) }()
+		var s []int
+		_ = s[len(s)]
+	}()
+
+	tests := []struct {
+		name string
+		v    any
+		want string
+	}{
+		{"runtime error", runtimeErr, runtimeErr.Error()},
+		{"string", "no command for list secret-project files", "string value " + Redacted},
+		{"error", errors.New("query secret-project failed"), "*errors.errorString value " + Redacted},
+		{"wrapped runtime error", fmt.Errorf("parsing secret-project: %w", runtimeErr), runtimeErr.Error()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Describe(tt.v); got != tt.want {
+				t.Errorf("Describe() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	args := []string{"explain", "secret-project"}
+
+	r := New("bad secret-project", []byte("stack"), "1.2.3", args, true, false)
+	if report := r.Format(); strings.Contains(report, "secret-project") {
+		t.Errorf("report quotes the query:\n%s", report)
+	}
+	if r.Version != "1.2.3" || r.OS == "" || r.GoVersion == "" || string(r.Stack) != "stack" {
+		t.Errorf("New() = %+v", r)
+	}
+
+	r = New("bad secret-project", nil, "1.2.3", args, true, true)
+	if r.Panic != "bad secret-project" || !reflect.DeepEqual(r.Args, args) {
+		t.Errorf("New() with the query included = %+v", r)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	r := Report{
+		Time:      time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Version:   "1.2.3",
+		GoVersion: "go1.21.0",
+		OS:        "linux",
+		Arch:      "amd64",
+		Args:      []string{"--verbose", Redacted},
+		Panic:     "runtime error: index out of range [1] with length 1",
+		Stack:     []byte("goroutine 1 [running]:\nmain.run()\n"),
+	}
+
+	path, err := Write(dir, r)
+	if err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"version: 1.2.3", "os:      linux/amd64", "args:    --verbose " + Redacted, "panic: runtime error", "main.run()"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("report lacks %q:\n%s", want, data)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("report permissions = %o, want 600", perm)
+	}
+
+	// Older reports make way for new ones.
+	for i := 0; i < MaxReports+2; i++ {
+		r.Time = r.Time.Add(time.Second)
+		if path, err = Write(dir, r); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != MaxReports {
+		t.Errorf("%d reports kept, want %d", len(entries), MaxReports)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("newest report removed: %v", err)
+	}
+}
@@ -0,0 +1,364 @@
+// Package cron parses crontab lines and describes their schedules in
+// plain English.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Entry is a parsed crontab line.
+type Entry struct {
+	// Schedule is the schedule as written: five fields or a macro such
+	// as "@daily".
+	Schedule string
+	// Command is the rest of the line, exactly as written.
+	Command string
+
+	fields []field // nil for @reboot
+}
+
+// field is one parsed schedule field.
+type field struct {
+	spec  fieldSpec
+	items []item
+}
+
+// item is one comma-separated part of a field: a value, a range, or every
+// value ("*"), with an optional step.
+type item struct {
+	any      bool
+	from, to int
+	step     int // 0 when there is none
+}
+
+// fieldSpec describes what a field may contain.
+type fieldSpec struct {
+	name     string
+	unit     string
+	min, max int
+	names    []string // names for min, min+1, ...
+}
+
+var (
+	minuteSpec = fieldSpec{name: "minute", unit: "minute", min: 0, max: 59}
+	hourSpec   = fieldSpec{name: "hour", unit: "hour", min: 0, max: 23}
+	domSpec    = fieldSpec{name: "day-of-month", unit: "day", min: 1, max: 31}
+	monthSpec  = fieldSpec{name: "month", unit: "month", min: 1, max: 12, names: []string{
+		"January", "February", "March", "April", "May", "June",
+		"July", "August", "September", "October", "November", "December",
+	}}
+	// Both 0 and 7 are Sunday.
+	dowSpec = fieldSpec{name: "day-of-week", unit: "day of the week", min: 0, max: 7, names: []string{
+		"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday",
+	}}
+
+	specs = []fieldSpec{minuteSpec, hourSpec, domSpec, monthSpec, dowSpec}
+)
+
+// macros maps the schedule shorthands to their five fields. @reboot is
+// handled separately, as it is not a time.
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a crontab line: a schedule followed by a command. It rejects
+// unescaped "%" in the command, which cron would turn into a newline.
+func Parse(line string) (Entry, error) {
+	line = strings.TrimSpace(line)
+	if strings.Contains(line, "\n") {
+		return Entry{}, errors.New("a crontab entry must be a single line")
+	}
+
+	var e Entry
+	rest := line
+	if strings.HasPrefix(line, "@") {
+		e.Schedule, rest = cut(line)
+		if e.Schedule != "@reboot" {
+			fields, ok := macros[e.Schedule]
+			if !ok {
+				return Entry{}, fmt.Errorf("unknown schedule %q", e.Schedule)
+			}
+			e.fields, _ = parseFields(strings.Fields(fields))
+		}
+	} else {
+		var parts []string
+		for range specs {
+			var part string
+			part, rest = cut(rest)
+			if part == "" {
+				return Entry{}, fmt.Errorf("a crontab entry needs five schedule fields and a command")
+			}
+			parts = append(parts, part)
+		}
+		fields, err := parseFields(parts)
+		if err != nil {
+			return Entry{}, err
+		}
+		e.Schedule = strings.Join(parts, " ")
+		e.fields = fields
+	}
+
+	e.Command = rest
+	if e.Command == "" {
+		return Entry{}, fmt.Errorf("the crontab entry has no command")
+	}
+	for i := 0; i < len(e.Command); i++ {
+		switch e.Command[i] {
+		case '\\':
+			i++
+		case '%':
+			return Entry{}, fmt.Errorf(`unescaped %% in the command; cron turns it into a newline, so write \%% instead`)
+		}
+	}
+	return e, nil
+}
+
+// cut splits off the first whitespace-separated word of s.
+func cut(s string) (word, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimLeft(s[i:], " \t")
+	}
+	return s, ""
+}
+
+// parseFields parses the five schedule fields.
+func parseFields(parts []string) ([]field, error) {
+	fields := make([]field, len(specs))
+	for i, spec := range specs {
+		f, err := parseField(parts[i], spec)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = f
+	}
+	return fields, nil
+}
+
+// parseField parses one schedule field, such as "*/15" or "1-5".
+func parseField(s string, spec fieldSpec) (field, error) {
+	f := field{spec: spec}
+	for _, part := range strings.Split(s, ",") {
+		it, err := parseItem(part, spec)
+		if err != nil {
+			return field{}, fmt.Errorf("invalid %s field %q: %w", spec.name, s, err)
+		}
+		f.items = append(f.items, it)
+	}
+	return f, nil
+}
+
+// parseItem parses one comma-separated part of a field.
+func parseItem(s string, spec fieldSpec) (item, error) {
+	var it item
+	base, step, hasStep := strings.Cut(s, "/")
+	if hasStep {
+		n, err := strconv.Atoi(step)
+		if err != nil || n < 1 {
+			return item{}, fmt.Errorf("invalid step %q", step)
+		}
+		it.step = n
+	}
+
+	if base == "*" {
+		it.any = true
+		it.from, it.to = spec.min, spec.max
+		return it, nil
+	}
+	from, to, isRange := strings.Cut(base, "-")
+	var err error
+	if it.from, err = parseValue(from, spec); err != nil {
+		return item{}, err
+	}
+	it.to = it.from
+	if isRange {
+		if it.to, err = parseValue(to, spec); err != nil {
+			return item{}, err
+		}
+		if it.to < it.from {
+			return item{}, fmt.Errorf("range %q runs backwards", base)
+		}
+	} else if hasStep {
+		// "5/15" means from 5 to the end in steps of 15.
+		it.to = spec.max
+	}
+	return it, nil
+}
+
+// parseValue parses a number or, for months and days of the week, the
+// first three letters of a name.
+func parseValue(s string, spec fieldSpec) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < spec.min || n > spec.max {
+			return 0, fmt.Errorf("%d is out of range %d-%d", n, spec.min, spec.max)
+		}
+		return n, nil
+	}
+	if len(s) == 3 {
+		for i, name := range spec.names {
+			if strings.EqualFold(name[:3], s) {
+				return spec.min + i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid value %q", s)
+}
+
+// Describe explains when e runs, such as "at 02:30 on Monday through
+// Friday".
+func (e Entry) Describe() string {
+	if e.fields == nil {
+		return "at system startup"
+	}
+	minute, hour, dom, month, dow := e.fields[0], e.fields[1], e.fields[2], e.fields[3], e.fields[4]
+
+	when, clock := describeTime(minute, hour)
+	var days string
+	switch {
+	case dom.every() && dow.every():
+		// "every 15 minutes every day" says nothing more.
+		if clock {
+			days = "every day"
+		}
+	case dow.every():
+		days = "on day " + dom.describe() + " of the month"
+	case dom.every():
+		days = "on " + dow.describe()
+	default:
+		// cron runs the job when either day field matches.
+		days = "on day " + dom.describe() + " of the month or on " + dow.describe()
+	}
+	if days != "" {
+		when += " " + days
+	}
+	if !month.every() {
+		when += " in " + month.describe()
+	}
+	return when
+}
+
+// describeTime explains the minute and hour fields. clock reports whether
+// they name times of day, such as "at 09:00 and 17:00".
+func describeTime(minute, hour field) (when string, clock bool) {
+	if m, ok := minute.single(); ok {
+		if hours, ok := hour.values(); ok && len(hours) <= 6 {
+			times := make([]string, len(hours))
+			for i, h := range hours {
+				times[i] = fmt.Sprintf("%02d:%02d", h, m)
+			}
+			return "at " + join(times), true
+		}
+		switch {
+		case hour.every() && m == 0:
+			return "every hour", false
+		case hour.every():
+			return fmt.Sprintf("at minute %d past every hour", m), false
+		case hour.everyStep() && m == 0:
+			return hour.describe(), false
+		}
+		return fmt.Sprintf("at minute %d past hour %s", m, hour.describe()), false
+	}
+	switch {
+	case minute.every():
+		when = "every minute"
+	case minute.everyStep():
+		when = minute.describe()
+	default:
+		when = "at minute " + minute.describe()
+	}
+	if !hour.every() {
+		when += " during hour " + hour.describe()
+	}
+	return when, false
+}
+
+// every reports whether f is "*".
+func (f field) every() bool {
+	return len(f.items) == 1 && f.items[0].any && f.items[0].step <= 1
+}
+
+// everyStep reports whether f is "*/n".
+func (f field) everyStep() bool {
+	return len(f.items) == 1 && f.items[0].any && f.items[0].step > 1
+}
+
+// single returns f's value if it has only one.
+func (f field) single() (int, bool) {
+	if vs, ok := f.values(); ok && len(vs) == 1 {
+		return vs[0], true
+	}
+	return 0, false
+}
+
+// values returns f's values if it lists them one by one.
+func (f field) values() ([]int, bool) {
+	var vs []int
+	for _, it := range f.items {
+		if it.any || it.from != it.to {
+			return nil, false
+		}
+		vs = append(vs, it.from)
+	}
+	return vs, true
+}
+
+// describe lists f's items in words, such as "1 and 15" or "Monday
+// through Friday".
+func (f field) describe() string {
+	parts := make([]string, len(f.items))
+	for i, it := range f.items {
+		parts[i] = f.describeItem(it)
+	}
+	return join(parts)
+}
+
+// describeItem puts one item of f into words.
+func (f field) describeItem(it item) string {
+	var s string
+	switch {
+	case it.any:
+	case it.from == it.to:
+		return f.name(it.from)
+	default:
+		s = f.name(it.from) + " through " + f.name(it.to)
+	}
+	if it.step > 1 {
+		every := fmt.Sprintf("every %d %ss", it.step, f.spec.unit)
+		if f.spec.unit == "day of the week" {
+			every = fmt.Sprintf("every %d days of the week", it.step)
+		}
+		if s == "" {
+			return every
+		}
+		return every + " from " + s
+	}
+	if s == "" {
+		return "every " + f.spec.unit
+	}
+	return s
+}
+
+// name returns the name of value v in f.
+func (f field) name(v int) string {
+	if f.spec.names != nil {
+		return f.spec.names[v-f.spec.min]
+	}
+	return strconv.Itoa(v)
+}
+
+// join lists parts in English: "a", "a and b", "a, b and c".
+func join(parts []string) string {
+	if len(parts) <= 1 {
+		return strings.Join(parts, "")
+	}
+	return strings.Join(parts[:len(parts)-1], ", ") + " and " + parts[len(parts)-1]
+}
@@ -0,0 +1,69 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		line         string
+		wantSchedule string
+		wantCommand  string
+		wantDescribe string
+		wantErr      string
+	}{
+		{line: "30 2 * * * /usr/local/bin/backup.sh", wantSchedule: "30 2 * * *", wantCommand: "/usr/local/bin/backup.sh", wantDescribe: "at 02:30 every day"},
+		{line: "*/15 * * * * curl -fsS https://example.com/ping", wantSchedule: "*/15 * * * *", wantCommand: "curl -fsS https://example.com/ping", wantDescribe: "every 15 minutes"},
+		{line: "0 9,17 * * mon-fri   cd ~/app && make report", wantSchedule: "0 9,17 * * mon-fri", wantCommand: "cd ~/app && make report", wantDescribe: "at 09:00 and 17:00 on Monday through Friday"},
+		{line: "0 0 1,15 * * find /tmp -mtime +7 -delete", wantDescribe: "at 00:00 on day 1 and 15 of the month"},
+		{line: "0 */2 * * * uptime", wantDescribe: "every 2 hours"},
+		{line: "5 * * * * uptime", wantDescribe: "at minute 5 past every hour"},
+		{line: "0 * * * * uptime", wantDescribe: "every hour"},
+		{line: "* 9-17 * * * uptime", wantDescribe: "every minute during hour 9 through 17"},
+		{line: "0 4 * jan-mar 0 uptime", wantDescribe: "at 04:00 on Sunday in January through March"},
+		{line: "0 4 1 * 1 uptime", wantDescribe: "at 04:00 on day 1 of the month or on Monday"},
+		{line: "0 3 * * 7 uptime", wantDescribe: "at 03:00 on Sunday"},
+		{line: "@daily backup.sh", wantSchedule: "@daily", wantCommand: "backup.sh", wantDescribe: "at 00:00 every day"},
+		{line: "@weekly backup.sh", wantDescribe: "at 00:00 on Sunday"},
+		{line: "@reboot /opt/agent/start", wantSchedule: "@reboot", wantCommand: "/opt/agent/start", wantDescribe: "at system startup"},
+		{line: `0 0 * * * tar czf /backups/$(date +\%F).tgz /srv`, wantCommand: `tar czf /backups/$(date +\%F).tgz /srv`, wantDescribe: "at 00:00 every day"},
+
+		{line: "60 * * * * uptime", wantErr: "minute"},
+		{line: "0 24 * * * uptime", wantErr: "out of range"},
+		{line: "0 0 0 * * uptime", wantErr: "day-of-month"},
+		{line: "0 0 * 13 * uptime", wantErr: "month"},
+		{line: "0 0 * * 8 uptime", wantErr: "day-of-week"},
+		{line: "0 0 * * fri-mon uptime", wantErr: "backwards"},
+		{line: "*/0 * * * * uptime", wantErr: "step"},
+		{line: "0 0 * * *", wantErr: "no command"},
+		{line: "0 0 * *", wantErr: "five schedule fields"},
+		{line: "@sometimes uptime", wantErr: "unknown schedule"},
+		{line: "0 0 * * * date +%F > /tmp/today", wantErr: "unescaped %"},
+		{line: "0 0 * * * uptime\n0 1 * * * uptime", wantErr: "single line"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			e, err := Parse(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if tt.wantSchedule != "" && e.Schedule != tt.wantSchedule {
+				t.Errorf("Schedule = %q, want %q", e.Schedule, tt.wantSchedule)
+			}
+			if tt.wantCommand != "" && e.Command != tt.wantCommand {
+				t.Errorf("Command = %q, want %q", e.Command, tt.wantCommand)
+			}
+			if got := e.Describe(); got != tt.wantDescribe {
+				t.Errorf("Describe() = %q, want %q", got, tt.wantDescribe)
+			}
+		})
+	}
+}
@@ -27,6 +27,30 @@ func NewEditor(editorCmd string) *Editor {
 // Returns empty string if file is empty or only comments.
 // Returns error if editor fails to launch.
 func (e *Editor) GetInput(ctx context.Context) (string, error) {
+	content, err := e.edit(ctx, InputTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	// Process and return input
+	return ProcessInput(content), nil
+}
+
+// Edit opens the editor on initial (e.g. a generated command) below
+// ResultTemplate and returns the edited text with the template lines and
+// surrounding whitespace removed. Unlike GetInput, other comment lines are
+// kept since they may be part of a command.
+func (e *Editor) Edit(ctx context.Context, initial string) (string, error) {
+	content, err := e.edit(ctx, ResultTemplate+initial+"\n")
+	if err != nil {
+		return "", err
+	}
+	return ProcessResult(content), nil
+}
+
+// edit writes initial to a secure temp file, opens the editor on it and
+// returns the file contents after the editor exits.
+func (e *Editor) edit(ctx context.Context, initial string) (string, error) {
 	// Create secure temp file with 0600 permissions
 	tmpFile, err := os.CreateTemp("", "qcmd-*.txt")
 	if err != nil {
@@ -39,8 +63,8 @@ func (e *Editor) GetInput(ctx context.Context) (string, error) {
 		os.Remove(tmpPath)
 	}()
 
-	// Write template to file
-	if _, err := tmpFile.WriteString(InputTemplate); err != nil {
+	// Write initial content to file
+	if _, err := tmpFile.WriteString(initial); err != nil {
 		tmpFile.Close()
 		return "", fmt.Errorf("writing template: %w", err)
 	}
@@ -56,14 +80,29 @@ func (e *Editor) GetInput(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("closing temp file: %w", err)
 	}
 
+	if err := e.Open(ctx, tmpPath); err != nil {
+		return "", err
+	}
+
+	// Read file contents
+	content, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return "", fmt.Errorf("reading temp file: %w", err)
+	}
+
+	return string(content), nil
+}
+
+// Open opens the editor on an existing file and waits for it to exit.
+func (e *Editor) Open(ctx context.Context, path string) error {
 	// Get editor command
 	cmdParts := getEditorCommand(e.EditorCmd)
 	if len(cmdParts) == 0 {
-		return "", fmt.Errorf("no editor command found")
+		return fmt.Errorf("no editor command found")
 	}
 
-	// Append temp file path to command
-	cmdParts = append(cmdParts, tmpPath)
+	// Append file path to command
+	cmdParts = append(cmdParts, path)
 
 	// Create command with context
 	cmd := exec.CommandContext(ctx, cmdParts[0], cmdParts[1:]...)
@@ -75,19 +114,12 @@ func (e *Editor) GetInput(ctx context.Context) (string, error) {
 	if err := cmd.Run(); err != nil {
 		// Check if context was cancelled
 		if ctx.Err() != nil {
-			return "", fmt.Errorf("editor cancelled: %w", ctx.Err())
+			return fmt.Errorf("editor cancelled: %w", ctx.Err())
 		}
-		return "", fmt.Errorf("running editor: %w", err)
+		return fmt.Errorf("running editor: %w", err)
 	}
 
-	// Read file contents
-	content, err := os.ReadFile(tmpPath)
-	if err != nil {
-		return "", fmt.Errorf("reading temp file: %w", err)
-	}
-
-	// Process and return input
-	return ProcessInput(string(content)), nil
+	return nil
 }
 
 // ProcessInput cleans up raw editor input.
@@ -125,6 +157,18 @@ func ProcessInput(raw string) string {
 	return strings.TrimSpace(result)
 }
 
+// ProcessResult cleans up an edited command. It removes the lines starting
+// with ResultCommentPrefix and trims surrounding whitespace.
+func ProcessResult(raw string) string {
+	var lines []string
+	for _, line := range strings.Split(raw, "\n") {
+		if !strings.HasPrefix(line, ResultCommentPrefix) {
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
 // getEditorCommand returns the editor command split into executable and arguments.
 // It follows the precedence: override -> $VISUAL -> $EDITOR -> vi
 func getEditorCommand(override string) []string {
@@ -173,6 +217,16 @@ const InputTemplate = `# Describe the shell command you need
 
 `
 
+// ResultCommentPrefix starts the lines of ResultTemplate. Only these lines
+// are removed from an edited command.
+const ResultCommentPrefix = "#:"
+
+// ResultTemplate is shown above a generated command being edited.
+const ResultTemplate = `#: Edit the command, then save and quit
+#: Lines starting with #: are ignored; delete everything to cancel
+
+`
+
 // TempFilePrefix is the prefix used for temp files.
 const TempFilePrefix = "qcmd-"
 
@@ -372,3 +372,52 @@ func containsHelper(s, substr string) bool {
 func endsWith(s, suffix string) bool {
 	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
 }
+
+func TestEditPrefilled(t *testing.T) {
+	// Create a fake editor that rewrites the command it was given
+	tmpDir := t.TempDir()
+	fakeEditor := filepath.Join(tmpDir, "sed-editor.sh")
+
+	script := `#!/bin/sh
+sed 's/ls -la/ls -A/' "$1" > "$1.new" && mv "$1.new" "$1"
+`
+	if err := os.WriteFile(fakeEditor, []byte(script), 0755); err != nil {
+		t.Fatalf("creating fake editor: %v", err)
+	}
+
+	e := NewEditor(fakeEditor)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := e.Edit(ctx, "ls -la # list everything")
+	if err != nil {
+		t.Fatalf("Edit failed: %v", err)
+	}
+
+	// Trailing comments are part of the command and must be preserved.
+	expected := "ls -A # list everything"
+	if result != expected {
+		t.Errorf("Edit() = %q, want %q", result, expected)
+	}
+}
+
+func TestProcessResult(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"template removed", ResultTemplate + "ls -la\n", "ls -la"},
+		{"shell comments kept", ResultTemplate + "# list\nls -la # all\n", "# list\nls -la # all"},
+		{"multi-line kept", "#: header\ndocker run \\\n  nginx\n", "docker run \\\n  nginx"},
+		{"only template cancels", ResultTemplate, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ProcessResult(tt.input); got != tt.want {
+				t.Errorf("ProcessResult() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Package exitcode defines the process exit codes returned by qcmd.
+//
+// The codes are part of qcmd's public interface: shell wrappers such as
+// shell/qcmd.zsh branch on them instead of parsing stderr, so existing
+// values must never be renumbered.
+package exitcode
+
+const (
+	// Success means a command was generated and delivered.
+	Success = 0
+
+	// UserError covers invalid input, flags or configuration, and queries
+	// the model refused to answer.
+	UserError = 1
+
+	// SystemError covers unexpected failures: timeouts, malformed API
+	// responses, provider errors not covered by a more specific code.
+	SystemError = 2
+
+	// DangerBlocked means the generated command matched a dangerous
+	// pattern and was printed instead of being injected.
+	DangerBlocked = 3
+
+	// RateLimited means the provider rejected the request with HTTP 429.
+	RateLimited = 4
+
+	// AuthFailure means no API key was configured or the provider
+	// rejected it (HTTP 401/403).
+	AuthFailure = 5
+
+	// NetworkFailure means the provider, or for `qcmd version --check` the
+	// release feed, could not be reached.
+	NetworkFailure = 6
+
+	// EmptyOutput means the model returned nothing usable after
+	// sanitization.
+	EmptyOutput = 7
+
+	// LowConfidence means the model reported low confidence in the
+	// command (see advanced.min_confidence), so in zle mode it was printed
+	// instead of being injected.
+	LowConfidence = 8
+
+	// Ambiguous means the model needs a detail the query left out; stderr
+	// says which. Adding it to the query and asking again may succeed.
+	Ambiguous = 9
+
+	// Placeholders means the command still has placeholders to fill in,
+	// such as <bucket-name> or YOUR_TOKEN (see safety.block_placeholders),
+	// so in zle mode it was printed instead of being injected.
+	Placeholders = 10
+
+	// TooComplex means the command is longer or has a deeper pipeline than
+	// safety.max_length or safety.max_pipeline allow, so in zle mode it was
+	// printed for careful review instead of being injected.
+	TooComplex = 11
+)
@@ -0,0 +1,79 @@
+// Package filelock keeps qcmds running at once, such as two terminals
+// pressing the keybinding together, from corrupting the data files they
+// share. Lock serializes the updates of a file across processes, and
+// WriteFile replaces a file so that readers never see it half written.
+//
+// Records kept in the store are protected by SQLite's own locking instead.
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Timeout is how long Lock waits for another qcmd to release a file.
+const Timeout = 5 * time.Second
+
+// pollInterval is how often Lock tries again while a file is locked.
+const pollInterval = 10 * time.Millisecond
+
+// ErrTimeout is returned when a file stays locked for longer than Timeout.
+var ErrTimeout = errors.New("timed out waiting for another qcmd to release the file")
+
+// Lock takes an exclusive lock on path, waiting up to Timeout for another
+// process holding it. The lock is held on path+".lock", so path itself can
+// be replaced while locked. The returned function releases it.
+func Lock(path string) (unlock func(), err error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(Timeout)
+	for {
+		unlock, ok, err := tryLock(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("locking %s: %w", filepath.Base(path), err)
+		}
+		if ok {
+			return unlock, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("locking %s: %w", filepath.Base(path), ErrTimeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// WriteFile writes data to path with perm through a temporary file in the
+// same directory renamed over it, so a concurrent reader sees either the
+// old or the new contents. The directory is created if needed.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	// Each writer gets its own temporary file, so two cannot mix theirs.
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
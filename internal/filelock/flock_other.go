@@ -0,0 +1,30 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package filelock
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// staleAfter is how old a lock file must be to be taken as left behind by
+// a qcmd that crashed while holding it. Nothing holds a lock this long.
+const staleAfter = 2 * Timeout
+
+// tryLock creates lockPath, failing if it exists, without waiting. A lock
+// file older than staleAfter is removed.
+func tryLock(lockPath string) (func(), bool, error) {
+	f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if errors.Is(err, os.ErrExist) {
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleAfter {
+			os.Remove(lockPath)
+		}
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	f.Close()
+	return func() { os.Remove(lockPath) }, true, nil
+}
@@ -0,0 +1,227 @@
+// Package filter checks generated jq, awk and sed programs and tries them
+// on a sample of the user's input before they are offered.
+//
+// A program only runs on the sample if it is a pipeline of text tools that
+// read stdin and write stdout: no redirections, command substitutions or
+// lists, and no tool features that write files or start other commands.
+// The checks are conservative; a program that fails them is still offered,
+// just not tried first.
+package filter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// tools are the commands a program may use, and the check for each one's
+// arguments.
+var tools = map[string]func(args []string) error{
+	"jq":    nil,
+	"awk":   checkAwk,
+	"gawk":  checkAwk,
+	"mawk":  checkAwk,
+	"nawk":  checkAwk,
+	"sed":   checkSed,
+	"gsed":  checkSed,
+	"grep":  nil,
+	"egrep": nil,
+	"head":  nil,
+	"tail":  nil,
+	"cut":   nil,
+	"tr":    nil,
+	"wc":    nil,
+	"sort":  checkSort,
+	"uniq":  checkUniq,
+}
+
+var (
+	// awkEscapes are awk features that run commands or write files.
+	awkEscapes = regexp.MustCompile(`\bsystem\s*\(|\|\s*getline|\b(print|printf)\b[^;}]*(>|\|)|\bfflush\s*\(\s*"`)
+	// sedEscapes are sed commands and s/// flags that run commands or
+	// write files.
+	sedEscapes = regexp.MustCompile(`(^|[;{}\n])\s*([0-9$]+|/[^/]*/)?,?([0-9$]+|/[^/]*/)?\s*!?\s*[wWe](\s|;|$)|/[gpiImM0-9]*[we](\s|;|}|$)`)
+)
+
+// Check reports why program should not be run on a sample, or nil if it
+// may be.
+func Check(program string) error {
+	if err := checkUnquoted(program); err != nil {
+		return err
+	}
+	if pipelines := shellparse.Pipelines(program); len(pipelines) != 1 {
+		return errors.New("it is not a single pipeline")
+	}
+	for _, cmd := range shellparse.Commands(program) {
+		words := shellparse.Words(cmd)
+		if len(words) == 0 {
+			return errors.New("it has an empty command")
+		}
+		check, ok := tools[words[0]]
+		if !ok {
+			return fmt.Errorf("%s is not a text filter qcmd tries", words[0])
+		}
+		if check != nil {
+			if err := check(words[1:]); err != nil {
+				return fmt.Errorf("%s: %w", words[0], err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkUnquoted rejects redirections outside quotes and command
+// substitutions outside single quotes.
+func checkUnquoted(program string) error {
+	var quote byte
+	for i := 0; i < len(program); i++ {
+		c := program[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+			continue
+		case c == '\\':
+			i++
+			continue
+		case c == '`' || c == '$' && strings.HasPrefix(program[i:], "$("):
+			return errors.New("it uses command substitution")
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '>' || c == '<':
+			return errors.New("it redirects input or output")
+		}
+	}
+	return nil
+}
+
+// checkAwk rejects awk programs that run commands or write files.
+func checkAwk(args []string) error {
+	for _, arg := range args {
+		if arg == "-f" || strings.HasPrefix(arg, "--file") {
+			return errors.New("the program is read from a file")
+		}
+		if awkEscapes.MatchString(arg) {
+			return errors.New("the program runs commands or writes files")
+		}
+	}
+	return nil
+}
+
+// checkSed rejects in-place editing and sed scripts that run commands or
+// write files.
+func checkSed(args []string) error {
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "--in-place"):
+			return errors.New("it edits files in place")
+		case strings.HasPrefix(arg, "--"):
+		case strings.HasPrefix(arg, "-") && strings.ContainsRune(arg, 'i'):
+			return errors.New("it edits files in place")
+		case arg == "-f" || strings.HasPrefix(arg, "--file"):
+			return errors.New("the script is read from a file")
+		case sedEscapes.MatchString(arg):
+			return errors.New("the script runs commands or writes files")
+		}
+	}
+	return nil
+}
+
+// checkSort rejects writing the result to a file.
+func checkSort(args []string) error {
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--output") || strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.ContainsRune(arg, 'o') {
+			return errors.New("it writes to a file")
+		}
+	}
+	return nil
+}
+
+// checkUniq rejects file operands, as a second one is written to.
+func checkUniq(args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			return errors.New("it names files")
+		}
+	}
+	return nil
+}
+
+// Result is what a program printed when run on a sample.
+type Result struct {
+	Output    []byte
+	Truncated bool // Output holds only the first MaxOutput bytes
+	Stderr    string
+	ExitCode  int
+}
+
+// Limits on a run on a sample.
+const (
+	Timeout   = 5 * time.Second
+	MaxOutput = 64 << 10
+)
+
+// Run runs program with /bin/sh, reading input, and returns what it
+// printed. It is stopped after Timeout.
+func Run(ctx context.Context, program string, input io.Reader) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	var stdout limitedBuffer
+	var stderr bytes.Buffer
+	stdout.max = MaxOutput
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", program)
+	cmd.Stdin = input
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Commands later in a pipeline may hold the output open after the
+	// shell is killed.
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	result := Result{Output: stdout.buf.Bytes(), Truncated: stdout.truncated, Stderr: strings.TrimSpace(stderr.String())}
+	if ctx.Err() == context.DeadlineExceeded {
+		return result, fmt.Errorf("stopped after %s", Timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	return result, err
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the
+// rest, so a runaway program cannot exhaust memory.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:room])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// Command returns the command that applies program to the file at path.
+func Command(program, path string) string {
+	return program + " < '" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
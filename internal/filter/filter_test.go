@@ -0,0 +1,84 @@
+package filter
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		program string
+		wantErr string
+	}{
+		{`jq -r '.users[].email'`, ""},
+		{`jq -r '.[] | "\(.name): \(.email)"'`, ""},
+		{`awk -F, '$3 > 100 { print $1 }'`, ""},
+		{`awk '{ n[$1]++ } END { for (k in n) print k, n[k] }' | sort -rn | head -5`, ""},
+		{`sed -n 's/.*email=\([^&]*\).*/\1/p'`, ""},
+		{`sed -E 's/a/e/g'`, ""},
+		{`grep -o '[a-z]*@[a-z.]*' | sort | uniq -c`, ""},
+		{`jq . > out.json`, "redirects"},
+		{`jq "$(cat filter.jq)"`, "command substitution"},
+		{"jq `cat f`", "command substitution"},
+		{`jq . ; rm -rf ~`, "single pipeline"},
+		{`python3 -c 'print(1)'`, "not a text filter"},
+		{`xargs rm`, "not a text filter"},
+		{`awk '{ system("rm " $1) }'`, "runs commands"},
+		{`awk '{ print $1 > "out.txt" }'`, "runs commands"},
+		{`awk '{ "date" | getline d }'`, "runs commands"},
+		{`awk -f prog.awk`, "from a file"},
+		{`sed -i 's/a/b/'`, "in place"},
+		{`sed -Ei 's/a/b/'`, "in place"},
+		{`sed --in-place=.bak 's/a/b/'`, "in place"},
+		{`sed 's/a/b/w out.txt'`, "runs commands"},
+		{`sed '1e date'`, "runs commands"},
+		{`sed -n '/x/w out.txt'`, "runs commands"},
+		{`sort -o sorted.txt`, "writes to a file"},
+		{`uniq - out.txt`, "names files"},
+	}
+	for _, tt := range tests {
+		err := Check(tt.program)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Check(%q) error = %v", tt.program, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Check(%q) error = %v, want one mentioning %q", tt.program, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	result, err := Run(context.Background(), `awk -F, '{ print $2 }'`, strings.NewReader("a,1\nb,2\n"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if string(result.Output) != "1\n2\n" || result.ExitCode != 0 {
+		t.Errorf("Run() = %+v, want output %q", result, "1\n2\n")
+	}
+
+	result, err = Run(context.Background(), `echo oops >&2; exit 3`, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.ExitCode != 3 || result.Stderr != "oops" {
+		t.Errorf("Run() = %+v, want exit code 3 and stderr %q", result, "oops")
+	}
+
+	result, err = Run(context.Background(), `head -c 100000 /dev/zero`, strings.NewReader(""))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.Truncated || len(result.Output) != MaxOutput {
+		t.Errorf("Run(head) kept %d bytes, truncated=%v; want %d, true", len(result.Output), result.Truncated, MaxOutput)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if got, want := Command("jq .", "it's.json"), `jq . < 'it'\''s.json'`; got != want {
+		t.Errorf("Command() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+// Package gitsync shares snippets through a user-specified git repository.
+//
+// The repository is cloned into a private checkout; only the snippets file
+// is exchanged with it, so personal history never leaves the machine.
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/user/qcmd/internal/snippets"
+)
+
+// DefaultCommitMessage is used for commits created by Push.
+const DefaultCommitMessage = "qcmd: update snippets"
+
+// ErrNoRemote is returned when sync is used without a configured remote.
+var ErrNoRemote = errors.New("no sync remote configured")
+
+// Repo is a local checkout of the shared snippets repository.
+type Repo struct {
+	// Dir is the local checkout, created on first use.
+	Dir string
+	// Remote is the git URL or path of the shared repository.
+	Remote string
+	// Branch is the branch to pull from and push to.
+	Branch string
+}
+
+// Pull updates the checkout and merges the shared snippets into the local
+// snippets file at localPath. Shared snippets win on name conflicts, since
+// they are the team-vetted versions. It returns the number of shared
+// snippets.
+func (r *Repo) Pull(ctx context.Context, localPath string) (int, error) {
+	if err := r.update(ctx); err != nil {
+		return 0, err
+	}
+
+	shared, err := snippets.Load(r.snippetsPath())
+	if err != nil {
+		return 0, err
+	}
+	err = snippets.Update(localPath, func(local []snippets.Snippet) ([]snippets.Snippet, error) {
+		return snippets.Merge(local, shared), nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(shared), nil
+}
+
+// Push merges the local snippets file into the shared repository and pushes
+// the result. Local snippets win on name conflicts. It returns the number
+// of snippets in the shared file after the merge.
+func (r *Repo) Push(ctx context.Context, localPath string) (int, error) {
+	if err := r.update(ctx); err != nil {
+		return 0, err
+	}
+
+	shared, err := snippets.Load(r.snippetsPath())
+	if err != nil {
+		return 0, err
+	}
+	local, err := snippets.Load(localPath)
+	if err != nil {
+		return 0, err
+	}
+
+	merged := snippets.Merge(shared, local)
+	if len(merged) == 0 {
+		return 0, nil
+	}
+	if err := snippets.Save(r.snippetsPath(), merged); err != nil {
+		return 0, err
+	}
+
+	if _, err := r.git(ctx, "add", snippets.FileName); err != nil {
+		return 0, err
+	}
+	// diff --cached --quiet exits 1 when there is something to commit.
+	if _, err := r.git(ctx, "diff", "--cached", "--quiet"); err != nil {
+		if _, err := r.git(ctx, "commit", "-m", DefaultCommitMessage); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := r.git(ctx, "push", "origin", "HEAD:refs/heads/"+r.Branch); err != nil {
+		return 0, err
+	}
+
+	return len(merged), nil
+}
+
+// snippetsPath returns the path of the shared snippets file in the checkout.
+func (r *Repo) snippetsPath() string {
+	return filepath.Join(r.Dir, snippets.FileName)
+}
+
+// update creates the checkout if needed and rebases it onto the remote
+// branch when that branch exists.
+func (r *Repo) update(ctx context.Context) error {
+	if r.Remote == "" {
+		return ErrNoRemote
+	}
+
+	if _, err := os.Stat(filepath.Join(r.Dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(r.Dir, 0700); err != nil {
+			return fmt.Errorf("creating sync directory: %w", err)
+		}
+		if _, err := r.git(ctx, "init", "--quiet"); err != nil {
+			return err
+		}
+		if _, err := r.git(ctx, "symbolic-ref", "HEAD", "refs/heads/"+r.Branch); err != nil {
+			return err
+		}
+		if _, err := r.git(ctx, "remote", "add", "origin", r.Remote); err != nil {
+			return err
+		}
+	} else {
+		if _, err := r.git(ctx, "remote", "set-url", "origin", r.Remote); err != nil {
+			return err
+		}
+		if err := r.discardChanges(ctx); err != nil {
+			return err
+		}
+	}
+
+	// ls-remote --exit-code exits 2 when the branch does not exist yet,
+	// which is expected for a freshly created shared repository.
+	if _, err := r.git(ctx, "ls-remote", "--exit-code", "--heads", "origin", r.Branch); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			return nil
+		}
+		return err
+	}
+
+	_, err := r.git(ctx, "pull", "--quiet", "--rebase", "origin", r.Branch)
+	return err
+}
+
+// discardChanges drops anything left uncommitted by an earlier failed push.
+// The checkout is private to qcmd, so the shared state is always what was
+// last committed.
+func (r *Repo) discardChanges(ctx context.Context) error {
+	if _, err := r.git(ctx, "rev-parse", "--verify", "--quiet", "HEAD"); err != nil {
+		// Nothing committed yet: the snippets file can only be a leftover.
+		if err := os.Remove(r.snippetsPath()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("resetting sync checkout: %w", err)
+		}
+		return nil
+	}
+	_, err := r.git(ctx, "reset", "--quiet", "--hard", "HEAD")
+	return err
+}
+
+// git runs a git command in the checkout and returns its trimmed output.
+func (r *Repo) git(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", r.Dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
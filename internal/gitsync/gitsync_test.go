@@ -0,0 +1,108 @@
+package gitsync
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/user/qcmd/internal/snippets"
+)
+
+// newRemote creates an empty bare repository to act as the shared remote.
+func newRemote(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	t.Setenv("GIT_AUTHOR_NAME", "qcmd test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "qcmd@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "qcmd test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "qcmd@example.com")
+
+	remote := filepath.Join(t.TempDir(), "shared.git")
+	if out, err := exec.Command("git", "init", "--bare", "--quiet", remote).CombinedOutput(); err != nil {
+		t.Fatalf("git init --bare: %v: %s", err, out)
+	}
+	return remote
+}
+
+func TestPushPull(t *testing.T) {
+	ctx := context.Background()
+	remote := newRemote(t)
+
+	// Alice shares a snippet.
+	aliceDir := t.TempDir()
+	alice := &Repo{Dir: filepath.Join(aliceDir, "sync"), Remote: remote, Branch: "main"}
+	aliceLocal := filepath.Join(aliceDir, snippets.FileName)
+	if err := snippets.Save(aliceLocal, []snippets.Snippet{{Name: "ports", Command: "ss -tulpn"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := alice.Push(ctx, aliceLocal); err != nil || n != 1 {
+		t.Fatalf("alice Push() = %d, %v; want 1, nil", n, err)
+	}
+
+	// Bob has a stale local version of the same snippet and a private one.
+	bobDir := t.TempDir()
+	bob := &Repo{Dir: filepath.Join(bobDir, "sync"), Remote: remote, Branch: "main"}
+	bobLocal := filepath.Join(bobDir, snippets.FileName)
+	if err := snippets.Save(bobLocal, []snippets.Snippet{
+		{Name: "ports", Command: "netstat -tulpn"},
+		{Name: "mine", Command: "echo private"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := bob.Pull(ctx, bobLocal); err != nil || n != 1 {
+		t.Fatalf("bob Pull() = %d, %v; want 1, nil", n, err)
+	}
+
+	got, err := snippets.Load(bobLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := snippets.Find(got, "ports"); s.Command != "ss -tulpn" {
+		t.Errorf("shared snippet should win on pull, got %q", s.Command)
+	}
+	if _, ok := snippets.Find(got, "mine"); !ok {
+		t.Error("pull dropped a local-only snippet")
+	}
+
+	// Bob pushes his private snippet; Alice pulls it back.
+	if n, err := bob.Push(ctx, bobLocal); err != nil || n != 2 {
+		t.Fatalf("bob Push() = %d, %v; want 2, nil", n, err)
+	}
+	if n, err := alice.Pull(ctx, aliceLocal); err != nil || n != 2 {
+		t.Fatalf("alice Pull() = %d, %v; want 2, nil", n, err)
+	}
+	got, err = snippets.Load(aliceLocal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := snippets.Find(got, "mine"); !ok {
+		t.Error("alice did not receive bob's snippet")
+	}
+
+	// Pushing again without changes is a no-op rather than an error.
+	if _, err := alice.Push(ctx, aliceLocal); err != nil {
+		t.Errorf("Push() without changes error = %v", err)
+	}
+}
+
+func TestPullEmptyRemote(t *testing.T) {
+	remote := newRemote(t)
+	dir := t.TempDir()
+	r := &Repo{Dir: filepath.Join(dir, "sync"), Remote: remote, Branch: "main"}
+
+	n, err := r.Pull(context.Background(), filepath.Join(dir, snippets.FileName))
+	if err != nil || n != 0 {
+		t.Errorf("Pull() on empty remote = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestNoRemote(t *testing.T) {
+	r := &Repo{Dir: t.TempDir(), Branch: "main"}
+	if _, err := r.Pull(context.Background(), "unused"); !errors.Is(err, ErrNoRemote) {
+		t.Errorf("Pull() error = %v, want ErrNoRemote", err)
+	}
+}
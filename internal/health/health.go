@@ -0,0 +1,156 @@
+// Package health remembers recent failures of each backend, so qcmd can
+// stop waiting on a provider that is down instead of sitting through the
+// full timeout on every invocation (a circuit breaker).
+//
+// After Policy.Failures failures within Policy.Window, a backend's circuit
+// opens and it is skipped for Policy.Cooldown. The next call after that is
+// a trial: success closes the circuit, another failure opens it again.
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// FileName is the name of the health file in the state directory.
+const FileName = "health.json"
+
+// Policy says when a backend's circuit opens and for how long.
+type Policy struct {
+	// Failures within Window open the circuit; 0 disables the breaker.
+	Failures int
+	Window   time.Duration
+	Cooldown time.Duration
+}
+
+// State is the recent health of each backend, by name.
+type State struct {
+	Backends map[string]*Record `json:"backends"`
+}
+
+// Record is the recent health of one backend.
+type Record struct {
+	// Failures are the times of failures within the policy window,
+	// oldest first.
+	Failures []time.Time `json:"failures,omitempty"`
+	// OpenUntil is when the backend may be tried again, if its circuit
+	// is open.
+	OpenUntil time.Time `json:"open_until,omitempty"`
+}
+
+// Load reads the state from path. A missing file yields an empty state.
+func Load(path string) (*State, error) {
+	s := &State{Backends: make(map[string]*Record)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading backend health: %w", err)
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("parsing backend health: %w", err)
+	}
+	if s.Backends == nil {
+		s.Backends = make(map[string]*Record)
+	}
+	return s, nil
+}
+
+// Save writes the state to path, creating parent directories as needed.
+// The file is replaced atomically.
+func Save(path string, s *State) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("encoding backend health: %w", err)
+	}
+	if err := filelock.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("writing backend health: %w", err)
+	}
+	return nil
+}
+
+// Update applies fn to the state at path and, if fn reports a change,
+// saves it, holding a lock so that the outcomes of calls made by other
+// qcmds at the same time are not lost.
+func Update(path string, fn func(*State) bool) error {
+	unlock, err := filelock.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if !fn(s) {
+		return nil
+	}
+	return Save(path, s)
+}
+
+// Open reports whether the circuit of backend name is open at now, and if
+// so until when.
+func (s *State) Open(name string, now time.Time) (bool, time.Time) {
+	r := s.Backends[name]
+	if r == nil || !now.Before(r.OpenUntil) {
+		return false, time.Time{}
+	}
+	return true, r.OpenUntil
+}
+
+// RecentFailures returns how many failures of backend name are recorded
+// within p.Window before now.
+func (s *State) RecentFailures(name string, now time.Time, p Policy) int {
+	r := s.Backends[name]
+	if r == nil {
+		return 0
+	}
+	n := 0
+	for _, t := range r.Failures {
+		if now.Sub(t) < p.Window {
+			n++
+		}
+	}
+	return n
+}
+
+// RecordFailure records a failure of backend name at now, forgetting
+// failures older than p.Window, and opens its circuit if that makes
+// p.Failures. It reports whether the circuit opened.
+func (s *State) RecordFailure(name string, now time.Time, p Policy) bool {
+	r := s.Backends[name]
+	if r == nil {
+		r = &Record{}
+		s.Backends[name] = r
+	}
+	kept := r.Failures[:0]
+	for _, t := range r.Failures {
+		if now.Sub(t) < p.Window {
+			kept = append(kept, t)
+		}
+	}
+	r.Failures = append(kept, now)
+	if p.Failures > 0 && len(r.Failures) >= p.Failures {
+		r.OpenUntil = now.Add(p.Cooldown)
+		return true
+	}
+	return false
+}
+
+// RecordSuccess forgets the failures of backend name and closes its
+// circuit. It reports whether there was anything to forget, that is
+// whether the state changed.
+func (s *State) RecordSuccess(name string) bool {
+	if _, ok := s.Backends[name]; !ok {
+		return false
+	}
+	delete(s.Backends, name)
+	return true
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	p := Policy{Failures: 3, Window: 5 * time.Minute, Cooldown: time.Minute}
+	start := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	s := &State{Backends: make(map[string]*Record)}
+
+	steps := []struct {
+		at       time.Duration
+		failed   bool
+		wantOpen bool // after the step
+	}{
+		{0, true, false},
+		{4 * time.Minute, true, false},
+		// The first failure is out of the window by now.
+		{6 * time.Minute, true, false},
+		{7 * time.Minute, true, true},
+		// A trial after the cooldown fails: open again.
+		{8 * time.Minute, true, true},
+		// A trial that succeeds closes the circuit.
+		{9 * time.Minute, false, false},
+		{10 * time.Minute, true, false},
+	}
+	for i, step := range steps {
+		now := start.Add(step.at)
+		if step.failed {
+			s.RecordFailure("openai", now, p)
+		} else {
+			s.RecordSuccess("openai")
+		}
+		open, until := s.Open("openai", now)
+		if open != step.wantOpen {
+			t.Errorf("step %d: Open() = %v, want %v", i, open, step.wantOpen)
+		}
+		if open && !until.Equal(now.Add(p.Cooldown)) {
+			t.Errorf("step %d: Open() until %v, want %v", i, until, now.Add(p.Cooldown))
+		}
+	}
+
+	now := start.Add(10 * time.Minute)
+	if open, _ := s.Open("anthropic", now); open {
+		t.Error("Open() of a backend without failures = true")
+	}
+	if open, _ := s.Open("openai", now.Add(-time.Minute)); open {
+		t.Error("Open() after a successful trial = true")
+	}
+	if n := s.RecentFailures("openai", now, p); n != 1 {
+		t.Errorf("RecentFailures() = %d, want 1", n)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	s := &State{Backends: make(map[string]*Record)}
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		if s.RecordFailure("openai", now, Policy{Window: time.Minute}) {
+			t.Fatal("RecordFailure() opened the circuit with the breaker disabled")
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qcmd", FileName)
+	s, err := Load(path)
+	if err != nil || len(s.Backends) != 0 {
+		t.Fatalf("Load() of a missing file = %+v, %v", s, err)
+	}
+
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	s.RecordFailure("openai", now, Policy{Failures: 1, Window: time.Minute, Cooldown: time.Minute})
+	if err := Save(path, s); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, s) {
+		t.Errorf("Load() = %+v, want %+v", got.Backends["openai"], s.Backends["openai"])
+	}
+	if s.RecordSuccess("anthropic") || !s.RecordSuccess("openai") {
+		t.Error("RecordSuccess() misreported whether the state changed")
+	}
+
+	// Update saves only what fn reports as changed.
+	err = Update(path, func(s *State) bool { return s.RecordSuccess("anthropic") })
+	if err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if got, _ := Load(path); got.Backends["openai"] == nil {
+		t.Error("Update() saved a state fn left unchanged")
+	}
+	err = Update(path, func(s *State) bool { return s.RecordSuccess("openai") })
+	if err != nil {
+		t.Fatalf("Update() error: %v", err)
+	}
+	if got, _ := Load(path); len(got.Backends) != 0 {
+		t.Errorf("Update() kept %+v, want the openai record cleared", got.Backends)
+	}
+}
@@ -0,0 +1,150 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+// CorrectionsFileName is the name of the file in the data directory
+// corrections were kept in before the store; Import moves it into the
+// store.
+const CorrectionsFileName = "corrections.jsonl"
+
+// minSimilarity is the minimum word overlap (Jaccard index) between two
+// queries for a past correction to be considered related.
+const minSimilarity = 0.3
+
+// Correction records a generated command the user edited before running.
+type Correction struct {
+	Time      time.Time `json:"time"`
+	Query     string    `json:"query"`
+	Generated string    `json:"generated"`
+	Edited    string    `json:"edited"`
+}
+
+// LoadCorrections reads all corrections from s, oldest first.
+func LoadCorrections(s *store.Store) ([]Correction, error) {
+	rows, err := s.DB().Query("SELECT time, query, generated, edited FROM corrections ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("reading corrections: %w", err)
+	}
+	defer rows.Close()
+
+	var corrections []Correction
+	for rows.Next() {
+		var c Correction
+		var t string
+		if err := rows.Scan(&t, &c.Query, &c.Generated, &c.Edited); err != nil {
+			return nil, fmt.Errorf("reading corrections: %w", err)
+		}
+		if c.Time, err = store.ParseTime(t); err != nil {
+			return nil, fmt.Errorf("reading corrections: %w", err)
+		}
+		corrections = append(corrections, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading corrections: %w", err)
+	}
+	return corrections, nil
+}
+
+// AppendCorrection adds c to the corrections in s, setting the time if
+// unset.
+func AppendCorrection(s *store.Store, c Correction) error {
+	if c.Time.IsZero() {
+		c.Time = time.Now()
+	}
+	if err := insertCorrection(s.DB(), c); err != nil {
+		return fmt.Errorf("recording correction: %w", err)
+	}
+	return nil
+}
+
+// execer is a *sql.DB or *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// insertCorrection stores c.
+func insertCorrection(db execer, c Correction) error {
+	_, err := db.Exec("INSERT INTO corrections (time, query, generated, edited) VALUES (?, ?, ?, ?)",
+		store.FormatTime(c.Time), c.Query, c.Generated, c.Edited)
+	return err
+}
+
+// SimilarCorrections returns up to max corrections whose query shares
+// enough words with query, most similar first (newer first on ties).
+func SimilarCorrections(corrections []Correction, query string, max int) []Correction {
+	if max <= 0 {
+		return nil
+	}
+
+	words := wordSet(query)
+	type scored struct {
+		c     Correction
+		score float64
+		index int
+	}
+	var matches []scored
+	for i, c := range corrections {
+		if score := jaccard(words, wordSet(c.Query)); score >= minSimilarity {
+			matches = append(matches, scored{c, score, i})
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].index > matches[j].index
+	})
+
+	if len(matches) > max {
+		matches = matches[:max]
+	}
+	result := make([]Correction, len(matches))
+	for i, m := range matches {
+		result[i] = m.c
+	}
+	return result
+}
+
+// stopWords are ignored when comparing queries.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "in": true, "of": true, "to": true,
+	"for": true, "and": true, "or": true, "all": true, "with": true, "on": true,
+	"me": true, "my": true, "that": true, "this": true, "is": true, "are": true,
+}
+
+// wordSet returns the lowercased non-stop words of s.
+func wordSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '-'
+	}) {
+		if !stopWords[w] {
+			set[w] = true
+		}
+	}
+	return set
+}
+
+// jaccard returns the Jaccard index of two word sets.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// ExamplesFileName is the name of the few-shot examples file in the data
+// directory. It is plain TOML so users can curate it by hand.
+const ExamplesFileName = "examples.toml"
+
+// Example is a query paired with the command the user wants for it.
+type Example struct {
+	Query   string `toml:"query"`
+	Command string `toml:"command"`
+}
+
+// examplesFile is the on-disk layout of the examples file.
+type examplesFile struct {
+	Examples []Example `toml:"example"`
+}
+
+// LoadExamples reads few-shot examples from path, oldest first. A missing
+// file yields no examples.
+func LoadExamples(path string) ([]Example, error) {
+	var f examplesFile
+	if _, err := toml.DecodeFile(path, &f); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("parse examples: %w", err)
+	}
+	return f.Examples, nil
+}
+
+// AppendExample adds ex to the examples file at path. An existing example
+// for the same query is replaced, so re-correcting a query doesn't
+// accumulate contradicting pairs.
+func AppendExample(path string, ex Example) error {
+	unlock, err := filelock.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	examples, err := LoadExamples(path)
+	if err != nil {
+		return err
+	}
+
+	kept := examples[:0]
+	for _, e := range examples {
+		if e.Query != ex.Query {
+			kept = append(kept, e)
+		}
+	}
+	kept = append(kept, ex)
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(examplesFile{Examples: kept}); err != nil {
+		return fmt.Errorf("encode examples: %w", err)
+	}
+	if err := filelock.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("writing examples: %w", err)
+	}
+	return nil
+}
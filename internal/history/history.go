@@ -0,0 +1,259 @@
+// Package history records generated commands locally so they can be
+// annotated, recalled and learned from. History never leaves the machine.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+// FileName is the name of the file in the data directory history was kept
+// in before the store; Import moves it into the store.
+const FileName = "history.jsonl"
+
+// Feedback values recorded by `qcmd feedback`.
+const (
+	FeedbackGood = "good"
+	FeedbackBad  = "bad"
+)
+
+// ErrEmpty is returned when an operation needs an entry but history is empty.
+var ErrEmpty = errors.New("history is empty")
+
+// ErrNotFound is returned when no entry has the requested ID.
+var ErrNotFound = errors.New("no history entry with that ID")
+
+// Entry is a single generated command.
+type Entry struct {
+	ID       int       `json:"id"`
+	Time     time.Time `json:"time"`
+	Query    string    `json:"query"`
+	Command  string    `json:"command"`
+	Backend  string    `json:"backend,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Executed string    `json:"executed,omitempty"`
+	Feedback string    `json:"feedback,omitempty"`
+	Note     string    `json:"note,omitempty"`
+
+	// ExitCode and Stderr describe how Executed ended, with the last lines
+	// of its error output, when history.capture_exec is on. A zero ExitCode
+	// means it succeeded or was not captured.
+	ExitCode int    `json:"exit_code,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+
+	// Undo is a best-effort command reversing Command, when
+	// history.suggest_undo is on and one was found.
+	Undo string `json:"undo,omitempty"`
+}
+
+// entryColumns are the history columns in the order scanEntry reads them.
+const entryColumns = "id, time, query, command, backend, model, executed, feedback, note, exit_code, stderr, undo"
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanEntry reads an entry selected with entryColumns.
+func scanEntry(row rowScanner) (Entry, error) {
+	var e Entry
+	var t string
+	err := row.Scan(&e.ID, &t, &e.Query, &e.Command, &e.Backend, &e.Model, &e.Executed, &e.Feedback, &e.Note, &e.ExitCode, &e.Stderr, &e.Undo)
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Time, err = store.ParseTime(t)
+	return e, err
+}
+
+// Load reads all entries from s, oldest first; an entry generated again
+// counts from the last time.
+func Load(s *store.Store) ([]Entry, error) {
+	rows, err := s.DB().Query("SELECT " + entryColumns + " FROM history ORDER BY seq")
+	if err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("reading history: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	return entries, nil
+}
+
+// readLines decodes a JSON Lines file. A missing file yields no values.
+func readLines[T any](path string) ([]T, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", filepath.Base(path), err)
+	}
+
+	var values []T
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var v T
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			return nil, fmt.Errorf("parsing %s line %d: %w", filepath.Base(path), line, err)
+		}
+		values = append(values, v)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filepath.Base(path), err)
+	}
+	return values, nil
+}
+
+// Append adds e to the history in s, assigning the next ID and, if unset,
+// the current time. It returns the stored entry.
+func Append(s *store.Store, e Entry) (Entry, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	err := s.Tx(func(tx *sql.Tx) error {
+		// IDs are not in seq order once an entry has been repeated.
+		err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) + 1 FROM history").Scan(&e.ID)
+		if err != nil {
+			return err
+		}
+		return insertEntry(tx, e)
+	})
+	if err != nil {
+		return Entry{}, fmt.Errorf("recording history: %w", err)
+	}
+	return e, nil
+}
+
+// insertEntry stores e, with its ID, after every other entry.
+func insertEntry(tx *sql.Tx, e Entry) error {
+	_, err := tx.Exec(`INSERT INTO history (`+entryColumns+`, seq)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM history))`,
+		e.ID, store.FormatTime(e.Time), e.Query, e.Command, e.Backend, e.Model, e.Executed, e.Feedback, e.Note, e.ExitCode, e.Stderr, e.Undo)
+	return err
+}
+
+// LastExecuted returns the most recent of entries whose command was run,
+// or false if none was.
+func LastExecuted(entries []Entry) (Entry, bool) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Executed != "" {
+			return entries[i], true
+		}
+	}
+	return Entry{}, false
+}
+
+// UpdateLast applies fn to the most recent entry and stores it. It
+// returns the updated entry, or ErrEmpty if there is no history.
+func UpdateLast(s *store.Store, fn func(*Entry)) (Entry, error) {
+	var last Entry
+	err := s.Tx(func(tx *sql.Tx) error {
+		var err error
+		last, err = scanEntry(tx.QueryRow("SELECT " + entryColumns + " FROM history ORDER BY seq DESC LIMIT 1"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrEmpty
+		}
+		if err != nil {
+			return err
+		}
+		fn(&last)
+		return updateEntry(tx, last, false)
+	})
+	if errors.Is(err, ErrEmpty) {
+		return Entry{}, err
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("updating history: %w", err)
+	}
+	return last, nil
+}
+
+// updateEntry stores e over the entry with its ID, moving it after every
+// other entry if last is set.
+func updateEntry(tx *sql.Tx, e Entry, last bool) error {
+	if last {
+		if _, err := tx.Exec("DELETE FROM history WHERE id = ?", e.ID); err != nil {
+			return err
+		}
+		return insertEntry(tx, e)
+	}
+	_, err := tx.Exec(`UPDATE history SET time = ?, query = ?, command = ?, backend = ?, model = ?,
+		executed = ?, feedback = ?, note = ?, exit_code = ?, stderr = ?, undo = ? WHERE id = ?`,
+		store.FormatTime(e.Time), e.Query, e.Command, e.Backend, e.Model, e.Executed, e.Feedback, e.Note, e.ExitCode, e.Stderr, e.Undo, e.ID)
+	return err
+}
+
+// Duplicate returns the most recent of entries generated since since that
+// has the same command as e for the same or a similar query, or false if
+// there is none.
+func Duplicate(entries []Entry, e Entry, since time.Time) (Entry, bool) {
+	command := strings.TrimSpace(e.Command)
+	words := wordSet(e.Query)
+	for i := len(entries) - 1; i >= 0; i-- {
+		old := entries[i]
+		if old.Time.Before(since) {
+			continue
+		}
+		if strings.TrimSpace(old.Command) != command {
+			continue
+		}
+		if old.Query == e.Query || jaccard(words, wordSet(old.Query)) >= minSimilarity {
+			return old, true
+		}
+	}
+	return Entry{}, false
+}
+
+// Repeat records that the entry with id was generated again at t instead
+// of adding a copy: it makes the entry the most recent one, so
+// `qcmd feedback` annotates it, sets its time and applies fn to it. It
+// returns the updated entry, or ErrNotFound.
+func Repeat(s *store.Store, id int, t time.Time, fn func(*Entry)) (Entry, error) {
+	var e Entry
+	err := s.Tx(func(tx *sql.Tx) error {
+		var err error
+		e, err = scanEntry(tx.QueryRow("SELECT "+entryColumns+" FROM history WHERE id = ?", id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		e.Time = t
+		if fn != nil {
+			fn(&e)
+		}
+		return updateEntry(tx, e, true)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return Entry{}, err
+	}
+	if err != nil {
+		return Entry{}, fmt.Errorf("updating history: %w", err)
+	}
+	return e, nil
+}
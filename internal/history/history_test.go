@@ -0,0 +1,387 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+// openStore opens a new store in a temporary directory.
+func openStore(t *testing.T) *store.Store {
+	t.Helper()
+	s, err := store.Open(filepath.Join(t.TempDir(), store.FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestAppendAndLoad(t *testing.T) {
+	s := openStore(t)
+
+	entries, err := Load(s)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("Load() on a new store = %v, %v; want empty, nil", entries, err)
+	}
+
+	first, err := Append(s, Entry{Query: "list files", Command: "ls"})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	second, err := Append(s, Entry{Query: "disk usage", Command: "df -h", ExitCode: 1, Stderr: "df: /mnt: No such file"})
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	if first.ID != 1 || second.ID != 2 {
+		t.Errorf("IDs = %d, %d; want 1, 2", first.ID, second.ID)
+	}
+	if first.Time.IsZero() {
+		t.Error("Append() did not set Time")
+	}
+
+	entries, err = Load(s)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	second.Time = second.Time.Round(0) // stored without the monotonic clock
+	if len(entries) != 2 || !reflect.DeepEqual(entries[1], second) {
+		t.Errorf("Load() = %+v, want the second entry to be %+v", entries, second)
+	}
+}
+
+func TestAppendConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), store.FileName)
+
+	// Each qcmd, as in two terminals, opens the store itself.
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := store.Open(path)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer s.Close()
+			if _, err := Append(s, Entry{Query: "list files", Command: "ls"}); err != nil {
+				t.Errorf("Append() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	entries, err := Load(s)
+	if err != nil || len(entries) != n {
+		t.Fatalf("Load() = %d entries, %v; want %d", len(entries), err, n)
+	}
+	seen := make(map[int]bool)
+	for _, e := range entries {
+		if seen[e.ID] {
+			t.Errorf("ID %d used twice", e.ID)
+		}
+		seen[e.ID] = true
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	lines := map[string]string{
+		FileName: `{"id":4,"time":"2026-01-02T10:00:00Z","query":"list files","command":"ls","feedback":"good"}
+{"id":7,"time":"2026-01-03T10:00:00Z","query":"disk usage","command":"df -h"}
+`,
+		CorrectionsFileName: `{"time":"2026-01-02T10:00:00Z","query":"disk usage","generated":"df","edited":"df -h"}
+`,
+		UsageFileName: `{"time":"2026-01-02T10:00:00Z","backend":"openai","model":"gpt-4o","input_tokens":10,"output_tokens":2,"cost_usd":0.5}
+`,
+	}
+	for name, data := range lines {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := openStore(t)
+	if err := Import(s, dir); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	// The files are moved aside, so a second import adds nothing.
+	if err := Import(s, dir); err != nil {
+		t.Fatalf("second Import() error = %v", err)
+	}
+	for name := range lines {
+		if _, err := os.Stat(filepath.Join(dir, name+store.ImportedSuffix)); err != nil {
+			t.Errorf("%s was not moved aside: %v", name, err)
+		}
+	}
+
+	entries, err := Load(s)
+	if err != nil || len(entries) != 2 || entries[0].ID != 4 || entries[0].Feedback != FeedbackGood || entries[1].ID != 7 {
+		t.Fatalf("Load() after Import() = %+v, %v", entries, err)
+	}
+	if next, err := Append(s, Entry{Query: "uptime", Command: "uptime"}); err != nil || next.ID != 8 {
+		t.Errorf("Append() after Import() = #%d, %v; want #8", next.ID, err)
+	}
+	if corrections, err := LoadCorrections(s); err != nil || len(corrections) != 1 || corrections[0].Edited != "df -h" {
+		t.Errorf("LoadCorrections() after Import() = %+v, %v", corrections, err)
+	}
+	if usage, err := LoadUsage(s); err != nil || len(usage) != 1 || usage[0].CostUSD != 0.5 {
+		t.Errorf("LoadUsage() after Import() = %+v, %v", usage, err)
+	}
+}
+
+func TestImportCorruptLine(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, FileName)
+	if err := os.WriteFile(path, []byte("{\"id\":1}\nnot json\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	s := openStore(t)
+	if err := Import(s, dir); err == nil {
+		t.Error("Import() expected error for corrupt line")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("history file not restored after a failed import: %v", err)
+	}
+	if entries, _ := Load(s); len(entries) != 0 {
+		t.Errorf("failed Import() kept %+v", entries)
+	}
+}
+
+func TestUpdateLast(t *testing.T) {
+	s := openStore(t)
+
+	if _, err := UpdateLast(s, func(*Entry) {}); !errors.Is(err, ErrEmpty) {
+		t.Errorf("UpdateLast() on empty history error = %v, want ErrEmpty", err)
+	}
+
+	Append(s, Entry{Query: "a", Command: "one"})
+	Append(s, Entry{Query: "b", Command: "two"})
+
+	got, err := UpdateLast(s, func(e *Entry) {
+		e.Feedback = FeedbackBad
+		e.Note = "wrong flag"
+	})
+	if err != nil {
+		t.Fatalf("UpdateLast() error = %v", err)
+	}
+	if got.ID != 2 || got.Feedback != FeedbackBad {
+		t.Errorf("UpdateLast() = %+v", got)
+	}
+
+	entries, _ := Load(s)
+	if entries[0].Feedback != "" || entries[1].Note != "wrong flag" {
+		t.Errorf("UpdateLast() stored %+v", entries)
+	}
+}
+
+func TestUndoRoundTrip(t *testing.T) {
+	s := openStore(t)
+	if _, err := Append(s, Entry{Command: "mv a b", Undo: "mv b a"}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Load(s)
+	if err != nil || len(entries) != 1 || entries[0].Undo != "mv b a" {
+		t.Errorf("Load() = %+v, %v; want the undo kept", entries, err)
+	}
+}
+
+func TestLastExecuted(t *testing.T) {
+	if _, ok := LastExecuted(nil); ok {
+		t.Error("LastExecuted(nil) found an entry")
+	}
+
+	entries := []Entry{
+		{ID: 1, Executed: "make", ExitCode: 2},
+		{ID: 2, Command: "ls"},
+	}
+	if got, ok := LastExecuted(entries); !ok || got.ID != 1 {
+		t.Errorf("LastExecuted() = %+v, %v; want entry 1", got, ok)
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	now := time.Now()
+	entries := []Entry{
+		{ID: 1, Time: now.Add(-30 * 24 * time.Hour), Query: "show disk usage", Command: "df -h"},
+		{ID: 2, Time: now.Add(-time.Hour), Query: "list files by size", Command: "ls -lS"},
+		{ID: 3, Time: now.Add(-time.Hour), Query: "list files", Command: "ls -lS"},
+		{ID: 4, Time: now.Add(-time.Hour), Query: "count lines in main.go", Command: "wc -l main.go"},
+	}
+	since := now.Add(-7 * 24 * time.Hour)
+
+	tests := []struct {
+		name   string
+		entry  Entry
+		wantID int
+	}{
+		{"same query", Entry{Query: "list files", Command: "ls -lS"}, 3},
+		{"similar query", Entry{Query: "list the biggest files by size", Command: "ls -lS"}, 3},
+		{"surrounding space", Entry{Query: "list files", Command: "ls -lS\n"}, 3},
+		{"different query", Entry{Query: "newest log", Command: "wc -l main.go"}, 0},
+		{"different command", Entry{Query: "list files", Command: "ls -la"}, 0},
+		{"too old", Entry{Query: "show disk usage", Command: "df -h"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Duplicate(entries, tt.entry, since)
+			if ok != (tt.wantID != 0) || got.ID != tt.wantID {
+				t.Errorf("Duplicate() = #%d, %v; want #%d", got.ID, ok, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	s := openStore(t)
+	Append(s, Entry{Query: "a", Command: "one", Feedback: FeedbackGood})
+	Append(s, Entry{Query: "b", Command: "two"})
+
+	if _, err := Repeat(s, 9, time.Now(), nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Repeat() of a missing ID error = %v, want ErrNotFound", err)
+	}
+
+	at := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	got, err := Repeat(s, 1, at, func(e *Entry) { e.Undo = "undo one" })
+	if err != nil {
+		t.Fatalf("Repeat() error = %v", err)
+	}
+	if got.ID != 1 || !got.Time.Equal(at) || got.Undo != "undo one" || got.Feedback != FeedbackGood {
+		t.Errorf("Repeat() = %+v", got)
+	}
+
+	entries, _ := Load(s)
+	if len(entries) != 2 || entries[0].ID != 2 || entries[1].ID != 1 {
+		t.Fatalf("Repeat() left %+v; want entry 1 moved last", entries)
+	}
+	last, err := UpdateLast(s, func(e *Entry) { e.Feedback = FeedbackBad })
+	if err != nil || last.ID != 1 {
+		t.Errorf("UpdateLast() after Repeat() = %+v, %v; want entry 1", last, err)
+	}
+
+	next, err := Append(s, Entry{Query: "c", Command: "three"})
+	if err != nil || next.ID != 3 {
+		t.Errorf("Append() after Repeat() = #%d, %v; want #3", next.ID, err)
+	}
+}
+
+func TestAppendExample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ExamplesFileName)
+
+	if err := AppendExample(path, Example{Query: "list files", Command: "ls"}); err != nil {
+		t.Fatalf("AppendExample() error = %v", err)
+	}
+	if err := AppendExample(path, Example{Query: "disk usage", Command: "df -h"}); err != nil {
+		t.Fatal(err)
+	}
+	// Correcting the same query again replaces the earlier pair.
+	if err := AppendExample(path, Example{Query: "list files", Command: "ls -A"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := LoadExamples(path)
+	if err != nil {
+		t.Fatalf("LoadExamples() error = %v", err)
+	}
+	want := []Example{
+		{Query: "disk usage", Command: "df -h"},
+		{Query: "list files", Command: "ls -A"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("LoadExamples() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("example[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestCorrections(t *testing.T) {
+	s := openStore(t)
+
+	for _, c := range []Correction{
+		{Query: "find large log files", Generated: "find . -name '*.log'", Edited: "find . -name '*.log' -size +100M"},
+		{Query: "show disk usage", Generated: "df", Edited: "df -h"},
+		{Query: "find large video files", Generated: "find . -name '*.mp4'", Edited: "find . -name '*.mp4' -size +1G"},
+	} {
+		if err := AppendCorrection(s, c); err != nil {
+			t.Fatalf("AppendCorrection() error = %v", err)
+		}
+	}
+
+	all, err := LoadCorrections(s)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("LoadCorrections() = %d entries, %v; want 3, nil", len(all), err)
+	}
+	if all[0].Time.IsZero() {
+		t.Error("AppendCorrection() did not set Time")
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		max   int
+		want  []string
+	}{
+		{"exact topic first", "find large log files in /var", 3, []string{"find large log files", "find large video files"}},
+		{"limited", "find large files", 1, []string{"find large video files"}},
+		{"unrelated", "restart nginx", 3, nil},
+		{"disabled", "show disk usage", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := SimilarCorrections(all, tt.query, tt.max)
+			if len(got) != len(tt.want) {
+				t.Fatalf("SimilarCorrections() = %+v, want queries %v", got, tt.want)
+			}
+			for i, q := range tt.want {
+				if got[i].Query != q {
+					t.Errorf("SimilarCorrections()[%d].Query = %q, want %q", i, got[i].Query, q)
+				}
+			}
+		})
+	}
+}
+
+func TestUsage(t *testing.T) {
+	s := openStore(t)
+	now := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	for _, u := range []Usage{
+		{Time: time.Date(2026, 2, 28, 23, 0, 0, 0, time.UTC), CostUSD: 1},
+		{Time: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), CostUSD: 0.25},
+		{Time: now, Backend: "openai", Model: "gpt-4o", InputTokens: 100, OutputTokens: 20, CostUSD: 0.5, User: "alice"},
+		{Time: time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), CostUSD: 4},
+	} {
+		if err := AppendUsage(s, u); err != nil {
+			t.Fatalf("AppendUsage() error = %v", err)
+		}
+	}
+
+	usage, err := LoadUsage(s)
+	if err != nil || len(usage) != 4 {
+		t.Fatalf("LoadUsage() = %d records, %v; want 4, nil", len(usage), err)
+	}
+	if got := MonthlySpend(usage, now); got != 0.75 {
+		t.Errorf("MonthlySpend() = %v, want 0.75", got)
+	}
+	byUser := MonthlySpendBy(usage, now, func(u Usage) string { return u.User })
+	if want := map[string]float64{"": 0.25, "alice": 0.5}; !reflect.DeepEqual(byUser, want) {
+		t.Errorf("MonthlySpendBy() = %v, want %v", byUser, want)
+	}
+}
@@ -0,0 +1,67 @@
+package history
+
+import (
+	"database/sql"
+	"path/filepath"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+// Import moves the history, corrections and usage files that qcmd kept in
+// dir before the store into s. Missing files are skipped.
+func Import(s *store.Store, dir string) error {
+	err := s.Import(filepath.Join(dir, FileName), func(tx *sql.Tx, path string) error {
+		entries, err := readLines[Entry](path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			// Legacy files could repeat an ID; later entries get a new one.
+			var taken bool
+			if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM history WHERE id = ?)", e.ID).Scan(&taken); err != nil {
+				return err
+			}
+			if taken || e.ID <= 0 {
+				if err := tx.QueryRow("SELECT COALESCE(MAX(id), 0) + 1 FROM history").Scan(&e.ID); err != nil {
+					return err
+				}
+			}
+			if err := insertEntry(tx, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.Import(filepath.Join(dir, CorrectionsFileName), func(tx *sql.Tx, path string) error {
+		corrections, err := readLines[Correction](path)
+		if err != nil {
+			return err
+		}
+		for _, c := range corrections {
+			if err := insertCorrection(tx, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.Import(filepath.Join(dir, UsageFileName), func(tx *sql.Tx, path string) error {
+		usage, err := readLines[Usage](path)
+		if err != nil {
+			return err
+		}
+		for _, u := range usage {
+			if err := insertUsage(tx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,107 @@
+package history
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/user/qcmd/internal/store"
+)
+
+// UsageFileName is the name of the file in the data directory usage was
+// kept in before the store; Import moves it into the store.
+const UsageFileName = "usage.jsonl"
+
+// Usage records the size and estimated cost of one API call, but not its
+// query or command.
+type Usage struct {
+	Time         time.Time `json:"time"`
+	Backend      string    `json:"backend"`
+	Model        string    `json:"model"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	CostUSD      float64   `json:"cost_usd"`
+	// User is who a `qcmd serve` gateway made the call for, as the client
+	// identified itself; empty for calls made directly.
+	User string `json:"user,omitempty"`
+}
+
+// LoadUsage reads all usage records from s, oldest first.
+func LoadUsage(s *store.Store) ([]Usage, error) {
+	return LoadUsageSince(s, time.Time{})
+}
+
+// LoadUsageSince reads the usage records from since on, oldest first.
+func LoadUsageSince(s *store.Store, since time.Time) ([]Usage, error) {
+	rows, err := s.DB().Query(`SELECT time, backend, model, input_tokens, output_tokens, cost_usd, user
+		FROM usage WHERE time >= ? ORDER BY time, id`, store.FormatTime(since))
+	if err != nil {
+		return nil, fmt.Errorf("reading usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []Usage
+	for rows.Next() {
+		var u Usage
+		var t string
+		if err := rows.Scan(&t, &u.Backend, &u.Model, &u.InputTokens, &u.OutputTokens, &u.CostUSD, &u.User); err != nil {
+			return nil, fmt.Errorf("reading usage: %w", err)
+		}
+		if u.Time, err = store.ParseTime(t); err != nil {
+			return nil, fmt.Errorf("reading usage: %w", err)
+		}
+		usage = append(usage, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reading usage: %w", err)
+	}
+	return usage, nil
+}
+
+// AppendUsage adds u to the usage records in s, setting the time if unset.
+func AppendUsage(s *store.Store, u Usage) error {
+	if u.Time.IsZero() {
+		u.Time = time.Now()
+	}
+	if err := insertUsage(s.DB(), u); err != nil {
+		return fmt.Errorf("recording usage: %w", err)
+	}
+	return nil
+}
+
+// insertUsage stores u.
+func insertUsage(db execer, u Usage) error {
+	_, err := db.Exec(`INSERT INTO usage (time, backend, model, input_tokens, output_tokens, cost_usd, user)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		store.FormatTime(u.Time), u.Backend, u.Model, u.InputTokens, u.OutputTokens, u.CostUSD, u.User)
+	return err
+}
+
+// MonthStart returns the start of the calendar month of now, in now's time
+// zone.
+func MonthStart(now time.Time) time.Time {
+	year, month, _ := now.Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+}
+
+// MonthlySpend returns the total estimated cost of the records in the
+// calendar month of now, in now's time zone.
+func MonthlySpend(usage []Usage, now time.Time) float64 {
+	total := 0.0
+	for _, spent := range MonthlySpendBy(usage, now, func(Usage) string { return "" }) {
+		total += spent
+	}
+	return total
+}
+
+// MonthlySpendBy is MonthlySpend split by key, such as the user or the
+// backend of each record.
+func MonthlySpendBy(usage []Usage, now time.Time, key func(Usage) string) map[string]float64 {
+	year, month, _ := now.Date()
+	spend := make(map[string]float64)
+	for _, u := range usage {
+		if y, m, _ := u.Time.In(now.Location()).Date(); y == year && m == month {
+			spend[key(u)] += u.CostUSD
+		}
+	}
+	return spend
+}
@@ -0,0 +1,291 @@
+package i18n
+
+// spanish translates messages to Spanish. Keys are the English messages
+// exactly as passed to T and Sprintf, including format verbs.
+var spanish = map[string]string{
+	// Safety warnings
+	"WARNING: Dangerous command detected!":                                 "ADVERTENCIA: ¡Se detectó un comando peligroso!",
+	"Caution: Review this command before executing.":                       "Precaución: revise este comando antes de ejecutarlo.",
+	"WARNING: This command has been flagged as potentially dangerous.":     "ADVERTENCIA: este comando se marcó como potencialmente peligroso.",
+	"Review carefully before executing.":                                   "Revíselo con cuidado antes de ejecutarlo.",
+	"WARNING: Edited command matches a dangerous pattern; not running it.": "ADVERTENCIA: el comando editado coincide con un patrón peligroso; no se ejecutará.",
+	"  Category: %s\n":                       "  Categoría: %s\n",
+	"  Reason: %s\n":                         "  Motivo: %s\n",
+	"  Segment: %s\n":                        "  Segmento: %s\n",
+	"  Hint: %s\n":                           "  Sugerencia: %s\n",
+	"  Score: %d\n":                          "  Puntuación: %d\n",
+	"  Matched: %s\n":                        "  Coincidencia: %s\n",
+	"%s (line %d)":                           "%s (línea %d)",
+	"Caution: line %d: %s: %s\n":             "Precaución: línea %d: %s: %s\n",
+	"Caution: line %d: %s\n":                 "Precaución: línea %d: %s\n",
+	"Caution: %s: %s: %s\n":                  "Precaución: %s: %s: %s\n",
+	"Tip: %s\n":                              "Consejo: %s\n",
+	"Warning: not POSIX sh: %s\n":            "Advertencia: no es sh POSIX: %s\n",
+	"[[ ]] is a bash test; use [ ] in sh":    "[[ ]] es una prueba de bash; use [ ] en sh",
+	"== in a test is a bashism; use = in sh": "== en una prueba es propio de bash; use = en sh",
+	"process substitution <(...) needs bash or zsh; use a pipe or a temporary file in sh":      "la sustitución de procesos <(...) requiere bash o zsh; use una tubería o un archivo temporal en sh",
+	"$'...' strings need bash or zsh; use printf in sh":                                        "las cadenas $'...' requieren bash o zsh; use printf en sh",
+	"brace expansion {a,b} needs bash or zsh; write the words out in sh":                       "la expansión de llaves {a,b} requiere bash o zsh; escriba las palabras completas en sh",
+	"arrays need bash or zsh; use positional parameters or a loop in sh":                       "los arrays requieren bash o zsh; use parámetros posicionales o un bucle en sh",
+	"&> needs bash or zsh; use > file 2>&1 in sh":                                              "&> requiere bash o zsh; use > archivo 2>&1 en sh",
+	"source is a bashism; use . in sh":                                                         "source es propio de bash; use . en sh",
+	"the function keyword is a bashism; use name() { ... } in sh":                              "la palabra clave function es propia de bash; use nombre() { ... } en sh",
+	"Warning: %s does not exist here; check the path before running the command\n":             "Advertencia: %s no existe aquí; comprueba la ruta antes de ejecutar el comando\n",
+	"Warning: writing to %s needs about %s, but only %s is free there\n":                       "Advertencia: escribir en %s necesita unos %s, pero allí solo quedan %s libres\n",
+	"Warning: writing to %s may need up to %s before compression, but only %s is free there\n": "Advertencia: escribir en %s puede necesitar hasta %s antes de comprimir, pero allí solo quedan %s libres\n",
+	"Caution: step %d: %s: %s\n":                                                               "Precaución: paso %d: %s: %s\n",
+	"\nStep %d of %d: %s\n":                                                                    "\nPaso %d de %d: %s\n",
+	"qcmd: not running step %d or the steps after it\n":                                        "qcmd: no se ejecutan el paso %d ni los siguientes\n",
+	"qcmd: stopped before step %d of %d\n":                                                     "qcmd: detenido antes del paso %d de %d\n",
+	"qcmd: step %d failed with exit status %d; not running the rest of the plan\n":             "qcmd: el paso %d falló con el código de salida %d; no se ejecuta el resto del plan\n",
+	"qcmd is running as root":                                                                  "qcmd se ejecuta como root",
+	"sudo credentials are cached":                                                              "las credenciales de sudo están en caché",
+	"  PRIVILEGED: %s.\n":                                                                      "  PRIVILEGIADO: %s.\n",
+	"  Risky filesystem and system commands are treated as dangerous.":                         "  Los comandos arriesgados de sistema de archivos y de sistema se tratan como peligrosos.",
+
+	// Advice for long-running commands
+	"Searching from / reads the whole filesystem; start from a narrower directory or add -xdev to stay on one filesystem":  "Buscar desde / recorre todo el sistema de archivos; empieza en un directorio más concreto o añade -xdev para no salir de un sistema de archivos",
+	"tar shows no progress on large archives; pipe it through pv (tar -cf - DIR | pv > out.tar) to see how far it has got": "tar no muestra el progreso en archivos grandes; pásalo por pv (tar -cf - DIR | pv > out.tar) para ver cuánto lleva",
+	"Add status=progress to see how far dd has got":                                                                               "Añade status=progress para ver cuánto lleva dd",
+	"Add -P (--partial --progress) to rsync to see progress and resume an interrupted transfer":                                   "Añade -P (--partial --progress) a rsync para ver el progreso y reanudar una transferencia interrumpida",
+	"scp cannot resume an interrupted copy; rsync -aP can, and shows progress":                                                    "scp no puede reanudar una copia interrumpida; rsync -aP sí, y muestra el progreso",
+	"If it may take a while, run it in tmux or screen, or with nohup, so it survives a closed terminal or dropped SSH connection": "Si puede tardar, ejecútalo en tmux o screen, o con nohup, para que sobreviva a un terminal cerrado o a una conexión SSH caída",
+
+	// Safety pattern descriptions
+	"Recursive delete on root or home directory":                             "Borrado recursivo del directorio raíz o personal",
+	"Delete everything in root directory":                                    "Borra todo el contenido del directorio raíz",
+	"Delete all files in current directory with force/recursive flags":       "Borra todos los archivos del directorio actual de forma forzada o recursiva",
+	"Direct disk write (dd to block device)":                                 "Escritura directa en disco (dd a un dispositivo de bloques)",
+	"Filesystem format on a device":                                          "Formatea un dispositivo",
+	"Redirect output to disk device":                                         "Redirige la salida a un dispositivo de disco",
+	"Fork bomb pattern detected":                                             "Se detectó una bomba fork",
+	"Dangerous permission change on root filesystem":                         "Cambio de permisos peligroso en el sistema de archivos raíz",
+	"Recursive ownership change on root filesystem":                          "Cambio recursivo de propietario en el sistema de archivos raíz",
+	"Move root directory":                                                    "Mueve el directorio raíz",
+	"Write random data to disk device":                                       "Escribe datos aleatorios en un dispositivo de disco",
+	"Overwrite authentication files":                                         "Sobrescribe archivos de autenticación",
+	"Base64-decoded payload piped to shell":                                  "Contenido decodificado de base64 enviado a un shell",
+	"Hex-decoded payload piped to shell":                                     "Contenido decodificado de hexadecimal enviado a un shell",
+	"Hex-escaped payload piped to shell":                                     "Contenido con escapes hexadecimales enviado a un shell",
+	"Decoded payload executed through command substitution":                  "Contenido decodificado ejecutado mediante sustitución de comandos",
+	"Interpreter executing an encoded payload":                               "Intérprete que ejecuta contenido codificado",
+	"Force-delete an S3 bucket and all of its objects":                       "Borra a la fuerza un bucket de S3 y todos sus objetos",
+	"Delete a database instance without a final snapshot":                    "Borra una instancia de base de datos sin instantánea final",
+	"Delete a Google Cloud project":                                          "Borra un proyecto de Google Cloud",
+	"Delete an Azure resource group and everything in it":                    "Borra un grupo de recursos de Azure y todo su contenido",
+	"Delete a Kubernetes namespace and all of its resources":                 "Borra un namespace de Kubernetes y todos sus recursos",
+	"Delete every Kubernetes resource of a kind":                             "Borra todos los recursos de Kubernetes de un tipo",
+	"Destroy infrastructure without a confirmation prompt":                   "Destruye infraestructura sin pedir confirmación",
+	"Drop an entire database":                                                "Elimina una base de datos entera",
+	"Remote installer piped to a root shell":                                 "Instalador remoto enviado a un shell de root",
+	"Command requires elevated privileges":                                   "El comando requiere privilegios elevados",
+	"Piping remote script directly to shell":                                 "Envía un script remoto directamente a un shell",
+	"Dynamic command execution with eval":                                    "Ejecución dinámica de comandos con eval",
+	"Recursive or forced file deletion":                                      "Borrado de archivos recursivo o forzado",
+	"Recursive permission change":                                            "Cambio recursivo de permisos",
+	"Recursive ownership change":                                             "Cambio recursivo de propietario",
+	"Kill processes by pattern":                                              "Termina procesos por patrón",
+	"Kill all processes by name":                                             "Termina todos los procesos con un nombre",
+	"Interpreter executing dynamically built code":                           "Intérprete que ejecuta código construido dinámicamente",
+	"Recursive deletion of S3 objects":                                       "Borrado recursivo de objetos de S3",
+	"Delete AWS resources":                                                   "Borra recursos de AWS",
+	"Delete cloud resources":                                                 "Borra recursos en la nube",
+	"Delete or evict Kubernetes resources":                                   "Borra o desaloja recursos de Kubernetes",
+	"Uninstall a Helm release":                                               "Desinstala una release de Helm",
+	"Change or destroy infrastructure":                                       "Cambia o destruye infraestructura",
+	"Drop or truncate a database table":                                      "Elimina o vacía una tabla de base de datos",
+	"Reboots or shuts down the machine":                                      "Reinicia o apaga la máquina",
+	"Reboots or shuts down the remote host this SSH session is connected to": "Reinicia o apaga el host remoto al que está conectada esta sesión SSH",
+	"Permanently delete untracked (and with -x, ignored) files":              "Borra para siempre los archivos sin seguimiento (y con -x, los ignorados)",
+	"Rewrite the entire repository history":                                  "Reescribe todo el historial del repositorio",
+	"Force push rewrites remote history":                                     "El push forzado reescribe el historial remoto",
+	"Hard reset discards any uncommitted changes":                            "El reset --hard descarta los cambios sin confirmar",
+	"Hard reset discards the uncommitted changes in this repository":         "El reset --hard descarta los cambios sin confirmar de este repositorio",
+	"Install Python packages system-wide as root":                            "Instala paquetes de Python en todo el sistema como root",
+	"Install Python packages outside a virtual environment":                  "Instala paquetes de Python fuera de un entorno virtual",
+	"Install packages globally":                                              "Instala paquetes de forma global",
+	"Install gems system-wide as root":                                       "Instala gemas en todo el sistema como root",
+	"Runs a privileged container with full access to the host":               "Ejecuta un contenedor privilegiado con acceso total al host",
+	"Mounts the Docker socket, which gives control of the host":              "Monta el socket de Docker, lo que da el control del host",
+	"Shares the host's network":                                              "Comparte la red del host",
+	"Shares the host's processes":                                            "Comparte los procesos del host",
+	"Mounts the host's root directory":                                       "Monta el directorio raíz del host",
+	"Adds broad kernel capabilities":                                         "Añade capacidades amplias del kernel",
+	"Pipes a download into a shell":                                          "Pasa una descarga directamente a un shell",
+	"Uses an unpinned base image; pin a tag or digest":                       "Usa una imagen base sin fijar; fije una etiqueta o un digest",
+
+	// Safety pattern hints
+	"Name the specific directory to delete instead of / or ~":                                       "Indique el directorio concreto que quiere borrar en lugar de / o ~",
+	"Name the specific directory to delete instead of /*":                                           "Indique el directorio concreto que quiere borrar en lugar de /*",
+	"List the files with ls first, or name them explicitly instead of *":                            "Liste antes los archivos con ls, o nómbrelos en lugar de usar *",
+	"Double-check the of= device with lsblk; write to an image file if unsure":                      "Compruebe el dispositivo de of= con lsblk; si duda, escriba en un archivo de imagen",
+	"Double-check the device with lsblk before formatting":                                          "Compruebe el dispositivo con lsblk antes de formatearlo",
+	"Redirect to a regular file instead of a disk device":                                           "Redirija a un archivo normal en lugar de a un dispositivo de disco",
+	"Do not run this; it exhausts process slots until the machine is rebooted":                      "No lo ejecute; agota los procesos disponibles hasta que se reinicie la máquina",
+	"Grant only the permissions needed, on a specific path":                                         "Conceda solo los permisos necesarios, sobre una ruta concreta",
+	"Change ownership of a specific path instead of /":                                              "Cambie el propietario de una ruta concreta en lugar de /",
+	"Move a specific subdirectory instead of /":                                                     "Mueva un subdirectorio concreto en lugar de /",
+	"Double-check the target device with lsblk; write to an image file if unsure":                   "Compruebe el dispositivo de destino con lsblk; si duda, escriba en un archivo de imagen",
+	"Use useradd, usermod, passwd or vipw instead of overwriting the file":                          "Use useradd, usermod, passwd o vipw en lugar de sobrescribir el archivo",
+	"Decode to a file and read it before running it":                                                "Decodifíquelo en un archivo y léalo antes de ejecutarlo",
+	"Print the decoded text and read it before running it":                                          "Muestre el texto decodificado y léalo antes de ejecutarlo",
+	"Decode the payload and read it before running it":                                              "Decodifique el contenido y léalo antes de ejecutarlo",
+	"Empty and delete the bucket in separate steps after checking its contents with aws s3 ls":      "Vacíe y borre el bucket en pasos separados tras revisar su contenido con aws s3 ls",
+	"Drop --skip-final-snapshot so the data can be restored":                                        "Quite --skip-final-snapshot para poder restaurar los datos",
+	"Check the project ID with gcloud config get-value project first":                               "Compruebe antes el ID del proyecto con gcloud config get-value project",
+	"List the group's resources with az resource list first":                                        "Liste antes los recursos del grupo con az resource list",
+	"Check the context with kubectl config current-context and delete individual resources instead": "Compruebe el contexto con kubectl config current-context y borre los recursos uno a uno",
+	"Select the resources by name or label instead of --all":                                        "Seleccione los recursos por nombre o etiqueta en lugar de --all",
+	"Run terraform plan -destroy first and drop -auto-approve":                                      "Ejecute antes terraform plan -destroy y quite -auto-approve",
+	"Take a backup with pg_dump or mysqldump first":                                                 "Haga antes una copia de seguridad con pg_dump o mysqldump",
+	"Download the installer, read it, then run it":                                                  "Descargue el instalador, léalo y luego ejecútelo",
+	"Check whether the command really needs root":                                                   "Compruebe si el comando necesita realmente root",
+	"Download the script, read it, then run it":                                                     "Descargue el script, léalo y luego ejecútelo",
+	"Run the command directly instead of through eval":                                              "Ejecute el comando directamente en lugar de con eval",
+	"Run ls on the paths first, or drop -f to be asked before each deletion":                        "Ejecute antes ls sobre las rutas, o quite -f para confirmar cada borrado",
+	"Grant only the permissions needed; directories and files usually differ":                       "Conceda solo los permisos necesarios; directorios y archivos suelen necesitar permisos distintos",
+	"Check the path before changing ownership recursively":                                          "Compruebe la ruta antes de cambiar el propietario de forma recursiva",
+	"Preview the matches with pgrep first":                                                          "Vea antes las coincidencias con pgrep",
+	"Kill specific PIDs instead":                                                                    "Termine PID concretos en su lugar",
+	"Read the code being executed before running it":                                                "Lea el código que se ejecuta antes de ejecutarlo",
+	"Preview the deletion with --dryrun first":                                                      "Previsualice antes el borrado con --dryrun",
+	"Check the resource IDs and the active profile (aws sts get-caller-identity) first":             "Compruebe antes los ID de los recursos y el perfil activo (aws sts get-caller-identity)",
+	"Check the active project or subscription first":                                                "Compruebe antes el proyecto o la suscripción activos",
+	"Preview with --dry-run=client and check the current context first":                             "Previsualice con --dry-run=client y compruebe antes el contexto actual",
+	"Check the release and namespace with helm list first":                                          "Compruebe antes la release y el namespace con helm list",
+	"Run terraform plan first and drop -auto-approve":                                               "Ejecute antes terraform plan y quite -auto-approve",
+	"Take a backup first, and run the statement in a transaction where possible":                    "Haga antes una copia de seguridad y, si es posible, ejecute la sentencia en una transacción",
+	"Warn logged-in users first, e.g. shutdown -r +5 instead of an immediate reboot":                "Avise antes a los usuarios conectados, p. ej. shutdown -r +5 en lugar de reiniciar de inmediato",
+	"Make sure you can reach the host again (console or out-of-band access) before running this":    "Asegúrese de poder volver a acceder al host (consola o acceso fuera de banda) antes de ejecutarlo",
+	"Read the whole command before running it":                                                      "Lea el comando completo antes de ejecutarlo",
+
+	// Prompts and notes
+	"Run this command? [y]es / [e]dit / [N]o: ": "¿Ejecutar este comando? [y] sí / [e] editar / [N] no: ",
+	"Your answer (Enter to give up): ":          "Tu respuesta (Intro para desistir): ",
+	"Value for %s (Enter to keep): ":            "Valor para %s (Intro para mantenerlo): ",
+	"qcmd: cancelled":                           "qcmd: cancelado",
+	"Use this command? [Y/n]: ":                 "¿Usar este comando? [Y] sí / [n] no: ",
+	"Output on %s:\n":                           "Salida con %s:\n",
+	"  (no output)":                             "  (sin salida)",
+	"  ... (output cut off)":                    "  ... (salida recortada)",
+	"  ... (%d more lines)\n":                   "  ... (%d líneas más)\n",
+	"  no match  %q\n":                          "  sin coincidencia  %q\n",
+	"  match     %q: %q":                        "  coincide         %q: %q",
+	" (groups: %s)":                             " (grupos: %s)",
+	"Recalled from %s%s":                        "Recuperado de %s%s",
+	"  also: %s\n":                              "  también: %s\n",
+	"Indexed %d items with %s (%d embedded, %d unchanged)\n":                 "%d elementos indexados con %s (%d calculados, %d sin cambios)\n",
+	"Note: recall only uses the index once [index] enabled = true":           "Nota: recall solo usa el índice con [index] enabled = true",
+	"No index yet; build one with: qcmd index rebuild":                       "Todavía no hay índice; créelo con: qcmd index rebuild",
+	"Items:   %d history, %d snippets\n":                                     "Elementos: %d del historial, %d fragmentos\n",
+	"Model:   %s\n":                                                          "Modelo:    %s\n",
+	"Built:   %s\n":                                                          "Creado:    %s\n",
+	"Status:  not used ([index] enabled = false)":                            "Estado:    sin usar ([index] enabled = false)",
+	"Status:  not used (configured model is %s; run qcmd index rebuild)\n":   "Estado:    sin usar (el modelo configurado es %s; ejecute qcmd index rebuild)\n",
+	"Status:  used by qcmd recall":                                           "Estado:    en uso por qcmd recall",
+	"qcmd: fixing %s (exit status %d)\n":                                     "qcmd: se corrige %s (código de salida %d)\n",
+	"qcmd: nothing in the history matches; generating a new command":         "qcmd: nada en el historial coincide; se genera un comando nuevo",
+	"Command copied to clipboard.":                                           "Comando copiado al portapapeles.",
+	"Safe":                                                                   "Seguro",
+	"Caution: %s":                                                            "Precaución: %s",
+	"DANGER: %s (not run)":                                                   "PELIGRO: %s (no se ejecuta)",
+	"Not safety-checked":                                                     "Sin comprobación de seguridad",
+	"fill in %s":                                                             "complete %s",
+	"Review carefully: this command is hard to check at a glance.":           "Revise con cuidado: este comando es difícil de comprobar de un vistazo.",
+	"  It is %d characters long (safety.max_length is %d).\n":                "  Tiene %d caracteres (safety.max_length es %d).\n",
+	"  It chains %d commands in one pipeline (safety.max_pipeline is %d).\n": "  Encadena %d comandos en una tubería (safety.max_pipeline es %d).\n",
+	"qcmd: same as #%d from %s\n":                                            "qcmd: igual que #%d de %s\n",
+	"qcmd: same as #%d from %s, which you marked bad\n":                      "qcmd: igual que #%d de %s, que usted marcó como malo\n",
+	"today":       "hoy",
+	"yesterday":   "ayer",
+	"%d days ago": "hace %d días",
+	"Note: the model is only %.0f%% confident in this command; check it before running it.\n":  "Nota: el modelo solo confía en este comando al %.0f%%; revíselo antes de ejecutarlo.\n",
+	"qcmd: warning: fill in the placeholders before running: %s\n":                             "qcmd: advertencia: complete los marcadores antes de ejecutarlo: %s\n",
+	"qcmd: warning: %q is your abbreviation for %q; typing it expands to: %s\n":                "qcmd: advertencia: %q es su abreviatura de %q; al escribirlo se expande a: %s\n",
+	"monthly budget of $%.2f reached ($%.2f spent this month)\n":                               "se alcanzó el presupuesto mensual de $%.2f ($%.2f gastados este mes)\n",
+	"  Use the local backend instead: qcmd --backend %s\n":                                     "  Use el backend local en su lugar: qcmd --backend %s\n",
+	"  Raise budget.monthly_usd, or set budget.local_backend to a free backend to use instead": "  Aumente budget.monthly_usd, o indique en budget.local_backend un backend gratuito",
+
+	// Errors
+	"empty query": "consulta vacía",
+	"query too long (%d bytes, max %d)\n" +
+		"  Shorten it, raise advanced.max_query_length, or set advanced.long_query = \"truncate\"\n" +
+		"  to keep the start and end of long --query-file and --clipboard queries": "consulta demasiado larga (%d bytes, máximo %d)\n" +
+		"  Acórtela, aumente advanced.max_query_length, o indique advanced.long_query = \"truncate\"\n" +
+		"  para conservar el principio y el final de las consultas largas de --query-file y --clipboard",
+
+	// Help
+	"qcmd - Natural language to shell command": "qcmd - De lenguaje natural a comandos de shell",
+	"Usage:":                                "Uso:",
+	"Flags:":                                "Opciones:",
+	"Commands:":                             "Comandos:",
+	"Input Precedence (highest to lowest):": "Prioridad de la entrada (de mayor a menor):",
+	"  1. --query-file (if provided; with --query, it is material for the query)":                   "  1. --query-file (si se indica; con --query, aporta material a la consulta)",
+	"  2. --clipboard (if provided)":                                                                "  2. --clipboard (si se indica)",
+	"  3. --query (if provided)":                                                                    "  3. --query (si se indica)",
+	"  4. Interactive editor":                                                                       "  4. Editor interactivo",
+	"  config           Show current configuration":                                                 "  config           Muestra la configuración actual",
+	"  config init      Create default config file":                                                 "  config init      Crea el archivo de configuración por defecto",
+	"  backends         List available backends":                                                    "  backends         Lista los backends disponibles",
+	"  snippet          List, show, add, or rm saved snippets":                                      "  snippet          Lista, muestra, añade o borra fragmentos guardados",
+	"  sync push|pull   Share snippets through the [sync] git remote":                               "  sync push|pull   Comparte fragmentos mediante el remoto git de [sync]",
+	"  feedback good|bad  Rate the last generated command":                                          "  feedback good|bad  Valora el último comando generado",
+	"  script [flags]   Generate an annotated multi-step script for review":                         "  script [flags]   Genera un script comentado de varios pasos para revisar",
+	"  explain [--clipboard] [COMMAND]  Explain a command without running it":                       "  explain [--clipboard] [COMANDO]  Explica un comando sin ejecutarlo",
+	"  cron [flags] DESCRIPTION  Generate a crontab line and explain its schedule":                  "  cron [opciones] DESCRIPCIÓN  Genera una línea de crontab y explica su programación",
+	"  unit [--write DIR] DESCRIPTION  Generate systemd service/timer units":                        "  unit [--write DIR] DESCRIPCIÓN  Genera unidades de servicio/temporizador de systemd",
+	"  container [--format F] DESCRIPTION  Generate a docker command, Dockerfile or compose file":   "  container [--format F] DESCRIPCIÓN  Genera un comando de docker, un Dockerfile o un archivo compose",
+	"  filter [--sample FILE] DESCRIPTION  Generate a jq/awk/sed program and try it on a sample":    "  filter [--sample ARCHIVO] DESCRIPCIÓN  Genera un programa de jq/awk/sed y lo prueba con una muestra",
+	"  regex [--flavor F] [--test S]... DESCRIPTION  Generate a regex and try it on test strings":   "  regex [--flavor F] [--test S]... DESCRIPCIÓN  Genera una expresión regular y la prueba con cadenas de prueba",
+	"  plan [--exec] DESCRIPTION  Generate several commands to run in order, one step at a time":    "  plan [--exec] DESCRIPCIÓN  Genera varios comandos para ejecutar en orden, paso a paso",
+	"  fix [COMMAND]    Correct a failed command, by default the last one":                          "  fix [COMANDO]    Corrige un comando fallido, por defecto el último",
+	"  undo             Show a best-effort command reversing the last one":                          "  undo             Muestra un comando que intenta revertir el último",
+	"  recall [--shell-history] DESCRIPTION  Find a past command, or generate one if none matches":  "  recall [--shell-history] DESCRIPCIÓN  Busca un comando anterior, o genera uno si ninguno coincide",
+	"  index rebuild|status  Index history and snippets for recall by meaning":                      "  index rebuild|status  Indexa el historial y los fragmentos para recall por significado",
+	"  serve                 Answer requests over a socket, reloading the config as it changes":     "  serve                 Atiende peticiones por un socket y recarga la configuración al cambiar",
+	"  safety test --file CASES  Check safety patterns against expected levels":                     "  safety test --file CASOS  Comprueba los patrones de seguridad con los niveles esperados",
+	"  safety forget [COMMAND]   Warn again about commands you ran despite a caution":               "  safety forget [COMANDO]   Vuelve a advertir de comandos ejecutados pese a una advertencia",
+	"  check [--verbose] COMMAND  Explain how the safety checker rates a command":                   "  check [--verbose] COMANDO  Explica cómo valora un comando el comprobador de seguridad",
+	"  check --format sarif --file FILE  Report a script's risky commands as SARIF":                 "  check --format sarif --file FICHERO  Informa en SARIF de los comandos arriesgados de un script",
+	"  zle-wrap [--key KEY]  Print the zsh widget for binding qcmd to a key":                        "  zle-wrap [--key TECLA]  Muestra el widget de zsh para asociar qcmd a una tecla",
+	"  completion-helper LINE  Complete a partly typed command; a # comment says what it should do": "  completion-helper LÍNEA  Completa un comando a medio escribir; un comentario # indica qué debe hacer",
+	"  hook zsh|bash    Print a shell hook that remembers the last failed command for fix":          "  hook zsh|bash    Muestra un hook de shell que recuerda el último comando fallido para fix",
+	"  cost estimate [QUERY]  Estimate tokens and cost per model without calling the API":           "  cost estimate [CONSULTA]  Estima tokens y coste por modelo sin llamar a la API",
+	"  cost report [--by user|backend|model]  Show this month's spend by user, backend or model":    "  cost report [--by user|backend|model]  Muestra el gasto de este mes por usuario, backend o modelo",
+	"  version [--json] [--check]  Show the version and build, and whether a newer release is out":  "  version [--json] [--check]  Muestra la versión y la compilación, y si hay una versión más reciente",
+	"  vscode-task [--write] [COMMAND]  Turn the last command into a VS Code task":                  "  vscode-task [--write] [COMANDO]  Convierte el último comando en una tarea de VS Code",
+	"Read query from file (- for stdin)":                                                            "Lee la consulta de un archivo (- para la entrada estándar)",
+	"Direct query string":                                                                           "Consulta directa",
+	"Read the query (with explain, the command) from the clipboard":                                 "Lee la consulta (con explain, el comando) del portapapeles",
+	"Override backend (anthropic|openai|openrouter|remote|mock)":                                    "Cambia el backend (anthropic|openai|openrouter|remote|mock)",
+	"Override model": "Cambia el modelo",
+	"Output mode: zle|clipboard|print|auto|auto-smart|launcher|vim":              "Modo de salida: zle|clipboard|print|auto|auto-smart|launcher|vim",
+	"Print the command as a build file entry: make-target NAME|just-recipe NAME": "Muestra el comando como entrada de un archivo de compilación: make-target NOMBRE|just-recipe NOMBRE",
+	"With unit, write the unit files to this directory instead of stdout":        "Con unit, escribe los archivos de unidad en este directorio en lugar de la salida estándar",
+	"With container, what to generate: auto|command|dockerfile|compose":          "Con container, qué generar: auto|command|dockerfile|compose",
+	"With filter, try the program on this input file before offering it":         "Con filter, prueba el programa con este archivo de entrada antes de ofrecerlo",
+	"With regex, the syntax to use: pcre|ere|bre|go (default pcre)":              "Con regex, la sintaxis que usar: pcre|ere|bre|go (pcre por defecto)",
+	"With regex, try it on this string (repeatable)":                             "Con regex, la prueba con esta cadena (se puede repetir)",
+	"Look for a matching command in the history before generating one":           "Busca un comando que coincida en el historial antes de generar uno",
+	"With recall, also search the shell's history file":                          "Con recall, busca también en el archivo de historial del shell",
+	"Disable safety checks": "Desactiva las comprobaciones de seguridad",
+	"Also write safety metadata (level, category) for the shell widget to this file descriptor":             "Escribe también los metadatos de seguridad (nivel, categoría) para el widget del shell en este descriptor de archivo",
+	"Also write safety metadata (level, category) for the shell widget to this file":                        "Escribe también los metadatos de seguridad (nivel, categoría) para el widget del shell en este archivo",
+	"With --output=zle, mark where the shell widget should put the cursor with %{CURSOR}%":                  "Con --output=zle, marca dónde debe poner el cursor el widget del shell con %{CURSOR}%",
+	"Show a spinner on stderr while waiting: auto|always|never (auto: only on a terminal, not in zle mode)": "Muestra un indicador en stderr durante la espera: auto|always|never (auto: solo en una terminal, no en modo zle)",
+	"Config file path": "Ruta del archivo de configuración",
+	"Stderr verbosity: quiet|normal|verbose|debug":                                                   "Nivel de detalle en stderr: quiet|normal|verbose|debug",
+	"Only print errors and danger warnings to stderr (--verbosity=quiet)":                            "Solo muestra errores y advertencias de peligro en stderr (--verbosity=quiet)",
+	"Verbose output to stderr (--verbosity=verbose)":                                                 "Salida detallada en stderr (--verbosity=verbose)",
+	"Debug output to stderr (--verbosity=debug)":                                                     "Salida de depuración en stderr (--verbosity=debug)",
+	"Print the assembled prompt and estimated cost without calling the API":                          "Muestra el prompt completo y el coste estimado sin llamar a la API",
+	"Print the estimated tokens and cost per model without calling the API":                          "Muestra los tokens y el coste estimados por modelo sin llamar a la API",
+	"Confirm, optionally edit, then run the command":                                                 "Confirma, edita si se desea, y ejecuta el comando",
+	"Edit the generated command in your editor before output":                                        "Edita el comando generado en su editor antes de mostrarlo",
+	"Replace the system prompt's instructions with this file's (a template; context is still added)": "Sustituye las instrucciones del prompt de sistema por las de este archivo (una plantilla; el contexto se sigue añadiendo)",
+	"Add instructions to the end of the system prompt":                                               "Añade instrucciones al final del prompt de sistema",
+	"Print version and exit":                                                                         "Muestra la versión y sale",
+	"qcmd: internal error: %s\n":                                                                     "qcmd: error interno: %s\n",
+	"qcmd: crash report saved to %s; please attach it to a bug report\n":                             "qcmd: informe de fallo guardado en %s; adjúntelo a un informe de error\n",
+	"qcmd: saving a crash report: %v\n":                                                              "qcmd: al guardar un informe de fallo: %v\n",
+}
@@ -0,0 +1,99 @@
+// Package i18n translates user-facing messages.
+//
+// Messages are looked up by their English text, so code stays readable and
+// a message without a translation is simply shown in English. Translations
+// live in one catalog per language (see es.go).
+package i18n
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultLanguage is the language messages are written in.
+const DefaultLanguage = "en"
+
+// catalogs maps a language to its translations, keyed by English text.
+var catalogs = map[string]map[string]string{
+	DefaultLanguage: {},
+	"es":            spanish,
+}
+
+// current is the language messages are translated to.
+var current atomic.Value
+
+func init() {
+	current.Store(DefaultLanguage)
+}
+
+// Languages returns the supported language codes, sorted.
+func Languages() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supported reports whether lang has a catalog.
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Detect returns the language to use: configured if it is set (and not
+// "auto"), otherwise the first of LC_ALL, LC_MESSAGES and LANG that is set.
+// Locale names such as "es_ES.UTF-8" are reduced to their language, and
+// unsupported languages fall back to English.
+func Detect(configured string) string {
+	locale := configured
+	if locale == "" || locale == "auto" {
+		locale = ""
+		for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+			if v := os.Getenv(name); v != "" {
+				locale = v
+				break
+			}
+		}
+	}
+	lang := strings.ToLower(locale)
+	if i := strings.IndexAny(lang, "_.@-"); i >= 0 {
+		lang = lang[:i]
+	}
+	if !Supported(lang) {
+		return DefaultLanguage
+	}
+	return lang
+}
+
+// SetLanguage selects the language of later messages. Unsupported
+// languages select English.
+func SetLanguage(lang string) {
+	if !Supported(lang) {
+		lang = DefaultLanguage
+	}
+	current.Store(lang)
+}
+
+// Language returns the selected language.
+func Language() string {
+	return current.Load().(string)
+}
+
+// T returns msg in the selected language, or msg itself if it has no
+// translation.
+func T(msg string) string {
+	if translated, ok := catalogs[Language()][msg]; ok {
+		return translated
+	}
+	return msg
+}
+
+// Sprintf formats according to the translation of format.
+func Sprintf(format string, args ...any) string {
+	return fmt.Sprintf(T(format), args...)
+}
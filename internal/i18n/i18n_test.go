@@ -0,0 +1,91 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/user/qcmd/internal/safety"
+)
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		env        map[string]string
+		want       string
+	}{
+		{"configured", "es", map[string]string{"LANG": "en_US.UTF-8"}, "es"},
+		{"auto from LANG", "auto", map[string]string{"LANG": "es_ES.UTF-8"}, "es"},
+		{"empty from LANG", "", map[string]string{"LANG": "es_MX"}, "es"},
+		{"LC_ALL wins", "", map[string]string{"LC_ALL": "en_GB.UTF-8", "LANG": "es_ES.UTF-8"}, "en"},
+		{"LC_MESSAGES before LANG", "", map[string]string{"LC_MESSAGES": "es_AR.UTF-8", "LANG": "en_US.UTF-8"}, "es"},
+		{"C locale", "", map[string]string{"LANG": "C.UTF-8"}, "en"},
+		{"unsupported", "", map[string]string{"LANG": "fr_FR.UTF-8"}, "en"},
+		{"unset", "", nil, "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+				t.Setenv(name, tt.env[name])
+			}
+			if got := Detect(tt.configured); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.configured, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	defer SetLanguage(Language())
+
+	SetLanguage("es")
+	if got := T("WARNING: Dangerous command detected!"); got != spanish["WARNING: Dangerous command detected!"] {
+		t.Errorf("T() = %q, want the Spanish warning", got)
+	}
+	if got := Sprintf("%s (line %d)", "rm -rf /", 2); got != "rm -rf / (línea 2)" {
+		t.Errorf("Sprintf() = %q, want %q", got, "rm -rf / (línea 2)")
+	}
+	if got := T("no translation for this"); got != "no translation for this" {
+		t.Errorf("T() of an untranslated message = %q, want it unchanged", got)
+	}
+
+	SetLanguage("xx")
+	if Language() != DefaultLanguage {
+		t.Errorf("SetLanguage(unsupported) selected %q, want %q", Language(), DefaultLanguage)
+	}
+}
+
+// formatVerb matches fmt verbs, so translations can be checked against the
+// arguments their message is formatted with.
+var formatVerb = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+func TestCatalogs(t *testing.T) {
+	for lang, catalog := range catalogs {
+		for msg, translated := range catalog {
+			want := formatVerb.FindAllString(msg, -1)
+			got := formatVerb.FindAllString(translated, -1)
+			if len(got) != len(want) {
+				t.Errorf("%s: %q has verbs %v, want %v", lang, translated, got, want)
+				continue
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%s: %q has verbs %v, want %v", lang, translated, got, want)
+					break
+				}
+			}
+		}
+	}
+
+	// Danger warnings must be readable in every supported language.
+	for _, patterns := range [][]safety.Pattern{safety.DangerPatterns, safety.CautionPatterns} {
+		for _, p := range patterns {
+			for _, msg := range []string{p.Description, p.Hint} {
+				if _, ok := spanish[msg]; msg != "" && !ok {
+					t.Errorf("es: no translation for pattern message %q", msg)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package index stores embeddings of the user's history and snippets, so
+// they can be searched by meaning: "shrink a movie file" can find a command
+// generated for "compress a video".
+//
+// The index is a plain JSON file in the data directory. It is rebuilt on
+// request rather than kept up to date, and a rebuild only embeds the texts
+// that changed since the last one.
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// FileName is the name of the index file in the data directory.
+const FileName = "index.json"
+
+// Item kinds.
+const (
+	KindHistory = "history"
+	KindSnippet = "snippet"
+)
+
+// Item is an indexed text.
+type Item struct {
+	// Kind is KindHistory or KindSnippet.
+	Kind string `json:"kind"`
+	// Key identifies the item within its kind: a history entry ID or a
+	// snippet name.
+	Key string `json:"key"`
+	// Text is what was embedded.
+	Text   string    `json:"text"`
+	Vector []float32 `json:"vector,omitempty"`
+}
+
+// ID returns the item's kind and key as one string, such as "history:42".
+func (it Item) ID() string {
+	return it.Kind + ":" + it.Key
+}
+
+// Index is a set of embedded items.
+type Index struct {
+	// Model is the embedding model that made the vectors. Vectors of
+	// different models cannot be compared.
+	Model string    `json:"model"`
+	Built time.Time `json:"built"`
+	Items []Item    `json:"items"`
+}
+
+// Hit is an item that matched a search, with its cosine similarity.
+type Hit struct {
+	Item
+	Similarity float64
+}
+
+// EmbedFunc returns one vector per text, in order.
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// Load reads the index at path. A missing file yields nil and no error.
+func Load(path string) (*Index, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	var idx Index
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("parsing index: %w", err)
+	}
+	return &idx, nil
+}
+
+// Save writes idx to path, creating parent directories as needed. The file
+// is replaced atomically.
+func Save(path string, idx *Index) error {
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return fmt.Errorf("encoding index: %w", err)
+	}
+	if err := filelock.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("writing index: %w", err)
+	}
+	return nil
+}
+
+// Build returns an index of items made with model. Vectors of previous for
+// the same model and text are reused; embed is called once, for the rest.
+// It also returns how many items were embedded.
+func Build(ctx context.Context, items []Item, model string, previous *Index, embed EmbedFunc, now time.Time) (*Index, int, error) {
+	known := make(map[string][]float32)
+	if previous != nil && previous.Model == model {
+		for _, it := range previous.Items {
+			known[it.Text] = it.Vector
+		}
+	}
+
+	idx := &Index{Model: model, Built: now, Items: make([]Item, len(items))}
+	var texts []string
+	var missing []int
+	for i, it := range items {
+		it.Vector = known[it.Text]
+		idx.Items[i] = it
+		if it.Vector == nil {
+			texts = append(texts, it.Text)
+			missing = append(missing, i)
+		}
+	}
+	if len(texts) == 0 {
+		return idx, 0, nil
+	}
+
+	vectors, err := embed(ctx, texts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("embedding: %w", err)
+	}
+	if len(vectors) != len(texts) {
+		return nil, 0, fmt.Errorf("embedding: got %d vectors for %d texts", len(vectors), len(texts))
+	}
+	for j, i := range missing {
+		idx.Items[i].Vector = vectors[j]
+	}
+	return idx, len(texts), nil
+}
+
+// Search returns the items whose similarity to query is at least
+// minSimilarity, most similar first.
+func (idx *Index) Search(query []float32, minSimilarity float64) []Hit {
+	var hits []Hit
+	for _, it := range idx.Items {
+		if s := Cosine(query, it.Vector); s >= minSimilarity {
+			hits = append(hits, Hit{Item: it, Similarity: s})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].Similarity > hits[j].Similarity })
+	return hits
+}
+
+// Cosine returns the cosine similarity of a and b, or 0 if their lengths
+// differ or either is all zeros.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
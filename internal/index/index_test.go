@@ -0,0 +1,132 @@
+package index
+
+import (
+	"context"
+	"errors"
+	"math"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// letterEmbed embeds a text as the counts of the letters a, b and c.
+func letterEmbed(calls *[][]string) EmbedFunc {
+	return func(ctx context.Context, texts []string) ([][]float32, error) {
+		*calls = append(*calls, texts)
+		vectors := make([][]float32, len(texts))
+		for i, t := range texts {
+			v := make([]float32, 3)
+			for _, r := range t {
+				if r >= 'a' && r <= 'c' {
+					v[r-'a']++
+				}
+			}
+			vectors[i] = v
+		}
+		return vectors, nil
+	}
+}
+
+func TestBuild(t *testing.T) {
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	var calls [][]string
+	items := []Item{
+		{Kind: KindHistory, Key: "1", Text: "aa"},
+		{Kind: KindSnippet, Key: "b", Text: "bb"},
+	}
+	idx, n, err := Build(context.Background(), items, "m1", nil, letterEmbed(&calls), now)
+	if err != nil {
+		t.Fatalf("Build() error: %v", err)
+	}
+	if n != 2 || len(calls) != 1 {
+		t.Errorf("Build() embedded %d texts in %d calls, want 2 in 1", n, len(calls))
+	}
+	if !reflect.DeepEqual(idx.Items[1].Vector, []float32{0, 2, 0}) || idx.Model != "m1" || !idx.Built.Equal(now) {
+		t.Errorf("Build() = %+v", idx)
+	}
+
+	// Unchanged texts keep their vectors; only the new one is embedded.
+	calls = nil
+	items = append(items, Item{Kind: KindHistory, Key: "2", Text: "cc"})
+	idx, n, err = Build(context.Background(), items, "m1", idx, letterEmbed(&calls), now)
+	if err != nil {
+		t.Fatalf("Build() error: %v", err)
+	}
+	if n != 1 || !reflect.DeepEqual(calls, [][]string{{"cc"}}) {
+		t.Errorf("Build() embedded %d texts as %q, want only \"cc\"", n, calls)
+	}
+
+	// Another model's vectors are not comparable, so everything is redone.
+	calls = nil
+	if _, n, _ = Build(context.Background(), items, "m2", idx, letterEmbed(&calls), now); n != 3 {
+		t.Errorf("Build() with a new model embedded %d texts, want 3", n)
+	}
+
+	failing := func(ctx context.Context, texts []string) ([][]float32, error) {
+		return nil, errors.New("offline")
+	}
+	if _, _, err := Build(context.Background(), items, "m3", nil, failing, now); err == nil {
+		t.Error("Build() with a failing embedder succeeded")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qcmd", FileName)
+	idx, err := Load(path)
+	if idx != nil || err != nil {
+		t.Fatalf("Load() of a missing file = %v, %v; want nil, nil", idx, err)
+	}
+
+	want := &Index{
+		Model: "m",
+		Built: time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC),
+		Items: []Item{{Kind: KindHistory, Key: "7", Text: "tar czf x.tgz x", Vector: []float32{0.5, -1}}},
+	}
+	if err := Save(path, want); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := &Index{Items: []Item{
+		{Kind: KindHistory, Key: "1", Vector: []float32{1, 0}},
+		{Kind: KindHistory, Key: "2", Vector: []float32{1, 1}},
+		{Kind: KindSnippet, Key: "s", Vector: []float32{0, 1}},
+		{Kind: KindSnippet, Key: "bad", Vector: []float32{1, 0, 0}},
+	}}
+	hits := idx.Search([]float32{1, 0.2}, 0.5)
+	var ids []string
+	for _, h := range hits {
+		ids = append(ids, h.ID())
+	}
+	if want := []string{"history:1", "history:2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Search() = %q, want %q", ids, want)
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		a, b []float32
+		want float64
+	}{
+		{[]float32{1, 0}, []float32{2, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 3}, 0},
+		{[]float32{1, 0}, []float32{-1, 0}, -1},
+		{[]float32{1, 1}, []float32{1, 0}, 1 / math.Sqrt2},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+		{[]float32{1}, []float32{1, 0}, 0},
+	}
+	for _, tt := range tests {
+		if got := Cosine(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Cosine(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package lint
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// fileCommands are the programs whose operands are files they remove,
+// move or copy, where an unquoted variable does the most harm.
+var fileCommands = map[string]bool{"rm": true, "mv": true, "cp": true, "rmdir": true}
+
+// cdRegex matches a cd at the start of a simple command and its operand,
+// in text whose quotes are masked.
+var cdRegex = regexp.MustCompile(`(?:^|[;&|(\n]|\b(?:then|do|else))[ \t]*cd\b[^;&|\n)]*`)
+
+// cdFollowers are the words that, after "cd dir;", end a block rather
+// than start a command that depends on the directory.
+var cdFollowers = map[string]bool{"then": true, "do": true, "fi": true, "done": true, "esac": true, "}": true, ")": true}
+
+// Builtin checks for the problems shellcheck most often finds in generated
+// commands, without shellcheck: unquoted variables naming the files rm, mv
+// or cp work on, cd whose failure is not handled, and backticks. Findings
+// use shellcheck's codes, so the same exclusions apply to both.
+type Builtin struct {
+	options
+}
+
+// NewBuiltin returns the built-in linter. The dialect is not used: the
+// checks apply to every shell.
+func NewBuiltin(opts ...Option) *Builtin {
+	b := &Builtin{}
+	for _, opt := range opts {
+		opt(&b.options)
+	}
+	return b
+}
+
+// Name returns "lint".
+func (b *Builtin) Name() string {
+	return "lint"
+}
+
+// Lint checks script and returns what it finds, in the order of the lines
+// they are on. It never fails.
+func (b *Builtin) Lint(_ context.Context, script string) ([]Finding, error) {
+	var findings []Finding
+	add := func(f Finding) {
+		if b.reports(f.Code, f.Level) {
+			findings = append(findings, f)
+		}
+	}
+
+	for _, pipeline := range shellparse.Pipelines(script) {
+		for _, c := range shellparse.Commands(pipeline.Text) {
+			for _, word := range unquotedFileOperands(c) {
+				add(Finding{Code: "SC2086", Level: "warning", Line: pipeline.Line, Message: fmt.Sprintf("Quote %s so it is not split into several paths or expanded as a glob", word)})
+			}
+		}
+	}
+
+	masked := shellparse.MaskQuotes(script)
+	for _, loc := range cdRegex.FindAllStringIndex(masked, -1) {
+		if !cdHandled(masked[loc[1]:]) {
+			add(Finding{Code: "SC2164", Level: "warning", Line: lineAt(script, loc[0]), Message: "Use cd ... && or cd ... || exit, so the commands after it do not run in the wrong directory if cd fails"})
+		}
+	}
+
+	for _, at := range backticks(script) {
+		add(Finding{Code: "SC2006", Level: "style", Line: lineAt(script, at), Message: "Use $(...) instead of legacy backticks `...`"})
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Line < findings[j].Line })
+	return findings, nil
+}
+
+// unquotedFileOperands returns the words of the simple command cmd with an
+// unquoted expansion, such as $dir/*, if it runs one of fileCommands.
+func unquotedFileOperands(cmd string) []string {
+	words := rawWords(cmd)
+	for len(words) > 0 && (words[0] == "sudo" || words[0] == "command" || words[0] == "nice") {
+		words = words[1:]
+	}
+	if len(words) == 0 || !fileCommands[filepath.Base(words[0])] {
+		return nil
+	}
+	var unquoted []string
+	for _, w := range words[1:] {
+		if unquotedExpansion(w) {
+			unquoted = append(unquoted, w)
+		}
+	}
+	return unquoted
+}
+
+// rawWords splits a simple command into words on unquoted blanks, keeping
+// quotes and escapes as written.
+func rawWords(cmd string) []string {
+	var words []string
+	start := -1
+	var quote byte
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		if start < 0 && c != ' ' && c != '\t' {
+			start = i
+		}
+		switch {
+		case quote == 0 && c == '\\':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == '"' && c == '\\':
+			i++
+		case quote == 0 && (c == ' ' || c == '\t') && start >= 0:
+			words = append(words, cmd[start:i])
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, cmd[start:])
+	}
+	return words
+}
+
+// unquotedExpansion reports whether word expands a variable or command,
+// as in $dir, ${dir} or $(ls), outside double quotes.
+func unquotedExpansion(word string) bool {
+	var quote byte
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; {
+		case quote == 0 && c == '\\':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && c == '$' && i+1 < len(word):
+			if n := word[i+1]; n == '{' || n == '(' || n == '_' || isAlnum(n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isAlnum reports whether c is an ASCII letter or digit.
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// cdHandled reports whether rest, the masked text after a cd and its
+// operand, handles cd failing: it goes on with && or ||, or what follows
+// does not depend on the directory.
+func cdHandled(rest string) bool {
+	rest = strings.TrimLeft(rest, " \t")
+	if strings.HasPrefix(rest, "&&") || strings.HasPrefix(rest, "||") {
+		return true
+	}
+	if !strings.HasPrefix(rest, ";") && !strings.HasPrefix(rest, "\n") {
+		return true
+	}
+	next := strings.Fields(strings.TrimLeft(rest, "; \t\n"))
+	return len(next) == 0 || cdFollowers[strings.TrimRight(next[0], ";")]
+}
+
+// backticks returns the offsets in script of the backticks that open a
+// command substitution, outside single quotes.
+func backticks(script string) []int {
+	var at []int
+	inSingle, inDouble, open := false, false, false
+	for i := 0; i < len(script); i++ {
+		switch c := script[i]; {
+		case c == '\\' && !inSingle:
+			i++
+		case c == '\'' && !inDouble && !open:
+			inSingle = !inSingle
+		case c == '"' && !inSingle && !open:
+			inDouble = !inDouble
+		case c == '`' && !inSingle:
+			if !open {
+				at = append(at, i)
+			}
+			open = !open
+		}
+	}
+	return at
+}
+
+// lineAt returns the 1-based line of offset in script.
+func lineAt(script string, offset int) int {
+	return strings.Count(script[:offset], "\n") + 1
+}
@@ -0,0 +1,215 @@
+// Package lint reports likely mistakes in generated commands, such as an
+// unquoted variable that may split into several words. It runs shellcheck
+// when it is installed, and otherwise a few built-in checks for the most
+// common problems. Like advice, findings never block a command.
+package lint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ShellcheckTimeout bounds one shellcheck run.
+const ShellcheckTimeout = 2 * time.Second
+
+// ErrNotInstalled is returned when shellcheck is not on $PATH.
+var ErrNotInstalled = errors.New("shellcheck is not installed")
+
+// Levels are the severities findings may have, from the most serious.
+var Levels = []string{"error", "warning", "info", "style"}
+
+// Finding is one problem found in a command.
+type Finding struct {
+	// Code identifies the check, as in SC2086.
+	Code string
+	// Level is one of Levels.
+	Level string
+	// Line and Column locate the problem, from 1.
+	Line, Column int
+	Message      string
+}
+
+// String formats f as "SC2086 (warning): message", with the line when it
+// is not the first.
+func (f Finding) String() string {
+	if f.Line > 1 {
+		return fmt.Sprintf("%s (%s, line %d): %s", f.Code, f.Level, f.Line, f.Message)
+	}
+	return fmt.Sprintf("%s (%s): %s", f.Code, f.Level, f.Message)
+}
+
+// HasErrors reports whether any of findings is an error.
+func HasErrors(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Level == "error" {
+			return true
+		}
+	}
+	return false
+}
+
+// Linter checks a command or script.
+type Linter interface {
+	// Name says which linter it is, to label findings.
+	Name() string
+	Lint(ctx context.Context, script string) ([]Finding, error)
+}
+
+// options are shared by the linters.
+type options struct {
+	dialect  string
+	severity string
+	exclude  []string
+}
+
+// reports reports whether a finding with code and level passes the
+// severity and exclude options.
+func (o *options) reports(code, level string) bool {
+	for _, excluded := range o.exclude {
+		if code == excluded {
+			return false
+		}
+	}
+	if o.severity == "" {
+		return true
+	}
+	return levelRank(level) <= levelRank(o.severity)
+}
+
+// levelRank returns the index of level in Levels, most serious first.
+func levelRank(level string) int {
+	for i, l := range Levels {
+		if l == level {
+			return i
+		}
+	}
+	return len(Levels)
+}
+
+// Option configures a linter.
+type Option func(*options)
+
+// WithDialect checks for a shell: sh, bash, dash or ksh. By default
+// shellcheck goes by a script's #! line, or else assumes bash.
+func WithDialect(dialect string) Option {
+	return func(o *options) {
+		o.dialect = dialect
+	}
+}
+
+// WithSeverity leaves out findings less serious than level, one of Levels.
+func WithSeverity(level string) Option {
+	return func(o *options) {
+		o.severity = level
+	}
+}
+
+// WithExclude skips the checks with the given codes, as in SC2086.
+func WithExclude(codes []string) Option {
+	return func(o *options) {
+		o.exclude = codes
+	}
+}
+
+// Shellcheck lints with the shellcheck program.
+type Shellcheck struct {
+	options
+	path string
+}
+
+// NewShellcheck returns a Shellcheck linter, or ErrNotInstalled.
+func NewShellcheck(opts ...Option) (*Shellcheck, error) {
+	path, err := exec.LookPath("shellcheck")
+	if err != nil {
+		return nil, ErrNotInstalled
+	}
+	s := &Shellcheck{path: path}
+	for _, opt := range opts {
+		opt(&s.options)
+	}
+	return s, nil
+}
+
+// Name returns "shellcheck".
+func (s *Shellcheck) Name() string {
+	return "shellcheck"
+}
+
+// Dialect returns the shellcheck dialect for commands run by shell, such
+// as zsh. shellcheck does not know zsh or fish, so bash, the closest, is
+// used for them. posix asks for plain sh.
+func Dialect(shell string, posix bool) string {
+	switch {
+	case posix:
+		return "sh"
+	case shell == "sh" || shell == "dash" || shell == "ksh" || shell == "bash":
+		return shell
+	default:
+		return "bash"
+	}
+}
+
+// shellcheckOutput is shellcheck's json1 format.
+type shellcheckOutput struct {
+	Comments []struct {
+		Line    int    `json:"line"`
+		Column  int    `json:"column"`
+		Level   string `json:"level"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"comments"`
+}
+
+// Lint runs shellcheck on script. A dialect given with WithDialect is
+// only used when the script has no #! line.
+func (s *Shellcheck) Lint(ctx context.Context, script string) ([]Finding, error) {
+	ctx, cancel := context.WithTimeout(ctx, ShellcheckTimeout)
+	defer cancel()
+
+	args := []string{"--format=json1"}
+	if s.dialect != "" && !strings.HasPrefix(script, "#!") {
+		args = append(args, "--shell="+s.dialect)
+	}
+	if s.severity != "" {
+		args = append(args, "--severity="+s.severity)
+	}
+	if len(s.exclude) > 0 {
+		args = append(args, "--exclude="+strings.Join(s.exclude, ","))
+	}
+	cmd := exec.CommandContext(ctx, s.path, append(args, "-")...)
+	cmd.Stdin = strings.NewReader(script)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	// shellcheck exits with 1 when it finds something.
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("running shellcheck: %w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("running shellcheck: %w", err)
+	}
+
+	var out shellcheckOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, fmt.Errorf("reading shellcheck output: %w", err)
+	}
+	findings := make([]Finding, 0, len(out.Comments))
+	for _, c := range out.Comments {
+		findings = append(findings, Finding{
+			Code:    fmt.Sprintf("SC%d", c.Code),
+			Level:   c.Level,
+			Line:    c.Line,
+			Column:  c.Column,
+			Message: c.Message,
+		})
+	}
+	return findings, nil
+}
@@ -0,0 +1,179 @@
+package lint
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeShellcheck puts a shellcheck on $PATH that records its arguments
+// and input in dir and prints output with the given exit status.
+func fakeShellcheck(t *testing.T, output string, status int) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(dir, "args") + "\ncat > " + filepath.Join(dir, "input") +
+		"\ncat <<'EOF'\n" + output + "\nEOF\nexit " + strconv.Itoa(status) + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "shellcheck"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestShellcheckLint(t *testing.T) {
+	dir := fakeShellcheck(t, `{"comments":[{"file":"-","line":1,"column":4,"level":"warning","code":2086,"message":"Double quote to prevent globbing and word splitting."},{"file":"-","line":2,"column":1,"level":"error","code":1009,"message":"The mentioned syntax error was in this simple command."}]}`, 1)
+
+	linter, err := NewShellcheck(WithDialect("sh"), WithSeverity("warning"), WithExclude([]string{"SC2034", "SC2164"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findings, err := linter.Lint(context.Background(), "rm $f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Finding{
+		{Code: "SC2086", Level: "warning", Line: 1, Column: 4, Message: "Double quote to prevent globbing and word splitting."},
+		{Code: "SC1009", Level: "error", Line: 2, Column: 1, Message: "The mentioned syntax error was in this simple command."},
+	}
+	if !reflect.DeepEqual(findings, want) {
+		t.Errorf("Lint() = %+v, want %+v", findings, want)
+	}
+	if !HasErrors(findings) || HasErrors(findings[:1]) {
+		t.Error("HasErrors() should report only the error")
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if got, want := strings.TrimSpace(string(args)), "--format=json1 --shell=sh --severity=warning --exclude=SC2034,SC2164 -"; got != want {
+		t.Errorf("shellcheck arguments = %q, want %q", got, want)
+	}
+	input, _ := os.ReadFile(filepath.Join(dir, "input"))
+	if string(input) != "rm $f" {
+		t.Errorf("shellcheck input = %q, want %q", input, "rm $f")
+	}
+
+	// A script's own #! line wins over the dialect.
+	if _, err := linter.Lint(context.Background(), "#!/bin/bash\nrm $f"); err != nil {
+		t.Fatal(err)
+	}
+	args, _ = os.ReadFile(filepath.Join(dir, "args"))
+	if strings.Contains(string(args), "--shell") {
+		t.Errorf("shellcheck arguments = %q, want no --shell for a script with #!", args)
+	}
+}
+
+func TestShellcheckFailure(t *testing.T) {
+	fakeShellcheck(t, "", 2)
+	linter, err := NewShellcheck()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := linter.Lint(context.Background(), "ls"); err == nil {
+		t.Error("Lint() should fail when shellcheck exits with 2")
+	}
+}
+
+func TestNewShellcheckNotInstalled(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	if _, err := NewShellcheck(); !errors.Is(err, ErrNotInstalled) {
+		t.Errorf("NewShellcheck() error = %v, want ErrNotInstalled", err)
+	}
+}
+
+func TestDialect(t *testing.T) {
+	tests := []struct {
+		shell string
+		posix bool
+		want  string
+	}{
+		{"bash", false, "bash"},
+		{"dash", false, "dash"},
+		{"zsh", false, "bash"},
+		{"fish", false, "bash"},
+		{"zsh", true, "sh"},
+	}
+	for _, tt := range tests {
+		if got := Dialect(tt.shell, tt.posix); got != tt.want {
+			t.Errorf("Dialect(%q, %v) = %q, want %q", tt.shell, tt.posix, got, tt.want)
+		}
+	}
+}
+
+func TestFindingString(t *testing.T) {
+	f := Finding{Code: "SC2086", Level: "warning", Line: 1, Message: "Double quote."}
+	if got, want := f.String(), "SC2086 (warning): Double quote."; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	f.Line = 3
+	if got, want := f.String(), "SC2086 (warning, line 3): Double quote."; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestBuiltin(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		want   []string
+	}{
+		{"unquoted rm target", "rm -rf $dir/build", []string{"SC2086:1"}},
+		{"quoted rm target", `rm -rf "$dir/build"`, nil},
+		{"unquoted mv under sudo", "sudo mv ${src} /tmp", []string{"SC2086:1"}},
+		{"command substitution", "rm $(find . -name '*.tmp')", []string{"SC2086:1"}},
+		{"other programs", "echo $dir && ls $dir", nil},
+		{"single-quoted dollar", `rm 'a$b'`, nil},
+		{"cd then semicolon", "cd build; make", []string{"SC2164:1"}},
+		{"cd then newline", "cd build\nrm -f *.o", []string{"SC2164:1"}},
+		{"cd with &&", "cd build && make", nil},
+		{"cd with || exit", "cd build || exit 1; make", nil},
+		{"cd at the end", "make; cd build", nil},
+		{"cd before then", "if cd build; then make; fi", nil},
+		{"cd in quotes", `echo "cd x; ls"`, nil},
+		{"backticks", "echo `date`", []string{"SC2006:1"}},
+		{"backticks in double quotes", "echo \"today is `date`\"", []string{"SC2006:1"}},
+		{"backticks in single quotes", "echo '`date`'", nil},
+		{"by line", "cd /srv; ls\necho `date`\nrm $f", []string{"SC2164:1", "SC2006:2", "SC2086:3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := NewBuiltin().Lint(context.Background(), tt.script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range findings {
+				got = append(got, f.Code+":"+strconv.Itoa(f.Line))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lint(%q) = %v, want %v", tt.script, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuiltinOptions(t *testing.T) {
+	script := "cd build; rm `ls`"
+	tests := []struct {
+		name string
+		opts []Option
+		want int
+	}{
+		{"all", nil, 2},
+		{"severity", []Option{WithSeverity("warning")}, 1},
+		{"exclude", []Option{WithExclude([]string{"SC2164"})}, 1},
+		{"both", []Option{WithSeverity("warning"), WithExclude([]string{"SC2164"})}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, _ := NewBuiltin(tt.opts...).Lint(context.Background(), script)
+			if len(findings) != tt.want {
+				t.Errorf("Lint() = %v, want %d findings", findings, tt.want)
+			}
+		})
+	}
+}
@@ -7,54 +7,104 @@ import (
 	"strings"
 )
 
-// CopyToClipboard copies text to the system clipboard.
-// It automatically detects the appropriate clipboard tool based on the OS:
-// - macOS: pbcopy
-// - Linux: wl-copy (Wayland), xclip, or xsel
+// clipboardProgram is a clipboard tool: the command that copies stdin to
+// the clipboard and the one that prints the clipboard.
+type clipboardProgram struct {
+	copy  []string
+	paste []string
+}
+
+// clipboardPrograms lists the supported clipboard tools per OS, in order
+// of preference. The first tool whose program is installed is used.
+//   - macOS: pbcopy/pbpaste
+//   - Linux: wl-copy/wl-paste (Wayland), xclip, xsel (X11), and the
+//     Windows tools when running under WSL
+//   - Windows: clip.exe and PowerShell's Get-Clipboard
+var clipboardPrograms = map[string][]clipboardProgram{
+	"darwin": {
+		{copy: []string{"pbcopy"}, paste: []string{"pbpaste"}},
+	},
+	"linux": {
+		{copy: []string{"wl-copy"}, paste: []string{"wl-paste", "--no-newline"}},
+		{copy: []string{"xclip", "-selection", "clipboard"}, paste: []string{"xclip", "-selection", "clipboard", "-o"}},
+		{copy: []string{"xsel", "--clipboard", "--input"}, paste: []string{"xsel", "--clipboard", "--output"}},
+		windowsClipboard,
+	},
+	"windows": {
+		windowsClipboard,
+	},
+}
+
+// windowsClipboard uses the tools that ship with Windows.
+var windowsClipboard = clipboardProgram{
+	copy:  []string{"clip.exe"},
+	paste: []string{"powershell.exe", "-NoProfile", "-Command", "Get-Clipboard"},
+}
+
+// findClipboardCommand returns the first installed copy (or paste)
+// command for the current OS.
 //
-// Returns ErrNoClipboard if no clipboard tool is available on Linux.
+// Returns ErrNoClipboard if no clipboard tool is installed.
 // Returns ErrUnsupportedOS for unsupported operating systems.
-func CopyToClipboard(text string) error {
-	var cmd *exec.Cmd
-
-	switch runtime.GOOS {
-	case "darwin":
-		cmd = exec.Command("pbcopy")
-	case "linux":
-		// Try clipboard tools in order of preference:
-		// 1. wl-copy (Wayland) - modern Linux desktop
-		// 2. xclip - common X11 clipboard tool
-		// 3. xsel - alternative X11 clipboard tool
-		if hasCommand("wl-copy") {
-			cmd = exec.Command("wl-copy")
-		} else if hasCommand("xclip") {
-			cmd = exec.Command("xclip", "-selection", "clipboard")
-		} else if hasCommand("xsel") {
-			cmd = exec.Command("xsel", "--clipboard", "--input")
-		} else {
-			return ErrNoClipboard
+func findClipboardCommand(paste bool) ([]string, error) {
+	programs, ok := clipboardPrograms[runtime.GOOS]
+	if !ok {
+		return nil, ErrUnsupportedOS
+	}
+	for _, p := range programs {
+		args := p.copy
+		if paste {
+			args = p.paste
+		}
+		if hasCommand(args[0]) {
+			return args, nil
 		}
-	default:
-		return ErrUnsupportedOS
 	}
+	return nil, ErrNoClipboard
+}
 
+// CopyToClipboard copies text to the system clipboard.
+// It automatically detects the appropriate clipboard tool based on the OS
+// (see clipboardPrograms).
+//
+// Returns ErrNoClipboard if no clipboard tool is available.
+// Returns ErrUnsupportedOS for unsupported operating systems.
+func CopyToClipboard(text string) error {
+	args, err := findClipboardCommand(false)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Stdin = strings.NewReader(text)
 	return cmd.Run()
 }
 
+// PasteFromClipboard returns the text on the system clipboard, using the
+// same tool detection as CopyToClipboard. Windows line endings are
+// converted to newlines.
+//
+// Returns ErrNoClipboard if no clipboard tool is available.
+// Returns ErrUnsupportedOS for unsupported operating systems.
+func PasteFromClipboard() (string, error) {
+	if pasteTool != nil {
+		return pasteTool()
+	}
+	args, err := findClipboardCommand(true)
+	if err != nil {
+		return "", err
+	}
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(string(out), "\r\n", "\n"), nil
+}
+
 // HasClipboard returns true if a clipboard tool is available on the current system.
 // This can be used to determine if clipboard operations will succeed before attempting them.
 func HasClipboard() bool {
-	switch runtime.GOOS {
-	case "darwin":
-		// macOS always has pbcopy
-		return hasCommand("pbcopy")
-	case "linux":
-		// Check for any of the supported Linux clipboard tools
-		return hasCommand("wl-copy") || hasCommand("xclip") || hasCommand("xsel")
-	default:
-		return false
-	}
+	_, err := findClipboardCommand(false)
+	return err == nil
 }
 
 // hasCommand checks if a command exists in the system PATH.
@@ -73,6 +123,15 @@ func SetClipboardFunc(fn func(text string) error) {
 	clipboardTool = fn
 }
 
+// pasteTool overrides PasteFromClipboard when set.
+var pasteTool func() (string, error)
+
+// SetPasteFunc allows tests to inject a custom function returning the
+// clipboard contents. Pass nil to restore default behavior.
+func SetPasteFunc(fn func() (string, error)) {
+	pasteTool = fn
+}
+
 // copyToClipboardWithOverride uses the injected clipboard function if available,
 // otherwise falls back to the real implementation.
 func copyToClipboardWithOverride(text string) error {
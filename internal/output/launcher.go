@@ -0,0 +1,71 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/user/qcmd/internal/i18n"
+)
+
+// launcherItems is the Script Filter JSON envelope read by Alfred and, with
+// their Alfred-compatible extensions, Raycast and Ulauncher.
+type launcherItems struct {
+	Items []launcherItem `json:"items"`
+}
+
+// launcherItem is a single result row.
+type launcherItem struct {
+	UID      string       `json:"uid"`
+	Title    string       `json:"title"`
+	Subtitle string       `json:"subtitle"`
+	Arg      string       `json:"arg"`
+	Valid    bool         `json:"valid"`
+	Text     launcherText `json:"text"`
+}
+
+// launcherText is what the launcher copies or shows in large type.
+type launcherText struct {
+	Copy      string `json:"copy"`
+	LargeType string `json:"largetype"`
+}
+
+// WriteLauncher writes cmd to w as a Script Filter item whose subtitle
+// gives m's safety rating. Dangerous commands are shown but marked invalid,
+// so the launcher does not act on them. The uid depends only on cmd, so the
+// launcher can learn which commands are picked often.
+func WriteLauncher(w io.Writer, cmd string, m Metadata) error {
+	sum := sha256.Sum256([]byte(cmd))
+	item := launcherItem{
+		UID:      "qcmd-" + hex.EncodeToString(sum[:8]),
+		Title:    cmd,
+		Subtitle: launcherSubtitle(m),
+		Arg:      cmd,
+		Valid:    m.Level != "danger",
+		Text:     launcherText{Copy: cmd, LargeType: cmd},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(launcherItems{Items: []launcherItem{item}})
+}
+
+// launcherSubtitle describes m in a line.
+func launcherSubtitle(m Metadata) string {
+	var parts []string
+	switch m.Level {
+	case "safe":
+		parts = append(parts, i18n.T("Safe"))
+	case "caution":
+		parts = append(parts, i18n.Sprintf("Caution: %s", i18n.T(m.Description)))
+	case "danger":
+		parts = append(parts, i18n.Sprintf("DANGER: %s (not run)", i18n.T(m.Description)))
+	case "unchecked":
+		parts = append(parts, i18n.T("Not safety-checked"))
+	}
+	if len(m.Placeholders) > 0 {
+		parts = append(parts, i18n.Sprintf("fill in %s", strings.Join(m.Placeholders, ", ")))
+	}
+	return strings.Join(parts, " · ")
+}
@@ -0,0 +1,47 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Metadata describes a generated command for the shell widget, which can
+// use it to color the injected command or show a badge. It travels on a
+// side channel so stdout stays exactly the raw command.
+type Metadata struct {
+	// Level is the safety level ("safe", "caution", "danger"), or
+	// "unchecked" when safety checks were disabled.
+	Level string
+	// Category is the category of the finding; empty when safe.
+	Category string
+	// Score is the severity score of the finding.
+	Score int
+	// ReadOnly reports that the command only reads; it is false when
+	// safety checks were disabled.
+	ReadOnly bool
+	// Description is the reason for the finding; empty when safe. It is
+	// shown by launchers, not written by WriteMetadata.
+	Description string
+	// Placeholders are the placeholders left in the command for the user
+	// to fill in, such as <bucket-name>.
+	Placeholders []string
+}
+
+// WriteMetadata writes m to w as a single line of shell assignments, e.g.
+//
+//	QCMD_LEVEL='caution' QCMD_CATEGORY='system' QCMD_SCORE='50' QCMD_PLACEHOLDERS=''
+//
+// so the file can be sourced by the widget. Placeholders are separated by
+// spaces.
+func WriteMetadata(w io.Writer, m Metadata) error {
+	_, err := fmt.Fprintf(w, "QCMD_LEVEL=%s QCMD_CATEGORY=%s QCMD_SCORE=%s QCMD_PLACEHOLDERS=%s\n",
+		shellQuote(m.Level), shellQuote(m.Category), shellQuote(fmt.Sprint(m.Score)),
+		shellQuote(strings.Join(m.Placeholders, " ")))
+	return err
+}
+
+// shellQuote quotes s for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/user/qcmd/internal/i18n"
 )
 
 // Common errors returned by output functions.
@@ -33,6 +35,15 @@ const (
 	ModePrint
 	// ModeAuto tries clipboard, falls back to print.
 	ModeAuto
+	// ModeLauncher prints a Script Filter JSON item for launchers such as
+	// Alfred, Raycast and Ulauncher.
+	ModeLauncher
+	// ModeVim prints the command and its safety rating as JSON for Vim and
+	// Neovim plugins.
+	ModeVim
+	// ModeAutoSmart picks one of the other modes for each query; the caller
+	// resolves it before output, and Output treats it as ModePrint.
+	ModeAutoSmart
 )
 
 // String returns the string representation of the mode.
@@ -46,6 +57,12 @@ func (m Mode) String() string {
 		return "print"
 	case ModeAuto:
 		return "auto"
+	case ModeLauncher:
+		return "launcher"
+	case ModeVim:
+		return "vim"
+	case ModeAutoSmart:
+		return "auto-smart"
 	default:
 		return "unknown"
 	}
@@ -63,6 +80,12 @@ func ParseMode(s string) (Mode, error) {
 		return ModePrint, nil
 	case "auto", "":
 		return ModeAuto, nil
+	case "launcher":
+		return ModeLauncher, nil
+	case "vim":
+		return ModeVim, nil
+	case "auto-smart":
+		return ModeAutoSmart, nil
 	default:
 		return ModeAuto, ErrInvalidMode
 	}
@@ -74,6 +97,16 @@ var (
 	stderr io.Writer = os.Stderr
 )
 
+// quiet suppresses confirmation messages such as "Command copied to
+// clipboard."
+var quiet bool
+
+// SetQuiet turns confirmation messages off (true) or back on (false).
+// Danger warnings are printed either way.
+func SetQuiet(q bool) {
+	quiet = q
+}
+
 // SetOutputWriters allows tests to capture output by replacing stdout/stderr.
 // Pass nil to restore default behavior.
 func SetOutputWriters(out, err io.Writer) {
@@ -89,13 +122,26 @@ func SetOutputWriters(out, err io.Writer) {
 	}
 }
 
+// CursorMarker marks where a shell widget should put the cursor in a
+// command written in ModeZLE with --cursor-marker. Widgets remove it before
+// inserting the command.
+const CursorMarker = "%{CURSOR}%"
+
+// MarkCursor returns cmd with CursorMarker inserted at byte offset at.
+func MarkCursor(cmd string, at int) string {
+	return cmd[:at] + CursorMarker + cmd[at:]
+}
+
 // Output routes the command to the appropriate output based on mode and safety.
 //
 // Mode behaviors:
 //   - ModeZLE: Raw command to stdout, NO trailing newline (for shell wrapper capture)
-//   - ModeClipboard: Copy to clipboard, print confirmation to stderr
+//   - ModeClipboard: Copy to clipboard, print confirmation to stderr unless quiet
 //   - ModePrint: Print command to stdout with newline
 //   - ModeAuto: Try clipboard; if unavailable, fall back to print
+//   - ModeLauncher: Script Filter JSON to stdout; see WriteLauncher, which
+//     callers that know the safety rating should use instead
+//   - ModeVim: JSON to stdout; see WriteVim, likewise
 //
 // Dangerous command handling:
 //   - If isDangerous is true AND mode is ModeZLE: Still output to stdout
@@ -123,6 +169,20 @@ func Output(cmd string, mode Mode, isDangerous bool) error {
 	case ModeAuto:
 		return outputAuto(cmd)
 
+	case ModeLauncher:
+		meta := Metadata{}
+		if isDangerous {
+			meta.Level = "danger"
+		}
+		return WriteLauncher(stdout, cmd, meta)
+
+	case ModeVim:
+		meta := Metadata{}
+		if isDangerous {
+			meta.Level = "danger"
+		}
+		return WriteVim(stdout, cmd, meta)
+
 	default:
 		// Fallback to print for unknown modes
 		return outputPrint(cmd)
@@ -137,7 +197,9 @@ func outputClipboard(cmd string) error {
 		// Caller can decide whether to fall back to print
 		return err
 	}
-	fmt.Fprintln(stderr, "Command copied to clipboard.")
+	if !quiet {
+		fmt.Fprintln(stderr, i18n.T("Command copied to clipboard."))
+	}
 	return nil
 }
 
@@ -164,14 +226,16 @@ func outputAuto(cmd string) error {
 		return outputPrint(cmd)
 	}
 
-	fmt.Fprintln(stderr, "Command copied to clipboard.")
+	if !quiet {
+		fmt.Fprintln(stderr, i18n.T("Command copied to clipboard."))
+	}
 	return nil
 }
 
 // printDangerWarning prints a warning to stderr about dangerous commands.
 func printDangerWarning() {
 	fmt.Fprintln(stderr, "")
-	fmt.Fprintln(stderr, "WARNING: This command has been flagged as potentially dangerous.")
-	fmt.Fprintln(stderr, "Review carefully before executing.")
+	fmt.Fprintln(stderr, i18n.T("WARNING: This command has been flagged as potentially dangerous."))
+	fmt.Fprintln(stderr, i18n.T("Review carefully before executing."))
 	fmt.Fprintln(stderr, "")
 }
@@ -2,9 +2,11 @@ package output
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestParseMode tests the ParseMode function with valid and invalid inputs.
@@ -21,6 +23,9 @@ func TestParseMode(t *testing.T) {
 		{"clipboard mode", "clipboard", ModeClipboard, false, nil},
 		{"print mode", "print", ModePrint, false, nil},
 		{"auto mode", "auto", ModeAuto, false, nil},
+		{"launcher mode", "launcher", ModeLauncher, false, nil},
+		{"vim mode", "vim", ModeVim, false, nil},
+		{"auto-smart mode", "auto-smart", ModeAutoSmart, false, nil},
 		{"empty string defaults to auto", "", ModeAuto, false, nil},
 
 		// Invalid modes
@@ -67,6 +72,9 @@ func TestModeString(t *testing.T) {
 		{ModeClipboard, "clipboard"},
 		{ModePrint, "print"},
 		{ModeAuto, "auto"},
+		{ModeLauncher, "launcher"},
+		{ModeVim, "vim"},
+		{ModeAutoSmart, "auto-smart"},
 		{Mode(99), "unknown"}, // Invalid mode
 	}
 
@@ -569,3 +577,265 @@ func TestMultiLineCommandPreservation(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteMetadata(t *testing.T) {
+	tests := []struct {
+		name string
+		meta Metadata
+		want string
+	}{
+		{"safe", Metadata{Level: "safe"}, "QCMD_LEVEL='safe' QCMD_CATEGORY='' QCMD_SCORE='0' QCMD_PLACEHOLDERS=''\n"},
+		{"caution", Metadata{Level: "caution", Category: "system", Score: 50}, "QCMD_LEVEL='caution' QCMD_CATEGORY='system' QCMD_SCORE='50' QCMD_PLACEHOLDERS=''\n"},
+		{"placeholders", Metadata{Level: "safe", Placeholders: []string{"<bucket>", "YOUR_TOKEN"}}, "QCMD_LEVEL='safe' QCMD_CATEGORY='' QCMD_SCORE='0' QCMD_PLACEHOLDERS='<bucket> YOUR_TOKEN'\n"},
+		{"quote in category", Metadata{Level: "danger", Category: "team's", Score: 95}, `QCMD_LEVEL='danger' QCMD_CATEGORY='team'\''s' QCMD_SCORE='95' QCMD_PLACEHOLDERS=''` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteMetadata(&buf, tt.meta); err != nil {
+				t.Fatalf("WriteMetadata() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteMetadata() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteLauncher(t *testing.T) {
+	tests := []struct {
+		name         string
+		meta         Metadata
+		wantSubtitle string
+		wantValid    bool
+	}{
+		{"safe", Metadata{Level: "safe"}, "Safe", true},
+		{"caution", Metadata{Level: "caution", Description: "Changes file permissions"}, "Caution: Changes file permissions", true},
+		{"danger", Metadata{Level: "danger", Description: "Deletes files recursively"}, "DANGER: Deletes files recursively (not run)", false},
+		{"unchecked", Metadata{Level: "unchecked"}, "Not safety-checked", true},
+		{"placeholders", Metadata{Level: "safe", Placeholders: []string{"<bucket>", "YOUR_TOKEN"}}, "Safe · fill in <bucket>, YOUR_TOKEN", true},
+	}
+
+	cmd := `grep -r "a && b" <dir>`
+	var uid string
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteLauncher(&buf, cmd, tt.meta); err != nil {
+				t.Fatalf("WriteLauncher() error = %v", err)
+			}
+			if strings.Contains(buf.String(), `\u0026`) {
+				t.Errorf("WriteLauncher() escaped HTML: %s", buf.String())
+			}
+			var got struct {
+				Items []struct {
+					UID      string `json:"uid"`
+					Title    string `json:"title"`
+					Subtitle string `json:"subtitle"`
+					Arg      string `json:"arg"`
+					Valid    bool   `json:"valid"`
+					Text     struct {
+						Copy string `json:"copy"`
+					} `json:"text"`
+				} `json:"items"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("WriteLauncher() wrote invalid JSON %q: %v", buf.String(), err)
+			}
+			if len(got.Items) != 1 {
+				t.Fatalf("WriteLauncher() wrote %d items, want 1", len(got.Items))
+			}
+			item := got.Items[0]
+			if item.Title != cmd || item.Arg != cmd || item.Text.Copy != cmd {
+				t.Errorf("WriteLauncher() item = %+v, want the command as title, arg and copy text", item)
+			}
+			if item.Subtitle != tt.wantSubtitle {
+				t.Errorf("subtitle = %q, want %q", item.Subtitle, tt.wantSubtitle)
+			}
+			if item.Valid != tt.wantValid {
+				t.Errorf("valid = %v, want %v", item.Valid, tt.wantValid)
+			}
+			if uid == "" {
+				uid = item.UID
+			} else if item.UID != uid {
+				t.Errorf("uid = %q, want the same uid for the same command (%q)", item.UID, uid)
+			}
+		})
+	}
+}
+
+func TestWriteVim(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		meta Metadata
+		want string
+	}{
+		{"safe", "ls -la", Metadata{Level: "safe", ReadOnly: true}, `{"command":"ls -la","level":"safe","category":"","score":0,"read_only":true,"description":"","placeholders":[]}` + "\n"},
+		{"danger", "rm -rf /", Metadata{Level: "danger", Category: "filesystem", Score: 100, Description: "Deletes files recursively"}, `{"command":"rm -rf /","level":"danger","category":"filesystem","score":100,"read_only":false,"description":"Deletes files recursively","placeholders":[]}` + "\n"},
+		{"multi-line with placeholders", "cd <dir> &&\nmake", Metadata{Level: "safe", Placeholders: []string{"<dir>"}}, `{"command":"cd <dir> &&\nmake","level":"safe","category":"","score":0,"read_only":false,"description":"","placeholders":["<dir>"]}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteVim(&buf, tt.cmd, tt.meta); err != nil {
+				t.Fatalf("WriteVim() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteVim() = %s, want %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRecipe(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		cmd     string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "make target escapes dollars",
+			spec: "make-target lint",
+			cmd:  `find . -name '*.go' -exec gofmt -l {} + | grep -c "$HOME"`,
+			want: ".PHONY: lint\nlint:\n\tfind . -name '*.go' -exec gofmt -l {} + | grep -c \"$$HOME\"\n",
+		},
+		{
+			name: "make target keeps backslash continuations",
+			spec: "make-target run",
+			cmd:  "docker run \\\n  --rm alpine",
+			want: ".PHONY: run\nrun:\n\tdocker run \\\n\t  --rm alpine\n",
+		},
+		{
+			name:    "make target rejects other multi-line commands",
+			spec:    "make-target loop",
+			cmd:     "for f in *.txt; do\n  wc -l $f\ndone",
+			wantErr: ErrMultiLineTarget,
+		},
+		{
+			name: "just recipe escapes interpolation",
+			spec: "just-recipe fmt",
+			cmd:  `docker ps --format '{{.Names}}'`,
+			want: "fmt:\n    docker ps --format '{{{{.Names}}'\n",
+		},
+		{
+			name: "just recipe uses a shebang for multi-line commands",
+			spec: "just-recipe loop",
+			cmd:  "for f in *.txt; do\n  wc -l $f\ndone\n",
+			want: "loop:\n    #!/usr/bin/env sh\n    for f in *.txt; do\n      wc -l $f\n    done\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRecipe(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseRecipe(%q) error = %v", tt.spec, err)
+			}
+			var buf bytes.Buffer
+			err = WriteRecipe(&buf, tt.cmd, r)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("WriteRecipe() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WriteRecipe() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("WriteRecipe() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRecipe(t *testing.T) {
+	for _, spec := range []string{"", "make-target", "rake-task build", "make-target a:b", "just-recipe 1st", "make-target $(X)"} {
+		if _, err := ParseRecipe(spec); err == nil {
+			t.Errorf("ParseRecipe(%q) expected error", spec)
+		}
+	}
+}
+
+func TestSpinner(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewSpinner(&buf, "Generating...")
+	s.interval = time.Millisecond
+	s.Start()
+	time.Sleep(20 * time.Millisecond)
+	s.Stop()
+	s.Stop() // stopping twice is a no-op
+
+	got := buf.String()
+	if !strings.Contains(got, "| Generating... 0.0s") {
+		t.Errorf("spinner output missing first frame: %q", got)
+	}
+	if !strings.HasSuffix(got, "\r\033[K") {
+		t.Errorf("spinner output should end by clearing the line: %q", got)
+	}
+
+	// A spinner that was never started can be stopped.
+	NewSpinner(&buf, "unused").Stop()
+}
+
+func TestOutputQuiet(t *testing.T) {
+	stdoutBuf := &bytes.Buffer{}
+	stderrBuf := &bytes.Buffer{}
+	SetOutputWriters(stdoutBuf, stderrBuf)
+	defer SetOutputWriters(nil, nil)
+	SetClipboardFunc(func(string) error { return nil })
+	defer SetClipboardFunc(nil)
+	SetQuiet(true)
+	defer SetQuiet(false)
+
+	if err := Output("ls -la", ModeClipboard, false); err != nil {
+		t.Fatalf("Output() unexpected error: %v", err)
+	}
+	if stderrBuf.Len() != 0 {
+		t.Errorf("quiet output wrote to stderr: %q", stderrBuf.String())
+	}
+
+	// Danger warnings are still printed.
+	if err := Output("rm -rf /", ModeClipboard, true); err != nil {
+		t.Fatalf("Output() unexpected error: %v", err)
+	}
+	if !strings.Contains(stderrBuf.String(), "WARNING") {
+		t.Errorf("quiet output should still warn about dangerous commands, got %q", stderrBuf.String())
+	}
+}
+
+func TestClipboardPrograms(t *testing.T) {
+	for goos, programs := range clipboardPrograms {
+		if len(programs) == 0 {
+			t.Errorf("%s: no clipboard programs", goos)
+		}
+		for _, p := range programs {
+			if len(p.copy) == 0 || len(p.paste) == 0 {
+				t.Errorf("%s: clipboard program %v needs both copy and paste commands", goos, p)
+			}
+		}
+	}
+
+	args, err := findClipboardCommand(true)
+	if err == nil && !hasCommand(args[0]) {
+		t.Errorf("findClipboardCommand(paste) = %v, which is not installed", args)
+	}
+	if err != nil && !errors.Is(err, ErrNoClipboard) && !errors.Is(err, ErrUnsupportedOS) {
+		t.Errorf("findClipboardCommand(paste) unexpected error: %v", err)
+	}
+}
+
+func TestPasteFromClipboardOverride(t *testing.T) {
+	SetPasteFunc(func() (string, error) { return "echo hi", nil })
+	defer SetPasteFunc(nil)
+
+	got, err := PasteFromClipboard()
+	if err != nil || got != "echo hi" {
+		t.Errorf("PasteFromClipboard() = %q, %v; want %q", got, err, "echo hi")
+	}
+}
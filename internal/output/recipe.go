@@ -0,0 +1,83 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Kinds of build file entries a command can be wrapped in.
+const (
+	RecipeMake = "make-target"
+	RecipeJust = "just-recipe"
+)
+
+// ErrMultiLineTarget is returned when a multi-line command cannot be made
+// into a Makefile target. Each recipe line runs in its own shell, so only
+// lines continued with a backslash keep their meaning.
+var ErrMultiLineTarget = errors.New("only single-line or backslash-continued commands can be make targets; use --as just-recipe instead")
+
+// recipeNames are the names each kind accepts without quoting.
+var recipeNames = map[string]*regexp.Regexp{
+	RecipeMake: regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`),
+	RecipeJust: regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`),
+}
+
+// Recipe names a build file entry to wrap a command in.
+type Recipe struct {
+	Kind string
+	Name string
+}
+
+// ParseRecipe parses "KIND NAME", where KIND is make-target or
+// just-recipe.
+func ParseRecipe(spec string) (Recipe, error) {
+	kind, name, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	name = strings.TrimSpace(name)
+	valid, ok := recipeNames[kind]
+	if !ok {
+		return Recipe{}, fmt.Errorf("unknown --as %q (must be %s or %s)", kind, RecipeMake, RecipeJust)
+	}
+	if name == "" {
+		return Recipe{}, fmt.Errorf("--as %s needs a NAME", kind)
+	}
+	if !valid.MatchString(name) {
+		return Recipe{}, fmt.Errorf("invalid %s name %q", kind, name)
+	}
+	return Recipe{Kind: kind, Name: name}, nil
+}
+
+// WriteRecipe writes cmd to w as a Makefile target or justfile recipe named
+// r.Name, escaped so make and just pass it to the shell unchanged.
+func WriteRecipe(w io.Writer, cmd string, r Recipe) error {
+	lines := strings.Split(strings.TrimRight(cmd, "\n"), "\n")
+	var b strings.Builder
+	switch r.Kind {
+	case RecipeMake:
+		for _, line := range lines[:len(lines)-1] {
+			if !strings.HasSuffix(line, `\`) {
+				return ErrMultiLineTarget
+			}
+		}
+		fmt.Fprintf(&b, ".PHONY: %s\n%s:\n", r.Name, r.Name)
+		for _, line := range lines {
+			b.WriteString("\t" + strings.ReplaceAll(line, "$", "$$") + "\n")
+		}
+	case RecipeJust:
+		fmt.Fprintf(&b, "%s:\n", r.Name)
+		if len(lines) > 1 {
+			// A shebang recipe runs as one script, so multi-line
+			// constructs such as loops and heredocs work.
+			b.WriteString("    #!/usr/bin/env sh\n")
+		}
+		for _, line := range lines {
+			b.WriteString("    " + strings.ReplaceAll(line, "{{", "{{{{") + "\n")
+		}
+	default:
+		return fmt.Errorf("unknown recipe kind %q", r.Kind)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,70 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// spinnerFrames are drawn in turn while a spinner runs.
+var spinnerFrames = []string{"|", "/", "-", `\`}
+
+// Spinner draws an animated progress indicator with the elapsed time on a
+// single terminal line until it is stopped.
+type Spinner struct {
+	w        io.Writer
+	label    string
+	interval time.Duration
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewSpinner creates a spinner that draws label on w.
+func NewSpinner(w io.Writer, label string) *Spinner {
+	return &Spinner{w: w, label: label, interval: 100 * time.Millisecond}
+}
+
+// Start begins drawing the spinner in the background.
+func (s *Spinner) Start() {
+	s.stop = make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		start := time.Now()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			fmt.Fprintf(s.w, "\r%s %s %.1fs", spinnerFrames[i%len(spinnerFrames)], s.label, time.Since(start).Seconds())
+			select {
+			case <-s.stop:
+				// Clear the line so later output starts at column zero.
+				fmt.Fprint(s.w, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the spinner and erases it. It is safe to call on a spinner
+// that was never started.
+func (s *Spinner) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.stop = nil
+}
+
+// IsTerminal reports whether f is attached to a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,38 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// vimResult is the object read by editor plugins.
+type vimResult struct {
+	Command      string   `json:"command"`
+	Level        string   `json:"level"`
+	Category     string   `json:"category"`
+	Score        int      `json:"score"`
+	ReadOnly     bool     `json:"read_only"`
+	Description  string   `json:"description"`
+	Placeholders []string `json:"placeholders"`
+}
+
+// WriteVim writes cmd and m to w as a single line of JSON, which Vim's
+// json_decode and Neovim's vim.json.decode read directly. Unlike stdout in
+// print mode, the command can span lines without confusing the reader.
+func WriteVim(w io.Writer, cmd string, m Metadata) error {
+	placeholders := m.Placeholders
+	if placeholders == nil {
+		placeholders = []string{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(vimResult{
+		Command:      cmd,
+		Level:        m.Level,
+		Category:     m.Category,
+		Score:        m.Score,
+		ReadOnly:     m.ReadOnly,
+		Description:  m.Description,
+		Placeholders: placeholders,
+	})
+}
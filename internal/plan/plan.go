@@ -0,0 +1,80 @@
+// Package plan splits a generated plan into its steps: commands meant to
+// run one after another, each checked and confirmed on its own.
+package plan
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// MaxSteps is the most steps a plan may have.
+const MaxSteps = 10
+
+// stepHeader matches the "# Step N: WHAT" line starting each step.
+var stepHeader = regexp.MustCompile(`(?i)^#\s*step\s+\d+\s*[:.]\s*(.*)$`)
+
+// Step is one command of a plan.
+type Step struct {
+	// Description says what the step does.
+	Description string
+	// Command is the step's command. It may span lines, as a heredoc does.
+	Command string
+}
+
+// Parse splits generated output into steps, each introduced by a
+// "# Step N: WHAT" line. Steps are numbered in order, whatever N says.
+func Parse(text string) ([]Step, error) {
+	var steps []Step
+	var command []string
+	flush := func() {
+		if len(steps) > 0 {
+			steps[len(steps)-1].Command = strings.TrimSpace(strings.Join(command, "\n"))
+		}
+		command = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch m := stepHeader.FindStringSubmatch(trimmed); {
+		case m != nil:
+			flush()
+			steps = append(steps, Step{Description: strings.TrimSpace(m[1])})
+		case len(steps) == 0:
+			if trimmed != "" {
+				return nil, fmt.Errorf(`plan must start with a "# Step 1: ..." line`)
+			}
+		default:
+			command = append(command, line)
+		}
+	}
+	flush()
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("no steps in the plan")
+	}
+	if len(steps) > MaxSteps {
+		return nil, fmt.Errorf("plan has %d steps, more than %d", len(steps), MaxSteps)
+	}
+	for i, s := range steps {
+		if s.Command == "" {
+			return nil, fmt.Errorf("step %d has no command", i+1)
+		}
+	}
+	return steps, nil
+}
+
+// Write writes steps to w in the form Parse reads, which is also a shell
+// script running them in order.
+func Write(w io.Writer, steps []Step) error {
+	for i, s := range steps {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "# Step %d: %s\n%s\n", i+1, s.Description, s.Command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
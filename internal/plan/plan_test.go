@@ -0,0 +1,71 @@
+package plan
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		want    []Step
+		wantErr string
+	}{
+		{
+			name: "steps",
+			text: "# Step 1: Make the directory\nmkdir -p out\n\n# Step 2: Copy the files\ncp *.png out/",
+			want: []Step{
+				{Description: "Make the directory", Command: "mkdir -p out"},
+				{Description: "Copy the files", Command: "cp *.png out/"},
+			},
+		},
+		{
+			name: "renumbered, multi-line command",
+			text: "# step 3. Write the config\ncat > app.conf <<'EOF'\nport = 80\nEOF\n# Step 7: Restart\nsystemctl restart app",
+			want: []Step{
+				{Description: "Write the config", Command: "cat > app.conf <<'EOF'\nport = 80\nEOF"},
+				{Description: "Restart", Command: "systemctl restart app"},
+			},
+		},
+		{name: "text first", text: "Here is the plan:\n# Step 1: List\nls", wantErr: "must start with"},
+		{name: "no steps", text: "", wantErr: "no steps"},
+		{name: "empty step", text: "# Step 1: List\n# Step 2: Count\nwc -l", wantErr: "step 1 has no command"},
+		{name: "too many", text: strings.Repeat("# Step 1: List\nls\n", MaxSteps+1), wantErr: "more than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Parse() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteParse(t *testing.T) {
+	steps := []Step{
+		{Description: "Make the directory", Command: "mkdir -p out"},
+		{Description: "Write the config", Command: "cat > out/app.conf <<'EOF'\nport = 80\nEOF"},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, steps); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	got, err := Parse(buf.String())
+	if err != nil || !reflect.DeepEqual(got, steps) {
+		t.Errorf("Parse(Write()) = %+v, %v; want %+v", got, err, steps)
+	}
+}
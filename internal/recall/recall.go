@@ -0,0 +1,233 @@
+// Package recall finds past commands that match a description such as
+// "that ffmpeg command I used to trim a video last month".
+//
+// Matching is fuzzy rather than exact: words match their inflections and
+// near misspellings ("trim" finds "trimming", "vidoe" finds "video"), and
+// time phrases such as "yesterday" or "last month" favor commands from
+// that period instead of being matched as words. Callers with an embedding
+// index can also score candidates by meaning; see Candidate.Semantic.
+package recall
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MinScore is the lowest score a candidate needs to be recalled.
+const MinScore = 0.5
+
+// Candidate is a past command to search.
+type Candidate struct {
+	// Command is the command line.
+	Command string
+	// Query is what the command was generated for, if known.
+	Query string
+	// Time is when the command was generated or run; zero if unknown.
+	Time time.Time
+	// Source names where the candidate came from, such as "history".
+	Source string
+	// ID identifies the candidate in an embedding index, if it is in one.
+	ID string
+	// Semantic is how well the candidate matches by meaning, from 0 to 1
+	// on the same scale as Search's scores, or 0 if unknown. Search uses
+	// it when it beats the match by words.
+	Semantic float64
+}
+
+// Result is a candidate that matched, with its score from 0 to 1.
+type Result struct {
+	Candidate
+	Score float64
+}
+
+// fillerWords are ignored in descriptions: they say how the user refers to
+// the command rather than what it does.
+var fillerWords = map[string]bool{
+	"a": true, "an": true, "the": true, "in": true, "of": true, "to": true,
+	"for": true, "and": true, "or": true, "with": true, "on": true, "at": true,
+	"me": true, "my": true, "that": true, "this": true, "is": true, "was": true,
+	"i": true, "it": true, "used": true, "use": true, "ran": true, "run": true,
+	"did": true, "typed": true, "wrote": true, "command": true, "commands": true,
+	"one": true, "which": true, "what": true, "how": true, "some": true,
+	"ago": true, "earlier": true, "before": true, "recently": true,
+}
+
+// timeWords are the words of time phrases; they only set the period.
+var timeWords = map[string]bool{
+	"today": true, "yesterday": true, "last": true, "week": true,
+	"month": true, "year": true,
+}
+
+// Search returns the candidates matching description, best first and newer
+// first on ties. Candidates scoring below MinScore are left out.
+func Search(description string, candidates []Candidate, now time.Time) []Result {
+	words := descriptionWords(description)
+	if len(words) == 0 {
+		return nil
+	}
+	from, to, hasPeriod := period(description, now)
+
+	var results []Result
+	for _, c := range candidates {
+		score := max(matchScore(words, candidateWords(c)), c.Semantic)
+		// A time phrase is a hint, not a filter: memories of "last month"
+		// are often a few weeks off.
+		if hasPeriod && !c.Time.IsZero() {
+			if c.Time.Before(from) || !c.Time.Before(to) {
+				score *= 0.8
+			} else {
+				score = score*0.9 + 0.1
+			}
+		}
+		if score >= MinScore {
+			results = append(results, Result{Candidate: c, Score: score})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Time.After(results[j].Time)
+	})
+	return dedupe(results)
+}
+
+// dedupe keeps the first result for each command.
+func dedupe(results []Result) []Result {
+	seen := make(map[string]bool)
+	kept := results[:0]
+	for _, r := range results {
+		if !seen[r.Command] {
+			seen[r.Command] = true
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// descriptionWords returns the words of a description that say what the
+// command does.
+func descriptionWords(description string) []string {
+	var words []string
+	for _, w := range splitWords(description) {
+		if !fillerWords[w] && !timeWords[w] {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// candidateWords returns the words of a candidate's command and query.
+func candidateWords(c Candidate) []string {
+	return splitWords(c.Query + " " + c.Command)
+}
+
+// splitWords lowercases s and splits it into words of letters and digits.
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchScore is the average, over the description's words, of how well
+// each matches its best word in the candidate.
+func matchScore(words, candidate []string) float64 {
+	total := 0.0
+	for _, w := range words {
+		best := 0.0
+		for _, c := range candidate {
+			if s := wordScore(w, c); s > best {
+				best = s
+				if best == 1 {
+					break
+				}
+			}
+		}
+		total += best
+	}
+	return total / float64(len(words))
+}
+
+// wordScore rates how well description word w matches candidate word c:
+// 1 for the same word, less for an inflection or a misspelling.
+func wordScore(w, c string) float64 {
+	switch {
+	case w == c:
+		return 1
+	case len(w) >= 4 && len(c) >= 4 && (strings.HasPrefix(c, w) || strings.HasPrefix(w, c)):
+		return 0.8
+	case len(w) >= 5 && len(c) >= 5 && editDistance(w, c) <= 1:
+		return 0.7
+	case len(w) >= 5 && len(c) >= 5 && commonPrefix(w, c) >= 5:
+		// Different endings of one stem: "compressed", "compression".
+		return 0.6
+	}
+	return 0
+}
+
+// commonPrefix returns the length of the longest common prefix of a and b.
+func commonPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// editDistance returns the Damerau-Levenshtein distance between a and b
+// (optimal string alignment), so a swap of two letters counts once.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// period returns the time range a description refers to, such as the
+// previous calendar month for "last month".
+func period(description string, now time.Time) (from, to time.Time, ok bool) {
+	d := " " + strings.Join(splitWords(description), " ") + " "
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7) // Monday
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	year := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+	switch {
+	case strings.Contains(d, " today "):
+		return day, day.AddDate(0, 0, 1), true
+	case strings.Contains(d, " yesterday "):
+		return day.AddDate(0, 0, -1), day, true
+	case strings.Contains(d, " last week "):
+		return week.AddDate(0, 0, -7), week, true
+	case strings.Contains(d, " this week "):
+		return week, week.AddDate(0, 0, 7), true
+	case strings.Contains(d, " last month "):
+		return month.AddDate(0, -1, 0), month, true
+	case strings.Contains(d, " this month "):
+		return month, month.AddDate(0, 1, 0), true
+	case strings.Contains(d, " last year "):
+		return year.AddDate(-1, 0, 0), year, true
+	case strings.Contains(d, " this year "):
+		return year, year.AddDate(1, 0, 0), true
+	}
+	return time.Time{}, time.Time{}, false
+}
@@ -0,0 +1,103 @@
+package recall
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	may := time.Date(2024, 5, 20, 10, 0, 0, 0, time.UTC)
+	june := time.Date(2024, 6, 11, 10, 0, 0, 0, time.UTC)
+	candidates := []Candidate{
+		{Command: "ffmpeg -ss 00:01:00 -to 00:02:00 -i in.mp4 -c copy out.mp4", Query: "trim a video to one minute", Time: may, Source: "history"},
+		{Command: "ffmpeg -i in.mov out.mp4", Query: "convert mov to mp4", Time: june, Source: "history"},
+		{Command: "tar czf backup.tgz ~/docs", Query: "compress my documents", Time: june, Source: "history"},
+		{Command: "git log --oneline -n 20", Time: june, Source: "shell"},
+		{Command: "tar czf backup.tgz ~/docs", Time: june, Source: "shell"},
+	}
+
+	tests := []struct {
+		description string
+		want        []string
+	}{
+		{"that ffmpeg command I used to trim a video last month", []string{
+			"ffmpeg -ss 00:01:00 -to 00:02:00 -i in.mp4 -c copy out.mp4",
+		}},
+		{"trimming videos", []string{"ffmpeg -ss 00:01:00 -to 00:02:00 -i in.mp4 -c copy out.mp4"}},
+		{"the vidoe trim", []string{"ffmpeg -ss 00:01:00 -to 00:02:00 -i in.mp4 -c copy out.mp4"}},
+		{"compression of documents", []string{"tar czf backup.tgz ~/docs"}},
+		{"git log", []string{"git log --oneline -n 20"}},
+		{"deploy the kubernetes cluster", nil},
+		{"the command I used yesterday", nil},
+	}
+	for _, tt := range tests {
+		results := Search(tt.description, candidates, now)
+		var got []string
+		for _, r := range results {
+			got = append(got, r.Command)
+		}
+		if len(got) < len(tt.want) || len(tt.want) == 0 && len(got) > 0 {
+			t.Errorf("Search(%q) = %q, want %q first", tt.description, got, tt.want)
+			continue
+		}
+		for i, w := range tt.want {
+			if got[i] != w {
+				t.Errorf("Search(%q) = %q, want %q first", tt.description, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSearchPeriod(t *testing.T) {
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	candidates := []Candidate{
+		{Command: "rsync -a src/ old/", Query: "sync the folders", Time: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{Command: "rsync -a src/ new/", Query: "sync the folders", Time: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)},
+		{Command: "rsync -a src/ today/", Query: "sync the folders", Time: time.Date(2024, 6, 12, 9, 0, 0, 0, time.UTC)},
+	}
+	for description, want := range map[string]string{
+		"sync folders last month": "rsync -a src/ new/",
+		"sync folders today":      "rsync -a src/ today/",
+		"sync folders":            "rsync -a src/ today/",
+	} {
+		results := Search(description, candidates, now)
+		if len(results) != 3 || results[0].Command != want {
+			t.Errorf("Search(%q) = %+v, want %q first of 3", description, results, want)
+		}
+	}
+}
+
+func TestSearchSemantic(t *testing.T) {
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	candidates := []Candidate{
+		{Command: "ffmpeg -i in.mp4 -vcodec libx265 -crf 28 out.mp4", Query: "compress a video"},
+		{Command: "gzip -9 movie.mp4", Query: "gzip a file", Semantic: 0.4},
+		{Command: "ffmpeg -i in.mov -vf scale=1280:-1 out.mov", Query: "make a video smaller", Semantic: 0.9},
+	}
+	results := Search("shrink a movie file", candidates, now)
+	// "file" and "movie" match the gzip command by words, but the semantic
+	// match is better; the first candidate matches neither way.
+	if len(results) != 2 || results[0].Command != candidates[2].Command || results[0].Score != 0.9 {
+		t.Errorf("Search() = %+v, want the semantic match first of 2", results)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"video", "video", 0},
+		{"video", "vidoe", 1},
+		{"video", "videos", 1},
+		{"trim", "tram", 1},
+		{"kitten", "sitting", 3},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
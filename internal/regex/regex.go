@@ -0,0 +1,110 @@
+// Package regex compiles generated regular expressions of several flavors
+// with Go's regexp package, so they can be tried on test strings without
+// calling out to grep or another engine.
+package regex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Flavor is a regular expression dialect.
+type Flavor string
+
+const (
+	// PCRE is the Perl-compatible flavor of grep -P, Python and
+	// JavaScript.
+	PCRE Flavor = "pcre"
+	// ERE is POSIX extended syntax, as in grep -E and awk.
+	ERE Flavor = "ere"
+	// BRE is POSIX basic syntax, as in grep and sed without -E.
+	BRE Flavor = "bre"
+	// Go is the RE2 syntax of Go's regexp package.
+	Go Flavor = "go"
+)
+
+// ParseFlavor parses a --flavor value.
+func ParseFlavor(s string) (Flavor, error) {
+	switch f := Flavor(s); f {
+	case PCRE, ERE, BRE, Go:
+		return f, nil
+	case "":
+		return PCRE, nil
+	}
+	return "", fmt.Errorf("invalid flavor %q (must be pcre, ere, bre, or go)", s)
+}
+
+// Compile compiles pattern as flavor. POSIX flavors match leftmost-longest,
+// as grep does. PCRE patterns that use features Go lacks, such as
+// lookarounds and backreferences, fail to compile.
+func Compile(pattern string, flavor Flavor) (*regexp.Regexp, error) {
+	switch flavor {
+	case BRE:
+		return regexp.CompilePOSIX(breToERE(pattern))
+	case ERE:
+		return regexp.CompilePOSIX(pattern)
+	}
+	return regexp.Compile(pattern)
+}
+
+// breToERE rewrites a basic regular expression in extended syntax: the
+// escaped operators \( \) \{ \} \| \+ \? become plain ones, and the plain
+// characters become escaped literals.
+func breToERE(pattern string) string {
+	var b strings.Builder
+	inClass := false
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case inClass:
+			b.WriteByte(c)
+			// A ] right after [ or [^ is a literal.
+			if c == ']' && !strings.HasSuffix(pattern[:i], "[") && !strings.HasSuffix(pattern[:i], "[^") {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+			b.WriteByte(c)
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			switch next := pattern[i]; next {
+			case '(', ')', '{', '}', '|', '+', '?':
+				b.WriteByte(next)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(next)
+			}
+		case strings.IndexByte("(){}|+?", c) >= 0:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Match is the result of trying a pattern on one test string.
+type Match struct {
+	Input   string
+	Matched bool
+	// Text is the leftmost match, and Groups its submatches.
+	Text   string
+	Groups []string
+}
+
+// Test tries re on each input.
+func Test(re *regexp.Regexp, inputs []string) []Match {
+	matches := make([]Match, len(inputs))
+	for i, input := range inputs {
+		m := Match{Input: input}
+		if sub := re.FindStringSubmatch(input); sub != nil {
+			m.Matched = true
+			m.Text = sub[0]
+			m.Groups = sub[1:]
+		}
+		matches[i] = m
+	}
+	return matches
+}
@@ -0,0 +1,96 @@
+package regex
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBREToERE(t *testing.T) {
+	tests := []struct {
+		bre  string
+		want string
+	}{
+		{`^\(ab\)\{2\}$`, `^(ab){2}$`},
+		{`a+b?(c)`, `a\+b\?\(c\)`},
+		{`x\|y`, `x|y`},
+		{`[(+)]\.`, `[(+)]\.`},
+		{`[]a]+`, `[]a]\+`},
+		{`[^]a]\+`, `[^]a]+`},
+		{`\1`, `\1`},
+	}
+	for _, tt := range tests {
+		if got := breToERE(tt.bre); got != tt.want {
+			t.Errorf("breToERE(%q) = %q, want %q", tt.bre, got, tt.want)
+		}
+	}
+}
+
+func TestCompileAndTest(t *testing.T) {
+	tests := []struct {
+		pattern string
+		flavor  Flavor
+		inputs  []string
+		want    []Match
+		wantErr bool
+	}{
+		{
+			pattern: `([a-z]+)@([a-z.]+)`,
+			flavor:  PCRE,
+			inputs:  []string{"mail ada@example.com now", "no address"},
+			want: []Match{
+				{Input: "mail ada@example.com now", Matched: true, Text: "ada@example.com", Groups: []string{"ada", "example.com"}},
+				{Input: "no address"},
+			},
+		},
+		{
+			pattern: `^[0-9]\{3\}-\([0-9]\{4\}\)$`,
+			flavor:  BRE,
+			inputs:  []string{"555-1234", "5551234"},
+			want: []Match{
+				{Input: "555-1234", Matched: true, Text: "555-1234", Groups: []string{"1234"}},
+				{Input: "5551234"},
+			},
+		},
+		{
+			// POSIX flavors match leftmost-longest.
+			pattern: `a|ab`,
+			flavor:  ERE,
+			inputs:  []string{"ab"},
+			want:    []Match{{Input: "ab", Matched: true, Text: "ab", Groups: []string{}}},
+		},
+		{
+			pattern: `a|ab`,
+			flavor:  Go,
+			inputs:  []string{"ab"},
+			want:    []Match{{Input: "ab", Matched: true, Text: "a", Groups: []string{}}},
+		},
+		{pattern: `foo(?=bar)`, flavor: PCRE, wantErr: true},
+	}
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern, tt.flavor)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Compile(%q, %s) expected error", tt.pattern, tt.flavor)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Compile(%q, %s) error = %v", tt.pattern, tt.flavor, err)
+			continue
+		}
+		if got := Test(re, tt.inputs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Test(%q, %s) = %+v, want %+v", tt.pattern, tt.flavor, got, tt.want)
+		}
+	}
+}
+
+func TestParseFlavor(t *testing.T) {
+	for _, s := range []string{"", "pcre", "ere", "bre", "go"} {
+		if _, err := ParseFlavor(s); err != nil {
+			t.Errorf("ParseFlavor(%q) error = %v", s, err)
+		}
+	}
+	if _, err := ParseFlavor("emacs"); err == nil {
+		t.Error("ParseFlavor(\"emacs\") expected error")
+	}
+}
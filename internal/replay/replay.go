@@ -0,0 +1,216 @@
+// Package replay records backend HTTP exchanges to fixture files and replays
+// them, VCR-style. It enables deterministic end-to-end tests and lets users
+// share reproducible bug reports without spending tokens.
+//
+// Fixtures are keyed by a hash of the request method, URL and body. Request
+// headers (which carry API keys) are never written to disk.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variables selecting the replay mode.
+const (
+	// EnvRecord names a directory to record exchanges into.
+	EnvRecord = "QCMD_RECORD"
+	// EnvReplay names a directory to replay exchanges from.
+	EnvReplay = "QCMD_REPLAY"
+)
+
+// ErrNoFixture is returned in replay mode when no fixture matches a request.
+var ErrNoFixture = errors.New("no replay fixture for request")
+
+// Mode selects whether the transport records or replays.
+type Mode int
+
+const (
+	// Off passes requests through unchanged.
+	Off Mode = iota
+	// Record forwards requests and saves each exchange as a fixture.
+	Record
+	// Replay serves responses from fixtures without touching the network.
+	Replay
+)
+
+// Fixture is the on-disk representation of one HTTP exchange.
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is the recorded request. Headers are deliberately omitted.
+type FixtureRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body"`
+}
+
+// FixtureResponse is the recorded response.
+type FixtureResponse struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        string `json:"body"`
+}
+
+// Transport is an http.RoundTripper that records or replays exchanges.
+type Transport struct {
+	// Dir is the fixture directory.
+	Dir string
+	// Mode selects recording or replaying.
+	Mode Mode
+	// Next is the underlying transport used when recording or when Off.
+	// If nil, http.DefaultTransport is used.
+	Next http.RoundTripper
+}
+
+// FromEnv returns the replay mode and fixture directory selected by the
+// QCMD_REPLAY and QCMD_RECORD environment variables. Replay wins if both
+// are set.
+func FromEnv() (Mode, string) {
+	if dir := os.Getenv(EnvReplay); dir != "" {
+		return Replay, dir
+	}
+	if dir := os.Getenv(EnvRecord); dir != "" {
+		return Record, dir
+	}
+	return Off, ""
+}
+
+// WrapClient returns a client whose transport records or replays according
+// to mode. When mode is Off, client is returned unchanged.
+func WrapClient(client *http.Client, mode Mode, dir string) *http.Client {
+	if mode == Off {
+		return client
+	}
+	wrapped := *client
+	wrapped.Transport = &Transport{Dir: dir, Mode: mode, Next: client.Transport}
+	return &wrapped
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if t.Mode == Off {
+		return next.RoundTrip(req)
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("replay: reading request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	path := filepath.Join(t.Dir, Key(req.Method, req.URL.String(), body)+".json")
+
+	if t.Mode == Replay {
+		return t.replay(req, path)
+	}
+	return t.record(req, path, body, next)
+}
+
+// replay serves the fixture at path.
+func (t *Transport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("replay: %w: %s %s (expected %s)", ErrNoFixture, req.Method, req.URL, path)
+		}
+		return nil, fmt.Errorf("replay: reading fixture: %w", err)
+	}
+
+	var fx Fixture
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return nil, fmt.Errorf("replay: parsing fixture %s: %w", path, err)
+	}
+
+	header := make(http.Header)
+	if fx.Response.ContentType != "" {
+		header.Set("Content-Type", fx.Response.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", fx.Response.StatusCode, http.StatusText(fx.Response.StatusCode)),
+		StatusCode:    fx.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(fx.Response.Body)),
+		ContentLength: int64(len(fx.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+// record forwards req and saves the exchange to path. The response is
+// requested uncompressed, so fixtures stay readable and replay as is.
+func (t *Transport) record(req *http.Request, path string, body []byte, next http.RoundTripper) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("replay: reading response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	fx := Fixture{
+		Request: FixtureRequest{
+			Method: req.Method,
+			URL:    req.URL.String(),
+			Body:   string(body),
+		},
+		Response: FixtureResponse{
+			StatusCode:  resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        string(respBody),
+		},
+	}
+	data, err := json.MarshalIndent(fx, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("replay: encoding fixture: %w", err)
+	}
+	if err := os.MkdirAll(t.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("replay: creating fixture directory: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return nil, fmt.Errorf("replay: writing fixture: %w", err)
+	}
+
+	return resp, nil
+}
+
+// Key returns the fixture key for a request.
+func Key(method, url string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(url))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,126 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") == "gzip, deflate" {
+			t.Error("recording should not ask for a compressed response")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, `{"ok":true}`)
+	}))
+
+	post := func(client *http.Client) (*http.Response, error) {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, server.URL+"/v1", strings.NewReader(`{"q":"x"}`))
+		req.Header.Set("Authorization", "Bearer secret-key")
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+		return client.Do(req)
+	}
+
+	// Record against the live server.
+	resp, err := post(WrapClient(http.DefaultClient, Record, dir))
+	if err != nil {
+		t.Fatalf("record: unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != `{"ok":true}` {
+		t.Errorf("record: body = %q, caller should still see the response", body)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 fixture, got %d", len(files))
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "secret-key") {
+		t.Error("fixture must not contain request headers")
+	}
+
+	// Replay with the server gone.
+	server.Close()
+	resp, err = post(WrapClient(http.DefaultClient, Replay, dir))
+	if err != nil {
+		t.Fatalf("replay: unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("replay: status = %d, want %d", resp.StatusCode, http.StatusTeapot)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("replay: Content-Type = %q", got)
+	}
+	if string(body) != `{"ok":true}` {
+		t.Errorf("replay: body = %q", body)
+	}
+}
+
+func TestReplayMissingFixture(t *testing.T) {
+	client := WrapClient(http.DefaultClient, Replay, t.TempDir())
+	_, err := client.Post("http://example.invalid/v1", "application/json", strings.NewReader(`{}`))
+	if !errors.Is(err, ErrNoFixture) {
+		t.Errorf("expected ErrNoFixture, got %v", err)
+	}
+}
+
+func TestKeyDependsOnRequest(t *testing.T) {
+	base := Key("POST", "https://api.example.com", []byte(`{"q":"a"}`))
+	if base != Key("POST", "https://api.example.com", []byte(`{"q":"a"}`)) {
+		t.Error("Key should be deterministic")
+	}
+	for _, other := range []string{
+		Key("GET", "https://api.example.com", []byte(`{"q":"a"}`)),
+		Key("POST", "https://api.example.org", []byte(`{"q":"a"}`)),
+		Key("POST", "https://api.example.com", []byte(`{"q":"b"}`)),
+	} {
+		if other == base {
+			t.Error("Key should differ when method, URL or body differ")
+		}
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		record   string
+		replay   string
+		wantMode Mode
+		wantDir  string
+	}{
+		{"off", "", "", Off, ""},
+		{"record", "rec", "", Record, "rec"},
+		{"replay", "", "rep", Replay, "rep"},
+		{"replay wins", "rec", "rep", Replay, "rep"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(EnvRecord, tt.record)
+			t.Setenv(EnvReplay, tt.replay)
+			mode, dir := FromEnv()
+			if mode != tt.wantMode || dir != tt.wantDir {
+				t.Errorf("FromEnv() = %v, %q; want %v, %q", mode, dir, tt.wantMode, tt.wantDir)
+			}
+		})
+	}
+}
+
+func TestWrapClientOff(t *testing.T) {
+	if got := WrapClient(http.DefaultClient, Off, ""); got != http.DefaultClient {
+		t.Error("WrapClient(Off) should return the client unchanged")
+	}
+}
@@ -2,8 +2,12 @@
 package safety
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+
+	"github.com/user/qcmd/internal/shellparse"
 )
 
 // DangerLevel represents the severity of a command's potential risk.
@@ -32,6 +36,20 @@ func (d DangerLevel) String() string {
 	}
 }
 
+// ParseLevel parses a level name as returned by DangerLevel.String.
+func ParseLevel(name string) (DangerLevel, error) {
+	switch name {
+	case "safe":
+		return Safe, nil
+	case "caution":
+		return Caution, nil
+	case "danger":
+		return Danger, nil
+	default:
+		return Safe, fmt.Errorf("unknown level %q (must be safe, caution, or danger)", name)
+	}
+}
+
 // CheckResult contains the result of a safety check.
 type CheckResult struct {
 	// Level is the determined danger level.
@@ -42,6 +60,19 @@ type CheckResult struct {
 	Description string
 	// Category is the type of danger (filesystem, network, system).
 	Category string
+	// Segment is the pipeline or simple command that triggered the result.
+	// Empty when the command is safe.
+	Segment string
+	// Line is the 1-based line of Segment within a multi-line command.
+	Line int
+	// Hint suggests a safer alternative. Empty when the command is safe.
+	Hint string
+	// Score is the severity of the match from 0 (nothing matched) to 100.
+	// Level is derived from it using the checker's thresholds.
+	Score int
+	// ReadOnly reports that the command only reads; see ReadOnly. It is
+	// independent of Level: reading a secret is read-only but not safe.
+	ReadOnly bool
 }
 
 // Checker performs safety checks on shell commands.
@@ -52,91 +83,346 @@ type Checker struct {
 	cautionPatterns []Pattern
 	// shellWrappers contains compiled regexes for extracting nested commands.
 	shellWrappers []*regexp.Regexp
+	// disabled contains the categories switched off by configuration.
+	disabled map[string]bool
+	// gitState describes the current repository; nil when unknown.
+	gitState *GitState
+	// protectedBranches are branch patterns force pushes are blocked on.
+	protectedBranches []string
+	// productionHosts are hostname patterns reboots are blocked on over SSH.
+	productionHosts []string
+	// env describes the process environment.
+	env Environment
+	// strictAsRoot escalates privileged cautionary results to Danger.
+	strictAsRoot bool
+	// sudoProbe, if set, reports whether sudo credentials are cached. It
+	// is only called for commands run through sudo or doas.
+	sudoProbe func() bool
+	// warnThreshold is the lowest score reported as Caution.
+	warnThreshold int
+	// blockThreshold is the lowest score reported as Danger.
+	blockThreshold int
 }
 
-// NewChecker creates a new Checker with the default pattern registry.
-func NewChecker() *Checker {
-	// Compile shell wrapper patterns
+// Option is a functional option for configuring a Checker.
+type Option func(*Checker)
+
+// WithDisabledCategories removes every pattern in the given categories
+// from the checker's registry.
+func WithDisabledCategories(categories ...string) Option {
+	return func(c *Checker) {
+		c.dangerPatterns = withoutCategories(c.dangerPatterns, categories)
+		c.cautionPatterns = withoutCategories(c.cautionPatterns, categories)
+		for _, category := range categories {
+			c.disabled[category] = true
+		}
+	}
+}
+
+// WithCustomPatterns adds user-defined patterns to the registry. They are
+// tried before the built-in patterns of the same level.
+func WithCustomPatterns(patterns ...Pattern) Option {
+	return func(c *Checker) {
+		var danger, caution []Pattern
+		for _, p := range patterns {
+			switch p.Level {
+			case Danger:
+				danger = append(danger, p)
+			case Caution:
+				caution = append(caution, p)
+			}
+		}
+		c.dangerPatterns = append(danger, c.dangerPatterns...)
+		c.cautionPatterns = append(caution, c.cautionPatterns...)
+	}
+}
+
+// WithGitState sets the state of the repository commands will run in,
+// enabling the git rules that depend on it.
+func WithGitState(state GitState) Option {
+	return func(c *Checker) {
+		c.gitState = &state
+	}
+}
+
+// WithThresholds sets the lowest scores reported as Caution and Danger.
+// Results scoring below warn are reported as Safe. A zero keeps the
+// default threshold.
+func WithThresholds(warn, block int) Option {
+	return func(c *Checker) {
+		if warn > 0 {
+			c.warnThreshold = warn
+		}
+		if block > 0 {
+			c.blockThreshold = block
+		}
+	}
+}
+
+// WithEnvironment sets the process environment commands will run in,
+// used by the package and power rules.
+func WithEnvironment(env Environment) Option {
+	return func(c *Checker) {
+		c.env = env
+	}
+}
+
+// WithProtectedBranches replaces DefaultProtectedBranches.
+func WithProtectedBranches(branches ...string) Option {
+	return func(c *Checker) {
+		c.protectedBranches = branches
+	}
+}
+
+// compiledShellWrappers compiles ShellWrappers once, on first use, for all
+// checkers.
+var compiledShellWrappers = sync.OnceValue(func() []*regexp.Regexp {
 	wrappers := make([]*regexp.Regexp, 0, len(ShellWrappers))
 	for _, pattern := range ShellWrappers {
 		wrappers = append(wrappers, regexp.MustCompile(pattern))
 	}
+	return wrappers
+})
+
+// NewChecker creates a new Checker with the default pattern registry.
+func NewChecker(opts ...Option) *Checker {
+	c := &Checker{
+		dangerPatterns:    DangerPatterns,
+		cautionPatterns:   CautionPatterns,
+		shellWrappers:     compiledShellWrappers(),
+		disabled:          make(map[string]bool),
+		protectedBranches: DefaultProtectedBranches,
+		productionHosts:   DefaultProductionHosts,
+		warnThreshold:     DefaultWarnThreshold,
+		blockThreshold:    DefaultBlockThreshold,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
 
-	return &Checker{
-		dangerPatterns:  DangerPatterns,
-		cautionPatterns: CautionPatterns,
-		shellWrappers:   wrappers,
+// withoutCategories returns the patterns whose category is not listed.
+func withoutCategories(patterns []Pattern, categories []string) []Pattern {
+	kept := make([]Pattern, 0, len(patterns))
+	for _, p := range patterns {
+		disabled := false
+		for _, category := range categories {
+			if p.Category == category {
+				disabled = true
+				break
+			}
+		}
+		if !disabled {
+			kept = append(kept, p)
+		}
 	}
+	return kept
 }
 
 // Check analyzes a command and returns the safety check result.
-// It handles command normalization and nested command extraction.
+// Compound commands and multi-line scripts are split into pipelines and
+// simple commands which are checked individually; the highest-scoring
+// result is returned together with the offending segment. Its level is
+// derived from the score and the checker's thresholds.
 func (c *Checker) Check(cmd string) CheckResult {
-	normalized := Normalize(cmd)
+	var incomplete *CheckResult
+	if len(cmd) > MaxCommandLength {
+		cmd = truncateCommand(cmd)
+		result := c.incomplete(fmt.Sprintf("Command is longer than %d bytes; only the start was checked", MaxCommandLength))
+		incomplete = &result
+	}
+
+	worst := CheckResult{Level: Safe}
+	for _, pipeline := range shellparse.Pipelines(cmd) {
+		for _, segment := range segments(pipeline.Text) {
+			result := c.checkSegment(segment)
+			result.Segment = segment
+			if result = c.escalate(result); result.Score > worst.Score {
+				result.Line = pipeline.Line
+				worst = result
+			}
+		}
+	}
+
+	// Check the whole command too, so patterns spanning segment boundaries
+	// are never missed by the split.
+	result := c.checkSegment(cmd)
+	result.Segment = strings.TrimSpace(cmd)
+	if result = c.escalate(result); result.Score > worst.Score {
+		result.Line = 1
+		worst = result
+	}
+	if incomplete != nil && incomplete.Score > worst.Score {
+		incomplete.Segment = strings.TrimSpace(cmd)
+		incomplete.Line = 1
+		worst = *incomplete
+	}
+
+	worst.Level = c.level(worst.Score)
+	if worst.Level == Safe {
+		worst = CheckResult{Level: Safe, Score: worst.Score}
+	}
+	// A truncated command's unchecked end may write anything.
+	worst.ReadOnly = incomplete == nil && ReadOnly(cmd)
+	return worst
+}
 
-	// First, check the full command against danger patterns
-	result := c.checkPatterns(normalized)
-	if result.Level == Danger {
-		return result
+// segments returns the pieces of pipeline checked on their own: the
+// pipeline, its simple commands when there are several, and the same for
+// the command lists run by its subshells, brace groups and command
+// substitutions, up to maxNestedChecks of those.
+func segments(pipeline string) []string {
+	var out []string
+	add := func(text string) {
+		out = append(out, text)
+		if commands := shellparse.Commands(text); len(commands) > 1 {
+			out = append(out, commands...)
+		}
 	}
+	add(pipeline)
+	nested := shellparse.Groups(pipeline)
+	for n := 0; n < len(nested) && n < maxNestedChecks; n++ {
+		for _, p := range shellparse.Pipelines(nested[n]) {
+			add(p.Text)
+			nested = append(nested, shellparse.Groups(p.Text)...)
+		}
+	}
+	return out
+}
 
-	// Extract and check nested commands recursively
-	nestedResult := c.checkNestedCommands(normalized, 0)
-	if nestedResult.Level > result.Level {
-		return nestedResult
+// MaxCommandLength is the number of bytes of a command Check inspects.
+// Matching is linear in the input, since Go's regexps use RE2 semantics,
+// but every segment and nested command is matched again; a pathological
+// multi-kilobyte model answer should not stall the checker. Only the start
+// of a longer command is checked, and it is rated at least Caution.
+const MaxCommandLength = 16 << 10
+
+// maxNestedChecks bounds how many wrapped and payload commands are
+// extracted from one segment. Each wrapper can match at every level, so
+// without a bound the work grows exponentially with the nesting depth.
+const maxNestedChecks = 32
+
+// truncateCommand cuts cmd to at most MaxCommandLength bytes, at the last
+// line break if there is one, so no line is checked half.
+func truncateCommand(cmd string) string {
+	cmd = cmd[:MaxCommandLength]
+	if i := strings.LastIndexByte(cmd, '\n'); i > 0 {
+		cmd = cmd[:i]
 	}
+	return cmd
+}
 
-	// If no danger found, check the full command against caution patterns
-	if result.Level == Safe {
-		result = c.checkCautionPatterns(normalized)
+// incomplete returns the result for a command that could not be checked
+// fully: just cautionary enough to be shown, since what was not checked
+// may be dangerous.
+func (c *Checker) incomplete(description string) CheckResult {
+	return CheckResult{
+		Level:       Caution,
+		Score:       c.warnThreshold,
+		Description: description,
+		Hint:        "Read the whole command before running it",
 	}
+}
 
-	return result
+// level maps a score to a level using the checker's thresholds.
+func (c *Checker) level(score int) DangerLevel {
+	switch {
+	case score >= c.blockThreshold:
+		return Danger
+	case score >= c.warnThreshold:
+		return Caution
+	default:
+		return Safe
+	}
 }
 
-// checkPatterns checks a command against danger patterns only.
-func (c *Checker) checkPatterns(cmd string) CheckResult {
-	for _, pattern := range c.dangerPatterns {
-		if pattern.Regex.MatchString(cmd) {
-			return CheckResult{
-				Level:       pattern.Level,
-				Pattern:     pattern.Regex.String(),
-				Description: pattern.Description,
-				Category:    pattern.Category,
-			}
+// checkSegment analyzes a single segment of a command.
+// It handles command normalization and nested command extraction.
+func (c *Checker) checkSegment(cmd string) CheckResult {
+	normalized := Normalize(cmd)
+	budget := maxNestedChecks
+
+	return highest(
+		c.checkPatterns(normalized),
+		// Rules that depend on the repository and environment
+		c.checkRules(cmd),
+		// Commands nested in wrappers, checked recursively
+		c.checkNestedCommands(normalized, 0, &budget),
+		c.checkCautionPatterns(normalized),
+	)
+}
+
+// highest returns the result with the highest score; the first wins ties.
+func highest(results ...CheckResult) CheckResult {
+	best := CheckResult{Level: Safe}
+	for _, result := range results {
+		if result.Score > best.Score {
+			best = result
+		}
+	}
+	return best
+}
+
+// checkRules applies the git and package rules to every simple command
+// in cmd. These inspect the command's words rather than matching regexes,
+// and depend on the state given by WithGitState and WithEnvironment.
+func (c *Checker) checkRules(cmd string) CheckResult {
+	worst := CheckResult{Level: Safe}
+	for _, pipeline := range shellparse.Pipelines(cmd) {
+		for _, simple := range shellparse.Commands(pipeline.Text) {
+			words := shellparse.Words(simple)
+			worst = highest(worst, c.checkGitCommand(words), c.checkPackageCommand(words), c.checkPowerCommand(words))
 		}
 	}
+	return worst
+}
 
-	return CheckResult{Level: Safe}
+// checkPatterns checks a command against danger patterns only.
+func (c *Checker) checkPatterns(cmd string) CheckResult {
+	return matchPatterns(c.dangerPatterns, cmd)
 }
 
 // checkCautionPatterns checks a command against caution patterns.
 func (c *Checker) checkCautionPatterns(cmd string) CheckResult {
-	for _, pattern := range c.cautionPatterns {
-		if pattern.Regex.MatchString(cmd) {
-			return CheckResult{
+	return matchPatterns(c.cautionPatterns, cmd)
+}
+
+// matchPatterns returns the highest-scoring pattern matching cmd.
+func matchPatterns(patterns []Pattern, cmd string) CheckResult {
+	best := CheckResult{Level: Safe}
+	for _, pattern := range patterns {
+		if pattern.score() > best.Score && pattern.Regex.MatchString(cmd) {
+			best = CheckResult{
 				Level:       pattern.Level,
+				Score:       pattern.score(),
 				Pattern:     pattern.Regex.String(),
 				Description: pattern.Description,
 				Category:    pattern.Category,
+				Hint:        pattern.Hint,
 			}
 		}
 	}
-
-	return CheckResult{Level: Safe}
+	return best
 }
 
 // checkNestedCommands extracts and checks commands inside shell wrappers.
-// It supports recursive checking up to a maximum depth to prevent infinite loops.
-func (c *Checker) checkNestedCommands(cmd string, depth int) CheckResult {
+// It supports recursive checking up to a maximum depth to prevent infinite
+// loops. budget is the number of nested commands still allowed to be
+// checked; once it runs out the result is at least Caution.
+func (c *Checker) checkNestedCommands(cmd string, depth int, budget *int) CheckResult {
 	// Prevent infinite recursion (max depth of 5)
 	const maxDepth = 5
 	if depth >= maxDepth {
 		return CheckResult{Level: Safe}
 	}
 
-	var highestResult CheckResult
-	highestResult.Level = Safe
+	highestResult := CheckResult{Level: Safe}
+	exhausted := func() CheckResult {
+		return highest(highestResult, c.incomplete("Command nests too many wrapped commands to check fully"))
+	}
 
 	for _, wrapper := range c.shellWrappers {
 		matches := wrapper.FindStringSubmatch(cmd)
@@ -145,33 +431,47 @@ func (c *Checker) checkNestedCommands(cmd string, depth int) CheckResult {
 			if innerCmd == "" {
 				continue
 			}
+			if *budget <= 0 {
+				return exhausted()
+			}
+			*budget--
 
 			// Normalize and check the inner command
 			normalizedInner := Normalize(innerCmd)
 
-			// Check inner command against danger patterns
-			innerResult := c.checkPatterns(normalizedInner)
+			// Check inner command against danger patterns and rules
+			innerResult := highest(c.checkPatterns(normalizedInner), c.checkRules(innerCmd))
 			if innerResult.Level == Danger {
 				innerResult.Pattern = innerResult.Pattern + " (via wrapper)"
-				return innerResult
 			}
 
 			// Recursively check for nested wrappers
-			nestedResult := c.checkNestedCommands(normalizedInner, depth+1)
-			if nestedResult.Level > highestResult.Level {
-				highestResult = nestedResult
-			}
+			highestResult = highest(highestResult, innerResult, c.checkNestedCommands(normalizedInner, depth+1, budget))
+		}
+	}
 
-			// Update highest result if inner result is higher
-			if innerResult.Level > highestResult.Level {
-				highestResult = innerResult
-			}
+	// Check payloads of find -exec, xargs and parallel
+	for _, p := range commandPayloads(cmd) {
+		if *budget <= 0 {
+			return exhausted()
+		}
+		*budget--
+		normalizedInner := Normalize(p.Cmd)
+
+		innerResult := highest(c.checkPatterns(normalizedInner), c.checkCautionPatterns(normalizedInner))
+		if innerResult.Level != Safe {
+			innerResult.Pattern = innerResult.Pattern + " (via " + p.Via + ")"
 		}
+
+		highestResult = highest(highestResult, innerResult, c.checkNestedCommands(normalizedInner, depth+1, budget))
 	}
 
 	return highestResult
 }
 
+// spaceRegex matches the runs of whitespace Normalize collapses.
+var spaceRegex = regexp.MustCompile(`\s+`)
+
 // Normalize prepares a command for pattern matching.
 // It performs the following transformations:
 // 1. Trim leading/trailing whitespace
@@ -182,7 +482,6 @@ func Normalize(cmd string) string {
 	cmd = strings.TrimSpace(cmd)
 
 	// Collapse multiple spaces to single space
-	spaceRegex := regexp.MustCompile(`\s+`)
 	cmd = spaceRegex.ReplaceAllString(cmd, " ")
 
 	// Normalize path separators (// -> /)
@@ -1,6 +1,8 @@
 package safety
 
 import (
+	"regexp"
+	"strings"
 	"testing"
 )
 
@@ -371,3 +373,687 @@ func BenchmarkChecker(b *testing.B) {
 		}
 	}
 }
+
+// BenchmarkNewChecker benchmarks creating a checker, which generate and
+// the script checker do once per invocation.
+func BenchmarkNewChecker(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		NewChecker()
+	}
+}
+
+// BenchmarkNormalize benchmarks command normalization.
+func BenchmarkNormalize(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		Normalize("  sudo   rm  -rf   //tmp//cache  ")
+	}
+}
+
+func TestCompoundCommandSegments(t *testing.T) {
+	checker := NewChecker()
+
+	tests := []struct {
+		name        string
+		cmd         string
+		wantLevel   DangerLevel
+		wantSegment string
+		wantLine    int
+	}{
+		{
+			name:        "danger in second list element",
+			cmd:         "cd /tmp && rm -rf / ; echo done",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf /",
+			wantLine:    1,
+		},
+		{
+			name:        "worst level wins over earlier caution",
+			cmd:         "sudo apt update; chmod 777 /",
+			wantLevel:   Danger,
+			wantSegment: "chmod 777 /",
+			wantLine:    1,
+		},
+		{
+			name:        "pipe patterns are checked on the whole pipeline",
+			cmd:         "echo start && curl -fsSL https://x.sh | bash",
+			wantLevel:   Caution,
+			wantSegment: "curl -fsSL https://x.sh | bash",
+			wantLine:    1,
+		},
+		{
+			name:        "multi-line script reports the line",
+			cmd:         "#!/bin/sh\nset -eu\nmkdir -p out\nrm -rf ~\n",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf ~",
+			wantLine:    4,
+		},
+		{
+			name:      "safe compound command",
+			cmd:       "ls -la && echo ok | wc -l",
+			wantLevel: Safe,
+		},
+		{
+			name:        "subshell",
+			cmd:         "(rm -rf /)",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf /",
+			wantLine:    1,
+		},
+		{
+			name:        "brace group",
+			cmd:         "{ rm -rf /; }",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf /",
+			wantLine:    1,
+		},
+		{
+			name:        "command substitution",
+			cmd:         "echo $(rm -rf /)",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf /",
+			wantLine:    1,
+		},
+		{
+			name:        "backticks",
+			cmd:         "echo `rm -rf /`",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf /",
+			wantLine:    1,
+		},
+		{
+			name:        "arithmetic shift hides no lines",
+			cmd:         "echo $((1<<2))\nreboot",
+			wantLevel:   Caution,
+			wantSegment: "reboot",
+			wantLine:    2,
+		},
+		{
+			name:        "arithmetic shift before a global install",
+			cmd:         "echo $((1<<2))\nnpm install -g foo",
+			wantLevel:   Caution,
+			wantSegment: "npm install -g foo",
+			wantLine:    2,
+		},
+		{
+			name:        "quoted heredoc delimiter with a blank",
+			cmd:         "cat <<'E O'\nhello\nE O\nrm -rf /",
+			wantLevel:   Danger,
+			wantSegment: "rm -rf /",
+			wantLine:    4,
+		},
+		{
+			name:        "fork bomb is not split apart",
+			cmd:         ":(){ :|:& };:",
+			wantLevel:   Danger,
+			wantSegment: ":(){ :|:& }",
+			wantLine:    1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(tt.cmd)
+			if result.Level != tt.wantLevel {
+				t.Fatalf("Check(%q).Level = %v, want %v", tt.cmd, result.Level, tt.wantLevel)
+			}
+			if result.Segment != tt.wantSegment || result.Line != tt.wantLine {
+				t.Errorf("Check(%q) segment = %q (line %d), want %q (line %d)",
+					tt.cmd, result.Segment, result.Line, tt.wantSegment, tt.wantLine)
+			}
+		})
+	}
+}
+
+func TestPayloadExtraction(t *testing.T) {
+	checker := NewChecker()
+
+	tests := []struct {
+		name    string
+		command string
+		level   DangerLevel
+		via     string
+	}{
+		{"find exec rm on root", `find / -name "*.log" -exec rm -rf {} \;`, Danger, "(via find -exec)"},
+		{"find execdir on home", `find ~ -type d -execdir rm -rf {} +`, Danger, "(via find -execdir)"},
+		{"find delete on root", "find / -delete", Danger, "(via find -delete)"},
+		{"sudo find delete on home", "sudo find $HOME -mindepth 1 -delete", Danger, "(via find -delete)"},
+		{"find delete in cwd", "find . -name '*.tmp' -delete", Caution, "(via find -delete)"},
+		{"find exec harmless", "find . -type f -exec grep -l TODO {} +", Safe, ""},
+		{"find exec plain rm", `find /var/log -mtime +7 -exec rm {} \;`, Safe, ""},
+		{"xargs forced delete", "ls *.bak | xargs rm -rf", Caution, "(via xargs)"},
+		{"xargs with replace string", "cat dirs.txt | xargs -I DIR rm -rf DIR", Caution, "(via xargs)"},
+		{"xargs through shell wrapper", `printf '/\n' | xargs -n1 sh -c 'rm -rf /'`, Danger, ""},
+		{"xargs harmless", "find . -name '*.go' | xargs wc -l", Safe, ""},
+		{"parallel on root", "parallel rm -rf {} ::: / /tmp", Danger, "(via parallel)"},
+		{"parallel with jobs option", "parallel -j 4 chmod 777 {} ::: /", Danger, "(via parallel)"},
+		{"parallel harmless", "parallel gzip ::: *.log", Safe, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (pattern: %s)",
+					tt.command, result.Level, tt.level, result.Pattern)
+			}
+			if tt.via != "" && !strings.HasSuffix(result.Pattern, tt.via) {
+				t.Errorf("Check(%q) pattern = %q, want suffix %q", tt.command, result.Pattern, tt.via)
+			}
+		})
+	}
+}
+
+func TestObfuscationPatterns(t *testing.T) {
+	checker := NewChecker()
+
+	tests := []struct {
+		name    string
+		command string
+		level   DangerLevel
+	}{
+		{"base64 decode to sh", "echo cm0gLXJmIH4= | base64 -d | sh", Danger},
+		{"base64 long flag to bash", "base64 --decode payload.txt | bash", Danger},
+		{"base64 macOS flag to sudo sh", "cat p.b64 | base64 -D | sudo sh", Danger},
+		{"xxd reverse to bash", "echo 726d202d7266207e | xxd -r -p | bash", Danger},
+		{"openssl base64 to sh", "openssl base64 -d -in p.txt | sh", Danger},
+		{"hex escapes to sh", `printf '\x72\x6d\x20\x2d\x72\x66' | sh`, Danger},
+		{"eval decoded substitution", `eval "$(echo cm0gLXJmIH4= | base64 -d)"`, Danger},
+		{"bash -c decoded substitution", `bash -c "$(base64 -d <<< cm0gLXJmIH4=)"`, Danger},
+		{"process substitution", "bash <(echo cm0= | base64 --decode)", Danger},
+		{"python exec base64", `python3 -c 'import base64; exec(base64.b64decode("cHJpbnQoMSk="))'`, Danger},
+		{"perl eval pack", `perl -e 'eval pack("H*", "7072696e74")'`, Danger},
+		{"python exec", `python -c 'exec(open("setup.py").read())'`, Caution},
+		{"node eval", `node -e 'eval(process.argv[1])'`, Caution},
+		{"base64 decode to file", "base64 -d cert.b64 > cert.pem", Safe},
+		{"base64 encode", "base64 -w0 image.png", Safe},
+		{"xxd dump", "xxd -p firmware.bin | head", Safe},
+		{"python decode only", `python3 -c 'import base64; print(base64.b64decode("aGk="))'`, Safe},
+		{"ssh with substitution", `ssh host "$(cat cmd.txt)"`, Safe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (pattern: %s)",
+					tt.command, result.Level, tt.level, result.Pattern)
+			}
+			if tt.level != Safe && result.Category != "obfuscation" {
+				t.Errorf("Check(%q) category = %q, want obfuscation", tt.command, result.Category)
+			}
+		})
+	}
+}
+
+func TestCloudPatterns(t *testing.T) {
+	checker := NewChecker()
+
+	tests := []struct {
+		name     string
+		command  string
+		level    DangerLevel
+		category string
+	}{
+		{"s3 bucket force delete", "aws s3 rb s3://prod-assets --force", Danger, "cloud"},
+		{"s3 recursive rm", "aws s3 rm s3://logs/2023/ --recursive", Caution, "cloud"},
+		{"s3 list", "aws s3 ls s3://logs/", Safe, ""},
+		{"rds delete without snapshot", "aws rds delete-db-instance --db-instance-identifier prod --skip-final-snapshot", Danger, "cloud"},
+		{"ec2 terminate", "aws ec2 terminate-instances --instance-ids i-0abc", Caution, "cloud"},
+		{"gcloud project delete", "gcloud projects delete my-project", Danger, "cloud"},
+		{"gcloud instance delete", "gcloud compute instances delete vm-1 --zone us-east1-b", Caution, "cloud"},
+		{"azure group delete", "az group delete --name prod-rg --yes", Danger, "cloud"},
+		{"kubectl delete namespace", "kubectl delete namespace staging", Danger, "kubernetes"},
+		{"kubectl delete ns with context", "kubectl --context prod delete ns payments", Danger, "kubernetes"},
+		{"kubectl delete all pods", "kubectl delete pods --all -n default", Danger, "kubernetes"},
+		{"kubectl delete pod", "kubectl delete pod web-7f9c", Caution, "kubernetes"},
+		{"kubectl get", "kubectl get pods -A", Safe, ""},
+		{"helm uninstall", "helm uninstall web -n prod", Caution, "kubernetes"},
+		{"terraform destroy auto-approve", "terraform destroy -auto-approve", Danger, "infrastructure"},
+		{"terraform apply destroy auto-approve", "terraform apply -destroy -auto-approve", Danger, "infrastructure"},
+		{"terraform destroy", "terraform destroy", Caution, "infrastructure"},
+		{"terraform plan", "terraform plan -out plan.tfplan", Safe, ""},
+		{"psql drop database", `psql -h db -U admin -c "DROP DATABASE orders;"`, Danger, "database"},
+		{"mysql drop schema", `mysql -u root -e 'drop schema legacy'`, Danger, "database"},
+		{"dropdb", "dropdb -h db orders", Danger, "database"},
+		{"psql drop table", `psql -c "DROP TABLE sessions"`, Caution, "database"},
+		{"psql select", `psql -c "SELECT count(*) FROM orders"`, Safe, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (pattern: %s)",
+					tt.command, result.Level, tt.level, result.Pattern)
+			}
+			if result.Category != tt.category {
+				t.Errorf("Check(%q) category = %q, want %q", tt.command, result.Category, tt.category)
+			}
+		})
+	}
+}
+
+func TestWithDisabledCategories(t *testing.T) {
+	checker := NewChecker(WithDisabledCategories("kubernetes", "database"))
+
+	tests := []struct {
+		command string
+		level   DangerLevel
+	}{
+		{"kubectl delete namespace staging", Safe},
+		{`psql -c "DROP DATABASE orders"`, Safe},
+		{"terraform destroy -auto-approve", Danger},
+		{"rm -rf /", Danger},
+		{"sudo kubectl delete ns staging", Caution},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := checker.Check(tt.command).Level; got != tt.level {
+				t.Errorf("Check(%q) level = %v, want %v", tt.command, got, tt.level)
+			}
+		})
+	}
+
+	if !KnownCategory("cloud") || KnownCategory("clouds") {
+		t.Error("KnownCategory() does not match the built-in categories")
+	}
+}
+
+func TestGitRules(t *testing.T) {
+	clean := WithGitState(GitState{Branch: "feature/login"})
+	dirty := WithGitState(GitState{Branch: "main", Dirty: true})
+	onMain := WithGitState(GitState{Branch: "main"})
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		command string
+		level   DangerLevel
+	}{
+		{"force push feature branch", []Option{clean}, "git push --force", Caution},
+		{"force push current protected branch", []Option{onMain}, "git push -f", Danger},
+		{"force push protected refspec", []Option{clean}, "git push --force-with-lease origin main", Danger},
+		{"plus refspec to protected", []Option{clean}, "git push origin +HEAD:master", Danger},
+		{"combined short flags", []Option{clean}, "git push -uf origin master", Danger},
+		{"plain push to protected", []Option{onMain}, "git push origin main", Safe},
+		{"delete protected branch", []Option{clean}, "git push origin --delete main", Danger},
+		{"delete protected with colon", []Option{clean}, "git push origin :master", Danger},
+		{"delete feature branch", []Option{clean}, "git push origin --delete feature/old", Safe},
+		{"custom protected pattern", []Option{clean, WithProtectedBranches("release/*")}, "git push -f origin release/1.2", Danger},
+		{"main unprotected by policy", []Option{onMain, WithProtectedBranches()}, "git push -f", Caution},
+		{"force push without context", nil, "git push --force", Caution},
+		{"force push with git global options", []Option{clean}, "git -C ../app -c push.default=current push -f origin main", Danger},
+		{"hard reset dirty tree", []Option{dirty}, "git reset --hard HEAD~1", Danger},
+		{"hard reset clean tree", []Option{onMain}, "git reset --hard origin/main", Safe},
+		{"hard reset without context", nil, "git reset --hard", Caution},
+		{"soft reset dirty tree", []Option{dirty}, "git reset --soft HEAD~1", Safe},
+		{"hard reset in compound command", []Option{dirty}, "git fetch && git reset --hard origin/main", Danger},
+		{"hard reset through sh -c", []Option{dirty}, `sh -c "git reset --hard"`, Danger},
+		{"force push after an arithmetic shift", []Option{clean}, "echo $((1<<2))\ngit push --force origin main", Danger},
+		{"force push in a subshell", []Option{onMain}, "(cd app && git push -f)", Danger},
+		{"clean fdx", []Option{clean}, "git clean -fdx", Caution},
+		{"clean dry run", []Option{clean}, "git clean -ndx", Safe},
+		{"filter-branch", []Option{clean}, "git filter-branch --tree-filter 'rm -f secrets.txt' HEAD", Caution},
+		{"git category disabled", []Option{dirty, WithDisabledCategories("git")}, "git reset --hard", Safe},
+		{"git log", []Option{dirty}, "git log --oneline -5", Safe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewChecker(tt.opts...).Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (%s)",
+					tt.command, result.Level, tt.level, result.Description)
+			}
+			if tt.level != Safe && result.Category != "git" {
+				t.Errorf("Check(%q) category = %q, want git", tt.command, result.Category)
+			}
+		})
+	}
+}
+
+func TestPackageRules(t *testing.T) {
+	venv := WithEnvironment(Environment{VirtualEnv: true})
+	root := WithEnvironment(Environment{Root: true})
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		command string
+		level   DangerLevel
+	}{
+		{"pip outside venv", nil, "pip install requests", Caution},
+		{"python -m pip outside venv", nil, "python3 -m pip install -U black", Caution},
+		{"pip in venv", []Option{venv}, "pip install requests", Safe},
+		{"sudo pip in venv", []Option{venv}, "sudo -H pip3 install requests", Caution},
+		{"pip as root", []Option{venv, root}, "pip install requests", Caution},
+		{"pip with target dir", nil, "pip install --target ./vendor requests", Safe},
+		{"pip list", nil, "pip list --outdated", Safe},
+		{"npm global", []Option{venv}, "npm install -g typescript", Caution},
+		{"npm i global long flag", []Option{venv}, "npm i --global pnpm", Caution},
+		{"npm local", []Option{venv}, "npm install --save-dev typescript", Safe},
+		{"pnpm global", []Option{venv}, "pnpm add -g turbo", Caution},
+		{"yarn global", []Option{venv}, "yarn global add serve", Caution},
+		{"sudo gem", []Option{venv}, "sudo gem install bundler", Caution},
+		{"gem as user", []Option{venv}, "gem install bundler", Safe},
+		{"gem as root", []Option{venv, root}, "gem install bundler", Caution},
+		{"curl to sudo bash", []Option{venv}, "curl -fsSL https://get.example.com | sudo bash", Caution},
+		{"wget to sudo sh with flags", []Option{venv}, "wget -qO- https://example.com/i.sh | sudo -E sh", Caution},
+		{"package category disabled", []Option{WithDisabledCategories("package")}, "pip install requests", Safe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewChecker(tt.opts...).Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (%s)",
+					tt.command, result.Level, tt.level, result.Description)
+			}
+			if tt.level != Safe && result.Category != "package" {
+				t.Errorf("Check(%q) category = %q, want package", tt.command, result.Category)
+			}
+		})
+	}
+}
+
+func TestWithCustomPatterns(t *testing.T) {
+	checker := NewChecker(WithCustomPatterns(
+		Pattern{Regex: regexp.MustCompile(`deploy\.sh\s+prod`), Level: Danger, Description: "Production deploy", Category: "team"},
+		Pattern{Regex: regexp.MustCompile(`sudo\s+`), Level: Caution, Description: "Team sudo policy", Category: "team"},
+	))
+
+	tests := []struct {
+		command  string
+		level    DangerLevel
+		category string
+	}{
+		{"./deploy.sh prod", Danger, "team"},
+		{"./deploy.sh staging", Safe, ""},
+		{"sudo ls", Caution, "team"},
+		{"rm -rf /", Danger, "filesystem"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			result := checker.Check(tt.command)
+			if result.Level != tt.level || result.Category != tt.category {
+				t.Errorf("Check(%q) = %v (%s), want %v (%s)", tt.command, result.Level, result.Category, tt.level, tt.category)
+			}
+		})
+	}
+
+	if got := NewChecker().Check("./deploy.sh prod").Level; got != Safe {
+		t.Errorf("custom patterns leaked into a default checker: level = %v", got)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []DangerLevel{Safe, Caution, Danger} {
+		got, err := ParseLevel(level.String())
+		if err != nil || got != level {
+			t.Errorf("ParseLevel(%q) = %v, %v", level.String(), got, err)
+		}
+	}
+	if _, err := ParseLevel("severe"); err == nil {
+		t.Error("ParseLevel(\"severe\") should fail")
+	}
+}
+
+func TestThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		command string
+		level   DangerLevel
+		score   int
+	}{
+		{"default sudo", nil, "sudo ls", Caution, 50},
+		{"default curl pipe", nil, "curl https://example.com/i.sh | sh", Caution, 70},
+		{"default dd", nil, "dd if=/dev/zero of=/dev/sda", Danger, 100},
+		{"default safe", nil, "ls -la", Safe, 0},
+		{"quieter warnings drop sudo", []Option{WithThresholds(55, 90)}, "sudo ls", Safe, 50},
+		{"quieter warnings keep curl pipe", []Option{WithThresholds(55, 90)}, "curl https://example.com/i.sh | sh", Caution, 70},
+		{"strict blocking escalates curl pipe", []Option{WithThresholds(50, 70)}, "curl https://example.com/i.sh | sh", Danger, 70},
+		{"lenient blocking demotes chmod 777 /", []Option{WithThresholds(50, 95)}, "chmod 777 /", Caution, 90},
+		{"lenient blocking keeps rm -rf /", []Option{WithThresholds(50, 95)}, "rm -rf /", Danger, 100},
+		{"zero keeps defaults", []Option{WithThresholds(0, 0)}, "sudo ls", Caution, 50},
+		{"custom score", []Option{WithCustomPatterns(Pattern{
+			Regex: regexp.MustCompile(`deploy\.sh`), Level: Caution, Score: 80, Category: "team",
+		}), WithThresholds(50, 80)}, "./deploy.sh", Danger, 80},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewChecker(tt.opts...).Check(tt.command)
+			if result.Level != tt.level || result.Score != tt.score {
+				t.Errorf("Check(%q) = %v (score %d), want %v (score %d)",
+					tt.command, result.Level, result.Score, tt.level, tt.score)
+			}
+		})
+	}
+}
+
+func TestPatternHints(t *testing.T) {
+	for _, patterns := range [][]Pattern{DangerPatterns, CautionPatterns} {
+		for _, p := range patterns {
+			if p.Hint == "" {
+				t.Errorf("pattern %q (%s) has no remediation hint", p.Regex, p.Description)
+			}
+		}
+	}
+
+	result := NewChecker(WithGitState(GitState{Branch: "main", Dirty: true})).Check("git reset --hard")
+	if result.Hint == "" {
+		t.Error("git rule result has no remediation hint")
+	}
+	if result := NewChecker().Check("ls"); result.Hint != "" {
+		t.Errorf("safe result has hint %q", result.Hint)
+	}
+}
+
+func TestPowerRules(t *testing.T) {
+	local := WithEnvironment(Environment{Hostname: "api-prod-1"})
+	remote := WithEnvironment(Environment{Remote: true, Hostname: "build-box"})
+	remoteProd := WithEnvironment(Environment{Remote: true, Hostname: "API-PROD-1"})
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		command string
+		level   DangerLevel
+	}{
+		{"local reboot", []Option{local}, "sudo reboot", Caution},
+		{"remote reboot", []Option{remote}, "sudo reboot", Caution},
+		{"remote prod reboot", []Option{remoteProd}, "sudo reboot", Danger},
+		{"remote prod shutdown", []Option{remoteProd}, "shutdown -h now", Danger},
+		{"remote prod systemctl", []Option{remoteProd}, "systemctl poweroff", Danger},
+		{"remote prod init 6", []Option{remoteProd}, "init 6", Danger},
+		{"cancel shutdown", []Option{remoteProd}, "shutdown -c", Safe},
+		{"systemctl restart", []Option{remoteProd}, "systemctl restart nginx", Safe},
+		{"custom production hosts", []Option{remoteProd, WithProductionHosts("db-*")}, "reboot", Caution},
+		{"custom production host match", []Option{remote, WithProductionHosts("build-*")}, "reboot", Danger},
+		{"system category disabled", []Option{remoteProd, WithDisabledCategories("system")}, "reboot", Safe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewChecker(tt.opts...).Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (%s)",
+					tt.command, result.Level, tt.level, result.Description)
+			}
+			if tt.level != Safe && result.Category != "system" {
+				t.Errorf("Check(%q) category = %q, want system", tt.command, result.Category)
+			}
+		})
+	}
+}
+
+func TestStrictAsRoot(t *testing.T) {
+	root := WithEnvironment(Environment{Root: true})
+	sudo := WithEnvironment(Environment{SudoCached: true})
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		command string
+		level   DangerLevel
+	}{
+		{"rm -rf as user", nil, "rm -rf build", Caution},
+		{"rm -rf as root, not strict", []Option{root}, "rm -rf build", Caution},
+		{"rm -rf as root", []Option{root, WithStrictAsRoot()}, "rm -rf build", Danger},
+		{"reboot as root", []Option{root, WithStrictAsRoot()}, "reboot", Danger},
+		{"other category as root", []Option{root, WithStrictAsRoot()}, "npm install -g typescript", Caution},
+		{"sudo rm with cached credentials", []Option{sudo, WithStrictAsRoot()}, "sudo rm -rf build", Danger},
+		{"rm without sudo, cached credentials", []Option{sudo, WithStrictAsRoot()}, "rm -rf build", Caution},
+		{"sudo with a harmless command, cached credentials", []Option{sudo, WithStrictAsRoot()}, "sudo apt update", Caution},
+		{"sudo systemctl status, cached credentials", []Option{sudo, WithStrictAsRoot()}, "sudo systemctl status nginx", Caution},
+		{"sudo -u with cached credentials", []Option{sudo, WithStrictAsRoot()}, "sudo -u www rm -rf build", Danger},
+		{"sudo as root", []Option{root, WithStrictAsRoot()}, "sudo apt update", Caution},
+		{"sudo rm, probed credentials", []Option{WithSudoProbe(func() bool { return true }), WithStrictAsRoot()}, "sudo rm -rf build", Danger},
+		{"safe as root", []Option{root, WithStrictAsRoot()}, "ls -la", Safe},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewChecker(tt.opts...).Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check(%q) level = %v, want %v (%s)",
+					tt.command, result.Level, tt.level, result.Description)
+			}
+		})
+	}
+
+	// Commands without sudo never run the probe.
+	probed := false
+	probe := WithSudoProbe(func() bool { probed = true; return true })
+	NewChecker(probe, WithStrictAsRoot()).Check("rm -rf build && reboot")
+	if probed {
+		t.Error("sudo probed for a command without sudo")
+	}
+}
+
+func TestLongCommands(t *testing.T) {
+	long := strings.Repeat("echo hello\n", MaxCommandLength/10)
+	nested := strings.Repeat("bash -c 'sh -c \"zsh -c ", 50) + "ls"
+
+	tests := []struct {
+		name        string
+		command     string
+		level       DangerLevel
+		description string
+	}{
+		{"long safe command", long, Caution, "only the start was checked"},
+		{"danger at the start", "rm -rf /\n" + long, Danger, "root"},
+		{"danger past the limit", long + "rm -rf /", Caution, "only the start was checked"},
+		{"deeply nested wrappers", nested, Caution, "too many wrapped commands"},
+		{"few nested wrappers", "sudo bash -c 'sh -c \"ls\"'", Caution, "elevated privileges"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := NewChecker().Check(tt.command)
+			if result.Level != tt.level {
+				t.Fatalf("Check() level = %v, want %v (%s)", result.Level, tt.level, result.Description)
+			}
+			if !strings.Contains(strings.ToLower(result.Description), tt.description) {
+				t.Errorf("Check() description = %q, want it to mention %q", result.Description, tt.description)
+			}
+			if len(result.Segment) > MaxCommandLength {
+				t.Errorf("Check() segment is %d bytes, want at most %d", len(result.Segment), MaxCommandLength)
+			}
+		})
+	}
+}
+
+func TestMeasureComplexity(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    Complexity
+		tooLong bool
+		tooDeep bool
+	}{
+		{"simple", "ls -la", Complexity{Length: 6, Pipeline: 1}, false, false},
+		{"pipeline", "ps aux | grep ssh | awk '{print $2}'", Complexity{Length: 36, Pipeline: 3}, false, false},
+		{"longest pipeline counts", "cat a | sort | uniq -c | sort -rn | head && echo done | wc -l", Complexity{Length: 61, Pipeline: 5}, true, true},
+		{"quoted pipe", `grep 'a|b|c|d|e' notes.txt`, Complexity{Length: 26, Pipeline: 1}, false, false},
+		{"characters, not bytes", "echo héllo", Complexity{Length: 10, Pipeline: 1}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MeasureComplexity(tt.command)
+			if got != tt.want {
+				t.Fatalf("MeasureComplexity(%q) = %+v, want %+v", tt.command, got, tt.want)
+			}
+			if got.TooLong(40) != tt.tooLong || got.TooDeep(4) != tt.tooDeep {
+				t.Errorf("TooLong(40), TooDeep(4) = %v, %v; want %v, %v", got.TooLong(40), got.TooDeep(4), tt.tooLong, tt.tooDeep)
+			}
+			if got.TooLong(0) || got.TooDeep(0) {
+				t.Error("a limit of 0 should mean no limit")
+			}
+		})
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"ls -la", true},
+		{"cat /etc/hosts | grep localhost | wc -l", true},
+		{"ps aux | sort -k3 -nr | head", true},
+		{"LC_ALL=C sort file.txt", true},
+		{"LANG=C TZ=UTC COLUMNS=200 ls -l", true},
+		{"grep -r TODO . 2>/dev/null", true},
+		{"find . -name '*.go' 2>&1 | less", true},
+		{"git -C repo log --oneline", true},
+		{"git status && git diff", true},
+		{"kubectl get pods -A", true},
+		{"/usr/bin/df -h", true},
+		{"hostname", true},
+		{"rm file.txt", false},
+		{"mv a b", false},
+		{"chmod +x script.sh", false},
+		{"git push origin main", false},
+		{"git log --output=log.txt", false},
+		{"git diff --output patch.diff", false},
+		{"git show --output=commit.txt HEAD", false},
+		{"git grep -Ovim TODO", false},
+		{"GIT_PAGER=./x.sh git log", false},
+		{"GIT_EXTERNAL_DIFF=./x.sh git diff", false},
+		{"LD_PRELOAD=./evil.so ls", false},
+		{"LC_ALL=C LD_PRELOAD=./evil.so ls", false},
+		{"git", false},
+		{"ls > listing.txt", false},
+		{"echo hi >> notes", false},
+		{"sort -o out.txt in.txt", false},
+		{"find . -name '*.tmp' -delete", false},
+		{"find . -exec rm {} +", false},
+		{"hostname newname", false},
+		{"ls; rm -f x", false},
+		{"cat $(mktemp)", false},
+		{"kubectl delete pod web", false},
+		{"curl https://example.com", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := ReadOnly(tt.command); got != tt.want {
+				t.Errorf("ReadOnly(%q) = %v, want %v", tt.command, got, tt.want)
+			}
+		})
+	}
+
+	checker := NewChecker()
+	if result := checker.Check("ls -la"); !result.ReadOnly {
+		t.Errorf("Check(ls -la).ReadOnly = false, want true")
+	}
+	if result := checker.Check(strings.Repeat("echo hello\n", MaxCommandLength/10)); result.ReadOnly {
+		t.Errorf("Check(truncated).ReadOnly = true, want false")
+	}
+}
@@ -0,0 +1,40 @@
+package safety
+
+import (
+	"unicode/utf8"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// Complexity measures how hard a command is to review at a glance. Very
+// long one-liners and deep pipelines are where mistakes hide.
+type Complexity struct {
+	// Length is the length of the command in characters.
+	Length int
+	// Pipeline is the number of commands in its longest pipeline.
+	Pipeline int
+}
+
+// MeasureComplexity returns the complexity of cmd. Pipelines inside
+// command substitutions are not counted separately.
+func MeasureComplexity(cmd string) Complexity {
+	c := Complexity{Length: utf8.RuneCountInString(cmd)}
+	for _, pipeline := range shellparse.Pipelines(cmd) {
+		if n := len(shellparse.Commands(pipeline.Text)); n > c.Pipeline {
+			c.Pipeline = n
+		}
+	}
+	return c
+}
+
+// TooLong reports whether c is longer than maxLength characters; a
+// maxLength of 0 means no limit.
+func (c Complexity) TooLong(maxLength int) bool {
+	return maxLength > 0 && c.Length > maxLength
+}
+
+// TooDeep reports whether c has a pipeline of more than maxPipeline
+// commands; a maxPipeline of 0 means no limit.
+func (c Complexity) TooDeep(maxPipeline int) bool {
+	return maxPipeline > 0 && c.Pipeline > maxPipeline
+}
@@ -0,0 +1,182 @@
+package safety
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultProtectedBranches are the branches force pushes are blocked on
+// when no project policy says otherwise.
+var DefaultProtectedBranches = []string{"main", "master"}
+
+// GitState describes the repository a command is going to run in.
+type GitState struct {
+	// Branch is the checked-out branch; empty when HEAD is detached.
+	Branch string
+	// Dirty reports uncommitted changes to tracked files.
+	Dirty bool
+}
+
+// gitGlobalArgOptions are git options, given before the subcommand, whose
+// value is a separate word.
+var gitGlobalArgOptions = map[string]bool{
+	"-C": true, "-c": true, "--git-dir": true, "--work-tree": true, "--namespace": true,
+}
+
+// checkGitCommand checks the words of a single git invocation. Unlike the
+// regex patterns these rules depend on the repository state, so a hard
+// reset is only dangerous when there is something to lose.
+func (c *Checker) checkGitCommand(words []string) CheckResult {
+	words = stripPrefixes(words)
+	if c.disabled["git"] || len(words) == 0 || words[0] != "git" {
+		return CheckResult{Level: Safe}
+	}
+
+	// Skip global options to find the subcommand.
+	args := words[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if gitGlobalArgOptions[args[0]] {
+			args = args[1:]
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return CheckResult{Level: Safe}
+	}
+
+	switch args[0] {
+	case "push":
+		return c.checkGitPush(args[1:])
+	case "reset":
+		if containsWord(args[1:], "--hard") {
+			return c.checkGitReset()
+		}
+	case "clean":
+		if gitCleanForced(args[1:]) {
+			return gitResult(Caution, 65, "git clean -f", "Permanently delete untracked (and with -x, ignored) files",
+				"Preview with git clean -n first")
+		}
+	case "filter-branch", "filter-repo":
+		return gitResult(Caution, 65, "git "+args[0], "Rewrite the entire repository history",
+			"Work on a fresh clone and keep a backup of the original")
+	}
+	return CheckResult{Level: Safe}
+}
+
+// checkGitPush flags force pushes, which are dangerous when they rewrite
+// a protected branch, and deletions of protected branches.
+func (c *Checker) checkGitPush(args []string) CheckResult {
+	force, del := false, false
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "-f" || arg == "--force" || arg == "--mirror" ||
+			strings.HasPrefix(arg, "--force-with-lease") || strings.HasPrefix(arg, "--force-if-includes"):
+			force = true
+		case arg == "-d" || arg == "--delete":
+			del = true
+		case strings.HasPrefix(arg, "-"):
+			if !strings.HasPrefix(arg, "--") && strings.Contains(arg[1:], "f") {
+				force = true
+			}
+		default:
+			positional = append(positional, arg)
+		}
+	}
+
+	// The first positional argument is the remote; the rest are refspecs.
+	var targets []string
+	if len(positional) > 1 {
+		for _, refspec := range positional[1:] {
+			if strings.HasPrefix(refspec, "+") {
+				force = true
+			}
+			if strings.HasPrefix(refspec, ":") {
+				del = true
+			}
+			refspec = strings.TrimPrefix(refspec, "+")
+			if i := strings.LastIndex(refspec, ":"); i >= 0 {
+				refspec = refspec[i+1:]
+			}
+			targets = append(targets, strings.TrimPrefix(refspec, "refs/heads/"))
+		}
+	} else if c.gitState != nil && c.gitState.Branch != "" {
+		targets = append(targets, c.gitState.Branch)
+	}
+	if !force && !del {
+		return CheckResult{Level: Safe}
+	}
+
+	for _, target := range targets {
+		if target == "HEAD" && c.gitState != nil {
+			target = c.gitState.Branch
+		}
+		if !c.protectedBranch(target) {
+			continue
+		}
+		if del {
+			return gitResult(Danger, 95, "git push --delete", "Delete protected branch "+target,
+				"Protected branches are normally deleted through the hosting service, if at all")
+		}
+		return gitResult(Danger, 95, "git push --force", "Force push rewrites protected branch "+target,
+			"Push a new branch and open a pull request, or use git revert")
+	}
+	if !force {
+		// Deleting feature branches is routine.
+		return CheckResult{Level: Safe}
+	}
+	return gitResult(Caution, 70, "git push --force", "Force push rewrites remote history",
+		"Use --force-with-lease so others' pushes are not overwritten")
+}
+
+// checkGitReset flags hard resets, which are only dangerous when the
+// working tree has uncommitted changes. Without git context the result
+// is a caution.
+func (c *Checker) checkGitReset() CheckResult {
+	switch {
+	case c.gitState == nil:
+		return gitResult(Caution, 60, "git reset --hard", "Hard reset discards any uncommitted changes",
+			"Run git stash first to keep uncommitted changes")
+	case c.gitState.Dirty:
+		return gitResult(Danger, 95, "git reset --hard", "Hard reset discards the uncommitted changes in this repository",
+			"Run git stash first to keep uncommitted changes")
+	default:
+		return CheckResult{Level: Safe}
+	}
+}
+
+// protectedBranch reports whether branch matches a protected branch
+// pattern. Patterns may use path.Match wildcards, e.g. "release/*".
+func (c *Checker) protectedBranch(branch string) bool {
+	if branch == "" {
+		return false
+	}
+	for _, pattern := range c.protectedBranches {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// gitCleanForced reports whether git clean arguments include --force.
+func gitCleanForced(args []string) bool {
+	for _, arg := range args {
+		if arg == "--force" || (strings.HasPrefix(arg, "-") && !strings.HasPrefix(arg, "--") && strings.Contains(arg, "f")) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitResult builds a CheckResult for a git rule.
+func gitResult(level DangerLevel, score int, rule, description, hint string) CheckResult {
+	return CheckResult{
+		Level:       level,
+		Score:       score,
+		Pattern:     rule,
+		Description: description,
+		Category:    "git",
+		Hint:        hint,
+	}
+}
@@ -0,0 +1,82 @@
+package safety
+
+import (
+	"path"
+	"strings"
+)
+
+// DefaultProductionHosts are the hostname patterns treated as production
+// when connected over SSH, unless replaced with WithProductionHosts.
+var DefaultProductionHosts = []string{"*prod*", "*prd*"}
+
+// WithProductionHosts replaces DefaultProductionHosts. Patterns use
+// path.Match wildcards and are matched case-insensitively.
+func WithProductionHosts(patterns ...string) Option {
+	return func(c *Checker) {
+		c.productionHosts = patterns
+	}
+}
+
+// checkPowerCommand flags commands that reboot or power off the machine.
+// They are cautionary locally and dangerous over SSH on a production host,
+// where nobody is at the console to bring it back.
+func (c *Checker) checkPowerCommand(words []string) CheckResult {
+	if c.disabled["system"] {
+		return CheckResult{Level: Safe}
+	}
+	words = stripPrefixes(words)
+	if len(words) == 0 {
+		return CheckResult{Level: Safe}
+	}
+
+	var rule string
+	name, args := words[0], words[1:]
+	switch {
+	case name == "reboot" || name == "poweroff" || name == "halt":
+		rule = name
+	case name == "shutdown" && !hasWord(args, "-c"):
+		rule = name
+	case name == "systemctl" && len(args) > 0 &&
+		(args[0] == "reboot" || args[0] == "poweroff" || args[0] == "halt" || args[0] == "kexec"):
+		rule = "systemctl " + args[0]
+	case (name == "init" || name == "telinit") && len(args) > 0 && (args[0] == "0" || args[0] == "6"):
+		rule = name + " " + args[0]
+	default:
+		return CheckResult{Level: Safe}
+	}
+
+	result := CheckResult{
+		Level:       Caution,
+		Score:       60,
+		Pattern:     rule,
+		Description: "Reboots or shuts down the machine",
+		Category:    "system",
+		Hint:        "Warn logged-in users first, e.g. shutdown -r +5 instead of an immediate reboot",
+	}
+	if c.env.Remote {
+		result.Score = 70
+		result.Description = "Reboots or shuts down the remote host this SSH session is connected to"
+		result.Hint = "Make sure you can reach the host again (console or out-of-band access) before running this"
+		if c.productionHost() {
+			result.Level = Danger
+			result.Score = 95
+			result.Description = "Reboots or shuts down " + c.env.Hostname + ", which looks like a production host"
+		}
+	}
+	return result
+}
+
+// productionHost reports whether the checker's hostname matches a
+// production host pattern.
+func (c *Checker) productionHost() bool {
+	host := strings.ToLower(c.env.Hostname)
+	if host == "" {
+		return false
+	}
+	for _, pattern := range c.productionHosts {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
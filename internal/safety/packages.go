@@ -0,0 +1,90 @@
+package safety
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Environment describes the process environment a command will run in.
+type Environment struct {
+	// VirtualEnv reports an active Python virtualenv or conda environment.
+	VirtualEnv bool
+	// Root reports that commands run as the superuser.
+	Root bool
+	// SudoCached reports that sudo runs without asking for a password.
+	SudoCached bool
+	// Remote reports that qcmd runs in an SSH session.
+	Remote bool
+	// Hostname is the name of the machine commands run on.
+	Hostname string
+}
+
+// pipCommand matches pip executables such as pip, pip3 and pip3.12.
+var pipCommand = regexp.MustCompile(`^pip[0-9.]*$`)
+
+// pythonCommand matches python executables such as python3 and python3.12.
+var pythonCommand = regexp.MustCompile(`^python[0-9.]*$`)
+
+// checkPackageCommand flags package installs that change the system or
+// user-wide environment rather than a project's.
+func (c *Checker) checkPackageCommand(words []string) CheckResult {
+	if c.disabled["package"] || len(words) == 0 {
+		return CheckResult{Level: Safe}
+	}
+	root := c.env.Root || words[0] == "sudo"
+	words = stripPrefixes(words)
+	if len(words) < 2 {
+		return CheckResult{Level: Safe}
+	}
+
+	name, args := words[0], words[1:]
+	if pythonCommand.MatchString(name) && len(args) >= 2 && args[0] == "-m" && args[1] == "pip" {
+		name, args = "pip", args[2:]
+	}
+
+	switch {
+	case pipCommand.MatchString(name) && len(args) > 0 && args[0] == "install":
+		switch {
+		case root:
+			return packageResult("pip install", "Install Python packages system-wide as root",
+				"Use a virtual environment, or pipx for command-line tools")
+		case !c.env.VirtualEnv && !hasWord(args, "--target") && !hasWord(args, "-t") && !hasWord(args, "--prefix"):
+			return packageResult("pip install", "Install Python packages outside a virtual environment",
+				"Create one with python3 -m venv .venv, or use pipx for command-line tools")
+		}
+	case (name == "npm" || name == "pnpm") && len(args) > 0 &&
+		(args[0] == "install" || args[0] == "i" || args[0] == "add") &&
+		(hasWord(args, "-g") || hasWord(args, "--global")):
+		return packageResult(name+" install -g", "Install packages globally",
+			"Install into the project and run it with npx")
+	case name == "yarn" && len(args) > 1 && args[0] == "global" && args[1] == "add":
+		return packageResult("yarn global add", "Install packages globally",
+			"Install into the project and run it with yarn exec")
+	case name == "gem" && args[0] == "install" && root && !hasWord(args, "--user-install"):
+		return packageResult("gem install", "Install gems system-wide as root",
+			"Use gem install --user-install or Bundler")
+	}
+	return CheckResult{Level: Safe}
+}
+
+// hasWord reports whether words contains w exactly.
+func hasWord(words []string, w string) bool {
+	for _, word := range words {
+		if word == w || strings.HasPrefix(word, w+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// packageResult builds a CheckResult for a package rule.
+func packageResult(rule, description, hint string) CheckResult {
+	return CheckResult{
+		Level:       Caution,
+		Score:       DefaultScore(Caution),
+		Pattern:     rule,
+		Description: description,
+		Category:    "package",
+		Hint:        hint,
+	}
+}
@@ -11,8 +11,42 @@ type Pattern struct {
 	Level DangerLevel
 	// Description is a human-readable explanation of the danger.
 	Description string
-	// Category is the type of danger (filesystem, network, system).
+	// Score is the severity from 1 to 100; zero means DefaultScore(Level).
+	Score int
+	// Category is the type of danger (filesystem, network, system,
+	// obfuscation, cloud, kubernetes, infrastructure, database, git, package).
 	Category string
+	// Hint suggests a safer way to achieve the same result.
+	Hint string
+}
+
+const (
+	// DefaultWarnThreshold is the lowest score reported as Caution.
+	DefaultWarnThreshold = 50
+	// DefaultBlockThreshold is the lowest score reported as Danger.
+	DefaultBlockThreshold = 90
+)
+
+// DefaultScore returns the score of a pattern of the given level that
+// does not set its own: one that lands in that level with the default
+// thresholds.
+func DefaultScore(level DangerLevel) int {
+	switch level {
+	case Danger:
+		return DefaultBlockThreshold
+	case Caution:
+		return DefaultWarnThreshold
+	default:
+		return 0
+	}
+}
+
+// score returns the pattern's severity.
+func (p Pattern) score() int {
+	if p.Score > 0 {
+		return p.Score
+	}
+	return DefaultScore(p.Level)
 }
 
 // DangerPatterns contains patterns that should block command injection.
@@ -23,134 +57,359 @@ var DangerPatterns = []Pattern{
 		// Case insensitive for r/R and f/F flags
 		Regex:       regexp.MustCompile(`(?i)rm\s+(-[rf]+\s+)*(/|~|\$HOME)(\s|$)`),
 		Level:       Danger,
+		Score:       100,
 		Description: "Recursive delete on root or home directory",
 		Category:    "filesystem",
+		Hint:        "Name the specific directory to delete instead of / or ~",
 	},
 	{
 		Regex:       regexp.MustCompile(`rm\s+(-[rRf]+\s+)*/\*(\s|$)`),
 		Level:       Danger,
+		Score:       100,
 		Description: "Delete everything in root directory",
 		Category:    "filesystem",
+		Hint:        "Name the specific directory to delete instead of /*",
 	},
 	{
 		Regex:       regexp.MustCompile(`rm\s+-[rRf]*[rRf][rRf]*\s+\*(\s|$)`),
 		Level:       Danger,
 		Description: "Delete all files in current directory with force/recursive flags",
 		Category:    "filesystem",
+		Hint:        "List the files with ls first, or name them explicitly instead of *",
 	},
 	{
 		Regex:       regexp.MustCompile(`dd\s+.*of=/dev/[sh]d[a-z]+`),
 		Level:       Danger,
+		Score:       100,
 		Description: "Direct disk write (dd to block device)",
 		Category:    "filesystem",
+		Hint:        "Double-check the of= device with lsblk; write to an image file if unsure",
 	},
 	{
 		Regex:       regexp.MustCompile(`mkfs\.[a-z0-9]+\s+/dev/`),
 		Level:       Danger,
+		Score:       100,
 		Description: "Filesystem format on a device",
 		Category:    "filesystem",
+		Hint:        "Double-check the device with lsblk before formatting",
 	},
 	{
 		Regex:       regexp.MustCompile(`>\s*/dev/[sh]d[a-z]`),
 		Level:       Danger,
+		Score:       100,
 		Description: "Redirect output to disk device",
 		Category:    "filesystem",
+		Hint:        "Redirect to a regular file instead of a disk device",
 	},
 	{
 		Regex:       regexp.MustCompile(`:\s*\(\s*\)\s*\{[^}]*:\s*\|\s*:`),
 		Level:       Danger,
+		Score:       95,
 		Description: "Fork bomb pattern detected",
 		Category:    "system",
+		Hint:        "Do not run this; it exhausts process slots until the machine is rebooted",
 	},
 	{
 		Regex:       regexp.MustCompile(`chmod\s+(-[rR]+\s+)*(000|777)\s+/(\s|$)`),
 		Level:       Danger,
 		Description: "Dangerous permission change on root filesystem",
 		Category:    "filesystem",
+		Hint:        "Grant only the permissions needed, on a specific path",
 	},
 	{
 		Regex:       regexp.MustCompile(`chown\s+(-[rR]+\s+)*.+\s+/(\s|$)`),
 		Level:       Danger,
 		Description: "Recursive ownership change on root filesystem",
 		Category:    "filesystem",
+		Hint:        "Change ownership of a specific path instead of /",
 	},
 	{
 		Regex:       regexp.MustCompile(`mv\s+/\s+`),
 		Level:       Danger,
 		Description: "Move root directory",
 		Category:    "filesystem",
+		Hint:        "Move a specific subdirectory instead of /",
 	},
 	{
 		Regex:       regexp.MustCompile(`cat\s+/dev/u?random\s*>\s*/dev/sd`),
 		Level:       Danger,
+		Score:       100,
 		Description: "Write random data to disk device",
 		Category:    "filesystem",
+		Hint:        "Double-check the target device with lsblk; write to an image file if unsure",
 	},
 	{
 		Regex:       regexp.MustCompile(`>\s*/etc/(passwd|shadow)`),
 		Level:       Danger,
 		Description: "Overwrite authentication files",
 		Category:    "system",
+		Hint:        "Use useradd, usermod, passwd or vipw instead of overwriting the file",
+	},
+
+	// Obfuscated payloads evade every other pattern by design, so decoding
+	// something straight into a shell is treated as dangerous on its own.
+	{
+		Regex:       regexp.MustCompile(`base64\s+(.*\s)?(-[a-zA-Z]*[dD]|--decode)\b.*\|\s*(sudo\s+)?(ba|z|da|k)?sh(\s|$)`),
+		Level:       Danger,
+		Description: "Base64-decoded payload piped to shell",
+		Category:    "obfuscation",
+		Hint:        "Decode to a file and read it before running it",
+	},
+	{
+		Regex:       regexp.MustCompile(`xxd\s+(.*\s)?-[a-z]*r[a-z]*\b.*\|\s*(sudo\s+)?(ba|z|da|k)?sh(\s|$)`),
+		Level:       Danger,
+		Description: "Hex-decoded payload piped to shell",
+		Category:    "obfuscation",
+		Hint:        "Decode to a file and read it before running it",
+	},
+	{
+		Regex:       regexp.MustCompile(`openssl\s+(enc\s+)?-?base64\s+(.*\s)?-d\b.*\|\s*(sudo\s+)?(ba|z|da|k)?sh(\s|$)`),
+		Level:       Danger,
+		Description: "Base64-decoded payload piped to shell",
+		Category:    "obfuscation",
+		Hint:        "Decode to a file and read it before running it",
+	},
+	{
+		Regex:       regexp.MustCompile(`(printf|echo\s+-e)\s+["']?(\\x[0-9a-fA-F]{2}){4,}.*\|\s*(sudo\s+)?(ba|z|da|k)?sh(\s|$)`),
+		Level:       Danger,
+		Description: "Hex-escaped payload piped to shell",
+		Category:    "obfuscation",
+		Hint:        "Print the decoded text and read it before running it",
+	},
+	{
+		Regex:       regexp.MustCompile("(\\beval|\\b(ba|z|da|k)?sh\\s+(-c\\s+)?[\"']?(\\$\\(|`)|\\b(ba|z|da|k)?sh\\s+<\\().*(base64\\s+(.*\\s)?(-[a-zA-Z]*[dD]|--decode)\\b|xxd\\s+(.*\\s)?-[a-z]*r[a-z]*\\b)"),
+		Level:       Danger,
+		Description: "Decoded payload executed through command substitution",
+		Category:    "obfuscation",
+		Hint:        "Decode to a file and read it before running it",
+	},
+	{
+		Regex:       regexp.MustCompile(`(python[0-9.]*|perl|ruby|node)\s+-[ce]\s+.*\b(exec|eval|system)\b.*(b64decode|decode_base64|unhexlify|fromhex|atob|Buffer\.from|pack\s*\(?\s*["']H)`),
+		Level:       Danger,
+		Description: "Interpreter executing an encoded payload",
+		Category:    "obfuscation",
+		Hint:        "Decode the payload and read it before running it",
+	},
+
+	// Cloud and operations tooling: deletions here are usually remote,
+	// immediate and unrecoverable.
+	{
+		Regex:       regexp.MustCompile(`aws\s+s3\s+rb\s+.*--force`),
+		Level:       Danger,
+		Description: "Force-delete an S3 bucket and all of its objects",
+		Category:    "cloud",
+		Hint:        "Empty and delete the bucket in separate steps after checking its contents with aws s3 ls",
+	},
+	{
+		Regex:       regexp.MustCompile(`aws\s+rds\s+delete-db-(instance|cluster)\s+.*--skip-final-snapshot`),
+		Level:       Danger,
+		Description: "Delete a database instance without a final snapshot",
+		Category:    "cloud",
+		Hint:        "Drop --skip-final-snapshot so the data can be restored",
+	},
+	{
+		Regex:       regexp.MustCompile(`gcloud\s+projects\s+delete\b`),
+		Level:       Danger,
+		Description: "Delete a Google Cloud project",
+		Category:    "cloud",
+		Hint:        "Check the project ID with gcloud config get-value project first",
+	},
+	{
+		Regex:       regexp.MustCompile(`az\s+group\s+delete\b`),
+		Level:       Danger,
+		Description: "Delete an Azure resource group and everything in it",
+		Category:    "cloud",
+		Hint:        "List the group's resources with az resource list first",
+	},
+	{
+		Regex:       regexp.MustCompile(`kubectl\s+(.*\s)?delete\s+(ns|namespaces?)\b`),
+		Level:       Danger,
+		Description: "Delete a Kubernetes namespace and all of its resources",
+		Category:    "kubernetes",
+		Hint:        "Check the context with kubectl config current-context and delete individual resources instead",
+	},
+	{
+		Regex:       regexp.MustCompile(`kubectl\s+(.*\s)?delete\s+.*(--all\b|--all-namespaces\b|\s-A\b)`),
+		Level:       Danger,
+		Description: "Delete every Kubernetes resource of a kind",
+		Category:    "kubernetes",
+		Hint:        "Select the resources by name or label instead of --all",
+	},
+	{
+		Regex:       regexp.MustCompile(`terraform\s+(destroy|apply\s+(.*\s)?-destroy)\b.*-auto-approve`),
+		Level:       Danger,
+		Description: "Destroy infrastructure without a confirmation prompt",
+		Category:    "infrastructure",
+		Hint:        "Run terraform plan -destroy first and drop -auto-approve",
+	},
+	{
+		Regex:       regexp.MustCompile(`(?i)(psql|mysql|mariadb|sqlcmd|sqlite3|clickhouse-client|cockroach\s+sql)\b.*\bdrop\s+(database|schema)\b`),
+		Level:       Danger,
+		Description: "Drop an entire database",
+		Category:    "database",
+		Hint:        "Take a backup with pg_dump or mysqldump first",
+	},
+	{
+		Regex:       regexp.MustCompile(`(dropdb|mysqladmin\s+(.*\s)?drop)\s+`),
+		Level:       Danger,
+		Description: "Drop an entire database",
+		Category:    "database",
+		Hint:        "Take a backup with pg_dump or mysqldump first",
 	},
 }
 
 // CautionPatterns contains patterns that should warn but allow execution.
 // These patterns match commands that are potentially risky but may be legitimate.
 var CautionPatterns = []Pattern{
+	// Installers piped to a root shell are listed before the generic sudo
+	// pattern so they are reported under the more specific category.
+	{
+		Regex:       regexp.MustCompile(`(curl|wget)\s+.*\|\s*sudo\s+(-\S+\s+)*(-u\s+\S+\s+)?(ba|z)?sh`),
+		Level:       Caution,
+		Score:       70,
+		Description: "Remote installer piped to a root shell",
+		Category:    "package",
+		Hint:        "Download the installer, read it, then run it",
+	},
 	{
 		Regex:       regexp.MustCompile(`sudo\s+`),
 		Level:       Caution,
 		Description: "Command requires elevated privileges",
 		Category:    "system",
+		Hint:        "Check whether the command really needs root",
 	},
 	{
 		Regex:       regexp.MustCompile(`curl\s+.*\|\s*(ba)?sh`),
 		Level:       Caution,
+		Score:       70,
 		Description: "Piping remote script directly to shell",
 		Category:    "network",
+		Hint:        "Download the script, read it, then run it",
 	},
 	{
 		Regex:       regexp.MustCompile(`wget\s+.*\|\s*(ba)?sh`),
 		Level:       Caution,
+		Score:       70,
 		Description: "Piping remote script directly to shell",
 		Category:    "network",
+		Hint:        "Download the script, read it, then run it",
 	},
 	{
 		Regex:       regexp.MustCompile(`eval\s+`),
 		Level:       Caution,
+		Score:       55,
 		Description: "Dynamic command execution with eval",
 		Category:    "system",
+		Hint:        "Run the command directly instead of through eval",
 	},
 	{
 		Regex:       regexp.MustCompile(`rm\s+-[rRf]+\s+`),
 		Level:       Caution,
+		Score:       60,
 		Description: "Recursive or forced file deletion",
 		Category:    "filesystem",
+		Hint:        "Run ls on the paths first, or drop -f to be asked before each deletion",
 	},
 	{
 		Regex:       regexp.MustCompile(`chmod\s+-[rR]+\s+`),
 		Level:       Caution,
 		Description: "Recursive permission change",
 		Category:    "filesystem",
+		Hint:        "Grant only the permissions needed; directories and files usually differ",
 	},
 	{
 		Regex:       regexp.MustCompile(`chown\s+-[rR]+\s+`),
 		Level:       Caution,
 		Description: "Recursive ownership change",
 		Category:    "filesystem",
+		Hint:        "Check the path before changing ownership recursively",
 	},
 	{
 		Regex:       regexp.MustCompile(`pkill\s+`),
 		Level:       Caution,
 		Description: "Kill processes by pattern",
 		Category:    "system",
+		Hint:        "Preview the matches with pgrep first",
 	},
 	{
 		Regex:       regexp.MustCompile(`killall\s+`),
 		Level:       Caution,
 		Description: "Kill all processes by name",
 		Category:    "system",
+		Hint:        "Kill specific PIDs instead",
+	},
+	{
+		Regex:       regexp.MustCompile(`(python[0-9.]*|perl|ruby|node)\s+-[ce]\s+.*\b(exec|eval)\s*\(`),
+		Level:       Caution,
+		Score:       60,
+		Description: "Interpreter executing dynamically built code",
+		Category:    "obfuscation",
+		Hint:        "Read the code being executed before running it",
+	},
+	{
+		Regex:       regexp.MustCompile(`aws\s+s3\s+rm\s+.*--recursive`),
+		Level:       Caution,
+		Description: "Recursive deletion of S3 objects",
+		Category:    "cloud",
+		Hint:        "Preview the deletion with --dryrun first",
 	},
+	{
+		Regex:       regexp.MustCompile(`aws\s+(ec2\s+terminate-instances|rds\s+delete-db-(instance|cluster)|dynamodb\s+delete-table|cloudformation\s+delete-stack)\b`),
+		Level:       Caution,
+		Description: "Delete AWS resources",
+		Category:    "cloud",
+		Hint:        "Check the resource IDs and the active profile (aws sts get-caller-identity) first",
+	},
+	{
+		Regex:       regexp.MustCompile(`(gcloud|az)\s+(.*\s)?delete\b`),
+		Level:       Caution,
+		Description: "Delete cloud resources",
+		Category:    "cloud",
+		Hint:        "Check the active project or subscription first",
+	},
+	{
+		Regex:       regexp.MustCompile(`kubectl\s+(.*\s)?(delete|drain)\s+`),
+		Level:       Caution,
+		Description: "Delete or evict Kubernetes resources",
+		Category:    "kubernetes",
+		Hint:        "Preview with --dry-run=client and check the current context first",
+	},
+	{
+		Regex:       regexp.MustCompile(`helm\s+(uninstall|delete)\s+`),
+		Level:       Caution,
+		Description: "Uninstall a Helm release",
+		Category:    "kubernetes",
+		Hint:        "Check the release and namespace with helm list first",
+	},
+	{
+		Regex:       regexp.MustCompile(`terraform\s+(destroy\b|apply\s+(.*\s)?-auto-approve)`),
+		Level:       Caution,
+		Description: "Change or destroy infrastructure",
+		Category:    "infrastructure",
+		Hint:        "Run terraform plan first and drop -auto-approve",
+	},
+	{
+		Regex:       regexp.MustCompile(`(?i)(psql|mysql|mariadb|sqlcmd|sqlite3|clickhouse-client|cockroach\s+sql)\b.*\b(drop\s+table|truncate\s+(table\s+)?\w)`),
+		Level:       Caution,
+		Description: "Drop or truncate a database table",
+		Category:    "database",
+		Hint:        "Take a backup first, and run the statement in a transaction where possible",
+	},
+}
+
+// KnownCategory reports whether any built-in pattern or rule uses category.
+func KnownCategory(category string) bool {
+	if category == "git" || category == "package" {
+		return true
+	}
+	for _, patterns := range [][]Pattern{DangerPatterns, CautionPatterns} {
+		for _, p := range patterns {
+			if p.Category == category {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 // ShellWrappers contains patterns for extracting nested commands.
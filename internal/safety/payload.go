@@ -0,0 +1,193 @@
+package safety
+
+import (
+	"strings"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// payload is a command run on behalf of another command, such as the
+// argument of find -exec or xargs.
+type payload struct {
+	// Via names the wrapping tool, e.g. "find -exec".
+	Via string
+	// Cmd is the payload command with {} replaced by the paths it will
+	// operate on, where those are known.
+	Cmd string
+}
+
+// xargsArgOptions are xargs options whose value is a separate word.
+var xargsArgOptions = map[string]bool{
+	"-a": true, "-d": true, "-E": true, "-I": true, "-L": true,
+	"-n": true, "-P": true, "-s": true, "--arg-file": true, "--delimiter": true,
+	"--max-args": true, "--max-procs": true, "--max-lines": true, "--max-chars": true,
+}
+
+// parallelArgOptions are GNU parallel options whose value is a separate word.
+var parallelArgOptions = map[string]bool{
+	"-j": true, "-P": true, "-S": true, "-n": true, "-N": true, "-I": true,
+	"--jobs": true, "--sshlogin": true, "--max-args": true, "--delay": true,
+	"--timeout": true, "--joblog": true, "--results": true,
+}
+
+// commandPayloads extracts the commands executed by find -exec/-execdir/
+// -ok/-okdir/-delete, xargs and parallel within cmd.
+func commandPayloads(cmd string) []payload {
+	var payloads []payload
+	for _, simple := range shellparse.Commands(cmd) {
+		words := stripPrefixes(shellparse.Words(simple))
+		if len(words) == 0 {
+			continue
+		}
+
+		switch words[0] {
+		case "find":
+			payloads = append(payloads, findPayloads(words[1:])...)
+		case "xargs":
+			payloads = append(payloads, xargsPayloads(words[1:])...)
+		case "parallel":
+			payloads = append(payloads, parallelPayloads(words[1:])...)
+		}
+	}
+	return payloads
+}
+
+// stripPrefixes drops leading words that run the rest of the command
+// unchanged, such as sudo (with its options) and nohup.
+func stripPrefixes(words []string) []string {
+	for len(words) > 0 && (words[0] == "sudo" || words[0] == "nice" || words[0] == "nohup" || words[0] == "time") {
+		sudo := words[0] == "sudo"
+		words = words[1:]
+		for sudo && len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			if words[0] == "-u" || words[0] == "-g" {
+				words = words[1:]
+			}
+			words = words[1:]
+		}
+	}
+	return words
+}
+
+// findPayloads returns the -exec style payloads of a find invocation, with
+// {} replaced by each starting path. -delete is treated as rm -rf.
+func findPayloads(args []string) []payload {
+	var roots []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") && args[0] != "(" && args[0] != "!" {
+		roots = append(roots, args[0])
+		args = args[1:]
+	}
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+
+	var payloads []payload
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-exec", "-execdir", "-ok", "-okdir":
+			via := "find " + args[i]
+			j := i + 1
+			for j < len(args) && args[j] != ";" && args[j] != "+" {
+				j++
+			}
+			payloads = append(payloads, expand(via, args[i+1:j], "{}", roots)...)
+			i = j
+		case "-delete":
+			payloads = append(payloads, expand("find -delete", []string{"rm", "-rf", "{}"}, "{}", roots)...)
+		}
+	}
+	return payloads
+}
+
+// xargsPayloads returns the command run by xargs. Input arguments are
+// unknown, so they are represented by the replace string or {}.
+func xargsPayloads(args []string) []payload {
+	replace := ""
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		opt := args[0]
+		args = args[1:]
+		switch {
+		case opt == "-I" && len(args) > 0:
+			replace = args[0]
+			args = args[1:]
+		case strings.HasPrefix(opt, "-I") && len(opt) > 2:
+			replace = opt[2:]
+		case opt == "-i" || opt == "--replace":
+			replace = "{}"
+		case xargsArgOptions[opt] && len(args) > 0:
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	if replace == "" {
+		args = append(args, "{}")
+	}
+	return []payload{{Via: "xargs", Cmd: strings.Join(args, " ")}}
+}
+
+// parallelPayloads returns the commands run by GNU parallel, with {}
+// replaced by each literal argument given after :::.
+func parallelPayloads(args []string) []payload {
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		opt := args[0]
+		args = args[1:]
+		if parallelArgOptions[opt] && len(args) > 0 {
+			args = args[1:]
+		}
+	}
+
+	var command, inputs []string
+	for i, w := range args {
+		if w == ":::" {
+			command = args[:i]
+			inputs = args[i+1:]
+			break
+		}
+		if w == "::::" {
+			command = args[:i]
+			break
+		}
+	}
+	if command == nil {
+		command = args
+	}
+	if len(command) == 0 {
+		return nil
+	}
+
+	if !containsWord(command, "{}") {
+		command = append(command, "{}")
+	}
+	if len(inputs) == 0 {
+		return []payload{{Via: "parallel", Cmd: strings.Join(command, " ")}}
+	}
+	return expand("parallel", command, "{}", inputs)
+}
+
+// expand returns one payload per value, with placeholder replaced by it.
+func expand(via string, words []string, placeholder string, values []string) []payload {
+	if len(words) == 0 {
+		return nil
+	}
+	cmd := strings.Join(words, " ")
+	if !strings.Contains(cmd, placeholder) {
+		return []payload{{Via: via, Cmd: cmd}}
+	}
+
+	payloads := make([]payload, 0, len(values))
+	for _, v := range values {
+		payloads = append(payloads, payload{Via: via, Cmd: strings.ReplaceAll(cmd, placeholder, v)})
+	}
+	return payloads
+}
+
+// containsWord reports whether words contains a word containing s.
+func containsWord(words []string, s string) bool {
+	for _, w := range words {
+		if strings.Contains(w, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package safety
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// readOnlyPrograms maps programs that only read files and print to a check
+// of their arguments: nil when the program never changes anything,
+// otherwise a function reporting whether these arguments keep it that way.
+var readOnlyPrograms = map[string]func(args []string) bool{
+	"ls": nil, "cat": nil, "tac": nil, "head": nil, "tail": nil, "less": nil, "more": nil,
+	"grep": nil, "egrep": nil, "fgrep": nil, "rg": nil, "ag": nil,
+	"wc": nil, "uniq": nil, "cut": nil, "tr": nil, "column": nil, "nl": nil,
+	"rev": nil, "fold": nil, "fmt": nil, "expand": nil, "seq": nil, "jq": nil,
+	"diff": nil, "cmp": nil, "comm": nil, "file": nil, "stat": nil,
+	"du": nil, "df": nil, "free": nil, "uptime": nil, "whoami": nil, "id": nil,
+	"ps": nil, "pgrep": nil, "top": nil, "htop": nil, "lsof": nil, "ss": nil, "netstat": nil,
+	"uname": nil, "pwd": nil, "echo": nil, "printf": nil, "which": nil, "type": nil,
+	"printenv": nil, "basename": nil, "dirname": nil, "realpath": nil, "readlink": nil,
+	"md5sum": nil, "sha1sum": nil, "sha256sum": nil, "sha512sum": nil,
+	"xxd": nil, "hexdump": nil, "od": nil, "strings": nil, "man": nil,
+	"true": nil, "false": nil, "test": nil, "[": nil,
+	"env":        noArgs,
+	"hostname":   noArgs,
+	"date":       withoutFlags("-s", "--set"),
+	"sort":       withoutFlags("-o", "--output"),
+	"tree":       withoutFlags("-o"),
+	"journalctl": withoutFlags("--vacuum", "--rotate", "--flush", "--sync"),
+	"find":       withoutFlags("-delete", "-exec", "-execdir", "-ok", "-okdir", "-fprint", "-fls"),
+	"git":        allOf(readOnlySubcommand(gitGlobalArgOptions, "status", "log", "diff", "show", "blame", "grep", "ls-files", "ls-tree", "rev-parse", "describe", "shortlog", "cat-file"), withoutFlags("--output", "-O", "--open-files-in-pager")),
+	"kubectl":    readOnlySubcommand(nil, "get", "describe", "logs", "top", "explain", "version", "api-resources", "cluster-info"),
+	"docker":     readOnlySubcommand(nil, "ps", "images", "logs", "inspect", "version", "info", "stats"),
+	"systemctl":  readOnlySubcommand(nil, "status", "show", "cat", "list-units", "list-timers", "list-unit-files", "is-active", "is-enabled", "is-failed"),
+}
+
+// noArgs allows a program only when it is given no arguments, for programs
+// such as hostname that print a setting and change it when given one.
+func noArgs(args []string) bool {
+	return len(args) == 0
+}
+
+// withoutFlags allows any arguments except the given flags, which also
+// match with a value attached (-i.bak, --vacuum-size=1G).
+func withoutFlags(flags ...string) func(args []string) bool {
+	return func(args []string) bool {
+		for _, arg := range args {
+			for _, flag := range flags {
+				if strings.HasPrefix(arg, flag) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+}
+
+// allOf allows arguments that every one of checks allows.
+func allOf(checks ...func(args []string) bool) func(args []string) bool {
+	return func(args []string) bool {
+		for _, check := range checks {
+			if !check(args) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// readOnlySubcommand allows the listed subcommands, found after any global
+// options; argOptions are the global options taking a separate value.
+func readOnlySubcommand(argOptions map[string]bool, subcommands ...string) func(args []string) bool {
+	return func(args []string) bool {
+		for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+			if argOptions[args[0]] {
+				args = args[1:]
+			}
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			return false
+		}
+		for _, sub := range subcommands {
+			if args[0] == sub {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// assignmentRegex matches a variable assignment before a command.
+var assignmentRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// harmlessAssignmentRegex matches assignments of the variables that only
+// change how output looks. Any other variable may make a program run or
+// load something else (GIT_PAGER, LD_PRELOAD), so it makes the command
+// mutating.
+var harmlessAssignmentRegex = regexp.MustCompile(`^(LC_[A-Z]+|LANG|TZ|COLUMNS)=`)
+
+// harmlessRedirectRegex matches output redirections that write no file:
+// duplicating or closing a descriptor, or writing to /dev/null.
+var harmlessRedirectRegex = regexp.MustCompile(`^[0-9&]?>>?(&[0-9-]|/dev/null)$`)
+
+// ReadOnly reports whether cmd only reads: every simple command in it runs
+// a program known not to change anything, with arguments that keep it that
+// way, and no output is redirected into a file. Anything it cannot see
+// into, such as command substitution, counts as mutating, as does a
+// command too long to check.
+func ReadOnly(cmd string) bool {
+	if len(cmd) > MaxCommandLength || strings.Contains(cmd, "$(") || strings.Contains(cmd, "`") || strings.Contains(cmd, "<(") || strings.Contains(cmd, ">(") {
+		return false
+	}
+	pipelines := shellparse.Pipelines(cmd)
+	if len(pipelines) == 0 {
+		return false
+	}
+	for _, pipeline := range pipelines {
+		for _, simple := range shellparse.Commands(pipeline.Text) {
+			if !readOnlyCommand(shellparse.Words(simple)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// readOnlyCommand reports whether the words of a simple command only read.
+func readOnlyCommand(words []string) bool {
+	var args []string
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if !strings.Contains(w, ">") {
+			args = append(args, w)
+			continue
+		}
+		// A redirection's target may be the next word.
+		if strings.HasSuffix(w, ">") && i+1 < len(words) {
+			i++
+			w += words[i]
+		}
+		if !harmlessRedirectRegex.MatchString(w) {
+			return false
+		}
+	}
+
+	for len(args) > 0 && assignmentRegex.MatchString(args[0]) {
+		if !harmlessAssignmentRegex.MatchString(args[0]) {
+			return false
+		}
+		args = args[1:]
+	}
+	args = stripPrefixes(args)
+	if len(args) == 0 {
+		return false
+	}
+	check, ok := readOnlyPrograms[path.Base(args[0])]
+	if !ok {
+		return false
+	}
+	return check == nil || check(args[1:])
+}
@@ -0,0 +1,98 @@
+package safety
+
+import "strings"
+
+// escalatedCategories are the categories whose cautionary results become
+// dangerous under WithStrictAsRoot.
+var escalatedCategories = map[string]bool{"filesystem": true, "system": true}
+
+// WithStrictAsRoot reports cautionary filesystem and system commands as
+// dangerous when they run with superuser rights: always when qcmd runs as
+// root, and for commands run through sudo or doas when sudo credentials
+// are cached (see WithSudoProbe).
+func WithStrictAsRoot() Option {
+	return func(c *Checker) {
+		c.strictAsRoot = true
+	}
+}
+
+// WithSudoProbe sets probe to find out whether sudo credentials are
+// cached, when Environment.SudoCached does not say so. It is called only
+// once a command run through sudo or doas would be escalated, since each
+// call may be a failed attempt in the system's auth log; the caller
+// remembers its answer.
+func WithSudoProbe(probe func() bool) Option {
+	return func(c *Checker) {
+		c.sudoProbe = probe
+	}
+}
+
+// Privileged reports whether the environment grants superuser rights
+// without a password prompt.
+func (e Environment) Privileged() bool {
+	return e.Root || e.SudoCached
+}
+
+// escalate raises the score of a cautionary result to the block threshold
+// if WithStrictAsRoot applies to it. For a command run through sudo or
+// doas, what it runs is judged rather than the use of sudo, which is
+// cautionary on its own.
+func (c *Checker) escalate(result CheckResult) CheckResult {
+	if !c.strictAsRoot || c.level(result.Score) != Caution {
+		return result
+	}
+	finding := result
+	payload, elevated := elevatedPayload(result.Segment)
+	if elevated {
+		finding = c.checkSegment(payload)
+		finding.Segment = result.Segment
+	}
+	if c.level(finding.Score) != Caution || !escalatedCategories[finding.Category] {
+		return result
+	}
+	switch {
+	case c.env.Root:
+		finding.Description += " (running as root)"
+	case elevated && c.sudoCached():
+		finding.Description += " (sudo credentials are cached)"
+	default:
+		return result
+	}
+	finding.Score = c.blockThreshold
+	return finding
+}
+
+// sudoCached reports whether sudo runs without a password prompt.
+func (c *Checker) sudoCached() bool {
+	return c.env.SudoCached || c.sudoProbe != nil && c.sudoProbe()
+}
+
+// RunsElevated reports whether cmd runs a command through sudo or doas.
+func RunsElevated(cmd string) bool {
+	for _, word := range strings.Fields(cmd) {
+		if word == "sudo" || word == "doas" {
+			return true
+		}
+	}
+	return false
+}
+
+// elevatedPayload returns the command segment runs through sudo or doas,
+// without their options, and whether it runs one.
+func elevatedPayload(segment string) (string, bool) {
+	words := strings.Fields(segment)
+	for i, word := range words {
+		if word != "sudo" && word != "doas" {
+			continue
+		}
+		rest := words[i+1:]
+		for len(rest) > 0 && strings.HasPrefix(rest[0], "-") {
+			if (rest[0] == "-u" || rest[0] == "-g") && len(rest) > 1 {
+				rest = rest[1:]
+			}
+			rest = rest[1:]
+		}
+		return strings.Join(rest, " "), true
+	}
+	return "", false
+}
@@ -0,0 +1,116 @@
+package sanitize
+
+import (
+	"regexp"
+	"strings"
+)
+
+// placeholderRegex matches the placeholders models leave in commands for
+// values they cannot know: <bucket-name>, {{name}}, YOUR_TOKEN, your-bucket,
+// API_KEY_HERE, upper-case names such as BUCKET_NAME and a few bare words
+// such as FILE or HOST. Redirections such as "< file" and Go templates such
+// as {{.Names}} do not match; variables are left out by isVariable.
+var placeholderRegex = regexp.MustCompile(`<[A-Za-z][A-Za-z0-9_.-]*>` +
+	`|\{\{\s*[A-Za-z_][A-Za-z0-9_-]*\s*\}\}` +
+	`|\b(?:YOUR|MY)_[A-Z0-9_]*[A-Z0-9]\b` +
+	`|\b[Yy]our[-_][A-Za-z0-9][A-Za-z0-9_-]*` +
+	`|\b[A-Z][A-Z0-9]*(?:_[A-Z0-9]+)+\b` +
+	`|\b(?:FILE|FILENAME|DIR|DIRECTORY|HOST|HOSTNAME|USERNAME|PASSWORD|TOKEN|BRANCH|PORT|PID|URL|IMAGE|CONTAINER|BUCKET|REGION|VALUE)\b`)
+
+// variableCommands take the names of variables as arguments, as in
+// unset HTTP_PROXY.
+var variableCommands = map[string]bool{
+	"unset": true, "export": true, "printenv": true, "readonly": true,
+	"declare": true, "typeset": true, "local": true,
+}
+
+// isVariable reports whether the word at command[start:end] names a shell
+// variable rather than standing in for a value: it is expanded ($NAME,
+// ${NAME}), assigned (NAME=value), an attribute (os.O_RDONLY) or an
+// argument of a command in variableCommands. An assigned value, as in
+// export API_KEY=API_KEY_HERE, is not.
+func isVariable(command string, start, end int) bool {
+	if start > 0 && command[start-1] == '=' {
+		return false
+	}
+	if start > 0 && strings.ContainsRune("$.", rune(command[start-1])) {
+		return true
+	}
+	if start > 1 && command[start-2:start] == "${" {
+		return true
+	}
+	if end < len(command) && command[end] == '=' {
+		return true
+	}
+	before := command[:start]
+	if i := strings.LastIndexAny(before, ";&|(\n"); i >= 0 {
+		before = before[i+1:]
+	}
+	fields := strings.Fields(before)
+	return len(fields) > 0 && variableCommands[fields[0]]
+}
+
+// fileNameRegex matches the extension after a word in a file name, as in
+// CHANGELOG_2024.md.
+var fileNameRegex = regexp.MustCompile(`^\.[A-Za-z]`)
+
+// placeholderIndexes returns the start and end of each placeholder in
+// command, in order.
+func placeholderIndexes(command string) [][]int {
+	var locs [][]int
+	for _, loc := range placeholderRegex.FindAllStringIndex(command, -1) {
+		if c := command[loc[0]]; c != '<' && c != '{' && (isVariable(command, loc[0], loc[1]) || fileNameRegex.MatchString(command[loc[1]:])) {
+			continue
+		}
+		locs = append(locs, loc)
+	}
+	return locs
+}
+
+// Placeholders returns the distinct placeholders in command, in the order
+// they first appear, or nil if there are none.
+func Placeholders(command string) []string {
+	var found []string
+	seen := make(map[string]bool)
+	for _, loc := range placeholderIndexes(command) {
+		if p := command[loc[0]:loc[1]]; !seen[p] {
+			seen[p] = true
+			found = append(found, p)
+		}
+	}
+	return found
+}
+
+// FillPlaceholders replaces every occurrence of each placeholder in values
+// with its value. Placeholders without a value, or with an empty one, are
+// left as they are.
+func FillPlaceholders(command string, values map[string]string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range placeholderIndexes(command) {
+		if v := values[command[loc[0]:loc[1]]]; v != "" {
+			b.WriteString(command[last:loc[0]])
+			b.WriteString(v)
+			last = loc[1]
+		}
+	}
+	b.WriteString(command[last:])
+	return b.String()
+}
+
+// emptyQuotesRegex matches a pair of empty quotes, such as the message in
+// git commit -m "".
+var emptyQuotesRegex = regexp.MustCompile(`""|''`)
+
+// EditPoint returns the byte offset in command where the user most likely
+// wants to type next: the start of the first placeholder, or else between
+// the first pair of empty quotes. It returns -1 if there is neither.
+func EditPoint(command string) int {
+	if locs := placeholderIndexes(command); locs != nil {
+		return locs[0][0]
+	}
+	if loc := emptyQuotesRegex.FindStringIndex(command); loc != nil {
+		return loc[0] + 1
+	}
+	return -1
+}
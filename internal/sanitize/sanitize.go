@@ -16,9 +16,11 @@ var inlineBacktickRegex = regexp.MustCompile("^`([^`]+)`$")
 // dollarPrefixRegex matches a leading "$ " on the first line.
 var dollarPrefixRegex = regexp.MustCompile(`^\$\s+`)
 
-// errorSentinelRegex matches the QCMD_ERROR sentinel format.
-// Matches: echo "QCMD_ERROR: message" or echo 'QCMD_ERROR: message'
-var errorSentinelRegex = regexp.MustCompile(`^echo\s+["']QCMD_ERROR:\s*(.+?)["']$`)
+// errorSentinelRegex matches the QCMD_ERROR sentinel format, with or
+// without a reason code.
+// Matches: echo "QCMD_ERROR: message", echo 'QCMD_ERROR: message' or
+// echo "QCMD_ERROR[code=ambiguous]: message"
+var errorSentinelRegex = regexp.MustCompile(`^echo\s+["']QCMD_ERROR(?:\[code=([a-z_]+)\])?:\s*(.+?)["']$`)
 
 // Sanitize cleans LLM output by removing markdown formatting while
 // preserving multi-line command structure.
@@ -95,6 +97,28 @@ func Sanitize(raw string) string {
 	return result
 }
 
+// Snippet cleans LLM output that is a file rather than a command, such as
+// a Dockerfile or YAML. Code fences and surrounding blank lines are
+// removed, but indentation is kept and nothing else is rewritten.
+func Snippet(raw string) string {
+	result := raw
+	if matches := codeFenceRegex.FindStringSubmatch(result); matches != nil {
+		result = matches[1]
+	}
+
+	lines := strings.Split(result, "\n")
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
 // trimLeadingTrailingWhitespace removes leading whitespace from the first line
 // and trailing whitespace from the last line, but preserves internal structure.
 func trimLeadingTrailingWhitespace(s string) string {
@@ -117,20 +141,62 @@ func trimLeadingTrailingWhitespace(s string) string {
 	return strings.Join(lines, "\n")
 }
 
-// CheckErrorSentinel checks if the command is an LLM error response.
-// Returns true if the command matches the error sentinel format:
+// Reason codes the prompts ask models to give in the error sentinel.
+// Models may send others, which callers treat like a sentinel without one.
+const (
+	// CodeAmbiguous means a detail is missing; the message asks for it.
+	CodeAmbiguous = "ambiguous"
+	// CodeImpossible means the request cannot be done as asked.
+	CodeImpossible = "impossible"
+)
+
+// Sentinel is an error the LLM reported instead of answering.
+type Sentinel struct {
+	// Code is the reason code, empty for the plain form.
+	Code    string
+	Message string
+}
+
+// ParseErrorSentinel checks if the command is an LLM error response in
+// either sentinel format:
 //
 //	echo "QCMD_ERROR: <message>"
+//	echo "QCMD_ERROR[code=<code>]: <message>"
 //
-// Returns the error message if found, empty string otherwise.
-func CheckErrorSentinel(cmd string) (bool, string) {
+// Returns the sentinel and true if found, false otherwise.
+func ParseErrorSentinel(cmd string) (Sentinel, bool) {
 	// Trim the command for matching
 	trimmed := strings.TrimSpace(cmd)
 
 	// Check against the error sentinel regex
 	if matches := errorSentinelRegex.FindStringSubmatch(trimmed); matches != nil {
-		return true, strings.TrimSpace(matches[1])
+		return Sentinel{Code: matches[1], Message: strings.TrimSpace(matches[2])}, true
 	}
 
-	return false, ""
+	return Sentinel{}, false
+}
+
+// CheckErrorSentinel checks if the command is an LLM error response, in
+// either format ParseErrorSentinel accepts.
+// Returns the error message if found, empty string otherwise.
+func CheckErrorSentinel(cmd string) (bool, string) {
+	sentinel, ok := ParseErrorSentinel(cmd)
+	return ok, sentinel.Message
+}
+
+// refusalRegex matches answers that open like a refusal written in prose,
+// such as "I can't help with that." or "Sorry, but I won't...", instead of
+// a command or the error sentinel. No shell command starts this way.
+var refusalRegex = regexp.MustCompile(`(?i)^(i\s+(can'?t|cannot|can\s+not|won'?t|will\s+not|am\s+not\s+able|am\s+unable|am\s+sorry|apologi[sz]e|must\s+decline)|i'm\s+(not\s+able|unable|sorry|afraid)|sorry\b|unfortunately,?\s+i\b|as\s+an\s+ai\b)`)
+
+// CheckRefusal checks if the command is a refusal written in prose rather
+// than a command. Returns true and the refusal's first line if so, false
+// and an empty string otherwise.
+func CheckRefusal(cmd string) (bool, string) {
+	trimmed := strings.TrimSpace(strings.NewReplacer("’", "'", "‘", "'").Replace(cmd))
+	if !refusalRegex.MatchString(trimmed) {
+		return false, ""
+	}
+	line, _, _ := strings.Cut(trimmed, "\n")
+	return true, strings.TrimSpace(line)
 }
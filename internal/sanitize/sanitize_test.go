@@ -1,6 +1,7 @@
 package sanitize
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -462,6 +463,94 @@ func TestSanitizeCodeFenceEdgeCases(t *testing.T) {
 	}
 }
 
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"plain", "FROM alpine\nRUN apk add curl", "FROM alpine\nRUN apk add curl"},
+		{"fenced yaml keeps indentation", "```yaml\nservices:\n  web:\n    image: nginx  \n```\n", "services:\n  web:\n    image: nginx"},
+		{"indented first line", "\n\n  web:\n    image: nginx\n\n", "  web:\n    image: nginx"},
+		{"dollar kept", "$ docker ps", "$ docker ps"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Snippet(tt.raw); got != tt.want {
+				t.Errorf("Snippet(%q) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceholders(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+	}{
+		{"angle brackets", "aws s3 ls s3://<bucket-name>/", []string{"<bucket-name>"}},
+		{"your prefix", `curl -H "Authorization: Bearer YOUR_TOKEN" https://api.example.com`, []string{"YOUR_TOKEN"}},
+		{"lowercase your", "gcloud config set project your-project-id", []string{"your-project-id"}},
+		{"here suffix", "export API_KEY=API_KEY_HERE", []string{"API_KEY_HERE"}},
+		{"braces", "ssh {{user}}@{{ host }}", []string{"{{user}}", "{{ host }}"}},
+		{"distinct in order", "cp <src> <dst> && ls <dst>", []string{"<src>", "<dst>"}},
+		{"redirections", "sort < input.txt > output.txt 2>&1", nil},
+		{"heredoc", "cat <<EOF > notes.txt\nhello\nEOF", nil},
+		{"go template", "docker ps --format '{{.Names}}'", nil},
+		{"variable", "echo $HOME ${USER}", nil},
+		{"your inside word", "ls ~/yourfiles", nil},
+		{"upper-case name", "gsutil cp notes.txt gs://BUCKET_NAME/", []string{"BUCKET_NAME"}},
+		{"bare word", "scp notes.txt USERNAME@HOST:", []string{"USERNAME", "HOST"}},
+		{"assignment", "AWS_PROFILE=dev aws s3 ls && export API_KEY=API_KEY_HERE", []string{"API_KEY_HERE"}},
+		{"braced variable", "echo ${AWS_REGION} $MY_DIR", nil},
+		{"variable command", "unset HTTP_PROXY HTTPS_PROXY", nil},
+		{"attribute", `python3 -c "import os; print(os.O_RDONLY)"`, nil},
+		{"upper-case file name", "cat CHANGELOG_2024.md", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Placeholders(tt.command)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Placeholders(%q) = %q, want %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditPoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    int
+	}{
+		{"placeholder", "aws s3 ls s3://<bucket-name>/", 15},
+		{"placeholder over quotes", `git commit -m "" --author <name>`, 26},
+		{"empty double quotes", `git commit -m ""`, 15},
+		{"empty single quotes", `grep -r '' .`, 9},
+		{"nothing to edit", "ls -la", -1},
+		{"filled quotes", `echo "hi"`, -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EditPoint(tt.command); got != tt.want {
+				t.Errorf("EditPoint(%q) = %d, want %d", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFillPlaceholders(t *testing.T) {
+	command := "cp <src> <dst> && ls <dst> YOUR_DIR $HOST HOST"
+	values := map[string]string{"<src>": "a.txt", "<dst>": "b.txt", "YOUR_DIR": "", "HOST": "web1"}
+	want := "cp a.txt b.txt && ls b.txt YOUR_DIR $HOST web1"
+	if got := FillPlaceholders(command, values); got != want {
+		t.Errorf("FillPlaceholders() = %q, want %q", got, want)
+	}
+}
+
 // BenchmarkSanitize benchmarks the sanitize function.
 func BenchmarkSanitize(b *testing.B) {
 	inputs := []string{
@@ -480,6 +569,35 @@ func BenchmarkSanitize(b *testing.B) {
 	}
 }
 
+func TestParseErrorSentinel(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  Sentinel
+		ok    bool
+	}{
+		{"plain", `echo "QCMD_ERROR: unclear request"`, Sentinel{Message: "unclear request"}, true},
+		{"ambiguous", `echo "QCMD_ERROR[code=ambiguous]: Which directory?"`, Sentinel{Code: CodeAmbiguous, Message: "Which directory?"}, true},
+		{"impossible single quotes", `echo 'QCMD_ERROR[code=impossible]: no such tool on macOS'`, Sentinel{Code: CodeImpossible, Message: "no such tool on macOS"}, true},
+		{"unknown code", `echo "QCMD_ERROR[code=other_reason]: hmm"`, Sentinel{Code: "other_reason", Message: "hmm"}, true},
+		{"malformed code", `echo "QCMD_ERROR[ambiguous]: Which directory?"`, Sentinel{}, false},
+		{"empty code", `echo "QCMD_ERROR[code=]: Which directory?"`, Sentinel{}, false},
+		{"command", `ls -la`, Sentinel{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseErrorSentinel(tt.input)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ParseErrorSentinel(%q) = %+v, %v; want %+v, %v", tt.input, got, ok, tt.want, tt.ok)
+			}
+			if isErr, msg := CheckErrorSentinel(tt.input); isErr != tt.ok || msg != tt.want.Message {
+				t.Errorf("CheckErrorSentinel(%q) = %v, %q; want %v, %q", tt.input, isErr, msg, tt.ok, tt.want.Message)
+			}
+		})
+	}
+}
+
 // BenchmarkCheckErrorSentinel benchmarks the error sentinel check.
 func BenchmarkCheckErrorSentinel(b *testing.B) {
 	inputs := []string{
@@ -495,3 +613,35 @@ func BenchmarkCheckErrorSentinel(b *testing.B) {
 		}
 	}
 }
+
+func TestCheckRefusal(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		refused bool
+		message string
+	}{
+		{"cannot", "I cannot help with that.", true, "I cannot help with that."},
+		{"contraction", "I can't assist with hacking into accounts.", true, "I can't assist with hacking into accounts."},
+		{"curly apostrophe", "I’m sorry, but I won’t do that.", true, "I'm sorry, but I won't do that."},
+		{"sorry", "Sorry, that request could damage your system.\nTry something else.", true, "Sorry, that request could damage your system."},
+		{"unfortunately", "Unfortunately, I am unable to generate that command.", true, "Unfortunately, I am unable to generate that command."},
+		{"as an ai", "As an AI model, I must decline.", true, "As an AI model, I must decline."},
+		{"lowercase", "i will not generate that", true, "i will not generate that"},
+		{"command", "ls -la", false, ""},
+		{"sentinel", `echo "QCMD_ERROR: I cannot do that"`, false, ""},
+		{"echo of refusal", `echo "I can't"`, false, ""},
+		{"word starting with sorry", "sorryctl --list", false, ""},
+		{"ifconfig", "ifconfig -a", false, ""},
+		{"empty", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refused, message := CheckRefusal(tt.input)
+			if refused != tt.refused || message != tt.message {
+				t.Errorf("CheckRefusal(%q) = %v, %q; want %v, %q", tt.input, refused, message, tt.refused, tt.message)
+			}
+		})
+	}
+}
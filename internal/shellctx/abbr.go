@@ -0,0 +1,231 @@
+package shellctx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// Abbreviation is a shell abbreviation: a word that the interactive shell
+// replaces with Expansion as it is typed (zsh-abbr, fish abbr).
+type Abbreviation struct {
+	Name      string
+	Expansion string
+}
+
+// LoadAbbreviations reads the user's abbreviations for shell ("zsh" or
+// "fish"), sorted by name. zsh abbreviations come from zsh-abbr's
+// user-abbreviations file; fish abbreviations from config.fish, conf.d and
+// fish_variables. Other shells have none. Missing files are not an error.
+func LoadAbbreviations(shell string) ([]Abbreviation, error) {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("finding home directory: %w", err)
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+
+	var files []string
+	var parse func(line string) (Abbreviation, bool)
+	switch shell {
+	case "zsh":
+		file := os.Getenv("ABBR_USER_ABBREVIATIONS_FILE")
+		if file == "" {
+			file = filepath.Join(configDir, "zsh-abbr", "user-abbreviations")
+		}
+		files = []string{file}
+		parse = parseZshAbbr
+	case "fish":
+		fishDir := filepath.Join(configDir, "fish")
+		confd, _ := filepath.Glob(filepath.Join(fishDir, "conf.d", "*.fish"))
+		files = append([]string{filepath.Join(fishDir, "fish_variables"), filepath.Join(fishDir, "config.fish")}, confd...)
+		parse = parseFishAbbr
+	default:
+		return nil, nil
+	}
+
+	// Later definitions win, as they would in the shell.
+	byName := make(map[string]string)
+	for _, file := range files {
+		if err := readAbbreviations(file, parse, byName); err != nil {
+			return nil, err
+		}
+	}
+
+	abbrs := make([]Abbreviation, 0, len(byName))
+	for name, expansion := range byName {
+		abbrs = append(abbrs, Abbreviation{Name: name, Expansion: expansion})
+	}
+	sort.Slice(abbrs, func(i, j int) bool { return abbrs[i].Name < abbrs[j].Name })
+	return abbrs, nil
+}
+
+// readAbbreviations parses each line of file into byName.
+func readAbbreviations(file string, parse func(string) (Abbreviation, bool), byName map[string]string) error {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading abbreviations: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if abbr, ok := parse(strings.TrimSpace(scanner.Text())); ok {
+			byName[abbr.Name] = abbr.Expansion
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading abbreviations from %s: %w", file, err)
+	}
+	return nil
+}
+
+// parseZshAbbr parses a zsh-abbr definition such as
+// abbr "gs"="git status" or abbr -g G="| grep".
+func parseZshAbbr(line string) (Abbreviation, bool) {
+	words := shellparse.Words(line)
+	if len(words) < 2 || words[0] != "abbr" {
+		return Abbreviation{}, false
+	}
+	for _, w := range words[1:] {
+		if strings.HasPrefix(w, "-") {
+			continue
+		}
+		name, expansion, ok := strings.Cut(w, "=")
+		if !ok || name == "" {
+			return Abbreviation{}, false
+		}
+		return Abbreviation{Name: name, Expansion: expansion}, true
+	}
+	return Abbreviation{}, false
+}
+
+// parseFishAbbr parses a fish abbreviation, either a command such as
+// abbr -a gs git status or a universal variable from fish_variables such
+// as SETUVAR _fish_abbr_gs:git\x20status (fish 3.5 and earlier).
+func parseFishAbbr(line string) (Abbreviation, bool) {
+	if rest, ok := strings.CutPrefix(line, "SETUVAR _fish_abbr_"); ok {
+		name, value, ok := strings.Cut(rest, ":")
+		if !ok || name == "" {
+			return Abbreviation{}, false
+		}
+		return Abbreviation{Name: unescapeFishVariable(name), Expansion: unescapeFishVariable(value)}, true
+	}
+
+	words := shellparse.Words(line)
+	if len(words) < 3 || words[0] != "abbr" {
+		return Abbreviation{}, false
+	}
+	var args []string
+	for i := 1; i < len(words); i++ {
+		switch w := words[i]; {
+		case w == "-a" || w == "--add" || w == "-g" || w == "--global" || w == "-U" || w == "--universal":
+		case w == "-p" || w == "--position":
+			i++
+		case strings.HasPrefix(w, "--position=") || strings.HasPrefix(w, "--set-cursor"):
+		case strings.HasPrefix(w, "-") && len(args) == 0:
+			// --erase, --function, --regex and friends: nothing to record.
+			return Abbreviation{}, false
+		default:
+			args = append(args, w)
+		}
+	}
+	if len(args) < 2 {
+		return Abbreviation{}, false
+	}
+	return Abbreviation{Name: args[0], Expansion: strings.Join(args[1:], " ")}, true
+}
+
+// unescapeFishVariable decodes the \xHH escapes fish uses in fish_variables.
+func unescapeFishVariable(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// AbbreviationsSection returns a low-priority context section listing
+// abbrs so the model can reuse them. Returns false if there are none.
+func AbbreviationsSection(abbrs []Abbreviation) (backend.ContextSection, bool) {
+	if len(abbrs) == 0 {
+		return backend.ContextSection{}, false
+	}
+	var b strings.Builder
+	b.WriteString("These words expand when typed interactively:\n")
+	for _, a := range abbrs {
+		fmt.Fprintf(&b, "%s = %s\n", a.Name, a.Expansion)
+	}
+	return backend.ContextSection{
+		Name:     "Shell abbreviations",
+		Content:  strings.TrimRight(b.String(), "\n"),
+		Priority: backend.PriorityLow,
+	}, true
+}
+
+// Collision is a command that runs an abbreviation's name as a command
+// name, where the shell would expand the abbreviation as it is typed.
+type Collision struct {
+	Abbreviation
+	// Command is the simple command as generated.
+	Command string
+	// Expanded is Command with the abbreviation expanded.
+	Expanded string
+}
+
+// AbbreviationCollisions returns the simple commands in command that start
+// with an abbreviation expanding to something else, e.g. a generated
+// "gs input.pdf" when gs is an abbreviation for "git status".
+func AbbreviationCollisions(command string, abbrs []Abbreviation) []Collision {
+	byName := make(map[string]string, len(abbrs))
+	for _, a := range abbrs {
+		byName[a.Name] = a.Expansion
+	}
+	return expandCommandNames(command, byName)
+}
+
+// expandCommandNames finds the simple commands in command whose command
+// name is a key of byName, and expands that name.
+func expandCommandNames(command string, byName map[string]string) []Collision {
+	var expansions []Collision
+	for _, pipeline := range shellparse.Pipelines(command) {
+		for _, cmd := range shellparse.Commands(pipeline.Text) {
+			cmd = strings.TrimSpace(cmd)
+			words := shellparse.Words(cmd)
+			if len(words) == 0 {
+				continue
+			}
+			// A quoted or escaped name does not expand.
+			name := words[0]
+			value, ok := byName[name]
+			if !ok || value == name || !strings.HasPrefix(cmd, name) {
+				continue
+			}
+			expansions = append(expansions, Collision{
+				Abbreviation: Abbreviation{Name: name, Expansion: value},
+				Command:      cmd,
+				Expanded:     value + strings.TrimPrefix(cmd, name),
+			})
+		}
+	}
+	return expansions
+}
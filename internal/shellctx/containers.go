@@ -0,0 +1,106 @@
+package shellctx
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+)
+
+// containerProbeTimeout bounds each kubectl or docker call, so an
+// unreachable cluster or daemon does not hold up the request.
+const containerProbeTimeout = 2 * time.Second
+
+// ContainersTimeout is how long ContainersSection is worth waiting for
+// when gathering context, since it runs external tools.
+const ContainersTimeout = containerProbeTimeout
+
+// maxResourceNameLength caps the length of each listed name.
+const maxResourceNameLength = 64
+
+// lookPath and commandOutput can be overridden for testing.
+var (
+	lookPath      = exec.LookPath
+	commandOutput = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+		return exec.CommandContext(ctx, name, args...).Output()
+	}
+)
+
+// ContainersSection returns a low-priority context section describing the
+// current Kubernetes context and namespace with its pods, and the running
+// docker containers, when kubectl or docker is installed. At most limit
+// names of each kind are listed and credentials are redacted. Returns false
+// if neither tool reports anything.
+func ContainersSection(limit int) (backend.ContextSection, bool) {
+	var b strings.Builder
+
+	if _, err := lookPath("kubectl"); err == nil {
+		if kubeContext := probe("kubectl", "config", "current-context"); kubeContext != "" {
+			namespace := probe("kubectl", "config", "view", "--minify", "-o", "jsonpath={..namespace}")
+			if namespace == "" {
+				namespace = "default"
+			}
+			fmt.Fprintf(&b, "Kubernetes context: %s\n", capName(kubeContext))
+			fmt.Fprintf(&b, "Kubernetes namespace: %s\n", capName(namespace))
+			if pods := probe("kubectl", "get", "pods", "-o", "name"); pods != "" {
+				writeNames(&b, "Pods", strings.ReplaceAll(pods, "pod/", ""), limit)
+			}
+		}
+	}
+
+	if _, err := lookPath("docker"); err == nil {
+		if names := probe("docker", "ps", "--format", "{{.Names}}"); names != "" {
+			writeNames(&b, "Running docker containers", names, limit)
+		}
+	}
+
+	if b.Len() == 0 {
+		return backend.ContextSection{}, false
+	}
+	return backend.ContextSection{
+		Name:     "Containers",
+		Content:  RedactSecrets(strings.TrimRight(b.String(), "\n")),
+		Priority: backend.PriorityLow,
+	}, true
+}
+
+// probe runs a command and returns its trimmed output, or "" on failure.
+func probe(name string, args ...string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), containerProbeTimeout)
+	defer cancel()
+	out, err := commandOutput(ctx, name, args...)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// writeNames writes a comma-separated list of the names (one per line in
+// out), at most limit of them.
+func writeNames(b *strings.Builder, label, out string, limit int) {
+	names := strings.Fields(out)
+	more := 0
+	if limit > 0 && len(names) > limit {
+		more = len(names) - limit
+		names = names[:limit]
+	}
+	for i, name := range names {
+		names[i] = capName(name)
+	}
+	fmt.Fprintf(b, "%s: %s", label, strings.Join(names, ", "))
+	if more > 0 {
+		fmt.Fprintf(b, " ... and %d more", more)
+	}
+	b.WriteString("\n")
+}
+
+// capName truncates name to maxResourceNameLength bytes.
+func capName(name string) string {
+	if len(name) > maxResourceNameLength {
+		return name[:maxResourceNameLength] + "..."
+	}
+	return name
+}
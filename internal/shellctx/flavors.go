@@ -0,0 +1,120 @@
+package shellctx
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+)
+
+// flavorProbeTimeout bounds each --version call. The calls run
+// concurrently, so it also bounds ToolFlavorsSection.
+const flavorProbeTimeout = 500 * time.Millisecond
+
+// ToolFlavorsTimeout is how long ToolFlavorsSection is worth waiting for
+// when gathering context, since it runs external tools.
+const ToolFlavorsTimeout = flavorProbeTimeout
+
+// flavorTools are the tools whose options differ most between the GNU and
+// BSD versions.
+var flavorTools = []string{"sed", "grep", "find", "xargs", "date", "stat", "ls", "readlink", "awk", "tar"}
+
+// Tool flavors reported by toolFlavor.
+const (
+	FlavorGNU     = "GNU"
+	FlavorBSD     = "BSD"
+	FlavorBusyBox = "BusyBox"
+)
+
+// ToolFlavorsSection returns a context section saying which of the tools
+// whose options differ between GNU and BSD are which, on systems that ship
+// BSD tools (goos darwin or a BSD). GNU versions installed alongside with
+// a g prefix, as Homebrew does, are mentioned. Probes are looked up in
+// cache first. Returns false on other systems or if none of the tools is
+// installed.
+func ToolFlavorsSection(goos string, cache *ProbeCache) (backend.ContextSection, bool) {
+	switch goos {
+	case "darwin", "freebsd", "openbsd", "netbsd", "dragonfly":
+	default:
+		return backend.ContextSection{}, false
+	}
+
+	descriptions := make([]string, len(flavorTools))
+	var wg sync.WaitGroup
+	for i, tool := range flavorTools {
+		wg.Add(1)
+		go func(i int, tool string) {
+			defer wg.Done()
+			descriptions[i] = describeFlavor(tool, cache)
+		}(i, tool)
+	}
+	wg.Wait()
+
+	var parts []string
+	bsd := false
+	for _, d := range descriptions {
+		if d != "" {
+			parts = append(parts, d)
+			bsd = bsd || strings.Contains(d, " "+FlavorBSD)
+		}
+	}
+	if len(parts) == 0 {
+		return backend.ContextSection{}, false
+	}
+	content := strings.Join(parts, ", ")
+	if bsd {
+		content += "\nUse options the BSD versions support, e.g. sed -i '' (not sed -i), unless using the GNU versions by their g names."
+	}
+	return backend.ContextSection{
+		Name:     "Tool versions",
+		Content:  content,
+		Priority: backend.PriorityNormal,
+	}, true
+}
+
+// describeFlavor returns "tool FLAVOR", noting an installed GNU version
+// with a g prefix, or "" if tool is not installed.
+func describeFlavor(tool string, cache *ProbeCache) string {
+	path, err := lookPath(tool)
+	if err != nil {
+		return ""
+	}
+	flavor := cache.probe("flavor", path, func() (string, bool) { return probeFlavor(path) })
+	description := tool + " " + flavor
+	if flavor != FlavorGNU {
+		if _, err := lookPath("g" + tool); err == nil {
+			description += fmt.Sprintf(" (GNU: g%s)", tool)
+		}
+	}
+	return description
+}
+
+// probeFlavor runs the tool at path with --version and classifies it. It
+// reports false if the tool timed out.
+func probeFlavor(path string) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), flavorProbeTimeout)
+	defer cancel()
+	out, err := combinedOutput(ctx, nil, path, "--version")
+	return toolFlavor(string(out), err == nil), ctx.Err() == nil
+}
+
+// toolFlavor classifies a tool by what it printed for --version and
+// whether that succeeded. On systems with BSD tools, a tool that does not
+// know --version is a BSD one.
+func toolFlavor(version string, ok bool) string {
+	lower := strings.ToLower(version)
+	switch {
+	case strings.Contains(lower, "busybox"):
+		return FlavorBusyBox
+	case strings.Contains(lower, "bsd"):
+		// Before GNU: "grep (BSD grep, GNU compatible)" is BSD grep.
+		return FlavorBSD
+	case ok && strings.Contains(version, "GNU"):
+		return FlavorGNU
+	default:
+		return FlavorBSD
+	}
+}
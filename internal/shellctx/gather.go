@@ -0,0 +1,81 @@
+package shellctx
+
+import (
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+)
+
+// Source is an optional source of prompt context, such as the directory
+// listing.
+type Source struct {
+	// Name identifies the source in diagnostics.
+	Name string
+	// Timeout overrides the default time to wait for the source.
+	Timeout time.Duration
+	// Gather returns the source's section, or false if it has none.
+	Gather func() (backend.ContextSection, bool)
+}
+
+// sourceResult is what a source's Gather returned.
+type sourceResult struct {
+	section backend.ContextSection
+	ok      bool
+}
+
+// GatherSections runs sources concurrently and returns the sections they
+// produced, in source order, so the prompt does not depend on which source
+// finished first. Sources still running after their timeout (timeout
+// unless they set their own) are abandoned; their names are returned in
+// late. A timeout of 0 waits for the source to finish. The wait is bounded
+// by the longest timeout, not their sum.
+func GatherSections(sources []Source, timeout time.Duration) (sections []backend.ContextSection, late []string) {
+	start := time.Now()
+	results := make([]chan sourceResult, len(sources))
+	for i, src := range sources {
+		// Buffered so an abandoned source can still finish and exit.
+		results[i] = make(chan sourceResult, 1)
+		go func(gather func() (backend.ContextSection, bool), done chan<- sourceResult) {
+			section, ok := gather()
+			done <- sourceResult{section, ok}
+		}(src.Gather, results[i])
+	}
+
+	for i, src := range sources {
+		wait := timeout
+		if src.Timeout > 0 {
+			wait = src.Timeout
+		}
+		if wait <= 0 {
+			if r := <-results[i]; r.ok {
+				sections = append(sections, r.section)
+			}
+			continue
+		}
+		r, ok := receiveBy(results[i], start.Add(wait))
+		if !ok {
+			late = append(late, src.Name)
+		} else if r.ok {
+			sections = append(sections, r.section)
+		}
+	}
+	return sections, late
+}
+
+// receiveBy receives from ch, waiting until deadline at most. A result that
+// is already there is taken even if the deadline has passed.
+func receiveBy(ch <-chan sourceResult, deadline time.Time) (sourceResult, bool) {
+	select {
+	case r := <-ch:
+		return r, true
+	default:
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case r := <-ch:
+		return r, true
+	case <-timer.C:
+		return sourceResult{}, false
+	}
+}
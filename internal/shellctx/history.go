@@ -0,0 +1,141 @@
+package shellctx
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryCommand is a command from the shell's history file. Time is zero
+// when the file does not record it.
+type HistoryCommand struct {
+	Command string
+	Time    time.Time
+}
+
+// LoadShellHistory reads the history file of shell ("zsh", "bash" or
+// "fish"), oldest first. $HISTFILE, when exported, names the file for zsh
+// and bash. Other shells have none, and a missing file is not an error.
+func LoadShellHistory(shell string) ([]HistoryCommand, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("finding home directory: %w", err)
+	}
+
+	var path string
+	var parse func(lines []string) []HistoryCommand
+	switch shell {
+	case "zsh":
+		path, parse = filepath.Join(home, ".zsh_history"), parseZshHistory
+	case "bash":
+		path, parse = filepath.Join(home, ".bash_history"), parseBashHistory
+	case "fish":
+		dataDir := os.Getenv("XDG_DATA_HOME")
+		if dataDir == "" {
+			dataDir = filepath.Join(home, ".local", "share")
+		}
+		return readHistory(filepath.Join(dataDir, "fish", "fish_history"), parseFishHistory)
+	default:
+		return nil, nil
+	}
+	if file := os.Getenv("HISTFILE"); file != "" {
+		path = file
+	}
+	return readHistory(path, parse)
+}
+
+// readHistory reads the history file at path and parses its lines.
+func readHistory(path string, parse func(lines []string) []HistoryCommand) ([]HistoryCommand, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading shell history: %w", err)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading shell history: %w", err)
+	}
+	return parse(lines), nil
+}
+
+// parseZshHistory parses zsh history, plain or in the extended format
+// ": 1700000000:0;command". Lines ending in a backslash continue the
+// command.
+func parseZshHistory(lines []string) []HistoryCommand {
+	var cmds []HistoryCommand
+	for i := 0; i < len(lines); i++ {
+		var hc HistoryCommand
+		line := lines[i]
+		if rest, ok := strings.CutPrefix(line, ": "); ok {
+			if meta, cmd, ok := strings.Cut(rest, ";"); ok {
+				stamp, _, _ := strings.Cut(meta, ":")
+				if sec, err := strconv.ParseInt(stamp, 10, 64); err == nil {
+					hc.Time = time.Unix(sec, 0)
+					line = cmd
+				}
+			}
+		}
+		for strings.HasSuffix(line, `\`) && i+1 < len(lines) {
+			i++
+			line = strings.TrimSuffix(line, `\`) + "\n" + lines[i]
+		}
+		if hc.Command = strings.TrimSpace(line); hc.Command != "" {
+			cmds = append(cmds, hc)
+		}
+	}
+	return cmds
+}
+
+// parseBashHistory parses bash history, where a "#1700000000" line before
+// a command records when it ran.
+func parseBashHistory(lines []string) []HistoryCommand {
+	var cmds []HistoryCommand
+	var stamp time.Time
+	for _, line := range lines {
+		if rest, ok := strings.CutPrefix(line, "#"); ok {
+			if sec, err := strconv.ParseInt(rest, 10, 64); err == nil {
+				stamp = time.Unix(sec, 0)
+				continue
+			}
+		}
+		if cmd := strings.TrimSpace(line); cmd != "" {
+			cmds = append(cmds, HistoryCommand{Command: cmd, Time: stamp})
+		}
+		stamp = time.Time{}
+	}
+	return cmds
+}
+
+// parseFishHistory parses fish history: "- cmd: COMMAND" entries followed
+// by "  when: 1700000000".
+func parseFishHistory(lines []string) []HistoryCommand {
+	var cmds []HistoryCommand
+	for _, line := range lines {
+		if cmd, ok := strings.CutPrefix(line, "- cmd: "); ok {
+			// fish escapes backslashes and newlines in the command.
+			cmd = strings.NewReplacer(`\\`, `\`, `\n`, "\n").Replace(cmd)
+			cmds = append(cmds, HistoryCommand{Command: cmd})
+			continue
+		}
+		if when, ok := strings.CutPrefix(strings.TrimSpace(line), "when: "); ok && len(cmds) > 0 {
+			if sec, err := strconv.ParseInt(when, 10, 64); err == nil {
+				cmds[len(cmds)-1].Time = time.Unix(sec, 0)
+			}
+		}
+	}
+	return cmds
+}
@@ -0,0 +1,85 @@
+package shellctx
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// ProbeCacheFileName is the name of the file in the cache directory
+// holding what tools said when probed.
+const ProbeCacheFileName = "tool-probes.json"
+
+// probeEntry is what probing a binary gave, with the binary's size and
+// modification time then. A binary that changed is probed again.
+type probeEntry struct {
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+	Result  string    `json:"result"`
+}
+
+// ProbeCache remembers the results of probing installed tools, such as
+// their --version output, so they are not run for every command. Results
+// are kept until the binary is replaced, e.g. by an upgrade. A nil
+// *ProbeCache probes every time. It is safe for concurrent use.
+type ProbeCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]probeEntry
+}
+
+// LoadProbeCache returns the cache stored at path. A missing or invalid
+// file gives an empty cache; path "" gives one that is not saved.
+func LoadProbeCache(path string) *ProbeCache {
+	c := &ProbeCache{path: path, entries: make(map[string]probeEntry)}
+	if path == "" {
+		return c
+	}
+	if data, err := os.ReadFile(path); err == nil {
+		var entries map[string]probeEntry
+		if json.Unmarshal(data, &entries) == nil && entries != nil {
+			c.entries = entries
+		}
+	}
+	return c
+}
+
+// probe returns the result of probe for the binary at path, which kind
+// names among the probes of a binary. probe's result is cached unless it
+// reports it is incomplete, as when the tool timed out.
+func (c *ProbeCache) probe(kind, path string, probe func() (string, bool)) string {
+	if c == nil {
+		result, _ := probe()
+		return result
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		result, _ := probe()
+		return result
+	}
+	key := kind + " " + path
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && e.Size == info.Size() && e.ModTime.Equal(info.ModTime()) {
+		return e.Result
+	}
+
+	result, complete := probe()
+	if !complete {
+		return result
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = probeEntry{ModTime: info.ModTime(), Size: info.Size(), Result: result}
+	if c.path != "" {
+		if data, err := json.Marshal(c.entries); err == nil {
+			filelock.WriteFile(c.path, data, 0600)
+		}
+	}
+	return result
+}
@@ -0,0 +1,59 @@
+package shellctx
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/user/qcmd/internal/backend"
+)
+
+// osReleasePath is read for the distribution name; overridden in tests.
+var osReleasePath = "/etc/os-release"
+
+// InSSHSession reports whether qcmd runs in a shell reached over SSH.
+func InSSHSession() bool {
+	return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_CLIENT") != "" || os.Getenv("SSH_TTY") != ""
+}
+
+// RemoteSection returns a context section naming the host and operating
+// system when qcmd runs over SSH, so commands suit the remote machine
+// rather than the user's workstation. Returns false outside SSH sessions.
+func RemoteSection() (backend.ContextSection, bool) {
+	if !InSSHSession() {
+		return backend.ContextSection{}, false
+	}
+
+	var b strings.Builder
+	b.WriteString("Connected over SSH to a remote host.\n")
+	if host, err := os.Hostname(); err == nil {
+		fmt.Fprintf(&b, "Hostname: %s\n", host)
+	}
+	if name := osPrettyName(); name != "" {
+		fmt.Fprintf(&b, "Operating system: %s\n", name)
+	}
+	return backend.ContextSection{
+		Name:     "Remote session",
+		Content:  strings.TrimRight(b.String(), "\n"),
+		Priority: backend.PriorityNormal,
+	}, true
+}
+
+// osPrettyName returns PRETTY_NAME from os-release, e.g. "Ubuntu 24.04 LTS",
+// or "" if it is unavailable.
+func osPrettyName() string {
+	f, err := os.Open(osReleasePath)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "PRETTY_NAME="); ok {
+			return strings.Trim(value, `"'`)
+		}
+	}
+	return ""
+}
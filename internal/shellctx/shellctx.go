@@ -2,9 +2,12 @@
 package shellctx
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/user/qcmd/internal/backend"
 )
@@ -43,6 +46,64 @@ func getShell() string {
 	return filepath.Base(shell)
 }
 
+// DirectoryListing returns a low-priority context section listing the
+// entries of dir (directories suffixed with "/"), at most limit entries.
+// Hidden entries are skipped. Returns false if dir cannot be read or is empty.
+func DirectoryListing(dir string, limit int) (backend.ContextSection, bool) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return backend.ContextSection{}, false
+	}
+
+	var names []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return backend.ContextSection{}, false
+	}
+
+	// os.ReadDir returns entries sorted by filename.
+	var b strings.Builder
+	for i, name := range names {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(&b, "... and %d more\n", len(names)-limit)
+			break
+		}
+		b.WriteString(name)
+		b.WriteString("\n")
+	}
+
+	return backend.ContextSection{
+		Name:     "Directory listing",
+		Content:  strings.TrimRight(b.String(), "\n"),
+		Priority: backend.PriorityLow,
+	}, true
+}
+
+// GitStatus reports the checked-out branch of the git repository
+// containing dir (empty when HEAD is detached) and whether tracked files
+// have uncommitted changes. ok is false outside a repository or when git
+// is not installed.
+func GitStatus(dir string) (branch string, dirty bool, ok bool) {
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--untracked-files=no").Output()
+	if err != nil {
+		return "", false, false
+	}
+	// symbolic-ref fails on a detached HEAD, which leaves branch empty.
+	if out, err := exec.Command("git", "-C", dir, "symbolic-ref", "--short", "-q", "HEAD").Output(); err == nil {
+		branch = strings.TrimSpace(string(out))
+	}
+	return branch, len(strings.TrimSpace(string(status))) > 0, true
+}
+
 // GetShellFromPath extracts the shell name from a full path.
 // Exported for testing purposes.
 func GetShellFromPath(shellPath string) string {
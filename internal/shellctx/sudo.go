@@ -0,0 +1,19 @@
+package shellctx
+
+import (
+	"context"
+	"time"
+)
+
+// SudoCached reports whether sudo runs without asking for a password,
+// because credentials are cached or the user has NOPASSWD rights. It never
+// prompts.
+func SudoCached() bool {
+	if _, err := lookPath("sudo"); err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := commandOutput(ctx, "sudo", "-n", "true")
+	return err == nil
+}
@@ -0,0 +1,177 @@
+package shellctx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// helpTimeout bounds each --help or man call.
+const helpTimeout = 2 * time.Second
+
+// combinedOutput runs a command with the given extra environment and
+// returns its stdout and stderr together; it can be overridden for testing.
+// Usage messages often go to stderr, and tools such as BSD sed exit with an
+// error after printing one.
+var combinedOutput = func(ctx context.Context, env []string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), env...)
+	return cmd.CombinedOutput()
+}
+
+// shellBuiltins are command names that are not programs with their own
+// help, or whose help does not say which flags a tool supports.
+var shellBuiltins = map[string]bool{
+	"cd": true, "echo": true, "printf": true, "export": true, "set": true,
+	"unset": true, "source": true, ".": true, "eval": true, "true": true,
+	"false": true, "test": true, "[": true, "[[": true, "read": true,
+	"alias": true, "type": true, "local": true, "return": true, "exit": true,
+	"if": true, "then": true, "else": true, "fi": true, "for": true,
+	"while": true, "do": true, "done": true, "case": true, "esac": true,
+	"{": true, "(": true, "!": true, "pushd": true, "popd": true,
+	"builtin": true, "let": true, "declare": true,
+}
+
+// assignment matches a leading VAR=value word.
+var assignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// overstrike matches man's bold (c\bc) and underline (_\bc) formatting.
+var overstrike = regexp.MustCompile(`.\x08`)
+
+// MainTool returns the program command is mostly about: the name of its
+// first simple command that is not a shell builtin, looking past
+// variable assignments and wrappers such as sudo, env and xargs. It
+// returns "" if there is none, or if the name is a path or is computed.
+func MainTool(command string) string {
+	for _, pipeline := range shellparse.Pipelines(command) {
+		for _, simple := range shellparse.Commands(pipeline.Text) {
+			words := unwrap(shellparse.Words(simple))
+			if len(words) == 0 || shellBuiltins[words[0]] {
+				continue
+			}
+			if strings.ContainsAny(words[0], "/$`") {
+				return ""
+			}
+			return words[0]
+		}
+	}
+	return ""
+}
+
+// unwrap drops leading assignments and the wrappers that run the rest of
+// the words as a command, with their options.
+func unwrap(words []string) []string {
+	for len(words) > 0 {
+		switch w := words[0]; {
+		case assignment.MatchString(w):
+			words = words[1:]
+		case w == "sudo" || w == "doas" || w == "env" || w == "nice" || w == "nohup" ||
+			w == "time" || w == "command" || w == "exec" || w == "xargs":
+			words = words[1:]
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				// Options taking a value, which is the next word.
+				if w == "sudo" && (words[0] == "-u" || words[0] == "-g") ||
+					w == "nice" && words[0] == "-n" ||
+					w == "xargs" && (words[0] == "-I" || words[0] == "-n" || words[0] == "-P") {
+					words = words[1:]
+				}
+				words = words[1:]
+			}
+		case w == "timeout":
+			words = words[1:]
+			for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+				if words[0] == "-s" || words[0] == "-k" {
+					words = words[1:]
+				}
+				words = words[1:]
+			}
+			if len(words) > 0 {
+				words = words[1:] // the duration
+			}
+		default:
+			return words
+		}
+	}
+	return words
+}
+
+// ToolHelpSection returns a high-priority context section with the help of
+// tool as installed on this machine: the usage it prints for --help, or its
+// man page from the synopsis on if that says nothing. At most limit bytes
+// are kept. It returns false if tool is not in $PATH or has no help.
+//
+// Running tool --help executes tool, so only programs found in $PATH, not
+// ones in the working directory, are asked.
+func ToolHelpSection(tool string, limit int) (backend.ContextSection, bool) {
+	path, err := lookPath(tool)
+	if err != nil || tool == "" {
+		return backend.ContextSection{}, false
+	}
+
+	help := helpOutput(path)
+	if help == "" {
+		help = manPage(tool)
+	}
+	if help == "" {
+		return backend.ContextSection{}, false
+	}
+	return backend.ContextSection{
+		Name:     fmt.Sprintf("Help for %s as installed here", tool),
+		Content:  truncateLines(help, limit),
+		Priority: backend.PriorityHigh,
+	}, true
+}
+
+// helpOutput returns what the program at path prints for --help, if it
+// looks like a usage message.
+func helpOutput(path string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), helpTimeout)
+	defer cancel()
+	// Tools that do not know --help usually print their usage and fail.
+	out, _ := combinedOutput(ctx, nil, path, "--help")
+	if ctx.Err() != nil {
+		return ""
+	}
+	help := strings.TrimSpace(string(out))
+	lower := strings.ToLower(help)
+	if !strings.Contains(lower, "usage") && !strings.Contains(help, "\n  -") {
+		return ""
+	}
+	return help
+}
+
+// manPage returns tool's man page as plain text from the SYNOPSIS heading
+// on, or "" if it has none.
+func manPage(tool string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), helpTimeout)
+	defer cancel()
+	out, err := combinedOutput(ctx, []string{"MANPAGER=cat", "PAGER=cat", "MANWIDTH=80"}, "man", tool)
+	if err != nil {
+		return ""
+	}
+	page := overstrike.ReplaceAllString(string(out), "")
+	if i := strings.Index(page, "SYNOPSIS"); i >= 0 {
+		page = page[i:]
+	}
+	return strings.TrimSpace(page)
+}
+
+// truncateLines cuts s to at most limit bytes at a line break, marking the
+// cut. A limit of 0 or less keeps all of s.
+func truncateLines(s string, limit int) string {
+	if limit <= 0 || len(s) <= limit {
+		return s
+	}
+	cut := s[:limit]
+	if i := strings.LastIndexByte(cut, '\n'); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, "\n") + "\n..."
+}
@@ -0,0 +1,87 @@
+package shellctx
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/user/qcmd/internal/backend"
+)
+
+// versionProbeTimeout bounds each version call. The calls run
+// concurrently, so it also bounds ToolVersionsSection.
+const versionProbeTimeout = time.Second
+
+// ToolVersionsTimeout is how long ToolVersionsSection is worth waiting for
+// when gathering context, since it runs external tools.
+const ToolVersionsTimeout = versionProbeTimeout
+
+// versionArgs are the arguments that print a tool's version, for tools
+// that do not take --version.
+var versionArgs = map[string][]string{
+	"kubectl": {"version", "--client"},
+	"go":      {"version"},
+	"java":    {"-version"},
+	"helm":    {"version", "--short"},
+}
+
+// versionNumber matches a dotted version number such as 2.43.0.
+var versionNumber = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// ToolVersionsSection returns a context section with the installed version
+// of each of tools, so commands avoid options the version lacks. Tools that
+// are not installed, or whose version cannot be read, are left out.
+// Versions are looked up in cache first. Returns false if none is left.
+func ToolVersionsSection(tools []string, cache *ProbeCache) (backend.ContextSection, bool) {
+	versions := make([]string, len(tools))
+	var wg sync.WaitGroup
+	for i, tool := range tools {
+		wg.Add(1)
+		go func(i int, tool string) {
+			defer wg.Done()
+			versions[i] = toolVersion(tool, cache)
+		}(i, tool)
+	}
+	wg.Wait()
+
+	var parts []string
+	for i, v := range versions {
+		if v != "" {
+			parts = append(parts, tools[i]+" "+v)
+		}
+	}
+	if len(parts) == 0 {
+		return backend.ContextSection{}, false
+	}
+	return backend.ContextSection{
+		Name:     "Installed versions",
+		Content:  strings.Join(parts, ", "),
+		Priority: backend.PriorityNormal,
+	}, true
+}
+
+// toolVersion returns the version tool reports, or "" if it is not
+// installed or prints no version number.
+func toolVersion(tool string, cache *ProbeCache) string {
+	path, err := lookPath(tool)
+	if err != nil {
+		return ""
+	}
+	args, ok := versionArgs[tool]
+	if !ok {
+		args = []string{"--version"}
+	}
+	return cache.probe("version", path, func() (string, bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), versionProbeTimeout)
+		defer cancel()
+		// java prints its version to stderr, and some tools exit with an
+		// error after printing it.
+		out, _ := combinedOutput(ctx, nil, path, args...)
+		if ctx.Err() != nil {
+			return "", false
+		}
+		return versionNumber.FindString(string(out)), true
+	})
+}
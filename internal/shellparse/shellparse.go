@@ -0,0 +1,437 @@
+// Package shellparse splits shell command text into the pieces the safety
+// checker inspects: command lists, pipelines, simple commands and words.
+//
+// It is not a full shell parser. It understands quoting, escapes, line
+// continuations, comments, heredocs and grouping with (), {} and $(), which
+// is enough to find command boundaries without being fooled by operators
+// inside strings, and to find the commands run inside groups.
+package shellparse
+
+import "strings"
+
+// Segment is a piece of shell text and the 1-based line it starts on.
+type Segment struct {
+	Text string
+	Line int
+}
+
+// Pipelines splits src into pipelines separated by ;, &&, ||, & and
+// newlines. Comments and heredoc bodies are skipped; text inside quotes or
+// grouping constructs is kept intact.
+func Pipelines(src string) []Segment {
+	var (
+		segments []Segment
+		cur      strings.Builder
+		start    = 1
+		line     = 1
+		depth    = 0
+		heredocs []string
+		blank    = true
+	)
+
+	// add appends s to the current segment, noting the line the segment
+	// starts on when s is its first non-blank text.
+	add := func(s string) {
+		if blank && strings.TrimSpace(s) != "" {
+			start = line
+			blank = false
+		}
+		cur.WriteString(s)
+	}
+	flush := func() {
+		if text := strings.TrimSpace(cur.String()); text != "" {
+			segments = append(segments, Segment{Text: text, Line: start})
+		}
+		cur.Reset()
+		blank = true
+	}
+
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+
+		switch {
+		case c == '\\':
+			if i+1 < len(src) && src[i+1] == '\n' {
+				// Line continuation: join with the next line.
+				line++
+				add(" ")
+				i++
+				continue
+			}
+			end := i + 2
+			if end > len(src) {
+				end = len(src)
+			}
+			add(src[i:end])
+			i = end - 1
+
+		case c == '\'' || c == '"' || c == '`':
+			end := closingQuote(src, i)
+			add(src[i:end])
+			line += strings.Count(src[i:end], "\n")
+			i = end - 1
+
+		case c == '#' && atWordStart(src, i):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+			i--
+
+		case c == '(' || c == '{':
+			depth++
+			add(string(c))
+
+		case (c == ')' || c == '}') && depth > 0:
+			depth--
+			add(string(c))
+
+		case c == '<' && strings.HasPrefix(src[i:], "<<<"):
+			// Here-string: the operand is on the same line.
+			add("<<<")
+			i += 2
+
+		case c == '<' && strings.HasPrefix(src[i:], "<<") && depth == 0:
+			// Inside grouping, as in $((1<<2)), << may be a shift; a
+			// heredoc there has its body checked as commands.
+			delim, n := heredocDelimiter(src[i+2:])
+			if delim != "" {
+				heredocs = append(heredocs, delim)
+			}
+			add(src[i : i+2+n])
+			i += 1 + n
+
+		case c == '\n':
+			line++
+			if depth == 0 {
+				flush()
+			} else {
+				add(" ")
+			}
+			for _, delim := range heredocs {
+				i = skipHeredoc(src, i+1, delim, &line) - 1
+			}
+			heredocs = nil
+
+		case depth > 0:
+			add(string(c))
+
+		case c == ';':
+			flush()
+
+		case c == '&' && strings.HasPrefix(src[i:], "&&"):
+			flush()
+			i++
+
+		case c == '|' && strings.HasPrefix(src[i:], "||"):
+			flush()
+			i++
+
+		case c == '&' && !isRedirectAmp(src, i):
+			flush()
+
+		default:
+			add(string(c))
+		}
+	}
+	flush()
+
+	return segments
+}
+
+// Commands splits a pipeline into its simple commands on unquoted | and |&.
+func Commands(pipeline string) []string {
+	var (
+		commands []string
+		last     = 0
+		depth    = 0
+	)
+
+	for i := 0; i < len(pipeline); i++ {
+		switch c := pipeline[i]; {
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			i = closingQuote(pipeline, i) - 1
+		case c == '(' || c == '{':
+			depth++
+		case (c == ')' || c == '}') && depth > 0:
+			depth--
+		case c == '|' && depth == 0:
+			if text := strings.TrimSpace(pipeline[last:i]); text != "" {
+				commands = append(commands, text)
+			}
+			if strings.HasPrefix(pipeline[i:], "|&") {
+				i++
+			}
+			last = i + 1
+		}
+	}
+	if text := strings.TrimSpace(pipeline[last:]); text != "" {
+		commands = append(commands, text)
+	}
+
+	return commands
+}
+
+// Groups returns the bodies of the subshells, brace groups, command and
+// process substitutions in cmd that are not nested in one another, for
+// checking the commands they run. Substitutions inside double quotes run
+// too and are included; arithmetic such as $((1<<2)) and array
+// assignments are not commands and are left out.
+func Groups(cmd string) []string {
+	return groups(cmd, false)
+}
+
+// groups implements Groups; quoted is true inside double quotes, where
+// only $( and ` start a command.
+func groups(cmd string, quoted bool) []string {
+	var bodies []string
+	for i := 0; i < len(cmd); i++ {
+		switch c := cmd[i]; {
+		case c == '\\':
+			i++
+		case c == '\'' && !quoted:
+			i = closingQuote(cmd, i) - 1
+		case c == '"' && !quoted:
+			end := closingQuote(cmd, i)
+			bodies = append(bodies, groups(strings.TrimSuffix(cmd[i+1:end], `"`), true)...)
+			i = end - 1
+		case c == '`':
+			end := closingQuote(cmd, i)
+			bodies = append(bodies, strings.TrimSuffix(cmd[i+1:end], "`"))
+			i = end - 1
+		case c == '$' && strings.HasPrefix(cmd[i:], "$(("):
+			i = closingGroup(cmd, i+1) - 1
+		case c == '$' && strings.HasPrefix(cmd[i:], "${"):
+			i = closingGroup(cmd, i+1) - 1
+		case c == '(' && (i == 0 || cmd[i-1] != '='):
+			end := closingGroup(cmd, i)
+			if (i == 0 || cmd[i-1] != '$') && strings.HasPrefix(cmd[i:], "((") {
+				// An arithmetic command: (( x <<= 1 )).
+				i = end - 1
+				continue
+			}
+			if quoted && (i == 0 || cmd[i-1] != '$') {
+				continue
+			}
+			bodies = append(bodies, strings.TrimSuffix(cmd[i+1:end], ")"))
+			i = end - 1
+		case c == '(':
+			i = closingGroup(cmd, i) - 1
+		case c == '{' && !quoted && atWordStart(cmd, i) && i+1 < len(cmd) && strings.IndexByte(" \t\n", cmd[i+1]) >= 0:
+			end := closingGroup(cmd, i)
+			bodies = append(bodies, strings.TrimSuffix(cmd[i+1:end], "}"))
+			i = end - 1
+		}
+	}
+	return bodies
+}
+
+// closingGroup returns the index just past the ) or } closing the ( or {
+// at src[i], or len(src) if it is unterminated.
+func closingGroup(src string, i int) int {
+	depth := 0
+	for j := i; j < len(src); j++ {
+		switch c := src[j]; {
+		case c == '\\':
+			j++
+		case c == '\'' || c == '"' || c == '`':
+			j = closingQuote(src, j) - 1
+		case c == '(' || c == '{':
+			depth++
+		case c == ')' || c == '}':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(src)
+}
+
+// Words splits a simple command into words, removing quotes and escapes.
+func Words(cmd string) []string {
+	var (
+		words  []string
+		cur    strings.Builder
+		inWord bool
+	)
+
+	for i := 0; i < len(cmd); i++ {
+		c := cmd[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		case c == '\\' && i+1 < len(cmd):
+			cur.WriteByte(cmd[i+1])
+			inWord = true
+			i++
+		case c == '\'':
+			end := closingQuote(cmd, i)
+			cur.WriteString(strings.TrimSuffix(cmd[i+1:end], "'"))
+			inWord = true
+			i = end - 1
+		case c == '"':
+			end := closingQuote(cmd, i)
+			inner := strings.TrimSuffix(cmd[i+1:end], `"`)
+			cur.WriteString(unescapeDouble(inner))
+			inWord = true
+			i = end - 1
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+
+	return words
+}
+
+// SplitComment splits a command line at its first comment, returning the
+// code before it and the comment's text after the #, both trimmed. A # in
+// quotes or inside a word, as in foo#bar, does not start a comment.
+func SplitComment(line string) (code, comment string) {
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"' || c == '`':
+			i = closingQuote(line, i) - 1
+		case c == '#' && atWordStart(line, i):
+			return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		}
+	}
+	return strings.TrimSpace(line), ""
+}
+
+// IsComment reports whether line is a comment on its own: a # after
+// optional blanks. A #! interpreter line is not a comment.
+func IsComment(line string) bool {
+	line = strings.TrimLeft(line, " \t")
+	return strings.HasPrefix(line, "#") && !strings.HasPrefix(line, "#!")
+}
+
+// MaskQuotes replaces the text inside single and double quotes with
+// spaces, keeping the quotes and a $ before them, so only the syntax around
+// strings is left to match.
+func MaskQuotes(cmd string) string {
+	b := []byte(cmd)
+	var quote byte
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case quote == 0 && c == '\\':
+			i++
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == '"' && c == '\\':
+			b[i] = ' '
+			if i+1 < len(b) {
+				i++
+				b[i] = ' '
+			}
+		case quote != 0 && c != '\n':
+			b[i] = ' '
+		}
+	}
+	return string(b)
+}
+
+// closingQuote returns the index just past the quote closing the one at
+// src[i], or len(src) if it is unterminated.
+func closingQuote(src string, i int) int {
+	q := src[i]
+	for j := i + 1; j < len(src); j++ {
+		if src[j] == '\\' && q != '\'' {
+			j++
+			continue
+		}
+		if src[j] == q {
+			return j + 1
+		}
+	}
+	return len(src)
+}
+
+// atWordStart reports whether src[i] begins a word, which is where an
+// unquoted # starts a comment.
+func atWordStart(src string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	return strings.IndexByte(" \t\n;&|()", src[i-1]) >= 0
+}
+
+// isRedirectAmp reports whether the & at src[i] is part of a redirection
+// such as 2>&1 or &>file rather than a background operator.
+func isRedirectAmp(src string, i int) bool {
+	if i > 0 && (src[i-1] == '>' || src[i-1] == '<') {
+		return true
+	}
+	return i+1 < len(src) && src[i+1] == '>'
+}
+
+// heredocDelimiter parses the delimiter following "<<" and returns it with
+// the number of bytes consumed.
+func heredocDelimiter(s string) (string, int) {
+	n := 0
+	if strings.HasPrefix(s, "-") {
+		n++
+	}
+	for n < len(s) && (s[n] == ' ' || s[n] == '\t') {
+		n++
+	}
+
+	start := n
+	if n < len(s) && (s[n] == '\'' || s[n] == '"') {
+		// A quoted delimiter may contain blanks.
+		n = closingQuote(s, n)
+		return strings.Trim(s[start:n], `'"`), n
+	}
+	for n < len(s) && strings.IndexByte(" \t\n;&|<>()", s[n]) < 0 {
+		n++
+	}
+	delim := strings.Trim(s[start:n], `'"\`)
+	return delim, n
+}
+
+// skipHeredoc skips heredoc body lines starting at src[i] up to and
+// including the delimiter line, and returns the index after it. If no line
+// is the delimiter, the << was not a heredoc after all, or not one qcmd
+// understands, and nothing is skipped: the lines are read as commands.
+func skipHeredoc(src string, i int, delim string, line *int) int {
+	for j, n := i, 0; j < len(src); {
+		end := strings.IndexByte(src[j:], '\n')
+		if end < 0 {
+			end = len(src) - j
+		}
+		body := src[j : j+end]
+		j += end + 1
+		n++
+		if strings.TrimLeft(body, "\t") == delim {
+			*line += n
+			return min(j, len(src))
+		}
+	}
+	return i
+}
+
+// unescapeDouble removes backslashes that escape characters special inside
+// double quotes.
+func unescapeDouble(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("\\\"$`", s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
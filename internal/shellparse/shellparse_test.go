@@ -0,0 +1,237 @@
+package shellparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPipelines(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []Segment
+	}{
+		{
+			name: "single command",
+			src:  "ls -la",
+			want: []Segment{{"ls -la", 1}},
+		},
+		{
+			name: "list operators",
+			src:  "cd /tmp && rm -rf build; make || echo failed & wait",
+			want: []Segment{{"cd /tmp", 1}, {"rm -rf build", 1}, {"make", 1}, {"echo failed", 1}, {"wait", 1}},
+		},
+		{
+			name: "pipes stay together",
+			src:  "ps aux | grep ssh | wc -l",
+			want: []Segment{{"ps aux | grep ssh | wc -l", 1}},
+		},
+		{
+			name: "operators inside quotes",
+			src:  `echo "a && b; c" && echo 'x | y'`,
+			want: []Segment{{`echo "a && b; c"`, 1}, {`echo 'x | y'`, 1}},
+		},
+		{
+			name: "escaped semicolon in find",
+			src:  `find . -name '*.tmp' -exec rm {} \; && echo done`,
+			want: []Segment{{`find . -name '*.tmp' -exec rm {} \;`, 1}, {"echo done", 1}},
+		},
+		{
+			name: "redirections are not background jobs",
+			src:  "make >build.log 2>&1 &>/dev/null",
+			want: []Segment{{"make >build.log 2>&1 &>/dev/null", 1}},
+		},
+		{
+			name: "multi-line script with comments",
+			src:  "#!/bin/sh\nset -eu\n\n# Step 1: build\nmake # compile\nrm -rf dist",
+			want: []Segment{{"set -eu", 2}, {"make", 5}, {"rm -rf dist", 6}},
+		},
+		{
+			name: "line continuation",
+			src:  "tar -czf out.tgz \\\n  src/ \\\n  docs/\necho ok",
+			want: []Segment{{"tar -czf out.tgz    src/    docs/", 1}, {"echo ok", 4}},
+		},
+		{
+			name: "subshell and brace groups stay intact",
+			src:  "(cd /tmp; ls) && { echo a; echo b; }",
+			want: []Segment{{"(cd /tmp; ls)", 1}, {"{ echo a; echo b; }", 1}},
+		},
+		{
+			name: "command substitution",
+			src:  "kill $(pgrep -f 'a;b'); echo x",
+			want: []Segment{{"kill $(pgrep -f 'a;b')", 1}, {"echo x", 1}},
+		},
+		{
+			name: "heredoc body is skipped",
+			src:  "cat <<'EOF' > notes.txt\nrm -rf /\nEOF\necho written",
+			want: []Segment{{"cat <<'EOF' > notes.txt", 1}, {"echo written", 4}},
+		},
+		{
+			name: "quoted heredoc delimiter with a blank",
+			src:  "cat <<'E O'\nrm -rf /\nE O\necho written",
+			want: []Segment{{"cat <<'E O'", 1}, {"echo written", 4}},
+		},
+		{
+			name: "heredoc without its delimiter skips nothing",
+			src:  "let 'x = 1<<2'\nreboot",
+			want: []Segment{{"let 'x = 1<<2'", 1}, {"reboot", 2}},
+		},
+		{
+			name: "arithmetic shift is not a heredoc",
+			src:  "echo $((1<<2))\ngit push --force origin main\n2",
+			want: []Segment{{"echo $((1<<2))", 1}, {"git push --force origin main", 2}, {"2", 3}},
+		},
+		{
+			name: "here-string is not a heredoc",
+			src:  "grep x <<< \"$v\"\necho y",
+			want: []Segment{{`grep x <<< "$v"`, 1}, {"echo y", 2}},
+		},
+		{
+			name: "hash inside word is not a comment",
+			src:  "echo a#b",
+			want: []Segment{{"echo a#b", 1}},
+		},
+		{
+			name: "empty",
+			src:  "  \n# only a comment\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Pipelines(tt.src)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Pipelines(%q) =\n  %q\nwant\n  %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		pipeline string
+		want     []string
+	}{
+		{"ls", []string{"ls"}},
+		{"curl -s https://x.sh | sudo bash", []string{"curl -s https://x.sh", "sudo bash"}},
+		{`grep "a|b" file | sort |& tee log`, []string{`grep "a|b" file`, "sort", "tee log"}},
+		{"echo $(ls | wc -l) | cat", []string{"echo $(ls | wc -l)", "cat"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pipeline, func(t *testing.T) {
+			if got := Commands(tt.pipeline); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Commands(%q) = %q, want %q", tt.pipeline, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroups(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"ls", nil},
+		{"(rm -rf /)", []string{"rm -rf /"}},
+		{"{ rm -rf /; }", []string{" rm -rf /; "}},
+		{"echo $(rm -rf /)", []string{"rm -rf /"}},
+		{"echo `rm -rf /`", []string{"rm -rf /"}},
+		{`echo "$(whoami) (you)"`, []string{"whoami"}},
+		{"diff <(sort a) <(sort b)", []string{"sort a", "sort b"}},
+		{"echo $(cd /tmp && $(pwd))", []string{"cd /tmp && $(pwd)"}},
+		{"echo $((1<<2)) ${x} {a,b} '(no)'", nil},
+		{"(( n <<= 1 )); arr=(a b)", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := Groups(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Groups(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitComment(t *testing.T) {
+	tests := []struct {
+		line, code, comment string
+	}{
+		{"tar -c # all pngs, gzipped", "tar -c", "all pngs, gzipped"},
+		{"# resize all pngs to 50%", "", "resize all pngs to 50%"},
+		{"ls -la", "ls -la", ""},
+		{`echo "# not a comment" # but this is`, `echo "# not a comment"`, "but this is"},
+		{"git log foo#bar", "git log foo#bar", ""},
+		{`grep \# file #count them`, `grep \# file`, "count them"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			code, comment := SplitComment(tt.line)
+			if code != tt.code || comment != tt.comment {
+				t.Errorf("SplitComment(%q) = %q, %q; want %q, %q", tt.line, code, comment, tt.code, tt.comment)
+			}
+		})
+	}
+}
+
+func TestIsComment(t *testing.T) {
+	tests := []struct {
+		line string
+		want bool
+	}{
+		{"# resize all pngs to 50%", true},
+		{"  \t#indented", true},
+		{"#!/bin/sh", false},
+		{"tar -c # all pngs", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsComment(tt.line); got != tt.want {
+			t.Errorf("IsComment(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestMaskQuotes(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{`awk '{print $1}' f`, `awk '          ' f`},
+		{`echo "a \"b\" c" x`, `echo "         " x`},
+		{`printf $'a\tb'`, `printf $'    '`},
+		{`echo \'not quoted`, `echo \'not quoted`},
+		{"ls", "ls"},
+	}
+
+	for _, tt := range tests {
+		if got := MaskQuotes(tt.cmd); got != tt.want {
+			t.Errorf("MaskQuotes(%q) = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		cmd  string
+		want []string
+	}{
+		{"ls  -la\t/tmp", []string{"ls", "-la", "/tmp"}},
+		{`echo "hello world" 'a b'`, []string{"echo", "hello world", "a b"}},
+		{`echo "say \"hi\"" a\ b`, []string{"echo", `say "hi"`, "a b"}},
+		{`find . -exec rm {} \;`, []string{"find", ".", "-exec", "rm", "{}", ";"}},
+		{`x=""`, []string{"x="}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			if got := Words(tt.cmd); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+// Package snippets stores named, reusable command recipes.
+//
+// Snippets live in a small TOML file so they stay readable in code review
+// when shared with a team through `qcmd sync`.
+package snippets
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// FileName is the name of the snippets file, both in the data directory
+// and at the root of a sync repository.
+const FileName = "snippets.toml"
+
+// Snippet is a named command recipe.
+type Snippet struct {
+	Name        string `toml:"name"`
+	Command     string `toml:"command"`
+	Description string `toml:"description,omitempty"`
+	Favorite    bool   `toml:"favorite,omitempty"`
+}
+
+// file is the on-disk layout of a snippets file.
+type file struct {
+	Snippets []Snippet `toml:"snippet"`
+}
+
+// Load reads snippets from path. A missing file yields no snippets.
+func Load(path string) ([]Snippet, error) {
+	var f file
+	if _, err := toml.DecodeFile(path, &f); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("parse snippets: %w", err)
+	}
+	return f.Snippets, nil
+}
+
+// Save writes snippets to path sorted by name, creating parent directories
+// as needed. The file is replaced atomically. Use Update to change the
+// snippets already saved.
+func Save(path string, snippets []Snippet) error {
+	sorted := append([]Snippet(nil), snippets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(file{Snippets: sorted}); err != nil {
+		return fmt.Errorf("encode snippets: %w", err)
+	}
+
+	if err := filelock.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("writing snippets: %w", err)
+	}
+	return nil
+}
+
+// Update applies fn to the snippets at path and saves the result, holding
+// a lock so that other qcmds cannot change the file in between. Nothing is
+// saved if fn fails.
+func Update(path string, fn func([]Snippet) ([]Snippet, error)) error {
+	unlock, err := filelock.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	snippets, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if snippets, err = fn(snippets); err != nil {
+		return err
+	}
+	return Save(path, snippets)
+}
+
+// Find returns the snippet with the given name.
+func Find(snippets []Snippet, name string) (Snippet, bool) {
+	for _, s := range snippets {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Snippet{}, false
+}
+
+// Put adds s to snippets, replacing any existing snippet with the same name.
+func Put(snippets []Snippet, s Snippet) []Snippet {
+	for i := range snippets {
+		if snippets[i].Name == s.Name {
+			snippets[i] = s
+			return snippets
+		}
+	}
+	return append(snippets, s)
+}
+
+// Remove deletes the snippet with the given name and reports whether it
+// was present.
+func Remove(snippets []Snippet, name string) ([]Snippet, bool) {
+	for i := range snippets {
+		if snippets[i].Name == name {
+			return append(snippets[:i:i], snippets[i+1:]...), true
+		}
+	}
+	return snippets, false
+}
+
+// Merge returns the union of base and overlay. When both contain a snippet
+// with the same name, the one from overlay wins.
+func Merge(base, overlay []Snippet) []Snippet {
+	merged := append([]Snippet(nil), base...)
+	for _, s := range overlay {
+		merged = Put(merged, s)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Name < merged[j].Name })
+	return merged
+}
@@ -0,0 +1,141 @@
+package snippets
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestLoadMissingFile(t *testing.T) {
+	got, err := Load(filepath.Join(t.TempDir(), FileName))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("Load() = %v, want no snippets", got)
+	}
+}
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", FileName)
+	in := []Snippet{
+		{Name: "ports", Command: "ss -tulpn", Description: "listening sockets", Favorite: true},
+		{Name: "disk", Command: `du -sh * | sort -h`},
+	}
+
+	if err := Save(path, in); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("snippets file not created: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("snippets file permissions = %o, want 0600", perm)
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	// Save sorts by name.
+	want := []Snippet{in[1], in[0]}
+	if len(got) != len(want) {
+		t.Fatalf("Load() returned %d snippets, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("snippet[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	if err := os.WriteFile(path, []byte("[[snippet]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() expected error for invalid TOML")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	base := []Snippet{
+		{Name: "a", Command: "base-a"},
+		{Name: "b", Command: "base-b"},
+	}
+	overlay := []Snippet{
+		{Name: "b", Command: "overlay-b"},
+		{Name: "c", Command: "overlay-c"},
+	}
+
+	got := Merge(base, overlay)
+	want := []string{"base-a", "overlay-b", "overlay-c"}
+	if len(got) != len(want) {
+		t.Fatalf("Merge() returned %d snippets, want %d", len(got), len(want))
+	}
+	for i, cmd := range want {
+		if got[i].Command != cmd {
+			t.Errorf("Merge()[%d].Command = %q, want %q", i, got[i].Command, cmd)
+		}
+	}
+	if base[1].Command != "base-b" {
+		t.Error("Merge() modified its base argument")
+	}
+}
+
+func TestPutFindRemove(t *testing.T) {
+	var list []Snippet
+	list = Put(list, Snippet{Name: "x", Command: "one"})
+	list = Put(list, Snippet{Name: "x", Command: "two"})
+
+	s, ok := Find(list, "x")
+	if !ok || s.Command != "two" || len(list) != 1 {
+		t.Errorf("Put() did not replace existing snippet: %+v", list)
+	}
+
+	list, ok = Remove(list, "x")
+	if !ok || len(list) != 0 {
+		t.Errorf("Remove() = %v, %v; want empty, true", list, ok)
+	}
+	if _, ok := Remove(list, "missing"); ok {
+		t.Error("Remove() reported success for a missing snippet")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+
+	// Concurrent updates, as from two terminals, all land.
+	names := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			err := Update(path, func(list []Snippet) ([]Snippet, error) {
+				return Put(list, Snippet{Name: name, Command: "echo " + name}), nil
+			})
+			if err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+		}(name)
+	}
+	wg.Wait()
+	if got, err := Load(path); err != nil || len(got) != len(names) {
+		t.Fatalf("Load() after updates = %d snippets, %v; want %d", len(got), err, len(names))
+	}
+
+	failed := errors.New("no such snippet")
+	err := Update(path, func([]Snippet) ([]Snippet, error) { return nil, failed })
+	if !errors.Is(err, failed) {
+		t.Errorf("Update() error = %v, want %v", err, failed)
+	}
+	if got, _ := Load(path); len(got) != len(names) {
+		t.Errorf("failed Update() saved %d snippets, want %d kept", len(got), len(names))
+	}
+}
@@ -0,0 +1,241 @@
+// Package store keeps qcmd's local records in a single SQLite database in
+// the data directory: the command history, corrections, API usage and the
+// audit log. The schema is created and upgraded by numbered migrations
+// when the database is opened.
+//
+// Files meant to be edited by hand, such as snippets.toml, are not kept
+// here.
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+)
+
+// FileName is the name of the database in the data directory.
+const FileName = "qcmd.db"
+
+// BusyTimeout is how long a statement waits for another qcmd holding the
+// database before failing.
+const BusyTimeout = 5 * time.Second
+
+// ErrNewer is returned when the database was written by a newer qcmd,
+// whose schema this one does not know.
+var ErrNewer = errors.New("database was created by a newer version of qcmd")
+
+// migration upgrades the schema by one version.
+type migration struct {
+	version int
+	sql     string
+}
+
+// migrations are applied in order to bring a database to the current
+// schema. Never edit one that has been released; add another.
+var migrations = []migration{
+	{1, `
+CREATE TABLE history (
+	id        INTEGER PRIMARY KEY,
+	seq       INTEGER NOT NULL,
+	time      TEXT NOT NULL,
+	query     TEXT NOT NULL DEFAULT '',
+	command   TEXT NOT NULL DEFAULT '',
+	backend   TEXT NOT NULL DEFAULT '',
+	model     TEXT NOT NULL DEFAULT '',
+	executed  TEXT NOT NULL DEFAULT '',
+	feedback  TEXT NOT NULL DEFAULT '',
+	note      TEXT NOT NULL DEFAULT '',
+	exit_code INTEGER NOT NULL DEFAULT 0,
+	stderr    TEXT NOT NULL DEFAULT '',
+	undo      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX history_seq ON history (seq);
+CREATE INDEX history_command ON history (command);
+
+CREATE TABLE corrections (
+	id        INTEGER PRIMARY KEY,
+	time      TEXT NOT NULL,
+	query     TEXT NOT NULL,
+	generated TEXT NOT NULL,
+	edited    TEXT NOT NULL
+);
+
+CREATE TABLE usage (
+	id            INTEGER PRIMARY KEY,
+	time          TEXT NOT NULL,
+	backend       TEXT NOT NULL,
+	model         TEXT NOT NULL,
+	input_tokens  INTEGER NOT NULL,
+	output_tokens INTEGER NOT NULL,
+	cost_usd      REAL NOT NULL,
+	user          TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX usage_time ON usage (time);
+
+CREATE TABLE audit (
+	id     INTEGER PRIMARY KEY,
+	time   TEXT NOT NULL,
+	event  TEXT NOT NULL,
+	user   TEXT NOT NULL DEFAULT '',
+	dir    TEXT NOT NULL DEFAULT '',
+	args   TEXT NOT NULL DEFAULT '',
+	detail TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE trusted (
+	hash  TEXT PRIMARY KEY,
+	until TEXT NOT NULL
+);
+`},
+	// Trusted commands moved to the state directory. Trust lasts hours at
+	// most, so it is not carried over: the commands are warned about once
+	// more.
+	{2, `DROP TABLE trusted;`},
+}
+
+// Store is an open database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens the database at path, creating it and its directory with
+// private permissions, and applies any migrations it lacks.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating data directory: %w", err)
+	}
+	// Create the file first so SQLite does not create it world-readable.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filepath.Base(path), err)
+	}
+	f.Close()
+
+	// Transactions take the write lock when they begin, so two qcmds
+	// never both read and then wait on each other to write.
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_txlock=immediate", path, BusyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filepath.Base(path), err)
+	}
+	s := &Store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening %s: %w", filepath.Base(path), err)
+	}
+	return s, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the database handle, for the packages that own its tables.
+func (s *Store) DB() *sql.DB {
+	return s.db
+}
+
+// Version returns the schema version of the database.
+func (s *Store) Version() (int, error) {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Tx runs fn in a transaction, committing it if fn returns nil and
+// rolling it back otherwise.
+func (s *Store) Tx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// ImportedSuffix is added to the name of a file once Import has moved its
+// records into the store. The file is kept in case the import needs
+// checking.
+const ImportedSuffix = ".imported"
+
+// Import moves the records of a file qcmd kept before the store into it:
+// it renames path and calls load on the renamed file in a transaction. The
+// rename comes first so that of several qcmds starting at once only one
+// imports the file; if load fails the file is renamed back. A missing file
+// is skipped.
+func (s *Store) Import(path string, load func(tx *sql.Tx, path string) error) error {
+	imported := path + ImportedSuffix
+	if err := os.Rename(path, imported); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("importing %s: %w", filepath.Base(path), err)
+	}
+	if err := s.Tx(func(tx *sql.Tx) error { return load(tx, imported) }); err != nil {
+		os.Rename(imported, path)
+		return fmt.Errorf("importing %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// migrate applies the migrations newer than the database's version, each
+// in its own transaction with the version it brings the schema to. The
+// version is read again in each transaction, as another qcmd may have
+// migrated the database in the meantime.
+func (s *Store) migrate() error {
+	version, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if latest := migrations[len(migrations)-1].version; version > latest {
+		return fmt.Errorf("%w (schema version %d, this qcmd knows %d)", ErrNewer, version, latest)
+	}
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		err := s.Tx(func(tx *sql.Tx) error {
+			if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version >= m.version {
+				return err
+			}
+			if _, err := tx.Exec(m.sql); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", m.version))
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migrating to schema version %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+// timeLayout is RFC 3339 with a fixed number of fractional digits, so
+// stored times sort as text.
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// FormatTime returns t as stored in the database, in UTC.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// ParseTime parses a time stored by FormatTime, in the local time zone.
+func ParseTime(s string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing stored time %q: %w", s, err)
+	}
+	return t.Local(), nil
+}
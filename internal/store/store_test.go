@@ -0,0 +1,134 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", FileName)
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	latest := migrations[len(migrations)-1].version
+	if v, err := s.Version(); err != nil || v != latest {
+		t.Errorf("Version() = %d, %v; want %d", v, err, latest)
+	}
+	if _, err := s.DB().Exec("INSERT INTO audit (time, event) VALUES ('', 'test')"); err != nil {
+		t.Fatalf("insert error = %v", err)
+	}
+	s.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("database permissions = %o, want 600", perm)
+	}
+
+	// Reopening keeps the data and migrates nothing.
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("reopening error = %v", err)
+	}
+	var n int
+	if err := s.DB().QueryRow("SELECT COUNT(*) FROM audit").Scan(&n); err != nil || n != 1 {
+		t.Errorf("rows after reopening = %d, %v; want 1", n, err)
+	}
+
+	// A database from a newer qcmd is not touched.
+	if _, err := s.DB().Exec("PRAGMA user_version = 999"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if _, err := Open(path); !errors.Is(err, ErrNewer) {
+		t.Errorf("Open() of a newer database error = %v, want ErrNewer", err)
+	}
+}
+
+func TestOpenConcurrently(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s, err := Open(path)
+			if err != nil {
+				errs <- err
+				return
+			}
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Open() error = %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(filepath.Join(dir, FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	path := filepath.Join(dir, "legacy.jsonl")
+	if err := s.Import(path, func(*sql.Tx, string) error { return errors.New("not called") }); err != nil {
+		t.Errorf("Import() of a missing file error = %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("bad data")
+	if err := s.Import(path, func(*sql.Tx, string) error { return failed }); !errors.Is(err, failed) {
+		t.Errorf("Import() error = %v, want %v", err, failed)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("file not restored after a failed import: %v", err)
+	}
+
+	var read string
+	err = s.Import(path, func(_ *sql.Tx, imported string) error {
+		data, err := os.ReadFile(imported)
+		read = string(data)
+		return err
+	})
+	if err != nil || read != "data" {
+		t.Errorf("Import() = %v, read %q; want nil, %q", err, read, "data")
+	}
+	if _, err := os.Stat(path + ImportedSuffix); err != nil {
+		t.Errorf("imported file not kept: %v", err)
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	times := []time.Time{
+		time.Date(2026, 3, 1, 12, 0, 5, 0, time.UTC),
+		time.Date(2026, 3, 1, 12, 0, 5, 500000000, time.UTC),
+		time.Date(2026, 3, 1, 14, 0, 6, 0, time.FixedZone("CEST", 2*60*60)),
+	}
+	for i := 1; i < len(times); i++ {
+		if a, b := FormatTime(times[i-1]), FormatTime(times[i]); a >= b {
+			t.Errorf("FormatTime() = %q, %q; want them in order", a, b)
+		}
+	}
+	for _, want := range times {
+		got, err := ParseTime(FormatTime(want))
+		if err != nil || !got.Equal(want) {
+			t.Errorf("ParseTime(FormatTime(%v)) = %v, %v", want, got, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package systemd splits generated systemd unit files and checks that they
+// are well formed before they are installed.
+package systemd
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fileHeader starts each unit file in generated output.
+const fileHeader = "# File: "
+
+// Unit is one unit file.
+type Unit struct {
+	// Name is the file name, such as "backup.service".
+	Name string
+	// Content is the file's contents, without the header line.
+	Content string
+}
+
+// Type returns the unit type, such as "service".
+func (u Unit) Type() string {
+	return u.Name[strings.LastIndexByte(u.Name, '.')+1:]
+}
+
+var (
+	unitNameRegex = regexp.MustCompile(`^[A-Za-z0-9:_.\\-]+(@[A-Za-z0-9:_.\\-]*)?\.(service|timer)$`)
+	keyRegex      = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_-]*$`)
+)
+
+// sections lists the sections each unit type may have. Sections starting
+// with "X-" are for other tools and always allowed.
+var sections = map[string][]string{
+	"service": {"Unit", "Service", "Install"},
+	"timer":   {"Unit", "Timer", "Install"},
+}
+
+// timerTriggers are the keys that make a timer fire.
+var timerTriggers = []string{"OnActiveSec", "OnBootSec", "OnStartupSec", "OnUnitActiveSec", "OnUnitInactiveSec", "OnCalendar"}
+
+// Split splits generated output into unit files, each introduced by a
+// "# File: NAME" line. Code fence lines around the files are dropped.
+func Split(text string) ([]Unit, error) {
+	var units []Unit
+	var content []string
+	flush := func() {
+		if len(units) > 0 {
+			units[len(units)-1].Content = strings.TrimSpace(strings.Join(content, "\n")) + "\n"
+		}
+		content = nil
+	}
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+		case strings.HasPrefix(trimmed, fileHeader):
+			flush()
+			name := strings.TrimSpace(strings.TrimPrefix(trimmed, fileHeader))
+			if !unitNameRegex.MatchString(name) {
+				return nil, fmt.Errorf("invalid unit file name %q (must end in .service or .timer)", name)
+			}
+			units = append(units, Unit{Name: name})
+		case len(units) == 0:
+			if trimmed != "" {
+				return nil, fmt.Errorf("unit output must start with a %q line", fileHeader+"NAME")
+			}
+		default:
+			content = append(content, line)
+		}
+	}
+	flush()
+	if len(units) == 0 {
+		return nil, fmt.Errorf("no unit files in the output")
+	}
+	seen := make(map[string]bool)
+	for _, u := range units {
+		if seen[u.Name] {
+			return nil, fmt.Errorf("unit file %s appears twice", u.Name)
+		}
+		seen[u.Name] = true
+	}
+	return units, nil
+}
+
+// Check reports the first problem that would stop systemd from loading u
+// as intended: lines that are neither sections nor key=value settings,
+// unknown sections, or a missing ExecStart= or timer trigger.
+func Check(u Unit) error {
+	allowed := sections[u.Type()]
+	var section string
+	keys := make(map[string]bool)
+	for _, l := range logicalLines(u.Content) {
+		text := strings.TrimSpace(l.text)
+		switch {
+		case text == "" || text[0] == '#' || text[0] == ';':
+			continue
+		case strings.HasPrefix(text, "["):
+			if !strings.HasSuffix(text, "]") {
+				return fmt.Errorf("%s:%d: malformed section header %q", u.Name, l.line, text)
+			}
+			section = text[1 : len(text)-1]
+			if !strings.HasPrefix(section, "X-") && !contains(allowed, section) {
+				return fmt.Errorf("%s:%d: unknown section [%s] (a %s has %s)", u.Name, l.line, section, u.Type(), strings.Join(allowed, ", "))
+			}
+			continue
+		}
+		key, _, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !keyRegex.MatchString(key) {
+			return fmt.Errorf("%s:%d: expected key=value, got %q", u.Name, l.line, text)
+		}
+		if section == "" {
+			return fmt.Errorf("%s:%d: %s= is outside of any section", u.Name, l.line, key)
+		}
+		keys[section+"."+key] = true
+	}
+
+	switch u.Type() {
+	case "service":
+		if !keys["Service.ExecStart"] {
+			return fmt.Errorf("%s: [Service] has no ExecStart=", u.Name)
+		}
+	case "timer":
+		for _, trigger := range timerTriggers {
+			if keys["Timer."+trigger] {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s: [Timer] has none of %s=", u.Name, strings.Join(timerTriggers, "=, "))
+	}
+	return nil
+}
+
+// Commands returns the command lines a service runs, from its Exec*=
+// settings, with systemd's prefix characters such as "-" removed, so they
+// can be safety-checked.
+func Commands(u Unit) []string {
+	var cmds []string
+	for _, l := range logicalLines(u.Content) {
+		key, value, ok := strings.Cut(strings.TrimSpace(l.text), "=")
+		if !ok || !strings.HasPrefix(strings.TrimSpace(key), "Exec") {
+			continue
+		}
+		if cmd := strings.TrimLeft(strings.TrimSpace(value), "@-:+!"); cmd != "" {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+// logicalLine is a setting with its continuation lines joined.
+type logicalLine struct {
+	text string
+	line int // line number of the first physical line
+}
+
+// logicalLines joins lines ending in a backslash with the next, as systemd
+// does.
+func logicalLines(content string) []logicalLine {
+	var lines []logicalLine
+	var cur *logicalLine
+	for i, line := range strings.Split(content, "\n") {
+		if cur == nil {
+			lines = append(lines, logicalLine{line: i + 1})
+			cur = &lines[len(lines)-1]
+		}
+		if strings.HasSuffix(line, `\`) {
+			cur.text += strings.TrimSuffix(line, `\`) + " "
+			continue
+		}
+		cur.text += line
+		cur = nil
+	}
+	return lines
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
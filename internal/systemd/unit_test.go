@@ -0,0 +1,109 @@
+package systemd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const backupUnits = "```ini\n" + `# File: backup.service
+[Unit]
+Description=Back up the database
+
+[Service]
+Type=oneshot
+ExecStart=/usr/local/bin/backup-db.sh \
+    --compress
+ExecStartPost=-/usr/bin/logger backup done
+` + "```\n```ini\n" + `# File: backup.timer
+[Unit]
+Description=Nightly database backup
+
+[Timer]
+OnCalendar=*-*-* 02:30:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+` + "```\n"
+
+func TestSplit(t *testing.T) {
+	units, err := Split(backupUnits)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	if len(units) != 2 || units[0].Name != "backup.service" || units[1].Name != "backup.timer" {
+		t.Fatalf("Split() = %+v, want backup.service and backup.timer", units)
+	}
+	if !strings.HasPrefix(units[0].Content, "[Unit]\n") || strings.Contains(units[0].Content, "```") {
+		t.Errorf("service content = %q", units[0].Content)
+	}
+	if !strings.HasSuffix(units[1].Content, "WantedBy=timers.target\n") {
+		t.Errorf("timer content = %q", units[1].Content)
+	}
+
+	for _, bad := range []string{
+		"",
+		"[Service]\nExecStart=/bin/true\n",
+		"# File: backup.conf\n[Unit]\n",
+		"# File: a.service\n[Service]\nExecStart=/bin/true\n# File: a.service\n",
+	} {
+		if _, err := Split(bad); err == nil {
+			t.Errorf("Split(%q) expected error", bad)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	units, err := Split(backupUnits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range units {
+		if err := Check(u); err != nil {
+			t.Errorf("Check(%s) error = %v", u.Name, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"a.service", "[Service]\nExecStart=/bin/true\n[Install]\nWantedBy=multi-user.target\n", ""},
+		{"a.service", "[Service]\n; comment\nX-Custom=1\nExecStart=/bin/true\n[X-Vendor]\nFoo=bar\n", ""},
+		{"a.service", "[Service]\nType=simple\n", "no ExecStart="},
+		{"a.service", "ExecStart=/bin/true\n", "outside of any section"},
+		{"a.service", "[Service]\nExecStart /bin/true\n", "expected key=value"},
+		{"a.service", "[Service\nExecStart=/bin/true\n", "malformed section header"},
+		{"a.service", "[Timer]\nOnCalendar=daily\n", "unknown section [Timer]"},
+		{"a.timer", "[Timer]\nPersistent=true\n", "has none of"},
+		{"a.timer", "[Timer]\nOnBootSec=5min\n", ""},
+	}
+	for _, tt := range tests {
+		err := Check(Unit{Name: tt.name, Content: tt.content})
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Check(%q) error = %v", tt.content, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Check(%q) error = %v, want one mentioning %q", tt.content, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	units, err := Split(backupUnits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/usr/local/bin/backup-db.sh      --compress", "/usr/bin/logger backup done"}
+	if got := Commands(units[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("Commands() = %q, want %q", got, want)
+	}
+	if got := Commands(units[1]); got != nil {
+		t.Errorf("Commands(timer) = %q, want none", got)
+	}
+}
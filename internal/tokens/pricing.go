@@ -0,0 +1,79 @@
+package tokens
+
+import (
+	"sort"
+	"strings"
+)
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	Input  float64 `toml:"input"`
+	Output float64 `toml:"output"`
+}
+
+// Cost returns the USD cost of a request with the given token counts.
+func (p Price) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*p.Input + float64(outputTokens)*p.Output) / 1e6
+}
+
+// DefaultPrices maps model name prefixes to list prices. Dated snapshots
+// (e.g., "claude-haiku-4-5-20251001") match their family prefix.
+var DefaultPrices = map[string]Price{
+	"claude-haiku-4-5":  {Input: 1.00, Output: 5.00},
+	"claude-sonnet-4-5": {Input: 3.00, Output: 15.00},
+	"claude-sonnet-4":   {Input: 3.00, Output: 15.00},
+	"claude-opus-4-1":   {Input: 15.00, Output: 75.00},
+	"claude-3-5-haiku":  {Input: 0.80, Output: 4.00},
+	"gpt-4o-mini":       {Input: 0.15, Output: 0.60},
+	"gpt-4o":            {Input: 2.50, Output: 10.00},
+	"gpt-4.1-mini":      {Input: 0.40, Output: 1.60},
+	"gpt-4.1":           {Input: 2.00, Output: 8.00},
+}
+
+// MergePrices returns DefaultPrices with overrides added, replacing any
+// built-in price for the same key.
+func MergePrices(overrides map[string]Price) map[string]Price {
+	table := make(map[string]Price, len(DefaultPrices)+len(overrides))
+	for k, p := range DefaultPrices {
+		table[k] = p
+	}
+	for k, p := range overrides {
+		table[k] = p
+	}
+	return table
+}
+
+// LookupPrice returns the price for model from DefaultPrices.
+// See LookupPriceIn for the matching rules.
+func LookupPrice(model string) (Price, bool) {
+	return LookupPriceIn(DefaultPrices, model)
+}
+
+// LookupPriceIn returns the price for model from table. An exact match wins;
+// otherwise the longest key that prefixes the model name is used. OpenRouter
+// style provider prefixes ("anthropic/...") are ignored when matching.
+func LookupPriceIn(table map[string]Price, model string) (Price, bool) {
+	if p, ok := table[model]; ok {
+		return p, true
+	}
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+		if p, ok := table[model]; ok {
+			return p, true
+		}
+	}
+
+	keys := make([]string, 0, len(table))
+	for k := range table {
+		keys = append(keys, k)
+	}
+	// Longest prefix first so "gpt-4o-mini" beats "gpt-4o".
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	for _, k := range keys {
+		if strings.HasPrefix(model, k) {
+			return table[k], true
+		}
+	}
+
+	return Price{}, false
+}
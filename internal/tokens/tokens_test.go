@@ -0,0 +1,91 @@
+package tokens
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{"empty", "", 0},
+		{"single word", "ls", 1},
+		{"command with flag", "ls -la", 3},
+		{"sentence", "find all files modified today", 5},
+		{"digits grouped by three", "1234567", 3},
+		{"operator pair", "a && b", 3},
+		{"long identifier", "supercalifragilistic", 3},
+		{"non-ascii", "héllo", 5},
+		{"indentation", "a\n        b", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Estimate(tt.text); got != tt.want {
+				t.Errorf("Estimate(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateScalesWithLength(t *testing.T) {
+	short := Estimate(strings.Repeat("list the files ", 10))
+	long := Estimate(strings.Repeat("list the files ", 100))
+	if long < 9*short || long > 11*short {
+		t.Errorf("Estimate should scale roughly linearly: short=%d long=%d", short, long)
+	}
+}
+
+func TestLookupPrice(t *testing.T) {
+	tests := []struct {
+		model  string
+		want   Price
+		wantOK bool
+	}{
+		{"claude-haiku-4-5-20251001", Price{Input: 1, Output: 5}, true},
+		{"anthropic/claude-haiku-4-5-20251001", Price{Input: 1, Output: 5}, true},
+		{"gpt-4o-mini-2024-07-18", Price{Input: 0.15, Output: 0.60}, true},
+		{"gpt-4o", Price{Input: 2.5, Output: 10}, true},
+		{"unknown-model", Price{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			got, ok := LookupPrice(tt.model)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("LookupPrice(%q) = %+v, %v; want %+v, %v", tt.model, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPriceCost(t *testing.T) {
+	p := Price{Input: 1, Output: 5}
+	got := p.Cost(1000, 200)
+	if want := 0.002; math.Abs(got-want) > 1e-12 {
+		t.Errorf("Cost(1000, 200) = %v, want %v", got, want)
+	}
+}
+
+func TestMergePrices(t *testing.T) {
+	table := MergePrices(map[string]Price{
+		"gpt-4o":   {Input: 1, Output: 2},
+		"local-7b": {},
+	})
+	if got := table["gpt-4o"]; got != (Price{Input: 1, Output: 2}) {
+		t.Errorf("override not applied: %+v", got)
+	}
+	if _, ok := LookupPriceIn(table, "local-7b"); !ok {
+		t.Error("added model not found")
+	}
+	if got, _ := LookupPriceIn(table, "gpt-4o-mini"); got != DefaultPrices["gpt-4o-mini"] {
+		t.Errorf("built-in price changed: %+v", got)
+	}
+	if DefaultPrices["gpt-4o"] == (Price{Input: 1, Output: 2}) {
+		t.Error("MergePrices modified DefaultPrices")
+	}
+}
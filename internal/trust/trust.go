@@ -0,0 +1,114 @@
+// Package trust remembers commands the user chose to run despite a safety
+// warning, so the identical command is not warned about again for a while.
+//
+// Commands are stored as SHA-256 hashes, not as text: the cache says which
+// commands were acknowledged without keeping a second copy of them.
+package trust
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// FileName is the name of the trust cache in the state directory.
+const FileName = "trusted.json"
+
+// Cache maps the hashes of acknowledged commands to when the trust ends.
+type Cache struct {
+	Commands map[string]time.Time `json:"commands"`
+}
+
+// Load reads the cache from path. A missing file yields an empty cache.
+func Load(path string) (*Cache, error) {
+	c := &Cache{Commands: make(map[string]time.Time)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading trusted commands: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing trusted commands: %w", err)
+	}
+	if c.Commands == nil {
+		c.Commands = make(map[string]time.Time)
+	}
+	return c, nil
+}
+
+// Save writes the cache to path, creating parent directories as needed.
+// The file is replaced atomically.
+func Save(path string, c *Cache) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("encoding trusted commands: %w", err)
+	}
+	if err := filelock.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("writing trusted commands: %w", err)
+	}
+	return nil
+}
+
+// Update applies fn to the cache at path and, if fn reports a change,
+// saves it, holding a lock so that commands trusted by other qcmds in the
+// meantime are not lost.
+func Update(path string, fn func(*Cache) bool) error {
+	unlock, err := filelock.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	c, err := Load(path)
+	if err != nil {
+		return err
+	}
+	if !fn(c) {
+		return nil
+	}
+	return Save(path, c)
+}
+
+// Hash returns the key command is stored under. Only the exact command
+// matches; a changed space or argument is a different command.
+func Hash(command string) string {
+	sum := sha256.Sum256([]byte(command))
+	return hex.EncodeToString(sum[:])
+}
+
+// Trusted reports whether command is trusted at now, and if so until when.
+func (c *Cache) Trusted(command string, now time.Time) (bool, time.Time) {
+	until, ok := c.Commands[Hash(command)]
+	if !ok || !now.Before(until) {
+		return false, time.Time{}
+	}
+	return true, until
+}
+
+// Add trusts command from now for window, forgetting expired entries.
+func (c *Cache) Add(command string, now time.Time, window time.Duration) {
+	for hash, until := range c.Commands {
+		if !now.Before(until) {
+			delete(c.Commands, hash)
+		}
+	}
+	c.Commands[Hash(command)] = now.Add(window)
+}
+
+// Forget stops trusting command. It reports whether it was in the cache.
+func (c *Cache) Forget(command string) bool {
+	hash := Hash(command)
+	if _, ok := c.Commands[hash]; !ok {
+		return false
+	}
+	delete(c.Commands, hash)
+	return true
+}
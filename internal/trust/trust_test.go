@@ -0,0 +1,105 @@
+package trust
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrusted(t *testing.T) {
+	start := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	c := &Cache{Commands: make(map[string]time.Time)}
+	c.Add("sudo systemctl restart nginx", start, time.Hour)
+
+	tests := []struct {
+		command string
+		at      time.Duration
+		want    bool
+	}{
+		{"sudo systemctl restart nginx", 0, true},
+		{"sudo systemctl restart nginx", 59 * time.Minute, true},
+		{"sudo systemctl restart nginx", time.Hour, false},
+		// Only the exact command is trusted.
+		{"sudo systemctl restart  nginx", 0, false},
+		{"sudo systemctl stop nginx", 0, false},
+	}
+	for _, tt := range tests {
+		if got, _ := c.Trusted(tt.command, start.Add(tt.at)); got != tt.want {
+			t.Errorf("Trusted(%q) after %v = %v, want %v", tt.command, tt.at, got, tt.want)
+		}
+	}
+
+	// Adding another command forgets expired ones.
+	c.Add("sudo reboot", start.Add(2*time.Hour), time.Hour)
+	if len(c.Commands) != 1 {
+		t.Errorf("Add() kept %d commands, want 1", len(c.Commands))
+	}
+	if !c.Forget("sudo reboot") || c.Forget("sudo reboot") {
+		t.Error("Forget() should report the command once")
+	}
+}
+
+func TestLoadSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", FileName)
+	c, err := Load(path)
+	if err != nil || len(c.Commands) != 0 {
+		t.Fatalf("Load() of a missing file = %+v, %v; want an empty cache", c, err)
+	}
+
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+	c.Add("sudo apt upgrade", now, time.Hour)
+	if err := Save(path, c); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("cache permissions = %o, want 600", perm)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if ok, until := got.Trusted("sudo apt upgrade", now); !ok || !until.Equal(now.Add(time.Hour)) {
+		t.Errorf("Trusted() after reload = %v, %v; want true, %v", ok, until, now.Add(time.Hour))
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() of a corrupt file should fail")
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	now := time.Date(2024, 6, 12, 15, 0, 0, 0, time.UTC)
+
+	// Each qcmd updates the file itself; none loses another's command.
+	commands := []string{"sudo apt upgrade", "sudo reboot", "sudo systemctl restart nginx", "sudo ufw enable"}
+	var wg sync.WaitGroup
+	for _, command := range commands {
+		wg.Add(1)
+		go func(command string) {
+			defer wg.Done()
+			err := Update(path, func(c *Cache) bool {
+				c.Add(command, now, time.Hour)
+				return true
+			})
+			if err != nil {
+				t.Errorf("Update() error = %v", err)
+			}
+		}(command)
+	}
+	wg.Wait()
+
+	c, err := Load(path)
+	if err != nil || len(c.Commands) != len(commands) {
+		t.Errorf("Load() after updates = %d commands, %v; want %d", len(c.Commands), err, len(commands))
+	}
+}
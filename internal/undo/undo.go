@@ -0,0 +1,109 @@
+// Package undo suggests commands that reverse simple shell commands.
+//
+// It knows a handful of commands whose inverse follows from their words
+// alone, such as mv and git stash. Anything else is left to the model. An
+// undo is a best-effort suggestion: it cannot know what the command
+// overwrote, so it is shown, never run.
+package undo
+
+import (
+	"strings"
+
+	"github.com/user/qcmd/internal/shellparse"
+)
+
+// Inverse returns a command that reverses cmd, or false if cmd is not one
+// it knows. Only single simple commands are handled, and only when the
+// arguments the inverse repeats need no quoting.
+func Inverse(cmd string) (string, bool) {
+	pipelines := shellparse.Pipelines(cmd)
+	if len(pipelines) != 1 || len(shellparse.Commands(pipelines[0].Text)) != 1 {
+		return "", false
+	}
+	words := shellparse.Words(pipelines[0].Text)
+	if len(words) == 0 {
+		return "", false
+	}
+
+	args := words[1:]
+	if words[0] != "git" && !plain(args) {
+		return "", false
+	}
+	switch words[0] {
+	case "mv":
+		// mv a b, into a name rather than a directory; if b turns out
+		// to be one, the inverse is wrong, hence best effort.
+		if len(args) == 2 && !isFlag(args[0]) && !strings.HasSuffix(args[1], "/") {
+			return "mv " + args[1] + " " + args[0], true
+		}
+	case "mkdir":
+		if len(args) > 0 && !hasFlags(args) {
+			return "rmdir " + strings.Join(args, " "), true
+		}
+	case "chmod":
+		// chmod +x f and the like; numeric modes lose the old one.
+		if len(args) >= 2 && len(args[0]) > 1 && (args[0][0] == '+' || args[0][0] == '-') && !hasFlags(args[1:]) {
+			sign := "-"
+			if args[0][0] == '-' {
+				sign = "+"
+			}
+			return "chmod " + sign + args[0][1:] + " " + strings.Join(args[1:], " "), true
+		}
+	case "git":
+		return inverseGit(args)
+	}
+	return "", false
+}
+
+// inverseGit handles git subcommands with an obvious inverse. Of their
+// arguments, only a commit message may need quoting.
+func inverseGit(args []string) (string, bool) {
+	if len(args) > 0 && args[0] == "commit" {
+		if len(args) == 1 || onlyMessage(args[1:]) {
+			return "git reset --soft HEAD~1", true
+		}
+		return "", false
+	}
+	if !plain(args) {
+		return "", false
+	}
+	switch {
+	case len(args) == 1 && args[0] == "stash", len(args) == 2 && args[0] == "stash" && args[1] == "push":
+		return "git stash pop", true
+	case len(args) >= 2 && args[0] == "add" && !hasFlags(args[1:]):
+		return "git restore --staged " + strings.Join(args[1:], " "), true
+	case len(args) == 2 && args[0] == "switch" && !isFlag(args[1]):
+		return "git switch -", true
+	}
+	return "", false
+}
+
+// onlyMessage reports whether git commit args give only a message, and
+// so make a plain commit that a soft reset takes back.
+func onlyMessage(args []string) bool {
+	return len(args) == 2 && (args[0] == "-m" || args[0] == "--message")
+}
+
+// plain reports whether words can be written back into a command as they
+// are: no whitespace, quotes, globs or other shell syntax.
+func plain(words []string) bool {
+	for _, w := range words {
+		if w == "" || strings.ContainsAny(w, " \t\n$`*?<>&;|(){}[]\\\"'~#") {
+			return false
+		}
+	}
+	return true
+}
+
+func isFlag(arg string) bool {
+	return strings.HasPrefix(arg, "-")
+}
+
+func hasFlags(args []string) bool {
+	for _, arg := range args {
+		if isFlag(arg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package undo
+
+import "testing"
+
+func TestInverse(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		want   string
+		wantOK bool
+	}{
+		{"mv a.txt b.txt", "mv b.txt a.txt", true},
+		{"mv old-name new-name", "mv new-name old-name", true},
+		{"mkdir build dist", "rmdir build dist", true},
+		{"chmod +x deploy.sh", "chmod -x deploy.sh", true},
+		{"chmod -w notes.md", "chmod +w notes.md", true},
+		{"git stash", "git stash pop", true},
+		{"git add main.go go.mod", "git restore --staged main.go go.mod", true},
+		{"git switch feature", "git switch -", true},
+		{`git commit -m "fix the (flaky) test"`, "git reset --soft HEAD~1", true},
+		{"mv 'a b' c", "", false},
+		{"mv a.txt dir/", "", false},
+		{"mv a b c/", "", false},
+		{"mv -f a b", "", false},
+		{"mkdir -p a/b", "", false},
+		{"chmod 755 deploy.sh", "", false},
+		{"git checkout main.go", "", false},
+		{"git commit -am wip", "", false},
+		{"mv a b && mv c d", "", false},
+		{"rm -rf build", "", false},
+		{"mv *.log logs", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			got, ok := Inverse(tt.cmd)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("Inverse(%q) = %q, %v; want %q, %v", tt.cmd, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
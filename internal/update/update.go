@@ -0,0 +1,197 @@
+// Package update looks up the latest release of qcmd and compares it with
+// the running version. qcmd only does so when asked, with `qcmd version
+// --check` or update.check; the answer is cached so scripts asking often do
+// not run into the release feed's rate limits.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/user/qcmd/internal/filelock"
+)
+
+// CacheFileName is the name of the file in the cache directory holding
+// the release last looked up.
+const CacheFileName = "latest-release.json"
+
+// CacheTTL is how long a release looked up is relied on before the feed
+// is asked again.
+const CacheTTL = 24 * time.Hour
+
+// maxBody bounds the feed's answer, which on GitHub includes the release
+// notes.
+const maxBody = 1 << 20
+
+// Release is a published version of qcmd.
+type Release struct {
+	Version string `json:"tag_name"`
+	URL     string `json:"html_url"`
+}
+
+// cacheEntry is a cached release, with the feed it came from and when.
+type cacheEntry struct {
+	Release Release   `json:"release"`
+	Feed    string    `json:"feed"`
+	Checked time.Time `json:"checked"`
+}
+
+// Latest asks the feed at url, which answers like GitHub's latest-release
+// API, for the latest release.
+func Latest(ctx context.Context, client *http.Client, url string) (Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Release{}, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Release{}, fmt.Errorf("looking up the latest release: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Release{}, fmt.Errorf("looking up the latest release: %s", resp.Status)
+	}
+
+	var r Release
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBody)).Decode(&r); err != nil {
+		return Release{}, fmt.Errorf("parsing the latest release: %w", err)
+	}
+	if r.Version == "" {
+		return Release{}, errors.New("parsing the latest release: no version in the answer")
+	}
+	return r, nil
+}
+
+// Cached returns the latest release from the feed at url like Latest, but
+// uses the one cached at path if it came from the same feed within
+// CacheTTL before now, and caches a release it looks up. A cache that
+// cannot be read or written is ignored.
+func Cached(ctx context.Context, client *http.Client, url, path string, now time.Time) (Release, error) {
+	if data, err := os.ReadFile(path); err == nil {
+		var e cacheEntry
+		if json.Unmarshal(data, &e) == nil && e.Feed == url && now.Sub(e.Checked) < CacheTTL && !e.Checked.After(now) {
+			return e.Release, nil
+		}
+	}
+
+	r, err := Latest(ctx, client, url)
+	if err != nil {
+		return Release{}, err
+	}
+	if data, err := json.Marshal(cacheEntry{Release: r, Feed: url, Checked: now}); err == nil {
+		filelock.WriteFile(path, data, 0600)
+	}
+	return r, nil
+}
+
+// describeSuffix is what git describe adds to a release's tag for the
+// commits after it: their number, the commit and, with --dirty, whether
+// the tree was modified.
+var describeSuffix = regexp.MustCompile(`(-[0-9]+-g[0-9a-f]+)?(-dirty)?$`)
+
+// version is a parsed semantic version.
+type version struct {
+	core       [3]int
+	prerelease []string
+}
+
+// parse parses a semantic version, with or without a leading "v". A
+// version git describe gives for a build after a release, such as
+// v1.2.3-4-gabc1234-dirty, parses as that release.
+func parse(s string) (version, bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s = describeSuffix.ReplaceAllString(s, "")
+	core, pre, hasPre := strings.Cut(s, "-")
+
+	var v version
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return version{}, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return version{}, false
+		}
+		v.core[i] = n
+	}
+	if hasPre {
+		v.prerelease = strings.Split(pre, ".")
+	}
+	return v, true
+}
+
+// compare returns -1, 0 or 1 as v is before, the same as or after w, by
+// the precedence rules of semantic versioning.
+func (v version) compare(w version) int {
+	for i := range v.core {
+		if c := compareInts(v.core[i], w.core[i]); c != 0 {
+			return c
+		}
+	}
+	// A prerelease comes before its release.
+	switch {
+	case len(v.prerelease) == 0 && len(w.prerelease) == 0:
+		return 0
+	case len(v.prerelease) == 0:
+		return 1
+	case len(w.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.prerelease) && i < len(w.prerelease); i++ {
+		if c := compareIdentifiers(v.prerelease[i], w.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(v.prerelease), len(w.prerelease))
+}
+
+// compareIdentifiers compares prerelease identifiers: numeric ones by
+// value and before alphanumeric ones, which compare as text.
+func compareIdentifiers(a, b string) int {
+	m, errA := strconv.Atoi(a)
+	n, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(m, n)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(m, n int) int {
+	switch {
+	case m < n:
+		return -1
+	case m > n:
+		return 1
+	}
+	return 0
+}
+
+// Newer reports whether latest is a later version than current. ok is
+// false if either is not a semantic version, as with development builds,
+// so they cannot be compared.
+func Newer(current, latest string) (newer, ok bool) {
+	c, okC := parse(current)
+	l, okL := parse(latest)
+	if !okC || !okL {
+		return false, false
+	}
+	return l.compare(c) > 0, true
+}
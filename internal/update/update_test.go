@@ -0,0 +1,111 @@
+package update
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		current, latest string
+		newer, ok       bool
+	}{
+		{"v1.2.3", "v1.3.0", true, true},
+		{"v1.2.3", "v1.2.3", false, true},
+		{"1.2.3", "v1.2.3", false, true},
+		{"v1.10.0", "v1.9.0", false, true},
+		{"v1.2.3", "v2.0.0", true, true},
+		{"v1.2.3-rc.1", "v1.2.3", true, true},
+		{"v1.2.3", "v1.2.4-rc.1", true, true},
+		{"v1.2.3-rc.2", "v1.2.3-rc.10", true, true},
+		{"v1.2.3-beta", "v1.2.3-rc.1", true, true},
+		{"v1.2.3-alpha", "v1.2.3-alpha.1", true, true},
+		{"v1.2.3+build.5", "v1.2.3", false, true},
+		{"v1.2.3-4-gabc1234", "v1.2.3", false, true},
+		{"v1.2.3-4-gabc1234-dirty", "v1.2.4", true, true},
+		{"v1.2.3-dirty", "v1.2.3", false, true},
+		{"dev", "v1.2.3", false, false},
+		{"abc1234", "v1.2.3", false, false},
+		{"v1.2.3", "nightly", false, false},
+		{"v1.2", "v1.2.3", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.current+" to "+tt.latest, func(t *testing.T) {
+			newer, ok := Newer(tt.current, tt.latest)
+			if newer != tt.newer || ok != tt.ok {
+				t.Errorf("Newer(%q, %q) = %v, %v; want %v, %v", tt.current, tt.latest, newer, ok, tt.newer, tt.ok)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    Release
+		wantErr bool
+	}{
+		{"release", http.StatusOK, `{"tag_name": "v1.3.0", "html_url": "https://example.com/v1.3.0", "body": "notes"}`, Release{Version: "v1.3.0", URL: "https://example.com/v1.3.0"}, false},
+		{"not found", http.StatusNotFound, `{"message": "Not Found"}`, Release{}, true},
+		{"no version", http.StatusOK, `{"html_url": "https://example.com"}`, Release{}, true},
+		{"not json", http.StatusOK, `<html>`, Release{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			got, err := Latest(context.Background(), srv.Client(), srv.URL)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Latest() = %+v, %v; want %+v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCached(t *testing.T) {
+	tag := "v1.3.0"
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, `{"tag_name": %q}`, tag)
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "cache", CacheFileName)
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name         string
+		feed         string
+		at           time.Time
+		want         string
+		wantRequests int
+	}{
+		{"first lookup", srv.URL, now, "v1.3.0", 1},
+		{"cached", srv.URL, now.Add(time.Hour), "v1.3.0", 1},
+		{"expired", srv.URL, now.Add(CacheTTL + time.Hour), "v1.4.0", 2},
+		{"other feed", srv.URL + "/mirror", now.Add(CacheTTL + 2*time.Hour), "v1.4.0", 3},
+		{"clock moved back", srv.URL + "/mirror", now, "v1.4.0", 4},
+	}
+	for _, step := range steps {
+		if step.name == "expired" {
+			tag = "v1.4.0"
+		}
+		r, err := Cached(context.Background(), srv.Client(), step.feed, path, step.at)
+		if err != nil || r.Version != step.want || requests != step.wantRequests {
+			t.Errorf("%s: Cached() = %+v, %v after %d requests; want %s after %d", step.name, r, err, requests, step.want, step.wantRequests)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+// Package xdg resolves the directories qcmd keeps its files in, following
+// the XDG Base Directory Specification: each is the qcmd directory in the
+// base directory an XDG_*_HOME variable names, or in that variable's
+// default when it is unset.
+//
+// On macOS and Windows, where those defaults are not the custom, the base
+// directories fall back to the platform's own (~/Library, %APPDATA% and
+// %LOCALAPPDATA%). macOS keeps using the Unix locations where a qcmd
+// directory already exists there, so upgrading does not lose any files, and
+// the config stays in ~/.config, where the shell integration is installed.
+package xdg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Name is the name of qcmd's directory in each base directory.
+const Name = "qcmd"
+
+// kind is a base directory.
+type kind int
+
+const (
+	config kind = iota
+	data
+	state
+	cache
+)
+
+// envVars are the variables naming each base directory.
+var envVars = map[kind]string{
+	config: "XDG_CONFIG_HOME",
+	data:   "XDG_DATA_HOME",
+	state:  "XDG_STATE_HOME",
+	cache:  "XDG_CACHE_HOME",
+}
+
+// unixDefaults are the base directories, relative to the home directory,
+// used when their variable is unset.
+var unixDefaults = map[kind]string{
+	config: ".config",
+	data:   filepath.Join(".local", "share"),
+	state:  filepath.Join(".local", "state"),
+	cache:  ".cache",
+}
+
+// ConfigDir returns the directory for the config file and files the user
+// edits alongside it: $XDG_CONFIG_HOME/qcmd, by default ~/.config/qcmd.
+func ConfigDir() (string, error) {
+	return resolve(config, runtime.GOOS)
+}
+
+// DataDir returns the directory for records worth keeping, such as the
+// database and snippets: $XDG_DATA_HOME/qcmd, by default
+// ~/.local/share/qcmd.
+func DataDir() (string, error) {
+	return resolve(data, runtime.GOOS)
+}
+
+// StateDir returns the directory for state that can be lost without
+// harm, such as backend health and crash reports: $XDG_STATE_HOME/qcmd, by
+// default ~/.local/state/qcmd.
+func StateDir() (string, error) {
+	return resolve(state, runtime.GOOS)
+}
+
+// CacheDir returns the directory for files that are fetched again when
+// missing: $XDG_CACHE_HOME/qcmd, by default ~/.cache/qcmd.
+func CacheDir() (string, error) {
+	return resolve(cache, runtime.GOOS)
+}
+
+// RuntimeDir returns the directory for short-lived files shared with the
+// shell, such as the last failed command: $XDG_RUNTIME_DIR/qcmd if set,
+// since it is private to the user and cleared on logout, otherwise the
+// state directory.
+func RuntimeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, Name), nil
+	}
+	return StateDir()
+}
+
+// resolve returns qcmd's directory in the base directory k on goos.
+// Relative paths in the variables are ignored, as the specification asks.
+func resolve(k kind, goos string) (string, error) {
+	if dir := os.Getenv(envVars[k]); filepath.IsAbs(dir) {
+		return filepath.Join(dir, Name), nil
+	}
+	switch goos {
+	case "windows":
+		return windowsDir(k)
+	case "darwin":
+		return darwinDir(k)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	return filepath.Join(home, unixDefaults[k], Name), nil
+}
+
+// windowsDir returns qcmd's directory in the base directory k on Windows:
+// the config roams with the user's profile, everything else stays on the
+// machine.
+func windowsDir(k kind) (string, error) {
+	env := "LOCALAPPDATA"
+	if k == config {
+		env = "APPDATA"
+	}
+	base := os.Getenv(env)
+	if base == "" {
+		return "", fmt.Errorf("%%%s%% is not set", env)
+	}
+	dir := filepath.Join(base, Name)
+	switch k {
+	case state:
+		dir = filepath.Join(dir, "State")
+	case cache:
+		dir = filepath.Join(dir, "Cache")
+	}
+	return dir, nil
+}
+
+// darwinDir returns qcmd's directory in the base directory k on macOS.
+func darwinDir(k kind) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	unix := filepath.Join(home, unixDefaults[k], Name)
+	if k == config {
+		return unix, nil
+	}
+	if _, err := os.Stat(unix); err == nil {
+		return unix, nil
+	}
+	switch k {
+	case state:
+		return filepath.Join(home, "Library", "Application Support", Name, "State"), nil
+	case cache:
+		return filepath.Join(home, "Library", "Caches", Name), nil
+	}
+	return filepath.Join(home, "Library", "Application Support", Name), nil
+}
@@ -0,0 +1,109 @@
+package xdg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	home := t.TempDir()
+	appData := filepath.Join(home, "AppData", "Roaming")
+	localAppData := filepath.Join(home, "AppData", "Local")
+	library := filepath.Join(home, "Library")
+
+	tests := []struct {
+		name string
+		goos string
+		kind kind
+		env  map[string]string
+		want string
+	}{
+		{"config from variable", "linux", config, map[string]string{"XDG_CONFIG_HOME": "/xdg/config"}, "/xdg/config/qcmd"},
+		{"variable wins on macOS", "darwin", data, map[string]string{"XDG_DATA_HOME": "/xdg/data"}, "/xdg/data/qcmd"},
+		{"variable wins on Windows", "windows", cache, map[string]string{"XDG_CACHE_HOME": "/xdg/cache"}, "/xdg/cache/qcmd"},
+		{"relative variable ignored", "linux", state, map[string]string{"XDG_STATE_HOME": "state"}, filepath.Join(home, ".local", "state", "qcmd")},
+		{"linux config", "linux", config, nil, filepath.Join(home, ".config", "qcmd")},
+		{"linux data", "linux", data, nil, filepath.Join(home, ".local", "share", "qcmd")},
+		{"linux state", "linux", state, nil, filepath.Join(home, ".local", "state", "qcmd")},
+		{"linux cache", "linux", cache, nil, filepath.Join(home, ".cache", "qcmd")},
+		{"freebsd data", "freebsd", data, nil, filepath.Join(home, ".local", "share", "qcmd")},
+		{"macOS config", "darwin", config, nil, filepath.Join(home, ".config", "qcmd")},
+		{"macOS data", "darwin", data, nil, filepath.Join(library, "Application Support", "qcmd")},
+		{"macOS state", "darwin", state, nil, filepath.Join(library, "Application Support", "qcmd", "State")},
+		{"macOS cache", "darwin", cache, nil, filepath.Join(library, "Caches", "qcmd")},
+		{"windows config", "windows", config, nil, filepath.Join(appData, "qcmd")},
+		{"windows data", "windows", data, nil, filepath.Join(localAppData, "qcmd")},
+		{"windows state", "windows", state, nil, filepath.Join(localAppData, "qcmd", "State")},
+		{"windows cache", "windows", cache, nil, filepath.Join(localAppData, "qcmd", "Cache")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", home)
+			t.Setenv("APPDATA", appData)
+			t.Setenv("LOCALAPPDATA", localAppData)
+			for _, v := range envVars {
+				t.Setenv(v, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			got, err := resolve(tt.kind, tt.goos)
+			if err != nil {
+				t.Fatalf("resolve() error = %v", err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveWindowsWithoutAppData(t *testing.T) {
+	for _, v := range envVars {
+		t.Setenv(v, "")
+	}
+	t.Setenv("LOCALAPPDATA", "")
+	if dir, err := resolve(data, "windows"); err == nil {
+		t.Errorf("resolve() = %q, want an error without %%LOCALAPPDATA%%", dir)
+	}
+}
+
+func TestResolveMacOSKeepsUnixDirectories(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for _, v := range envVars {
+		t.Setenv(v, "")
+	}
+	existing := filepath.Join(home, ".local", "share", "qcmd")
+	if err := os.MkdirAll(existing, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if dir, err := resolve(data, "darwin"); err != nil || dir != existing {
+		t.Errorf("resolve(data) = %q, %v; want the existing %q", dir, err, existing)
+	}
+	want := filepath.Join(home, "Library", "Caches", "qcmd")
+	if dir, err := resolve(cache, "darwin"); err != nil || dir != want {
+		t.Errorf("resolve(cache) = %q, %v; want %q", dir, err, want)
+	}
+}
+
+func TestRuntimeDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", tmpDir)
+	if dir, err := RuntimeDir(); err != nil || dir != filepath.Join(tmpDir, "qcmd") {
+		t.Errorf("RuntimeDir() = %q, %v; want %q", dir, err, filepath.Join(tmpDir, "qcmd"))
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	t.Setenv("XDG_STATE_HOME", tmpDir)
+	want, err := StateDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, err := RuntimeDir(); err != nil || dir != want {
+		t.Errorf("RuntimeDir() without XDG_RUNTIME_DIR = %q, %v; want the state directory %q", dir, err, want)
+	}
+}